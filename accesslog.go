@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// accessRecord is one line of the access log. Its fields are a fixed
+// schema for log ingestion: add to it, but do not rename or drop any.
+type accessRecord struct {
+	Timestamp  string  `json:"timestamp"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Route      string  `json:"route"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMs float64 `json:"duration_ms"`
+	RequestID  string  `json:"request_id"`
+	ClientIP   string  `json:"client_ip"`
+}
+
+// accessLog writes an accessRecord per request as JSON Lines, apart from
+// the application log, unsampled, and whatever the log level.
+type accessLog struct {
+	mu sync.Mutex // keeps lines whole on writers that do not
+	w  io.Writer
+}
+
+// openAccessLog opens the access log at target: "-" for stdout, or else a
+// file, created with its directory if need be and rotated once it would
+// grow past maxSize bytes, keeping backups rotated files.
+func openAccessLog(target string, maxSize int64, backups int) (*accessLog, error) {
+	if target == "-" {
+		return &accessLog{w: os.Stdout}, nil
+	}
+	f, err := openRotatingFile(target, maxSize, backups)
+	if err != nil {
+		return nil, err
+	}
+	return &accessLog{w: f}, nil
+}
+
+// Close closes the log's file, if it has one.
+func (l *accessLog) Close() error {
+	if c, ok := l.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// middleware writes the line of each request next serves, with the client
+// IP resolved through proxies, including one that panics.
+func (l *accessLog) middleware(next http.Handler, proxies trustedProxies) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			line, err := json.Marshal(accessRecord{
+				Timestamp:  start.UTC().Format(time.RFC3339Nano),
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				Route:      requestRoute(r.Context()),
+				Status:     rec.Status(),
+				Bytes:      rec.bytes,
+				DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+				RequestID:  requestID(r.Context()),
+				ClientIP:   clientIP(r, proxies),
+			})
+			if err != nil {
+				return
+			}
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.w.Write(append(line, '\n'))
+		}()
+		rec.serve(next, r)
+	})
+}
+
+// rotatingFile appends to a file, moving it aside to path.1 before a
+// write would take it past maxSize, and path.1 to path.2 and so on, up to
+// backups old files.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	f       *os.File
+	size    int64
+}
+
+func openRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	r := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens the file for appending, picking up its current size.
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+// Write writes p in a single write, never split across files.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the old files along, dropping the oldest, and starts a new
+// file.
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	if r.backups == 0 {
+		os.Remove(r.path)
+	} else {
+		for i := r.backups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return err
+		}
+	}
+	return r.open()
+}
+
+// Close closes the file.
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// accessLines returns the lines of the access log at path, one per request.
+func accessLines(t *testing.T, path string) [][]byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines [][]byte
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		lines = append(lines, slices.Clone(sc.Bytes()))
+	}
+	return lines
+}
+
+func TestAccessLogOneLinePerRequest(t *testing.T) {
+	s := startServer(t, "-access-log", "logs/access.jsonl")
+	path := filepath.Join("logs", "access.jsonl")
+	getWithHeaders(t, s.url("/api/questions"), map[string]string{requestIDHeader: "req-1"})
+	get(t, s.url("/no/such/page"))
+	waitFor(t, "both requests logged", func() bool { return len(accessLines(t, path)) >= 2 })
+
+	lines := accessLines(t, path)
+	if len(lines) != 2 {
+		t.Fatalf("%d access log lines for 2 requests:\n%s", len(lines), bytes.Join(lines, []byte("\n")))
+	}
+	fields := []string{"bytes", "client_ip", "duration_ms", "method", "path", "request_id", "route", "status", "timestamp"}
+	for _, line := range lines {
+		var rec map[string]any
+		if err := json.Unmarshal(line, &rec); err != nil {
+			t.Fatalf("%v: %s", err, line)
+		}
+		var keys []string
+		for k := range rec {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		if !slices.Equal(keys, fields) {
+			t.Errorf("fields %v, want %v", keys, fields)
+		}
+	}
+	var first, second accessRecord
+	json.Unmarshal(lines[0], &first)
+	json.Unmarshal(lines[1], &second)
+	if first.Method != http.MethodGet || first.Path != "/api/questions" || first.Status != http.StatusOK ||
+		first.RequestID != "req-1" || first.ClientIP != "127.0.0.1" || first.Bytes == 0 {
+		t.Errorf("first record %+v", first)
+	}
+	if second.Path != "/no/such/page" || second.Status != http.StatusNotFound || second.RequestID == "" {
+		t.Errorf("second record %+v", second)
+	}
+}
+
+func TestRotatingFileRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "access.jsonl")
+	f, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n", "six\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]string{path: "six\n", path + ".1": "four\nfive\n", path + ".2": "three\n"} {
+		if data, _ := os.ReadFile(name); string(data) != want {
+			t.Errorf("%s holds %q, want %q", filepath.Base(name), data, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("more backups than asked for were kept")
+	}
+}
+
+func TestAccessLogValidated(t *testing.T) {
+	for _, args := range [][]string{{"-access-log-max-size", "0"}, {"-access-log-backups", "-1"}} {
+		if _, err := loadConfig(args, noEnv); err == nil || !strings.Contains(err.Error(), strings.TrimPrefix(args[0], "-")) {
+			t.Errorf("%v: %v", args, err)
+		}
+	}
+	file := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(file, nil, 0o644)
+	if _, err := loadConfig([]string{"-access-log", filepath.Join(file, "access.jsonl")}, noEnv); err == nil || !strings.Contains(err.Error(), "access-log") {
+		t.Errorf("access log under a file: %v", err)
+	}
+}
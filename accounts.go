@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+const (
+	// profileNamespace holds one profile per signed-in player, keyed by
+	// profile ID.
+	profileNamespace = "profiles"
+	// profileLoginNamespace maps each provider account to its profile ID,
+	// a JSON string, keyed by the provider name and a hash of the
+	// provider's subject.
+	profileLoginNamespace = "profile-logins"
+	// profileCookie carries a signed-in player's profile ID, and
+	// oauthStateCookie the state of a login in progress.
+	profileCookie    = "ll_profile"
+	oauthStateCookie = "ll_oauth_state"
+	// profileLoginTTL is how long a login lasts, and oauthStateTTL how long
+	// a player has to finish one at the provider.
+	profileLoginTTL = 30 * 24 * time.Hour
+	oauthStateTTL   = 10 * time.Minute
+	// maxOAuthUserBody bounds a provider's user info response.
+	maxOAuthUserBody = 64 << 10
+)
+
+var errNoLogin = errors.New("not signed in")
+
+// oauthProvider is an OAuth 2.0 provider players can sign in with. The
+// redirect URL is filled in per request.
+type oauthProvider struct {
+	config oauth2.Config
+	// userURL returns the signed-in user's oauthUser.
+	userURL string
+}
+
+// oauthProviders returns the providers with credentials configured, by
+// name.
+func (cfg *Config) oauthProviders() map[string]*oauthProvider {
+	providers := make(map[string]*oauthProvider)
+	if cfg.OAuthGitHubClientID != "" {
+		providers["github"] = &oauthProvider{
+			config: oauth2.Config{
+				ClientID:     cfg.OAuthGitHubClientID,
+				ClientSecret: cfg.OAuthGitHubClientSecret,
+				Endpoint:     endpoints.GitHub,
+				Scopes:       []string{"read:user"},
+			},
+			userURL: "https://api.github.com/user",
+		}
+	}
+	if cfg.OAuthGoogleClientID != "" {
+		providers["google"] = &oauthProvider{
+			config: oauth2.Config{
+				ClientID:     cfg.OAuthGoogleClientID,
+				ClientSecret: cfg.OAuthGoogleClientSecret,
+				Endpoint:     endpoints.Google,
+				Scopes:       []string{"openid", "profile"},
+			},
+			userURL: "https://openidconnect.googleapis.com/v1/userinfo",
+		}
+	}
+	return providers
+}
+
+// oauthUser is what a profile takes from a provider's user info. GitHub
+// identifies users by a numeric id, OpenID Connect providers by sub.
+type oauthUser struct {
+	ID    json.Number `json:"id"`
+	Sub   string      `json:"sub"`
+	Login string      `json:"login"`
+	Name  string      `json:"name"`
+}
+
+func (u oauthUser) subject() string {
+	if u.Sub != "" {
+		return u.Sub
+	}
+	return u.ID.String()
+}
+
+// profile is a signed-in player. Token is the player token their saves
+// and achievements are kept under, the same on every device they sign
+// in on.
+type profile struct {
+	ID        string    `json:"id"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Name      string    `json:"name"`
+	Token     string    `json:"token"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// profileClaims is the signed payload of profileCookie.
+type profileClaims struct {
+	ID      string `json:"id"`
+	Expires int64  `json:"exp"`
+}
+
+// oauthState is the signed payload of oauthStateCookie. Token is the
+// anonymous player token the login started from, if any.
+type oauthState struct {
+	State    string `json:"state"`
+	Provider string `json:"provider"`
+	Token    string `json:"token,omitempty"`
+	Expires  int64  `json:"exp"`
+}
+
+// accounts signs players in with OAuth providers and keeps their
+// profiles in a Store. Cookies are signed with the session secret.
+type accounts struct {
+	store     Store
+	sess      *sessions
+	providers map[string]*oauthProvider
+	client    *http.Client
+	publicURL string
+	basePath  string
+	// sameSite is SameSiteNoneMode when the game may be embedded in other
+	// sites' frames, where the cookies would otherwise not be sent.
+	sameSite http.SameSite
+
+	mu sync.Mutex // serializes profile lookup-or-create
+}
+
+// newAccounts returns the accounts signed in with providers. If framed,
+// the game may be embedded by other sites, and the sign-in cookies are
+// sent in their frames too.
+func newAccounts(store Store, sess *sessions, providers map[string]*oauthProvider, client *http.Client, publicURL, basePath string, framed bool) *accounts {
+	a := &accounts{store: store, sess: sess, providers: providers, client: client, publicURL: publicURL, basePath: basePath, sameSite: http.SameSiteLaxMode}
+	if framed {
+		a.sameSite = http.SameSiteNoneMode
+	}
+	return a
+}
+
+// sign returns v as a cookie value signed for purpose.
+func (a *accounts) sign(purpose string, v any) string {
+	payload, _ := json.Marshal(v)
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(a.sess.mac(purpose, string(payload)))
+}
+
+// open checks a cookie value signed for purpose and decodes it into v.
+func (a *accounts) open(purpose, value string, v any) bool {
+	enc := base64.RawURLEncoding
+	payloadPart, sigPart, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	payload, err1 := enc.DecodeString(payloadPart)
+	sig, err2 := enc.DecodeString(sigPart)
+	if err1 != nil || err2 != nil || !hmac.Equal(sig, a.sess.mac(purpose, string(payload))) {
+		return false
+	}
+	return json.Unmarshal(payload, v) == nil
+}
+
+// cookie returns a cookie named name for the paths under path. A
+// negative maxAge deletes it.
+func (a *accounts) cookie(name, value, path string, maxAge time.Duration) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     a.basePath + path,
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: a.sameSite,
+	}
+}
+
+// redirectURL is where provider sends players back to after they sign
+// in.
+func (a *accounts) redirectURL(r *http.Request, provider string) string {
+	return publicOrigin(a.publicURL, r) + a.basePath + "/auth/" + provider + "/callback"
+}
+
+// Current returns the profile of the player signed in on r, failing with
+// errNoLogin if there is none. It is safe to call on a nil accounts.
+func (a *accounts) Current(r *http.Request) (profile, error) {
+	if a == nil {
+		return profile{}, errNoLogin
+	}
+	c, err := r.Cookie(profileCookie)
+	if err != nil {
+		return profile{}, errNoLogin
+	}
+	var claims profileClaims
+	if !a.open("profile", c.Value, &claims) || claims.ID == "" || time.Now().Unix() >= claims.Expires {
+		return profile{}, errNoLogin
+	}
+	p, err := a.load(claims.ID)
+	if errors.Is(err, ErrNotFound) {
+		return profile{}, errNoLogin
+	}
+	return p, err
+}
+
+func (a *accounts) load(id string) (profile, error) {
+	var p profile
+	data, err := a.store.Get(profileNamespace, id)
+	if err != nil {
+		return p, err
+	}
+	err = json.Unmarshal(data, &p)
+	return p, err
+}
+
+// loginKey is the profileLoginNamespace key of a provider account.
+// Subjects are hashed, since they need not be valid store names.
+func loginKey(provider, subject string) string {
+	sum := sha256.Sum256([]byte(subject))
+	return provider + "-" + hex.EncodeToString(sum[:])
+}
+
+// profileFor returns the profile of the provider account user, creating
+// it on its first login. When the login started from the anonymous
+// player token, that player's save and achievements are carried over to
+// the profile unless it already has its own.
+func (a *accounts) profileFor(provider string, user oauthUser, token string) (profile, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := loginKey(provider, user.subject())
+	var p profile
+	data, err := a.store.Get(profileLoginNamespace, key)
+	switch {
+	case err == nil:
+		var id string
+		if err := json.Unmarshal(data, &id); err != nil {
+			return p, err
+		}
+		if p, err = a.load(id); err != nil {
+			return p, err
+		}
+	case errors.Is(err, ErrNotFound):
+		name := strings.TrimSpace(user.Name)
+		if name == "" {
+			name = user.Login
+		}
+		if r := []rune(name); len(r) > maxNameLength {
+			name = string(r[:maxNameLength])
+		}
+		p = profile{ID: randomID(16), Provider: provider, Subject: user.subject(), Name: name, Token: randomID(16), CreatedAt: time.Now().UTC()}
+		data, err := json.Marshal(p)
+		if err != nil {
+			return p, err
+		}
+		if err := a.store.Set(profileNamespace, p.ID, data); err != nil {
+			return p, err
+		}
+		id, _ := json.Marshal(p.ID)
+		if err := a.store.Set(profileLoginNamespace, key, id); err != nil {
+			return p, err
+		}
+	default:
+		return p, err
+	}
+	if token != "" && token != p.Token {
+		for _, ns := range []string{savesNamespace, progressNamespace} {
+			if err := a.adopt(ns, token, p.Token); err != nil {
+				return p, err
+			}
+		}
+	}
+	return p, nil
+}
+
+// adopt copies the value under from in namespace to to, if to has none.
+func (a *accounts) adopt(namespace, from, to string) error {
+	if _, err := a.store.Get(namespace, to); !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	data, err := a.store.Get(namespace, from)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return a.store.Set(namespace, to, data)
+}
+
+// loginHandler serves GET /auth/{provider}/login[?token=T], sending the
+// player to the provider to sign in. T is the anonymous player token
+// whose progress the profile should take over.
+func (a *accounts) loginHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("provider")
+		provider, ok := a.providers[name]
+		if !ok {
+			writeError(w, r, http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			writeError(w, r, http.StatusMethodNotAllowed)
+			return
+		}
+		token := r.URL.Query().Get("token")
+		if token != "" && !validToken.MatchString(token) {
+			writeError(w, r, http.StatusBadRequest)
+			return
+		}
+		st := oauthState{State: randomID(16), Provider: name, Token: token, Expires: time.Now().Add(oauthStateTTL).Unix()}
+		http.SetCookie(w, a.cookie(oauthStateCookie, a.sign("oauth-state", st), "/auth/", oauthStateTTL))
+		conf := provider.config
+		conf.RedirectURL = a.redirectURL(r, name)
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, conf.AuthCodeURL(st.State), http.StatusFound)
+	})
+}
+
+// callbackHandler serves GET /auth/{provider}/callback, where the
+// provider returns the player with an authorization code. The state must
+// match the one the login set in oauthStateCookie. The code is exchanged
+// for the player's identity, whose profile is signed in with
+// profileCookie before the player is sent back to the game.
+func (a *accounts) callbackHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("provider")
+		provider, ok := a.providers[name]
+		if !ok {
+			writeError(w, r, http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			writeError(w, r, http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		var st oauthState
+		c, err := r.Cookie(oauthStateCookie)
+		query := r.URL.Query()
+		if err != nil || !a.open("oauth-state", c.Value, &st) || st.Provider != name || time.Now().Unix() >= st.Expires ||
+			subtle.ConstantTimeCompare([]byte(st.State), []byte(query.Get("state"))) != 1 {
+			writeError(w, r, http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, a.cookie(oauthStateCookie, "", "/auth/", -time.Second))
+		code := query.Get("code")
+		if code == "" {
+			// The player declined, or the provider refused the login.
+			writeError(w, r, http.StatusForbidden)
+			return
+		}
+		ctx := context.WithValue(r.Context(), oauth2.HTTPClient, a.client)
+		conf := provider.config
+		conf.RedirectURL = a.redirectURL(r, name)
+		user, err := fetchOAuthUser(ctx, &conf, provider.userURL, code)
+		if err != nil {
+			slog.WarnContext(r.Context(), "oauth login failed", "provider", name, "err", err)
+			writeError(w, r, http.StatusBadGateway)
+			return
+		}
+		p, err := a.profileFor(name, user, st.Token)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "could not save profile", "provider", name, "err", err)
+			writeError(w, r, http.StatusInternalServerError)
+			return
+		}
+		claims := profileClaims{ID: p.ID, Expires: time.Now().Add(profileLoginTTL).Unix()}
+		http.SetCookie(w, a.cookie(profileCookie, a.sign("profile", claims), "/", profileLoginTTL))
+		http.Redirect(w, r, a.basePath+"/", http.StatusSeeOther)
+	})
+}
+
+// fetchOAuthUser exchanges code for a token and reads the user it
+// belongs to from userURL.
+func fetchOAuthUser(ctx context.Context, conf *oauth2.Config, userURL, code string) (oauthUser, error) {
+	var user oauthUser
+	tok, err := conf.Exchange(ctx, code)
+	if err != nil {
+		return user, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, userURL, nil)
+	if err != nil {
+		return user, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := conf.Client(ctx, tok).Do(req)
+	if err != nil {
+		return user, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return user, fmt.Errorf("user info: %s", resp.Status)
+	}
+	dec := json.NewDecoder(io.LimitReader(resp.Body, maxOAuthUserBody))
+	dec.UseNumber()
+	if err := dec.Decode(&user); err != nil {
+		return user, fmt.Errorf("user info: %w", err)
+	}
+	if user.subject() == "" {
+		return user, errors.New("user info has no subject")
+	}
+	return user, nil
+}
+
+// profileHandler serves GET /api/profile, the signed-in player's profile,
+// and DELETE /api/profile to sign out. Without a login GET answers 401.
+func profileHandler(a *accounts) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			p, err := a.Current(r)
+			if errors.Is(err, errNoLogin) {
+				writeAPIError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, "could not load profile")
+				return
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			writeJSON(w, http.StatusOK, p)
+		case http.MethodDelete:
+			http.SetCookie(w, a.cookie(profileCookie, "", "/", -time.Second))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, DELETE")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// mockOAuth is an OAuth provider that grants every authorization code
+// but "denied", for the user it holds.
+type mockOAuth struct {
+	*httptest.Server
+	user string
+}
+
+func newMockOAuth(t *testing.T, user string) *mockOAuth {
+	t.Helper()
+	m := &mockOAuth{user: user}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") == "denied" {
+			http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "tok-` + r.FormValue("code") + `", "token_type": "Bearer"}`))
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer tok-") {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(m.user))
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// testAccounts returns accounts signing in with provider "mock" at m,
+// and a mux serving their endpoints.
+func testAccounts(t *testing.T, m *mockOAuth, store Store) (*accounts, http.Handler) {
+	t.Helper()
+	providers := map[string]*oauthProvider{"mock": {
+		config: oauth2.Config{
+			ClientID:     "client",
+			ClientSecret: "secret",
+			Endpoint:     oauth2.Endpoint{AuthURL: m.URL + "/authorize", TokenURL: m.URL + "/token"},
+		},
+		userURL: m.URL + "/user",
+	}}
+	a := newAccounts(store, newSessions(testSecret, store, 0), providers, m.Client(), "https://game.example", "", false)
+	mux := http.NewServeMux()
+	mux.Handle("/auth/{provider}/login", a.loginHandler())
+	mux.Handle("/auth/{provider}/callback", a.callbackHandler())
+	mux.Handle("/api/profile", profileHandler(a))
+	return a, mux
+}
+
+// cookieNamed returns the cookie called name that w sets, or nil.
+func cookieNamed(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// oauthLogin starts a login for token on h and returns the state cookie
+// and the state sent to the provider.
+func oauthLogin(t *testing.T, h http.Handler, token string) (*http.Cookie, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/mock/login?token="+token, nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login: status %d, want 302", w.Code)
+	}
+	to, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := to.Query().Get("redirect_uri"); got != "https://game.example/auth/mock/callback" {
+		t.Errorf("redirect_uri = %q", got)
+	}
+	c := cookieNamed(w, oauthStateCookie)
+	if c == nil || !c.HttpOnly || !c.Secure {
+		t.Fatalf("state cookie %+v, want a secure HttpOnly cookie", c)
+	}
+	return c, to.Query().Get("state")
+}
+
+// oauthCallback returns from the provider with state and code, sending
+// cookie.
+func oauthCallback(h http.Handler, cookie *http.Cookie, state, code string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/auth/mock/callback?state="+url.QueryEscape(state)+"&code="+code, nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestOAuthCallbackSignsIn(t *testing.T) {
+	store := newMemoryStore()
+	store.Set(savesNamespace, testSaveToken, []byte(`{"room":"library"}`))
+	a, h := testAccounts(t, newMockOAuth(t, `{"id": 42, "login": "ada", "name": "Ada Lovelace"}`), store)
+
+	cookie, state := oauthLogin(t, h, testSaveToken)
+	w := oauthCallback(h, cookie, state, "abc")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/" {
+		t.Fatalf("callback: status %d to %q, want 303 to /", w.Code, w.Header().Get("Location"))
+	}
+	session := cookieNamed(w, profileCookie)
+	if session == nil || !session.HttpOnly || !session.Secure || session.MaxAge <= 0 {
+		t.Fatalf("profile cookie %+v, want a lasting secure HttpOnly cookie", session)
+	}
+	if c := cookieNamed(w, oauthStateCookie); c == nil || c.MaxAge >= 0 {
+		t.Error("the state cookie was not cleared")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/profile", nil)
+	r.AddCookie(session)
+	p, err := a.Current(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Provider != "mock" || p.Subject != "42" || p.Name != "Ada Lovelace" || p.Token == "" {
+		t.Errorf("profile %+v", p)
+	}
+	if data, err := store.Get(savesNamespace, p.Token); err != nil || string(data) != `{"room":"library"}` {
+		t.Errorf("the anonymous save was not carried over: %q, %v", data, err)
+	}
+	pw := httptest.NewRecorder()
+	h.ServeHTTP(pw, r)
+	var served profile
+	json.Unmarshal(pw.Body.Bytes(), &served)
+	if pw.Code != http.StatusOK || served.ID != p.ID {
+		t.Errorf("/api/profile: status %d, profile %+v", pw.Code, served)
+	}
+
+	// Signing in again, on another device, finds the same profile.
+	cookie, state = oauthLogin(t, h, "")
+	w = oauthCallback(h, cookie, state, "def")
+	r = httptest.NewRequest(http.MethodGet, "/api/profile", nil)
+	r.AddCookie(cookieNamed(w, profileCookie))
+	if again, err := a.Current(r); err != nil || again.ID != p.ID || again.Token != p.Token {
+		t.Errorf("second login gave profile %+v, %v; want %s again", again, err, p.ID)
+	}
+}
+
+func TestOAuthCallbackChecksState(t *testing.T) {
+	_, h := testAccounts(t, newMockOAuth(t, `{"sub": "user-1"}`), newMemoryStore())
+	cookie, state := oauthLogin(t, h, "")
+	forged := *cookie
+	forged.Value = strings.Replace(forged.Value, ".", "x.", 1)
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		state  string
+		code   string
+		want   int
+	}{
+		{"no state cookie", nil, state, "abc", http.StatusBadRequest},
+		{"mismatched state", cookie, state + "x", "abc", http.StatusBadRequest},
+		{"forged cookie", &forged, state, "abc", http.StatusBadRequest},
+		{"declined", cookie, state, "", http.StatusForbidden},
+		{"code refused", cookie, state, "denied", http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		w := oauthCallback(h, tt.cookie, tt.state, tt.code)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if cookieNamed(w, profileCookie) != nil {
+			t.Errorf("%s: signed in", tt.name)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/nowhere/login", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown provider: status %d, want 404", w.Code)
+	}
+}
+
+func TestProfileSignOut(t *testing.T) {
+	_, h := testAccounts(t, newMockOAuth(t, `{"sub": "user-1"}`), newMemoryStore())
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/profile", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("profile without a login: status %d, want 401", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/profile", nil))
+	if c := cookieNamed(w, profileCookie); w.Code != http.StatusNoContent || c == nil || c.MaxAge >= 0 {
+		t.Errorf("sign out: status %d, cookie %+v", w.Code, c)
+	}
+}
+
+func TestOAuthOffByDefault(t *testing.T) {
+	s := startServer(t)
+	if code, _ := get(t, s.url("/auth/github/login")); code != http.StatusNotFound {
+		t.Errorf("/auth/github/login without credentials: status %d, want 404", code)
+	}
+	if code := getJSON(t, s.url("/api/questions"), nil); code != http.StatusOK {
+		t.Errorf("anonymous play: status %d", code)
+	}
+	if _, err := loadConfig([]string{"-oauth-github-client-id", "id"}, noEnv); err == nil {
+		t.Error("a client ID without its secret was accepted")
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// achievementsFile holds the achievement definitions. Translations
+	// sit beside it as data/achievements.<lang>.json.
+	achievementsFile = "data/achievements.json"
+	// progressNamespace holds each player's answer tallies and unlocked
+	// achievements, keyed by player token.
+	progressNamespace = "progress"
+)
+
+// What an achievement condition's value must be, by conditionKinds.
+const (
+	conditionCount    = "count"    // a positive whole number
+	conditionRoom     = "room"     // a room ID
+	conditionFlag     = "flag"     // true
+	conditionAccuracy = "accuracy" // accuracy and minQuestions instead
+)
+
+// conditionKinds lists the known condition types and what each one's
+// value must be.
+var conditionKinds = map[string]string{
+	"correct_answers":        conditionCount,
+	"total_questions":        conditionCount,
+	"consecutive_correct":    conditionCount,
+	"comeback_correct":       conditionCount,
+	"quick_answers":          conditionCount,
+	"rooms_visited":          conditionCount,
+	"completion_time":        conditionCount,
+	"specific_room_visited":  conditionRoom,
+	"all_rooms_visited":      conditionFlag,
+	"game_completed_perfect": conditionFlag,
+	"accuracy_with_minimum":  conditionAccuracy,
+}
+
+// loadAchievements parses the achievement definitions in the file name
+// from fsys.
+func loadAchievements(fsys fs.FS, name string) ([]Achievement, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Achievements []Achievement `json:"achievements"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return doc.Achievements, nil
+}
+
+// achievementSet holds the achievement definitions keyed by language. The
+// definitions may be replaced while the set is in use.
+type achievementSet struct {
+	mu     sync.RWMutex
+	byLang map[string][]Achievement
+}
+
+func newAchievementSet(byLang map[string][]Achievement) *achievementSet {
+	return &achievementSet{byLang: byLang}
+}
+
+// Replace swaps in a new set of definitions.
+func (s *achievementSet) Replace(byLang map[string][]Achievement) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byLang = byLang
+}
+
+// Variants returns the definitions keyed by language. The caller must not
+// modify the result.
+func (s *achievementSet) Variants() map[string][]Achievement {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.byLang
+}
+
+// playerProgress is what the server knows of a player's answers, for
+// judging achievements, and the achievements unlocked so far.
+type playerProgress struct {
+	Answered int `json:"answered"`
+	Correct  int `json:"correct"`
+	Streak   int `json:"streak"` // correct answers in a row
+	Misses   int `json:"misses"` // wrong answers in a row
+	// Comeback is how many wrong answers in a row the latest answer
+	// broke, if it was correct.
+	Comeback int                  `json:"comeback"`
+	Unlocked map[string]time.Time `json:"unlocked"`
+}
+
+// met reports whether c holds for p. Conditions on rooms and timing are
+// judged by the client, which sees the whole game; for those met is
+// always false.
+func (c AchievementCondition) met(p playerProgress) bool {
+	var n float64
+	json.Unmarshal(c.Value, &n)
+	switch c.Type {
+	case "correct_answers":
+		return float64(p.Correct) >= n
+	case "total_questions":
+		return float64(p.Answered) >= n
+	case "consecutive_correct":
+		return float64(p.Streak) >= n
+	case "comeback_correct":
+		return float64(p.Comeback) >= n
+	case "accuracy_with_minimum":
+		return p.Answered >= c.MinQuestions && float64(p.Correct) >= c.Accuracy*float64(p.Answered)
+	}
+	return false
+}
+
+// cumulative reports whether c is judged on tallies that only ever grow,
+// so that a player who does not meet it now never did. A player may have
+// met a condition on a streak or on accuracy earlier and since fallen
+// short, so those are never taken back.
+func (c AchievementCondition) cumulative() bool {
+	return c.Type == "correct_answers" || c.Type == "total_questions"
+}
+
+// achievementTracker keeps per-player progress in a Store and unlocks the
+// achievements in defs as they are met, announcing each unlock to hooks.
+type achievementTracker struct {
+	mu    sync.Mutex // serializes read-modify-write updates
+	store Store
+	defs  *achievementSet
+	hooks *webhooks
+}
+
+func newAchievementTracker(store Store, defs *achievementSet, hooks *webhooks) *achievementTracker {
+	return &achievementTracker{store: store, defs: defs, hooks: hooks}
+}
+
+// Load returns the progress of the player with token, which is none for a
+// new player.
+func (t *achievementTracker) Load(token string) (playerProgress, error) {
+	p := playerProgress{Unlocked: map[string]time.Time{}}
+	data, err := t.store.Get(progressNamespace, token)
+	if errors.Is(err, ErrNotFound) {
+		return p, nil
+	}
+	if err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, err
+	}
+	if p.Unlocked == nil {
+		p.Unlocked = map[string]time.Time{}
+	}
+	return p, nil
+}
+
+// Record tallies an answer by the player with token at now and returns
+// the IDs of the achievements it unlocked.
+func (t *achievementTracker) Record(token string, correct bool, now time.Time) ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, err := t.Load(token)
+	if err != nil {
+		return nil, err
+	}
+	p.Answered++
+	p.Comeback = 0
+	if correct {
+		p.Correct++
+		p.Streak++
+		p.Comeback, p.Misses = p.Misses, 0
+	} else {
+		p.Streak = 0
+		p.Misses++
+	}
+	var unlocked []Achievement
+	for _, a := range t.defs.Variants()[defaultLanguage] {
+		if _, done := p.Unlocked[a.ID]; !done && a.Condition.met(p) {
+			p.Unlocked[a.ID] = now.UTC()
+			unlocked = append(unlocked, a)
+		}
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.store.Set(progressNamespace, token, data); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(unlocked))
+	for i, a := range unlocked {
+		ids[i] = a.ID
+		t.hooks.Notify(eventAchievementUnlocked, map[string]any{"player": playerRef(token), "achievement": a})
+	}
+	return ids, nil
+}
+
+// reevaluation summarizes a run of Reevaluate: how many players were
+// checked and changed, and how many players each achievement was granted
+// to or revoked from.
+type reevaluation struct {
+	Players int            `json:"players"`
+	Changed int            `json:"changed"`
+	Granted map[string]int `json:"granted"`
+	Revoked map[string]int `json:"revoked"`
+}
+
+// Reevaluate checks the progress of every player against the current
+// definitions, unlocking at now the achievements they meet but have not
+// unlocked, as Record would have. With revoke, it also takes back those
+// that are no longer defined and those on a cumulative condition the
+// player no longer meets. Players are loaded and saved one at a time, so
+// that the run holds only one in memory and answers being recorded meanwhile
+// wait for no more than a player's update. Running it again changes
+// nothing more. Unlocks made here are not announced to hooks.
+func (t *achievementTracker) Reevaluate(revoke bool, now time.Time) (reevaluation, error) {
+	res := reevaluation{Granted: map[string]int{}, Revoked: map[string]int{}}
+	tokens, err := t.store.List(progressNamespace)
+	if err != nil {
+		return res, err
+	}
+	for _, token := range tokens {
+		changed, err := t.reevaluate(token, revoke, now, &res)
+		if err != nil {
+			return res, fmt.Errorf("player %s: %w", playerRef(token), err)
+		}
+		res.Players++
+		if changed {
+			res.Changed++
+		}
+	}
+	return res, nil
+}
+
+// reevaluate updates the achievements of the player with token for
+// Reevaluate, tallying the changes in res, and reports whether there were
+// any.
+func (t *achievementTracker) reevaluate(token string, revoke bool, now time.Time, res *reevaluation) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, err := t.Load(token)
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	defined := make(map[string]bool)
+	for _, a := range t.defs.Variants()[defaultLanguage] {
+		defined[a.ID] = true
+		_, done := p.Unlocked[a.ID]
+		switch met := a.Condition.met(p); {
+		case !done && met:
+			p.Unlocked[a.ID] = now.UTC()
+			res.Granted[a.ID]++
+			changed = true
+		case done && !met && revoke && a.Condition.cumulative():
+			delete(p.Unlocked, a.ID)
+			res.Revoked[a.ID]++
+			changed = true
+		}
+	}
+	if revoke {
+		for id := range p.Unlocked {
+			if !defined[id] {
+				delete(p.Unlocked, id)
+				res.Revoked[id]++
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return false, err
+	}
+	return true, t.store.Set(progressNamespace, token, data)
+}
+
+// adminAchievementsHandler serves POST
+// /api/admin/achievements/reevaluate?revoke=B, which brings every player's
+// achievements in line with the current definitions and reports what
+// changed.
+func adminAchievementsHandler(tracker *achievementTracker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		revoke := false
+		if v := r.URL.Query().Get("revoke"); v != "" {
+			var err error
+			if revoke, err = strconv.ParseBool(v); err != nil {
+				writeAPIError(w, http.StatusBadRequest, "revoke must be true or false")
+				return
+			}
+		}
+		res, err := tracker.Reevaluate(revoke, time.Now())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "achievement re-evaluation failed", "err", err, "players", res.Players)
+			writeAPIError(w, http.StatusInternalServerError, "could not re-evaluate achievements")
+			return
+		}
+		slog.InfoContext(r.Context(), "achievements re-evaluated", "players", res.Players, "changed", res.Changed, "revoke", revoke)
+		writeJSON(w, http.StatusOK, res)
+	})
+}
+
+// achievementStatus is an achievement as listed for a player.
+type achievementStatus struct {
+	Achievement
+	Unlocked   bool       `json:"unlocked"`
+	UnlockedAt *time.Time `json:"unlockedAt,omitempty"`
+}
+
+// achievementsHandler serves GET /api/achievements?token=T in the
+// negotiated language. With a player token, each achievement says whether
+// and when that player unlocked it.
+func achievementsHandler(achievements *achievementSet, tracker *achievementTracker, langs *languageRegistry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		defs := localize(w, r, langs, achievements.Variants())
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			writeJSON(w, http.StatusOK, map[string]any{"achievements": defs})
+			return
+		}
+		if !validToken.MatchString(token) {
+			writeAPIError(w, http.StatusBadRequest, "invalid token")
+			return
+		}
+		p, err := tracker.Load(token)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "could not load achievements")
+			return
+		}
+		list := make([]achievementStatus, len(defs))
+		for i, a := range defs {
+			list[i] = achievementStatus{Achievement: a}
+			if at, ok := p.Unlocked[a.ID]; ok {
+				list[i].Unlocked, list[i].UnlockedAt = true, &at
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"achievements": list})
+	})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testAchievements is a small definition set with one achievement of each
+// kind the server judges, plus one the client judges.
+func testAchievements() *achievementSet {
+	cond := func(typ, value string) AchievementCondition {
+		return AchievementCondition{Type: typ, Value: json.RawMessage(value)}
+	}
+	return newAchievementSet(map[string][]Achievement{defaultLanguage: {
+		{ID: "first", Condition: cond("correct_answers", "1")},
+		{ID: "streak", Condition: cond("consecutive_correct", "3")},
+		{ID: "comeback", Condition: cond("comeback_correct", "2")},
+		{ID: "sharp", Condition: AchievementCondition{Type: "accuracy_with_minimum", Accuracy: 0.8, MinQuestions: 5}},
+		{ID: "explorer", Condition: cond("rooms_visited", "1")},
+	}})
+}
+
+func TestAchievementsUnlockWhenMet(t *testing.T) {
+	tracker := newAchievementTracker(newMemoryStore(), testAchievements(), nil)
+	now := time.Now()
+	var unlocked []string
+	for _, correct := range []bool{false, false, true, true, true, true, true} {
+		ids, err := tracker.Record(testSaveToken, correct, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unlocked = append(unlocked, ids...)
+	}
+	if want := []string{"first", "comeback", "streak"}; !slices.Equal(unlocked, want) {
+		t.Errorf("unlocked %v, want %v in that order", unlocked, want)
+	}
+	p, err := tracker.Load(testSaveToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.Unlocked["first"]; !ok || p.Answered != 7 || p.Correct != 5 {
+		t.Errorf("saved progress %+v", p)
+	}
+
+	// 5 of 7 is short of the 80%, and rooms are the client's to judge.
+	for _, id := range []string{"sharp", "explorer"} {
+		if _, ok := p.Unlocked[id]; ok {
+			t.Errorf("%s unlocked without being met", id)
+		}
+	}
+	if ids, _ := tracker.Record(testSaveToken, true, now); slices.Contains(ids, "first") {
+		t.Error("first unlocked twice")
+	}
+}
+
+func TestAchievementsNotUnlockedByWrongAnswers(t *testing.T) {
+	tracker := newAchievementTracker(newMemoryStore(), testAchievements(), nil)
+	for i := 0; i < 10; i++ {
+		if ids, err := tracker.Record(testSaveToken, false, time.Now()); err != nil || len(ids) > 0 {
+			t.Fatalf("wrong answer %d unlocked %v (%v)", i, ids, err)
+		}
+	}
+}
+
+func TestAchievementsEndpoint(t *testing.T) {
+	s := startServer(t)
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := questions[0]
+	var resp answerResponse
+	if code := postJSON(t, s.url("/api/answer?token="+testSaveToken), map[string]any{"questionID": q.ID, "choiceIndex": q.CorrectAnswer}, &resp); code != http.StatusOK {
+		t.Fatalf("answer: status %d", code)
+	}
+	if !slices.Contains(resp.Unlocked, "first_steps") {
+		t.Errorf("a first correct answer unlocked %v, want first_steps", resp.Unlocked)
+	}
+	wrong := (q.CorrectAnswer + 1) % len(q.Answers)
+	resp = answerResponse{}
+	if postJSON(t, s.url("/api/answer?token=other-player"), map[string]any{"questionID": q.ID, "choiceIndex": wrong}, &resp); len(resp.Unlocked) > 0 {
+		t.Errorf("a wrong answer unlocked %v", resp.Unlocked)
+	}
+
+	var body struct {
+		Achievements []achievementStatus `json:"achievements"`
+	}
+	if code := getJSON(t, s.url("/api/achievements?token="+testSaveToken), &body); code != http.StatusOK {
+		t.Fatalf("/api/achievements: status %d", code)
+	}
+	for _, a := range body.Achievements {
+		if want := a.ID == "first_steps"; a.Unlocked != want || (a.UnlockedAt != nil) != want {
+			t.Errorf("%s: unlocked %v at %v", a.ID, a.Unlocked, a.UnlockedAt)
+		}
+	}
+	if len(body.Achievements) == 0 {
+		t.Error("no achievements listed")
+	}
+	if code := getJSON(t, s.url("/api/achievements?token=bad%20token"), nil); code != http.StatusBadRequest {
+		t.Errorf("invalid token: status %d, want 400", code)
+	}
+}
+
+func TestReevaluateGrantsNewAchievements(t *testing.T) {
+	defs := testAchievements()
+	tracker := newAchievementTracker(newMemoryStore(), defs, nil)
+	now := time.Now()
+	for _, correct := range []bool{true, true, true} {
+		tracker.Record(testSaveToken, correct, now)
+	}
+	tracker.Record("other-player", false, now)
+
+	cond := func(typ, value string) AchievementCondition {
+		return AchievementCondition{Type: typ, Value: json.RawMessage(value)}
+	}
+	rules := defs.Variants()[defaultLanguage]
+	defs.Replace(map[string][]Achievement{defaultLanguage: append(slices.Clone(rules),
+		Achievement{ID: "triple", Condition: cond("correct_answers", "3")},
+		Achievement{ID: "tenfold", Condition: cond("correct_answers", "10")},
+	)})
+	res, err := tracker.Reevaluate(false, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Players != 2 || res.Changed != 1 || res.Granted["triple"] != 1 || len(res.Granted) != 1 || len(res.Revoked) != 0 {
+		t.Errorf("re-evaluation %+v, want triple granted to one of two players", res)
+	}
+	p, _ := tracker.Load(testSaveToken)
+	if _, ok := p.Unlocked["triple"]; !ok {
+		t.Error("triple was not saved as unlocked")
+	}
+	if other, _ := tracker.Load("other-player"); len(other.Unlocked) != 0 {
+		t.Errorf("a player with no correct answers unlocked %v", other.Unlocked)
+	}
+	if res, _ := tracker.Reevaluate(false, now); res.Changed != 0 || len(res.Granted) != 0 {
+		t.Errorf("a second run changed %+v", res)
+	}
+
+	// Raising triple's bar and dropping first takes both back, but the
+	// streak, met once, stands.
+	defs.Replace(map[string][]Achievement{defaultLanguage: {
+		{ID: "streak", Condition: cond("consecutive_correct", "3")},
+		{ID: "triple", Condition: cond("correct_answers", "5")},
+	}})
+	tracker.Record(testSaveToken, false, now)
+	if res, _ := tracker.Reevaluate(false, now); res.Changed != 0 {
+		t.Errorf("without revoke, re-evaluation changed %+v", res)
+	}
+	res, err = tracker.Reevaluate(true, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Changed != 1 || res.Revoked["triple"] != 1 || res.Revoked["first"] != 1 || len(res.Revoked) != 2 {
+		t.Errorf("revoking re-evaluation %+v, want triple and first taken back", res)
+	}
+	p, _ = tracker.Load(testSaveToken)
+	if _, ok := p.Unlocked["streak"]; !ok || len(p.Unlocked) != 1 {
+		t.Errorf("unlocked after revoking %v, want only streak", p.Unlocked)
+	}
+}
+
+func TestReevaluateEndpoint(t *testing.T) {
+	const path = "/api/admin/achievements/reevaluate"
+	s := startServer(t, "-admin-password", testAdminPassword)
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := questions[0]
+	if code := postJSON(t, s.url("/api/answer?token="+testSaveToken), map[string]any{"questionID": q.ID, "choiceIndex": q.CorrectAnswer}, nil); code != http.StatusOK {
+		t.Fatalf("answer: status %d", code)
+	}
+	code, body := adminDo(t, s, http.MethodPost, path+"?revoke=true", nil)
+	var res reevaluation
+	if err := json.Unmarshal(body, &res); code != http.StatusOK || err != nil || res.Players != 1 || res.Changed != 0 {
+		t.Errorf("re-evaluation: status %d, %s", code, body)
+	}
+	if code, _ := adminDo(t, s, http.MethodPost, path+"?revoke=maybe", nil); code != http.StatusBadRequest {
+		t.Errorf("revoke=maybe: status %d, want 400", code)
+	}
+	if code, _ := adminDo(t, s, http.MethodGet, path, nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want 405", code)
+	}
+	if code := postJSON(t, s.url(path), nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("without credentials: status %d, want 401", code)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// performanceNamespace holds each game session's recent answers, keyed
+	// by session ID.
+	performanceNamespace = "performance"
+	// accuracyWindow is how many of a session's latest answers its
+	// accuracy is measured over.
+	accuracyWindow = 8
+	// minAdaptSamples is how many answers a session needs before the
+	// difficulty moves off medium.
+	minAdaptSamples = 3
+	// hotAccuracy and coldAccuracy are the accuracies at or beyond which
+	// harder or easier questions are served.
+	hotAccuracy  = 0.75
+	coldAccuracy = 0.4
+)
+
+// difficultyRank orders the difficulty levels from easiest.
+var difficultyRank = map[string]int{"easy": 0, "medium": 1, "hard": 2}
+
+// performance is a session's latest answers, oldest first, whether each
+// was correct.
+type performance struct {
+	Recent  []bool    `json:"recent"`
+	Expires time.Time `json:"expires"`
+}
+
+// performances keeps per-session answer windows in a Store.
+type performances struct {
+	mu    sync.Mutex // serializes read-modify-write updates
+	store Store
+}
+
+func newPerformances(store Store) *performances {
+	return &performances{store: store}
+}
+
+// Load returns the recent answers of session id, none for a new session.
+func (p *performances) Load(id string) (performance, error) {
+	var perf performance
+	data, err := p.store.Get(performanceNamespace, id)
+	if errors.Is(err, ErrNotFound) {
+		return perf, nil
+	}
+	if err != nil {
+		return perf, err
+	}
+	err = json.Unmarshal(data, &perf)
+	return perf, err
+}
+
+// Record adds an answer to session id's window, dropping the oldest once
+// it is full.
+func (p *performances) Record(id string, correct bool, now time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	perf, err := p.Load(id)
+	if err != nil {
+		return err
+	}
+	perf.Recent = append(perf.Recent, correct)
+	if len(perf.Recent) > accuracyWindow {
+		perf.Recent = perf.Recent[len(perf.Recent)-accuracyWindow:]
+	}
+	if perf.Expires.IsZero() {
+		perf.Expires = now.Add(sessionTTL).UTC()
+	}
+	data, err := json.Marshal(perf)
+	if err != nil {
+		return err
+	}
+	return p.store.Set(performanceNamespace, id, data)
+}
+
+// collect deletes the windows of sessions whose tokens have expired, every
+// interval until ctx is cancelled.
+func (p *performances) collect(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			ids, err := p.store.List(performanceNamespace)
+			if err != nil {
+				slog.Warn("could not list session performance", "err", err)
+				continue
+			}
+			p.mu.Lock()
+			for _, id := range ids {
+				if perf, err := p.Load(id); err == nil && now.After(perf.Expires) {
+					p.store.Delete(performanceNamespace, id)
+				}
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// Difficulty is the level to serve next: hard on a hot streak, easy on a
+// cold one, and medium otherwise or until there are enough answers to
+// tell.
+func (perf performance) Difficulty() string {
+	if len(perf.Recent) < minAdaptSamples {
+		return "medium"
+	}
+	correct := 0
+	for _, ok := range perf.Recent {
+		if ok {
+			correct++
+		}
+	}
+	switch accuracy := float64(correct) / float64(len(perf.Recent)); {
+	case accuracy >= hotAccuracy:
+		return "hard"
+	case accuracy <= coldAccuracy:
+		return "easy"
+	default:
+		return "medium"
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPerformanceDifficulty(t *testing.T) {
+	tests := []struct {
+		recent []bool
+		want   string
+	}{
+		{nil, "medium"},
+		{[]bool{true, true}, "medium"},
+		{[]bool{true, true, true}, "hard"},
+		{[]bool{false, false, false}, "easy"},
+		{[]bool{true, false, true, false}, "medium"},
+		{[]bool{false, true, true, true}, "hard"},
+		{[]bool{true, false, false, false, true}, "easy"},
+	}
+	for _, tt := range tests {
+		if got := (performance{Recent: tt.recent}).Difficulty(); got != tt.want {
+			t.Errorf("recent answers %v: difficulty %s, want %s", tt.recent, got, tt.want)
+		}
+	}
+}
+
+func TestPerformanceWindowRolls(t *testing.T) {
+	p := newPerformances(newMemoryStore())
+	now := time.Now()
+	for i := 0; i < accuracyWindow; i++ {
+		p.Record("session-1", false, now)
+	}
+	for i := 0; i < accuracyWindow; i++ {
+		p.Record("session-1", true, now)
+	}
+	perf, err := p.Load("session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(perf.Recent) != accuracyWindow || perf.Difficulty() != "hard" {
+		t.Errorf("after a cold then a hot streak: %d answers kept, difficulty %s; want %d and hard", len(perf.Recent), perf.Difficulty(), accuracyWindow)
+	}
+}
+
+// streak answers n questions in sess, all correctly or all wrongly, and
+// returns the difficulty /api/questions then targets along with the rank
+// of each question it serves over 30 draws.
+func streak(t *testing.T, s *runningServer, sess sessionResponse, n int, correct bool) (string, []int) {
+	t.Helper()
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shown := shownQuestions(t, s, sess)
+	session := "session=" + url.QueryEscape(sess.Token)
+	for _, q := range questions[:n] {
+		choice := slices.Index(shown[q.ID], q.Answers[q.CorrectAnswer])
+		if !correct {
+			choice = (choice + 1) % len(q.Answers)
+		}
+		if code := postJSON(t, s.url("/api/answer?"+session), map[string]any{"questionID": q.ID, "choiceIndex": choice}, nil); code != http.StatusOK {
+			t.Fatalf("answer %s: status %d", q.ID, code)
+		}
+	}
+	var target string
+	var ranks []int
+	for i := 0; i < 30; i++ {
+		var body struct {
+			Difficulty string           `json:"difficulty"`
+			Questions  []servedQuestion `json:"questions"`
+		}
+		if code := getJSON(t, s.url("/api/questions?count=1&"+session), &body); code != http.StatusOK {
+			t.Fatalf("/api/questions: status %d", code)
+		}
+		target = body.Difficulty
+		for _, q := range body.Questions {
+			ranks = append(ranks, difficultyRank[q.Difficulty])
+		}
+	}
+	return target, ranks
+}
+
+// tally counts the easy and hard questions among ranks.
+func tally(ranks []int) (easy, hard int) {
+	for _, r := range ranks {
+		switch r {
+		case difficultyRank["easy"]:
+			easy++
+		case difficultyRank["hard"]:
+			hard++
+		}
+	}
+	return easy, hard
+}
+
+func TestAdaptiveDifficultyFollowsStreaks(t *testing.T) {
+	s := startServer(t, "-session-secret", string(testSecret), "-rate-limit", "1000", "-rate-burst", "1000")
+
+	target, ranks := streak(t, s, startSession(t, s), 4, true)
+	if easy, hard := tally(ranks); target != "hard" || hard <= easy {
+		t.Errorf("after a hot streak: target %q, %d hard and %d easy questions served; want hard ones to dominate", target, hard, easy)
+	}
+	target, ranks = streak(t, s, startSession(t, s), 4, false)
+	if easy, hard := tally(ranks); target != "easy" || easy <= hard {
+		t.Errorf("after a cold streak: target %q, %d easy and %d hard questions served; want easy ones to dominate", target, easy, hard)
+	}
+
+	var body struct {
+		Difficulty string `json:"difficulty"`
+	}
+	getJSON(t, s.url("/api/questions?count=1"), &body)
+	if body.Difficulty != "" {
+		t.Errorf("an anonymous request reported difficulty %q", body.Difficulty)
+	}
+}
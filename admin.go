@@ -0,0 +1,335 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+const (
+	// overlayNamespace holds admin edits to the question bank, one entry
+	// per question ID.
+	overlayNamespace = "questions"
+	// maxAdminBody bounds a single question upload.
+	maxAdminBody = 16 << 10
+	// overlaySource names the admin edits as a source of questions in
+	// content problems.
+	overlaySource = "admin edits"
+)
+
+// errQuestionExists and errNoQuestion report a create of an existing ID
+// and an update or delete of a missing one.
+var (
+	errQuestionExists = errors.New("question already exists")
+	errNoQuestion     = errors.New("no such question")
+)
+
+// overlayEntry is a stored admin edit: a replacement or new question, or
+// a deletion of an embedded one.
+type overlayEntry struct {
+	Question *Question `json:"question,omitempty"`
+	Deleted  bool      `json:"deleted,omitempty"`
+}
+
+// questionOverlay layers admin edits kept in a Store over the embedded
+// questions and keeps bank holding the merged set.
+type questionOverlay struct {
+	mu    sync.Mutex
+	store Store
+	base  []Question
+	bank  *questionBank
+}
+
+// newQuestionOverlay applies the edits already in store to bank, whose
+// current questions are taken as the embedded defaults.
+func newQuestionOverlay(store Store, bank *questionBank) (*questionOverlay, error) {
+	o := &questionOverlay{store: store, base: bank.All(), bank: bank}
+	edits, err := o.edits()
+	if err != nil {
+		return nil, err
+	}
+	merged := o.merge(edits)
+	if problems := o.validate(edits, merged); len(problems) > 0 {
+		return nil, fmt.Errorf("stored question edits: %w", &contentError{Problems: problems})
+	}
+	o.bank.Replace(merged)
+	return o, nil
+}
+
+// Rebase replaces the embedded questions the stored edits are applied to,
+// as when the question file is reloaded, and updates the bank.
+func (o *questionOverlay) Rebase(base []Question) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	edits, err := o.edits()
+	if err != nil {
+		return err
+	}
+	prev := o.base
+	o.base = base
+	merged := o.merge(edits)
+	if problems := o.validate(edits, merged); len(problems) > 0 {
+		o.base = prev
+		return fmt.Errorf("stored question edits: %w", &contentError{Problems: problems})
+	}
+	o.bank.Replace(merged)
+	return nil
+}
+
+// Reload reapplies the stored edits, as after a restore.
+func (o *questionOverlay) Reload() error {
+	o.mu.Lock()
+	base := o.base
+	o.mu.Unlock()
+	return o.Rebase(base)
+}
+
+// Check reports the problems with the question set edits would produce,
+// without storing them.
+func (o *questionOverlay) Check(edits map[string]overlayEntry) []contentProblem {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.validate(edits, o.merge(edits))
+}
+
+// edits loads every stored overlay entry, keyed by question ID.
+func (o *questionOverlay) edits() (map[string]overlayEntry, error) {
+	keys, err := o.store.List(overlayNamespace)
+	if err != nil {
+		return nil, err
+	}
+	edits := make(map[string]overlayEntry, len(keys))
+	for _, id := range keys {
+		data, err := o.store.Get(overlayNamespace, id)
+		if err != nil {
+			return nil, err
+		}
+		var e overlayEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, fmt.Errorf("stored question %s: %w", id, err)
+		}
+		edits[id] = e
+	}
+	return edits, nil
+}
+
+// merge applies edits to the embedded questions, keeping their order and
+// appending new questions at the end.
+func (o *questionOverlay) merge(edits map[string]overlayEntry) []Question {
+	merged := make([]Question, 0, len(o.base)+len(edits))
+	inBase := make(map[string]bool, len(o.base))
+	for _, q := range o.base {
+		inBase[q.ID] = true
+		e, ok := edits[q.ID]
+		switch {
+		case !ok:
+			merged = append(merged, q)
+		case !e.Deleted && e.Question != nil:
+			merged = append(merged, *e.Question)
+		}
+	}
+	for _, id := range sortedKeys(edits) {
+		if e := edits[id]; !inBase[id] && !e.Deleted && e.Question != nil {
+			merged = append(merged, *e.Question)
+		}
+	}
+	return merged
+}
+
+// validate reports the problems with merged, the questions edits produce:
+// IDs defined by more than one source, or failing that whatever the
+// startup validator finds.
+func (o *questionOverlay) validate(edits map[string]overlayEntry, merged []Question) []contentProblem {
+	if problems := o.conflicts(edits); len(problems) > 0 {
+		return problems
+	}
+	return checkQuestions(merged)
+}
+
+// conflicts reports the question IDs that more than one source would
+// define once edits are applied, naming each source: the embedded
+// questions, less those an edit replaces or deletes, and the edits. An
+// edit can clash with an embedded question when it is stored under a
+// different ID than its question has, as a restored backup may be.
+func (o *questionOverlay) conflicts(edits map[string]overlayEntry) []contentProblem {
+	type source struct{ file, item string }
+	defined := make(map[string]source)
+	var problems []contentProblem
+	claim := func(id string, src source) {
+		if prev, dup := defined[id]; dup {
+			problems = append(problems, contentProblem{File: src.file, Item: src.item, Message: fmt.Sprintf("duplicate id, also defined by %s %s", prev.file, prev.item)})
+			return
+		}
+		defined[id] = src
+	}
+	for i, q := range o.base {
+		if _, edited := edits[q.ID]; !edited {
+			claim(q.ID, source{questionsFile, fmt.Sprintf("questions[%d] (%s)", i, q.ID)})
+		}
+	}
+	for _, key := range sortedKeys(edits) {
+		if e := edits[key]; !e.Deleted && e.Question != nil {
+			item := key
+			if e.Question.ID != key {
+				item = fmt.Sprintf("%s (%s)", key, e.Question.ID)
+			}
+			claim(e.Question.ID, source{overlaySource, item})
+		}
+	}
+	return problems
+}
+
+// checkQuestions runs the startup validator over questions.
+func checkQuestions(questions []Question) []contentProblem {
+	data, err := json.Marshal(map[string]any{"questions": questions})
+	if err != nil {
+		return []contentProblem{{Message: err.Error()}}
+	}
+	return validateQuestions(questionsFile, data)
+}
+
+// apply stores e for id once the resulting question set validates, and
+// updates the bank. wantExists says whether id must currently be served.
+func (o *questionOverlay) apply(id string, e overlayEntry, wantExists bool) ([]contentProblem, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, exists := o.bank.Get(id); exists != wantExists {
+		if exists {
+			return nil, errQuestionExists
+		}
+		return nil, errNoQuestion
+	}
+	edits, err := o.edits()
+	if err != nil {
+		return nil, err
+	}
+	edits[id] = e
+	merged := o.merge(edits)
+	if problems := o.validate(edits, merged); len(problems) > 0 {
+		return problems, nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.store.Set(overlayNamespace, id, data); err != nil {
+		return nil, err
+	}
+	o.bank.Replace(merged)
+	return nil, nil
+}
+
+// Create adds q, which must have a new ID.
+func (o *questionOverlay) Create(q Question) ([]contentProblem, error) {
+	return o.apply(q.ID, overlayEntry{Question: &q}, false)
+}
+
+// Update replaces the question with q's ID.
+func (o *questionOverlay) Update(q Question) ([]contentProblem, error) {
+	return o.apply(q.ID, overlayEntry{Question: &q}, true)
+}
+
+// Delete removes the question with the given ID.
+func (o *questionOverlay) Delete(id string) error {
+	_, err := o.apply(id, overlayEntry{Deleted: true}, true)
+	return err
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// basicAuth lets through only requests carrying the given HTTP Basic
+// credentials.
+func basicAuth(next http.Handler, user, password string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		userOK := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+		if !ok || !userOK || !passOK {
+			w.Header().Set("WWW-Authenticate", `Basic realm="LobeLabyrinth admin", charset="UTF-8"`)
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminQuestionsHandler serves POST /api/admin/questions to create a
+// question, and PUT and DELETE /api/admin/questions/{id} to edit one.
+func adminQuestionsHandler(overlay *questionOverlay) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		allowed := "POST"
+		if id != "" {
+			allowed = "PUT, DELETE"
+		}
+		var problems []contentProblem
+		var err error
+		switch {
+		case r.Method == http.MethodPost && id == "", r.Method == http.MethodPut && id != "":
+			var q Question
+			r.Body = http.MaxBytesReader(w, r.Body, maxAdminBody)
+			dec := json.NewDecoder(r.Body)
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&q); err != nil {
+				writeBodyError(w, err, "invalid JSON body")
+				return
+			}
+			if id != "" {
+				if q.ID != "" && q.ID != id {
+					writeAPIError(w, http.StatusBadRequest, "question id does not match the URL")
+					return
+				}
+				q.ID = id
+			}
+			if !validStoreName.MatchString(q.ID) {
+				writeAPIError(w, http.StatusBadRequest, "id must be 1-128 letters, digits, '-' or '_'")
+				return
+			}
+			if id == "" {
+				problems, err = overlay.Create(q)
+			} else {
+				problems, err = overlay.Update(q)
+			}
+			if err == nil && len(problems) == 0 {
+				status := http.StatusOK
+				if id == "" {
+					status = http.StatusCreated
+				}
+				writeJSON(w, status, q)
+				return
+			}
+		case r.Method == http.MethodDelete && id != "":
+			if err = overlay.Delete(id); err == nil {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		default:
+			w.Header().Set("Allow", allowed)
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		switch {
+		case len(problems) > 0:
+			writeAPIErrorDetails(w, http.StatusBadRequest, "invalid question", problems)
+		case errors.Is(err, errQuestionExists):
+			writeAPIError(w, http.StatusConflict, err.Error())
+		case errors.Is(err, errNoQuestion):
+			writeAPIError(w, http.StatusNotFound, err.Error())
+		default:
+			writeAPIError(w, http.StatusInternalServerError, "could not save question")
+		}
+	})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testAdminPassword = "correct horse battery"
+
+// adminRequest sends method to the admin API path on s with body encoded
+// as JSON, authenticated as user with password.
+func adminRequest(t *testing.T, s *runningServer, method, path, user, password string, body any) int {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, s.url(path), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// newAdminQuestion returns a valid question with the given ID.
+func newAdminQuestion(id string) Question {
+	return Question{
+		ID:            id,
+		Category:      "history",
+		Difficulty:    "easy",
+		Question:      "Which castle tower is tallest?",
+		Answers:       []string{"North", "South", "East", "West"},
+		CorrectAnswer: 1,
+		Points:        50,
+		TimeLimit:     30,
+		Explanation:   "The south tower was rebuilt taller after the fire.",
+	}
+}
+
+func TestAdminRejectsBadCredentials(t *testing.T) {
+	s := startServer(t, "-admin-password", testAdminPassword)
+	q := newAdminQuestion("q900")
+	for _, c := range []struct{ user, password string }{
+		{"", ""},
+		{"admin", "wrong password!"},
+		{"root", testAdminPassword},
+	} {
+		if code := adminRequest(t, s, http.MethodPost, "/api/admin/questions", c.user, c.password, q); code != http.StatusUnauthorized {
+			t.Errorf("credentials %q/%q: status %d, want 401", c.user, c.password, code)
+		}
+	}
+	if slices.Contains(questionIDs(t, s, "count=50"), "q900") {
+		t.Error("an unauthorized create was served")
+	}
+}
+
+func TestAdminDisabledWithoutPassword(t *testing.T) {
+	s := startServer(t)
+	if code := adminRequest(t, s, http.MethodPost, "/api/admin/questions", "admin", "", newAdminQuestion("q900")); code == http.StatusCreated {
+		t.Error("the admin API accepted a question with no password configured")
+	}
+}
+
+func TestAdminRejectsInvalidQuestions(t *testing.T) {
+	s := startServer(t, "-admin-password", testAdminPassword)
+	noAnswers := newAdminQuestion("q901")
+	noAnswers.Answers = nil
+	badKey := newAdminQuestion("q902")
+	badKey.CorrectAnswer = 7
+	badDifficulty := newAdminQuestion("q903")
+	badDifficulty.Difficulty = "impossible"
+	for _, q := range []Question{noAnswers, badKey, badDifficulty} {
+		if code := adminRequest(t, s, http.MethodPost, "/api/admin/questions", "admin", testAdminPassword, q); code != http.StatusBadRequest {
+			t.Errorf("question %s: status %d, want 400", q.ID, code)
+		}
+	}
+	if code := adminRequest(t, s, http.MethodPost, "/api/admin/questions", "admin", testAdminPassword, newAdminQuestion("q001")); code != http.StatusConflict {
+		t.Errorf("creating an existing ID: status %d, want 409", code)
+	}
+	if code := adminRequest(t, s, http.MethodPut, "/api/admin/questions/q999", "admin", testAdminPassword, newAdminQuestion("q999")); code != http.StatusNotFound {
+		t.Errorf("updating a missing question: status %d, want 404", code)
+	}
+}
+
+func TestAdminCreateThenRead(t *testing.T) {
+	s := startServer(t, "-admin-password", testAdminPassword)
+	if code := adminRequest(t, s, http.MethodPost, "/api/admin/questions", "admin", testAdminPassword, newAdminQuestion("q900")); code != http.StatusCreated {
+		t.Fatalf("create: status %d, want 201", code)
+	}
+	if !slices.Contains(questionIDs(t, s, "count=50"), "q900") {
+		t.Fatal("/api/questions does not serve the created question")
+	}
+
+	if code := adminRequest(t, s, http.MethodDelete, "/api/admin/questions/q001", "admin", testAdminPassword, nil); code != http.StatusNoContent {
+		t.Fatalf("delete: status %d, want 204", code)
+	}
+	if slices.Contains(questionIDs(t, s, "count=50"), "q001") {
+		t.Error("/api/questions still serves a deleted embedded question")
+	}
+}
+
+func TestAdminEditsPersist(t *testing.T) {
+	inTempDir(t)
+	s := startServerIn(t, "-admin-password", testAdminPassword)
+	if code := adminRequest(t, s, http.MethodPost, "/api/admin/questions", "admin", testAdminPassword, newAdminQuestion("q900")); code != http.StatusCreated {
+		t.Fatalf("create: status %d, want 201", code)
+	}
+	s.stop(t)
+
+	s = startServerIn(t, "-admin-password", testAdminPassword)
+	if !slices.Contains(questionIDs(t, s, "count=50"), "q900") {
+		t.Error("the created question did not survive a restart")
+	}
+}
+
+// embeddedOverlay returns an overlay of edits in store on the embedded
+// questions.
+func embeddedOverlay(t *testing.T, store Store) (*questionOverlay, error) {
+	t.Helper()
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newQuestionOverlay(store, newQuestionBank(questions))
+}
+
+func TestEmbeddedQuestionIDsUnique(t *testing.T) {
+	o, err := embeddedOverlay(t, newMemoryStore())
+	if err != nil {
+		t.Fatalf("the embedded questions alone: %v", err)
+	}
+	if problems := o.conflicts(nil); len(problems) > 0 {
+		t.Errorf("embedded questions conflict: %s", problemMessages(problems))
+	}
+}
+
+func TestOverlayDuplicateIDRejected(t *testing.T) {
+	// An edit stored under another key than its question's ID, as a
+	// restored backup may hold, redefines the embedded q001.
+	store := newMemoryStore()
+	dup := newAdminQuestion("q001")
+	data, _ := json.Marshal(overlayEntry{Question: &dup})
+	store.Set(overlayNamespace, "q950", data)
+	_, err := embeddedOverlay(t, store)
+	if err == nil {
+		t.Fatal("an overlay redefining an embedded question was accepted")
+	}
+	for _, want := range []string{questionsFile, overlaySource + ": q950 (q001)", "duplicate id"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not name %q", err, want)
+		}
+	}
+
+	o, err := embeddedOverlay(t, newMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra := newAdminQuestion("q951")
+	problems := o.Check(map[string]overlayEntry{"q950": {Question: &extra}, "q951": {Question: &extra}})
+	if msg := problemMessages(problems); len(problems) != 1 || !strings.Contains(msg, "also defined by "+overlaySource+" q950 (q951)") {
+		t.Errorf("two edits defining q951: %s", msg)
+	}
+	// Replacing an embedded question under its own ID is no conflict.
+	edit := newAdminQuestion("q001")
+	if problems := o.Check(map[string]overlayEntry{"q001": {Question: &edit}}); len(problems) > 0 {
+		t.Errorf("editing q001 in place: %s", problemMessages(problems))
+	}
+	if problems, err := o.Create(newAdminQuestion("q952")); err != nil || len(problems) > 0 {
+		t.Errorf("creating a new question: %v %s", err, problemMessages(problems))
+	}
+}
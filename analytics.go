@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// maxEventsBody bounds a batch of analytics events, and maxEventBatch
+	// how many events it may hold.
+	maxEventsBody = 64 << 10
+	maxEventBatch = 100
+	// maxEventProperties bounds the properties of one event, and
+	// maxEventValue the length of a string property.
+	maxEventProperties = 20
+	maxEventValue      = 256
+	// eventMaxAge is how old an event may be when it arrives, and
+	// eventMaxSkew how far in the future, allowing for client clocks.
+	eventMaxAge  = 24 * time.Hour
+	eventMaxSkew = 5 * time.Minute
+	// eventsRate and eventsBurst bound how fast one session may send
+	// batches.
+	eventsRate  = 0.5
+	eventsBurst = 10
+	// eventsFile is the file the file sink appends to in the store
+	// directory when no other is given.
+	eventsFile = "events.jsonl"
+)
+
+// analyticsEventTypes are the funnel steps clients may report.
+var analyticsEventTypes = map[string]bool{
+	"game-started":      true,
+	"room-entered":      true,
+	"question-answered": true,
+	"game-completed":    true,
+}
+
+// clientEvent is one event of a batch sent to /api/events. Properties
+// hold strings, numbers, and booleans.
+type clientEvent struct {
+	Type       string         `json:"type"`
+	Time       time.Time      `json:"time"`
+	Properties map[string]any `json:"properties,omitempty"`
+}
+
+// analyticsEvent is a clientEvent as recorded, with the session that sent
+// it and when it arrived.
+type analyticsEvent struct {
+	clientEvent
+	Session    string    `json:"session"`
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+// eventSink is where accepted analytics events go.
+type eventSink interface {
+	Write(events []analyticsEvent) error
+}
+
+// openEventSink returns the sink selected by kind: "file", appending JSON
+// lines to path, "log", writing each event to the server log, or "off",
+// which discards them.
+func openEventSink(kind, path string) (eventSink, error) {
+	switch kind {
+	case "file":
+		return newFileSink(path)
+	case "log":
+		return logSink{}, nil
+	case "off":
+		return discardSink{}, nil
+	default:
+		return nil, fmt.Errorf("unknown events sink %q", kind)
+	}
+}
+
+// fileSink appends events to a file, one JSON object per line.
+type fileSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// newFileSink opens path for appending, creating it and its directory if
+// needed.
+func newFileSink(path string) (*fileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{f: f}, nil
+}
+
+// Write appends events in a single write, so that batches written at the
+// same time never interleave.
+func (s *fileSink) Write(events []analyticsEvent) error {
+	var buf []byte
+	for _, ev := range events {
+		line, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.f.Write(buf)
+	return err
+}
+
+// Close closes the file.
+func (s *fileSink) Close() error {
+	return s.f.Close()
+}
+
+// logSink writes events to the server log.
+type logSink struct{}
+
+func (logSink) Write(events []analyticsEvent) error {
+	for _, ev := range events {
+		slog.Info("analytics event", "type", ev.Type, "time", ev.Time, "session", ev.Session, "properties", ev.Properties)
+	}
+	return nil
+}
+
+// discardSink drops events.
+type discardSink struct{}
+
+func (discardSink) Write([]analyticsEvent) error { return nil }
+
+// eventError reports why the event at Index of a batch was dropped.
+type eventError struct {
+	Index   int    `json:"index"`
+	Message string `json:"message"`
+}
+
+// checkEvent returns why ev, arriving at now, cannot be recorded, or ""
+// if it can.
+func checkEvent(ev clientEvent, now time.Time) string {
+	switch {
+	case !analyticsEventTypes[ev.Type]:
+		return fmt.Sprintf("unknown event type %q", ev.Type)
+	case ev.Time.IsZero():
+		return "missing time"
+	case ev.Time.Before(now.Add(-eventMaxAge)) || ev.Time.After(now.Add(eventMaxSkew)):
+		return "time is too far from the present"
+	case len(ev.Properties) > maxEventProperties:
+		return fmt.Sprintf("at most %d properties allowed", maxEventProperties)
+	}
+	for k, v := range ev.Properties {
+		switch v := v.(type) {
+		case string:
+			if len(v) > maxEventValue {
+				return fmt.Sprintf("property %q must be at most %d bytes", k, maxEventValue)
+			}
+		case float64, bool:
+		default:
+			return fmt.Sprintf("property %q must be a string, number, or boolean", k)
+		}
+	}
+	return ""
+}
+
+// eventsHandler serves POST /api/events?session=S with a batch of
+// {"events": [...]} from a game session, recording each valid event in
+// sink. Invalid events are dropped and reported by index, without
+// failing the rest of the batch. Each session's batches are rate-limited
+// by limiter.
+func eventsHandler(sink eventSink, limiter *rateLimiter, sess *sessions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		sessionID, err := sess.fromQuery(r)
+		if err != nil {
+			writeAPIError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if sessionID == "" {
+			writeAPIError(w, http.StatusBadRequest, "a session token is required")
+			return
+		}
+		if ok, retryAfter := limiter.reserve(sessionID); !ok {
+			writeRetryAfter(w, http.StatusTooManyRequests, "too many event batches", retryAfter)
+			return
+		}
+		var batch struct {
+			Events []clientEvent `json:"events"`
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxEventsBody)
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			writeBodyError(w, err, "invalid JSON body")
+			return
+		}
+		if len(batch.Events) == 0 || len(batch.Events) > maxEventBatch {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("a batch must hold between 1 and %d events", maxEventBatch))
+			return
+		}
+		now := time.Now().UTC()
+		accepted := make([]analyticsEvent, 0, len(batch.Events))
+		errs := []eventError{}
+		for i, ev := range batch.Events {
+			if msg := checkEvent(ev, now); msg != "" {
+				errs = append(errs, eventError{Index: i, Message: msg})
+				continue
+			}
+			ev.Time = ev.Time.UTC()
+			accepted = append(accepted, analyticsEvent{clientEvent: ev, Session: sessionID, ReceivedAt: now})
+		}
+		if len(accepted) > 0 {
+			if err := sink.Write(accepted); err != nil {
+				slog.ErrorContext(r.Context(), "could not record analytics events", "err", err)
+				writeAPIError(w, http.StatusInternalServerError, "could not record events")
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"accepted": len(accepted), "errors": errs})
+	})
+}
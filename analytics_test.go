@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postEvents posts batch to s's events endpoint in session sess and
+// decodes the response into out.
+func postEvents(t *testing.T, s *runningServer, sess sessionResponse, batch any, out any) int {
+	t.Helper()
+	return postJSON(t, s.url("/api/events?session="+url.QueryEscape(sess.Token)), batch, out)
+}
+
+// recordedEvents reads the events the file sink appended to path.
+func recordedEvents(t *testing.T, path string) []analyticsEvent {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var events []analyticsEvent
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		var ev analyticsEvent
+		if err := json.Unmarshal(lines.Bytes(), &ev); err != nil {
+			t.Fatalf("line %q: %v", lines.Text(), err)
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+type eventsResponse struct {
+	Accepted int          `json:"accepted"`
+	Errors   []eventError `json:"errors"`
+}
+
+func TestEventsRecorded(t *testing.T) {
+	s := startServer(t)
+	sess := startSession(t, s)
+	now := time.Now().UTC().Truncate(time.Second)
+	batch := map[string]any{"events": []clientEvent{
+		{Type: "game-started", Time: now.Add(-time.Minute)},
+		{Type: "room-entered", Time: now, Properties: map[string]any{"room": "library", "visits": 2.0, "first": true}},
+	}}
+	var resp eventsResponse
+	if code := postEvents(t, s, sess, batch, &resp); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if resp.Accepted != 2 || len(resp.Errors) != 0 {
+		t.Errorf("response %+v, want both events accepted", resp)
+	}
+	events := recordedEvents(t, filepath.Join("store", eventsFile))
+	if len(events) != 2 {
+		t.Fatalf("recorded %d events, want 2", len(events))
+	}
+	for _, ev := range events {
+		if ev.Session != sess.SessionID || ev.ReceivedAt.IsZero() {
+			t.Errorf("event %+v lacks its session or arrival time", ev)
+		}
+	}
+	if ev := events[1]; ev.Type != "room-entered" || !ev.Time.Equal(now) || ev.Properties["room"] != "library" {
+		t.Errorf("second event recorded as %+v", ev)
+	}
+}
+
+func TestEventsDropsInvalidOnes(t *testing.T) {
+	s := startServer(t)
+	now := time.Now()
+	batch := map[string]any{"events": []map[string]any{
+		{"type": "game-completed", "time": now},
+		{"type": "cheated", "time": now},
+		{"type": "game-started"},
+		{"type": "game-started", "time": now.Add(-48 * time.Hour)},
+		{"type": "game-started", "time": now.Add(time.Hour)},
+		{"type": "room-entered", "time": now, "properties": map[string]any{"room": map[string]any{"id": 1}}},
+		{"type": "room-entered", "time": now, "properties": map[string]any{"room": strings.Repeat("x", maxEventValue+1)}},
+	}}
+	var resp eventsResponse
+	if code := postEvents(t, s, startSession(t, s), batch, &resp); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	want := map[int]string{1: "unknown event type", 2: "missing time", 3: "too far", 4: "too far", 5: "string, number, or boolean", 6: "at most"}
+	if resp.Accepted != 1 || len(resp.Errors) != len(want) {
+		t.Fatalf("response %+v, want 1 accepted and %d errors", resp, len(want))
+	}
+	for _, e := range resp.Errors {
+		if !strings.Contains(e.Message, want[e.Index]) {
+			t.Errorf("event %d dropped with %q, want %q", e.Index, e.Message, want[e.Index])
+		}
+	}
+	if events := recordedEvents(t, filepath.Join("store", eventsFile)); len(events) != 1 || events[0].Type != "game-completed" {
+		t.Errorf("recorded %+v, want the valid event alone", events)
+	}
+}
+
+func TestEventsRejectsBadBatches(t *testing.T) {
+	s := startServer(t)
+	sess := startSession(t, s)
+	ev := clientEvent{Type: "game-started", Time: time.Now()}
+	many := make([]clientEvent, maxEventBatch+1)
+	for i := range many {
+		many[i] = ev
+	}
+	huge := clientEvent{Type: "game-started", Time: time.Now(), Properties: map[string]any{}}
+	for i := range maxEventProperties {
+		huge.Properties[strings.Repeat("k", 10)+string(rune('a'+i))] = strings.Repeat("v", maxEventsBody/maxEventProperties)
+	}
+	for _, tt := range []struct {
+		name  string
+		batch any
+		want  int
+	}{
+		{"empty", map[string]any{"events": []clientEvent{}}, http.StatusBadRequest},
+		{"too many", map[string]any{"events": many}, http.StatusBadRequest},
+		{"oversized", map[string]any{"events": []clientEvent{huge}}, http.StatusRequestEntityTooLarge},
+		{"malformed", "not a batch", http.StatusBadRequest},
+	} {
+		if code := postEvents(t, s, sess, tt.batch, nil); code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, code, tt.want)
+		}
+	}
+	batch := map[string]any{"events": []clientEvent{ev}}
+	if code := postJSON(t, s.url("/api/events"), batch, nil); code != http.StatusBadRequest {
+		t.Errorf("no session: status %d, want 400", code)
+	}
+	if code := postEvents(t, s, sessionResponse{Token: sess.Token + "x"}, batch, nil); code != http.StatusForbidden {
+		t.Errorf("forged session: status %d, want 403", code)
+	}
+	if code, _ := get(t, s.url("/api/events")); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want 405", code)
+	}
+	if _, err := os.Stat(filepath.Join("store", eventsFile)); err == nil {
+		if events := recordedEvents(t, filepath.Join("store", eventsFile)); len(events) > 0 {
+			t.Errorf("rejected batches recorded %d events", len(events))
+		}
+	}
+}
+
+func TestEventsRateLimitedPerSession(t *testing.T) {
+	s := startServer(t, "-rate-limit", "1000", "-rate-burst", "1000")
+	sess := startSession(t, s)
+	batch := map[string]any{"events": []clientEvent{{Type: "game-started", Time: time.Now()}}}
+	limited := false
+	for range eventsBurst + 1 {
+		if code := postEvents(t, s, sess, batch, nil); code == http.StatusTooManyRequests {
+			limited = true
+		}
+	}
+	if !limited {
+		t.Error("a session's batches were never limited")
+	}
+	if code := postEvents(t, s, startSession(t, s), batch, nil); code != http.StatusOK {
+		t.Errorf("another session: status %d, want 200", code)
+	}
+}
+
+func TestEventSinks(t *testing.T) {
+	inTempDir(t)
+	logs := recordLogs(t)
+	sink, err := openEventSink("log", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Write([]analyticsEvent{{clientEvent: clientEvent{Type: "game-started"}, Session: "s1"}})
+	if got, _ := logs.attr("analytics event", "session"); got.String() != "s1" {
+		t.Errorf("log sink logged session %q, want s1", got)
+	}
+	if _, err := openEventSink("kafka", ""); err == nil {
+		t.Error("an unknown sink opened")
+	}
+	if _, err := loadConfig([]string{"-events-sink", "kafka"}, noEnv); err == nil || !strings.Contains(err.Error(), "events-sink") {
+		t.Errorf("-events-sink kafka: %v", err)
+	}
+
+	path := filepath.Join("events", "out.jsonl")
+	file, err := openEventSink("file", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.(*fileSink).Close()
+	file.Write([]analyticsEvent{{clientEvent: clientEvent{Type: "a"}}, {clientEvent: clientEvent{Type: "b"}}})
+	file.Write([]analyticsEvent{{clientEvent: clientEvent{Type: "c"}}})
+	data, _ := os.ReadFile(path)
+	if lines := bytes.Count(data, []byte("\n")); lines != 3 {
+		t.Errorf("file sink wrote %d lines, want 3", lines)
+	}
+}
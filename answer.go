@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	// answerAttemptRate and answerAttemptBurst limit how often one client
+	// may try to answer the same question: a few quick retries, then one
+	// every ten seconds.
+	answerAttemptRate  = 0.1
+	answerAttemptBurst = 3
+	// maxAnswerBody bounds an answer submission body.
+	maxAnswerBody = 1 << 10
+)
+
+// answerRequest is the body of POST /api/answer. Quality optionally
+// grades how well the player recalled the answer, from 0 (blackout) to 5
+// (perfect), for review scheduling.
+type answerRequest struct {
+	QuestionID  string `json:"questionID"`
+	ChoiceIndex int    `json:"choiceIndex"`
+	Quality     *int   `json:"quality,omitempty"`
+}
+
+// answerResponse tells the player whether they were right. Only the
+// explanation is revealed, never the correct index. NextReview is when
+// the question is next due for review, for graded answers, and Unlocked
+// lists the IDs of achievements the answer unlocked. Score is the
+// session's running score after the answer, for answers in a session.
+// Under an attempt limit, AttemptsLeft counts the wrong answers a session
+// may still give to the question, and Locked is set once it may give no
+// more; the explanation is withheld until the question is answered
+// correctly, or locked with RevealLocked set. TimedOut is set when the
+// answer came after the question's time limit, under enforced limits, and
+// so counts as wrong.
+type answerResponse struct {
+	Correct      bool           `json:"correct"`
+	TimedOut     bool           `json:"timedOut,omitempty"`
+	Locked       bool           `json:"locked,omitempty"`
+	AttemptsLeft int            `json:"attemptsLeft,omitempty"`
+	Explanation  string         `json:"explanation"`
+	NextReview   *time.Time     `json:"nextReview,omitempty"`
+	Unlocked     []string       `json:"unlocked,omitempty"`
+	Score        *scoreSnapshot `json:"score,omitempty"`
+}
+
+// answerer grades answers against the answer key kept server-side and
+// records them with the services that follow a player's progress. It
+// backs both /api/answer and the GraphQL answer mutation.
+type answerer struct {
+	attempts *rateLimiter
+	history  *histories
+	rv       *reviews
+	perf     *performances
+	tracker  *achievementTracker
+	rp       *replays
+	stats    *answerStats
+	exps     *experiments
+	scores   *scorekeeper
+	sess     *sessions
+}
+
+func newAnswerer(attempts *rateLimiter, history *histories, rv *reviews, perf *performances, tracker *achievementTracker, rp *replays, stats *answerStats, exps *experiments, scores *scorekeeper, sess *sessions) *answerer {
+	return &answerer{attempts: attempts, history: history, rv: rv, perf: perf, tracker: tracker, rp: rp, stats: stats, exps: exps, scores: scores, sess: sess}
+}
+
+// answerError is an answer Grade refuses, with the status to report it
+// with. RetryAfter is set when the attempt was rate-limited.
+type answerError struct {
+	Status     int
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *answerError) Error() string { return e.Message }
+
+// Grade grades req, sent by the client of r, against bank. With a session
+// ID, choiceIndex is a position in that session's shuffled answers.
+// Repeated attempts at the same question from one client are
+// rate-limited. With a player token, the question is added to the
+// player's history and counts toward achievements, and a quality grade
+// reschedules its review. Answers in a session are scored into its
+// running score, which the response carries, and added to its replay and
+// to the accuracy that sets its difficulty; under enforced time limits,
+// one that comes too late counts as wrong. Every answer is added to
+// the question's statistics, timed from when the session was served the
+// question, and, for a question in an experiment, graded as the session's
+// bucket words it and added to that bucket's results. A request that
+// cannot be graded fails with an *answerError. A session locked out of
+// the question is not graded at all: the response only says so.
+func (a *answerer) Grade(r *http.Request, bank *questionBank, token, sessionID string, req answerRequest) (answerResponse, error) {
+	q, ok := bank.Get(req.QuestionID)
+	if !ok {
+		return answerResponse{}, &answerError{Status: http.StatusNotFound, Message: "unknown question"}
+	}
+	worded := a.exps.Apply(bank, *q, sessionID)
+	q = &worded
+	if req.ChoiceIndex < 0 || req.ChoiceIndex >= len(q.Answers) {
+		return answerResponse{}, &answerError{Status: http.StatusBadRequest, Message: "choiceIndex out of range"}
+	}
+	choice := req.ChoiceIndex
+	if sessionID != "" {
+		choice = a.sess.answerOrder(sessionID, q.ID, len(q.Answers))[choice]
+	}
+	if req.Quality != nil {
+		if token == "" {
+			return answerResponse{}, &answerError{Status: http.StatusBadRequest, Message: "quality requires a token"}
+		}
+		if *req.Quality < 0 || *req.Quality > maxQuality {
+			return answerResponse{}, &answerError{Status: http.StatusBadRequest, Message: fmt.Sprintf("quality must be between 0 and %d", maxQuality)}
+		}
+	}
+	if sessionID != "" {
+		if locked, err := a.scores.Locked(sessionID, q.ID); err != nil {
+			return answerResponse{}, &answerError{Status: http.StatusInternalServerError, Message: "could not load score"}
+		} else if locked {
+			return a.locked(sessionID, q)
+		}
+	}
+	if ok, retryAfter := a.attempts.reserve(clientIP(r, a.attempts.proxies) + "|" + q.ID); !ok {
+		return answerResponse{}, &answerError{Status: http.StatusTooManyRequests, Message: "too many attempts at this question", RetryAfter: retryAfter}
+	}
+	resp := answerResponse{
+		Correct:     choice == q.CorrectAnswer,
+		Explanation: q.Explanation,
+	}
+	now := time.Now()
+	var elapsed time.Duration
+	if sessionID != "" {
+		served, ok, err := a.scores.Served(sessionID, q.ID)
+		if err != nil {
+			return answerResponse{}, &answerError{Status: http.StatusInternalServerError, Message: "could not load score"}
+		}
+		if ok {
+			elapsed = now.Sub(served)
+		}
+		if a.scores.TimedOut(q, elapsed, ok) {
+			resp.Correct, resp.TimedOut = false, true
+		}
+	}
+	if err := a.stats.Record(q.ID, resp.Correct, elapsed); err != nil {
+		slog.WarnContext(r.Context(), "could not record question statistics", "err", err)
+	}
+	if err := a.exps.Record(bank, *q, sessionID, resp.Correct, elapsed); err != nil {
+		slog.WarnContext(r.Context(), "could not record experiment results", "err", err)
+	}
+	if token != "" {
+		if err := a.history.Record(token, q.ID, now); err != nil {
+			// The answer is still graded; only repeat avoidance suffers.
+			slog.WarnContext(r.Context(), "could not record question history", "err", err)
+		}
+		var err error
+		if resp.Unlocked, err = a.tracker.Record(token, resp.Correct, now); err != nil {
+			slog.WarnContext(r.Context(), "could not update achievement progress", "err", err)
+		}
+	}
+	if sessionID != "" {
+		out, err := a.scores.Record(sessionID, q, resp.Correct, elapsed, now)
+		if errors.Is(err, errQuestionLocked) {
+			return a.locked(sessionID, q)
+		}
+		if err != nil {
+			return answerResponse{}, &answerError{Status: http.StatusInternalServerError, Message: "could not record score"}
+		}
+		earned := out.Earned
+		resp.Locked, resp.AttemptsLeft = out.Locked, out.AttemptsLeft
+		if !resp.Correct && !resp.TimedOut && a.scores.rules.MaxAttempts > 0 && !(out.Locked && a.scores.rules.RevealLocked) {
+			resp.Explanation = ""
+		}
+		snap, err := a.scores.Snapshot(sessionID, now)
+		if err != nil {
+			return answerResponse{}, &answerError{Status: http.StatusInternalServerError, Message: "could not load score"}
+		}
+		resp.Score = &snap
+		a.rp.record(sessionID, replayEvent{Type: replayAnswer, Question: q.ID, Choice: &choice, Correct: &resp.Correct, Delta: earned}, now)
+		if err := a.perf.Record(sessionID, resp.Correct, now); err != nil {
+			slog.WarnContext(r.Context(), "could not record session performance", "err", err)
+		}
+	}
+	if req.Quality != nil {
+		if card, err := a.rv.Grade(token, q.ID, *req.Quality, now); err != nil {
+			slog.WarnContext(r.Context(), "could not update review schedule", "err", err)
+		} else {
+			resp.NextReview = &card.Due
+		}
+	}
+	return resp, nil
+}
+
+// locked is the response to an answer to q that session id is locked out
+// of, which earns nothing.
+func (a *answerer) locked(id string, q *Question) (answerResponse, error) {
+	snap, err := a.scores.Snapshot(id, time.Now())
+	if err != nil {
+		return answerResponse{}, &answerError{Status: http.StatusInternalServerError, Message: "could not load score"}
+	}
+	resp := answerResponse{Locked: true, Score: &snap}
+	if a.scores.rules.RevealLocked {
+		resp.Explanation = q.Explanation
+	}
+	return resp, nil
+}
+
+// answerHandler serves POST /api/answer?token=T&session=S, grading the
+// choice with answers in the negotiated language, whose answer order may
+// differ.
+func answerHandler(banks map[string]*questionBank, langs *languageRegistry, answers *answerer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		token := r.URL.Query().Get("token")
+		if token != "" && !validToken.MatchString(token) {
+			writeAPIError(w, http.StatusBadRequest, "invalid token")
+			return
+		}
+		sessionID, err := answers.sess.fromQuery(r)
+		if err != nil {
+			writeAPIError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		var req answerRequest
+		r.Body = http.MaxBytesReader(w, r.Body, maxAnswerBody)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeBodyError(w, err, "invalid JSON body")
+			return
+		}
+		resp, err := answers.Grade(r, localize(w, r, langs, banks), token, sessionID, req)
+		var refused *answerError
+		if errors.As(err, &refused) {
+			if refused.RetryAfter > 0 {
+				writeRetryAfter(w, refused.Status, refused.Message, refused.RetryAfter)
+				return
+			}
+			writeAPIError(w, refused.Status, refused.Message)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
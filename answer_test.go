@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAnswerGrading(t *testing.T) {
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := questions[0]
+	s := startServer(t)
+
+	var resp struct {
+		Correct     bool   `json:"correct"`
+		Explanation string `json:"explanation"`
+	}
+	if code := postJSON(t, s.url("/api/answer"), map[string]any{"questionID": q.ID, "choiceIndex": q.CorrectAnswer}, &resp); code != http.StatusOK {
+		t.Fatalf("correct answer: status %d", code)
+	}
+	if !resp.Correct || resp.Explanation == "" {
+		t.Errorf("correct answer graded %+v", resp)
+	}
+
+	wrong := (q.CorrectAnswer + 1) % len(q.Answers)
+	resp.Correct = true
+	if code := postJSON(t, s.url("/api/answer"), map[string]any{"questionID": q.ID, "choiceIndex": wrong}, &resp); code != http.StatusOK {
+		t.Fatalf("wrong answer: status %d", code)
+	}
+	if resp.Correct {
+		t.Error("wrong answer graded correct")
+	}
+}
+
+func TestAnswerRejectsBadRequests(t *testing.T) {
+	s := startServer(t)
+	if code := postJSON(t, s.url("/api/answer"), map[string]any{"questionID": "no-such-question", "choiceIndex": 0}, nil); code != http.StatusNotFound {
+		t.Errorf("unknown question: status %d, want 404", code)
+	}
+	questions, _ := loadQuestions(staticFS, questionsFile)
+	if code := postJSON(t, s.url("/api/answer"), map[string]any{"questionID": questions[0].ID, "choiceIndex": 99}, nil); code != http.StatusBadRequest {
+		t.Errorf("choice out of range: status %d, want 400", code)
+	}
+}
+
+func TestAnswerAttemptsRateLimited(t *testing.T) {
+	questions, _ := loadQuestions(staticFS, questionsFile)
+	s := startServer(t)
+	limited := false
+	for i := 0; i < answerAttemptBurst+2; i++ {
+		if code := postJSON(t, s.url("/api/answer"), map[string]any{"questionID": questions[1].ID, "choiceIndex": 0}, nil); code == http.StatusTooManyRequests {
+			limited = true
+		}
+	}
+	if !limited {
+		t.Error("repeated attempts at one question were never limited")
+	}
+	// Another question has an allowance of its own.
+	if code := postJSON(t, s.url("/api/answer"), map[string]any{"questionID": questions[2].ID, "choiceIndex": 0}, nil); code != http.StatusOK {
+		t.Errorf("a different question: status %d, want 200", code)
+	}
+}
+
+func TestQuestionsNeverLeakAnswers(t *testing.T) {
+	s := startServer(t)
+	for _, query := range []string{"count=50", "count=50&difficulty=hard", "count=5&seed=1"} {
+		_, body := get(t, s.url("/api/questions?"+query))
+		for _, key := range []string{"correctAnswer", "explanation"} {
+			if strings.Contains(body, `"`+key+`"`) {
+				t.Errorf("/api/questions?%s exposes %s", query, key)
+			}
+		}
+	}
+}
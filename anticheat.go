@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// quarantineNamespace holds the leaderboard scores held back for
+	// review, keyed by a random ID.
+	quarantineNamespace = "quarantined-scores"
+	// cheatMinAnswers is how many answers a run needs before its pace is
+	// judged; shorter runs are too little to go on.
+	cheatMinAnswers = 3
+	// maxQuarantineBody bounds a resolve request.
+	maxQuarantineBody = 1 << 10
+)
+
+// AntiCheatLimits are the thresholds leaderboard submissions are held
+// to. A zero value switches its check off.
+type AntiCheatLimits struct {
+	// MinAnswerTime is the least median time a run may take to answer a
+	// question, from when the server served it.
+	MinAnswerTime time.Duration
+	// FastAccuracy and FastAnswerTime flag a run at least FastAccuracy
+	// correct (0 to 1) whose median answer time is under FastAnswerTime:
+	// plausible alone, but not together.
+	FastAccuracy   float64
+	FastAnswerTime time.Duration
+	// MaxSubmissions is how many scores one IP address may submit an hour.
+	MaxSubmissions int
+}
+
+// quarantinedScore is a score held back by the anti-cheat checks. Boards
+// are the keys of the leaderboards it goes on if approved.
+type quarantinedScore struct {
+	Entry         LeaderboardEntry `json:"entry"`
+	Boards        []string         `json:"boards"`
+	Reason        string           `json:"reason"`
+	QuarantinedAt time.Time        `json:"quarantinedAt"`
+}
+
+// quarantineItem is a quarantinedScore as listed for review.
+type quarantineItem struct {
+	ID string `json:"id"`
+	quarantinedScore
+}
+
+// scoreGuard screens leaderboard submissions. Each IP address is held to
+// limits.MaxSubmissions an hour, and the pace of each run, as recorded in
+// its replay, to the other limits; a run that fails is quarantined for an
+// admin to approve or reject instead of being published.
+type scoreGuard struct {
+	limits      AntiCheatLimits
+	rp          *replays
+	store       Store
+	proxies     trustedProxies
+	submissions *rateLimiter // nil without a cap
+	// boards returns the leaderboard stored under a key, for publishing
+	// approved scores.
+	boards func(key string) (*leaderboard, error)
+
+	mu sync.Mutex // serializes Resolve
+}
+
+func newScoreGuard(limits AntiCheatLimits, rp *replays, store Store, proxies trustedProxies, boards func(string) (*leaderboard, error)) *scoreGuard {
+	g := &scoreGuard{limits: limits, rp: rp, store: store, proxies: proxies, boards: boards}
+	if limits.MaxSubmissions > 0 {
+		g.submissions = newRateLimiter(float64(limits.MaxSubmissions)/time.Hour.Seconds(), limits.MaxSubmissions, proxies)
+		g.submissions.idle = time.Hour
+	}
+	return g
+}
+
+// Allow takes one of the submissions the client of r has left this hour,
+// returning how long it must wait if none is.
+func (g *scoreGuard) Allow(r *http.Request) (ok bool, retryAfter time.Duration) {
+	if g.submissions == nil {
+		return true, 0
+	}
+	return g.submissions.reserve(clientIP(r, g.proxies))
+}
+
+// runPace is how a run answered: how many questions, how many of them
+// correctly, and the median time taken from being served a question to
+// answering it.
+type runPace struct {
+	Answers int
+	Correct int
+	Median  time.Duration
+}
+
+// paceOf measures the answers in rec.
+func paceOf(rec replay) runPace {
+	var p runPace
+	served := make(map[string]int64)
+	var times []int64
+	for _, ev := range rec.Events {
+		switch ev.Type {
+		case replayQuestion:
+			served[ev.Question] = ev.T
+		case replayAnswer:
+			t, ok := served[ev.Question]
+			if !ok {
+				continue
+			}
+			p.Answers++
+			if ev.Correct != nil && *ev.Correct {
+				p.Correct++
+			}
+			times = append(times, ev.T-t)
+		}
+	}
+	if len(times) > 0 {
+		slices.Sort(times)
+		p.Median = time.Duration(times[len(times)/2]) * time.Millisecond
+	}
+	return p
+}
+
+// Suspect returns why the run of session id looks automated, or "" if it
+// does not. A run without a recording, or with too few answers, is given
+// the benefit of the doubt.
+func (g *scoreGuard) Suspect(id string) string {
+	rec, err := g.rp.Load(id)
+	if err != nil {
+		return ""
+	}
+	p := paceOf(rec)
+	if p.Answers < cheatMinAnswers {
+		return ""
+	}
+	if p.Median < g.limits.MinAnswerTime {
+		return fmt.Sprintf("median answer time %s is under the minimum %s", p.Median, g.limits.MinAnswerTime)
+	}
+	accuracy := float64(p.Correct) / float64(p.Answers)
+	if g.limits.FastAccuracy > 0 && accuracy >= g.limits.FastAccuracy && p.Median < g.limits.FastAnswerTime {
+		return fmt.Sprintf("%d of %d correct at a median answer time of %s", p.Correct, p.Answers, p.Median)
+	}
+	return ""
+}
+
+// Quarantine holds e back from the leaderboards stored under boards for
+// the stated reason.
+func (g *scoreGuard) Quarantine(e LeaderboardEntry, boards []string, reason string) error {
+	data, err := json.Marshal(quarantinedScore{Entry: e, Boards: boards, Reason: reason, QuarantinedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	return g.store.Set(quarantineNamespace, randomID(8), data)
+}
+
+func (g *scoreGuard) load(id string) (quarantinedScore, error) {
+	var q quarantinedScore
+	data, err := g.store.Get(quarantineNamespace, id)
+	if err != nil {
+		return q, err
+	}
+	if err := json.Unmarshal(data, &q); err != nil {
+		return q, fmt.Errorf("quarantined score %s: %w", id, err)
+	}
+	return q, nil
+}
+
+// Quarantined lists the scores awaiting review, oldest first.
+func (g *scoreGuard) Quarantined() ([]quarantineItem, error) {
+	ids, err := g.store.List(quarantineNamespace)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]quarantineItem, 0, len(ids))
+	for _, id := range ids {
+		q, err := g.load(id)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, quarantineItem{ID: id, quarantinedScore: q})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].QuarantinedAt.Before(items[j].QuarantinedAt) })
+	return items, nil
+}
+
+// Resolve approves the quarantined score id, adding it to its
+// leaderboards, or rejects it. Either way it leaves the quarantine.
+func (g *scoreGuard) Resolve(id, action string) error {
+	if action != "approve" && action != "reject" {
+		return fmt.Errorf("%w %q: scores take approve, reject", errBadAction, action)
+	}
+	if !validStoreName.MatchString(id) {
+		return errNoItem
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	q, err := g.load(id)
+	if errors.Is(err, ErrNotFound) {
+		return errNoItem
+	}
+	if err != nil {
+		return err
+	}
+	if action == "approve" {
+		for _, key := range q.Boards {
+			lb, err := g.boards(key)
+			if err != nil {
+				return err
+			}
+			if _, err := lb.Add(q.Entry); err != nil {
+				return err
+			}
+		}
+	}
+	return g.store.Delete(quarantineNamespace, id)
+}
+
+// adminQuarantineHandler serves GET /api/admin/quarantine, the scores held
+// back for review, and POST /api/admin/quarantine/{id} with {"action": A},
+// "approve" or "reject", to resolve one.
+func adminQuarantineHandler(g *scoreGuard) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		switch {
+		case id == "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+			items, err := g.Quarantined()
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, "could not load quarantined scores")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"scores": items})
+		case id != "" && r.Method == http.MethodPost:
+			var req resolveRequest
+			r.Body = http.MaxBytesReader(w, r.Body, maxQuarantineBody)
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeBodyError(w, err, "invalid JSON body")
+				return
+			}
+			err := g.Resolve(id, req.Action)
+			switch {
+			case errors.Is(err, errNoItem):
+				writeAPIError(w, http.StatusNotFound, "no such quarantined score")
+				return
+			case errors.Is(err, errBadAction):
+				writeAPIError(w, http.StatusBadRequest, err.Error())
+				return
+			case err != nil:
+				writeAPIError(w, http.StatusInternalServerError, "could not resolve quarantined score")
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			if id == "" {
+				w.Header().Set("Allow", "GET, HEAD")
+			} else {
+				w.Header().Set("Allow", "POST")
+			}
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordRun records in rp a run of session id answering len(correct)
+// questions, each answerTime after it was served, correct[i] telling
+// whether the ith was right.
+func recordRun(t *testing.T, rp *replays, id string, answerTime time.Duration, correct ...bool) {
+	t.Helper()
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, ok := range correct {
+		q := "q" + string(rune('a'+i))
+		if err := rp.Record(id, replayEvent{Type: replayQuestion, Question: q}, now); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(answerTime)
+		choice := 0
+		if err := rp.Record(id, replayEvent{Type: replayAnswer, Question: q, Choice: &choice, Correct: &ok}, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestScoreGuardSuspect(t *testing.T) {
+	store := newMemoryStore()
+	rp, err := newReplays(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := newScoreGuard(defaultConfig().AntiCheat, rp, store, nil, nil)
+	recordRun(t, rp, "legit", 4*time.Second, true, false, true, true)
+	recordRun(t, rp, "fast", 100*time.Millisecond, true, false, true)
+	recordRun(t, rp, "perfect", time.Second, true, true, true, true)
+	recordRun(t, rp, "brief", 10*time.Millisecond, true, true)
+	tests := []struct {
+		id, want string
+	}{
+		{"legit", ""},
+		{"fast", "under the minimum"},
+		{"perfect", "4 of 4 correct"},
+		{"brief", ""},
+		{"unrecorded", ""},
+	}
+	for _, tt := range tests {
+		got := g.Suspect(tt.id)
+		if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+			t.Errorf("%s run: Suspect = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+
+	off := newScoreGuard(AntiCheatLimits{}, rp, store, nil, nil)
+	for _, id := range []string{"fast", "perfect"} {
+		if reason := off.Suspect(id); reason != "" {
+			t.Errorf("checks off: %s run suspected: %s", id, reason)
+		}
+	}
+}
+
+func TestScoreGuardSubmissionCap(t *testing.T) {
+	g := newScoreGuard(AntiCheatLimits{MaxSubmissions: 2}, nil, newMemoryStore(), nil, nil)
+	r := httptest.NewRequest(http.MethodPost, "/api/leaderboard", nil)
+	for i := range 2 {
+		if ok, _ := g.Allow(r); !ok {
+			t.Fatalf("submission %d refused", i+1)
+		}
+	}
+	if ok, retry := g.Allow(r); ok || retry <= 0 {
+		t.Errorf("third submission within the hour: ok %v, retry after %s", ok, retry)
+	}
+	other := httptest.NewRequest(http.MethodPost, "/api/leaderboard", nil)
+	other.RemoteAddr = "198.51.100.9:1234"
+	if ok, _ := g.Allow(other); !ok {
+		t.Error("another address shared the cap")
+	}
+}
+
+// quarantined fetches the scores awaiting review.
+func quarantined(t *testing.T, s *runningServer) []quarantineItem {
+	t.Helper()
+	code, data := adminDo(t, s, http.MethodGet, "/api/admin/quarantine", nil)
+	if code != http.StatusOK {
+		t.Fatalf("/api/admin/quarantine: status %d", code)
+	}
+	var body struct {
+		Scores []quarantineItem `json:"scores"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Scores
+}
+
+func TestFastRunQuarantined(t *testing.T) {
+	s := startServer(t, "-min-run-time", "0s", "-admin-password", testAdminPassword, "-rate-limit", "1000", "-rate-burst", "1000", "-cheat-min-answer-time", "1h")
+	sess := startSession(t, s)
+	for id := range shownQuestions(t, s, sess) {
+		postJSON(t, s.url("/api/answer?session="+url.QueryEscape(sess.Token)), map[string]any{"questionID": id, "choiceIndex": 0}, nil)
+	}
+	if code := submitScore(t, s, sess, "speedy", 0, 1000); code != http.StatusAccepted {
+		t.Fatalf("fast run: status %d, want 202", code)
+	}
+	if names := boardNames(t, s, "/api/leaderboard"); len(names) != 0 {
+		t.Errorf("quarantined score published: %v", names)
+	}
+	if _, ok := s.logs.attr("leaderboard score quarantined", "reason"); !ok {
+		t.Error("the quarantine was not logged with its reason")
+	}
+	items := quarantined(t, s)
+	if len(items) != 1 || items[0].Entry.Name != "speedy" || !strings.Contains(items[0].Reason, "minimum") {
+		t.Fatalf("quarantine %+v, want speedy's run with its reason", items)
+	}
+
+	if code, _ := adminDo(t, s, http.MethodPost, "/api/admin/quarantine/"+items[0].ID, []byte(`{"action": "approve"}`)); code != http.StatusNoContent {
+		t.Fatalf("approve: status %d", code)
+	}
+	if names := boardNames(t, s, "/api/leaderboard"); len(names) != 1 || names[0] != "speedy" {
+		t.Errorf("board after approving = %v, want [speedy]", names)
+	}
+	if items := quarantined(t, s); len(items) != 0 {
+		t.Errorf("quarantine after approving %+v, want it empty", items)
+	}
+}
+
+func TestLegitimateRunPublished(t *testing.T) {
+	s := startServer(t, "-min-run-time", "0s", "-admin-password", testAdminPassword, "-rate-limit", "1000", "-rate-burst", "1000", "-cheat-min-answer-time", "0s", "-cheat-fast-accuracy", "0")
+	sess := startSession(t, s)
+	for id := range shownQuestions(t, s, sess) {
+		postJSON(t, s.url("/api/answer?session="+url.QueryEscape(sess.Token)), map[string]any{"questionID": id, "choiceIndex": 0}, nil)
+	}
+	if code := submitScore(t, s, sess, "ada", 0, 1000); code != http.StatusCreated {
+		t.Fatalf("run within the limits: status %d, want 201", code)
+	}
+	if items := quarantined(t, s); len(items) != 0 {
+		t.Errorf("quarantine %+v, want it empty", items)
+	}
+}
+
+func TestSubmissionCapPerIP(t *testing.T) {
+	s := startServer(t, "-min-run-time", "0s", "-cheat-max-submissions", "1")
+	submitScore(t, s, startSession(t, s), "ada", 0, 1000)
+	if code := submitScore(t, s, startSession(t, s), "ada", 0, 1000); code != http.StatusTooManyRequests {
+		t.Errorf("second submission in the hour: status %d, want 429", code)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// apiErrorCodes are the machine-readable codes of API errors, by status.
+// Clients may rely on them not changing.
+var apiErrorCodes = map[int]string{
+	http.StatusBadRequest:            "invalid_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+// apiError is the body of every API error response, under "error".
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// writeJSON sends v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAPIError sends an API error with the given status and message.
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIErrorDetails(w, status, message, nil)
+}
+
+// writeAPIErrorDetails sends an API error carrying details, such as the
+// individual problems found in a request. Statuses without a code of
+// their own get the lower-cased, underscored status text.
+func writeAPIErrorDetails(w http.ResponseWriter, status int, message string, details any) {
+	code, ok := apiErrorCodes[status]
+	if !ok {
+		code = strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	}
+	writeJSON(w, status, map[string]apiError{"error": {Code: code, Message: message, Details: details}})
+}
+
+// notModified sets tag, a strong entity tag, as the response's ETag and
+// reports whether r's If-None-Match already matches it, in which case it
+// has answered 304 Not Modified. Tags are compared weakly, since
+// compression turns them weak.
+func notModified(w http.ResponseWriter, r *http.Request, tag string) bool {
+	w.Header().Set("ETag", tag)
+	for _, t := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == tag || t == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
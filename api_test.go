@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// apiErrorOf makes a request and decodes the error envelope it answers.
+func apiErrorOf(t *testing.T, method, url, body string) (int, apiError) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s %s: Content-Type %q, want application/json", method, url, ct)
+	}
+	var envelope struct {
+		Error apiError `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		t.Errorf("%s %s: body is not an error envelope: %v", method, url, err)
+	}
+	return resp.StatusCode, envelope.Error
+}
+
+func TestAPIErrorEnvelope(t *testing.T) {
+	s := startServer(t)
+	tests := []struct {
+		method, path, body string
+		status             int
+		code               string
+	}{
+		{http.MethodGet, "/api/questions?count=abc", "", http.StatusBadRequest, "invalid_request"},
+		{http.MethodPost, "/api/answer", `{"questionID": "no-such-question", "choiceIndex": 0}`, http.StatusNotFound, "not_found"},
+		{http.MethodGet, "/api/nowhere", "", http.StatusNotFound, "not_found"},
+		{http.MethodPost, "/api/answer", `not json`, http.StatusBadRequest, "invalid_request"},
+		{http.MethodDelete, "/api/questions", "", http.StatusMethodNotAllowed, "method_not_allowed"},
+		{http.MethodPost, "/api/leaderboard", `{"name": "eve", "score": 0, "timeMs": 1000}`, http.StatusForbidden, "forbidden"},
+	}
+	for _, tt := range tests {
+		status, e := apiErrorOf(t, tt.method, s.url(tt.path), tt.body)
+		if status != tt.status || e.Code != tt.code || e.Message == "" {
+			t.Errorf("%s %s: status %d, error %+v; want %d with code %s and a message", tt.method, tt.path, status, e, tt.status, tt.code)
+		}
+	}
+
+	resp, err := http.Get(s.url("/css/missing.css"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound || resp.Header.Get("Content-Type") == "application/json" {
+		t.Errorf("missing static file: status %d as %s, want a plain 404", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestWriteAPIErrorDetails(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeAPIErrorDetails(w, http.StatusUnprocessableEntity, "bad set", []string{"q1: no answers"})
+	var envelope map[string]apiError
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	e := envelope["error"]
+	if w.Code != http.StatusUnprocessableEntity || e.Code != "unprocessable_entity" || e.Message != "bad set" {
+		t.Errorf("status %d, error %+v", w.Code, e)
+	}
+	if details, _ := json.Marshal(e.Details); !bytes.Equal(details, []byte(`["q1: no answers"]`)) {
+		t.Errorf("details %s", details)
+	}
+
+	w = httptest.NewRecorder()
+	writeAPIError(w, http.StatusTooManyRequests, "slow down")
+	if !strings.Contains(w.Body.String(), `"code":"rate_limited"`) || strings.Contains(w.Body.String(), "details") {
+		t.Errorf("rate-limit error %s", w.Body)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// apiVersions lists the versions of the JSON API being served, oldest
+// first; the last is the current one. Each is served under /api/<version>/.
+var apiVersions = []string{"v1"}
+
+// legacyAPIVersion is the version also served at the unversioned /api/
+// paths it replaced, which are deprecated.
+const legacyAPIVersion = "v1"
+
+// apiRouter registers the routes of one API version on mux.
+type apiRouter struct {
+	mux     *http.ServeMux
+	version string
+}
+
+// Handle serves h at /api/<version><path>, and for legacyAPIVersion also
+// at /api<path> with the responses marked deprecated.
+func (a *apiRouter) Handle(path string, h http.Handler) {
+	a.mux.Handle("/api/"+a.version+path, h)
+	if a.version == legacyAPIVersion {
+		a.mux.Handle("/api"+path, deprecatedAPI(h, a.version))
+	}
+}
+
+// deprecatedAPI serves an unversioned API route with a Deprecation header
+// and a Link to the same route under version. The first request to the
+// route is logged, so operators can see which clients need updating
+// without a warning per request.
+func deprecatedAPI(next http.Handler, version string) http.Handler {
+	var warn sync.Once
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		successor := requestBasePath(r) + "/api/" + version + strings.TrimPrefix(r.URL.Path, "/api")
+		warn.Do(func() {
+			slog.WarnContext(r.Context(), "deprecated unversioned API path", "path", r.URL.Path, "successor", successor)
+		})
+		w.Header().Set("Deprecation", "true")
+		w.Header().Add("Link", "<"+successor+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiVersionHandler serves GET /api/version, reporting the current API
+// version and every version served.
+func apiVersionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"version":  apiVersions[len(apiVersions)-1],
+			"versions": apiVersions,
+		})
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestAPIRouterServesLegacyAlias(t *testing.T) {
+	logs := recordLogs(t)
+	mux := http.NewServeMux()
+	var served []string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = append(served, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	(&apiRouter{mux: mux, version: "v1"}).Handle("/widgets", h)
+	(&apiRouter{mux: mux, version: "v2"}).Handle("/gadgets", h)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	if w := serve("/api/v1/widgets"); w.Code != http.StatusNoContent || w.Header().Get("Deprecation") != "" {
+		t.Errorf("versioned path: status %d, Deprecation %q", w.Code, w.Header().Get("Deprecation"))
+	}
+	for range 2 {
+		w := serve("/api/widgets")
+		if w.Code != http.StatusNoContent || w.Header().Get("Deprecation") != "true" {
+			t.Errorf("legacy path: status %d, Deprecation %q, want the handler with Deprecation: true", w.Code, w.Header().Get("Deprecation"))
+		}
+		if got, want := w.Header().Get("Link"), `</api/v1/widgets>; rel="successor-version"`; got != want {
+			t.Errorf("legacy Link %q, want %q", got, want)
+		}
+	}
+	if len(served) != 3 {
+		t.Errorf("handler served %v, want the versioned path and the legacy one twice", served)
+	}
+	if v, ok := logs.attr("deprecated unversioned API path", "successor"); !ok || v.String() != "/api/v1/widgets" {
+		t.Errorf("deprecation warning successor %v (logged %v)", v, ok)
+	}
+	warnings := 0
+	for _, r := range logs.records {
+		if r.Message == "deprecated unversioned API path" {
+			warnings++
+		}
+	}
+	if warnings != 1 {
+		t.Errorf("%d deprecation warnings, want one per route", warnings)
+	}
+
+	if w := serve("/api/v2/gadgets"); w.Code != http.StatusNoContent {
+		t.Errorf("v2 route: status %d", w.Code)
+	}
+	if w := serve("/api/gadgets"); w.Code != http.StatusNotFound {
+		t.Errorf("v2 route without a version: status %d, want 404", w.Code)
+	}
+}
+
+func TestVersionedAndLegacyPathsAgree(t *testing.T) {
+	s := startServer(t)
+	for _, c := range []struct {
+		path       string
+		deprecated string
+	}{
+		{"/api/v1/questions", ""},
+		{"/api/questions", "true"},
+	} {
+		resp, err := http.Get(s.url(c.path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Deprecation") != c.deprecated {
+			t.Errorf("%s: status %d, Deprecation %q; want 200 with %q", c.path, resp.StatusCode, resp.Header.Get("Deprecation"), c.deprecated)
+		}
+	}
+	legacy := questionIDs(t, s, "seed=7&count=5")
+	var current struct {
+		Questions []servedQuestion `json:"questions"`
+	}
+	getJSON(t, s.url("/api/v1/questions?seed=7&count=5"), &current)
+	if len(current.Questions) != len(legacy) {
+		t.Fatalf("the two paths served %v and %v", current.Questions, legacy)
+	}
+	for i, q := range current.Questions {
+		if q.ID != legacy[i] {
+			t.Errorf("the two paths served %v and %v", current.Questions, legacy)
+			break
+		}
+	}
+
+	var version struct {
+		Version  string   `json:"version"`
+		Versions []string `json:"versions"`
+	}
+	if code := getJSON(t, s.url("/api/version"), &version); code != http.StatusOK || version.Version != "v1" || !slices.Equal(version.Versions, apiVersions) {
+		t.Errorf("/api/version: status %d, %+v", code, version)
+	}
+}
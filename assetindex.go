@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// assetInfo describes one shipped asset in the asset index. URL is where
+// it is served, relative to the base path, which differs from its path
+// for fingerprinted stylesheets and scripts.
+type assetInfo struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+	URL  string `json:"url"`
+}
+
+// buildAssetIndex returns every embedded file in content keyed by its
+// path, with the hash hashes records for it. Brotli variants are left
+// out, since they are only ever served in place of their source.
+func buildAssetIndex(content fs.FS, hashes map[string]string, assets *fingerprints) (map[string]assetInfo, error) {
+	index := make(map[string]assetInfo, len(hashes))
+	err := fs.WalkDir(content, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(name, ".br") {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		index[name] = assetInfo{Hash: hashes[name], Size: info.Size(), URL: assets.URL(name)}
+		return nil
+	})
+	return index, err
+}
+
+// assetIndexHandler serves GET /api/assets, the index of shipped assets,
+// encoded once up front.
+func assetIndexHandler(index map[string]assetInfo) (http.Handler, error) {
+	body, err := json.Marshal(map[string]any{"assets": index})
+	if err != nil {
+		return nil, err
+	}
+	tag := etag(contentHash(body))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		if notModified(w, r, tag) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}), nil
+}
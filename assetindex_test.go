@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestBuildAssetIndex(t *testing.T) {
+	fsys := fstest.MapFS{
+		"css/game.css":    {Data: []byte("body{}")},
+		"css/game.css.br": {Data: []byte("compressed")},
+		"index.html":      {Data: []byte("<html>")},
+	}
+	hashes, err := hashAssets(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := buildAssetIndex(fsys, hashes, newFingerprints(hashes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := index["css/game.css.br"]; ok {
+		t.Error("the index lists a Brotli variant")
+	}
+	css := index["css/game.css"]
+	if css.Hash != contentHash([]byte("body{}")) || css.Size != 6 {
+		t.Errorf("css/game.css = %+v, want its hash and 6 bytes", css)
+	}
+	if !strings.HasPrefix(css.URL, "css/game.") || css.URL == "css/game.css" {
+		t.Errorf("css/game.css is served at %q, want its fingerprinted name", css.URL)
+	}
+	if index["index.html"].URL != "index.html" {
+		t.Errorf("index.html is served at %q", index["index.html"].URL)
+	}
+}
+
+func TestAssetIndexEndpoint(t *testing.T) {
+	s := startServer(t)
+	var body struct {
+		Assets map[string]assetInfo `json:"assets"`
+	}
+	if code := getJSON(t, s.url("/api/assets"), &body); code != http.StatusOK {
+		t.Fatalf("/api/assets: status %d", code)
+	}
+	for _, name := range []string{"css/game.css", "manifest.json", "index.html"} {
+		a, ok := body.Assets[name]
+		if !ok || a.Hash == "" || a.Size <= 0 || a.URL == "" {
+			t.Errorf("%s listed as %+v", name, a)
+		}
+	}
+	css, err := fs.ReadFile(staticFS, "css/game.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := body.Assets["css/game.css"]; got.Hash != contentHash(css) || got.Size != int64(len(css)) {
+		t.Errorf("css/game.css listed as %+v, not the embedded file", got)
+	}
+	// The listed URL serves the listed file.
+	if code, served := get(t, s.url("/"+body.Assets["css/game.css"].URL)); code != http.StatusOK || served != string(css) {
+		t.Errorf("GET %s: status %d, not the stylesheet", body.Assets["css/game.css"].URL, code)
+	}
+	_, manifest := get(t, s.url("/manifest.json"))
+	if got := body.Assets["manifest.json"]; got.Hash != contentHash([]byte(manifest)) {
+		t.Errorf("manifest.json hash %s is not that of the served manifest", got.Hash)
+	}
+
+	_, tag := conditionalGet(t, s.url("/api/assets"), "")
+	if code, _ := conditionalGet(t, s.url("/api/assets"), tag); code != http.StatusNotModified {
+		t.Errorf("/api/assets with its ETag: status %d, want 304", code)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// backupFormat versions the archive layout; restore refuses others.
+	backupFormat = 1
+	// backupManifest is the archive's first entry, describing the rest.
+	backupManifest = "backup.json"
+	// maxRestoreBody bounds an uploaded archive, and maxRestoreSize what it
+	// may unpack to.
+	maxRestoreBody = 64 << 20
+	maxRestoreSize = 256 << 20
+)
+
+// backupNamespaces are the Store namespaces a backup holds.
+var backupNamespaces = []string{
+	leaderboardNamespace,
+	savesNamespace,
+	overlayNamespace,
+	historyNamespace,
+	reviewNamespace,
+	replayNamespace,
+	hintNamespace,
+	performanceNamespace,
+	reportNamespace,
+	progressNamespace,
+	sessionNamespace,
+	spentNamespace,
+	dailyServedNamespace,
+	statsNamespace,
+	hiddenNamespace,
+	chatFlagNamespace,
+	bannedNamespace,
+	profileNamespace,
+	profileLoginNamespace,
+	quarantineNamespace,
+	experimentStatsNamespace,
+	scoreNamespace,
+	nameNamespace,
+	nameOwnerNamespace,
+}
+
+// backupSchemas give, for the namespaces whose values restore checks
+// beyond being JSON, a value of the type each must decode into.
+var backupSchemas = map[string]func() any{
+	leaderboardNamespace:     func() any { return new([]LeaderboardEntry) },
+	savesNamespace:           func() any { return new(GameState) },
+	overlayNamespace:         func() any { return new(overlayEntry) },
+	replayNamespace:          func() any { return new(replay) },
+	chatFlagNamespace:        func() any { return new(chatFlag) },
+	profileNamespace:         func() any { return new(profile) },
+	profileLoginNamespace:    func() any { return new(string) },
+	quarantineNamespace:      func() any { return new(quarantinedScore) },
+	experimentStatsNamespace: func() any { return new(answerTotals) },
+	scoreNamespace:           func() any { return new(sessionScore) },
+}
+
+// backupInfo is the content of backupManifest.
+type backupInfo struct {
+	Format    int       `json:"format"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// storeState is the contents of some Store namespaces, by namespace and
+// key.
+type storeState map[string]map[string][]byte
+
+// snapshot reads every backupNamespaces value in store.
+func snapshot(store Store) (storeState, error) {
+	state := make(storeState, len(backupNamespaces))
+	for _, ns := range backupNamespaces {
+		keys, err := store.List(ns)
+		if err != nil {
+			return nil, err
+		}
+		state[ns] = make(map[string][]byte, len(keys))
+		for _, key := range keys {
+			data, err := store.Get(ns, key)
+			if errors.Is(err, ErrNotFound) {
+				continue // deleted since the listing
+			}
+			if err != nil {
+				return nil, err
+			}
+			state[ns][key] = data
+		}
+	}
+	return state, nil
+}
+
+// replaceState makes the backupNamespaces in store hold exactly state,
+// in one transaction where store supports them.
+func replaceState(store Store, state storeState) error {
+	if u, ok := store.(updater); ok {
+		return u.Update(func(tx Store) error { return writeState(tx, state) })
+	}
+	return writeState(store, state)
+}
+
+// writeState is replaceState without a transaction.
+func writeState(store Store, state storeState) error {
+	for _, ns := range backupNamespaces {
+		keys, err := store.List(ns)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if _, keep := state[ns][key]; keep {
+				continue
+			}
+			if err := store.Delete(ns, key); err != nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+		}
+		for _, key := range sortedKeys(state[ns]) {
+			if err := store.Set(ns, key, state[ns][key]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeBackup writes state to w as a gzipped tar archive: backupManifest,
+// then one <namespace>/<key> entry per value.
+func writeBackup(w io.Writer, state storeState, now time.Time) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: now, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	info, err := json.Marshal(backupInfo{Format: backupFormat, CreatedAt: now.UTC()})
+	if err != nil {
+		return err
+	}
+	if err := add(backupManifest, info); err != nil {
+		return err
+	}
+	for _, ns := range backupNamespaces {
+		for _, key := range sortedKeys(state[ns]) {
+			if err := add(ns+"/"+key, state[ns][key]); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// readBackup unpacks and checks an archive made by writeBackup. Every
+// entry must be a value of a backed-up namespace under a valid key that
+// decodes as that namespace expects, and the stored question edits must
+// leave a valid question set according to overlay.
+func readBackup(r io.Reader, overlay *questionOverlay) (storeState, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a gzip file: %w", err)
+	}
+	known := make(map[string]bool, len(backupNamespaces))
+	state := make(storeState, len(backupNamespaces))
+	for _, ns := range backupNamespaces {
+		known[ns] = true
+		state[ns] = make(map[string][]byte)
+	}
+
+	tr := tar.NewReader(&io.LimitedReader{R: gz, N: maxRestoreSize})
+	for first := true; ; first = false {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			if first {
+				return nil, errors.New("archive is empty")
+			}
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("corrupt archive: %w", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("corrupt archive: %s: %w", hdr.Name, err)
+		}
+		if first {
+			var info backupInfo
+			if hdr.Name != backupManifest || json.Unmarshal(data, &info) != nil {
+				return nil, fmt.Errorf("archive does not start with %s", backupManifest)
+			}
+			if info.Format != backupFormat {
+				return nil, fmt.Errorf("unsupported backup format %d", info.Format)
+			}
+			continue
+		}
+		ns, key := path.Split(hdr.Name)
+		ns = strings.TrimSuffix(ns, "/")
+		if hdr.Typeflag != tar.TypeReg || !known[ns] || !validStoreName.MatchString(key) {
+			return nil, fmt.Errorf("unexpected entry %q", hdr.Name)
+		}
+		if _, dup := state[ns][key]; dup {
+			return nil, fmt.Errorf("duplicate entry %q", hdr.Name)
+		}
+		if schema, ok := backupSchemas[ns]; ok {
+			err = json.Unmarshal(data, schema())
+		} else if !json.Valid(data) {
+			err = errors.New("invalid JSON")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+		state[ns][key] = data
+	}
+
+	edits := make(map[string]overlayEntry, len(state[overlayNamespace]))
+	for id, data := range state[overlayNamespace] {
+		var e overlayEntry
+		json.Unmarshal(data, &e) // checked above
+		edits[id] = e
+	}
+	if problems := overlay.Check(edits); len(problems) > 0 {
+		return nil, fmt.Errorf("question edits: %w", &contentError{Problems: problems})
+	}
+	return state, nil
+}
+
+// adminBackupHandler serves GET /api/admin/backup, streaming an archive
+// of all stored state.
+func adminBackupHandler(store Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		state, err := snapshot(store)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "could not read stored state")
+			return
+		}
+		now := time.Now()
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", `attachment; filename="lobelabyrinth-backup-`+now.UTC().Format("20060102-150405")+`.tar.gz"`)
+		w.Header().Set("Cache-Control", "no-store")
+		if err := writeBackup(w, state, now); err != nil {
+			slog.WarnContext(r.Context(), "backup interrupted", "err", err)
+		}
+	})
+}
+
+// adminRestoreHandler serves POST /api/admin/restore, replacing all
+// stored state with an archive from /api/admin/backup. The archive is
+// checked in full before anything is changed; if writing it fails part
+// way, the previous state is put back. reload then refreshes whatever
+// the server holds in memory.
+func adminRestoreHandler(store Store, overlay *questionOverlay, reload func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxRestoreBody)
+		state, err := readBackup(r.Body, overlay)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeAPIError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("archive must be at most %d bytes", tooLarge.Limit))
+				return
+			}
+			writeAPIError(w, http.StatusBadRequest, "invalid backup: "+err.Error())
+			return
+		}
+
+		prev, err := snapshot(store)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "could not read stored state")
+			return
+		}
+		if err := replaceState(store, state); err != nil {
+			slog.ErrorContext(r.Context(), "restore failed; putting back the previous state", "err", err)
+			if err := replaceState(store, prev); err != nil {
+				slog.ErrorContext(r.Context(), "could not put back the previous state", "err", err)
+			}
+			reload()
+			writeAPIError(w, http.StatusInternalServerError, "could not restore state")
+			return
+		}
+		if err := reload(); err != nil {
+			slog.ErrorContext(r.Context(), "restored state could not be loaded", "err", err)
+			writeAPIError(w, http.StatusInternalServerError, "restored, but the server could not load the restored state")
+			return
+		}
+		counts := make(map[string]int, len(state))
+		for ns, values := range state {
+			counts[ns] = len(values)
+		}
+		slog.InfoContext(r.Context(), "state restored", "counts", counts)
+		writeJSON(w, http.StatusOK, map[string]any{"restored": counts})
+	})
+}
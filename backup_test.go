@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// adminDo makes an authenticated admin request and returns the status
+// code and body.
+func adminDo(t *testing.T, s *runningServer, method, path string, body []byte) (int, []byte) {
+	t.Helper()
+	req, _ := http.NewRequest(method, s.url(path), bytes.NewReader(body))
+	req.SetBasicAuth("admin", testAdminPassword)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
+
+// savedRoom returns the room of the game saved under testSaveToken.
+func savedRoom(t *testing.T, s *runningServer) string {
+	t.Helper()
+	var state GameState
+	if code := getJSON(t, s.url("/api/load?token="+testSaveToken), &state); code != http.StatusOK {
+		t.Fatalf("/api/load: status %d", code)
+	}
+	return state.Room
+}
+
+// leaderboardNames returns the names on the main leaderboard.
+func leaderboardNames(t *testing.T, s *runningServer) []string {
+	t.Helper()
+	var body struct {
+		Entries []LeaderboardEntry `json:"entries"`
+	}
+	getJSON(t, s.url("/api/leaderboard"), &body)
+	var names []string
+	for _, e := range body.Entries {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	s := startServer(t, "-admin-password", testAdminPassword, "-min-run-time", "0s")
+	postJSON(t, s.url("/api/save?token="+testSaveToken), GameState{Room: "library", Score: 100}, nil)
+	if code := submitScore(t, s, startSession(t, s), "ada", 0, 1000); code != http.StatusCreated {
+		t.Fatalf("score: status %d", code)
+	}
+
+	code, archive := adminDo(t, s, http.MethodGet, "/api/admin/backup", nil)
+	if code != http.StatusOK {
+		t.Fatalf("backup: status %d", code)
+	}
+	if _, err := gzip.NewReader(bytes.NewReader(archive)); err != nil {
+		t.Fatalf("backup is not gzipped: %v", err)
+	}
+
+	postJSON(t, s.url("/api/save?token="+testSaveToken), GameState{Room: "vault", Score: 900}, nil)
+	submitScore(t, s, startSession(t, s), "bob", 0, 1000)
+	q := newAdminQuestion("q900")
+	q.Question = "Which gargoyle guards the moat?"
+	if code := adminRequest(t, s, http.MethodPost, "/api/admin/questions", "admin", testAdminPassword, q); code != http.StatusCreated {
+		t.Fatalf("create: status %d, want 201", code)
+	}
+	if ids := searchIDs(t, s, "gargoyle"); len(ids) != 1 {
+		t.Fatalf("before restore search finds %v, want the created question", ids)
+	}
+	if code, body := adminDo(t, s, http.MethodPost, "/api/admin/restore", archive); code != http.StatusOK {
+		t.Fatalf("restore: status %d: %s", code, body)
+	}
+	if room := savedRoom(t, s); room != "library" {
+		t.Errorf("after restore the save is in %q, want the backed-up library", room)
+	}
+	if names := leaderboardNames(t, s); len(names) != 1 || names[0] != "ada" {
+		t.Errorf("after restore the leaderboard holds %v, want only ada", names)
+	}
+	if ids := searchIDs(t, s, "gargoyle"); len(ids) != 0 {
+		t.Errorf("after restore search finds %v, a question created after the backup", ids)
+	}
+}
+
+func TestRestoreRejectsCorruptArchive(t *testing.T) {
+	s := startServer(t, "-admin-password", testAdminPassword)
+	postJSON(t, s.url("/api/save?token="+testSaveToken), GameState{Room: "library"}, nil)
+	_, archive := adminDo(t, s, http.MethodGet, "/api/admin/backup", nil)
+
+	archiveOf := func(state storeState) []byte {
+		var buf bytes.Buffer
+		if err := writeBackup(&buf, state, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	var empty bytes.Buffer
+	gzip.NewWriter(&empty).Close()
+
+	for name, body := range map[string][]byte{
+		"not gzip":     []byte("plain text"),
+		"truncated":    archive[:len(archive)/2],
+		"empty":        empty.Bytes(),
+		"bad save":     archiveOf(storeState{savesNamespace: {testSaveToken: []byte(`{"room": 7}`)}}),
+		"invalid JSON": archiveOf(storeState{historyNamespace: {testSaveToken: []byte(`{`)}}),
+		"unknown key":  archiveOf(storeState{savesNamespace: {"../escape": []byte(`{"room": "vault"}`)}}),
+		"bad question": archiveOf(storeState{overlayNamespace: {"q900": []byte(`{"question": {"id": "q900"}}`)}}),
+	} {
+		if code, _ := adminDo(t, s, http.MethodPost, "/api/admin/restore", body); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, code)
+		}
+	}
+	if room := savedRoom(t, s); room != "library" {
+		t.Errorf("a rejected restore changed the save to %q", room)
+	}
+	if code := adminRequest(t, s, http.MethodGet, "/api/admin/backup", "admin", "wrong password!", nil); code != http.StatusUnauthorized {
+		t.Errorf("backup with a bad password: status %d, want 401", code)
+	}
+}
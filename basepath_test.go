@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+const testBasePath = "/games/labyrinth"
+
+func TestBasePathRoutes(t *testing.T) {
+	s := startServer(t, "-base-path", testBasePath, "-metrics")
+	code, body := get(t, s.url(testBasePath+"/help"))
+	if code != http.StatusOK {
+		t.Fatalf("%s/help: status %d, want 200", testBasePath, code)
+	}
+	if !regexp.MustCompile(`href="` + testBasePath + `/css/game\.(\w+\.)?css"`).MatchString(body) {
+		t.Error("/help does not link the stylesheet under the base path")
+	}
+	for _, path := range []string{"/css/game.css", "/healthz", "/readyz", "/metrics", "/api/questions"} {
+		if code, _ := get(t, s.url(testBasePath+path)); code != http.StatusOK {
+			t.Errorf("%s%s: status %d, want 200", testBasePath, path, code)
+		}
+	}
+	for _, path := range []string{"/help", "/css/game.css", "/api/questions"} {
+		if code, _ := get(t, s.url(path)); code != http.StatusNotFound {
+			t.Errorf("%s outside the base path: status %d, want 404", path, code)
+		}
+	}
+}
+
+func TestBasePathInManifest(t *testing.T) {
+	s := startServer(t, "-base-path", testBasePath)
+	var manifest struct {
+		StartURL string `json:"start_url"`
+		Scope    string `json:"scope"`
+	}
+	if code := getJSON(t, s.url(testBasePath+"/manifest.json"), &manifest); code != http.StatusOK {
+		t.Fatalf("manifest: status %d", code)
+	}
+	if manifest.StartURL != testBasePath+"/game.html" || manifest.Scope != testBasePath+"/" {
+		t.Errorf("start_url %q and scope %q, want them under %s", manifest.StartURL, manifest.Scope, testBasePath)
+	}
+}
+
+func TestBasePathValidated(t *testing.T) {
+	for _, bad := range []string{"games", "/games/../x", "/games//x"} {
+		if _, err := loadConfig([]string{"-base-path", bad}, func(string) string { return "" }); err == nil {
+			t.Errorf("base-path %q was accepted", bad)
+		}
+	}
+}
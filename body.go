@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// defaultMaxBodyBytes is the default bound on an API request body.
+const defaultMaxBodyBytes = 1 << 20
+
+// limitBody rejects requests whose body is declared longer than limit with
+// 413, and stops reading the body of the rest once limit bytes have been
+// read. Handlers may set a smaller limit of their own.
+func limitBody(limit int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body must be at most %d bytes", limit))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeBodyError reports a request body that could not be read or
+// decoded: 413 if it ran past a limit, and otherwise 400 with message.
+func writeBodyError(w http.ResponseWriter, err error, message string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body must be at most %d bytes", tooLarge.Limit))
+		return
+	}
+	writeAPIError(w, http.StatusBadRequest, message)
+}
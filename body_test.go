@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// readingHandler reads the whole request body, as the JSON endpoints do,
+// reporting a body past the limit with writeBodyError.
+var readingHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if _, err := io.ReadAll(r.Body); err != nil {
+		writeBodyError(w, err, "could not read body")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+})
+
+func TestLimitBody(t *testing.T) {
+	h := limitBody(16, readingHandler)
+	tests := []struct {
+		name    string
+		body    string
+		chunked bool
+		want    int
+	}{
+		{"within the limit", "0123456789abcdef", false, http.StatusNoContent},
+		{"declared too long", "0123456789abcdefg", false, http.StatusRequestEntityTooLarge},
+		{"chunked, within the limit", "0123456789", true, http.StatusNoContent},
+		{"chunked, too long", strings.Repeat("x", 1000), true, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/api/save", strings.NewReader(tt.body))
+		if tt.chunked {
+			r.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+			continue
+		}
+		if w.Code == http.StatusRequestEntityTooLarge {
+			var body struct {
+				Error apiError `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || !strings.Contains(body.Error.Message, "16 bytes") {
+				t.Errorf("%s: body %q, want a JSON error naming the limit", tt.name, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestServerBodyLimit(t *testing.T) {
+	s := startServer(t, "-max-body-bytes", "200", "-admin-password", testAdminPassword)
+	if code := postJSON(t, s.url("/api/save?token="+testSaveToken), map[string]any{"room": "library", "score": 100}, nil); code >= 300 {
+		t.Errorf("small save: status %d", code)
+	}
+	large := map[string]any{"room": strings.Repeat("r", 300)}
+	if code := postJSON(t, s.url("/api/save?token="+testSaveToken), large, nil); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("save past -max-body-bytes: status %d, want 413", code)
+	}
+
+	// The question import takes far larger bodies than the API default.
+	code, _ := importCSV(t, s, "id,category,difficulty,question,answers,correctAnswer,explanation\n"+
+		"q900,history,easy,\"When did the Norman conquest begin?\",1066|1215|1492,0,\"Hastings, 1066.\"\n"+
+		"q901,science,hard,What is the chemical symbol for gold?,Au|Ag|Fe,0,\"From the Latin aurum.\"\n")
+	if code != http.StatusOK {
+		t.Errorf("question import past -max-body-bytes: status %d, want its own larger limit", code)
+	}
+}
+
+func TestMaxBodyBytesValidated(t *testing.T) {
+	if _, err := loadConfig([]string{"-max-body-bytes", "0"}, noEnv); err == nil || !strings.Contains(err.Error(), "max-body-bytes") {
+		t.Errorf("-max-body-bytes 0: err = %v", err)
+	}
+}
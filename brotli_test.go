@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/andybalholm/brotli"
+)
+
+// fetchEncoded fetches url with the Accept-Encoding header accept, without
+// the transport's own gzip negotiation, and returns the response and its
+// undecoded body.
+func fetchEncoded(t *testing.T, url, accept string) (*http.Response, []byte) {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if accept != "" {
+		req.Header.Set("Accept-Encoding", accept)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestBrotliNegotiation(t *testing.T) {
+	const asset = "css/victory.css"
+	want, err := fs.ReadFile(staticFS, asset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := startServer(t)
+	decode := map[string]func([]byte) ([]byte, error){
+		"br": func(b []byte) ([]byte, error) { return io.ReadAll(brotli.NewReader(bytes.NewReader(b))) },
+		"gzip": func(b []byte) ([]byte, error) {
+			zr, err := gzip.NewReader(bytes.NewReader(b))
+			if err != nil {
+				return nil, err
+			}
+			return io.ReadAll(zr)
+		},
+		"": func(b []byte) ([]byte, error) { return b, nil },
+	}
+	for _, tt := range []struct{ accept, want string }{
+		{"br, gzip", "br"},
+		{"gzip, deflate, br", "br"},
+		{"gzip", "gzip"},
+		{"br;q=0, gzip", "gzip"},
+		{"", ""},
+		{"identity", ""},
+	} {
+		resp, body := fetchEncoded(t, s.url("/"+asset), tt.accept)
+		if got := resp.Header.Get("Content-Encoding"); got != tt.want {
+			t.Errorf("Accept-Encoding %q: Content-Encoding = %q, want %q", tt.accept, got, tt.want)
+			continue
+		}
+		if vary := resp.Header.Values("Vary"); !containsToken(vary, "Accept-Encoding") {
+			t.Errorf("Accept-Encoding %q: Vary = %q, want Accept-Encoding", tt.accept, vary)
+		}
+		got, err := decode[tt.want](body)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("Accept-Encoding %q: body does not decode to %s (%v)", tt.accept, asset, err)
+		}
+	}
+}
+
+// containsToken reports whether any of the comma-separated header values
+// lists token.
+func containsToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if strings.TrimSpace(part) == token {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestLoadBrotliSkipsStaleVariants(t *testing.T) {
+	compress := func(data string) []byte {
+		var b bytes.Buffer
+		bw := brotli.NewWriter(&b)
+		io.WriteString(bw, data)
+		bw.Close()
+		return b.Bytes()
+	}
+	fsys := fstest.MapFS{
+		"app.js":       {Data: []byte("console.log('current')")},
+		"app.js.br":    {Data: compress("console.log('current')")},
+		"old.js":       {Data: []byte("console.log('edited')")},
+		"old.js.br":    {Data: compress("console.log('original')")},
+		"orphan.js.br": {Data: compress("gone")},
+	}
+	hashes, err := hashAssets(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordLogs(t)
+	variants, err := loadBrotli(fsys, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(variants) != 1 || variants["app.js"] == nil {
+		t.Errorf("loaded variants for %v, want only app.js", sortedKeys(variants))
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// certReloader serves the certificate/key pair in certFile and keyFile,
+// reading them again when they change on disk or a reloadSignals signal
+// arrives, so that a renewed certificate is picked up without a restart.
+// Handshakes in progress keep the certificate they started with.
+type certReloader struct {
+	certFile, keyFile string
+	cert              atomic.Pointer[tls.Certificate]
+}
+
+// newCertReloader returns a reloader serving the pair in certFile and
+// keyFile, which must load and be valid now.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(time.Now()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the pair again and swaps it in if it is valid at now. An
+// invalid pair, such as one caught half-written, is reported and the
+// certificate in use is kept.
+func (r *certReloader) Reload(now time.Time) error {
+	pair, err := loadKeyPair(r.certFile, r.keyFile, now)
+	if err != nil {
+		return err
+	}
+	r.cert.Store(&pair)
+	return nil
+}
+
+// GetCertificate returns the certificate in use, for tls.Config.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// reload reloads the pair and logs the outcome.
+func (r *certReloader) reload() {
+	if err := r.Reload(time.Now()); err != nil {
+		slog.Error("TLS certificate reload failed; keeping the current certificate", "err", err)
+		return
+	}
+	slog.Info("TLS certificate reloaded", "cert", r.certFile, "expires", r.cert.Load().Leaf.NotAfter)
+}
+
+// watch reloads the pair whenever a file in the directories of certFile or
+// keyFile changes, and on each reloadSignals signal, until ctx is done.
+// The directories are watched rather than the files, so that a pair
+// replaced by renaming, as certificate tools and mounted secrets do, is
+// seen.
+func (r *certReloader) watch(ctx context.Context) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for _, dir := range dedupe([]string{filepath.Dir(r.certFile), filepath.Dir(r.keyFile)}) {
+		if err := w.Add(dir); err != nil {
+			w.Close()
+			return fmt.Errorf("watching %s: %w", dir, err)
+		}
+	}
+	sig := make(chan os.Signal, 1)
+	if len(reloadSignals) > 0 {
+		signal.Notify(sig, reloadSignals...)
+	}
+	watched := map[string]bool{filepath.Clean(r.certFile): true, filepath.Clean(r.keyFile): true}
+	go func() {
+		defer w.Close()
+		defer signal.Stop(sig)
+		timer := time.NewTimer(reloadDelay)
+		timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case ev := <-w.Events:
+				// A mounted secret is swapped by renaming a directory that
+				// the files link into, so any change there may be theirs.
+				if watched[filepath.Clean(ev.Name)] || filepath.Base(ev.Name)[0] == '.' {
+					timer.Reset(reloadDelay)
+				}
+			case err := <-w.Errors:
+				slog.Warn("certificate watcher", "err", err)
+			case <-timer.C:
+				r.reload()
+			case <-sig:
+				r.reload()
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// servedExpiry returns when the certificate s presents in a new handshake
+// expires.
+func servedExpiry(t *testing.T, s *runningServer) time.Time {
+	t.Helper()
+	conn, err := tls.Dial("tcp", s.addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].NotAfter
+}
+
+// replaceKeyPair writes a pair valid until notAfter and moves it over
+// certFile and keyFile, as renewal tools do.
+func replaceKeyPair(t *testing.T, certFile, keyFile string, notAfter time.Time) {
+	t.Helper()
+	cert, key := writeKeyPair(t, t.TempDir(), time.Now().Add(-time.Hour), notAfter)
+	for from, to := range map[string]string{cert: certFile, key: keyFile} {
+		data, err := os.ReadFile(from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(to+".new", data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(to+".new", to); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCertReloaderKeepsValidCertificate(t *testing.T) {
+	dir := t.TempDir()
+	first := time.Now().Add(time.Hour).Truncate(time.Second)
+	certFile, keyFile := writeKeyPair(t, dir, time.Now().Add(-time.Hour), first)
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiry := func() time.Time {
+		cert, _ := r.GetCertificate(nil)
+		return cert.Leaf.NotAfter
+	}
+	if !expiry().Equal(first) {
+		t.Fatalf("serving a certificate expiring %v, want %v", expiry(), first)
+	}
+
+	renewed := first.Add(time.Hour)
+	replaceKeyPair(t, certFile, keyFile, renewed)
+	if err := r.Reload(time.Now()); err != nil || !expiry().Equal(renewed) {
+		t.Errorf("after renewal: %v, expiring %v; want %v", err, expiry(), renewed)
+	}
+
+	os.WriteFile(certFile, []byte("-----BEGIN CERTIFICATE-----\nhalf"), 0o644)
+	if err := r.Reload(time.Now()); err == nil || !expiry().Equal(renewed) {
+		t.Errorf("a garbled certificate: %v, expiring %v; want the renewed one kept", err, expiry())
+	}
+	expired := time.Now().Add(-time.Minute)
+	replaceKeyPair(t, certFile, keyFile, expired)
+	if err := r.Reload(time.Now()); err == nil || !expiry().Equal(renewed) {
+		t.Errorf("an expired certificate: %v, expiring %v; want the renewed one kept", err, expiry())
+	}
+
+	if _, err := newCertReloader(filepath.Join(dir, "missing.pem"), keyFile); err == nil {
+		t.Error("a reloader started without a certificate")
+	}
+}
+
+func TestCertReloaderWatchesFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := r.watch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	renewed := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	replaceKeyPair(t, certFile, keyFile, renewed)
+	waitFor(t, "the renewed certificate", func() bool {
+		cert, _ := r.GetCertificate(nil)
+		return cert.Leaf.NotAfter.Equal(renewed)
+	})
+}
+
+func TestServerReloadsCertificateOnSIGHUP(t *testing.T) {
+	dir := t.TempDir()
+	first := time.Now().Add(time.Hour).Truncate(time.Second)
+	certFile, keyFile := writeKeyPair(t, dir, time.Now().Add(-time.Hour), first)
+	s := startServer(t, "-tls-cert", certFile, "-tls-key", keyFile)
+	if got := servedExpiry(t, s); !got.Equal(first) {
+		t.Fatalf("served a certificate expiring %v, want %v", got, first)
+	}
+
+	renewed := first.Add(24 * time.Hour)
+	replaceKeyPair(t, certFile, keyFile, renewed)
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	waitFor(t, "the renewed certificate", func() bool { return servedExpiry(t, s).Equal(renewed) })
+
+	os.WriteFile(keyFile, []byte("not a key"), 0o600)
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	waitFor(t, "the failed reload", func() bool {
+		_, ok := s.logs.attr("TLS certificate reload failed; keeping the current certificate", "err")
+		return ok
+	})
+	if got := servedExpiry(t, s); !got.Equal(renewed) {
+		t.Errorf("after an invalid replacement, served a certificate expiring %v, want %v", got, renewed)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// maxChatLength bounds a chat message, in characters.
+	maxChatLength = 280
+	// chatRate and chatBurst bound how fast one connection may chat.
+	chatRate  = rate.Limit(1)
+	chatBurst = 5
+)
+
+// chatWord matches the words a chat filter checks.
+var chatWord = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// chatFilter masks blocked words in chat messages.
+type chatFilter struct {
+	words map[string]bool
+}
+
+// loadChatFilter reads a word list with one word per line; blank lines
+// and lines starting with # are skipped. An empty path means no filter.
+func loadChatFilter(path string) (*chatFilter, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	words := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		words[strings.ToLower(word)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &chatFilter{words: words}, nil
+}
+
+// Clean returns text with every blocked word, matched regardless of case,
+// replaced by asterisks. A nil filter leaves text alone.
+func (f *chatFilter) Clean(text string) string {
+	if f == nil || len(f.words) == 0 {
+		return text
+	}
+	return chatWord.ReplaceAllStringFunc(text, func(word string) string {
+		if f.words[strings.ToLower(word)] {
+			return strings.Repeat("*", utf8.RuneCountInString(word))
+		}
+		return word
+	})
+}
+
+// Blocks reports whether text holds a blocked word, matched regardless of
+// case, either as a word of its own or spelled across separators, as in
+// "b.a.d". A nil filter blocks nothing.
+func (f *chatFilter) Blocks(text string) bool {
+	if f == nil || len(f.words) == 0 {
+		return false
+	}
+	words := chatWord.FindAllString(strings.ToLower(text), -1)
+	for _, word := range words {
+		if f.words[word] {
+			return true
+		}
+	}
+	return f.words[strings.Join(words, "")]
+}
+
+// chat sends text from p to everyone in p's room, p included. Messages
+// are relayed live only; nothing is kept once they are sent, except that
+// those the filter had to mask are flagged for moderation as written.
+func (h *hub) chat(p *roomPlayer, text string) error {
+	text = strings.TrimSpace(text)
+	switch {
+	case text == "":
+		return errors.New("text is required")
+	case utf8.RuneCountInString(text) > maxChatLength:
+		return fmt.Errorf("text must be at most %d characters", maxChatLength)
+	case !p.chat.Allow():
+		return errors.New("sending chat messages too fast")
+	}
+	now := time.Now().UTC()
+	clean := h.filter.Clean(text)
+	if clean != text {
+		if err := h.mod.FlagChat(chatFlag{Room: p.room.id, Name: p.name, Text: text, SentAt: now}); err != nil {
+			slog.Warn("could not flag chat message", "err", err)
+		}
+	}
+	msg := serverMessage{Type: msgChat, From: p.id, Name: p.name, Text: clean, Time: &now}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.deliverAll(p.room, msg)
+	return nil
+}
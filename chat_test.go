@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChatStaysInItsRoom(t *testing.T) {
+	s := startServer(t)
+	ada := joinRoom(t, s, "crypt", "ada")
+	ada.stateWith(1)
+	grace := joinRoom(t, s, "crypt", "grace")
+	grace.stateWith(2)
+	alan := joinRoom(t, s, "tower", "alan")
+	alan.stateWith(1)
+
+	ada.send(clientMessage{Type: msgChat, Text: "  hello crypt  "})
+	for _, p := range []*wsPlayer{ada, grace} {
+		msg := p.next(msgChat)
+		if msg.Name != "ada" || msg.Text != "hello crypt" || msg.From == "" || msg.Time == nil || msg.Time.IsZero() {
+			t.Errorf("chat message %+v lacks its sender, text, or time", msg)
+		}
+	}
+
+	// Alan's own message is the first chat he sees: ada's never reached
+	// him.
+	alan.send(clientMessage{Type: msgChat, Text: "anyone?"})
+	if msg := alan.next(msgChat); msg.Name != "alan" || msg.Text != "anyone?" {
+		t.Errorf("tower received %+v, want only alan's message", msg)
+	}
+}
+
+func TestChatRejectsEmptyAndLongMessages(t *testing.T) {
+	s := startServer(t)
+	ada := joinRoom(t, s, "crypt", "ada")
+	ada.stateWith(1)
+	for _, text := range []string{"   ", strings.Repeat("é", maxChatLength+1)} {
+		ada.send(clientMessage{Type: msgChat, Text: text})
+		if msg := ada.next(msgError); msg.Error == "" {
+			t.Errorf("chat of %d characters accepted", len([]rune(text)))
+		}
+	}
+	ada.send(clientMessage{Type: msgChat, Text: strings.Repeat("é", maxChatLength)})
+	if msg := ada.next(msgChat); len([]rune(msg.Text)) != maxChatLength {
+		t.Errorf("a message of exactly %d characters came back as %d", maxChatLength, len([]rune(msg.Text)))
+	}
+}
+
+func TestChatRateLimited(t *testing.T) {
+	s := startServer(t)
+	ada := joinRoom(t, s, "crypt", "ada")
+	ada.stateWith(1)
+	for range chatBurst + 1 {
+		ada.send(clientMessage{Type: msgChat, Text: "spam"})
+	}
+	if msg := ada.next(msgError); !strings.Contains(msg.Error, "too fast") {
+		t.Errorf("error %q, want the sender told to slow down", msg.Error)
+	}
+}
+
+func TestChatFilterMasksBlockedWords(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(list, []byte("# words\n\nDarn\nheck\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := loadChatFilter(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.Clean("Darn it, what the HECK, darnation"), "**** it, what the ****, darnation"; got != want {
+		t.Errorf("Clean = %q, want %q", got, want)
+	}
+	var none *chatFilter
+	if got := none.Clean("darn"); got != "darn" {
+		t.Errorf("nil filter changed the text to %q", got)
+	}
+	if _, err := loadChatFilter(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("a missing word list was accepted")
+	}
+
+	s := startServer(t, "-chat-blocklist", list)
+	ada := joinRoom(t, s, "crypt", "ada")
+	ada.stateWith(1)
+	ada.send(clientMessage{Type: msgChat, Text: "oh heck"})
+	if msg := ada.next(msgChat); msg.Text != "oh ****" {
+		t.Errorf("relayed %q, want the blocked word masked", msg.Text)
+	}
+}
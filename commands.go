@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// subcommands maps each subcommand name to its entry point, which returns
+// the process exit code.
+var subcommands = map[string]func(args []string, stdout, stderr io.Writer) int{
+	"validate":  runValidate,
+	"checksums": runChecksums,
+	"verify":    runVerify,
+	"doctor":    runDoctor,
+}
+
+// validateReport is the -json output of the validate subcommand.
+type validateReport struct {
+	Dir      string           `json:"dir"`
+	Valid    bool             `json:"valid"`
+	Files    []string         `json:"files"`
+	Problems []contentProblem `json:"problems"`
+}
+
+// runValidate implements "lobelabyrinth validate [-json] [dir]", checking
+// the question, achievement, and map files on disk under dir (default ".").
+func runValidate(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	jsonOut := fs.Bool("json", false, "write the report as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: lobelabyrinth validate [-json] [dir]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+	dir := "."
+	if fs.NArg() == 1 {
+		dir = fs.Arg(0)
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		fmt.Fprintf(stderr, "validate: %s is not a directory\n", dir)
+		return 2
+	}
+
+	content := os.DirFS(dir)
+	report := validateReport{Dir: dir, Files: contentFiles(content), Problems: []contentProblem{}}
+	if len(report.Files) == 0 {
+		report.Files = []string{}
+		report.Problems = append(report.Problems, contentProblem{File: dir, Message: "no question, achievement, or map files found"})
+	}
+	var ce *contentError
+	if err := validateContent(content); errors.As(err, &ce) {
+		report.Problems = append(report.Problems, ce.Problems...)
+	}
+	report.Valid = len(report.Problems) == 0
+
+	if *jsonOut {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		for _, f := range report.Files {
+			fmt.Fprintf(stdout, "checked %s\n", f)
+		}
+		for _, p := range report.Problems {
+			fmt.Fprintf(stdout, "ERROR %s\n", p)
+		}
+		if report.Valid {
+			fmt.Fprintf(stdout, "OK: %d file(s), no problems\n", len(report.Files))
+		} else {
+			fmt.Fprintf(stdout, "FAIL: %d problem(s)\n", len(report.Problems))
+		}
+	}
+	if !report.Valid {
+		return 1
+	}
+	return 0
+}
+
+// runChecksums implements "lobelabyrinth checksums [-o file] [dir]",
+// writing the manifest of the files under dir (default ".") that would be
+// embedded, for committing as checksumsFile.
+func runChecksums(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("checksums", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	out := fs.String("o", "", "write the manifest to this file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: lobelabyrinth checksums [-o file] [dir]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+	dir := "."
+	if fs.NArg() == 1 {
+		dir = fs.Arg(0)
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		fmt.Fprintf(stderr, "checksums: %s is not a directory\n", dir)
+		return 2
+	}
+	hashes, err := hashAssets(devFS{os.DirFS(dir), embedPatterns})
+	if err != nil {
+		fmt.Fprintf(stderr, "checksums: %v\n", err)
+		return 1
+	}
+	manifest := formatChecksums(hashes)
+	if *out == "" {
+		stdout.Write(manifest)
+		return 0
+	}
+	if err := os.WriteFile(*out, manifest, 0o644); err != nil {
+		fmt.Fprintf(stderr, "checksums: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runVerify implements "lobelabyrinth verify [-manifest file] [dir]",
+// checking the embedded assets, or the files under dir that would be
+// embedded, against the embedded checksumsFile or the given manifest.
+func runVerify(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	against := flags.String("manifest", "", "check against this manifest instead of the embedded "+checksumsFile)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: lobelabyrinth verify [-manifest file] [dir]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return 2
+	}
+	manifest := assetChecksums
+	if *against != "" {
+		var err error
+		if manifest, err = os.ReadFile(*against); err != nil {
+			fmt.Fprintf(stderr, "verify: %v\n", err)
+			return 2
+		}
+	}
+	var bundle fs.FS = staticFS
+	if flags.NArg() == 1 {
+		dir := flags.Arg(0)
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			fmt.Fprintf(stderr, "verify: %s is not a directory\n", dir)
+			return 2
+		}
+		bundle = devFS{os.DirFS(dir), embedPatterns}
+	}
+
+	mismatches, err := verifyAssets(bundle, manifest)
+	if err != nil {
+		fmt.Fprintf(stdout, "ERROR %v\n", err)
+		return 1
+	}
+	for _, m := range mismatches {
+		fmt.Fprintf(stdout, "ERROR %s\n", m)
+	}
+	if len(mismatches) > 0 {
+		fmt.Fprintf(stdout, "FAIL: %d file(s) differ from the manifest\n", len(mismatches))
+		return 1
+	}
+	fmt.Fprintln(stdout, "OK: every file matches the manifest")
+	return 0
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runCommand runs the subcommand name with args and returns its exit code
+// and output.
+func runCommand(name string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := subcommands[name](args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestValidateCommandValidFixture(t *testing.T) {
+	code, out, _ := runCommand("validate", "testdata/validate/valid")
+	if code != 0 {
+		t.Fatalf("exit %d, want 0:\n%s", code, out)
+	}
+	for _, want := range []string{"checked data/questions.json", "checked data/achievements.json", "OK: 2 file(s)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestValidateCommandInvalidFixture(t *testing.T) {
+	code, out, _ := runCommand("validate", "testdata/validate/invalid")
+	if code != 1 {
+		t.Fatalf("exit %d, want 1:\n%s", code, out)
+	}
+	for _, want := range []string{
+		"ERROR data/questions.json: questions[0] (t001): correctAnswer 4",
+		"ERROR data/questions.json: questions[1] (t001): duplicate id",
+		"empty question prompt",
+		`unknown difficulty "legendary"`,
+		"FAIL: 4 problem(s)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestValidateCommandJSON(t *testing.T) {
+	code, out, _ := runCommand("validate", "-json", "testdata/validate/invalid")
+	if code != 1 {
+		t.Errorf("exit %d, want 1", code)
+	}
+	var report validateReport
+	if err := json.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatalf("-json output is not JSON: %v\n%s", err, out)
+	}
+	if report.Valid || len(report.Problems) != 4 || report.Problems[0].File != "data/questions.json" {
+		t.Errorf("report %+v, want 4 problems in data/questions.json", report)
+	}
+
+	code, out, _ = runCommand("validate", "-json", "testdata/validate/valid")
+	if err := json.Unmarshal([]byte(out), &report); err != nil || code != 0 || !report.Valid || report.Problems == nil {
+		t.Errorf("valid fixture: exit %d, report %+v, err %v", code, report, err)
+	}
+}
+
+func TestValidateCommandEmptyAndMissingDirs(t *testing.T) {
+	if code, out, _ := runCommand("validate", "testdata/validate/empty"); code != 1 || !strings.Contains(out, "no question, achievement, or map files") {
+		t.Errorf("empty directory: exit %d:\n%s", code, out)
+	}
+	if code, _, errOut := runCommand("validate", "testdata/validate/nowhere"); code != 2 || !strings.Contains(errOut, "not a directory") {
+		t.Errorf("missing directory: exit %d: %s", code, errOut)
+	}
+	if code, _, _ := runCommand("validate", "a", "b"); code != 2 {
+		t.Errorf("two directories: exit %d, want 2", code)
+	}
+}
+
+// tamperableBundle copies the valid validate fixture to a temporary
+// directory and writes its manifest alongside, returning both paths.
+func tamperableBundle(t *testing.T) (dir, manifest string) {
+	t.Helper()
+	dir = filepath.Join(t.TempDir(), "bundle")
+	if err := os.CopyFS(dir, os.DirFS("testdata/validate/valid")); err != nil {
+		t.Fatal(err)
+	}
+	manifest = filepath.Join(t.TempDir(), checksumsFile)
+	if code, _, errOut := runCommand("checksums", "-o", manifest, dir); code != 0 {
+		t.Fatalf("checksums: exit %d: %s", code, errOut)
+	}
+	return dir, manifest
+}
+
+func TestVerifyCommandIntactBundle(t *testing.T) {
+	dir, manifest := tamperableBundle(t)
+	code, out, _ := runCommand("verify", "-manifest", manifest, dir)
+	if code != 0 || !strings.Contains(out, "OK: every file matches") {
+		t.Errorf("exit %d, want 0:\n%s", code, out)
+	}
+	data, _ := os.ReadFile(manifest)
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("manifest lists %d files, want the fixture's 2:\n%s", lines, data)
+	}
+}
+
+func TestVerifyCommandTamperedBundle(t *testing.T) {
+	dir, manifest := tamperableBundle(t)
+	if err := os.WriteFile(filepath.Join(dir, "data", "questions.json"), []byte(`{"questions": []}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "data", "achievements.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "extra.html"), []byte("<p>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	code, out, _ := runCommand("verify", "-manifest", manifest, dir)
+	if code != 1 {
+		t.Fatalf("exit %d, want 1:\n%s", code, out)
+	}
+	for _, want := range []string{
+		"ERROR missing data/achievements.json",
+		"ERROR changed data/questions.json",
+		"ERROR unexpected extra.html",
+		"FAIL: 3 file(s)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestVerifyCommandBadArguments(t *testing.T) {
+	if code, _, _ := runCommand("verify", "testdata/no-such-dir"); code != 2 {
+		t.Errorf("missing directory: exit %d, want 2", code)
+	}
+	if code, _, _ := runCommand("verify", "-manifest", "testdata/no-such-file"); code != 2 {
+		t.Errorf("missing manifest: exit %d, want 2", code)
+	}
+	garbled := filepath.Join(t.TempDir(), "bad.txt")
+	os.WriteFile(garbled, []byte("not a checksum line\n"), 0o644)
+	if code, out, _ := runCommand("verify", "-manifest", garbled); code != 1 || !strings.Contains(out, "line 1") {
+		t.Errorf("garbled manifest: exit %d:\n%s", code, out)
+	}
+}
+
+func TestEmbeddedAssetsMatchChecksums(t *testing.T) {
+	code, out, _ := runCommand("verify")
+	if code != 0 {
+		t.Errorf("the embedded assets differ from %s; run go generate:\n%s", checksumsFile, out)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default compression settings: gzip's own default level, and the
+// smallest response body worth compressing; below it the encoding
+// overhead outweighs the savings.
+const (
+	defaultCompressLevel   = 6
+	defaultCompressMinSize = 1024
+)
+
+// compression gzips (or deflates) responses at level, leaving bodies
+// shorter than minSize alone. Its compressors are pooled.
+type compression struct {
+	level   int
+	minSize int
+
+	gzipWriters, flateWriters sync.Pool
+}
+
+// newCompression returns a compression at level, from 1 (fastest) to 9
+// (smallest), for bodies of at least minSize bytes.
+func newCompression(level, minSize int) *compression {
+	c := &compression{level: level, minSize: minSize}
+	c.gzipWriters.New = func() any {
+		w, _ := gzip.NewWriterLevel(io.Discard, level)
+		return w
+	}
+	c.flateWriters.New = func() any {
+		w, _ := flate.NewWriter(io.Discard, level)
+		return w
+	}
+	return c
+}
+
+// middleware transparently compresses responses from next when the client
+// accepts it and the body is large enough and not already in a compressed
+// format.
+func (c *compression) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := ""
+		switch {
+		case acceptsEncoding(r, "gzip"):
+			encoding = "gzip"
+		case acceptsEncoding(r, "deflate"):
+			encoding = "deflate"
+		}
+		// Byte ranges refer to the identity encoding, so leave those alone.
+		if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, c: c, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptsEncoding reports whether the request's Accept-Encoding header
+// allows coding with a non-zero quality, either by name or via "*".
+func acceptsEncoding(r *http.Request, coding string) bool {
+	wildcard := false
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case coding:
+			return q > 0
+		case "*":
+			wildcard = q > 0
+		}
+	}
+	return wildcard
+}
+
+// incompressible reports whether contentType is already compressed, so
+// encoding it again would only waste CPU.
+func incompressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+	switch {
+	case mediaType == "image/svg+xml":
+		return false
+	case strings.HasPrefix(mediaType, "image/"),
+		strings.HasPrefix(mediaType, "audio/"),
+		strings.HasPrefix(mediaType, "video/"),
+		mediaType == "font/woff2",
+		mediaType == "application/zip",
+		mediaType == "application/gzip":
+		return true
+	}
+	return false
+}
+
+// compressWriter buffers the start of a response until it knows whether
+// the body reaches the minimum size, then either streams it through a
+// pooled compressor or passes it through untouched.
+type compressWriter struct {
+	http.ResponseWriter
+	c        *compression
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	zw       io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.status != 0 {
+		return
+	}
+	cw.status = code
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < cw.c.minSize {
+			return len(p), nil
+		}
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if cw.zw != nil {
+		return cw.zw.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// decide writes the status line, choosing compression when large is set
+// and the response is eligible, and then drains the buffered prefix.
+func (cw *compressWriter) decide(large bool) error {
+	cw.decided = true
+	h := cw.Header()
+	if large && h.Get("Content-Encoding") == "" && !incompressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		// The encoded bytes differ from the identity representation, so a
+		// strong validator no longer applies to them.
+		if tag := h.Get("ETag"); strings.HasPrefix(tag, `"`) {
+			h.Set("ETag", "W/"+tag)
+		}
+		cw.zw = cw.c.compressor(cw.encoding, cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.zw != nil {
+		_, err = cw.zw.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush sends whatever has been written so far; a response flushed before
+// reaching the minimum size is sent uncompressed.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.decide(false)
+	}
+	if f, ok := cw.zw.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close finishes the response and returns the compressor to its pool.
+func (cw *compressWriter) Close() error {
+	if !cw.decided && cw.status != 0 {
+		if err := cw.decide(false); err != nil {
+			return err
+		}
+	}
+	if cw.zw == nil {
+		return nil
+	}
+	err := cw.zw.Close()
+	switch zw := cw.zw.(type) {
+	case *gzip.Writer:
+		cw.c.gzipWriters.Put(zw)
+	case *flate.Writer:
+		cw.c.flateWriters.Put(zw)
+	}
+	cw.zw = nil
+	return err
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// compressor takes a writer for encoding from its pool and points it at
+// w.
+func (c *compression) compressor(encoding string, w io.Writer) io.WriteCloser {
+	if encoding == "deflate" {
+		zw := c.flateWriters.Get().(*flate.Writer)
+		zw.Reset(w)
+		return zw
+	}
+	zw := c.gzipWriters.Get().(*gzip.Writer)
+	zw.Reset(w)
+	return zw
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// compressTestBody is a compressible body comfortably past the minimum
+// size.
+var compressTestBody = strings.Repeat("body { color: #123456; }\n", 200)
+
+// serveCompressed serves body with content type ctype through the default
+// compression, for a client sending Accept-Encoding accept.
+func serveCompressed(t *testing.T, body, ctype, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+	h := newCompression(defaultCompressLevel, defaultCompressMinSize).middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ctype)
+		io.WriteString(w, body)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/css/game.css", nil)
+	if accept != "" {
+		r.Header.Set("Accept-Encoding", accept)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestCompressionGzipsForGzipClients(t *testing.T) {
+	w := serveCompressed(t, compressTestBody, "text/css", "gzip, deflate")
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("Vary = %q, want Accept-Encoding", got)
+	}
+	if w.Body.Len() >= len(compressTestBody) {
+		t.Errorf("compressed body is %d bytes, no smaller than the original %d", w.Body.Len(), len(compressTestBody))
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != compressTestBody {
+		t.Error("gzipped body does not decompress to the original")
+	}
+}
+
+func TestCompressionDeflate(t *testing.T) {
+	w := serveCompressed(t, compressTestBody, "text/css", "deflate")
+	if got := w.Header().Get("Content-Encoding"); got != "deflate" {
+		t.Fatalf("Content-Encoding = %q, want deflate", got)
+	}
+	got, err := io.ReadAll(flate.NewReader(w.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != compressTestBody {
+		t.Error("deflated body does not decompress to the original")
+	}
+}
+
+func TestCompressionLeavesPlainClientsAlone(t *testing.T) {
+	for _, accept := range []string{"", "identity", "gzip;q=0"} {
+		w := serveCompressed(t, compressTestBody, "text/css", accept)
+		if got := w.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("Accept-Encoding %q: Content-Encoding = %q, want none", accept, got)
+		}
+		if w.Body.String() != compressTestBody {
+			t.Errorf("Accept-Encoding %q: body was altered", accept)
+		}
+		if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: Vary = %q, want Accept-Encoding", accept, got)
+		}
+	}
+}
+
+func TestCompressionSkipsSmallAndCompressedBodies(t *testing.T) {
+	tests := []struct {
+		name, body, ctype string
+	}{
+		{"small body", "body{}", "text/css"},
+		{"png", compressTestBody, "image/png"},
+		{"icon", compressTestBody, "image/x-icon"},
+	}
+	for _, tt := range tests {
+		w := serveCompressed(t, tt.body, tt.ctype, "gzip")
+		if got := w.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("%s: Content-Encoding = %q, want none", tt.name, got)
+		}
+		if w.Body.String() != tt.body {
+			t.Errorf("%s: body was altered", tt.name)
+		}
+	}
+}
+
+func TestCompressionPoolReuse(t *testing.T) {
+	c := newCompression(defaultCompressLevel, defaultCompressMinSize)
+	h := c.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, compressTestBody)
+	}))
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		zr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		got, _ := io.ReadAll(zr)
+		if !bytes.Equal(got, []byte(compressTestBody)) {
+			t.Fatalf("request %d: a reused compressor garbled the body", i)
+		}
+	}
+}
+
+func BenchmarkCompression(b *testing.B) {
+	h := newCompression(defaultCompressLevel, defaultCompressMinSize).middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		io.WriteString(w, compressTestBody)
+	}))
+	for _, accept := range []string{"identity", "gzip"} {
+		b.Run(accept, func(b *testing.B) {
+			r := httptest.NewRequest(http.MethodGet, "/css/game.css", nil)
+			r.Header.Set("Accept-Encoding", accept)
+			b.SetBytes(int64(len(compressTestBody)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				h.ServeHTTP(httptest.NewRecorder(), r)
+			}
+		})
+	}
+}
+
+func TestServerCompressesHelpAndAssets(t *testing.T) {
+	s := startServer(t)
+	for _, path := range []string{"/help", "/css/game.css"} {
+		req, _ := http.NewRequest(http.MethodGet, s.url(path), nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+			t.Errorf("%s: Content-Encoding = %q, want gzip", path, got)
+		}
+	}
+}
+
+func TestCompressionMinSizeConfigurable(t *testing.T) {
+	body := strings.Repeat("a", 100)
+	for _, tt := range []struct {
+		minSize int
+		want    string
+	}{
+		{101, ""},
+		{100, "gzip"},
+		{1, "gzip"},
+	} {
+		h := newCompression(defaultCompressLevel, tt.minSize).middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, body)
+		}))
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Header().Get("Content-Encoding"); got != tt.want {
+			t.Errorf("minimum %d for a 100-byte body: Content-Encoding = %q, want %q", tt.minSize, got, tt.want)
+		}
+	}
+}
+
+func TestCompressionLevel(t *testing.T) {
+	size := func(level int) int {
+		h := newCompression(level, 1).middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, compressTestBody+strings.Repeat("x = 1; y = 2; z = 3;\n", 500))
+		}))
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Body.Len()
+	}
+	if fast, small := size(1), size(9); small > fast {
+		t.Errorf("level 9 gave %d bytes, more than level 1's %d", small, fast)
+	}
+}
+
+func TestCompressConfigValidated(t *testing.T) {
+	for _, args := range [][]string{
+		{"-compress-level", "0"},
+		{"-compress-level", "10"},
+		{"-compress-min-bytes", "-1"},
+	} {
+		_, err := loadConfig(args, noEnv)
+		if err == nil || !strings.Contains(err.Error(), strings.TrimPrefix(args[0], "-")) {
+			t.Errorf("%v: err = %v, want it rejected at startup", args, err)
+		}
+	}
+	cfg, err := loadConfig(nil, noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CompressLevel != defaultCompressLevel || cfg.CompressMinBytes != defaultCompressMinSize {
+		t.Errorf("defaults: level %d, minimum %d", cfg.CompressLevel, cfg.CompressMinBytes)
+	}
+}
+
+func TestServerHonoursCompressMinBytes(t *testing.T) {
+	encodingFrom := func(s *runningServer) string {
+		req, _ := http.NewRequest(http.MethodGet, s.url("/api/questions?count=50"), nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.Header.Get("Content-Encoding")
+	}
+	s := startServer(t)
+	if got := encodingFrom(s); got != "gzip" {
+		t.Errorf("default threshold: Content-Encoding = %q, want gzip", got)
+	}
+	s.stop(t)
+	if got := encodingFrom(startServer(t, "-compress-min-bytes", "100000000")); got != "" {
+		t.Errorf("below a raised threshold: Content-Encoding = %q, want none", got)
+	}
+}
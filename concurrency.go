@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// concurrencyRetryAfter is how soon a client turned away by the
+// concurrency limiter is told to try again; slots free up as fast as the
+// requests holding them finish.
+const concurrencyRetryAfter = time.Second
+
+// Weights of the expensive routes against ConcurrencyLimits.Global,
+// roughly how much CPU a request takes. The global cap must be at least
+// maxConcurrencyWeight, or the heaviest route could never run.
+const (
+	searchWeight = 1
+	statsWeight  = 2
+	iconWeight   = 4
+
+	maxConcurrencyWeight = iconWeight
+)
+
+// ConcurrencyLimits bound how many expensive requests run at once. A zero
+// value switches its limit off.
+type ConcurrencyLimits struct {
+	// Global is the total weight of expensive requests in flight.
+	Global int
+	// PerIP is how many expensive requests one client IP may have in
+	// flight.
+	PerIP int
+	// Search, Stats, and Icons cap the requests in flight to each route.
+	Search int
+	Stats  int
+	Icons  int
+}
+
+// concurrencyLimiter sheds expensive requests the server has no room for
+// with 503 Service Unavailable, rather than queueing them. Unlike
+// rateLimiter, it counts requests in flight, not requests made.
+type concurrencyLimiter struct {
+	global  *semaphore.Weighted // nil without a cap
+	perIP   int
+	proxies trustedProxies
+
+	mu      sync.Mutex
+	clients map[string]int // client IP -> requests in flight
+}
+
+func newConcurrencyLimiter(limits ConcurrencyLimits, proxies trustedProxies) *concurrencyLimiter {
+	c := &concurrencyLimiter{perIP: limits.PerIP, proxies: proxies, clients: make(map[string]int)}
+	if limits.Global > 0 {
+		c.global = semaphore.NewWeighted(int64(limits.Global))
+	}
+	return c
+}
+
+// acquireIP takes one of the slots of client ip, reporting whether one
+// was free.
+func (c *concurrencyLimiter) acquireIP(ip string) bool {
+	if c.perIP <= 0 {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.clients[ip] >= c.perIP {
+		return false
+	}
+	c.clients[ip]++
+	return true
+}
+
+func (c *concurrencyLimiter) releaseIP(ip string) {
+	if c.perIP <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.clients[ip]--; c.clients[ip] <= 0 {
+		delete(c.clients, ip)
+	}
+}
+
+// route returns a middleware that lets at most limit requests at once
+// into the handlers it wraps, together, each weighing weight against the
+// global cap; a limit of zero leaves the route to the global and per-IP
+// caps alone. Routes that serve the same work in different forms share
+// one middleware, and so one limit.
+func (c *concurrencyLimiter) route(limit int, weight int64) func(http.Handler) http.Handler {
+	var own *semaphore.Weighted
+	if limit > 0 {
+		own = semaphore.NewWeighted(int64(limit))
+	}
+	return func(next http.Handler) http.Handler {
+		return c.admit(own, weight, next)
+	}
+}
+
+// admit lets a request into next if the client, own (unless nil), and the
+// global cap all have room for it.
+func (c *concurrencyLimiter) admit(own *semaphore.Weighted, weight int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r, c.proxies)
+		if !c.acquireIP(ip) {
+			c.reject(w)
+			return
+		}
+		defer c.releaseIP(ip)
+		if own != nil {
+			if !own.TryAcquire(1) {
+				c.reject(w)
+				return
+			}
+			defer own.Release(1)
+		}
+		if c.global != nil {
+			if !c.global.TryAcquire(weight) {
+				c.reject(w)
+				return
+			}
+			defer c.global.Release(weight)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reject turns a request away for want of capacity.
+func (c *concurrencyLimiter) reject(w http.ResponseWriter) {
+	writeRetryAfter(w, http.StatusServiceUnavailable, "server busy, try again shortly", concurrencyRetryAfter)
+}
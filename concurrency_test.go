@@ -0,0 +1,176 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// gate is a handler that holds every request until it is opened,
+// announcing each one it holds on entered.
+type gate struct {
+	entered chan struct{}
+	open    chan struct{}
+}
+
+func newGate() *gate {
+	return &gate{entered: make(chan struct{}, 100), open: make(chan struct{})}
+}
+
+func (g *gate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.entered <- struct{}{}
+	<-g.open
+	w.WriteHeader(http.StatusOK)
+}
+
+// flood sends n concurrent requests from the client IPs ips, in turn,
+// to h, and returns their status codes once the first held ones have
+// entered and g has been opened.
+func flood(t *testing.T, h http.Handler, g *gate, ips []string, n, held int) map[int]int {
+	t.Helper()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		statuses = map[int]int{}
+		rejected = make(chan struct{}, n)
+	)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = ips[i%len(ips)] + ":1234"
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code == http.StatusServiceUnavailable {
+				if w.Header().Get("Retry-After") == "" {
+					t.Error("503 without Retry-After")
+				}
+				rejected <- struct{}{}
+			}
+			mu.Lock()
+			statuses[w.Code]++
+			mu.Unlock()
+		}()
+	}
+	// Every request is either held or turned away before the gate opens.
+	for range held {
+		<-g.entered
+	}
+	for range n - held {
+		<-rejected
+	}
+	close(g.open)
+	wg.Wait()
+	return statuses
+}
+
+func TestConcurrencyRouteCap(t *testing.T) {
+	c := newConcurrencyLimiter(ConcurrencyLimits{}, nil)
+	g := newGate()
+	statuses := flood(t, c.route(3, searchWeight)(g), g, []string{"192.0.2.1", "192.0.2.2"}, 8, 3)
+	if statuses[http.StatusOK] != 3 || statuses[http.StatusServiceUnavailable] != 5 {
+		t.Errorf("statuses %v, want 3 served and 5 turned away", statuses)
+	}
+}
+
+func TestConcurrencyRouteCapShared(t *testing.T) {
+	c := newConcurrencyLimiter(ConcurrencyLimits{}, nil)
+	g := newGate()
+	// Two routes wrapped by one middleware, as /stats and /stats.csv are,
+	// share its cap of 2.
+	limit := c.route(2, statsWeight)
+	page, csv := limit(g), limit(g)
+	done := make(chan int, 2)
+	for _, h := range []http.Handler{page, csv} {
+		go func() {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+			done <- w.Code
+		}()
+	}
+	<-g.entered
+	<-g.entered
+	for _, h := range []http.Handler{page, csv} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("with the shared cap full: status %d, want 503", w.Code)
+		}
+	}
+	close(g.open)
+	for range 2 {
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("a request within the cap: status %d", code)
+		}
+	}
+}
+
+func TestConcurrencyPerIPCap(t *testing.T) {
+	c := newConcurrencyLimiter(ConcurrencyLimits{PerIP: 2}, nil)
+	g := newGate()
+	statuses := flood(t, c.route(0, searchWeight)(g), g, []string{"192.0.2.1"}, 5, 2)
+	if statuses[http.StatusOK] != 2 || statuses[http.StatusServiceUnavailable] != 3 {
+		t.Errorf("statuses %v, want 2 served and 3 turned away", statuses)
+	}
+	if len(c.clients) != 0 {
+		t.Errorf("slots left held after every request finished: %v", c.clients)
+	}
+}
+
+func TestConcurrencyGlobalWeights(t *testing.T) {
+	c := newConcurrencyLimiter(ConcurrencyLimits{Global: 8}, nil)
+	g := newGate()
+	// Two icon renders fill the global cap, shutting out searches too.
+	icons := c.route(0, iconWeight)(g)
+	search := c.route(0, searchWeight)(g)
+	done := make(chan int, 2)
+	for range 2 {
+		go func() {
+			w := httptest.NewRecorder()
+			icons.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/icon", nil))
+			done <- w.Code
+		}()
+	}
+	<-g.entered
+	<-g.entered
+	w := httptest.NewRecorder()
+	search.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/search", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("search with the cap full: status %d, want 503", w.Code)
+	}
+	close(g.open)
+	for range 2 {
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("icon render: status %d", code)
+		}
+	}
+	w = httptest.NewRecorder()
+	g.open = make(chan struct{})
+	close(g.open)
+	search.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/search", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("search after the renders finished: status %d, want 200", w.Code)
+	}
+}
+
+func TestConcurrencyConfigValidated(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-concurrency-search", "-1"}, "must not be negative"},
+		{[]string{"-concurrency", "3"}, "at least 4"},
+		{[]string{"-concurrency", "0", "-concurrency-per-ip", "0"}, ""},
+	} {
+		_, err := loadConfig(tt.args, noEnv)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%v: %v", tt.args, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%v: error %v, want one containing %q", tt.args, err, tt.want)
+		}
+	}
+}
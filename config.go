@@ -0,0 +1,884 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is prepended to a setting's upper-cased flag name to form its
+// environment variable, e.g. -rate-limit becomes LOBELABYRINTH_RATE_LIMIT.
+const envPrefix = "LOBELABYRINTH_"
+
+// Config holds every server setting. Each field is bound to a command-line
+// flag; the same name is used as the key in a config file and, prefixed
+// with envPrefix, as an environment variable.
+type Config struct {
+	// ConfigFile is the optional JSON or YAML file settings are read from.
+	ConfigFile string
+
+	// Addr is the host:port to listen on.
+	Addr string
+	// PortFallback is how many following ports to try when Addr's port
+	// is already in use. Zero fails immediately.
+	PortFallback int
+	// UnixSocket, when set, is the path of a Unix domain socket to listen
+	// on instead of Addr.
+	UnixSocket string
+	// H2C serves HTTP/2 without TLS ("h2c", by prior knowledge or an
+	// Upgrade) alongside HTTP/1.1, for proxies that speak it.
+	H2C bool
+	// BasePath is the URL path prefix the app is served under, such as
+	// /games/labyrinth when a reverse proxy forwards that subpath. Empty
+	// serves from the root.
+	BasePath string
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout, and IdleTimeout are
+	// applied to every connection so slow clients cannot tie the server
+	// up. Zero disables a limit.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout bounds how long in-flight requests may take to
+	// finish once a shutdown signal arrives.
+	ShutdownTimeout time.Duration
+	// Store selects where the leaderboard and saved games are kept:
+	// "file", under StoreDir, "sqlite", in the database StoreDSN (by
+	// default a file in StoreDir), or "memory".
+	Store    string
+	StoreDir string
+	StoreDSN string
+	// EventsSink selects where analytics events from /api/events go:
+	// "file", appended to EventsFile (by default a file in StoreDir),
+	// "log", or "off".
+	EventsSink string
+	EventsFile string
+	// Tenants names an optional JSON or YAML file of further game
+	// instances served from the same process, each picked by the Host of
+	// a request. Requests for any other host get the instance configured
+	// here.
+	Tenants string
+	// Dev serves content straight from disk: caching is disabled and the
+	// help page is re-rendered on every request.
+	Dev bool
+	// ContentDir names a directory whose game data, maps, stylesheets,
+	// manifest, question media, and READMEs replace the built-in ones file
+	// by file. Files it lacks are served as built in.
+	ContentDir string
+	// VerifyAssets checks the embedded assets against their committed
+	// checksums at startup, refusing to serve a bundle that differs.
+	VerifyAssets bool
+
+	// TLSCert and TLSKey name a certificate/key pair to serve HTTPS with.
+	TLSCert, TLSKey string
+	// AutocertDomain enables automatic Let's Encrypt certificates for the
+	// named domain, cached in AutocertCacheDir. Plain HTTP requests on
+	// RedirectAddr are answered with ACME challenges or a redirect.
+	AutocertDomain   string
+	AutocertCacheDir string
+	RedirectAddr     string
+
+	// RateLimit and RateBurst bound how fast each client IP may call the
+	// API, in requests per second.
+	RateLimit float64
+	RateBurst int
+	// TrustedProxies is a comma-separated list of CIDR prefixes of the
+	// reverse proxies whose X-Forwarded-For entries give the client IP.
+	TrustedProxies string
+	// CORSOrigins is a comma-separated list of origins allowed to call
+	// the API from another site.
+	CORSOrigins string
+
+	// AdminUser and AdminPassword are the HTTP Basic credentials for the
+	// admin API, which is disabled while AdminPassword is empty.
+	AdminUser     string
+	AdminPassword string
+	// PublicStats serves the question statistics at /api/stats to
+	// everyone; otherwise they are part of the admin API.
+	PublicStats bool
+
+	// FrameAncestors is a comma-separated list of the origins allowed to
+	// embed the game in a frame, such as a school's learning platform.
+	// When empty, no site may.
+	FrameAncestors string
+	// CSP is the Content-Security-Policy sent with every response, with
+	// inlineScriptsToken expanded. Empty omits the header.
+	CSP string
+
+	// AssetTypes is a comma-separated list of .ext=type entries adding to
+	// or overriding the content types static files are served with.
+	AssetTypes string
+	// IndexFile is the page served for the root and for any directory
+	// holding one. Other directories are 404 Not Found, not listed.
+	IndexFile string
+
+	// SessionSecret keys the HMAC on session tokens and score
+	// signatures. When empty a random secret is generated at startup.
+	SessionSecret string
+
+	// OAuthGitHubClientID and OAuthGitHubClientSecret let players sign in
+	// with GitHub, and OAuthGoogleClientID and OAuthGoogleClientSecret
+	// with Google, for a profile that follows them across devices. With
+	// neither, and by default, play is anonymous only.
+	OAuthGitHubClientID     string
+	OAuthGitHubClientSecret string
+	OAuthGoogleClientID     string
+	OAuthGoogleClientSecret string
+
+	// RoomCapacity is how many players a multiplayer room holds.
+	// MatchmakeTimeout is how long /api/matchmake waits for a room to fill
+	// before starting it with the players it has, possibly just one.
+	// RoomCodeTTL is how long the code of a private room stays reserved
+	// while no one is in the room.
+	RoomCapacity     int
+	MatchmakeTimeout time.Duration
+	RoomCodeTTL      time.Duration
+
+	// DrainGrace is how long multiplayer players are given to finish once
+	// shutdown starts, before their connections are closed. It is cut to
+	// fit within ShutdownTimeout.
+	DrainGrace time.Duration
+
+	// WSCompress has multiplayer messages of at least WSCompressMinBytes
+	// sent compressed to clients that negotiate permessage-deflate.
+	WSCompress         bool
+	WSCompressMinBytes int
+
+	// Features switches optional parts of the game on or off.
+	Features FeatureFlags
+
+	// ChatBlocklist names a file of words, one per line, that are masked
+	// in multiplayer chat. Empty disables the filter.
+	ChatBlocklist string
+	// NameBlocklist names a file of words, in the same format, that
+	// display names may not contain. Empty uses ChatBlocklist.
+	NameBlocklist string
+
+	// Experiments names a JSON file of experiments comparing phrasings of
+	// questions. Empty runs none.
+	Experiments string
+
+	// HintPenalty is how many points each hint from /api/hint costs.
+	HintPenalty int
+
+	// Scoring is how the server scores a session's answers; see
+	// ScoringRules.
+	Scoring ScoringRules
+
+	// Selection is how /api/questions weighs the questions it draws; see
+	// SelectionWeights.
+	Selection SelectionWeights
+
+	// MinRunTime is the shortest game, from session start to score
+	// submission, that the leaderboard accepts.
+	MinRunTime time.Duration
+
+	// AntiCheat holds leaderboard submissions to plausible paces and rates.
+	AntiCheat AntiCheatLimits
+
+	// Concurrency bounds the search, stats, and icon requests in flight.
+	Concurrency ConcurrencyLimits
+
+	// WebhookURLs is a comma-separated list of URLs that are sent achievement
+	// unlocks and top leaderboard scores. WebhookSecret, when set, signs
+	// each delivery.
+	WebhookURLs   string
+	WebhookSecret string
+
+	// Outbound bounds webhook deliveries, requests to OAuth providers,
+	// and emails.
+	Outbound OutboundTimeouts
+
+	// Mail sends an email when a score enters the top of the leaderboard.
+	Mail MailSettings
+
+	// AppName, AppShortName, ThemeColor, BackgroundColor, StartURL, and
+	// Scope override the matching members of the web app manifest. Empty
+	// values keep those in the embedded manifest.json.
+	AppName         string
+	AppShortName    string
+	ThemeColor      string
+	BackgroundColor string
+	StartURL        string
+	Scope           string
+
+	// Theme is the palette the game's stylesheets are drawn in, which
+	// /theme.css sets.
+	Theme ThemeColors
+
+	// PublicURL is the scheme and host the server is reached at from
+	// outside, such as https://labyrinth.example.com, used for the
+	// absolute URLs in /robots.txt and /sitemap.xml. When empty they are
+	// taken from each request.
+	PublicURL string
+	// RobotsDisallow is a comma-separated list of paths, relative to the
+	// base path, that /robots.txt asks crawlers to stay out of.
+	RobotsDisallow string
+
+	// CompressLevel is the gzip and deflate level for responses, from 1
+	// (fastest) to 9 (smallest). Responses shorter than CompressMinBytes
+	// are sent uncompressed.
+	CompressLevel    int
+	CompressMinBytes int
+
+	// MaxBodyBytes bounds API request bodies, apart from the backup
+	// restore and question import uploads, which have limits of their own.
+	MaxBodyBytes int64
+
+	// IconCacheBytes is the memory budget for rendered manifest icons,
+	// which are evicted least recently used first. 0 renders every
+	// request afresh.
+	IconCacheBytes int64
+
+	// Metrics exposes Prometheus metrics at /metrics.
+	Metrics bool
+	// Pprof serves runtime profiles under /debug/pprof/ behind the admin
+	// credentials, which it requires.
+	Pprof bool
+
+	// OTLPEndpoint is the OTLP/HTTP URL traces are exported to, e.g.
+	// http://collector:4318/v1/traces. Tracing is off when it is empty.
+	// TraceSampleRate is the fraction of new traces recorded; requests
+	// carrying a sampled trace context are always recorded.
+	OTLPEndpoint    string
+	TraceSampleRate float64
+
+	// LogLevel and LogFormat configure the application logger.
+	LogLevel  string
+	LogFormat string
+
+	// LogStaticRate keeps one in LogStaticRate access-log lines for static
+	// files served successfully. Requests taking LogSlow or longer are
+	// always logged, as are API requests and errors.
+	LogStaticRate int
+	LogSlow       time.Duration
+
+	// AccessLog, if set, is where a JSON Lines access log is written: a
+	// file, or "-" for stdout. A file is rotated once it reaches
+	// AccessLogMaxSize megabytes, keeping AccessLogBackups old files.
+	AccessLog        string
+	AccessLogMaxSize int
+	AccessLogBackups int
+}
+
+// defaultConfig returns the settings used when nothing overrides them.
+func defaultConfig() *Config {
+	return &Config{
+		Addr:               ":8080",
+		ReadHeaderTimeout:  5 * time.Second,
+		ReadTimeout:        30 * time.Second,
+		WriteTimeout:       60 * time.Second,
+		IdleTimeout:        2 * time.Minute,
+		ShutdownTimeout:    10 * time.Second,
+		Outbound:           OutboundTimeouts{Dial: 5 * time.Second, TLSHandshake: 5 * time.Second, ResponseHeader: 10 * time.Second, Request: 15 * time.Second},
+		Mail:               MailSettings{Port: 587, TopRank: 1},
+		Store:              "file",
+		StoreDir:           "store",
+		EventsSink:         "file",
+		AutocertCacheDir:   "autocert-cache",
+		IndexFile:          "index.html",
+		RedirectAddr:       ":80",
+		RateLimit:          5,
+		RateBurst:          20,
+		AdminUser:          "admin",
+		RoomCapacity:       4,
+		MatchmakeTimeout:   15 * time.Second,
+		RoomCodeTTL:        10 * time.Minute,
+		DrainGrace:         5 * time.Second,
+		WSCompress:         true,
+		WSCompressMinBytes: 512,
+		Features:           FeatureFlags{Multiplayer: true, Daily: true, Hints: true},
+		HintPenalty:        10,
+		Scoring:            ScoringRules{TimeBonus: 50, TimeBonusWindow: 10 * time.Second, StreakStep: 0.1, StreakMax: 2, TimeLimitGrace: 2 * time.Second},
+		Selection:          SelectionWeights{Freshness: 1, Difficulty: 4, ExposureHalfLife: time.Hour},
+		MinRunTime:         30 * time.Second,
+		AntiCheat:          AntiCheatLimits{MinAnswerTime: 500 * time.Millisecond, FastAccuracy: 0.95, FastAnswerTime: 1500 * time.Millisecond, MaxSubmissions: 20},
+		Theme:              ThemeColors{Primary: "#8B4513", Accent: "#FFFF00", Background: "#B8B8B8", Panel: "#C0C0C0", Text: "#000000"},
+		Concurrency:        ConcurrencyLimits{Global: 32, PerIP: 4, Search: 16, Stats: 4, Icons: 4},
+		RobotsDisallow:     "/api/,/admin/",
+		CompressLevel:      defaultCompressLevel,
+		CompressMinBytes:   defaultCompressMinSize,
+		MaxBodyBytes:       defaultMaxBodyBytes,
+		IconCacheBytes:     defaultIconCacheBytes,
+		TraceSampleRate:    1,
+		CSP:                defaultCSP,
+		LogLevel:           "info",
+		LogFormat:          "json",
+		LogStaticRate:      1,
+		LogSlow:            time.Second,
+		AccessLogMaxSize:   100,
+		AccessLogBackups:   5,
+	}
+}
+
+// flagSet binds a flag for every setting to the corresponding field of
+// cfg, using the field's current value as the default.
+func (cfg *Config) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("lobelabyrinth", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: lobelabyrinth [flags]\n\n"+
+			"Each flag may also be set as %s<FLAG> in the environment or as a key in the -config file.\n\n", envPrefix)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cfg.ConfigFile, "config", cfg.ConfigFile, "JSON or YAML config file to read settings from")
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "address to listen on, as host:port")
+	fs.IntVar(&cfg.PortFallback, "port-fallback", cfg.PortFallback, "if the port is busy, try up to this many following ports (0 disables)")
+	fs.StringVar(&cfg.UnixSocket, "unix-socket", cfg.UnixSocket, "listen on this Unix domain socket instead of -addr")
+	fs.BoolVar(&cfg.H2C, "h2c", cfg.H2C, "also serve cleartext HTTP/2 (h2c) on the plain HTTP listener")
+	fs.StringVar(&cfg.BasePath, "base-path", cfg.BasePath, "URL path prefix to serve the app under, e.g. /games/labyrinth")
+	fs.DurationVar(&cfg.ReadHeaderTimeout, "read-header-timeout", cfg.ReadHeaderTimeout, "how long a client may take to send request headers")
+	fs.DurationVar(&cfg.ReadTimeout, "read-timeout", cfg.ReadTimeout, "how long a client may take to send a whole request (0 for no limit)")
+	fs.DurationVar(&cfg.WriteTimeout, "write-timeout", cfg.WriteTimeout, "how long writing a response may take (0 for no limit)")
+	fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", cfg.IdleTimeout, "how long an idle keep-alive connection is kept open (0 for no limit)")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "how long to wait for in-flight requests to finish on shutdown")
+	fs.StringVar(&cfg.Store, "store", cfg.Store, "where the leaderboard and saved games are kept: file, sqlite, or memory")
+	fs.StringVar(&cfg.StoreDir, "store-dir", cfg.StoreDir, "directory the file store writes to")
+	fs.StringVar(&cfg.EventsSink, "events-sink", cfg.EventsSink, "where analytics events go: file, log, or off")
+	fs.StringVar(&cfg.EventsFile, "events-file", cfg.EventsFile, "JSON lines file the file events sink appends to (default events.jsonl in store-dir)")
+	fs.StringVar(&cfg.Tenants, "tenants", cfg.Tenants, "JSON or YAML file of tenants, game instances served by Host header")
+	fs.StringVar(&cfg.StoreDSN, "store-dsn", cfg.StoreDSN, "SQLite database for the sqlite store (default "+sqliteFile+" in store-dir)")
+	fs.BoolVar(&cfg.Dev, "dev", cfg.Dev, "serve assets and README from the working directory instead of the embedded copy")
+	fs.StringVar(&cfg.ContentDir, "content-dir", cfg.ContentDir, "directory of questions, achievements, maps, stylesheets, and READMEs served in place of the built-in ones")
+	fs.BoolVar(&cfg.VerifyAssets, "verify-assets", cfg.VerifyAssets, "check the embedded assets against "+checksumsFile+" at startup")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", cfg.TLSCert, "TLS certificate file; serve HTTPS when set together with -tls-key")
+	fs.StringVar(&cfg.TLSKey, "tls-key", cfg.TLSKey, "TLS private key file")
+	fs.StringVar(&cfg.AutocertDomain, "autocert-domain", cfg.AutocertDomain, "obtain certificates for this domain from Let's Encrypt")
+	fs.StringVar(&cfg.AutocertCacheDir, "autocert-cache", cfg.AutocertCacheDir, "directory Let's Encrypt certificates are cached in")
+	fs.StringVar(&cfg.RedirectAddr, "redirect-addr", cfg.RedirectAddr, "plain HTTP address that redirects to HTTPS in -autocert-domain mode")
+	fs.Float64Var(&cfg.RateLimit, "rate-limit", cfg.RateLimit, "API requests per second allowed per client IP")
+	fs.IntVar(&cfg.RateBurst, "rate-burst", cfg.RateBurst, "API request burst allowed per client IP")
+	fs.StringVar(&cfg.TrustedProxies, "trusted-proxies", cfg.TrustedProxies, "comma-separated CIDR prefixes of proxies whose X-Forwarded-For is believed, e.g. 10.0.0.0/8")
+	fs.StringVar(&cfg.CORSOrigins, "cors-origins", cfg.CORSOrigins, "comma-separated origins allowed to call the API cross-site (\"*\" for any, without credentials)")
+	fs.StringVar(&cfg.AdminUser, "admin-user", cfg.AdminUser, "user name for the admin API")
+	fs.StringVar(&cfg.AdminPassword, "admin-password", cfg.AdminPassword, "password for the admin API (disabled when empty; at least 12 characters)")
+	fs.BoolVar(&cfg.PublicStats, "public-stats", cfg.PublicStats, "serve question statistics at /api/stats without admin credentials")
+	fs.StringVar(&cfg.AssetTypes, "asset-types", cfg.AssetTypes, "comma-separated .ext=type entries overriding the content types of static files, e.g. .wasm=application/wasm")
+	fs.StringVar(&cfg.IndexFile, "index-file", cfg.IndexFile, "page served for the root and for directories holding one")
+	fs.StringVar(&cfg.FrameAncestors, "allow-frame-ancestors", cfg.FrameAncestors, "comma-separated origins allowed to embed the game in a frame, e.g. https://lms.example.edu (empty denies framing)")
+	fs.StringVar(&cfg.CSP, "csp", cfg.CSP, "Content-Security-Policy header; "+inlineScriptsToken+" expands to the hashes of the pages' inline scripts, empty disables")
+	fs.StringVar(&cfg.SessionSecret, "session-secret", cfg.SessionSecret, "secret used to sign session tokens (at least 16 bytes; random if unset)")
+	fs.StringVar(&cfg.OAuthGitHubClientID, "oauth-github-client-id", cfg.OAuthGitHubClientID, "GitHub OAuth app client ID; enables signing in with GitHub")
+	fs.StringVar(&cfg.OAuthGitHubClientSecret, "oauth-github-client-secret", cfg.OAuthGitHubClientSecret, "GitHub OAuth app client secret")
+	fs.StringVar(&cfg.OAuthGoogleClientID, "oauth-google-client-id", cfg.OAuthGoogleClientID, "Google OAuth client ID; enables signing in with Google")
+	fs.StringVar(&cfg.OAuthGoogleClientSecret, "oauth-google-client-secret", cfg.OAuthGoogleClientSecret, "Google OAuth client secret")
+	fs.IntVar(&cfg.RoomCapacity, "room-capacity", cfg.RoomCapacity, "players per multiplayer room")
+	fs.DurationVar(&cfg.MatchmakeTimeout, "matchmake-timeout", cfg.MatchmakeTimeout, "how long matchmaking waits for a room to fill before starting it")
+	fs.DurationVar(&cfg.RoomCodeTTL, "room-code-ttl", cfg.RoomCodeTTL, "how long a private room's code stays reserved while the room is empty")
+	fs.DurationVar(&cfg.DrainGrace, "drain-grace", cfg.DrainGrace, "how long multiplayer players get to finish when the server shuts down")
+	fs.BoolVar(&cfg.WSCompress, "ws-compress", cfg.WSCompress, "compress multiplayer WebSocket messages for clients that support permessage-deflate")
+	fs.IntVar(&cfg.WSCompressMinBytes, "ws-compress-min-bytes", cfg.WSCompressMinBytes, "smallest multiplayer message worth compressing")
+	fs.BoolVar(&cfg.Features.Multiplayer, "feature-multiplayer", cfg.Features.Multiplayer, "enable multiplayer rooms and matchmaking")
+	fs.BoolVar(&cfg.Features.Daily, "feature-daily", cfg.Features.Daily, "enable the daily challenge")
+	fs.BoolVar(&cfg.Features.Hints, "feature-hints", cfg.Features.Hints, "enable hints")
+	fs.StringVar(&cfg.ChatBlocklist, "chat-blocklist", cfg.ChatBlocklist, "file of words (one per line) masked in multiplayer chat")
+	fs.StringVar(&cfg.NameBlocklist, "name-blocklist", cfg.NameBlocklist, "file of words (one per line) display names may not contain (default the chat blocklist)")
+	fs.StringVar(&cfg.Experiments, "experiments", cfg.Experiments, "JSON file of experiments serving sessions alternative question wordings")
+	fs.IntVar(&cfg.HintPenalty, "hint-penalty", cfg.HintPenalty, "points deducted from a session's score per hint")
+	fs.IntVar(&cfg.Scoring.TimeBonus, "time-bonus", cfg.Scoring.TimeBonus, "most bonus points a correct answer earns for speed")
+	fs.DurationVar(&cfg.Scoring.TimeBonusWindow, "time-bonus-window", cfg.Scoring.TimeBonusWindow, "time after a question is served within which its answer earns a speed bonus")
+	fs.Float64Var(&cfg.Scoring.StreakStep, "streak-step", cfg.Scoring.StreakStep, "multiplier added to a correct answer's points for each correct answer in a row before it")
+	fs.Float64Var(&cfg.Scoring.StreakMax, "streak-max", cfg.Scoring.StreakMax, "largest streak multiplier")
+	fs.IntVar(&cfg.Scoring.MaxAttempts, "max-answer-attempts", cfg.Scoring.MaxAttempts, "wrong answers a session may give to a question before it is locked out of it (0 scores only the first answer)")
+	fs.BoolVar(&cfg.Scoring.RevealLocked, "reveal-locked-explanation", cfg.Scoring.RevealLocked, "reveal a question's explanation once a session is locked out of it")
+	fs.BoolVar(&cfg.Scoring.EnforceTimeLimits, "enforce-time-limits", cfg.Scoring.EnforceTimeLimits, "score answers that arrive after their question's time limit as timed out, earning nothing")
+	fs.DurationVar(&cfg.Scoring.TimeLimitGrace, "time-limit-grace", cfg.Scoring.TimeLimitGrace, "time allowed past a question's time limit for network latency")
+	fs.StringVar(&cfg.Scoring.DifficultyTimeLimits, "difficulty-time-limits", cfg.Scoring.DifficultyTimeLimits, "comma-separated difficulty=duration time limits of questions without their own, e.g. easy=30s,hard=20s")
+	fs.Float64Var(&cfg.Selection.Freshness, "selection-freshness", cfg.Selection.Freshness, "how strongly questions served lately, to anyone, are drawn less often (0 ignores it)")
+	fs.Float64Var(&cfg.Selection.Difficulty, "selection-difficulty", cfg.Selection.Difficulty, "how strongly questions away from a session's target difficulty are drawn less often (0 ignores it)")
+	fs.DurationVar(&cfg.Selection.ExposureHalfLife, "exposure-half-life", cfg.Selection.ExposureHalfLife, "time over which how often a question has been served counts for half as much")
+	fs.DurationVar(&cfg.MinRunTime, "min-run-time", cfg.MinRunTime, "reject leaderboard scores from sessions shorter than this")
+	fs.DurationVar(&cfg.AntiCheat.MinAnswerTime, "cheat-min-answer-time", cfg.AntiCheat.MinAnswerTime, "quarantine leaderboard runs whose median answer time is shorter (0 disables)")
+	fs.Float64Var(&cfg.AntiCheat.FastAccuracy, "cheat-fast-accuracy", cfg.AntiCheat.FastAccuracy, "quarantine runs at least this accurate (0-1) that are also faster than cheat-fast-answer-time (0 disables)")
+	fs.DurationVar(&cfg.AntiCheat.FastAnswerTime, "cheat-fast-answer-time", cfg.AntiCheat.FastAnswerTime, "median answer time under which a run at cheat-fast-accuracy is quarantined")
+	fs.IntVar(&cfg.AntiCheat.MaxSubmissions, "cheat-max-submissions", cfg.AntiCheat.MaxSubmissions, "leaderboard submissions allowed per IP address an hour (0 disables)")
+	fs.IntVar(&cfg.Concurrency.Global, "concurrency", cfg.Concurrency.Global, "total weight of search (1), stats (2), and icon (4) requests in flight (0 disables)")
+	fs.IntVar(&cfg.Concurrency.PerIP, "concurrency-per-ip", cfg.Concurrency.PerIP, "search, stats, and icon requests in flight allowed per client IP (0 disables)")
+	fs.IntVar(&cfg.Concurrency.Search, "concurrency-search", cfg.Concurrency.Search, "search requests in flight (0 disables)")
+	fs.IntVar(&cfg.Concurrency.Stats, "concurrency-stats", cfg.Concurrency.Stats, "stats requests in flight (0 disables)")
+	fs.IntVar(&cfg.Concurrency.Icons, "concurrency-icons", cfg.Concurrency.Icons, "icon renders in flight (0 disables)")
+	fs.StringVar(&cfg.WebhookURLs, "webhook-urls", cfg.WebhookURLs, "comma-separated URLs notified of achievement unlocks and top-3 scores")
+	fs.StringVar(&cfg.WebhookSecret, "webhook-secret", cfg.WebhookSecret, "HMAC key for the X-LobeLabyrinth-Signature header on webhook deliveries")
+	fs.DurationVar(&cfg.Outbound.Dial, "outbound-dial-timeout", cfg.Outbound.Dial, "how long outbound requests may take to connect (0 disables)")
+	fs.DurationVar(&cfg.Outbound.TLSHandshake, "outbound-tls-timeout", cfg.Outbound.TLSHandshake, "how long outbound requests may take for the TLS handshake (0 disables)")
+	fs.DurationVar(&cfg.Outbound.ResponseHeader, "outbound-response-timeout", cfg.Outbound.ResponseHeader, "how long outbound requests may wait for response headers (0 disables)")
+	fs.DurationVar(&cfg.Outbound.Request, "outbound-timeout", cfg.Outbound.Request, "how long an outbound request may take in all (0 disables)")
+	fs.StringVar(&cfg.Mail.Host, "smtp-host", cfg.Mail.Host, "SMTP server emailed new top scores go through (empty disables email)")
+	fs.IntVar(&cfg.Mail.Port, "smtp-port", cfg.Mail.Port, "SMTP server port; 465 uses TLS from the start, others STARTTLS when offered")
+	fs.StringVar(&cfg.Mail.From, "smtp-from", cfg.Mail.From, "sender address of score emails")
+	fs.StringVar(&cfg.Mail.To, "smtp-to", cfg.Mail.To, "comma-separated addresses score emails are sent to")
+	fs.StringVar(&cfg.Mail.User, "smtp-user", cfg.Mail.User, "SMTP user name (empty sends without authenticating)")
+	fs.StringVar(&cfg.Mail.Password, "smtp-password", cfg.Mail.Password, "SMTP password")
+	fs.IntVar(&cfg.Mail.TopRank, "smtp-top-rank", cfg.Mail.TopRank, "email scores that place this high or higher on the leaderboard")
+	fs.StringVar(&cfg.AppName, "app-name", cfg.AppName, "app name in the web app manifest")
+	fs.StringVar(&cfg.AppShortName, "app-short-name", cfg.AppShortName, "short app name in the web app manifest")
+	fs.StringVar(&cfg.ThemeColor, "theme-color", cfg.ThemeColor, "theme color in the web app manifest, e.g. #D4AF37")
+	fs.StringVar(&cfg.BackgroundColor, "background-color", cfg.BackgroundColor, "splash screen background color in the web app manifest")
+	fs.StringVar(&cfg.StartURL, "start-url", cfg.StartURL, "URL the installed app opens at")
+	fs.StringVar(&cfg.Scope, "scope", cfg.Scope, "URL scope of the installed app")
+	fs.StringVar(&cfg.Theme.Primary, "theme-primary", cfg.Theme.Primary, "primary color of the game's stylesheets, as #RRGGBB")
+	fs.StringVar(&cfg.Theme.Accent, "theme-accent", cfg.Theme.Accent, "accent color of the game's stylesheets, as #RRGGBB")
+	fs.StringVar(&cfg.Theme.Background, "theme-bg", cfg.Theme.Background, "background color of the game's stylesheets, as #RRGGBB")
+	fs.StringVar(&cfg.Theme.Panel, "theme-panel", cfg.Theme.Panel, "panel color of the game's stylesheets, as #RRGGBB")
+	fs.StringVar(&cfg.Theme.Text, "theme-text", cfg.Theme.Text, "text color of the game's stylesheets, as #RRGGBB")
+	fs.StringVar(&cfg.PublicURL, "public-url", cfg.PublicURL, "external scheme and host for robots.txt and sitemap.xml, e.g. https://labyrinth.example.com")
+	fs.StringVar(&cfg.RobotsDisallow, "robots-disallow", cfg.RobotsDisallow, "comma-separated paths robots.txt disallows (empty allows everything)")
+	fs.IntVar(&cfg.CompressLevel, "compress-level", cfg.CompressLevel, "gzip/deflate level for responses, 1 (fastest) to 9 (smallest)")
+	fs.IntVar(&cfg.CompressMinBytes, "compress-min-bytes", cfg.CompressMinBytes, "send responses smaller than this uncompressed")
+	fs.Int64Var(&cfg.MaxBodyBytes, "max-body-bytes", cfg.MaxBodyBytes, "largest API request body accepted, in bytes")
+	fs.Int64Var(&cfg.IconCacheBytes, "icon-cache-bytes", cfg.IconCacheBytes, "memory budget for rendered icons, in bytes (0 disables the cache)")
+	fs.BoolVar(&cfg.Metrics, "metrics", cfg.Metrics, "expose Prometheus metrics at /metrics")
+	fs.BoolVar(&cfg.Pprof, "pprof", cfg.Pprof, "serve runtime profiles at /debug/pprof/ behind the admin credentials")
+	fs.StringVar(&cfg.OTLPEndpoint, "otlp-endpoint", cfg.OTLPEndpoint, "OTLP/HTTP URL to export traces to (tracing is off if empty)")
+	fs.Float64Var(&cfg.TraceSampleRate, "trace-sample-rate", cfg.TraceSampleRate, "fraction of new traces to record, from 0 to 1")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "minimum log level: debug, info, warn, or error")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "log output format: json or text")
+	fs.IntVar(&cfg.LogStaticRate, "log-static-rate", cfg.LogStaticRate, "log one in this many successful static file requests")
+	fs.DurationVar(&cfg.LogSlow, "log-slow", cfg.LogSlow, "always log requests taking at least this long (0 disables)")
+	fs.StringVar(&cfg.AccessLog, "access-log", cfg.AccessLog, "file to write a JSON Lines access log to, or - for stdout (empty writes none)")
+	fs.IntVar(&cfg.AccessLogMaxSize, "access-log-max-size", cfg.AccessLogMaxSize, "megabytes the access log file grows to before it is rotated")
+	fs.IntVar(&cfg.AccessLogBackups, "access-log-backups", cfg.AccessLogBackups, "rotated access log files to keep")
+	return fs
+}
+
+// envName returns the environment variable for the flag called name.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// loadConfig builds the configuration from, in increasing priority, the
+// defaults, the file named by -config, environment variables, and the
+// command-line flags in args. The result is validated.
+func loadConfig(args []string, getenv func(string) string) (*Config, error) {
+	// A first pass just finds -config; errors are reported by the second.
+	probe := defaultConfig()
+	probeFlags := probe.flagSet()
+	probeFlags.SetOutput(io.Discard)
+	probeFlags.Parse(args)
+	configFile := probe.ConfigFile
+	if configFile == "" {
+		configFile = getenv(envName("config"))
+	}
+
+	cfg := defaultConfig()
+	fs := cfg.flagSet()
+	if configFile != "" {
+		if err := applyConfigFile(fs, configFile); err != nil {
+			return nil, err
+		}
+	}
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if v := getenv(envName(f.Name)); v != "" {
+			if err := fs.Set(f.Name, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", envName(f.Name), err))
+			}
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	// Rebinding picks up the file and environment values as defaults, so
+	// only flags actually given on the command line override them.
+	fs = cfg.flagSet()
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	cfg.ConfigFile = configFile
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyConfigFile sets each flag named by a key in the JSON or YAML file
+// at path. Unknown keys are an error.
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	values := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	default:
+		err = json.Unmarshal(data, &values)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var errs []error
+	for _, k := range keys {
+		if k == "config" || fs.Lookup(k) == nil {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", path, k))
+			continue
+		}
+		if err := fs.Set(k, configValue(values[k])); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", path, k, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// configValue formats a decoded config file value the way it would be
+// written on the command line. Lists and objects are passed on as JSON.
+func configValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []any, map[string]any:
+		data, _ := json.Marshal(v)
+		return string(data)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// basePath returns BasePath without its trailing slash, so that the root
+// is "" and prefixes can be joined with root-relative paths.
+func (cfg *Config) basePath() string {
+	return strings.TrimSuffix(cfg.BasePath, "/")
+}
+
+// storeDSN returns the SQLite database the sqlite store opens.
+func (cfg *Config) storeDSN() string {
+	if cfg.StoreDSN != "" {
+		return cfg.StoreDSN
+	}
+	return filepath.Join(cfg.StoreDir, sqliteFile)
+}
+
+// wsCompressMin returns the smallest multiplayer message to compress, 0
+// if compression is off.
+func (cfg *Config) wsCompressMin() int {
+	if !cfg.WSCompress {
+		return 0
+	}
+	return cfg.WSCompressMinBytes
+}
+
+// eventsFile returns the file the file events sink appends to.
+func (cfg *Config) eventsFile() string {
+	if cfg.EventsFile != "" {
+		return cfg.EventsFile
+	}
+	return filepath.Join(cfg.StoreDir, eventsFile)
+}
+
+// frameAncestors returns the entries of FrameAncestors.
+func (cfg *Config) frameAncestors() []string {
+	var origins []string
+	for _, o := range strings.Split(cfg.FrameAncestors, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
+
+// webhookURLs returns the entries of WebhookURLs.
+func (cfg *Config) webhookURLs() []string {
+	var urls []string
+	for _, u := range strings.Split(cfg.WebhookURLs, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// validate checks the merged configuration, reporting every problem at
+// once.
+func (cfg *Config) validate() error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(cfg.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("addr %q: %w", cfg.Addr, err))
+	}
+	if cfg.UnixSocket != "" && (cfg.Addr != defaultConfig().Addr || cfg.PortFallback != 0) {
+		errs = append(errs, errors.New("unix-socket cannot be combined with addr or port-fallback"))
+	}
+	if cfg.H2C && cfg.tlsEnabled() {
+		errs = append(errs, errors.New("h2c is for plain HTTP and cannot be combined with TLS"))
+	}
+	if cfg.PortFallback < 0 {
+		errs = append(errs, errors.New("port-fallback must not be negative"))
+	}
+	if p := cfg.basePath(); p != "" && (p[0] != '/' || path.Clean(p) != p || strings.ContainsAny(p, "?#")) {
+		errs = append(errs, fmt.Errorf("base-path %q must be a clean absolute path such as /games/labyrinth", cfg.BasePath))
+	}
+	if cfg.ReadHeaderTimeout <= 0 {
+		errs = append(errs, errors.New("read-header-timeout must be positive"))
+	}
+	if cfg.ReadTimeout < 0 || cfg.WriteTimeout < 0 || cfg.IdleTimeout < 0 {
+		errs = append(errs, errors.New("read-timeout, write-timeout, and idle-timeout must not be negative"))
+	}
+	if o := cfg.Outbound; o.Dial < 0 || o.TLSHandshake < 0 || o.ResponseHeader < 0 || o.Request < 0 {
+		errs = append(errs, errors.New("outbound timeouts must not be negative"))
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown-timeout must be positive"))
+	}
+	if cfg.RateLimit <= 0 || cfg.RateBurst < 1 {
+		errs = append(errs, errors.New("rate-limit must be positive and rate-burst at least 1"))
+	}
+	if _, err := parseTrustedProxies(cfg.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("trusted-proxies: %w", err))
+	}
+	if cfg.SessionSecret != "" && len(cfg.SessionSecret) < 16 {
+		errs = append(errs, errors.New("session-secret must be at least 16 bytes"))
+	}
+	if (cfg.OAuthGitHubClientID == "") != (cfg.OAuthGitHubClientSecret == "") {
+		errs = append(errs, errors.New("oauth-github-client-id and oauth-github-client-secret must be set together"))
+	}
+	if (cfg.OAuthGoogleClientID == "") != (cfg.OAuthGoogleClientSecret == "") {
+		errs = append(errs, errors.New("oauth-google-client-id and oauth-google-client-secret must be set together"))
+	}
+	if cfg.RoomCapacity < 1 {
+		errs = append(errs, errors.New("room-capacity must be at least 1"))
+	}
+	if cfg.MatchmakeTimeout <= 0 || (cfg.WriteTimeout > 0 && cfg.MatchmakeTimeout >= cfg.WriteTimeout) {
+		errs = append(errs, errors.New("matchmake-timeout must be positive and shorter than write-timeout"))
+	}
+	if cfg.RoomCodeTTL <= 0 {
+		errs = append(errs, errors.New("room-code-ttl must be positive"))
+	}
+	if cfg.DrainGrace < 0 {
+		errs = append(errs, errors.New("drain-grace must not be negative"))
+	}
+	if cfg.WSCompressMinBytes < 1 {
+		errs = append(errs, errors.New("ws-compress-min-bytes must be at least 1"))
+	}
+	if cfg.ChatBlocklist != "" {
+		if _, err := os.Stat(cfg.ChatBlocklist); err != nil {
+			errs = append(errs, fmt.Errorf("chat-blocklist: %w", err))
+		}
+	}
+	if cfg.NameBlocklist != "" {
+		if _, err := os.Stat(cfg.NameBlocklist); err != nil {
+			errs = append(errs, fmt.Errorf("name-blocklist: %w", err))
+		}
+	}
+	if cfg.Experiments != "" {
+		if _, err := os.Stat(cfg.Experiments); err != nil {
+			errs = append(errs, fmt.Errorf("experiments: %w", err))
+		}
+	}
+	if cfg.MinRunTime < 0 {
+		errs = append(errs, errors.New("min-run-time must not be negative"))
+	}
+	if cfg.AntiCheat.MinAnswerTime < 0 || cfg.AntiCheat.FastAnswerTime < 0 {
+		errs = append(errs, errors.New("cheat-min-answer-time and cheat-fast-answer-time must not be negative"))
+	}
+	if cfg.AntiCheat.FastAccuracy < 0 || cfg.AntiCheat.FastAccuracy > 1 {
+		errs = append(errs, errors.New("cheat-fast-accuracy must be between 0 and 1"))
+	}
+	if cfg.AntiCheat.MaxSubmissions < 0 {
+		errs = append(errs, errors.New("cheat-max-submissions must not be negative"))
+	}
+	if c := cfg.Concurrency; c.Global < 0 || c.PerIP < 0 || c.Search < 0 || c.Stats < 0 || c.Icons < 0 {
+		errs = append(errs, errors.New("concurrency limits must not be negative"))
+	} else if c.Global > 0 && c.Global < maxConcurrencyWeight {
+		errs = append(errs, fmt.Errorf("concurrency must be 0 or at least %d", maxConcurrencyWeight))
+	}
+	if cfg.HintPenalty < 0 {
+		errs = append(errs, errors.New("hint-penalty must not be negative"))
+	}
+	if s := cfg.Scoring; s.TimeBonus < 0 || s.TimeBonusWindow < 0 || s.StreakStep < 0 {
+		errs = append(errs, errors.New("time-bonus, time-bonus-window, and streak-step must not be negative"))
+	}
+	if cfg.Scoring.StreakMax < 1 {
+		errs = append(errs, errors.New("streak-max must be at least 1"))
+	}
+	if cfg.Scoring.MaxAttempts < 0 {
+		errs = append(errs, errors.New("max-answer-attempts must not be negative"))
+	}
+	if cfg.Scoring.TimeLimitGrace < 0 {
+		errs = append(errs, errors.New("time-limit-grace must not be negative"))
+	}
+	if _, err := parseDifficultyTimeLimits(cfg.Scoring.DifficultyTimeLimits); err != nil {
+		errs = append(errs, fmt.Errorf("difficulty-time-limits: %w", err))
+	}
+	if s := cfg.Selection; s.Freshness < 0 || s.Difficulty < 0 {
+		errs = append(errs, errors.New("selection-freshness and selection-difficulty must not be negative"))
+	}
+	if cfg.Selection.ExposureHalfLife <= 0 {
+		errs = append(errs, errors.New("exposure-half-life must be positive"))
+	}
+	if cfg.CompressLevel < 1 || cfg.CompressLevel > 9 {
+		errs = append(errs, errors.New("compress-level must be between 1 and 9"))
+	}
+	if cfg.CompressMinBytes < 0 {
+		errs = append(errs, errors.New("compress-min-bytes must not be negative"))
+	}
+	if cfg.MaxBodyBytes < 1 {
+		errs = append(errs, errors.New("max-body-bytes must be positive"))
+	}
+	if cfg.IconCacheBytes < 0 {
+		errs = append(errs, errors.New("icon-cache-bytes must not be negative"))
+	}
+	if cfg.TraceSampleRate < 0 || cfg.TraceSampleRate > 1 {
+		errs = append(errs, errors.New("trace-sample-rate must be between 0 and 1"))
+	}
+	if cfg.OTLPEndpoint != "" {
+		if parsed, err := url.Parse(cfg.OTLPEndpoint); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			errs = append(errs, fmt.Errorf("otlp-endpoint: %q is not an http(s) URL", cfg.OTLPEndpoint))
+		}
+	}
+	if cfg.PublicURL != "" {
+		if parsed, err := url.Parse(cfg.PublicURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" ||
+			strings.TrimSuffix(parsed.Path, "/") != "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+			errs = append(errs, fmt.Errorf("public-url: %q is not an http(s) URL without a path", cfg.PublicURL))
+		}
+	}
+	for _, p := range cfg.robotsDisallow() {
+		if p[0] != '/' {
+			errs = append(errs, fmt.Errorf("robots-disallow: %q must start with /", p))
+		}
+	}
+	for _, o := range cfg.frameAncestors() {
+		if !validFrameAncestor(o) {
+			errs = append(errs, fmt.Errorf("allow-frame-ancestors: %q is not an origin such as https://lms.example.edu or https://*.example.edu", o))
+		}
+	}
+	for _, u := range cfg.webhookURLs() {
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			errs = append(errs, fmt.Errorf("webhook-urls: %q is not an http(s) URL", u))
+		}
+	}
+	errs = append(errs, validateMail(cfg.Mail)...)
+	if cfg.AdminPassword != "" && (cfg.AdminUser == "" || len(cfg.AdminPassword) < 12) {
+		errs = append(errs, errors.New("admin-user must be set and admin-password at least 12 characters"))
+	}
+	if cfg.Pprof && cfg.AdminPassword == "" {
+		errs = append(errs, errors.New("pprof requires admin-password"))
+	}
+	if err := cfg.validateTLS(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, f := range []string{cfg.TLSCert, cfg.TLSKey} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			errs = append(errs, fmt.Errorf("TLS file: %w", err))
+		}
+	}
+	switch cfg.Store {
+	case "file":
+		if err := checkWritableDir(nearestDir(cfg.StoreDir)); err != nil {
+			errs = append(errs, fmt.Errorf("store-dir: %w", err))
+		}
+	case "sqlite":
+		if cfg.StoreDSN == "" {
+			if err := checkWritableDir(nearestDir(cfg.StoreDir)); err != nil {
+				errs = append(errs, fmt.Errorf("store-dir: %w", err))
+			}
+		}
+	case "memory":
+	default:
+		errs = append(errs, fmt.Errorf("store must be file, sqlite, or memory, not %q", cfg.Store))
+	}
+	for _, c := range []struct{ flag, value string }{
+		{"theme-primary", cfg.Theme.Primary},
+		{"theme-accent", cfg.Theme.Accent},
+		{"theme-bg", cfg.Theme.Background},
+		{"theme-panel", cfg.Theme.Panel},
+		{"theme-text", cfg.Theme.Text},
+	} {
+		if _, ok := parseHexColor(c.value); !ok {
+			errs = append(errs, fmt.Errorf("%s must be a #RRGGBB color, not %q", c.flag, c.value))
+		}
+	}
+	if cfg.ContentDir != "" {
+		if fi, err := os.Stat(cfg.ContentDir); err != nil || !fi.IsDir() {
+			errs = append(errs, fmt.Errorf("content-dir %q is not a directory", cfg.ContentDir))
+		}
+	}
+	switch cfg.EventsSink {
+	case "file":
+		if err := checkWritableDir(nearestDir(filepath.Dir(cfg.eventsFile()))); err != nil {
+			errs = append(errs, fmt.Errorf("events-file: %w", err))
+		}
+	case "log", "off":
+	default:
+		errs = append(errs, fmt.Errorf("events-sink must be file, log, or off, not %q", cfg.EventsSink))
+	}
+	if _, err := parseLogLevel(cfg.LogLevel); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newLogger(io.Discard, slog.LevelInfo, cfg.LogFormat); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := parseAssetTypes(cfg.AssetTypes); err != nil {
+		errs = append(errs, fmt.Errorf("asset-types: %w", err))
+	}
+	if cfg.IndexFile == "" || cfg.IndexFile == "." || cfg.IndexFile == ".." || strings.ContainsAny(cfg.IndexFile, `/\`) {
+		errs = append(errs, fmt.Errorf("index-file %q must be a file name", cfg.IndexFile))
+	}
+	if cfg.LogStaticRate < 1 {
+		errs = append(errs, errors.New("log-static-rate must be at least 1"))
+	}
+	if cfg.LogSlow < 0 {
+		errs = append(errs, errors.New("log-slow must not be negative"))
+	}
+	if cfg.AccessLog != "" && cfg.AccessLog != "-" {
+		if err := checkWritableDir(nearestDir(filepath.Dir(cfg.AccessLog))); err != nil {
+			errs = append(errs, fmt.Errorf("access-log: %w", err))
+		}
+	}
+	if cfg.AccessLogMaxSize < 1 {
+		errs = append(errs, errors.New("access-log-max-size must be at least 1"))
+	}
+	if cfg.AccessLogBackups < 0 {
+		errs = append(errs, errors.New("access-log-backups must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// nearestDir returns dir if it exists, or else its closest existing
+// ancestor, which is where it would be created.
+func nearestDir(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// checkWritableDir verifies that files can be created in dir.
+func checkWritableDir(dir string) error {
+	f, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// noEnv is a getenv with nothing set.
+func noEnv(string) string { return "" }
+
+// writeConfigFile writes a config file named name with contents data to a
+// temporary directory and returns its path.
+func writeConfigFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	file := writeConfigFile(t, "config.json", `{"addr": ":1111", "log-level": "warn", "dev": true}`)
+	env := map[string]string{
+		"LOBELABYRINTH_ADDR":      ":2222",
+		"LOBELABYRINTH_LOG_LEVEL": "error",
+	}
+	getenv := func(key string) string { return env[key] }
+
+	cfg, err := loadConfig([]string{"-config", file}, noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":1111" || cfg.LogLevel != "warn" || !cfg.Dev {
+		t.Errorf("file alone: addr %q, log-level %q, dev %v", cfg.Addr, cfg.LogLevel, cfg.Dev)
+	}
+
+	cfg, err = loadConfig([]string{"-config", file}, getenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":2222" || cfg.LogLevel != "error" || !cfg.Dev {
+		t.Errorf("environment over file: addr %q, log-level %q, dev %v", cfg.Addr, cfg.LogLevel, cfg.Dev)
+	}
+
+	cfg, err = loadConfig([]string{"-config", file, "-addr", ":3333"}, getenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":3333" || cfg.LogLevel != "error" {
+		t.Errorf("flag over environment: addr %q, log-level %q", cfg.Addr, cfg.LogLevel)
+	}
+}
+
+func TestConfigFileFromEnvironment(t *testing.T) {
+	file := writeConfigFile(t, "config.json", `{"addr": ":1111"}`)
+	cfg, err := loadConfig(nil, func(key string) string {
+		if key == "LOBELABYRINTH_CONFIG" {
+			return file
+		}
+		return ""
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":1111" {
+		t.Errorf("addr = %q, want the one from the file named by the environment", cfg.Addr)
+	}
+}
+
+func TestConfigYAML(t *testing.T) {
+	file := writeConfigFile(t, "config.yaml", "addr: \":4444\"\ndev: true\n")
+	cfg, err := loadConfig([]string{"-config", file}, noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":4444" || !cfg.Dev {
+		t.Errorf("YAML config: addr %q, dev %v", cfg.Addr, cfg.Dev)
+	}
+}
+
+func TestConfigFileUnknownKey(t *testing.T) {
+	file := writeConfigFile(t, "config.json", `{"addr": ":1111", "adress": ":2222"}`)
+	_, err := loadConfig([]string{"-config", file}, noEnv)
+	if err == nil || !strings.Contains(err.Error(), "adress") {
+		t.Fatalf("unknown key: err = %v, want it named", err)
+	}
+}
+
+func TestConfigErrorsAggregated(t *testing.T) {
+	_, err := loadConfig([]string{"-addr", "nonsense", "-tls-cert", "cert.pem"}, noEnv)
+	if err == nil {
+		t.Fatal("invalid config accepted")
+	}
+	for _, want := range []string{"addr", "tls-key"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestMalformedAddrRejected(t *testing.T) {
+	_, err := loadConfig([]string{"-addr", "8080"}, noEnv)
+	if err == nil || !strings.Contains(err.Error(), "addr") {
+		t.Fatalf("loadConfig with a malformed addr = %v, want an addr error", err)
+	}
+}
+
+func TestConfigBadEnvironmentValue(t *testing.T) {
+	_, err := loadConfig(nil, func(key string) string {
+		if key == "LOBELABYRINTH_DEV" {
+			return "sometimes"
+		}
+		return ""
+	})
+	if err == nil || !strings.Contains(err.Error(), "LOBELABYRINTH_DEV") {
+		t.Errorf("bad environment value: err = %v, want it named", err)
+	}
+}
+
+func TestConfigUnwritableStoreDir(t *testing.T) {
+	file := writeConfigFile(t, "not-a-dir", "")
+	_, err := loadConfig([]string{"-store-dir", filepath.Join(file, "store")}, noEnv)
+	if err == nil || !strings.Contains(err.Error(), "store-dir") {
+		t.Errorf("store-dir under a file: err = %v, want a store-dir error", err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync/atomic"
+)
+
+// reloadableSettings are the settings a config reload applies to the
+// running server. Changes to any other setting need a restart.
+var reloadableSettings = map[string]bool{
+	"rate-limit":          true,
+	"rate-burst":          true,
+	"cors-origins":        true,
+	"feature-multiplayer": true,
+	"feature-daily":       true,
+	"feature-hints":       true,
+	"log-level":           true,
+}
+
+// liveConfig holds the configuration the server is running with. A
+// reload swaps in a new one whole, so readers see either the old
+// settings or the new and never a mix.
+type liveConfig struct {
+	cfg atomic.Pointer[Config]
+	// load reads the configuration afresh, from the same sources as at
+	// startup.
+	load func() (*Config, error)
+	// apply hands the reloadable settings of a new configuration to the
+	// parts of the server that keep their own copy.
+	apply func(*Config)
+}
+
+func newLiveConfig(cfg *Config, load func() (*Config, error), apply func(*Config)) *liveConfig {
+	l := &liveConfig{load: load, apply: apply}
+	l.cfg.Store(cfg)
+	return l
+}
+
+// Load returns the configuration in effect.
+func (l *liveConfig) Load() *Config {
+	return l.cfg.Load()
+}
+
+// Reload reads and validates the configuration again and applies its
+// reloadableSettings. Other settings keep their current values; those
+// that changed are returned so they can be reported. Nothing is applied
+// if the new configuration is invalid.
+func (l *liveConfig) Reload() (needRestart []string, err error) {
+	next, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+	cur := l.cfg.Load()
+	merged := *cur
+	fs := merged.flagSet()
+	nextFlags := next.flagSet()
+	var applied []string
+	fs.VisitAll(func(f *flag.Flag) {
+		v := nextFlags.Lookup(f.Name).Value.String()
+		if f.Name == "config" || f.Value.String() == v {
+			return
+		}
+		if !reloadableSettings[f.Name] {
+			needRestart = append(needRestart, f.Name)
+			return
+		}
+		// The value came from a valid configuration, so it parses.
+		fs.Set(f.Name, v)
+		applied = append(applied, f.Name)
+	})
+	// Logged first, so that it is seen even where the reload raises the
+	// log level.
+	if len(applied) > 0 {
+		slog.Info("configuration reloaded", "changed", applied)
+	}
+	l.cfg.Store(&merged)
+	l.apply(&merged)
+	return needRestart, nil
+}
+
+// watchSignals reloads the configuration on each reloadSignals signal
+// until ctx is done. A configuration that fails to load or validate is
+// reported and the running one is kept.
+func (l *liveConfig) watchSignals(ctx context.Context) {
+	if len(reloadSignals) == 0 {
+		return
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, reloadSignals...)
+	go func() {
+		defer signal.Stop(sig)
+		for {
+			select {
+			case <-sig:
+				needRestart, err := l.Reload()
+				if err != nil {
+					slog.Error("config reload failed; keeping the running configuration", "err", err)
+					continue
+				}
+				if len(needRestart) > 0 {
+					slog.Warn("config changes ignored until restart", "settings", needRestart)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// reloadSignals reload the configuration. Without SIGHUP, it is only read
+// at startup.
+var reloadSignals []os.Signal
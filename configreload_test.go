@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"syscall"
+	"testing"
+)
+
+func TestLiveConfigReload(t *testing.T) {
+	recordLogs(t)
+	cur := defaultConfig()
+	next := defaultConfig()
+	next.RateLimit, next.Features.Hints, next.RoomCapacity = 99, false, 2
+	var applied *Config
+	l := newLiveConfig(cur, func() (*Config, error) { return next, nil }, func(c *Config) { applied = c })
+	needRestart, err := l.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(needRestart, []string{"room-capacity"}) {
+		t.Errorf("needRestart = %v, want [room-capacity]", needRestart)
+	}
+	got := l.Load()
+	if got.RateLimit != 99 || got.Features.Hints || got.RoomCapacity != cur.RoomCapacity {
+		t.Errorf("reloaded rate-limit %v, hints %v, room-capacity %d; want 99, false, and the old capacity", got.RateLimit, got.Features.Hints, got.RoomCapacity)
+	}
+	if applied != got {
+		t.Error("the reloaded configuration was not applied")
+	}
+	if cur.RateLimit == 99 {
+		t.Error("the reload changed the previous configuration in place")
+	}
+
+	bad := newLiveConfig(cur, func() (*Config, error) { return nil, errors.New("invalid") }, func(*Config) { t.Error("an invalid configuration was applied") })
+	if _, err := bad.Reload(); err == nil {
+		t.Error("an invalid configuration reloaded")
+	}
+	if bad.Load() != cur {
+		t.Error("a failed reload replaced the running configuration")
+	}
+}
+
+func TestSIGHUPReloadsRateLimit(t *testing.T) {
+	inTempDir(t)
+	writeConfig := func(limit, burst, capacity int) {
+		data := fmt.Sprintf(`{"rate-limit": %d, "rate-burst": %d, "room-capacity": %d}`, limit, burst, capacity)
+		if err := os.WriteFile("config.json", []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(1000, 1000, 4)
+	// A reload reads the process's own flags again.
+	defer func(args []string) { os.Args = args }(os.Args)
+	os.Args = []string{"lobelabyrinth", "-addr", "127.0.0.1:0", "-config", "config.json"}
+	s := startServerIn(t, os.Args[3:]...)
+	limited := func() bool {
+		for range 10 {
+			if code := getJSON(t, s.url("/api/questions"), nil); code == http.StatusTooManyRequests {
+				return true
+			}
+		}
+		return false
+	}
+	if limited() {
+		t.Fatal("limited before the reload")
+	}
+
+	writeConfig(1, 2, 2)
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	waitFor(t, "the reload", func() bool {
+		_, ok := s.logs.attr("config changes ignored until restart", "settings")
+		return ok
+	})
+	if v, _ := s.logs.attr("config changes ignored until restart", "settings"); fmt.Sprint(v.Any()) != "[room-capacity]" {
+		t.Errorf("settings needing a restart = %v, want [room-capacity]", v)
+	}
+	if !limited() {
+		t.Error("the reloaded rate limit was not applied")
+	}
+
+	os.WriteFile("config.json", []byte(`{"rate-limit": -1}`), 0o644)
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	waitFor(t, "the failed reload", func() bool {
+		_, ok := s.logs.attr("config reload failed; keeping the running configuration", "err")
+		return ok
+	})
+	if code, _ := get(t, s.url("/css/game.css")); code != http.StatusOK {
+		t.Errorf("after a failed reload: status %d", code)
+	}
+	if !limited() {
+		t.Error("a failed reload dropped the running rate limit")
+	}
+}
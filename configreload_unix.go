@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals reload the configuration.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
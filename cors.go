@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	corsAllowMethods = "GET, HEAD, POST, PUT, DELETE, OPTIONS"
+	corsAllowHeaders = "Content-Type, Authorization"
+	// corsMaxAge is how long browsers may cache a preflight result.
+	corsMaxAge = 600
+)
+
+// corsPolicy allows cross-origin API calls from a set of origins, which a
+// config reload may replace while the server runs.
+type corsPolicy struct {
+	allowed atomic.Pointer[corsOrigins]
+}
+
+// corsOrigins is the origin set of a corsPolicy. The single entry "*"
+// allows any origin, but never with credentials.
+type corsOrigins struct {
+	any     bool
+	origins map[string]bool
+}
+
+// newCORSPolicy builds a policy from a comma-separated origin list such as
+// "https://a.example, https://b.example".
+func newCORSPolicy(list string) *corsPolicy {
+	p := &corsPolicy{}
+	p.Set(list)
+	return p
+}
+
+// Set replaces the allowed origins with those in list, given as for
+// newCORSPolicy.
+func (p *corsPolicy) Set(list string) {
+	o := &corsOrigins{origins: make(map[string]bool)}
+	for _, origin := range strings.Split(list, ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		switch origin {
+		case "":
+		case "*":
+			o.any = true
+		default:
+			o.origins[origin] = true
+		}
+	}
+	p.allowed.Store(o)
+}
+
+// middleware adds CORS headers for allowed origins and answers preflight
+// requests. A preflight from an origin that is not allowed gets 403.
+func (p *corsPolicy) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		o := p.allowed.Load()
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		allowed := o.any || o.origins[origin]
+		if allowed {
+			if o.origins[origin] {
+				h.Set("Access-Control-Allow-Origin", origin)
+				h.Set("Access-Control-Allow-Credentials", "true")
+			} else {
+				h.Set("Access-Control-Allow-Origin", "*")
+			}
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				writeAPIError(w, http.StatusForbidden, "origin not allowed")
+				return
+			}
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", corsAllowMethods)
+			h.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			h.Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
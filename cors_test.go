@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// corsRequest serves r through policy in front of a handler answering
+// 200.
+func corsRequest(policy *corsPolicy, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	policy.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+	return w
+}
+
+func preflight(origin string) *http.Request {
+	r := httptest.NewRequest(http.MethodOptions, "/api/leaderboard", nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	return r
+}
+
+func TestCORSPreflight(t *testing.T) {
+	policy := newCORSPolicy("https://game.example, https://beta.example/")
+	w := corsRequest(policy, preflight("https://beta.example"))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("preflight: status %d, want 204", w.Code)
+	}
+	h := w.Header()
+	if got := h.Get("Access-Control-Allow-Origin"); got != "https://beta.example" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the origin echoed", got)
+	}
+	if h.Get("Access-Control-Allow-Methods") == "" || h.Get("Access-Control-Allow-Headers") == "" {
+		t.Errorf("preflight lacks the allowed methods or headers: %v", h)
+	}
+	if got := h.Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("Access-Control-Allow-Credentials = %q for a listed origin", got)
+	}
+}
+
+func TestCORSRejectsDisallowedOrigin(t *testing.T) {
+	policy := newCORSPolicy("https://game.example")
+	w := corsRequest(policy, preflight("https://evil.example"))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("preflight from a disallowed origin: status %d, want 403", w.Code)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/api/leaderboard", nil)
+	r.Header.Set("Origin", "https://evil.example")
+	if got := corsRequest(policy, r).Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("disallowed origin given Access-Control-Allow-Origin %q", got)
+	}
+}
+
+func TestCORSWildcardWithoutCredentials(t *testing.T) {
+	w := corsRequest(newCORSPolicy("*"), preflight("https://anyone.example"))
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("wildcard origin allowed credentials: %q", got)
+	}
+}
+
+func TestCORSOnlyOnAPI(t *testing.T) {
+	s := startServer(t, "-cors-origins", "https://game.example")
+	for path, want := range map[string]string{"/api/questions": "https://game.example", "/css/game.css": ""} {
+		req, _ := http.NewRequest(http.MethodGet, s.url(path), nil)
+		req.Header.Set("Origin", "https://game.example")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != want {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// sitemapPages are the public HTML pages listed in /sitemap.xml, relative
+// to the base path.
+var sitemapPages = []string{"/", "/help"}
+
+// robotsDisallow returns the entries of RobotsDisallow.
+func (cfg *Config) robotsDisallow() []string {
+	var paths []string
+	for _, p := range strings.Split(cfg.RobotsDisallow, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// publicOrigin returns the scheme and host crawlers should use to reach
+// the server: PublicURL when set, otherwise those r arrived with.
+func publicOrigin(publicURL string, r *http.Request) string {
+	if publicURL != "" {
+		return strings.TrimSuffix(publicURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// robotsHandler serves /robots.txt, keeping crawlers out of the disallowed
+// paths under basePath and pointing them at the sitemap.
+func robotsHandler(publicURL, basePath string, disallow []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var b strings.Builder
+		b.WriteString("User-agent: *\n")
+		for _, p := range disallow {
+			fmt.Fprintf(&b, "Disallow: %s%s\n", basePath, p)
+		}
+		fmt.Fprintf(&b, "Allow: %s/\n\nSitemap: %s%s/sitemap.xml\n", basePath, publicOrigin(publicURL, r), basePath)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", staticMaxAge))
+		w.Write([]byte(b.String()))
+	})
+}
+
+// sitemapURLSet is the root element of a sitemap.
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc string `xml:"loc"`
+}
+
+// sitemapHandler serves /sitemap.xml, listing sitemapPages under the
+// public origin and basePath.
+func sitemapHandler(publicURL, basePath string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		set := sitemapURLSet{}
+		origin := publicOrigin(publicURL, r)
+		for _, p := range sitemapPages {
+			set.URLs = append(set.URLs, sitemapURL{Loc: origin + basePath + p})
+		}
+		data, err := xml.MarshalIndent(set, "", "  ")
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", staticMaxAge))
+		w.Write([]byte(xml.Header))
+		w.Write(data)
+		w.Write([]byte("\n"))
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRobotsDisallowsAPI(t *testing.T) {
+	s := startServer(t, "-public-url", "https://labyrinth.example.com")
+	code, body := get(t, s.url("/robots.txt"))
+	if code != http.StatusOK {
+		t.Fatalf("/robots.txt: status %d", code)
+	}
+	for _, want := range []string{"User-agent: *\n", "Disallow: /api/\n", "Disallow: /admin/\n", "Allow: /\n", "Sitemap: https://labyrinth.example.com/sitemap.xml\n"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("robots.txt lacks %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestRobotsConfigurable(t *testing.T) {
+	s := startServer(t, "-robots-disallow", "/private/", "-base-path", testBasePath)
+	_, body := get(t, s.url(testBasePath+"/robots.txt"))
+	if !strings.Contains(body, "Disallow: "+testBasePath+"/private/\n") || strings.Contains(body, "/api/") {
+		t.Errorf("robots.txt with -robots-disallow /private/:\n%s", body)
+	}
+	if want := "Sitemap: http://" + s.addr + testBasePath + "/sitemap.xml"; !strings.Contains(body, want) {
+		t.Errorf("robots.txt without -public-url lacks %q:\n%s", want, body)
+	}
+}
+
+func TestSitemapListsHelp(t *testing.T) {
+	s := startServer(t, "-public-url", "https://labyrinth.example.com/")
+	resp, err := http.Get(s.url("/sitemap.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/xml") {
+		t.Errorf("Content-Type %q, want XML", resp.Header.Get("Content-Type"))
+	}
+	var set sitemapURLSet
+	if err := xml.NewDecoder(resp.Body).Decode(&set); err != nil {
+		t.Fatalf("sitemap is not valid XML: %v", err)
+	}
+	var locs []string
+	for _, u := range set.URLs {
+		locs = append(locs, u.Loc)
+	}
+	if got := strings.Join(locs, " "); got != "https://labyrinth.example.com/ https://labyrinth.example.com/help" {
+		t.Errorf("sitemap lists %s", got)
+	}
+}
+
+func TestPublicURLValidated(t *testing.T) {
+	inTempDir(t)
+	for _, bad := range []string{"labyrinth.example.com", "ftp://labyrinth.example.com", "https://labyrinth.example.com/path?q=1"} {
+		if _, err := loadConfig([]string{"-public-url", bad}, func(string) string { return "" }); err == nil {
+			t.Errorf("-public-url %q accepted", bad)
+		}
+	}
+}
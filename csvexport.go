@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"net/http"
+)
+
+// csvFlushRows is how many rows a CSV export writes between flushes, so
+// that a large download reaches the client as it is produced.
+const csvFlushRows = 256
+
+// csvExport streams a CSV download to a client row by row, never holding
+// more than a flush's worth in memory. It stops once the request is
+// cancelled, as it is when the client goes away, so that an abandoned
+// export does not go on reading the Store.
+type csvExport struct {
+	ctx  context.Context
+	cw   *csv.Writer
+	rc   *http.ResponseController
+	rows int
+}
+
+// newCSVExport starts the CSV download filename of r on w with header as
+// its first row. The response is sent chunked, as its length is not known
+// until the last row.
+func newCSVExport(w http.ResponseWriter, r *http.Request, filename string, header []string) *csvExport {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Del("Content-Length")
+	e := &csvExport{ctx: r.Context(), cw: csv.NewWriter(w), rc: http.NewResponseController(w)}
+	e.cw.Write(header)
+	return e
+}
+
+// Write adds row to the export, flushing every csvFlushRows rows. It fails
+// once the request is cancelled or the client cannot be written to, and
+// the caller should then stop.
+func (e *csvExport) Write(row []string) error {
+	if err := e.ctx.Err(); err != nil {
+		return err
+	}
+	if err := e.cw.Write(row); err != nil {
+		return err
+	}
+	if e.rows++; e.rows%csvFlushRows == 0 {
+		return e.flush()
+	}
+	return nil
+}
+
+// Close flushes the rows not yet sent.
+func (e *csvExport) Close() error {
+	return e.flush()
+}
+
+func (e *csvExport) flush() error {
+	e.cw.Flush()
+	if err := e.cw.Error(); err != nil {
+		return err
+	}
+	// A writer that cannot flush sends everything at the end, which is no
+	// reason to fail.
+	if err := e.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
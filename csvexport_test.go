@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flushRecorder records a response and how much of it had been written at
+// each flush, calling onFlush after each.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushedAt []int
+	onFlush   func()
+}
+
+func (w *flushRecorder) Flush() {
+	w.flushedAt = append(w.flushedAt, w.Body.Len())
+	w.ResponseRecorder.Flush()
+	if w.onFlush != nil {
+		w.onFlush()
+	}
+}
+
+// countingStore is a Store counting its Gets.
+type countingStore struct {
+	Store
+	gets atomic.Int64
+}
+
+func (s *countingStore) Get(namespace, key string) ([]byte, error) {
+	s.gets.Add(1)
+	return s.Store.Get(namespace, key)
+}
+
+func TestCSVExportStreams(t *testing.T) {
+	const rows = 10 * csvFlushRows
+	w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	export := newCSVExport(w, httptest.NewRequest(http.MethodGet, "/export.csv", nil), "export.csv", []string{"n"})
+	for i := range rows {
+		if err := export.Write([]string{fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+		if i == csvFlushRows && len(w.flushedAt) != 1 {
+			t.Fatalf("%d flushes after %d rows, want 1", len(w.flushedAt), i)
+		}
+	}
+	if err := export.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.flushedAt) != rows/csvFlushRows+1 {
+		t.Errorf("%d flushes for %d rows, want one every %d", len(w.flushedAt), rows, csvFlushRows)
+	}
+	if first, total := w.flushedAt[0], w.Body.Len(); first == 0 || first > total/5 {
+		t.Errorf("%d of %d bytes were held back before the first flush", first, total)
+	}
+	h := w.Header()
+	if h.Get("Content-Type") != "text/csv; charset=utf-8" || h.Get("Content-Disposition") != `attachment; filename="export.csv"` || h.Get("Content-Length") != "" {
+		t.Errorf("export headers %v", h)
+	}
+	records, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+	if err != nil || len(records) != rows+1 || records[rows][0] != fmt.Sprint(rows-1) {
+		t.Errorf("export of %d records (%v), want the header and %d rows", len(records), err, rows)
+	}
+}
+
+func TestStatsCSVStopsWhenCancelled(t *testing.T) {
+	const questions = 20 * csvFlushRows
+	store := &countingStore{Store: newMemoryStore()}
+	stats := newAnswerStats(store)
+	for i := range questions {
+		stats.Record(fmt.Sprintf("q%04d", i), i%2 == 0, time.Second)
+	}
+
+	store.gets.Store(0)
+	w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	statsCSVHandler(stats).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/stats.csv", nil))
+	if got := strings.Count(w.Body.String(), "\n"); got != questions+1 {
+		t.Fatalf("full export of %d lines, want %d", got, questions+1)
+	}
+	if !strings.HasPrefix(w.Body.String(), "questionID,attempts,correct,correctRate,avgTimeMs\nq0000,1,1,1,1000\n") {
+		t.Errorf("export begins %q", w.Body.String()[:80])
+	}
+	full := store.gets.Load()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store.gets.Store(0)
+	w = &flushRecorder{ResponseRecorder: httptest.NewRecorder(), onFlush: cancel}
+	statsCSVHandler(stats).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/stats.csv", nil).WithContext(ctx))
+	if got := store.gets.Load(); got > 2*csvFlushRows || got >= full {
+		t.Errorf("after the client went away the export read %d of %d questions", got, full)
+	}
+	if got := strings.Count(w.Body.String(), "\n"); got > csvFlushRows+2 {
+		t.Errorf("%d lines were written after the client went away", got)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultCursorLimit is how many items a cursor-paged list returns by
+	// default, and maxCursorLimit the most a request may ask for.
+	defaultCursorLimit = 20
+	maxCursorLimit     = 100
+)
+
+// errBadCursor rejects a cursor that was not issued for the list it is
+// used on, or was altered.
+var errBadCursor = errors.New("invalid cursor")
+
+// cursorPage is a page of a list paged by keys. A cursor is the last key
+// of the previous page, signed together with the list it belongs to, so
+// that paging resumes after that key however many items were inserted
+// before it in the meantime. Keys must be unique and never change while
+// their item exists.
+type cursorPage struct {
+	sess  *sessions
+	list  string // names the list, binding cursors to it
+	after string // key the page starts after, "" for the first page
+	limit int
+}
+
+// cursorParams reads the cursor and limit query parameters of a request
+// for the list named list, reporting any that are invalid.
+func cursorParams(r *http.Request, sess *sessions, list string) (cursorPage, error) {
+	page := cursorPage{sess: sess, list: list, limit: defaultCursorLimit}
+	query := r.URL.Query()
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxCursorLimit {
+			return page, fmt.Errorf("limit must be between 1 and %d", maxCursorLimit)
+		}
+		page.limit = n
+	}
+	if v := query.Get("cursor"); v != "" {
+		after, err := page.decode(v)
+		if err != nil {
+			return page, err
+		}
+		page.after = after
+	}
+	return page, nil
+}
+
+func (p cursorPage) encode(key string) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(key)) + "." + enc.EncodeToString(p.sess.mac("cursor", p.list, key))
+}
+
+func (p cursorPage) decode(cursor string) (string, error) {
+	enc := base64.RawURLEncoding
+	k, s, _ := strings.Cut(cursor, ".")
+	key, err1 := enc.DecodeString(k)
+	sig, err2 := enc.DecodeString(s)
+	if err1 != nil || err2 != nil || len(key) == 0 || !hmac.Equal(sig, p.sess.mac("cursor", p.list, string(key))) {
+		return "", errBadCursor
+	}
+	return string(key), nil
+}
+
+// Keys sorts keys and returns those on the page, along with the cursor of
+// the next page, "" if this is the last.
+func (p cursorPage) Keys(keys []string) (page []string, next string) {
+	sort.Strings(keys)
+	i := sort.Search(len(keys), func(i int) bool { return keys[i] > p.after })
+	keys = keys[i:]
+	if len(keys) <= p.limit {
+		return keys, ""
+	}
+	page = keys[:p.limit]
+	return page, p.encode(page[len(page)-1])
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCursorPagingStableUnderInserts(t *testing.T) {
+	sess := newSessions(testSecret, newMemoryStore(), 0)
+	keys := []string{"k05", "k01", "k03", "k02", "k04", "k06", "k07"}
+	want := slices.Clone(keys)
+	seen := map[string]int{}
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > len(keys) {
+			t.Fatal("paging never ended")
+		}
+		r := httptest.NewRequest(http.MethodGet, "/?limit=3&cursor="+url.QueryEscape(cursor), nil)
+		page, err := cursorParams(r, sess, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, next := page.Keys(slices.Clone(keys))
+		for _, k := range got {
+			seen[k]++
+		}
+		if pages == 0 {
+			// One item lands before the cursor and one after it.
+			keys = append(keys, "k00", "k08")
+			want = append(want, "k08")
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	for _, k := range want {
+		if seen[k] != 1 {
+			t.Errorf("%s seen %d times, want once", k, seen[k])
+		}
+	}
+	if seen["k00"] != 0 {
+		t.Error("an item inserted before the cursor was served on a later page")
+	}
+}
+
+func TestCursorParamsRejectTampering(t *testing.T) {
+	sess := newSessions(testSecret, newMemoryStore(), 0)
+	page := cursorPage{sess: sess, list: "replays", limit: 1}
+	_, cursor := page.Keys([]string{"a", "b"})
+	if cursor == "" {
+		t.Fatal("first of two pages has no next cursor")
+	}
+	key, sig, _ := strings.Cut(cursor, ".")
+	forged := cursorPage{sess: newSessions([]byte("another secret of thirty-two bytes"), newMemoryStore(), 0), list: "replays", limit: 1}
+	_, forgedCursor := forged.Keys([]string{"a", "b"})
+	tests := []struct {
+		name, query, list string
+	}{
+		{"altered key", "cursor=" + "Yg." + sig, "replays"},
+		{"altered signature", "cursor=" + key + ".AAAA", "replays"},
+		{"other list", "cursor=" + cursor, "moderation"},
+		{"other secret", "cursor=" + forgedCursor, "replays"},
+		{"garbled", "cursor=!!.??", "replays"},
+		{"no signature", "cursor=" + key, "replays"},
+		{"zero limit", "limit=0", "replays"},
+		{"huge limit", fmt.Sprintf("limit=%d", maxCursorLimit+1), "replays"},
+		{"word limit", "limit=ten", "replays"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+		if _, err := cursorParams(r, sess, tt.list); err == nil {
+			t.Errorf("%s: accepted", tt.name)
+		}
+	}
+	r := httptest.NewRequest(http.MethodGet, "/?cursor="+cursor, nil)
+	if page, err := cursorParams(r, sess, "replays"); err != nil || page.after != "a" || page.limit != defaultCursorLimit {
+		t.Errorf("genuine cursor: %+v, %v", page, err)
+	}
+}
+
+// historyPage fetches a page of a player's answered questions.
+func historyPage(t *testing.T, s *runningServer, cursor string) (ids []string, next string) {
+	t.Helper()
+	var body struct {
+		Entries    []historyEntry `json:"entries"`
+		NextCursor string         `json:"nextCursor"`
+	}
+	if code := getJSON(t, s.url("/api/history?limit=2&token="+testSaveToken+"&cursor="+url.QueryEscape(cursor)), &body); code != http.StatusOK {
+		t.Fatalf("history page: status %d", code)
+	}
+	for _, e := range body.Entries {
+		if e.AnsweredAt.IsZero() {
+			t.Errorf("%s has no answer time", e.Question)
+		}
+		ids = append(ids, e.Question)
+	}
+	return ids, body.NextCursor
+}
+
+func TestHistoryPagedWithCursors(t *testing.T) {
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(questions, func(a, b Question) int { return strings.Compare(a.ID, b.ID) })
+	s := startServer(t)
+	answer := func(q Question) {
+		t.Helper()
+		if code := postJSON(t, s.url("/api/answer?token="+testSaveToken), map[string]any{"questionID": q.ID, "choiceIndex": 0}, nil); code != http.StatusOK {
+			t.Fatalf("answering %s: status %d", q.ID, code)
+		}
+	}
+	for _, q := range questions[:5] {
+		answer(q)
+	}
+	var seen []string
+	ids, next := historyPage(t, s, "")
+	seen = append(seen, ids...)
+	// The last question sorts after every page already served.
+	answer(questions[len(questions)-1])
+	for next != "" {
+		ids, next = historyPage(t, s, next)
+		seen = append(seen, ids...)
+	}
+	want := []string{questions[0].ID, questions[1].ID, questions[2].ID, questions[3].ID, questions[4].ID, questions[len(questions)-1].ID}
+	if !slices.Equal(seen, want) {
+		t.Errorf("paged through %v, want %v", seen, want)
+	}
+
+	_, next = historyPage(t, s, "")
+	k, _, _ := strings.Cut(next, ".")
+	if code := getJSON(t, s.url("/api/history?token="+testSaveToken+"&cursor="+k+".AAAA"), nil); code != http.StatusBadRequest {
+		t.Errorf("tampered cursor: status %d, want 400", code)
+	}
+}
+
+func TestModerationQueuePagedWithCursors(t *testing.T) {
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(questions, func(a, b Question) int { return strings.Compare(a.ID, b.ID) })
+	s := startServer(t, "-admin-password", testAdminPassword)
+	for _, q := range questions[:3] {
+		reportQuestion(t, s, startSession(t, s), q.ID, "typo")
+	}
+	page := func(cursor string) ([]string, string) {
+		t.Helper()
+		code, data := adminDo(t, s, http.MethodGet, "/api/admin/moderation?limit=1&cursor="+url.QueryEscape(cursor), nil)
+		if code != http.StatusOK {
+			t.Fatalf("moderation page: status %d", code)
+		}
+		var body struct {
+			Items      []moderationItem `json:"items"`
+			NextCursor string           `json:"nextCursor"`
+		}
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, item := range body.Items {
+			ids = append(ids, item.ID)
+		}
+		return ids, body.NextCursor
+	}
+	seen, next := page("")
+	reportQuestion(t, s, startSession(t, s), questions[len(questions)-1].ID, "typo")
+	for next != "" {
+		var ids []string
+		ids, next = page(next)
+		seen = append(seen, ids...)
+	}
+	var want []string
+	for _, q := range append(questions[:3:3], questions[len(questions)-1]) {
+		want = append(want, "question:"+q.ID)
+	}
+	if !slices.Equal(seen, want) {
+		t.Errorf("paged through %v, want %v", seen, want)
+	}
+	if items := moderationQueue(t, s); len(items) != 4 {
+		t.Errorf("unpaged queue has %d items, want 4", len(items))
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// dailyQuestionCount is how many questions a daily challenge has.
+	dailyQuestionCount = 10
+	// dailyDateLayout is how challenge dates are written: a UTC calendar
+	// day.
+	dailyDateLayout = "2006-01-02"
+	// maxDailyAge is how many days back past challenges and their
+	// leaderboards can be fetched.
+	maxDailyAge = 366
+	// dailyServedNamespace holds the date of today's challenge for each
+	// session it was served to, keyed by session ID, so that only those
+	// sessions can rank on its board.
+	dailyServedNamespace = "daily-served"
+)
+
+var errNotServed = errors.New("session was not served today's challenge")
+
+// dailyDate returns the challenge date r asks for with ?date=, or today's
+// UTC date without it. Dates in the future or more than maxDailyAge days
+// back are rejected.
+func dailyDate(r *http.Request, now time.Time) (string, error) {
+	today := now.UTC().Format(dailyDateLayout)
+	v := r.URL.Query().Get("date")
+	if v == "" {
+		return today, nil
+	}
+	d, err := time.Parse(dailyDateLayout, v)
+	if err != nil {
+		return "", fmt.Errorf("date must be a UTC date like %s", today)
+	}
+	if v > today || now.UTC().Sub(d) > maxDailyAge*24*time.Hour {
+		return "", fmt.Errorf("date must be between %d days ago and today", maxDailyAge)
+	}
+	return v, nil
+}
+
+// dailyQuestions picks the challenge for date from all: the same date
+// always gives the same questions for the same question set, whatever the
+// order of all.
+func dailyQuestions(all []Question, date string) []Question {
+	picked := append([]Question(nil), all...)
+	sort.Slice(picked, func(i, j int) bool { return picked[i].ID < picked[j].ID })
+	seed := sha256.Sum256([]byte("daily:" + date))
+	rng := rand.New(rand.NewPCG(binary.BigEndian.Uint64(seed[:8]), binary.BigEndian.Uint64(seed[8:16])))
+	rng.Shuffle(len(picked), func(i, j int) { picked[i], picked[j] = picked[j], picked[i] })
+	if len(picked) > dailyQuestionCount {
+		picked = picked[:dailyQuestionCount]
+	}
+	return picked
+}
+
+// dailyHandler serves GET /api/daily?date=D&session=S, the daily challenge
+// questions for date D (default today, UTC) in the negotiated language.
+// With a session token, answers are shuffled as for /api/questions, the
+// questions are recorded in scores as served, and a session served
+// today's challenge is recorded in boards as one that may submit to its
+// leaderboard. Questions mod hides are left out of the
+// pick.
+func dailyHandler(banks map[string]*questionBank, langs *languageRegistry, sess *sessions, scores *scorekeeper, mod *moderation, boards *dailyBoards) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		now := time.Now()
+		date, err := dailyDate(r, now)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		sessionID, err := sess.fromQuery(r)
+		if err != nil {
+			writeAPIError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if sessionID != "" && date == now.UTC().Format(dailyDateLayout) {
+			if err := boards.Serve(sessionID, date, now); err != nil {
+				writeAPIError(w, http.StatusInternalServerError, "could not record the challenge")
+				return
+			}
+		}
+		// The pick is made from the default bank so that every language
+		// gets the same challenge.
+		picked := dailyQuestions(mod.Visible(banks[defaultLanguage].All()), date)
+		bank := localize(w, r, langs, banks)
+		questions := make([]PublicQuestion, 0, len(picked))
+		for _, q := range picked {
+			if local, ok := bank.Get(q.ID); ok {
+				q = *local
+			}
+			questions = append(questions, sess.shuffleAnswers(q.Public(), sessionID))
+		}
+		if sessionID != "" {
+			ids := make([]string, len(questions))
+			for i, q := range questions {
+				ids[i] = q.ID
+			}
+			if err := scores.Serve(sessionID, ids, now); err != nil {
+				writeAPIError(w, http.StatusInternalServerError, "could not record the questions served")
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"date": date, "questions": questions})
+	})
+}
+
+// dailyBoards holds one leaderboard per challenge date, opened on first
+// use.
+type dailyBoards struct {
+	store Store
+
+	mu     sync.Mutex
+	boards map[string]*leaderboard
+}
+
+func newDailyBoards(store Store) *dailyBoards {
+	return &dailyBoards{store: store, boards: make(map[string]*leaderboard)}
+}
+
+// Board returns the leaderboard of the challenge on date.
+func (d *dailyBoards) Board(date string) (*leaderboard, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if lb, ok := d.boards[date]; ok {
+		return lb, nil
+	}
+	lb, err := openLeaderboard(d.store, "daily-"+date)
+	if err != nil {
+		return nil, err
+	}
+	d.boards[date] = lb
+	return lb, nil
+}
+
+// dailyServed is the stored record of the challenge a session was served,
+// kept until the session would have expired.
+type dailyServed struct {
+	Date    string    `json:"date"`
+	Expires time.Time `json:"expires"`
+}
+
+// Serve records that session sessionID was served the challenge on date
+// at now.
+func (d *dailyBoards) Serve(sessionID, date string, now time.Time) error {
+	data, err := json.Marshal(dailyServed{Date: date, Expires: now.Add(sessionTTL).UTC()})
+	if err != nil {
+		return err
+	}
+	return d.store.Set(dailyServedNamespace, sessionID, data)
+}
+
+// Admit fails with errNotServed unless session sessionID was served the
+// challenge on date.
+func (d *dailyBoards) Admit(sessionID, date string) error {
+	data, err := d.store.Get(dailyServedNamespace, sessionID)
+	if errors.Is(err, ErrNotFound) {
+		return errNotServed
+	}
+	if err != nil {
+		return err
+	}
+	var served dailyServed
+	if err := json.Unmarshal(data, &served); err != nil {
+		return err
+	}
+	if served.Date != date {
+		return errNotServed
+	}
+	return nil
+}
+
+// Reset forgets the opened leaderboards, so each is read from the store
+// again on next use.
+func (d *dailyBoards) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	clear(d.boards)
+}
+
+// dailyLeaderboardHandler serves /api/daily/leaderboard?date=D like
+// /api/leaderboard, for the challenge on date D (default today, UTC).
+// Scores can only be submitted to today's challenge, by sessions that
+// were served it.
+func dailyLeaderboardHandler(boards *dailyBoards, sess *sessions, scores *scorekeeper, hooks *webhooks, mail *mailer, profiles *accounts, guard *scoreGuard, names *nameRegistry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		date, err := dailyDate(r, now)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if r.Method == http.MethodPost && date != now.UTC().Format(dailyDateLayout) {
+			writeAPIError(w, http.StatusForbidden, "scores can only be submitted to today's challenge")
+			return
+		}
+		lb, err := boards.Board(date)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "could not load leaderboard")
+			return
+		}
+		admit := func(sessionID string) error { return boards.Admit(sessionID, date) }
+		leaderboardHandler(lb, nil, sess, scores, hooks, mail, profiles, guard, names, admit).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+// dailyIDs fetches the daily challenge with query and returns its date and
+// question IDs.
+func dailyIDs(t *testing.T, s *runningServer, query string) (string, []string) {
+	t.Helper()
+	var body struct {
+		Date      string           `json:"date"`
+		Questions []servedQuestion `json:"questions"`
+	}
+	if code := getJSON(t, s.url("/api/daily?"+query), &body); code != http.StatusOK {
+		t.Fatalf("/api/daily?%s: status %d", query, code)
+	}
+	ids := make([]string, len(body.Questions))
+	for i, q := range body.Questions {
+		ids[i] = q.ID
+	}
+	return body.Date, ids
+}
+
+func TestDailyQuestionsDeterministic(t *testing.T) {
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := func(qs []Question) []string {
+		out := make([]string, len(qs))
+		for i, q := range qs {
+			out[i] = q.ID
+		}
+		return out
+	}
+	first := ids(dailyQuestions(questions, "2024-03-01"))
+	if len(first) != min(dailyQuestionCount, len(questions)) {
+		t.Fatalf("%d daily questions, want %d", len(first), dailyQuestionCount)
+	}
+	reversed := slices.Clone(questions)
+	slices.Reverse(reversed)
+	if again := ids(dailyQuestions(reversed, "2024-03-01")); !slices.Equal(again, first) {
+		t.Errorf("the same date gave %v and then %v", first, again)
+	}
+	if other := ids(dailyQuestions(questions, "2024-03-02")); slices.Equal(other, first) {
+		t.Errorf("two dates share the challenge %v", first)
+	}
+}
+
+func TestDailyEndpoint(t *testing.T) {
+	s := startServer(t)
+	now := time.Now().UTC()
+	past, earlier := now.AddDate(0, 0, -3).Format(dailyDateLayout), now.AddDate(0, 0, -4).Format(dailyDateLayout)
+	date, first := dailyIDs(t, s, "date="+past)
+	if date != past {
+		t.Errorf("date %q, want the one asked for, %s", date, past)
+	}
+	if _, again := dailyIDs(t, s, "date="+past); !slices.Equal(again, first) {
+		t.Errorf("two calls on one date gave %v and %v", first, again)
+	}
+	if _, other := dailyIDs(t, s, "date="+earlier); slices.Equal(other, first) {
+		t.Errorf("two dates share the challenge %v", first)
+	}
+	if today, _ := dailyIDs(t, s, ""); today != now.Format(dailyDateLayout) {
+		t.Errorf("default date %q is not today in UTC", today)
+	}
+
+	tomorrow := now.AddDate(0, 0, 1).Format(dailyDateLayout)
+	tooOld := now.AddDate(0, 0, -maxDailyAge-1).Format(dailyDateLayout)
+	for _, date := range []string{"yesterday", "2024-13-01", tomorrow, tooOld} {
+		if code := getJSON(t, s.url("/api/daily?date="+date), nil); code != http.StatusBadRequest {
+			t.Errorf("date %s: status %d, want 400", date, code)
+		}
+	}
+}
+
+func TestDailyLeaderboardScopedToDate(t *testing.T) {
+	s := startServer(t, "-min-run-time", "0s", "-session-secret", string(testSecret))
+	if code := submitScoreTo(t, s, "/api/daily/leaderboard", startSession(t, s), "ada", 0, 1000); code != http.StatusForbidden {
+		t.Errorf("a session never served the challenge: status %d, want 403", code)
+	}
+	ada := startSession(t, s)
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(dailyDateLayout)
+	if code := getJSON(t, s.url("/api/daily?date="+yesterday+"&session="+url.QueryEscape(ada.Token)), nil); code != http.StatusOK {
+		t.Fatalf("yesterday's challenge: status %d", code)
+	}
+	if code := submitScoreTo(t, s, "/api/daily/leaderboard", ada, "ada", 0, 1000); code != http.StatusForbidden {
+		t.Errorf("a session served only yesterday's challenge: status %d, want 403", code)
+	}
+	if code := getJSON(t, s.url("/api/daily?session="+url.QueryEscape(ada.Token)), nil); code != http.StatusOK {
+		t.Fatalf("today's challenge: status %d", code)
+	}
+	if code := submitScoreTo(t, s, "/api/daily/leaderboard", ada, "ada", 0, 1000); code != http.StatusCreated {
+		t.Fatalf("daily submission: status %d, want 201", code)
+	}
+	entries := func(query string) []LeaderboardEntry {
+		var body struct {
+			Entries []LeaderboardEntry `json:"entries"`
+		}
+		if code := getJSON(t, s.url("/api/daily/leaderboard?"+query), &body); code != http.StatusOK {
+			t.Fatalf("/api/daily/leaderboard?%s: status %d", query, code)
+		}
+		return body.Entries
+	}
+	if today := entries(""); len(today) != 1 || today[0].Name != "ada" {
+		t.Errorf("today's board %+v, want ada's entry", today)
+	}
+	if past := entries("date=" + yesterday); len(past) != 0 {
+		t.Errorf("yesterday's board %+v, want it empty", past)
+	}
+	var main struct {
+		Entries []LeaderboardEntry `json:"entries"`
+	}
+	getJSON(t, s.url("/api/leaderboard"), &main)
+	if len(main.Entries) != 0 {
+		t.Errorf("the daily score reached the main board: %+v", main.Entries)
+	}
+	if code := submitScoreTo(t, s, "/api/daily/leaderboard?date="+yesterday, startSession(t, s), "bob", 0, 1000); code != http.StatusForbidden {
+		t.Errorf("submission to a past day: status %d, want 403", code)
+	}
+}
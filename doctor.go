@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// doctorCheck is one line of the doctor report: what was checked, and why
+// it failed, if it did.
+type doctorCheck struct {
+	Name   string
+	Detail string
+	Err    error
+}
+
+// runDoctor implements "lobelabyrinth doctor [flags]", which checks, with
+// the settings the server would start with, everything it needs to run:
+// the embedded assets and content, the configuration, the storage
+// directories, the TLS certificate, the endpoints it sends to, and the
+// ports it listens on. It exits 1 if any check fails.
+func runDoctor(args []string, stdout, stderr io.Writer) int {
+	cfg, err := loadConfig(args, os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintln(stderr, "Usage: lobelabyrinth doctor [server flags]")
+		return 2
+	}
+	checks := []doctorCheck{{Name: "config", Detail: "settings are valid", Err: err}}
+	if err == nil {
+		checks = append(checks, doctor(cfg, staticFS)...)
+	}
+
+	failed := 0
+	for _, c := range checks {
+		if c.Err != nil {
+			failed++
+			fmt.Fprintf(stdout, "FAIL %s: %v\n", c.Name, c.Err)
+		} else {
+			fmt.Fprintf(stdout, "PASS %s: %s\n", c.Name, c.Detail)
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(stdout, "FAIL: %d of %d check(s) failed\n", failed, len(checks))
+		return 1
+	}
+	fmt.Fprintf(stdout, "OK: %d check(s) passed\n", len(checks))
+	return 0
+}
+
+// doctor runs every check of a valid cfg but the configuration's own,
+// with bundle as the embedded assets. It changes nothing: directories are
+// probed, not created, and ports are released as soon as they are bound.
+func doctor(cfg *Config, bundle fs.FS) []doctorCheck {
+	var checks []doctorCheck
+	content := siteContent(cfg, bundle)
+	if !cfg.Dev {
+		c := doctorCheck{Name: "assets", Detail: "embedded assets match " + checksumsFile}
+		if mismatches, err := verifyAssets(bundle, assetChecksums); err != nil {
+			c.Err = err
+		} else if len(mismatches) > 0 {
+			c.Err = fmt.Errorf("%d file(s) differ from %s, first %s", len(mismatches), checksumsFile, mismatches[0])
+		}
+		checks = append(checks, c)
+	}
+	checks = append(checks, doctorCheck{Name: "content", Detail: "questions, achievements, and maps are valid", Err: validateContent(content)})
+	if cfg.Tenants != "" {
+		_, err := loadTenants(cfg, content)
+		checks = append(checks, doctorCheck{Name: "tenants", Detail: cfg.Tenants + " is valid", Err: err})
+	}
+
+	for _, dir := range doctorDirs(cfg) {
+		checks = append(checks, doctorCheck{Name: "storage", Detail: dir + " is writable", Err: checkWritable(dir)})
+	}
+	if cfg.TLSCert != "" {
+		checks = append(checks, doctorCheck{Name: "tls", Detail: cfg.TLSCert + " and " + cfg.TLSKey + " are a valid pair", Err: checkKeyPair(cfg.TLSCert, cfg.TLSKey, time.Now())})
+	}
+	for _, addr := range doctorEndpoints(cfg) {
+		checks = append(checks, doctorCheck{Name: "endpoint", Detail: addr + " is reachable", Err: checkReachable(addr, cfg.Outbound.Dial)})
+	}
+	if cfg.UnixSocket == "" {
+		checks = append(checks, doctorCheck{Name: "listen", Detail: cfg.Addr + " is free", Err: checkListen(cfg.Addr, cfg.PortFallback)})
+	}
+	if cfg.AutocertDomain != "" {
+		checks = append(checks, doctorCheck{Name: "listen", Detail: cfg.RedirectAddr + " is free", Err: checkListen(cfg.RedirectAddr, 0)})
+	}
+	return checks
+}
+
+// doctorDirs returns the directories cfg writes to.
+func doctorDirs(cfg *Config) []string {
+	var dirs []string
+	switch cfg.Store {
+	case "file":
+		dirs = append(dirs, cfg.StoreDir)
+	case "sqlite":
+		if dsn := cfg.storeDSN(); dsn != ":memory:" && !strings.HasPrefix(dsn, "file:") {
+			dirs = append(dirs, filepath.Dir(dsn))
+		}
+	}
+	if cfg.EventsSink == "file" {
+		dirs = append(dirs, filepath.Dir(cfg.eventsFile()))
+	}
+	if cfg.AccessLog != "" && cfg.AccessLog != "-" {
+		dirs = append(dirs, filepath.Dir(cfg.AccessLog))
+	}
+	if cfg.AutocertDomain != "" {
+		dirs = append(dirs, cfg.AutocertCacheDir)
+	}
+	if cfg.UnixSocket != "" {
+		dirs = append(dirs, filepath.Dir(cfg.UnixSocket))
+	}
+	return dedupe(dirs)
+}
+
+// doctorEndpoints returns the host:port of every server cfg sends to:
+// webhook receivers, the SMTP server, and the OAuth providers' token and
+// user endpoints.
+func doctorEndpoints(cfg *Config) []string {
+	var addrs []string
+	urls := cfg.webhookURLs()
+	for _, p := range cfg.oauthProviders() {
+		urls = append(urls, p.config.Endpoint.TokenURL, p.userURL)
+	}
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		port := u.Port()
+		if port == "" {
+			port = "443"
+			if u.Scheme == "http" {
+				port = "80"
+			}
+		}
+		addrs = append(addrs, net.JoinHostPort(u.Hostname(), port))
+	}
+	if cfg.Mail.Host != "" {
+		addrs = append(addrs, net.JoinHostPort(cfg.Mail.Host, strconv.Itoa(cfg.Mail.Port)))
+	}
+	return dedupe(addrs)
+}
+
+// dedupe returns list without repeated entries, in order.
+func dedupe(list []string) []string {
+	seen := make(map[string]bool, len(list))
+	out := list[:0]
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// checkWritable checks that dir is an existing directory a file can be
+// created in. A missing directory fails although the server would create
+// it, since on a deploy it usually means a volume that is not mounted.
+func checkWritable(dir string) error {
+	fi, err := os.Stat(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s does not exist", dir)
+	}
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	f, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkKeyPair checks that certFile and keyFile load as a pair whose
+// certificate is valid at now.
+func checkKeyPair(certFile, keyFile string, now time.Time) error {
+	_, err := loadKeyPair(certFile, keyFile, now)
+	return err
+}
+
+// checkReachable checks that a TCP connection to addr opens within
+// timeout.
+func checkReachable(addr string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// checkListen checks that addr, or with fallback one of the ports after
+// it, can be listened on.
+func checkListen(addr string, fallback int) error {
+	ln, err := listen(addr, fallback)
+	if err != nil {
+		return err
+	}
+	return ln.Close()
+}
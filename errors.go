@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// errorPageTemplate renders the themed HTML error pages.
+var errorPageTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{.Title}} - LobeLabyrinth</title>
+    <link rel="stylesheet" href="{{.Base}}/css/game.css">
+    <link rel="stylesheet" href="{{.Base}}/theme.css">
+</head>
+<body class="help-page">
+<main class="help-content error-page">
+<h1>{{.Icon}} {{.Title}}</h1>
+<p>{{.Message}}</p>
+<p><a href="{{.Base}}/">Return to the castle entrance</a></p>
+</main>
+</body>
+</html>
+`))
+
+// errorPage holds the wording for one themed error status. Base is the
+// base path its links are resolved under, filled in when it is rendered.
+type errorPage struct {
+	Icon, Title, Message string
+	Base                 string
+}
+
+// basePathKey is the context key under which stripBasePath records the
+// base path a request arrived under.
+type basePathKey struct{}
+
+// withBasePath returns a copy of r that records basePath for writeError.
+func withBasePath(r *http.Request, basePath string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), basePathKey{}, basePath))
+}
+
+// requestBasePath returns the base path recorded by withBasePath, or "".
+func requestBasePath(r *http.Request) string {
+	p, _ := r.Context().Value(basePathKey{}).(string)
+	return p
+}
+
+var errorPages = map[int]errorPage{
+	http.StatusForbidden: {
+		Icon:    "🔒",
+		Title:   "The Seal Is Broken",
+		Message: "This passage is barred to you. A shared link may have expired, or been altered on its way here.",
+	},
+	http.StatusNotFound: {
+		Icon:    "🗺️",
+		Title:   "Lost in the Labyrinth",
+		Message: "This passage leads nowhere. The room you seek does not exist in this castle.",
+	},
+	http.StatusInternalServerError: {
+		Icon:    "🔥",
+		Title:   "The Castle Walls Tremble",
+		Message: "Something went wrong within the castle. Please try again in a moment.",
+	},
+	http.StatusServiceUnavailable: {
+		Icon:    "🛠️",
+		Title:   "Closed for Repairs",
+		Message: "The castle's builders are at work within. Its gates will reopen shortly, so please come back soon.",
+	},
+}
+
+// wantsJSON reports whether an error for r should be sent as JSON rather
+// than HTML: API paths always get JSON, as do clients asking only for it.
+func wantsJSON(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// writeError sends a themed error response for status, choosing JSON,
+// HTML, or plain text based on the request.
+func writeError(w http.ResponseWriter, r *http.Request, status int) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	h.Del("ETag")
+	h.Set("Cache-Control", "no-store")
+	switch {
+	case wantsJSON(r):
+		writeAPIError(w, status, strings.ToLower(http.StatusText(status)))
+	case strings.Contains(r.Header.Get("Accept"), "text/html"):
+		page, ok := errorPages[status]
+		if !ok {
+			page = errorPage{Icon: "🏰", Title: http.StatusText(status), Message: http.StatusText(status) + "."}
+		}
+		page.Base = requestBasePath(r)
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		errorPageTemplate.Execute(w, page)
+	default:
+		http.Error(w, http.StatusText(status), status)
+	}
+}
+
+// themedNotFound replaces the plain 404 body from next (typically
+// http.FileServer) with the themed error page, and labels the request
+// unmatched, as it is for the catch-all route.
+func themedNotFound(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&notFoundWriter{ResponseWriter: w, r: r}, r)
+	})
+}
+
+// notFoundWriter intercepts a 404 status and writes the themed page in
+// place of whatever body the wrapped handler produces.
+type notFoundWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	wroteHeader bool
+	intercepted bool
+}
+
+func (nw *notFoundWriter) WriteHeader(code int) {
+	if nw.wroteHeader {
+		return
+	}
+	nw.wroteHeader = true
+	if code == http.StatusNotFound {
+		nw.intercepted = true
+		routeNotFound(nw.r.Context())
+		writeError(nw.ResponseWriter, nw.r, code)
+		return
+	}
+	nw.ResponseWriter.WriteHeader(code)
+}
+
+func (nw *notFoundWriter) Write(p []byte) (int, error) {
+	if !nw.wroteHeader {
+		nw.WriteHeader(http.StatusOK)
+	}
+	if nw.intercepted {
+		return len(p), nil
+	}
+	return nw.ResponseWriter.Write(p)
+}
+
+func (nw *notFoundWriter) Unwrap() http.ResponseWriter {
+	return nw.ResponseWriter
+}
+
+// recoverPanics turns a panic in next into a themed 500 response instead
+// of a crashed server, logging the panic with its stack trace. It wraps
+// the whole handler chain so that a panic in any middleware is caught
+// too. http.ErrAbortHandler is re-raised for net/http to drop the
+// connection quietly, as is any panic after the response has started,
+// since a 500 can no longer be sent.
+func recoverPanics(next http.Handler, basePath string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			slog.ErrorContext(r.Context(), "panic serving request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"panic", fmt.Sprint(v),
+				"stack", string(debug.Stack()),
+			)
+			if rec.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			writeError(w, withBasePath(r, basePath), http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestThemedNotFoundPage(t *testing.T) {
+	s := serveRoutes(t, map[string]http.Handler{"/": themedNotFound(http.FileServer(http.FS(staticFS)))})
+	req, _ := http.NewRequest(http.MethodGet, s.url("/no/such/page.html"), nil)
+	req.Header.Set("Accept", "text/html")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status %d, want 404", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+		t.Errorf("Content-Type = %q, want HTML", got)
+	}
+	for _, want := range []string{errorPages[http.StatusNotFound].Title, "css/game.css"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("404 page lacks %q", want)
+		}
+	}
+}
+
+func TestAPINotFoundIsJSON(t *testing.T) {
+	s := serveRoutes(t, map[string]http.Handler{"/": themedNotFound(http.FileServer(http.FS(staticFS)))})
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if code := getJSON(t, s.url("/api/no-such-endpoint"), &body); code != http.StatusNotFound {
+		t.Errorf("status %d, want 404", code)
+	}
+	if body.Error.Message == "" {
+		t.Error("404 JSON body has no error message")
+	}
+}
+
+func TestThemedServerErrorPage(t *testing.T) {
+	recordLogs(t)
+	h := recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("the drawbridge jammed")
+	}), "")
+	for accept, ctype := range map[string]string{"text/html": "text/html", "application/json": "application/json"} {
+		r := httptest.NewRequest(http.MethodGet, "/help", nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("Accept %s: status %d, want 500", accept, w.Code)
+		}
+		if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, ctype) {
+			t.Errorf("Accept %s: Content-Type = %q", accept, got)
+		}
+		if accept == "text/html" && !strings.Contains(w.Body.String(), errorPages[http.StatusInternalServerError].Title) {
+			t.Error("500 page is not the themed one")
+		}
+		if accept == "application/json" && !json.Valid(w.Body.Bytes()) {
+			t.Errorf("500 JSON body %q is not JSON", w.Body)
+		}
+	}
+}
+
+func TestPanicBecomes500AndServerStaysUp(t *testing.T) {
+	logs := recordLogs(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/boom", func(w http.ResponseWriter, r *http.Request) {
+		var rooms map[string]int
+		rooms["library"]++
+	})
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "still standing")
+	})
+	srv := httptest.NewServer(recoverPanics(mux, ""))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/boom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("panicking handler: status %d, want 500", resp.StatusCode)
+	}
+	stack, ok := logs.attr("panic serving request", "stack")
+	if !ok || !strings.Contains(stack.String(), "errors_test.go") {
+		t.Errorf("panic logged without the stack trace of the handler: %v", stack)
+	}
+
+	resp, err = http.Get(srv.URL + "/ok")
+	if err != nil {
+		t.Fatalf("request after the panic: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "still standing" {
+		t.Errorf("request after the panic: status %d body %q", resp.StatusCode, body)
+	}
+}
+
+func TestPanicRecordedAs500(t *testing.T) {
+	recordLogs(t)
+	var logged, accessed bytes.Buffer
+	logger, err := newLogger(&logged, slog.LevelInfo, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	access := &accessLog{w: &accessed}
+	m := newMetrics()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rooms/{name}", func(w http.ResponseWriter, r *http.Request) {
+		var rooms map[string]int
+		rooms[r.PathValue("name")]++
+	})
+	h := stack{
+		matchRoutes,
+		func(h http.Handler) http.Handler { return recoverPanics(h, "") },
+		func(h http.Handler) http.Handler { return logRequests(h, logger, nil, newLogSampler(1, 0, "")) },
+		func(h http.Handler) http.Handler { return access.middleware(h, nil) },
+		m.instrument,
+	}.then(routeMux(mux))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/rooms/library", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("panicking handler: status %d, want 500", w.Code)
+	}
+
+	var line, record map[string]any
+	if err := json.Unmarshal(logged.Bytes(), &line); err != nil || line["status"] != float64(500) {
+		t.Errorf("request log %q, want one line with status 500", logged.String())
+	}
+	if err := json.Unmarshal(accessed.Bytes(), &record); err != nil || record["status"] != float64(500) {
+		t.Errorf("access log %q, want one line with status 500", accessed.String())
+	}
+	scrape := httptest.NewRecorder()
+	m.handler().ServeHTTP(scrape, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if n := requestCount(scrape.Body.String(), "/rooms/{name}", 500); n != 1 {
+		t.Errorf("the panic counted %d times as a 500, want 1:\n%s", n, scrape.Body)
+	}
+}
+
+func TestRecoverPanicsRethrowsAbort(t *testing.T) {
+	recordLogs(t)
+	for name, handler := range map[string]http.HandlerFunc{
+		"ErrAbortHandler": func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		},
+		"after the response started": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			panic("too late for a 500")
+		},
+	} {
+		func() {
+			defer func() {
+				if v := recover(); v != http.ErrAbortHandler {
+					t.Errorf("%s: recovered %v, want http.ErrAbortHandler re-raised", name, v)
+				}
+			}()
+			recoverPanics(handler, "").ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+	}
+}
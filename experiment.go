@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// experimentStatsNamespace holds the answer totals of each question in
+// each experiment bucket, keyed by experiment, bucket, and question ID
+// joined with "_".
+const experimentStatsNamespace = "experiment-stats"
+
+// validExperimentName is what experiment and bucket names may look like.
+// They leave out "_", which separates them in store keys.
+var validExperimentName = regexp.MustCompile(`^[A-Za-z0-9-]{1,32}$`)
+
+// errNoExperiment reports an experiment that is not defined.
+var errNoExperiment = errors.New("no such experiment")
+
+// questionVariant rewords a question for one experiment bucket. Fields
+// left out keep the question's own.
+type questionVariant struct {
+	Question      *string  `json:"question,omitempty"`
+	Answers       []string `json:"answers,omitempty"`
+	CorrectAnswer *int     `json:"correctAnswer,omitempty"`
+	Explanation   *string  `json:"explanation,omitempty"`
+}
+
+// apply returns q as v rewords it.
+func (v questionVariant) apply(q Question) Question {
+	if v.Question != nil {
+		q.Question = *v.Question
+	}
+	if v.Answers != nil {
+		q.Answers = v.Answers
+	}
+	if v.CorrectAnswer != nil {
+		q.CorrectAnswer = *v.CorrectAnswer
+	}
+	if v.Explanation != nil {
+		q.Explanation = *v.Explanation
+	}
+	return q
+}
+
+// experiment compares phrasings of some questions in one language. Each
+// session is put in one of Buckets, and served the Variants of that
+// bucket, by bucket and then question ID; a bucket without variants sees
+// the questions as they are.
+type experiment struct {
+	ID       string                                `json:"id"`
+	Lang     string                                `json:"lang,omitempty"`
+	Buckets  []string                              `json:"buckets"`
+	Variants map[string]map[string]questionVariant `json:"variants"`
+}
+
+// Bucket returns the bucket of session id. It depends only on the
+// experiment, its buckets, and the session, so a session keeps its
+// bucket for as long as the experiment runs unchanged.
+func (e *experiment) Bucket(sessionID string) string {
+	sum := sha256.Sum256([]byte(e.ID + "\x00" + sessionID))
+	return e.Buckets[binary.BigEndian.Uint64(sum[:8])%uint64(len(e.Buckets))]
+}
+
+// experiments runs the experiments defined in a file, recording how each
+// bucket answers in a Store. A nil *experiments runs none.
+type experiments struct {
+	store  Store
+	banks  map[string]*questionBank
+	byID   map[string]*experiment
+	byQues map[string]*experiment // question ID -> experiment rewording it
+
+	mu sync.Mutex // serializes read-modify-write updates
+}
+
+// loadExperiments reads the experiments defined in the JSON file path, a
+// list of experiment objects, and checks them against the questions in
+// banks, by language. An empty path defines none.
+func loadExperiments(path string, banks map[string]*questionBank, store Store) (*experiments, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var defs []*experiment
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	x := &experiments{store: store, banks: banks, byID: make(map[string]*experiment), byQues: make(map[string]*experiment)}
+	for i, e := range defs {
+		if err := x.add(e); err != nil {
+			return nil, fmt.Errorf("%s: experiments[%d]: %w", path, i, err)
+		}
+	}
+	return x, nil
+}
+
+// add checks e and starts running it.
+func (x *experiments) add(e *experiment) error {
+	if !validExperimentName.MatchString(e.ID) {
+		return fmt.Errorf("id %q must be 1-32 letters, digits, or '-'", e.ID)
+	}
+	if x.byID[e.ID] != nil {
+		return fmt.Errorf("duplicate id %q", e.ID)
+	}
+	if e.Lang == "" {
+		e.Lang = defaultLanguage
+	}
+	bank, ok := x.banks[e.Lang]
+	if !ok {
+		return fmt.Errorf("%s: no questions in language %q", e.ID, e.Lang)
+	}
+	if len(e.Buckets) < 2 {
+		return fmt.Errorf("%s: needs at least 2 buckets", e.ID)
+	}
+	buckets := make(map[string]bool)
+	for _, b := range e.Buckets {
+		if !validExperimentName.MatchString(b) || buckets[b] {
+			return fmt.Errorf("%s: bucket %q must be unique and 1-32 letters, digits, or '-'", e.ID, b)
+		}
+		buckets[b] = true
+	}
+	for _, b := range sortedKeys(e.Variants) {
+		if !buckets[b] {
+			return fmt.Errorf("%s: variants for unknown bucket %q", e.ID, b)
+		}
+		for _, id := range sortedKeys(e.Variants[b]) {
+			q, ok := bank.Get(id)
+			if !ok {
+				return fmt.Errorf("%s: unknown question %q", e.ID, id)
+			}
+			if other := x.byQues[id]; other != nil && other != e {
+				return fmt.Errorf("%s: question %s is already in experiment %s", e.ID, id, other.ID)
+			}
+			if problems := checkQuestions([]Question{e.Variants[b][id].apply(*q)}); len(problems) > 0 {
+				return fmt.Errorf("%s: bucket %s: question %s: %s", e.ID, b, id, problems[0].Message)
+			}
+			x.byQues[id] = e
+		}
+	}
+	x.byID[e.ID] = e
+	return nil
+}
+
+// lookup returns the experiment q, served from bank, is in, if any.
+func (x *experiments) lookup(bank *questionBank, q Question) *experiment {
+	if x == nil {
+		return nil
+	}
+	e := x.byQues[q.ID]
+	if e == nil || x.banks[e.Lang] != bank {
+		return nil
+	}
+	return e
+}
+
+// Apply returns q, served from bank, as the bucket of session id words
+// it. Questions outside any experiment, or served in another language or
+// without a session, are left as they are.
+func (x *experiments) Apply(bank *questionBank, q Question, sessionID string) Question {
+	e := x.lookup(bank, q)
+	if e == nil || sessionID == "" {
+		return q
+	}
+	if v, ok := e.Variants[e.Bucket(sessionID)][q.ID]; ok {
+		return v.apply(q)
+	}
+	return q
+}
+
+// Record counts an answer to q, served from bank to session id, in the
+// results of its bucket. elapsed is how long the player took, or 0 if
+// that is not known.
+func (x *experiments) Record(bank *questionBank, q Question, sessionID string, correct bool, elapsed time.Duration) error {
+	e := x.lookup(bank, q)
+	if e == nil || sessionID == "" {
+		return nil
+	}
+	key := strings.Join([]string{e.ID, e.Bucket(sessionID), q.ID}, "_")
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var t answerTotals
+	data, err := x.store.Get(experimentStatsNamespace, key)
+	switch {
+	case errors.Is(err, ErrNotFound):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &t); err != nil {
+			return fmt.Errorf("experiment stats %s: %w", key, err)
+		}
+	}
+	t.add(correct, elapsed)
+	if data, err = json.Marshal(t); err != nil {
+		return err
+	}
+	return x.store.Set(experimentStatsNamespace, key, data)
+}
+
+// Results returns the statistics of the experiment id, by bucket, each
+// in the given order. Every bucket is listed, answered or not.
+func (x *experiments) Results(id, order string) (map[string][]questionStats, error) {
+	if x == nil || x.byID[id] == nil {
+		return nil, errNoExperiment
+	}
+	results := make(map[string][]questionStats)
+	for _, b := range x.byID[id].Buckets {
+		results[b] = []questionStats{}
+	}
+	keys, err := x.store.List(experimentStatsNamespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		parts := strings.SplitN(key, "_", 3)
+		if len(parts) != 3 || parts[0] != id {
+			continue
+		}
+		if _, ok := results[parts[1]]; !ok {
+			continue // a bucket since dropped from the experiment
+		}
+		data, err := x.store.Get(experimentStatsNamespace, key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var t answerTotals
+		if err := json.Unmarshal(data, &t); err != nil {
+			return nil, fmt.Errorf("experiment stats %s: %w", key, err)
+		}
+		if t.Attempts > 0 {
+			results[parts[1]] = append(results[parts[1]], t.stats(parts[2]))
+		}
+	}
+	// keys are sorted, and so each bucket's questions by ID.
+	for _, stats := range results {
+		sortStats(stats, order)
+	}
+	return results, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExperimentBucketStableAndBalanced(t *testing.T) {
+	e := &experiment{ID: "wording", Buckets: []string{"control", "short", "long"}}
+	counts := map[string]int{}
+	const sessions = 3000
+	for i := range sessions {
+		id := fmt.Sprintf("session-%d", i)
+		b := e.Bucket(id)
+		for range 3 {
+			if again := e.Bucket(id); again != b {
+				t.Fatalf("%s moved from bucket %s to %s", id, b, again)
+			}
+		}
+		counts[b]++
+	}
+	for _, b := range e.Buckets {
+		if share := float64(counts[b]) / sessions; share < 0.28 || share > 0.39 {
+			t.Errorf("bucket %s got %.1f%% of sessions, want about a third", b, share*100)
+		}
+	}
+	// Another experiment buckets the same sessions independently.
+	other := &experiment{ID: "other", Buckets: e.Buckets}
+	same := 0
+	for i := range sessions {
+		id := fmt.Sprintf("session-%d", i)
+		if e.Bucket(id) == other.Bucket(id) {
+			same++
+		}
+	}
+	if same > sessions/2 {
+		t.Errorf("%d of %d sessions share buckets across experiments", same, sessions)
+	}
+}
+
+func TestLoadExperimentsValidated(t *testing.T) {
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	banks := map[string]*questionBank{defaultLanguage: newQuestionBank(questions)}
+	id := questions[0].ID
+	tests := []struct {
+		name, doc, want string
+	}{
+		{"valid", `[{"id":"a","buckets":["x","y"],"variants":{"y":{"` + id + `":{"question":"Shorter?"}}}}]`, ""},
+		{"bad id", `[{"id":"a_b","buckets":["x","y"]}]`, "letters, digits"},
+		{"duplicate id", `[{"id":"a","buckets":["x","y"]},{"id":"a","buckets":["x","y"]}]`, "duplicate id"},
+		{"one bucket", `[{"id":"a","buckets":["x"]}]`, "at least 2 buckets"},
+		{"duplicate bucket", `[{"id":"a","buckets":["x","x"]}]`, "must be unique"},
+		{"unknown bucket", `[{"id":"a","buckets":["x","y"],"variants":{"z":{}}}]`, `unknown bucket "z"`},
+		{"unknown question", `[{"id":"a","buckets":["x","y"],"variants":{"y":{"nope":{}}}}]`, `unknown question "nope"`},
+		{"unknown language", `[{"id":"a","lang":"xx","buckets":["x","y"]}]`, `language "xx"`},
+		{"bad variant", `[{"id":"a","buckets":["x","y"],"variants":{"y":{"` + id + `":{"correctAnswer":9}}}}]`, "correctAnswer 9"},
+		{"question twice", `[{"id":"a","buckets":["x","y"],"variants":{"y":{"` + id + `":{}}}},{"id":"b","buckets":["x","y"],"variants":{"y":{"` + id + `":{}}}}]`, "already in experiment a"},
+		{"malformed", `[{"id":`, "unexpected end"},
+	}
+	for _, tt := range tests {
+		path := writeConfigFile(t, "experiments.json", tt.doc)
+		_, err := loadExperiments(path, banks, newMemoryStore())
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: error %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+	if x, err := loadExperiments("", banks, newMemoryStore()); x != nil || err != nil {
+		t.Errorf("no file: %v, %v, want no experiments", x, err)
+	}
+}
+
+// sessionQuestion returns question id as session sess is served it.
+func sessionQuestion(t *testing.T, s *runningServer, sess sessionResponse, id string) PublicQuestion {
+	t.Helper()
+	var body struct {
+		Questions []PublicQuestion `json:"questions"`
+	}
+	if code := getJSON(t, s.url("/api/questions?count=50&session="+url.QueryEscape(sess.Token)), &body); code != http.StatusOK {
+		t.Fatalf("/api/questions in a session: status %d", code)
+	}
+	for _, q := range body.Questions {
+		if q.ID == id {
+			return q
+		}
+	}
+	t.Fatalf("%s was not served", id)
+	return PublicQuestion{}
+}
+
+func TestExperimentServesAndSeparatesBuckets(t *testing.T) {
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := questions[0]
+	doc := `[{"id":"wording","buckets":["control","short"],"variants":{"short":{"` + q.ID + `":{"question":"Short?","answers":["Yes","No"],"correctAnswer":0}}}}]`
+	s := startServer(t, "-experiments", writeConfigFile(t, "experiments.json", doc), "-public-stats", "-rate-limit", "1000", "-rate-burst", "1000")
+
+	e := &experiment{ID: "wording", Buckets: []string{"control", "short"}}
+	inBucket := map[string]sessionResponse{}
+	for len(inBucket) < 2 {
+		sess := startSession(t, s)
+		inBucket[e.Bucket(sess.SessionID)] = sess
+	}
+	answer := func(sess sessionResponse, shown PublicQuestion, text string) bool {
+		t.Helper()
+		var resp answerResponse
+		choice := slices.Index(shown.Answers, text)
+		if code := postJSON(t, s.url("/api/answer?session="+url.QueryEscape(sess.Token)), map[string]any{"questionID": q.ID, "choiceIndex": choice}, &resp); code != http.StatusOK {
+			t.Fatalf("answering %q: status %d", text, code)
+		}
+		return resp.Correct
+	}
+
+	control := sessionQuestion(t, s, inBucket["control"], q.ID)
+	if control.Question != q.Question {
+		t.Errorf("control bucket served %q, want the question as written", control.Question)
+	}
+	if !answer(inBucket["control"], control, q.Answers[q.CorrectAnswer]) {
+		t.Error("control bucket's correct answer graded wrong")
+	}
+	short := sessionQuestion(t, s, inBucket["short"], q.ID)
+	if short.Question != "Short?" || len(short.Answers) != 2 {
+		t.Errorf("short bucket served %q %v, want its variant", short.Question, short.Answers)
+	}
+	if !answer(inBucket["short"], short, "Yes") || answer(inBucket["short"], short, "No") {
+		t.Error("short bucket not graded against its variant")
+	}
+	// Without a session, the question is served as written.
+	var plain struct {
+		Questions []PublicQuestion `json:"questions"`
+	}
+	getJSON(t, s.url("/api/questions?count=50"), &plain)
+	for _, p := range plain.Questions {
+		if p.ID == q.ID && p.Question != q.Question {
+			t.Errorf("served %q without a session", p.Question)
+		}
+	}
+
+	var body struct {
+		Buckets map[string][]questionStats `json:"buckets"`
+	}
+	if code := getJSON(t, s.url("/api/stats?experiment=wording"), &body); code != http.StatusOK {
+		t.Fatalf("experiment stats: status %d", code)
+	}
+	for bucket, want := range map[string]questionStats{
+		"control": {QuestionID: q.ID, Attempts: 1, Correct: 1},
+		"short":   {QuestionID: q.ID, Attempts: 2, Correct: 1},
+	} {
+		got := body.Buckets[bucket]
+		if len(got) != 1 || got[0].QuestionID != want.QuestionID || got[0].Attempts != want.Attempts || got[0].Correct != want.Correct {
+			data, _ := json.Marshal(got)
+			t.Errorf("bucket %s stats %s, want %d of %d correct", bucket, data, want.Correct, want.Attempts)
+		}
+	}
+	if code := getJSON(t, s.url("/api/stats?experiment=nope"), nil); code != http.StatusNotFound {
+		t.Errorf("unknown experiment: status %d, want 404", code)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// saveFileVersion is the format version written into exported saves.
+	saveFileVersion = 1
+	// maxImportBody bounds an uploaded save file: a saved state plus the
+	// SaveFile envelope.
+	maxImportBody = maxSaveBody + 1<<10
+)
+
+// errFutureVersion and errBadChecksum reject save files this server cannot
+// read or that have been altered since export.
+var (
+	errFutureVersion = errors.New("save file is from a newer version")
+	errBadChecksum   = errors.New("save file checksum does not match its contents")
+)
+
+// saveMigrations upgrades a save file from the version it is keyed by to
+// the next one.
+var saveMigrations = map[int]func([]byte) ([]byte, error){
+	// Version 0 is a bare GameState, as returned by /api/load.
+	0: func(data []byte) ([]byte, error) {
+		var state GameState
+		if err := decodeStrict(data, &state); err != nil {
+			return nil, err
+		}
+		sum, err := stateChecksum(state)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(SaveFile{Version: 1, State: state, Checksum: sum})
+	},
+}
+
+// SaveFile is the downloadable form of a saved game. Checksum is the hex
+// SHA-256 of State encoded as compact JSON, so that tampering or damage
+// can be detected on import.
+type SaveFile struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exportedAt"`
+	State      GameState `json:"state"`
+	Checksum   string    `json:"checksum"`
+}
+
+// stateChecksum returns the SaveFile checksum of state.
+func stateChecksum(state GameState) (string, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	return contentHash(data), nil
+}
+
+// exportHandler serves GET /api/export?token=T, offering the saved game as
+// a SaveFile download.
+func exportHandler(store Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		token := r.URL.Query().Get("token")
+		if !validToken.MatchString(token) {
+			writeAPIError(w, http.StatusBadRequest, "invalid or missing token")
+			return
+		}
+		data, err := traceStore(r.Context(), store).Get(savesNamespace, token)
+		if errors.Is(err, ErrNotFound) {
+			writeAPIError(w, http.StatusNotFound, "no save found")
+			return
+		}
+		file := SaveFile{Version: saveFileVersion, ExportedAt: time.Now().UTC()}
+		if err == nil {
+			err = json.Unmarshal(data, &file.State)
+		}
+		if err == nil {
+			file.Checksum, err = stateChecksum(file.State)
+		}
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "could not export game")
+			return
+		}
+		name := fmt.Sprintf("lobelabyrinth-save-%s.json", file.ExportedAt.Format("2006-01-02"))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, file)
+	})
+}
+
+// decodeStrict decodes data into v, rejecting unknown fields.
+func decodeStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// readSaveFile parses an uploaded save file of any supported version,
+// migrating it to the current one and verifying its checksum.
+func readSaveFile(data []byte) (SaveFile, error) {
+	var file SaveFile
+	var probe struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return file, err
+	}
+	if probe.Version > saveFileVersion {
+		return file, fmt.Errorf("%w (version %d, this server reads up to %d)", errFutureVersion, probe.Version, saveFileVersion)
+	}
+	for v := probe.Version; v < saveFileVersion; v++ {
+		migrate, ok := saveMigrations[v]
+		if !ok {
+			return file, fmt.Errorf("unsupported save file version %d", v)
+		}
+		var err error
+		if data, err = migrate(data); err != nil {
+			return file, fmt.Errorf("migrating save file from version %d: %w", v, err)
+		}
+	}
+	if err := decodeStrict(data, &file); err != nil {
+		return file, err
+	}
+	sum, err := stateChecksum(file.State)
+	if err != nil {
+		return file, err
+	}
+	if sum != file.Checksum {
+		return file, errBadChecksum
+	}
+	return file, nil
+}
+
+// importHandler serves POST /api/import?token=T with a SaveFile body,
+// storing its state as the token's saved game.
+func importHandler(store Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		token := r.URL.Query().Get("token")
+		if !validToken.MatchString(token) {
+			writeAPIError(w, http.StatusBadRequest, "invalid or missing token")
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBody))
+		if errors.As(err, new(*http.MaxBytesError)) {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("save file must be at most %d bytes", maxImportBody))
+			return
+		}
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "could not read save file")
+			return
+		}
+		file, err := readSaveFile(data)
+		if err == nil {
+			err = file.State.validate()
+		}
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid save file: "+err.Error())
+			return
+		}
+		state := file.State
+		state.SavedAt = time.Now().UTC()
+		data, err = json.Marshal(state)
+		if err == nil {
+			err = traceStore(r.Context(), store).Set(savesNamespace, token, data)
+		}
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "could not import game")
+			return
+		}
+		writeJSON(w, http.StatusOK, state)
+	})
+}
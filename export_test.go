@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// exportSaved saves state for testSaveToken in a fresh store and exports
+// it.
+func exportSaved(t *testing.T, state GameState) *httptest.ResponseRecorder {
+	t.Helper()
+	store := newMemoryStore()
+	body, _ := json.Marshal(state)
+	w := httptest.NewRecorder()
+	saveHandler(store).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/save?token="+testSaveToken, strings.NewReader(string(body))))
+	if w.Code != http.StatusOK {
+		t.Fatalf("save: status %d: %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	exportHandler(store).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/export?token="+testSaveToken, nil))
+	return w
+}
+
+func TestExportIsADownload(t *testing.T) {
+	w := exportSaved(t, GameState{Room: "library", Score: 120})
+	if w.Code != http.StatusOK {
+		t.Fatalf("export: status %d: %s", w.Code, w.Body)
+	}
+	disposition := w.Header().Get("Content-Disposition")
+	if !regexp.MustCompile(`^attachment; filename="lobelabyrinth-save-\d{4}-\d{2}-\d{2}\.json"$`).MatchString(disposition) {
+		t.Errorf("Content-Disposition = %q, want a dated attachment", disposition)
+	}
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+		t.Errorf("Content-Type = %q, want JSON", got)
+	}
+}
+
+func TestExportChecksumMatchesPayload(t *testing.T) {
+	w := exportSaved(t, GameState{Room: "armory", Score: 300, AnsweredQuestions: []string{"q003"}})
+	var file struct {
+		Version  int             `json:"version"`
+		State    json.RawMessage `json:"state"`
+		Checksum string          `json:"checksum"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &file); err != nil {
+		t.Fatal(err)
+	}
+	if file.Version != saveFileVersion {
+		t.Errorf("version = %d, want %d", file.Version, saveFileVersion)
+	}
+	var state GameState
+	if err := json.Unmarshal(file.State, &state); err != nil {
+		t.Fatal(err)
+	}
+	compact, _ := json.Marshal(state)
+	sum := sha256.Sum256(compact)
+	if want := hex.EncodeToString(sum[:]); file.Checksum != want {
+		t.Errorf("checksum = %s, want the SHA-256 of the state, %s", file.Checksum, want)
+	}
+	if state.Room != "armory" || state.Score != 300 {
+		t.Errorf("exported state %+v is not the saved one", state)
+	}
+}
+
+func TestExportWithoutSave(t *testing.T) {
+	w := httptest.NewRecorder()
+	exportHandler(newMemoryStore()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/export?token="+testSaveToken, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("export with no save: status %d, want 404", w.Code)
+	}
+	w = httptest.NewRecorder()
+	exportHandler(newMemoryStore()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/export?token=no", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("export with a bad token: status %d, want 400", w.Code)
+	}
+}
+
+// importInto posts body to /api/import for token in store.
+func importInto(store Store, token, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	importHandler(store).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/import?token="+token, strings.NewReader(body)))
+	return w
+}
+
+// loadFrom returns the state saved for token in store.
+func loadFrom(t *testing.T, store Store, token string) GameState {
+	t.Helper()
+	w := httptest.NewRecorder()
+	loadHandler(store).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/load?token="+token, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("load: status %d: %s", w.Code, w.Body)
+	}
+	var state GameState
+	if err := json.Unmarshal(w.Body.Bytes(), &state); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestImportExportedSave(t *testing.T) {
+	exported := exportSaved(t, GameState{Room: "tower", Score: 900, Achievements: []string{"first_steps"}})
+	store := newMemoryStore()
+	if w := importInto(store, "player-token-2", exported.Body.String()); w.Code != http.StatusOK {
+		t.Fatalf("import: status %d: %s", w.Code, w.Body)
+	}
+	if got := loadFrom(t, store, "player-token-2"); got.Room != "tower" || got.Score != 900 || len(got.Achievements) != 1 {
+		t.Errorf("imported state %+v is not the exported one", got)
+	}
+}
+
+func TestImportRejectsTamperedAndFutureFiles(t *testing.T) {
+	exported := exportSaved(t, GameState{Room: "tower", Score: 900}).Body.String()
+	tests := []struct {
+		name, body, want string
+	}{
+		{"tampered score", strings.Replace(exported, `"score":900`, `"score":99999`, 1), "checksum"},
+		{"future version", strings.Replace(exported, `"version":1`, `"version":99`, 1), "newer version"},
+		{"not JSON", "save me", "invalid save file"},
+	}
+	for _, tt := range tests {
+		if tt.body == exported {
+			t.Fatalf("%s: the export has no field to alter: %s", tt.name, exported)
+		}
+		store := newMemoryStore()
+		w := importInto(store, testSaveToken, tt.body)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("%s: status %d: %s, want 400 mentioning %q", tt.name, w.Code, w.Body, tt.want)
+		}
+		if keys, _ := store.List(savesNamespace); len(keys) != 0 {
+			t.Errorf("%s: a rejected import was stored", tt.name)
+		}
+	}
+}
+
+func TestImportMigratesOlderVersion(t *testing.T) {
+	// A version 0 file is a bare GameState, as /api/load returns.
+	store := newMemoryStore()
+	w := importInto(store, testSaveToken, `{"room": "cellar", "score": 40, "answeredQuestions": ["q002"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("import of a version 0 file: status %d: %s", w.Code, w.Body)
+	}
+	if got := loadFrom(t, store, testSaveToken); got.Room != "cellar" || got.Score != 40 {
+		t.Errorf("migrated state %+v, want the room and score of the old file", got)
+	}
+}
+
+func TestImportBoundsUploadSize(t *testing.T) {
+	w := importInto(newMemoryStore(), testSaveToken, `{"version": 1, "state": {"room": "`+strings.Repeat("x", maxImportBody)+`"}}`)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized upload: status %d, want 413", w.Code)
+	}
+}
+
+func TestImportReadErrorIsBadRequest(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/import?token="+testSaveToken, iotest.ErrReader(errors.New("connection reset")))
+	importHandler(newMemoryStore()).ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("an upload cut short: status %d: %s, want 400", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+)
+
+// FeatureFlags switch optional parts of the game on or off for a
+// deployment. A disabled feature's endpoints answer 404.
+type FeatureFlags struct {
+	// Multiplayer covers /ws/room/{id}, /api/matchmake, and
+	// /api/presence.
+	Multiplayer bool `json:"multiplayer"`
+	// Daily covers /api/daily and /api/daily/leaderboard.
+	Daily bool `json:"daily"`
+	// Hints covers /api/hint.
+	Hints bool `json:"hints"`
+}
+
+// requireFeature serves requests with h while enabled reports the named
+// feature is on, and otherwise reports that it is not available. enabled
+// is asked on every request, since a config reload may switch features.
+func requireFeature(enabled func() bool, name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !enabled() {
+			writeAPIError(w, http.StatusNotFound, fmt.Sprintf("the %s feature is disabled on this server", name))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// optionalContent reports whether content holds the files of an optional
+// feature, the first of names that exists, logging that the feature is
+// unavailable when none does. A minimal build may leave them out; only an
+// error other than their absence is returned.
+func optionalContent(content fs.FS, feature string, names ...string) (bool, error) {
+	for _, name := range names {
+		_, err := fs.Stat(content, name)
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return false, err
+		}
+	}
+	slog.Info("optional content missing; feature unavailable", "feature", feature, "paths", names)
+	return false, nil
+}
+
+// unavailableFeature answers every request for the named feature, whose
+// content this build does not have, with 404.
+func unavailableFeature(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("the %s feature is not available on this server", name))
+	})
+}
+
+// featuresHandler serves GET /api/features with the flags in effect in
+// live, so the client can hide what is switched off.
+func featuresHandler(live *liveConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		writeJSON(w, http.StatusOK, live.Load().Features)
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFeaturesReflectConfig(t *testing.T) {
+	var on FeatureFlags
+	s := startServer(t)
+	if code := getJSON(t, s.url("/api/features"), &on); code != http.StatusOK {
+		t.Fatalf("/api/features: status %d", code)
+	}
+	if !on.Multiplayer || !on.Daily || !on.Hints {
+		t.Errorf("default features %+v, want all on", on)
+	}
+
+	var off FeatureFlags
+	s.stop(t)
+	s = startServer(t, "-feature-daily=false", "-feature-hints=false")
+	getJSON(t, s.url("/api/features"), &off)
+	if want := (FeatureFlags{Multiplayer: true}); off != want {
+		t.Errorf("features %+v, want %+v", off, want)
+	}
+}
+
+func TestDisabledFeaturesAnswer404(t *testing.T) {
+	s := startServer(t, "-feature-multiplayer=false", "-feature-daily=false", "-feature-hints=false")
+	for _, path := range []string{"/api/daily", "/api/daily/leaderboard", "/api/hint?questionID=q1", "/api/presence", "/api/matchmake", "/ws/room/crypt"} {
+		if code, _ := get(t, s.url(path)); code != http.StatusNotFound {
+			t.Errorf("%s with its feature off: status %d, want 404", path, code)
+		}
+	}
+	if code := getJSON(t, s.url("/api/questions"), nil); code != http.StatusOK {
+		t.Errorf("/api/questions: status %d; it is not optional", code)
+	}
+}
+
+func TestFeaturesFromFileAndEnvironment(t *testing.T) {
+	file := writeConfigFile(t, "config.json", `{"feature-hints": false}`)
+	cfg, err := loadConfig([]string{"-config", file}, func(key string) string {
+		if key == "LOBELABYRINTH_FEATURE_MULTIPLAYER" {
+			return "false"
+		}
+		return ""
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (FeatureFlags{Daily: true}); cfg.Features != want {
+		t.Errorf("features %+v, want %+v", cfg.Features, want)
+	}
+}
+
+// contentWithout returns the embedded content less the files under each
+// of paths.
+func contentWithout(t *testing.T, paths ...string) fstest.MapFS {
+	t.Helper()
+	content := fstest.MapFS{}
+	err := fs.WalkDir(staticFS, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		for _, p := range paths {
+			if name == p || strings.HasPrefix(name, p+"/") {
+				return nil
+			}
+		}
+		data, err := fs.ReadFile(staticFS, name)
+		content[name] = &fstest.MapFile{Data: data}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestStartsWithoutOptionalContent(t *testing.T) {
+	inTempDir(t)
+	content := contentWithout(t, achievementsFile, defaultMapFile, "maps", questionMediaDir)
+	s := startServerOn(t, content)
+	for _, path := range []string{"/api/v1/achievements", "/api/v1/maps", "/api/v1/maps/tower"} {
+		code, body := get(t, s.url(path))
+		if code != http.StatusNotFound || !strings.Contains(body, "is not available on this server") {
+			t.Errorf("%s: status %d, %s; want the 404 of a missing feature", path, code, body)
+		}
+	}
+	if code, _ := get(t, s.url("/"+questionMediaDir+"/red-planet.png")); code != http.StatusNotFound {
+		t.Errorf("question media: status %d, want 404", code)
+	}
+	if code := getJSON(t, s.url("/api/questions"), nil); code != http.StatusOK {
+		t.Errorf("/api/questions: status %d", code)
+	}
+
+	var missing []string
+	s.logs.mu.Lock()
+	for _, r := range s.logs.records {
+		r.Attrs(func(a slog.Attr) bool {
+			if r.Message == "optional content missing; feature unavailable" && a.Key == "feature" {
+				missing = append(missing, a.Value.String())
+			}
+			return true
+		})
+	}
+	s.logs.mu.Unlock()
+	slices.Sort(missing)
+	if want := []string{"achievements", "maps", "question media"}; !slices.Equal(missing, want) {
+		t.Errorf("logged %v missing, want %v", missing, want)
+	}
+}
+
+func TestMalformedOptionalContentStopsStartup(t *testing.T) {
+	cfg, err := loadConfig([]string{"-addr", "127.0.0.1:0"}, noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{achievementsFile, defaultMapFile} {
+		content := contentWithout(t)
+		content[name] = &fstest.MapFile{Data: []byte(`{"broken":`)}
+		inTempDir(t)
+		if err := serve(content, cfg); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("malformed %s: %v, want an error naming it", name, err)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// immutableCacheControl is sent with fingerprinted assets: their URL
+// changes whenever their content does, so they never need revalidating.
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// fingerprintExts are the extensions of the assets that get
+// content-hashed URLs: the stylesheets and scripts the pages link to.
+var fingerprintExts = map[string]bool{".css": true, ".js": true}
+
+// assetRef matches the href and src attributes the pages link assets with.
+var assetRef = regexp.MustCompile(`\b(href|src)="([^"]+)"`)
+
+// fingerprints maps assets to URLs carrying their content hash, such as
+// css/game.css to css/game.0123456789ab.css, and back.
+type fingerprints struct {
+	hashed  map[string]string
+	logical map[string]string
+}
+
+// newFingerprints names every stylesheet and script in hashes below the
+// top directory (so not sw.js, whose URL must stay put) by its hash.
+func newFingerprints(hashes map[string]string) *fingerprints {
+	f := &fingerprints{hashed: make(map[string]string), logical: make(map[string]string)}
+	for name, hash := range hashes {
+		ext := path.Ext(name)
+		if !fingerprintExts[ext] || !strings.Contains(name, "/") {
+			continue
+		}
+		hashed := strings.TrimSuffix(name, ext) + "." + hash[:12] + ext
+		f.hashed[name] = hashed
+		f.logical[hashed] = name
+	}
+	return f
+}
+
+// URL returns the name an asset is served under.
+func (f *fingerprints) URL(name string) string {
+	if hashed, ok := f.hashed[name]; ok {
+		return hashed
+	}
+	return name
+}
+
+// rewrite points the relative asset links in page at the fingerprinted
+// URLs.
+func (f *fingerprints) rewrite(page []byte) []byte {
+	return assetRef.ReplaceAllFunc(page, func(attr []byte) []byte {
+		m := assetRef.FindSubmatch(attr)
+		if hashed, ok := f.hashed[string(m[2])]; ok {
+			return []byte(string(m[1]) + `="` + hashed + `"`)
+		}
+		return attr
+	})
+}
+
+// rewriteFiles returns content with the links in the named pages
+// rewritten, updating their entries in hashes to match.
+func (f *fingerprints) rewriteFiles(content fs.FS, hashes map[string]string, names ...string) (fs.FS, error) {
+	files := make(map[string][]byte, len(names))
+	for _, name := range names {
+		data, err := fs.ReadFile(content, name)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = f.rewrite(data)
+		hashes[name] = contentHash(files[name])
+	}
+	return overlayFS{FS: content, files: files}, nil
+}
+
+// middleware serves requests for fingerprinted URLs from the underlying
+// asset, marking them immutable.
+func (f *fingerprints) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := f.logical[strings.TrimPrefix(path.Clean(r.URL.Path), "/")]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/" + name
+		r2.URL.RawPath = ""
+		next.ServeHTTP(&immutableWriter{ResponseWriter: w}, r2)
+	})
+}
+
+// immutableWriter replaces the Cache-Control of successful responses
+// with immutableCacheControl.
+type immutableWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *immutableWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if code < http.StatusMultipleChoices || code == http.StatusNotModified {
+			w.Header().Set("Cache-Control", immutableCacheControl)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *immutableWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *immutableWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// overlayFS is an FS whose named files are replaced by in-memory contents.
+type overlayFS struct {
+	fs.FS
+	files map[string][]byte
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	data, ok := o.files[name]
+	if !ok {
+		return o.FS.Open(name)
+	}
+	return &memFile{Reader: bytes.NewReader(data), name: path.Base(name)}, nil
+}
+
+// memFile is an open overlayFS file, and its own FileInfo. It is seekable
+// so that http.FileServer can serve ranges of it.
+type memFile struct {
+	*bytes.Reader
+	name string
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f, nil }
+func (f *memFile) Close() error               { return nil }
+
+func (f *memFile) Name() string       { return f.name }
+func (f *memFile) Mode() fs.FileMode  { return 0o444 }
+func (f *memFile) ModTime() time.Time { return time.Time{} }
+func (f *memFile) IsDir() bool        { return false }
+func (f *memFile) Sys() any           { return nil }
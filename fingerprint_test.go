@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFingerprintsRewriteLinks(t *testing.T) {
+	f := newFingerprints(map[string]string{
+		"css/game.css": "0123456789abcdef0123",
+		"src/game.js":  "fedcba98765432100000",
+		"sw.js":        "aaaaaaaaaaaaaaaaaaaa",
+		"index.html":   "bbbbbbbbbbbbbbbbbbbb",
+	})
+	page := `<link rel="stylesheet" href="css/game.css"><script src="src/game.js"></script>` +
+		`<script src="sw.js"></script><a href="#main">skip</a><link href="css/other.css">`
+	want := `<link rel="stylesheet" href="css/game.0123456789ab.css"><script src="src/game.fedcba987654.js"></script>` +
+		`<script src="sw.js"></script><a href="#main">skip</a><link href="css/other.css">`
+	if got := string(f.rewrite([]byte(page))); got != want {
+		t.Errorf("rewrite =\n%s\nwant\n%s", got, want)
+	}
+	if got := f.URL("sw.js"); got != "sw.js" {
+		t.Errorf("sw.js fingerprinted as %s; its URL must stay put", got)
+	}
+	if got := f.URL("index.html"); got != "index.html" {
+		t.Errorf("index.html fingerprinted as %s", got)
+	}
+}
+
+func TestFingerprintedURLServesAsset(t *testing.T) {
+	fsys := fstest.MapFS{"css/game.css": {Data: []byte("body{}")}}
+	hashes, err := hashAssets(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newFingerprints(hashes)
+	h := f.middleware(cacheStatic(http.FileServer(http.FS(fsys)), hashes))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+f.URL("css/game.css"), nil))
+	if w.Code != http.StatusOK || w.Body.String() != "body{}" {
+		t.Fatalf("hashed URL: status %d body %q, want the stylesheet", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Cache-Control"); got != immutableCacheControl {
+		t.Errorf("hashed URL: Cache-Control = %q, want %q", got, immutableCacheControl)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/css/game.css", nil))
+	if got := w.Header().Get("Cache-Control"); got == immutableCacheControl {
+		t.Error("the logical URL was marked immutable")
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/css/game.000000000000.css", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("stale hash: status %d, want 404", w.Code)
+	}
+}
+
+func TestServerFingerprintsIndexLinks(t *testing.T) {
+	s := startServer(t)
+	_, page := get(t, s.url("/"))
+	link := regexp.MustCompile(`href="(css/game\.[0-9a-f]{12}\.css)"`).FindStringSubmatch(page)
+	if link == nil {
+		t.Fatal("index.html does not link the fingerprinted game.css")
+	}
+	if strings.Contains(page, `href="css/game.css"`) {
+		t.Error("index.html still links the logical game.css")
+	}
+
+	resp, err := http.Get(s.url("/" + link[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	want, err := fs.ReadFile(staticFS, "css/game.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != string(want) {
+		t.Errorf("%s: status %d, want game.css's bytes", link[1], resp.StatusCode)
+	}
+	if got := resp.Header.Get("Cache-Control"); got != immutableCacheControl {
+		t.Errorf("%s: Cache-Control = %q, want %q", link[1], got, immutableCacheControl)
+	}
+
+	if _, sw := get(t, s.url("/sw.js")); !strings.Contains(sw, `"/`+link[1]+`"`) {
+		t.Errorf("service worker does not precache %s", link[1])
+	}
+}
@@ -0,0 +1,65 @@
+//go:build ignore
+
+// gen_brotli writes a Brotli-compressed .br sibling of every CSS, JS, and
+// JSON asset that is large enough to be worth compressing, for the server
+// to embed and serve to clients that accept it. Run it via go generate
+// after changing any of those files.
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// minSize matches defaultCompressMinSize in compress.go.
+const minSize = 1024
+
+func main() {
+	for _, dir := range []string{"css", "data", "maps", "src"} {
+		err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			if strings.HasSuffix(name, ".br") {
+				// Drop variants whose source has gone.
+				if _, err := os.Stat(strings.TrimSuffix(name, ".br")); os.IsNotExist(err) {
+					return os.Remove(name)
+				}
+				return nil
+			}
+			switch filepath.Ext(name) {
+			case ".css", ".js", ".json":
+			default:
+				return nil
+			}
+			data, err := os.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			if len(data) < minSize {
+				if err := os.Remove(name + ".br"); !os.IsNotExist(err) {
+					return err
+				}
+				return nil
+			}
+			var buf bytes.Buffer
+			bw := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+			if _, err := bw.Write(data); err != nil {
+				return err
+			}
+			if err := bw.Close(); err != nil {
+				return err
+			}
+			return os.WriteFile(name+".br", buf.Bytes(), 0o644)
+		})
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatal(err)
+		}
+	}
+}
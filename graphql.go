@@ -0,0 +1,536 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+const (
+	// maxGraphQLDepth is how deeply a GraphQL query may nest fields.
+	maxGraphQLDepth = 8
+	// maxGraphQLComplexity bounds the estimated cost of a GraphQL query:
+	// one per field, with the fields under a list counted once for each
+	// item it may return.
+	maxGraphQLComplexity = 5000
+	// graphqlListCost is the number of items assumed of a list field
+	// queried without a limit.
+	graphqlListCost = 10
+)
+
+// graphqlLists are the fields returning lists of objects, whose
+// selections are paid for once per item.
+var graphqlLists = map[string]bool{
+	"questions":    true,
+	"categories":   true,
+	"entries":      true,
+	"achievements": true,
+	"stats":        true,
+}
+
+// graphqlRequest is the body of POST /api/graphql, and the parameters of
+// GET.
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables"`
+	OperationName string         `json:"operationName"`
+}
+
+// graphqlBankKey and graphqlHTTPKey carry, in a GraphQL request's
+// context, the question bank in the negotiated language and the HTTP
+// request itself.
+type (
+	graphqlBankKey struct{}
+	graphqlHTTPKey struct{}
+)
+
+func graphqlBank(p graphql.ResolveParams) *questionBank {
+	return p.Context.Value(graphqlBankKey{}).(*questionBank)
+}
+
+func graphqlHTTP(p graphql.ResolveParams) *http.Request {
+	return p.Context.Value(graphqlHTTPKey{}).(*http.Request)
+}
+
+// graphqlToken returns the token argument, which must be a valid player
+// token if given.
+func graphqlToken(p graphql.ResolveParams) (string, error) {
+	token, _ := p.Args["token"].(string)
+	if token != "" && !validToken.MatchString(token) {
+		return "", errors.New("invalid token")
+	}
+	return token, nil
+}
+
+// graphqlServices are what the GraphQL schema reads from and writes to:
+// the same services as the REST API. stats is nil unless statistics are
+// public.
+type graphqlServices struct {
+	mod          *moderation
+	achievements *achievementSet
+	tracker      *achievementTracker
+	langs        *languageRegistry
+	lb           *leaderboard
+	categories   *categoryBoards
+	stats        *answerStats
+	answers      *answerer
+}
+
+// newGraphQLSchema builds the schema served at /api/graphql over svc.
+func newGraphQLSchema(svc graphqlServices) (graphql.Schema, error) {
+	category := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Category",
+		Description: "A question category.",
+		Fields: graphql.Fields{
+			"name":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"count": &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Description: "How many questions are in the category."},
+		},
+	})
+	question := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Question",
+		Description: "A quiz question, without its answer key.",
+		Fields: graphql.Fields{
+			"id":         &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"difficulty": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"question":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"answers":    &graphql.Field{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+			"points":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"timeLimit":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"image": &graphql.Field{
+				Type:        graphql.String,
+				Description: "The URL of the question's image, relative to the base path.",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					if image := p.Source.(PublicQuestion).Image; image != "" {
+						return image, nil
+					}
+					return nil, nil
+				},
+			},
+			"category": &graphql.Field{
+				Type: category,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					name := p.Source.(PublicQuestion).Category
+					for _, c := range graphqlBank(p).Categories() {
+						if c.Name == name {
+							return c, nil
+						}
+					}
+					return nil, nil
+				},
+			},
+		},
+	})
+	// questionsField lists visible questions of the category it is asked
+	// of, or of any category asked at the root.
+	questionsField := &graphql.Field{
+		Type: graphql.NewList(question),
+		Args: graphql.FieldConfigArgument{
+			"category":   &graphql.ArgumentConfig{Type: graphql.String},
+			"difficulty": &graphql.ArgumentConfig{Type: graphql.String},
+			"offset":     &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+			"limit":      &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultQuestionCount},
+		},
+		Resolve: func(p graphql.ResolveParams) (any, error) {
+			bank := graphqlBank(p)
+			var categories, levels []string
+			if c, ok := p.Source.(categoryCount); ok {
+				categories = []string{c.Name}
+			} else if c, _ := p.Args["category"].(string); c != "" {
+				if !bank.HasCategory(c) {
+					return nil, fmt.Errorf("unknown category %q", c)
+				}
+				categories = []string{c}
+			}
+			if d, _ := p.Args["difficulty"].(string); d != "" {
+				if !difficulties[d] {
+					return nil, fmt.Errorf("unknown difficulty %q", d)
+				}
+				levels = []string{d}
+			}
+			offset, limit := p.Args["offset"].(int), p.Args["limit"].(int)
+			if offset < 0 || limit < 1 || limit > maxQuestionCount {
+				return nil, fmt.Errorf("offset must not be negative and limit must be between 1 and %d", maxQuestionCount)
+			}
+			matched := svc.mod.Visible(bank.Filter(categories, levels))
+			out := []PublicQuestion{}
+			for i := offset; i < len(matched) && len(out) < limit; i++ {
+				out = append(out, matched[i].Public())
+			}
+			return out, nil
+		},
+	}
+	category.AddFieldConfig("questions", questionsField)
+
+	entry := graphql.NewObject(graphql.ObjectConfig{
+		Name: "LeaderboardEntry",
+		Fields: graphql.Fields{
+			"name":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"score":       &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"timeMs":      &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"submittedAt": &graphql.Field{Type: graphql.DateTime},
+			"profile":     &graphql.Field{Type: graphql.String},
+		},
+	})
+	leaderboardPage := graphql.NewObject(graphql.ObjectConfig{
+		Name: "LeaderboardPage",
+		Fields: graphql.Fields{
+			"total":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"entries": &graphql.Field{Type: graphql.NewList(entry)},
+		},
+	})
+	achievement := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Achievement",
+		Description: "An achievement, and for a player token whether and when it was unlocked.",
+		Fields: graphql.Fields{
+			"id":          &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"name":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"description": &graphql.Field{Type: graphql.String},
+			"icon":        &graphql.Field{Type: graphql.String},
+			"category":    &graphql.Field{Type: graphql.String},
+			"points":      &graphql.Field{Type: graphql.Int},
+			"rarity":      &graphql.Field{Type: graphql.String},
+			"unlocked":    &graphql.Field{Type: graphql.Boolean},
+			"unlockedAt":  &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+	stats := graphql.NewObject(graphql.ObjectConfig{
+		Name: "QuestionStats",
+		Fields: graphql.Fields{
+			"questionID":  &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"attempts":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"correct":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"correctRate": &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+			"avgTimeMs":   &graphql.Field{Type: graphql.Float},
+		},
+	})
+	scoreSnapshotType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "ScoreSnapshot",
+		Description: "A session's score as the server computed it, signed so that it cannot be altered.",
+		Fields: graphql.Fields{
+			"sessionID": &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"score":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"points":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"penalty":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"answered":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"correct":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"streak":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"issuedAt":  &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+			"signature": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+	answerResult := graphql.NewObject(graphql.ObjectConfig{
+		Name: "AnswerResult",
+		Fields: graphql.Fields{
+			"correct":      &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"timedOut":     &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean), Description: "Whether the answer came after the question's time limit and so counts as wrong."},
+			"locked":       &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean), Description: "Whether the session may no longer answer the question."},
+			"attemptsLeft": &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Description: "Wrong answers the session may still give to the question, under an attempt limit."},
+			"explanation":  &graphql.Field{Type: graphql.String},
+			"nextReview":   &graphql.Field{Type: graphql.DateTime},
+			"unlocked":     &graphql.Field{Type: graphql.NewList(graphql.NewNonNull(graphql.ID))},
+			"score":        &graphql.Field{Type: scoreSnapshotType, Description: "The session's running score, for answers given in a session."},
+		},
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"questions": questionsField,
+			"question": &graphql.Field{
+				Type: question,
+				Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					q, ok := graphqlBank(p).Get(p.Args["id"].(string))
+					if !ok || len(svc.mod.Visible([]Question{*q})) == 0 {
+						return nil, nil
+					}
+					return q.Public(), nil
+				},
+			},
+			"categories": &graphql.Field{
+				Type: graphql.NewList(category),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return graphqlBank(p).Categories(), nil
+				},
+			},
+			"leaderboard": &graphql.Field{
+				Type: leaderboardPage,
+				Args: graphql.FieldConfigArgument{
+					"category": &graphql.ArgumentConfig{Type: graphql.String},
+					"offset":   &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+					"limit":    &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultLeaderboardLimit},
+					"sort":     &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "score"},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					offset, limit, order := p.Args["offset"].(int), p.Args["limit"].(int), p.Args["sort"].(string)
+					if offset < 0 || limit < 1 || limit > maxLeaderboardEntries {
+						return nil, fmt.Errorf("offset must not be negative and limit must be between 1 and %d", maxLeaderboardEntries)
+					}
+					if _, ok := leaderboardSorts[order]; !ok {
+						return nil, errors.New("sort must be score, time, or date")
+					}
+					board := svc.lb
+					if c, _ := p.Args["category"].(string); c != "" {
+						var err error
+						if board, _, err = categoryBoard(svc.categories, c); err != nil {
+							return nil, err
+						}
+					}
+					entries, total := board.Page(offset, limit, order)
+					return map[string]any{"total": total, "entries": entries}, nil
+				},
+			},
+			"achievements": &graphql.Field{
+				Type: graphql.NewList(achievement),
+				Args: graphql.FieldConfigArgument{"token": &graphql.ArgumentConfig{Type: graphql.String}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					token, err := graphqlToken(p)
+					if err != nil {
+						return nil, err
+					}
+					variants := svc.achievements.Variants()
+					defs, ok := variants[svc.langs.negotiate(graphqlHTTP(p))]
+					if !ok {
+						defs = variants[defaultLanguage]
+					}
+					var progress playerProgress
+					if token != "" {
+						if progress, err = svc.tracker.Load(token); err != nil {
+							return nil, errors.New("could not load achievements")
+						}
+					}
+					out := make([]map[string]any, len(defs))
+					for i, a := range defs {
+						out[i] = map[string]any{
+							"id": a.ID, "name": a.Name, "description": a.Description, "icon": a.Icon,
+							"category": a.Category, "points": a.Points, "rarity": a.Rarity,
+						}
+						if token != "" {
+							at, unlocked := progress.Unlocked[a.ID]
+							out[i]["unlocked"] = unlocked
+							if unlocked {
+								out[i]["unlockedAt"] = at
+							}
+						}
+					}
+					return out, nil
+				},
+			},
+			"stats": &graphql.Field{
+				Type: graphql.NewList(stats),
+				Args: graphql.FieldConfigArgument{"sort": &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "id"}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					if svc.stats == nil {
+						return nil, errors.New("statistics are not public on this server")
+					}
+					order := p.Args["sort"].(string)
+					if !statsSorts[order] {
+						return nil, errors.New("sort must be id, attempts, or hardest")
+					}
+					all, err := svc.stats.All(order)
+					if err != nil {
+						return nil, errors.New("could not load statistics")
+					}
+					return all, nil
+				},
+			},
+		},
+	})
+
+	mutationType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"answer": &graphql.Field{
+				Type:        answerResult,
+				Description: "Grades an answer as POST /api/answer does. With a session token, choiceIndex is a position in that session's shuffled answers.",
+				Args: graphql.FieldConfigArgument{
+					"questionID":  &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"choiceIndex": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"quality":     &graphql.ArgumentConfig{Type: graphql.Int},
+					"token":       &graphql.ArgumentConfig{Type: graphql.String},
+					"session":     &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					token, err := graphqlToken(p)
+					if err != nil {
+						return nil, err
+					}
+					var sessionID string
+					if s, _ := p.Args["session"].(string); s != "" {
+						claims, err := svc.answers.sess.verify(s, time.Now())
+						if err != nil {
+							return nil, err
+						}
+						sessionID = claims.ID
+					}
+					req := answerRequest{QuestionID: p.Args["questionID"].(string), ChoiceIndex: p.Args["choiceIndex"].(int)}
+					if q, ok := p.Args["quality"].(int); ok {
+						req.Quality = &q
+					}
+					return svc.answers.Grade(graphqlHTTP(p), graphqlBank(p), token, sessionID, req)
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: queryType, Mutation: mutationType})
+}
+
+// queryCost walks the selections in set, expanding fragments, and
+// returns how deeply they nest and their estimated cost. Introspection
+// fields are free, since they are bounded by the schema rather than the
+// data. Fragments already being expanded are skipped, so cycles end.
+func queryCost(set *ast.SelectionSet, fragments map[string]*ast.FragmentDefinition, expanding map[string]bool) (depth, cost int) {
+	if set == nil {
+		return 0, 0
+	}
+	for _, sel := range set.Selections {
+		var d, c int
+		switch sel := sel.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(sel.Name.Value, "__") {
+				continue
+			}
+			d, c = queryCost(sel.SelectionSet, fragments, expanding)
+			if graphqlLists[sel.Name.Value] && sel.SelectionSet != nil {
+				c *= listSize(sel)
+			}
+			d, c = d+1, c+1
+		case *ast.InlineFragment:
+			d, c = queryCost(sel.SelectionSet, fragments, expanding)
+		case *ast.FragmentSpread:
+			name := sel.Name.Value
+			frag, ok := fragments[name]
+			if !ok || expanding[name] {
+				continue
+			}
+			expanding[name] = true
+			d, c = queryCost(frag.SelectionSet, fragments, expanding)
+			delete(expanding, name)
+		}
+		depth, cost = max(depth, d), cost+c
+	}
+	return depth, cost
+}
+
+// listSize is how many items the list field f may return for the sake of
+// its cost: its limit argument when given as a literal, and otherwise
+// graphqlListCost, or the largest limit for a variable.
+func listSize(f *ast.Field) int {
+	for _, arg := range f.Arguments {
+		if arg.Name.Value != "limit" {
+			continue
+		}
+		if v, ok := arg.Value.(*ast.IntValue); ok {
+			var n int
+			if _, err := fmt.Sscan(v.Value, &n); err == nil && n > 0 {
+				return n
+			}
+		}
+		return maxLeaderboardEntries
+	}
+	return graphqlListCost
+}
+
+// checkGraphQL parses query and rejects it if it nests deeper than
+// maxGraphQLDepth or costs more than maxGraphQLComplexity. It reports
+// whether the query contains a mutation.
+func checkGraphQL(query string) (mutation bool, err error) {
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return false, err
+	}
+	fragments := make(map[string]*ast.FragmentDefinition)
+	for _, def := range doc.Definitions {
+		if frag, ok := def.(*ast.FragmentDefinition); ok {
+			fragments[frag.Name.Value] = frag
+		}
+	}
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		mutation = mutation || op.Operation == ast.OperationTypeMutation
+		depth, cost := queryCost(op.SelectionSet, fragments, make(map[string]bool))
+		if depth > maxGraphQLDepth {
+			return mutation, fmt.Errorf("query is nested %d levels deep; at most %d are allowed", depth, maxGraphQLDepth)
+		}
+		if cost > maxGraphQLComplexity {
+			return mutation, fmt.Errorf("query is too expensive (estimated cost %d, at most %d)", cost, maxGraphQLComplexity)
+		}
+	}
+	return mutation, nil
+}
+
+// writeGraphQLError reports a query that was not run, in the GraphQL
+// response format.
+func writeGraphQLError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &graphql.Result{Errors: []gqlerrors.FormattedError{gqlerrors.FormatError(err)}})
+}
+
+// graphqlHandler serves /api/graphql: GET with ?query=Q&variables=V&
+// operationName=N for queries, and POST with a graphqlRequest body for
+// queries and the answer mutation. Questions and achievements are in the
+// negotiated language. Queries nested deeper than maxGraphQLDepth or
+// estimated to cost more than maxGraphQLComplexity are rejected with 400
+// before they run.
+func graphqlHandler(schema graphql.Schema, banks map[string]*questionBank, langs *languageRegistry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req graphqlRequest
+		switch r.Method {
+		case http.MethodGet:
+			query := r.URL.Query()
+			req.Query, req.OperationName = query.Get("query"), query.Get("operationName")
+			if v := query.Get("variables"); v != "" {
+				if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+					writeAPIError(w, http.StatusBadRequest, "variables must be a JSON object")
+					return
+				}
+			}
+		case http.MethodPost:
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeBodyError(w, err, "invalid JSON body")
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if req.Query == "" {
+			writeAPIError(w, http.StatusBadRequest, "query is required")
+			return
+		}
+		mutation, err := checkGraphQL(req.Query)
+		if err != nil {
+			writeGraphQLError(w, http.StatusBadRequest, err)
+			return
+		}
+		if mutation && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeGraphQLError(w, http.StatusMethodNotAllowed, errors.New("mutations must be sent with POST"))
+			return
+		}
+		ctx := context.WithValue(r.Context(), graphqlBankKey{}, localize(w, r, langs, banks))
+		ctx = context.WithValue(ctx, graphqlHTTPKey{}, r)
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			VariableValues: req.Variables,
+			OperationName:  req.OperationName,
+			Context:        ctx,
+		})
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, result)
+	})
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// graphqlResult is a GraphQL response whose data is decoded into Data.
+type graphqlResult[T any] struct {
+	Data   T `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// graphqlMessages joins the messages of a response's errors.
+func (r graphqlResult[T]) messages() string {
+	msgs := make([]string, len(r.Errors))
+	for i, e := range r.Errors {
+		msgs[i] = e.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// postGraphQL posts query with vars to s's GraphQL endpoint and decodes
+// the response into out.
+func postGraphQL[T any](t *testing.T, s *runningServer, query string, vars map[string]any, out *graphqlResult[T]) int {
+	t.Helper()
+	return postJSON(t, s.url("/api/graphql"), graphqlRequest{Query: query, Variables: vars}, out)
+}
+
+func TestGraphQLNestedQuestionsAndCategories(t *testing.T) {
+	s := startServer(t)
+	var resp graphqlResult[struct {
+		Categories []struct {
+			Name      string `json:"name"`
+			Count     int    `json:"count"`
+			Questions []struct {
+				ID       string   `json:"id"`
+				Question string   `json:"question"`
+				Answers  []string `json:"answers"`
+				Category struct {
+					Name string `json:"name"`
+				} `json:"category"`
+			} `json:"questions"`
+		} `json:"categories"`
+	}]
+	query := `{ categories { name count questions(limit: 2) { id question answers category { name } } } }`
+	if code := postGraphQL(t, s, query, nil, &resp); code != http.StatusOK || len(resp.Errors) > 0 {
+		t.Fatalf("status %d, errors %s", code, resp.messages())
+	}
+
+	var body struct {
+		Categories []categoryCount `json:"categories"`
+	}
+	getJSON(t, s.url("/api/categories"), &body)
+	want := body.Categories
+	if len(resp.Data.Categories) != len(want) || len(want) == 0 {
+		t.Fatalf("got %d categories, want the %d of /api/categories", len(resp.Data.Categories), len(want))
+	}
+	for i, c := range resp.Data.Categories {
+		if c.Name != want[i].Name || c.Count != want[i].Count {
+			t.Errorf("category %d = %s (%d), want %s (%d)", i, c.Name, c.Count, want[i].Name, want[i].Count)
+		}
+		if len(c.Questions) == 0 || len(c.Questions) > 2 {
+			t.Errorf("%s: %d questions, want 1 or 2", c.Name, len(c.Questions))
+		}
+		for _, q := range c.Questions {
+			if q.Category.Name != c.Name {
+				t.Errorf("question %s of %s names its category %q", q.ID, c.Name, q.Category.Name)
+			}
+			if q.Question == "" || len(q.Answers) < 2 {
+				t.Errorf("question %s lacks its prompt or answers", q.ID)
+			}
+		}
+	}
+}
+
+func TestGraphQLQuestionByID(t *testing.T) {
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := questions[0]
+	s := startServer(t)
+
+	var resp graphqlResult[struct {
+		Question struct {
+			ID       string   `json:"id"`
+			Answers  []string `json:"answers"`
+			Category struct {
+				Name string `json:"name"`
+			} `json:"category"`
+		} `json:"question"`
+	}]
+	query := `query Q($id: ID!) { question(id: $id) { id answers category { name } } }`
+	if code := postGraphQL(t, s, query, map[string]any{"id": q.ID}, &resp); code != http.StatusOK || len(resp.Errors) > 0 {
+		t.Fatalf("status %d, errors %s", code, resp.messages())
+	}
+	if got := resp.Data.Question; got.ID != q.ID || got.Category.Name != q.Category || len(got.Answers) != len(q.Answers) {
+		t.Errorf("question(id: %s) = %+v", q.ID, got)
+	}
+
+	// The answer key is not part of the schema.
+	var leak graphqlResult[json.RawMessage]
+	postGraphQL(t, s, `{ question(id: "`+q.ID+`") { correctAnswer } }`, nil, &leak)
+	if len(leak.Errors) == 0 {
+		t.Error("querying correctAnswer succeeded")
+	}
+}
+
+func TestGraphQLRejectsDeepQueries(t *testing.T) {
+	s := startServer(t)
+	// Each category { questions } pair is two levels deep.
+	query := "id"
+	for range 5 {
+		query = "category { questions(limit: 1) { " + query + " } }"
+	}
+	query = "{ questions(limit: 1) { " + query + " } }"
+
+	var resp graphqlResult[json.RawMessage]
+	if code := postGraphQL(t, s, query, nil, &resp); code != http.StatusBadRequest {
+		t.Fatalf("deep query: status %d, want 400", code)
+	}
+	if !strings.Contains(resp.messages(), "levels deep") {
+		t.Errorf("deep query errors %q do not mention its depth", resp.messages())
+	}
+	if string(resp.Data) != "" && string(resp.Data) != "null" {
+		t.Errorf("deep query returned data %s", resp.Data)
+	}
+}
+
+func TestCheckGraphQL(t *testing.T) {
+	tests := []struct {
+		name, query, want string
+		mutation          bool
+	}{
+		{"shallow", `{ categories { name questions { id } } }`, "", false},
+		{"deep", `{ a { b { c { d { e { f { g { h { i } } } } } } } } }`, "9 levels deep", false},
+		{"fragment cycle", `{ questions { ...Q } } fragment Q on Question { category { questions { ...Q } } }`, "", false},
+		{"expensive", `{ categories(limit: 100) { questions(limit: 100) { id } } }`, "too expensive", false},
+		{"default limits", `{ categories { questions { category { questions { id } } } } }`, "", false},
+		{"mutation", `mutation { answer(questionID: "q", choiceIndex: 0) { correct } }`, "", true},
+		{"malformed", `{ categories {`, "Syntax Error", false},
+	}
+	for _, tt := range tests {
+		mutation, err := checkGraphQL(tt.query)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: error %v, want one containing %q", tt.name, err, tt.want)
+		case mutation != tt.mutation:
+			t.Errorf("%s: mutation = %t, want %t", tt.name, mutation, tt.mutation)
+		}
+	}
+}
+
+func TestGraphQLAnswerMutation(t *testing.T) {
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := questions[0]
+	s := startServer(t)
+	mutation := `mutation A($id: ID!, $choice: Int!) { answer(questionID: $id, choiceIndex: $choice) { correct explanation } }`
+
+	var resp graphqlResult[struct {
+		Answer struct {
+			Correct     bool   `json:"correct"`
+			Explanation string `json:"explanation"`
+		} `json:"answer"`
+	}]
+	if code := postGraphQL(t, s, mutation, map[string]any{"id": q.ID, "choice": q.CorrectAnswer}, &resp); code != http.StatusOK || len(resp.Errors) > 0 {
+		t.Fatalf("status %d, errors %s", code, resp.messages())
+	}
+	if !resp.Data.Answer.Correct || resp.Data.Answer.Explanation == "" {
+		t.Errorf("correct answer graded %+v", resp.Data.Answer)
+	}
+	wrong := (q.CorrectAnswer + 1) % len(q.Answers)
+	postGraphQL(t, s, mutation, map[string]any{"id": q.ID, "choice": wrong}, &resp)
+	if resp.Data.Answer.Correct {
+		t.Error("wrong answer graded correct")
+	}
+
+	// Mutations change state, so they are not accepted over GET.
+	code, _ := get(t, s.url("/api/graphql?query="+url.QueryEscape(`mutation { answer(questionID: "`+q.ID+`", choiceIndex: 0) { correct } }`)))
+	if code != http.StatusMethodNotAllowed {
+		t.Errorf("mutation over GET: status %d, want 405", code)
+	}
+	code, body := get(t, s.url("/api/graphql?query="+url.QueryEscape(`{ categories { name } }`)))
+	if code != http.StatusOK || !strings.Contains(body, `"categories"`) {
+		t.Errorf("query over GET: status %d, body %s", code, body)
+	}
+}
+
+func TestGraphQLLeaderboard(t *testing.T) {
+	s := startServer(t, "-min-run-time", "0s")
+	submitScore(t, s, startSession(t, s), "ada", 0, 1000)
+	submitScore(t, s, startSession(t, s), "bob", 0, 2000)
+
+	var resp graphqlResult[struct {
+		Leaderboard struct {
+			Total   int `json:"total"`
+			Entries []struct {
+				Name  string `json:"name"`
+				Score int    `json:"score"`
+			} `json:"entries"`
+		} `json:"leaderboard"`
+	}]
+	if code := postGraphQL(t, s, `{ leaderboard(limit: 1) { total entries { name score } } }`, nil, &resp); code != http.StatusOK || len(resp.Errors) > 0 {
+		t.Fatalf("status %d, errors %s", code, resp.messages())
+	}
+	lb := resp.Data.Leaderboard
+	want := boardNames(t, s, "/api/leaderboard")
+	if lb.Total != 2 || len(lb.Entries) != 1 || len(want) != 2 || lb.Entries[0].Name != want[0] {
+		t.Errorf("leaderboard(limit: 1) = %+v, want the first of %v", lb, want)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// health backs the liveness and readiness probes.
+type health struct {
+	ready atomic.Bool
+}
+
+// livenessHandler serves /healthz, which succeeds whenever the server is
+// accepting connections.
+func (h *health) livenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+}
+
+// readinessHandler serves /readyz, which succeeds only once the embedded
+// content has been loaded and checked, and fails again during shutdown so
+// load balancers stop routing new traffic here.
+func (h *health) readinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.ready.Load() {
+			w.Header().Set("Cache-Control", "no-store")
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadinessFollowsContentLoad(t *testing.T) {
+	h := &health{}
+	ready := h.readinessHandler()
+	w := httptest.NewRecorder()
+	ready.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("before content load: status %d, want 503", w.Code)
+	}
+	if !json.Valid(w.Body.Bytes()) {
+		t.Errorf("body %q is not JSON", w.Body)
+	}
+
+	h.ready.Store(true)
+	w = httptest.NewRecorder()
+	ready.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("after content load: status %d, want 200", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	(&health{}).livenessHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("liveness before content load: status %d, want 200", w.Code)
+	}
+}
+
+func TestProbesOnServer(t *testing.T) {
+	s := startServer(t, "-rate-limit", "1", "-rate-burst", "1")
+	for _, path := range []string{"/healthz", "/readyz"} {
+		for i := 0; i < 5; i++ {
+			if code := getJSON(t, s.url(path), nil); code != http.StatusOK {
+				t.Fatalf("%s request %d: status %d, want 200 however often it is polled", path, i, code)
+			}
+		}
+	}
+	if v, ok := s.logs.attr("request", "path"); ok {
+		t.Errorf("probe %s was logged", v)
+	}
+}
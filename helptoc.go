@@ -0,0 +1,92 @@
+package main
+
+import (
+	"html"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// Headings from tocMinLevel to tocMaxLevel are listed in the help page's
+// table of contents. The single h1 is the page title, so it is left out.
+const (
+	tocMinLevel = 2
+	tocMaxLevel = 4
+)
+
+// tocEntry is one heading in the table of contents.
+type tocEntry struct {
+	level    int
+	id, text string
+}
+
+// tocEntries collects the headings of doc that have an ID. goldmark gives
+// every heading one, adding -1, -2, ... to repeated heading text so that
+// each is unique.
+func tocEntries(doc ast.Node, source []byte) []tocEntry {
+	var entries []tocEntry
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		h, ok := n.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		id, _ := h.AttributeString("id")
+		idBytes, _ := id.([]byte)
+		if h.Level >= tocMinLevel && h.Level <= tocMaxLevel && len(idBytes) > 0 {
+			entries = append(entries, tocEntry{level: h.Level, id: string(idBytes), text: headingText(h, source)})
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	return entries
+}
+
+// headingText returns the plain text of heading h, without its markup.
+func headingText(h *ast.Heading, source []byte) string {
+	var b strings.Builder
+	ast.Walk(h, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Text:
+			b.Write(n.Value(source))
+			if n.SoftLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(n.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(b.String())
+}
+
+// tocHTML renders entries as a collapsible table of contents, nesting
+// each heading's list under the heading above it. It is empty when there
+// are no entries.
+func tocHTML(entries []tocEntry) string {
+	if len(entries) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(`<details class="help-toc"><summary>Contents</summary>` + "\n")
+	var open []int // levels of the lists currently open
+	for _, e := range entries {
+		if len(open) == 0 || e.level > open[len(open)-1] {
+			b.WriteString("<ul>\n")
+			open = append(open, e.level)
+		} else {
+			b.WriteString("</li>\n")
+			for len(open) > 1 && open[len(open)-1] > e.level {
+				b.WriteString("</ul></li>\n")
+				open = open[:len(open)-1]
+			}
+		}
+		b.WriteString(`<li><a href="#` + html.EscapeString(e.id) + `">` + html.EscapeString(e.text) + "</a>")
+	}
+	for range open {
+		b.WriteString("</li></ul>\n")
+	}
+	b.WriteString("</details>\n")
+	return b.String()
+}
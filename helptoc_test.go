@@ -0,0 +1,66 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestHelpTOCFollowsHeadings(t *testing.T) {
+	out := READMEHTML("# Title\n\n## Setup\n\n### Install *now*\n\n#### Deep\n\n## Setup\n\n## Play\n\n##### Too deep\n")
+	for _, want := range []string{
+		`<h2 id="setup">Setup</h2>`,
+		`<h2 id="setup-1">Setup</h2>`,
+		`<h3 id="install-now">`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("rendered help lacks %q:\n%s", want, out)
+		}
+	}
+	toc, _, ok := strings.Cut(out, "</details>")
+	if !ok || !strings.HasPrefix(out, `<details class="help-toc"><summary>Contents</summary>`) {
+		t.Fatalf("help does not open with the table of contents:\n%s", out)
+	}
+	want := `<details class="help-toc"><summary>Contents</summary>
+<ul>
+<li><a href="#setup">Setup</a><ul>
+<li><a href="#install-now">Install now</a><ul>
+<li><a href="#deep">Deep</a></li>
+</ul></li>
+</ul></li>
+<li><a href="#setup-1">Setup</a></li>
+<li><a href="#play">Play</a></li></ul>
+`
+	if toc != want {
+		t.Errorf("table of contents:\n%s\nwant:\n%s", toc, want)
+	}
+	if strings.Contains(toc, "Title") || strings.Contains(toc, "Too deep") {
+		t.Error("the table of contents lists the title or a heading below h4")
+	}
+}
+
+func TestHelpTOCOmittedWithoutSections(t *testing.T) {
+	if out := READMEHTML("# Only a title\n\nSome text.\n"); strings.Contains(out, "help-toc") {
+		t.Errorf("a README without sections got a table of contents:\n%s", out)
+	}
+}
+
+func TestHelpPageLinksEverySection(t *testing.T) {
+	s := startServer(t)
+	_, page := get(t, s.url("/help"))
+	links := regexp.MustCompile(`<a href="#([^"]+)">`).FindAllStringSubmatch(page, -1)
+	if len(links) == 0 {
+		t.Fatal("/help has no table of contents links")
+	}
+	seen := map[string]bool{}
+	for _, l := range links {
+		id := l[1]
+		if seen[id] {
+			t.Errorf("anchor %q linked twice", id)
+		}
+		seen[id] = true
+		if !strings.Contains(page, ` id="`+id+`"`) {
+			t.Errorf("/help links #%s, but no element has that id", id)
+		}
+	}
+}
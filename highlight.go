@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+)
+
+// codeStyle colours the help page's code blocks like an illuminated
+// manuscript: iron-gall brown for keywords, vermilion for numbers, and
+// verdigris green for strings, all legible on the inset panel colour. No
+// background is set, so blocks keep the game.css one.
+var codeStyle = chroma.MustNewStyle("lobelabyrinth", chroma.StyleEntries{
+	chroma.Comment:             "italic #5A4E3C",
+	chroma.CommentPreproc:      "noitalic #6B3A1F",
+	chroma.Keyword:             "bold #5C2E0E",
+	chroma.KeywordType:         "nobold #1F4E5F",
+	chroma.KeywordConstant:     "#8B1E1E",
+	chroma.Operator:            "#4A2C17",
+	chroma.Punctuation:         "#3A2A1A",
+	chroma.NameFunction:        "#2E2470",
+	chroma.NameBuiltin:         "#6B4400",
+	chroma.NameClass:           "bold #1F4E5F",
+	chroma.NameTag:             "bold #5C2E0E",
+	chroma.NameAttribute:       "#2E2470",
+	chroma.LiteralString:       "#1E5631",
+	chroma.LiteralStringEscape: "bold #1E5631",
+	chroma.LiteralNumber:       "#8B1E1E",
+	chroma.NameVariable:        "#3B2A6B",
+	chroma.GenericDeleted:      "#8B1E1E",
+	chroma.GenericInserted:     "#1E5631",
+	chroma.GenericHeading:      "bold #5C2E0E",
+	chroma.GenericSubheading:   "bold #6B4400",
+	chroma.GenericEmph:         "italic",
+	chroma.GenericStrong:       "bold",
+	chroma.Error:               "#8B1E1E",
+})
+
+// codeFormatOptions mark up highlighted tokens with classes rather than
+// style attributes; codeStyleSheet holds the rules for them.
+var codeFormatOptions = []chromahtml.Option{chromahtml.WithClasses(true)}
+
+// codeHighlighting highlights fenced code blocks that name their language
+// as the help page is rendered.
+var codeHighlighting = highlighting.NewHighlighting(
+	highlighting.WithCustomStyle(codeStyle),
+	highlighting.WithFormatOptions(codeFormatOptions...),
+)
+
+// codeStyleSheet is the style element the help page head carries for
+// highlighted code. The CSP's style-src allows inline styles.
+var codeStyleSheet = func() string {
+	var b strings.Builder
+	b.WriteString("<style>\n")
+	if err := chromahtml.New(codeFormatOptions...).WriteCSS(&b, codeStyle); err != nil {
+		return ""
+	}
+	b.WriteString("</style>\n")
+	return b.String()
+}()
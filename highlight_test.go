@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHighlightsFencedCode(t *testing.T) {
+	out := READMEHTML("# Example\n\n```go\nfunc main() {\n\tfmt.Println(\"hi\", 42)\n}\n```\n")
+	for _, want := range []string{
+		`<pre class="chroma">`,
+		`<span class="kd">func</span>`,
+		`<span class="s">&#34;hi&#34;</span>`,
+		`<span class="mi">42</span>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("highlighted output lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `style="`) {
+		t.Errorf("highlighted output uses style attributes rather than classes:\n%s", out)
+	}
+}
+
+func TestUnlabelledCodeLeftPlain(t *testing.T) {
+	out := READMEHTML("```\nplain text\n```\n")
+	if !strings.Contains(out, "plain text") || strings.Contains(out, `<span class="kd">`) {
+		t.Errorf("a block with no language was highlighted:\n%s", out)
+	}
+}
+
+func TestCodeStyleSheetInHelpHead(t *testing.T) {
+	head, _, _ := strings.Cut(HELP_CONTENT, "</head>")
+	for _, want := range []string{"<style>", ".chroma .kd { color: #5c2e0e; font-weight: bold }"} {
+		if !strings.Contains(head, want) {
+			t.Errorf("help page head lacks %q", want)
+		}
+	}
+}
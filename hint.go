@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// hintNamespace holds the hints taken in each game session, keyed by
+	// session ID.
+	hintNamespace = "hints"
+	// maxEliminations is how many wrong answers hints may rule out. At
+	// least two answers are always left standing.
+	maxEliminations = 2
+	// maxHintBody bounds a hint request body.
+	maxHintBody = 1 << 10
+)
+
+// errNoMoreHints reports a hint request for a question whose hints have
+// all been taken.
+var errNoMoreHints = errors.New("no more hints for this question")
+
+// sessionHints is what a game session has spent on hints: how many were
+// taken per question ID, and the points they cost in total.
+type sessionHints struct {
+	Taken   map[string]int `json:"taken"`
+	Penalty int            `json:"penalty"`
+	Expires time.Time      `json:"expires"`
+}
+
+// hintLedger keeps per-session hint usage in a Store.
+type hintLedger struct {
+	mu      sync.Mutex // serializes read-modify-write updates
+	store   Store
+	penalty int
+}
+
+func newHintLedger(store Store, penalty int) *hintLedger {
+	return &hintLedger{store: store, penalty: penalty}
+}
+
+// Load returns the hints taken in session id, which are none for a new
+// session.
+func (l *hintLedger) Load(id string) (sessionHints, error) {
+	data, err := l.store.Get(hintNamespace, id)
+	if errors.Is(err, ErrNotFound) {
+		return sessionHints{Taken: map[string]int{}}, nil
+	}
+	if err != nil {
+		return sessionHints{}, err
+	}
+	var h sessionHints
+	if err := json.Unmarshal(data, &h); err != nil {
+		return sessionHints{}, err
+	}
+	if h.Taken == nil {
+		h.Taken = map[string]int{}
+	}
+	return h, nil
+}
+
+// Take spends one more hint on question qid in session id, up to levels,
+// charging the ledger's penalty. It returns the new hint level and the
+// session's total penalty.
+func (l *hintLedger) Take(id, qid string, levels int, now time.Time) (level, penalty int, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h, err := l.Load(id)
+	if err != nil {
+		return 0, 0, err
+	}
+	if h.Taken[qid] >= levels {
+		return h.Taken[qid], h.Penalty, errNoMoreHints
+	}
+	h.Taken[qid]++
+	h.Penalty += l.penalty
+	if h.Expires.IsZero() {
+		h.Expires = now.Add(sessionTTL).UTC()
+	}
+	data, err := json.Marshal(h)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := l.store.Set(hintNamespace, id, data); err != nil {
+		return 0, 0, err
+	}
+	return h.Taken[qid], h.Penalty, nil
+}
+
+// Penalty returns the points session id has spent on hints.
+func (l *hintLedger) Penalty(id string) (int, error) {
+	h, err := l.Load(id)
+	return h.Penalty, err
+}
+
+// collect deletes the records of sessions whose tokens have expired,
+// every interval until ctx is cancelled.
+func (l *hintLedger) collect(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			ids, err := l.store.List(hintNamespace)
+			if err != nil {
+				slog.Warn("could not list hint records", "err", err)
+				continue
+			}
+			l.mu.Lock()
+			for _, id := range ids {
+				if h, err := l.Load(id); err == nil && now.After(h.Expires) {
+					l.store.Delete(hintNamespace, id)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// hintLevels is how many hints question q offers: one per wrong answer
+// that may be ruled out, then the clue if it has one.
+func hintLevels(q *Question) int {
+	n := max(0, min(maxEliminations, len(q.Answers)-2))
+	if q.Hint != "" {
+		n++
+	}
+	return n
+}
+
+// eliminated returns the n wrong answers of q that hints rule out in
+// session sessionID, as positions in that session's shuffled answers.
+// Each level rules out the same answers as the one before plus one more.
+func (s *sessions) eliminated(sessionID string, q *Question, n int) []int {
+	var wrong []int
+	for pos, i := range s.answerOrder(sessionID, q.ID, len(q.Answers)) {
+		if i != q.CorrectAnswer {
+			wrong = append(wrong, pos)
+		}
+	}
+	seed := s.mac("hint", sessionID, q.ID)
+	rng := mathrand.New(mathrand.NewPCG(binary.BigEndian.Uint64(seed[:8]), binary.BigEndian.Uint64(seed[8:16])))
+	rng.Shuffle(len(wrong), func(i, j int) { wrong[i], wrong[j] = wrong[j], wrong[i] })
+	wrong = wrong[:min(n, len(wrong))]
+	sort.Ints(wrong)
+	return wrong
+}
+
+// hintRequest is the body of POST /api/hint.
+type hintRequest struct {
+	QuestionID string `json:"questionID"`
+}
+
+// hintResponse is a hint: the answers ruled out so far, by position in the
+// session's shuffled answers, and at the last level a text clue. Penalty
+// is what the session has spent on hints in total.
+type hintResponse struct {
+	QuestionID string `json:"questionID"`
+	Level      int    `json:"level"`
+	Levels     int    `json:"levels"`
+	Eliminated []int  `json:"eliminated"`
+	Clue       string `json:"clue,omitempty"`
+	Penalty    int    `json:"penalty"`
+}
+
+// hintHandler serves POST /api/hint?session=S, giving the next hint for a
+// question in exchange for the ledger's penalty, which is deducted from
+// the session's leaderboard score. Each call is more revealing than the
+// last, and the correct answer is never named.
+func hintHandler(banks map[string]*questionBank, langs *languageRegistry, ledger *hintLedger, rp *replays, sess *sessions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		sessionID, err := sess.fromQuery(r)
+		if err != nil {
+			writeAPIError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if sessionID == "" {
+			writeAPIError(w, http.StatusBadRequest, "session is required")
+			return
+		}
+		var req hintRequest
+		r.Body = http.MaxBytesReader(w, r.Body, maxHintBody)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeBodyError(w, err, "invalid JSON body")
+			return
+		}
+		q, ok := localize(w, r, langs, banks).Get(req.QuestionID)
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, "unknown question")
+			return
+		}
+		levels := hintLevels(q)
+		if levels == 0 {
+			writeAPIError(w, http.StatusConflict, errNoMoreHints.Error())
+			return
+		}
+		now := time.Now()
+		level, penalty, err := ledger.Take(sessionID, q.ID, levels, now)
+		if errors.Is(err, errNoMoreHints) {
+			writeAPIError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "could not record hint")
+			return
+		}
+		rp.record(sessionID, replayEvent{Type: replayHint, Question: q.ID, Delta: -ledger.penalty}, now)
+
+		elims := levels
+		if q.Hint != "" {
+			elims--
+		}
+		resp := hintResponse{
+			QuestionID: q.ID,
+			Level:      level,
+			Levels:     levels,
+			Eliminated: sess.eliminated(sessionID, q, min(level, elims)),
+			Penalty:    penalty,
+		}
+		if level > elims {
+			resp.Clue = q.Hint
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHintsEscalateAndCapOut(t *testing.T) {
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := questions[0]
+	if len(q.Answers) != 4 || q.Hint == "" {
+		t.Fatalf("question %s needs 4 answers and a clue for this test", q.ID)
+	}
+	s := startServer(t, "-session-secret", string(testSecret), "-hint-penalty", "15")
+	sess := startSession(t, s)
+	hintURL := s.url("/api/hint?session=" + url.QueryEscape(sess.Token))
+	correct := slices.Index(shownQuestions(t, s, sess)[q.ID], q.Answers[q.CorrectAnswer])
+
+	var prev []int
+	for level := 1; level <= 3; level++ {
+		var h hintResponse
+		if code := postJSON(t, hintURL, hintRequest{QuestionID: q.ID}, &h); code != http.StatusOK {
+			t.Fatalf("hint %d: status %d", level, code)
+		}
+		if h.Level != level || h.Levels != 3 || h.Penalty != 15*level {
+			t.Errorf("hint %d: level %d of %d costing %d in all, want level %d of 3 costing %d", level, h.Level, h.Levels, h.Penalty, level, 15*level)
+		}
+		if slices.Contains(h.Eliminated, correct) {
+			t.Fatalf("hint %d ruled out the correct answer", level)
+		}
+		for _, pos := range prev {
+			if !slices.Contains(h.Eliminated, pos) {
+				t.Errorf("hint %d brought back answer %d, ruled out before", level, pos)
+			}
+		}
+		switch {
+		case level < 3 && (len(h.Eliminated) != level || h.Clue != ""):
+			t.Errorf("hint %d: eliminated %v with clue %q, want %d answers ruled out and no clue", level, h.Eliminated, h.Clue, level)
+		case level == 3 && (len(h.Eliminated) != 2 || h.Clue != q.Hint):
+			t.Errorf("last hint: eliminated %v with clue %q, want 2 ruled out and the clue", h.Eliminated, h.Clue)
+		}
+		if strings.Contains(h.Clue, q.Answers[q.CorrectAnswer]) {
+			t.Errorf("hint %d names the correct answer", level)
+		}
+		prev = h.Eliminated
+	}
+	if code := postJSON(t, hintURL, hintRequest{QuestionID: q.ID}, nil); code != http.StatusConflict {
+		t.Errorf("hint past the last level: status %d, want 409", code)
+	}
+
+	// Another session starts without hints taken.
+	var h hintResponse
+	postJSON(t, s.url("/api/hint?session="+url.QueryEscape(startSession(t, s).Token)), hintRequest{QuestionID: q.ID}, &h)
+	if h.Level != 1 || h.Penalty != 15 {
+		t.Errorf("a new session's first hint: %+v", h)
+	}
+}
+
+func TestHintRequiresSessionAndQuestion(t *testing.T) {
+	s := startServer(t, "-session-secret", string(testSecret))
+	if code := postJSON(t, s.url("/api/hint"), hintRequest{QuestionID: "q001"}, nil); code != http.StatusBadRequest {
+		t.Errorf("no session: status %d, want 400", code)
+	}
+	if code := postJSON(t, s.url("/api/hint?session=forged"), hintRequest{QuestionID: "q001"}, nil); code != http.StatusForbidden {
+		t.Errorf("forged session: status %d, want 403", code)
+	}
+	sess := startSession(t, s)
+	if code := postJSON(t, s.url("/api/hint?session="+url.QueryEscape(sess.Token)), hintRequest{QuestionID: "q404"}, nil); code != http.StatusNotFound {
+		t.Errorf("unknown question: status %d, want 404", code)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// historyNamespace holds each player's question history, keyed by player
+// token.
+const historyNamespace = "history"
+
+// questionHistory records when a player last answered each question, by
+// question ID.
+type questionHistory map[string]time.Time
+
+// histories keeps per-player question histories in a Store.
+type histories struct {
+	mu    sync.Mutex // serializes read-modify-write updates
+	store Store
+}
+
+func newHistories(store Store) *histories {
+	return &histories{store: store}
+}
+
+// Load returns the history for token, which is empty for a new player.
+func (h *histories) Load(token string) (questionHistory, error) {
+	data, err := h.store.Get(historyNamespace, token)
+	if errors.Is(err, ErrNotFound) {
+		return questionHistory{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var hist questionHistory
+	if err := json.Unmarshal(data, &hist); err != nil {
+		return nil, err
+	}
+	return hist, nil
+}
+
+// Record notes that the player with token answered question id at t.
+func (h *histories) Record(token, id string, t time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist, err := h.Load(token)
+	if err != nil {
+		return err
+	}
+	hist[id] = t.UTC()
+	data, err := json.Marshal(hist)
+	if err != nil {
+		return err
+	}
+	return h.store.Set(historyNamespace, token, data)
+}
+
+// Reset forgets every question the player with token has answered.
+func (h *histories) Reset(token string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.store.Delete(historyNamespace, token); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// preferUnseen reorders pool so that questions missing from hist come
+// first, in their current order, followed by the rest from least to most
+// recently answered.
+func preferUnseen(pool []PublicQuestion, hist questionHistory) {
+	sort.SliceStable(pool, func(i, j int) bool {
+		return hist[pool[i].ID].Before(hist[pool[j].ID])
+	})
+}
+
+// historyEntry is one answered question as GET /api/history lists it.
+type historyEntry struct {
+	Question   string    `json:"question"`
+	AnsweredAt time.Time `json:"answeredAt"`
+}
+
+// historyHandler serves GET /api/history?token=T&cursor=C&limit=N, the
+// questions the player has answered in question ID order, a page at a
+// time, and DELETE /api/history?token=T, clearing the player's question
+// history so that every question counts as unseen again.
+func historyHandler(h *histories, sess *sessions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodDelete {
+			w.Header().Set("Allow", "GET, HEAD, DELETE")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		token := r.URL.Query().Get("token")
+		if !validToken.MatchString(token) {
+			writeAPIError(w, http.StatusBadRequest, "invalid or missing token")
+			return
+		}
+		if r.Method == http.MethodDelete {
+			if err := h.Reset(token); err != nil {
+				writeAPIError(w, http.StatusInternalServerError, "could not reset history")
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		page, err := cursorParams(r, sess, "history:"+token)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		hist, err := h.Load(token)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "could not load history")
+			return
+		}
+		ids, next := page.Keys(sortedKeys(hist))
+		entries := make([]historyEntry, len(ids))
+		for i, id := range ids {
+			entries[i] = historyEntry{Question: id, AnsweredAt: hist[id]}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"entries": entries, "nextCursor": next})
+	})
+}
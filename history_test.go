@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPreferUnseen(t *testing.T) {
+	now := time.Now()
+	pool := []PublicQuestion{{ID: "old"}, {ID: "new"}, {ID: "fresh1"}, {ID: "older"}, {ID: "fresh2"}}
+	preferUnseen(pool, questionHistory{
+		"old":   now.Add(-time.Hour),
+		"new":   now,
+		"older": now.Add(-2 * time.Hour),
+	})
+	var got []string
+	for _, q := range pool {
+		got = append(got, q.ID)
+	}
+	if want := []string{"fresh1", "fresh2", "older", "old", "new"}; !slices.Equal(got, want) {
+		t.Errorf("order %v, want unseen first, then least recently answered: %v", got, want)
+	}
+}
+
+func TestQuestionsAvoidRepeatsPerPlayer(t *testing.T) {
+	const token = "history-player-1"
+	all, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := startServer(t, "-rate-limit", "1000", "-rate-burst", "1000")
+	keys := make(map[string]int, len(all))
+	for _, q := range all {
+		keys[q.ID] = q.CorrectAnswer
+	}
+	play := func() []string {
+		ids := questionIDs(t, s, "count=4&token="+token)
+		for _, id := range ids {
+			if code := postJSON(t, s.url("/api/answer?token="+token), map[string]any{"questionID": id, "choiceIndex": keys[id]}, nil); code != http.StatusOK {
+				t.Fatalf("answering %s: status %d", id, code)
+			}
+		}
+		return ids
+	}
+
+	seen := map[string]bool{}
+	var first []string
+	for len(seen) < len(all) {
+		ids := play()
+		if first == nil {
+			first = ids
+		}
+		for _, id := range ids {
+			if seen[id] {
+				t.Fatalf("%s repeated after %d of %d questions were seen", id, len(seen), len(all))
+			}
+			seen[id] = true
+		}
+	}
+	if len(all)%4 == 0 {
+		// The bank is exhausted, so the least recently seen come back.
+		again := questionIDs(t, s, "count=4&token="+token)
+		slices.Sort(again)
+		slices.Sort(first)
+		if !slices.Equal(again, first) {
+			t.Errorf("after exhausting the bank got %v, want the first questions seen %v", again, first)
+		}
+	}
+
+	if code := doRequest(t, http.MethodDelete, s.url("/api/history?token="+token)); code != http.StatusNoContent {
+		t.Fatalf("history reset: status %d, want 204", code)
+	}
+	// With no history left, the player gets what an anonymous one does.
+	player, anonymous := questionIDs(t, s, "count=4&seed=7&token="+token), questionIDs(t, s, "count=4&seed=7")
+	if !slices.Equal(player, anonymous) {
+		t.Errorf("after a reset the player got %v, want the unbiased %v", player, anonymous)
+	}
+}
+
+// doRequest sends a bodyless method request to url and returns the
+// status code.
+func doRequest(t *testing.T, method, url string) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// defaultLanguage is the language of the unsuffixed content files, used
+// whenever no better match is available.
+const defaultLanguage = "en"
+
+// localizedFile matches a language variant of a content file, such as
+// data/questions.fr.json or README.pt-BR.md, capturing the base name, the
+// language tag, and the extension.
+var localizedFile = regexp.MustCompile(`^(.+)\.([a-z]{2,3}(?:-[A-Za-z0-9]{2,8})*)(\.(?:md|json))$`)
+
+// localizedName returns the name of the lang variant of the file name.
+func localizedName(name, lang string) string {
+	if lang == defaultLanguage {
+		return name
+	}
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + lang + ext
+}
+
+// languageRegistry records which languages the content is available in
+// and which files have a variant in each.
+type languageRegistry struct {
+	langs    []string // defaultLanguage first, then sorted
+	variants map[string]map[string]bool
+	matcher  language.Matcher
+}
+
+// newLanguageRegistry scans content for localized variants of existing
+// files.
+func newLanguageRegistry(content fs.FS) (*languageRegistry, error) {
+	l := &languageRegistry{variants: make(map[string]map[string]bool)}
+	found := map[string]bool{}
+	err := fs.WalkDir(content, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		m := localizedFile.FindStringSubmatch(name)
+		if m == nil {
+			return nil
+		}
+		tag, err := language.Parse(m[2])
+		if err != nil {
+			return nil
+		}
+		base := m[1] + m[3]
+		if _, err := fs.Stat(content, base); err != nil {
+			return nil
+		}
+		lang := tag.String()
+		if l.variants[base] == nil {
+			l.variants[base] = make(map[string]bool)
+		}
+		l.variants[base][lang] = true
+		found[lang] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	delete(found, defaultLanguage)
+	for lang := range found {
+		l.langs = append(l.langs, lang)
+	}
+	sort.Strings(l.langs)
+	l.langs = append([]string{defaultLanguage}, l.langs...)
+
+	tags := make([]language.Tag, len(l.langs))
+	for i, lang := range l.langs {
+		tags[i] = language.MustParse(lang)
+	}
+	l.matcher = language.NewMatcher(tags)
+	return l, nil
+}
+
+// Languages returns every available language, defaultLanguage first.
+func (l *languageRegistry) Languages() []string {
+	return append([]string(nil), l.langs...)
+}
+
+// negotiate picks the language for r: the ?lang= parameter if it names an
+// available language, otherwise the best match for Accept-Language, and
+// otherwise defaultLanguage.
+func (l *languageRegistry) negotiate(r *http.Request) string {
+	if v := r.URL.Query().Get("lang"); v != "" {
+		if tag, err := language.Parse(v); err == nil {
+			for _, lang := range l.langs {
+				if lang == tag.String() {
+					return lang
+				}
+			}
+		}
+	}
+	if accept := r.Header.Get("Accept-Language"); accept != "" {
+		_, i := language.MatchStrings(l.matcher, accept)
+		return l.langs[i]
+	}
+	return defaultLanguage
+}
+
+// file returns the variant of the content file name to serve for lang,
+// and the language it is in.
+func (l *languageRegistry) file(name, lang string) (string, string) {
+	if l.variants[name][lang] {
+		return localizedName(name, lang), lang
+	}
+	return name, defaultLanguage
+}
+
+// localize returns the entry of variants best matching r, falling back to
+// defaultLanguage, and labels the response with its language.
+func localize[T any](w http.ResponseWriter, r *http.Request, langs *languageRegistry, variants map[string]T) T {
+	lang := langs.negotiate(r)
+	v, ok := variants[lang]
+	if !ok {
+		lang = defaultLanguage
+		v = variants[lang]
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Content-Language", lang)
+	return v
+}
+
+// localizeStatic serves the negotiated language variant in place of any
+// static file that has one, e.g. data/questions.fr.json for
+// data/questions.json, so the client picks up translations unchanged.
+func localizeStatic(next http.Handler, langs *languageRegistry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		if _, ok := langs.variants[name]; !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		file, lang := langs.file(name, langs.negotiate(r))
+		w.Header().Add("Vary", "Accept-Language")
+		w.Header().Set("Content-Language", lang)
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/" + file
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// loadLocalized loads name and each of its language variants from content
+// with load, keyed by language.
+func loadLocalized[T any](content fs.FS, langs *languageRegistry, name string, load func(fs.FS, string) (T, error)) (map[string]T, error) {
+	variants := make(map[string]T)
+	for _, lang := range langs.langs {
+		file, got := langs.file(name, lang)
+		if got != lang {
+			continue
+		}
+		v, err := load(content, file)
+		if err != nil {
+			return nil, err
+		}
+		variants[lang] = v
+	}
+	return variants, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+// localizedContent has Spanish and French variants of some files, and a
+// German file without a default to localize.
+var localizedContent = fstest.MapFS{
+	"README.md":              {Data: []byte("# Help")},
+	"README.es.md":           {Data: []byte("# Ayuda")},
+	"data/questions.json":    {Data: []byte(`{"lang":"en"}`)},
+	"data/questions.fr.json": {Data: []byte(`{"lang":"fr"}`)},
+	"data/orphan.de.json":    {Data: []byte(`{}`)},
+	"css/game.css":           {Data: []byte("body{}")},
+}
+
+func newTestLanguages(t *testing.T) *languageRegistry {
+	t.Helper()
+	langs, err := newLanguageRegistry(localizedContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return langs
+}
+
+func TestLanguageRegistry(t *testing.T) {
+	langs := newTestLanguages(t)
+	if got, want := langs.Languages(), []string{"en", "es", "fr"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Languages() = %v, want %v", got, want)
+	}
+}
+
+func TestLanguageNegotiation(t *testing.T) {
+	langs := newTestLanguages(t)
+	tests := []struct {
+		name, query, accept, want string
+	}{
+		{"no preference", "", "", "en"},
+		{"exact match", "", "fr", "fr"},
+		{"regional variant", "", "es-MX,es;q=0.9", "es"},
+		{"weighted preference", "", "de;q=0.9,fr;q=0.8,es;q=0.5", "fr"},
+		{"missing language", "", "ja", "en"},
+		{"override", "lang=es", "fr", "es"},
+		{"override to missing language", "lang=ja", "fr", "fr"},
+		{"malformed override", "lang=%%%", "es", "es"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/help?"+tt.query, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept-Language", tt.accept)
+		}
+		if got := langs.negotiate(r); got != tt.want {
+			t.Errorf("%s: negotiated %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLocalizeStaticFallsBack(t *testing.T) {
+	h := localizeStatic(http.FileServer(http.FS(localizedContent)), newTestLanguages(t))
+	serve := func(path, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Accept-Language", accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("/data/questions.json", "fr")
+	if w.Body.String() != `{"lang":"fr"}` || w.Header().Get("Content-Language") != "fr" {
+		t.Errorf("French client got %q in %q, want the French questions", w.Body.String(), w.Header().Get("Content-Language"))
+	}
+	w = serve("/data/questions.json", "es")
+	if w.Body.String() != `{"lang":"en"}` || w.Header().Get("Content-Language") != "en" {
+		t.Errorf("Spanish client got %q in %q, want the English fallback", w.Body.String(), w.Header().Get("Content-Language"))
+	}
+	if w = serve("/css/game.css", "fr"); w.Header().Get("Content-Language") != "" {
+		t.Error("a file without variants was labelled with a language")
+	}
+}
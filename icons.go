@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// iconSizes are the square PNG icon sizes served under /icons/, in
+// pixels. Anything else is refused so a request cannot force a huge
+// render.
+var iconSizes = []int{48, 72, 96, 128, 144, 180, 192, 256, 384, 512}
+
+// iconSamples is how many samples per pixel edge are taken when drawing
+// an icon, for anti-aliasing.
+const iconSamples = 4
+
+// defaultIconCacheBytes is the default memory budget for rendered icons,
+// enough to hold every size.
+const defaultIconCacheBytes = 1 << 20
+
+// Default icon colors, the embedded manifest's background and theme.
+var (
+	defaultIconBackground = color.RGBA{0x2C, 0x18, 0x10, 0xFF}
+	defaultIconForeground = color.RGBA{0xD4, 0xAF, 0x37, 0xFF}
+)
+
+// manifestIcons lists the PNG icons for the web app manifest.
+func manifestIcons(basePath string) []map[string]string {
+	icons := make([]map[string]string, len(iconSizes))
+	for i, size := range iconSizes {
+		icons[i] = map[string]string{
+			"src":     fmt.Sprintf("%s/icons/%d.png", basePath, size),
+			"sizes":   fmt.Sprintf("%dx%d", size, size),
+			"type":    "image/png",
+			"purpose": "any",
+		}
+	}
+	return icons
+}
+
+// iconRenderer draws the castle icon in the manifest's colors, keeping
+// the sizes it has rendered in a least-recently-used cache of at most
+// budget bytes of PNG.
+type iconRenderer struct {
+	background, foreground color.RGBA
+	budget                 int64
+	renders                singleflight.Group // one render of each size at a time
+
+	mu    sync.Mutex
+	used  int64
+	lru   *list.List // of *cachedIcon, most recently used first
+	cache map[int]*list.Element
+}
+
+// cachedIcon is a rendered icon in the cache of an iconRenderer.
+type cachedIcon struct {
+	size int
+	data []byte
+}
+
+// newIconRenderer draws in the background_color and theme_color of the
+// generated manifest, where they are #RRGGBB colors, and in the default
+// colors otherwise, caching up to budget bytes of icons.
+func newIconRenderer(manifest []byte, budget int64) *iconRenderer {
+	var m struct {
+		Background string `json:"background_color"`
+		Theme      string `json:"theme_color"`
+	}
+	json.Unmarshal(manifest, &m)
+	ir := &iconRenderer{
+		background: defaultIconBackground,
+		foreground: defaultIconForeground,
+		budget:     budget,
+		lru:        list.New(),
+		cache:      make(map[int]*list.Element),
+	}
+	if c, ok := parseHexColor(m.Background); ok {
+		ir.background = c
+	}
+	if c, ok := parseHexColor(m.Theme); ok {
+		ir.foreground = c
+	}
+	return ir
+}
+
+// parseHexColor parses a #RRGGBB color.
+func parseHexColor(s string) (color.RGBA, bool) {
+	if len(s) != 7 || s[0] != '#' {
+		return color.RGBA{}, false
+	}
+	v, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return color.RGBA{}, false
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xFF}, true
+}
+
+// Cached returns the icon at size pixels if it is in the cache, marking
+// it as the most recently used.
+func (ir *iconRenderer) Cached(size int) ([]byte, bool) {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+	el, ok := ir.cache[size]
+	if !ok {
+		return nil, false
+	}
+	ir.lru.MoveToFront(el)
+	return el.Value.(*cachedIcon).data, true
+}
+
+// PNG returns the icon at size pixels, encoded as PNG, from the cache or
+// rendered afresh. Concurrent calls for a size missing from the cache
+// share one render.
+func (ir *iconRenderer) PNG(size int) ([]byte, error) {
+	if data, ok := ir.Cached(size); ok {
+		return data, nil
+	}
+	v, err, _ := ir.renders.Do(strconv.Itoa(size), func() (any, error) {
+		if data, ok := ir.Cached(size); ok {
+			return data, nil
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, ir.render(size)); err != nil {
+			return nil, err
+		}
+		ir.add(size, buf.Bytes())
+		return buf.Bytes(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
+// add caches data as the icon at size pixels, evicting the least recently
+// used icons until the cache fits its budget. An icon larger than the
+// whole budget is not kept.
+func (ir *iconRenderer) add(size int, data []byte) {
+	if int64(len(data)) > ir.budget {
+		return
+	}
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+	ir.cache[size] = ir.lru.PushFront(&cachedIcon{size: size, data: data})
+	ir.used += int64(len(data))
+	for ir.used > ir.budget {
+		ir.evict(ir.lru.Back())
+	}
+}
+
+// evict drops el from the cache and lets go of its PNG.
+func (ir *iconRenderer) evict(el *list.Element) {
+	icon := ir.lru.Remove(el).(*cachedIcon)
+	delete(ir.cache, icon.size)
+	ir.used -= int64(len(icon.data))
+	icon.data = nil
+}
+
+// render draws the icon: a rounded square of the background color with
+// the castle in the foreground color filling its middle half, laid out
+// like the original SVG icons.
+func (ir *iconRenderer) render(size int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	n := iconSamples * iconSamples
+	for py := 0; py < size; py++ {
+		for px := 0; px < size; px++ {
+			var bg, fg int
+			for sy := 0; sy < iconSamples; sy++ {
+				for sx := 0; sx < iconSamples; sx++ {
+					x := (float64(px) + (float64(sx)+0.5)/iconSamples) / float64(size)
+					y := (float64(py) + (float64(sy)+0.5)/iconSamples) / float64(size)
+					if !inRoundedSquare(x, y, 0.125) {
+						continue
+					}
+					if inCastle((x-0.25)*2, (y-0.25)*2) {
+						fg++
+					} else {
+						bg++
+					}
+				}
+			}
+			if bg+fg == 0 {
+				continue
+			}
+			// Blend the two colors by coverage; the alpha is the share of
+			// samples inside the square.
+			c := color.NRGBA{A: uint8((bg + fg) * 0xFF / n)}
+			mix := func(b, f uint8) uint8 { return uint8((int(b)*bg + int(f)*fg) / (bg + fg)) }
+			c.R = mix(ir.background.R, ir.foreground.R)
+			c.G = mix(ir.background.G, ir.foreground.G)
+			c.B = mix(ir.background.B, ir.foreground.B)
+			img.SetNRGBA(px, py, c)
+		}
+	}
+	return img
+}
+
+// inRoundedSquare reports whether (x, y) lies in the unit square with
+// corners rounded to radius r.
+func inRoundedSquare(x, y, r float64) bool {
+	if x < 0 || x >= 1 || y < 0 || y >= 1 {
+		return false
+	}
+	cx := math.Max(r-x, x-(1-r))
+	cy := math.Max(r-y, y-(1-r))
+	if cx <= 0 || cy <= 0 {
+		return true
+	}
+	return cx*cx+cy*cy <= r*r
+}
+
+// inCastle reports whether (x, y) lies in the castle silhouette drawn in
+// the unit square: two crenellated towers joined by a crenellated wall,
+// with an arched gate and arrow slits cut out.
+func inCastle(x, y float64) bool {
+	in := func(x0, y0, x1, y1 float64) bool { return x >= x0 && x < x1 && y >= y0 && y < y1 }
+	// crenel reports whether x is on one of the raised teeth of a
+	// battlement running from x0 to x1 with the given number of teeth.
+	crenel := func(x0, x1 float64, teeth int) bool {
+		pos := (x - x0) / (x1 - x0) * float64(2*teeth-1)
+		return int(pos)%2 == 0
+	}
+	var solid bool
+	switch {
+	case in(0.05, 0.22, 0.3, 1), in(0.7, 0.22, 0.95, 1):
+		solid = true // towers
+	case in(0.05, 0.1, 0.3, 0.22):
+		solid = crenel(0.05, 0.3, 3)
+	case in(0.7, 0.1, 0.95, 0.22):
+		solid = crenel(0.7, 0.95, 3)
+	case in(0.3, 0.45, 0.7, 1):
+		solid = true // wall
+	case in(0.3, 0.35, 0.7, 0.45):
+		solid = crenel(0.3, 0.7, 3)
+	}
+	if !solid {
+		return false
+	}
+	// The gate: a rectangle topped by a half circle.
+	if in(0.41, 0.72, 0.59, 1) || math.Hypot(x-0.5, y-0.72) < 0.09 {
+		return false
+	}
+	// Arrow slits in the towers.
+	if in(0.16, 0.36, 0.19, 0.52) || in(0.81, 0.36, 0.84, 0.52) {
+		return false
+	}
+	return true
+}
+
+// iconHandler serves /icons/{size}.png for the sizes in iconSizes. Icons
+// in the cache are served at once; rendering one passes through limit,
+// so that only renders count against the concurrency caps.
+func iconHandler(icons *iconRenderer, limit func(http.Handler) http.Handler) http.Handler {
+	serve := func(w http.ResponseWriter, r *http.Request, name string, data []byte) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.Header().Set("ETag", etag(contentHash(data)))
+		http.ServeContent(w, r, name+".png", time.Time{}, bytes.NewReader(data))
+	}
+	render := limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimSuffix(r.PathValue("file"), ".png")
+		size, _ := strconv.Atoi(name)
+		data, err := icons.PNG(size)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError)
+			return
+		}
+		serve(w, r, name, data)
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := strings.CutSuffix(r.PathValue("file"), ".png")
+		size, err := strconv.Atoi(name)
+		if !ok || err != nil || !slices.Contains(iconSizes, size) {
+			writeError(w, r, http.StatusNotFound)
+			return
+		}
+		if data, ok := icons.Cached(size); ok {
+			serve(w, r, name, data)
+			return
+		}
+		render.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// fetchIcon fetches /icons/<size>.png from s and decodes it.
+func fetchIcon(t *testing.T, s *runningServer, size int) image.Image {
+	t.Helper()
+	resp, err := http.Get(s.url("/icons/" + strconv.Itoa(size) + ".png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/png" {
+		t.Fatalf("%d.png: status %d as %s", size, resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("%d.png is not a PNG: %v", size, err)
+	}
+	return img
+}
+
+func TestIconRenderedAtRequestedSize(t *testing.T) {
+	s := startServer(t)
+	for _, size := range []int{48, 192, 512} {
+		if b := fetchIcon(t, s, size).Bounds(); b.Dx() != size || b.Dy() != size {
+			t.Errorf("%d.png is %dx%d", size, b.Dx(), b.Dy())
+		}
+	}
+	for _, path := range []string{"/icons/100.png", "/icons/4096.png", "/icons/192.gif", "/icons/big.png", "/icons/-48.png"} {
+		if code, _ := get(t, s.url(path)); code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", path, code)
+		}
+	}
+}
+
+func TestIconColorsAndCache(t *testing.T) {
+	ir := newIconRenderer([]byte(`{"background_color": "#102030", "theme_color": "#ff8000"}`), defaultIconCacheBytes)
+	data, err := ir.PNG(96)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The middle of the icon is castle; just inside the corner is
+	// background.
+	if got := color.NRGBAModel.Convert(img.At(8, 8)).(color.NRGBA); got != (color.NRGBA{0x10, 0x20, 0x30, 0xff}) {
+		t.Errorf("background pixel %v, want the manifest's #102030", got)
+	}
+	if cached, ok := ir.Cached(96); !ok || !bytes.Equal(cached, data) {
+		t.Error("a rendered icon was not cached")
+	}
+
+	small := newIconRenderer(nil, int64(len(data)))
+	small.PNG(96)
+	small.PNG(48)
+	if _, ok := small.Cached(96); ok {
+		t.Error("the least recently used icon stayed cached past the budget")
+	}
+	if _, ok := small.Cached(48); !ok {
+		t.Error("the latest icon was not cached")
+	}
+}
+
+func TestManifestListsIcons(t *testing.T) {
+	icons := manifestIcons("/games/labyrinth")
+	if len(icons) != len(iconSizes) {
+		t.Fatalf("%d manifest icons, want one per size", len(icons))
+	}
+	if got := icons[0]["src"]; got != "/games/labyrinth/icons/48.png" || icons[0]["sizes"] != "48x48" {
+		t.Errorf("first icon %v", icons[0])
+	}
+}
+
+func TestIconHandlerRendersEachSizeOnce(t *testing.T) {
+	renders := 0
+	limit := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			renders++
+			next.ServeHTTP(w, r)
+		})
+	}
+	ir := newIconRenderer(nil, defaultIconCacheBytes)
+	mux := http.NewServeMux()
+	mux.Handle("/icons/{file}", iconHandler(ir, limit))
+	fetch := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	first := fetch("/icons/192.png")
+	second := fetch("/icons/192.png")
+	if first.Code != http.StatusOK || second.Code != http.StatusOK {
+		t.Fatalf("statuses %d and %d", first.Code, second.Code)
+	}
+	if renders != 1 {
+		t.Errorf("two requests for one size rendered %d times, want once", renders)
+	}
+	if !bytes.Equal(first.Body.Bytes(), second.Body.Bytes()) || first.Header().Get("ETag") != second.Header().Get("ETag") {
+		t.Error("the cached icon differs from the one rendered")
+	}
+	fetch("/icons/48.png")
+	if renders != 2 {
+		t.Errorf("a new size rendered %d times in all, want 2", renders)
+	}
+	if w := fetch("/icons/100.png"); w.Code != http.StatusNotFound || renders != 2 {
+		t.Errorf("unknown size: status %d after %d renders, want 404 without a render", w.Code, renders)
+	}
+}
+
+func TestIconCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	sizes := map[int]int{}
+	for _, size := range []int{48, 72, 96} {
+		data, err := newIconRenderer(nil, 0).PNG(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[size] = len(data)
+	}
+	// Room for 48 with either of the others, but not all three.
+	ir := newIconRenderer(nil, int64(sizes[48]+max(sizes[72], sizes[96])))
+	ir.PNG(48)
+	ir.PNG(72)
+	ir.Cached(48) // 72 is now the least recently used
+	ir.PNG(96)
+	if _, ok := ir.Cached(72); ok {
+		t.Error("the least recently used icon was kept")
+	}
+	if _, ok := ir.Cached(48); !ok {
+		t.Error("a recently used icon was evicted")
+	}
+	if _, ok := ir.Cached(96); !ok {
+		t.Error("the latest icon was not cached")
+	}
+	if ir.used > ir.budget || ir.lru.Len() != len(ir.cache) {
+		t.Errorf("cache holds %d bytes of a %d budget in %d entries indexed %d times", ir.used, ir.budget, ir.lru.Len(), len(ir.cache))
+	}
+
+	none := newIconRenderer(nil, 0)
+	none.PNG(48)
+	if _, ok := none.Cached(48); ok {
+		t.Error("a zero budget cached an icon")
+	}
+}
+
+func TestIconRendersShared(t *testing.T) {
+	ir := newIconRenderer(nil, defaultIconCacheBytes)
+	results := make([][]byte, 8)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = ir.PNG(512)
+		}()
+	}
+	wg.Wait()
+	for i, data := range results {
+		if len(data) == 0 || &data[0] != &results[0][0] {
+			t.Fatalf("call %d got a PNG of its own", i)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// checksumsFile is the committed manifest of the embedded assets' hashes,
+// one "<sha256>  <path>" line per file in the format of sha256sum. It is
+// regenerated by go generate, after the Brotli variants; see main.go.
+const checksumsFile = "checksums.txt"
+
+// assetChecksums is the manifest staticFS is checked against.
+//
+//go:embed checksums.txt
+var assetChecksums []byte
+
+// assetMismatch is a file of a bundle that differs from the manifest.
+type assetMismatch struct {
+	File    string `json:"file"`
+	Problem string `json:"problem"` // "changed", "missing", or "unexpected"
+}
+
+func (m assetMismatch) String() string {
+	return m.Problem + " " + m.File
+}
+
+// formatChecksums returns the manifest of the files in hashes, sorted by
+// path.
+func formatChecksums(hashes map[string]string) []byte {
+	var b strings.Builder
+	for _, name := range sortedKeys(hashes) {
+		fmt.Fprintf(&b, "%s  %s\n", hashes[name], name)
+	}
+	return []byte(b.String())
+}
+
+// parseChecksums reads a manifest written by formatChecksums, keyed by
+// path. Blank lines and lines starting with "#" are skipped.
+func parseChecksums(manifest []byte) (map[string]string, error) {
+	hashes := make(map[string]string)
+	for i, line := range strings.Split(string(manifest), "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hash, name, ok := strings.Cut(line, "  ")
+		if !ok || len(hash) != 64 || strings.Trim(hash, "0123456789abcdef") != "" || name == "" {
+			return nil, fmt.Errorf("line %d: want \"<sha256>  <path>\"", i+1)
+		}
+		if _, dup := hashes[name]; dup {
+			return nil, fmt.Errorf("line %d: %s is listed twice", i+1, name)
+		}
+		hashes[name] = hash
+	}
+	return hashes, nil
+}
+
+// verifyAssets hashes every file of fsys and returns how the files differ
+// from manifest, by path: files whose hash changed, files the manifest
+// lists that fsys lacks, and files of fsys the manifest does not list.
+func verifyAssets(fsys fs.FS, manifest []byte) ([]assetMismatch, error) {
+	want, err := parseChecksums(manifest)
+	if err != nil {
+		return nil, err
+	}
+	got, err := hashAssets(fsys)
+	if err != nil {
+		return nil, err
+	}
+	var mismatches []assetMismatch
+	for name, hash := range want {
+		switch h, ok := got[name]; {
+		case !ok:
+			mismatches = append(mismatches, assetMismatch{File: name, Problem: "missing"})
+		case h != hash:
+			mismatches = append(mismatches, assetMismatch{File: name, Problem: "changed"})
+		}
+	}
+	for name := range got {
+		if _, ok := want[name]; !ok {
+			mismatches = append(mismatches, assetMismatch{File: name, Problem: "unexpected"})
+		}
+	}
+	slices.SortFunc(mismatches, func(a, b assetMismatch) int { return strings.Compare(a.File, b.File) })
+	return mismatches, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestChecksumsRoundTrip(t *testing.T) {
+	hashes := map[string]string{
+		"b.css": contentHash([]byte("b")),
+		"a.js":  contentHash([]byte("a")),
+	}
+	manifest := formatChecksums(hashes)
+	if !strings.HasPrefix(string(manifest), hashes["a.js"]+"  a.js\n") {
+		t.Errorf("manifest is not sorted by path:\n%s", manifest)
+	}
+	got, err := parseChecksums(append([]byte("# comment\n\n"), manifest...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got["a.js"] != hashes["a.js"] || got["b.css"] != hashes["b.css"] {
+		t.Errorf("parsed %v, want %v", got, hashes)
+	}
+}
+
+func TestParseChecksumsRejects(t *testing.T) {
+	hash := contentHash(nil)
+	for _, manifest := range []string{
+		"abc  a.js",
+		hash + " a.js",
+		hash + "  ",
+		strings.ToUpper(hash) + "  a.js",
+		hash + "  a.js\n" + hash + "  a.js",
+	} {
+		if _, err := parseChecksums([]byte(manifest)); err == nil {
+			t.Errorf("%q parsed", manifest)
+		}
+	}
+}
+
+func TestVerifyAssets(t *testing.T) {
+	fsys := fstest.MapFS{"a.js": {Data: []byte("a")}, "b.css": {Data: []byte("b")}}
+	hashes, err := hashAssets(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := formatChecksums(hashes)
+	if mismatches, err := verifyAssets(fsys, manifest); err != nil || len(mismatches) != 0 {
+		t.Errorf("intact bundle: %v, %v", mismatches, err)
+	}
+	fsys["a.js"] = &fstest.MapFile{Data: []byte("tampered")}
+	delete(fsys, "b.css")
+	fsys["c.html"] = &fstest.MapFile{Data: []byte("<p>")}
+	mismatches, err := verifyAssets(fsys, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []assetMismatch{{"a.js", "changed"}, {"b.css", "missing"}, {"c.html", "unexpected"}}
+	if len(mismatches) != len(want) {
+		t.Fatalf("mismatches %v, want %v", mismatches, want)
+	}
+	for i := range want {
+		if mismatches[i] != want[i] {
+			t.Errorf("mismatch %d = %v, want %v", i, mismatches[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxLeaderboardEntries caps how many scores are kept.
+	maxLeaderboardEntries = 100
+	// defaultLeaderboardLimit is how many entries GET returns by default.
+	defaultLeaderboardLimit = 10
+	// maxNameLength bounds a submitted player name, in runes.
+	maxNameLength = 32
+	// maxLeaderboardBody bounds a score submission body.
+	maxLeaderboardBody = 4 << 10
+)
+
+// LeaderboardEntry is one submitted score.
+type LeaderboardEntry struct {
+	Name        string    `json:"name"`
+	Score       int       `json:"score"`
+	TimeMs      int64     `json:"timeMs"`
+	SubmittedAt time.Time `json:"submittedAt"`
+	// Profile is the ID of the signed-in player who submitted the score.
+	Profile string `json:"profile,omitempty"`
+}
+
+// leaderboardNamespace and leaderboardKey locate the stored scores of
+// the all-time leaderboard.
+const (
+	leaderboardNamespace = "leaderboard"
+	leaderboardKey       = "scores"
+)
+
+// categoryBoards holds one leaderboard per question category, opened on
+// first use and stored beside the all-time board under
+// "category-<name>". Only categories bank has questions in are accepted.
+type categoryBoards struct {
+	store Store
+	bank  *questionBank
+
+	mu     sync.Mutex
+	boards map[string]*leaderboard
+}
+
+func newCategoryBoards(store Store, bank *questionBank) *categoryBoards {
+	return &categoryBoards{store: store, bank: bank, boards: make(map[string]*leaderboard)}
+}
+
+// Board returns the leaderboard of category.
+func (c *categoryBoards) Board(category string) (*leaderboard, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if lb, ok := c.boards[category]; ok {
+		return lb, nil
+	}
+	lb, err := openLeaderboard(c.store, "category-"+category)
+	if err != nil {
+		return nil, err
+	}
+	c.boards[category] = lb
+	return lb, nil
+}
+
+// Reset forgets the opened leaderboards, so each is read from the store
+// again on next use.
+func (c *categoryBoards) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.boards)
+}
+
+// leaderboard keeps the best scores sorted and mirrored to a Store.
+type leaderboard struct {
+	mu      sync.Mutex
+	store   Store
+	key     string
+	entries []LeaderboardEntry
+	subs    map[chan struct{}]struct{}
+}
+
+// openLeaderboard loads the leaderboard kept under key in store, starting
+// empty if nothing has been saved yet.
+func openLeaderboard(store Store, key string) (*leaderboard, error) {
+	lb := &leaderboard{store: store, key: key, subs: make(map[chan struct{}]struct{})}
+	data, err := store.Get(leaderboardNamespace, key)
+	if errors.Is(err, ErrNotFound) {
+		return lb, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &lb.entries); err != nil {
+		return nil, fmt.Errorf("leaderboard %s: %w", key, err)
+	}
+	sortEntries(lb.entries)
+	return lb, nil
+}
+
+// Top returns up to n of the highest scores.
+func (lb *leaderboard) Top(n int) []LeaderboardEntry {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	n = min(n, len(lb.entries))
+	return append([]LeaderboardEntry{}, lb.entries[:n]...)
+}
+
+// leaderboardSorts are the orders a page of the leaderboard may be taken
+// in: by score (the board's own order), fastest time first, or newest
+// first. Ties fall back to the board's order.
+var leaderboardSorts = map[string]func(a, b LeaderboardEntry) bool{
+	"score": nil,
+	"time":  func(a, b LeaderboardEntry) bool { return a.TimeMs < b.TimeMs },
+	"date":  func(a, b LeaderboardEntry) bool { return a.SubmittedAt.After(b.SubmittedAt) },
+}
+
+// Page returns up to limit entries starting at offset in the order named
+// by order, a key of leaderboardSorts, and the total number of entries.
+func (lb *leaderboard) Page(offset, limit int, order string) ([]LeaderboardEntry, int) {
+	lb.mu.Lock()
+	entries := append([]LeaderboardEntry{}, lb.entries...)
+	lb.mu.Unlock()
+	if less := leaderboardSorts[order]; less != nil {
+		slices.SortStableFunc(entries, func(a, b LeaderboardEntry) int {
+			switch {
+			case less(a, b):
+				return -1
+			case less(b, a):
+				return 1
+			}
+			return 0
+		})
+	}
+	total := len(entries)
+	offset = min(offset, total)
+	return entries[offset:min(offset+limit, total)], total
+}
+
+// Len returns the number of stored entries.
+func (lb *leaderboard) Len() int {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return len(lb.entries)
+}
+
+// Add records e, drops anything beyond maxLeaderboardEntries, and saves
+// the result. It returns e's rank, counting from 1, or 0 if e did not make
+// the board.
+func (lb *leaderboard) Add(e LeaderboardEntry) (int, error) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.entries = append(lb.entries, e)
+	sortEntries(lb.entries)
+	if len(lb.entries) > maxLeaderboardEntries {
+		lb.entries = lb.entries[:maxLeaderboardEntries]
+	}
+	if err := lb.save(); err != nil {
+		return 0, err
+	}
+	rank := 0
+	for i := range lb.entries {
+		if lb.entries[i] == e {
+			rank = i + 1
+			break
+		}
+	}
+	lb.notify()
+	return rank, nil
+}
+
+// Reload replaces the entries with those now in the store, as after a
+// restore.
+func (lb *leaderboard) Reload() error {
+	var entries []LeaderboardEntry
+	data, err := lb.store.Get(leaderboardNamespace, lb.key)
+	switch {
+	case errors.Is(err, ErrNotFound):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return fmt.Errorf("leaderboard %s: %w", lb.key, err)
+		}
+	}
+	sortEntries(entries)
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.entries = entries
+	lb.notify()
+	return nil
+}
+
+// notify tells each subscriber the leaderboard changed. lb.mu must be
+// held.
+func (lb *leaderboard) notify() {
+	for ch := range lb.subs {
+		select {
+		case ch <- struct{}{}:
+		default: // already has a change pending
+		}
+	}
+}
+
+// Subscribe returns a channel that receives a value after each change to
+// the leaderboard, and a function that stops the subscription.
+func (lb *leaderboard) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	lb.mu.Lock()
+	lb.subs[ch] = struct{}{}
+	lb.mu.Unlock()
+	return ch, func() {
+		lb.mu.Lock()
+		delete(lb.subs, ch)
+		lb.mu.Unlock()
+	}
+}
+
+// Subscribers returns the number of active subscriptions.
+func (lb *leaderboard) Subscribers() int {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return len(lb.subs)
+}
+
+// save writes the entries to the store. lb.mu must be held.
+func (lb *leaderboard) save() error {
+	data, err := json.MarshalIndent(lb.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return lb.store.Set(leaderboardNamespace, lb.key, data)
+}
+
+// sortEntries orders entries by score, breaking ties by the faster time
+// and then the earlier submission.
+func sortEntries(entries []LeaderboardEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.TimeMs != b.TimeMs {
+			return a.TimeMs < b.TimeMs
+		}
+		return a.SubmittedAt.Before(b.SubmittedAt)
+	})
+}
+
+// scoreSubmission is the body of POST /api/leaderboard. Signature is
+// scoreSignature over the score, time, and the session in Token. A score
+// with a Category also goes on that category's board.
+type scoreSubmission struct {
+	Name      string `json:"name"`
+	Category  string `json:"category,omitempty"`
+	Score     int    `json:"score"`
+	TimeMs    int64  `json:"timeMs"`
+	Token     string `json:"token"`
+	Signature string `json:"signature"`
+}
+
+// pageParams reads the offset, limit, and sort query parameters of a
+// leaderboard request, reporting any that are invalid.
+func pageParams(r *http.Request) (offset, limit int, order string, err error) {
+	query := r.URL.Query()
+	limit = defaultLeaderboardLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLeaderboardEntries {
+			return 0, 0, "", fmt.Errorf("limit must be between 1 and %d", maxLeaderboardEntries)
+		}
+		limit = n
+	}
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, "", errors.New("offset must be a non-negative integer")
+		}
+		offset = n
+	}
+	order = "score"
+	if v := query.Get("sort"); v != "" {
+		if _, ok := leaderboardSorts[v]; !ok {
+			return 0, 0, "", errors.New("sort must be score, time, or date")
+		}
+		order = v
+	}
+	return offset, limit, order, nil
+}
+
+// categoryBoard returns the board of the named category, reporting a
+// category that categories, which may be nil, does not know.
+func categoryBoard(categories *categoryBoards, category string) (*leaderboard, int, error) {
+	if categories == nil {
+		return nil, http.StatusBadRequest, errors.New("this leaderboard has no categories")
+	}
+	if !categories.bank.HasCategory(category) {
+		return nil, http.StatusBadRequest, fmt.Errorf("unknown category %q", category)
+	}
+	board, err := categories.Board(category)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("could not load leaderboard")
+	}
+	return board, http.StatusOK, nil
+}
+
+// leaderboardHandler serves GET /api/leaderboard?offset=N&limit=N&sort=S,
+// a page of entries with the total count in X-Total-Count, and POST
+// /api/leaderboard with a signed scoreSubmission body. The time recorded
+// is how long the session ran on the server's clock, not the one
+// submitted, and the score recorded is the one scores computed for the
+// session as its answers were graded, not the one submitted. A score that
+// places in the top webhookTopRank is announced to hooks, and one within
+// mail's top rank emailed. With categories, GET takes ?category=C for
+// that category's board, and a submitted score's category board is
+// updated along with lb. A score submitted while signed in to profiles is
+// linked to the profile. guard caps how often each client may submit and
+// quarantines runs it suspects, answering 202 Accepted for those instead
+// of publishing them. Names follow the rules of claimed ones: a name
+// checkDisplayName refuses or names' filter blocks is 400 Bad Request,
+// and one claimed in names by another player than the submitting profile
+// or session is 409 Conflict. With admit, a session admit fails with
+// errNotServed is 403 Forbidden.
+func leaderboardHandler(lb *leaderboard, categories *categoryBoards, sess *sessions, scores *scorekeeper, hooks *webhooks, mail *mailer, profiles *accounts, guard *scoreGuard, names *nameRegistry, admit func(sessionID string) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			offset, limit, order, err := pageParams(r)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			board := lb
+			if category := r.URL.Query().Get("category"); category != "" {
+				var status int
+				if board, status, err = categoryBoard(categories, category); err != nil {
+					writeAPIError(w, status, err.Error())
+					return
+				}
+			}
+			entries, total := board.Page(offset, limit, order)
+			w.Header().Set("X-Total-Count", strconv.Itoa(total))
+			writeJSON(w, http.StatusOK, map[string]any{"entries": entries})
+
+		case http.MethodPost:
+			var sub scoreSubmission
+			r.Body = http.MaxBytesReader(w, r.Body, maxLeaderboardBody)
+			if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+				writeBodyError(w, err, "invalid JSON body")
+				return
+			}
+			e := LeaderboardEntry{Name: strings.TrimSpace(sub.Name), Score: sub.Score, TimeMs: sub.TimeMs}
+			switch {
+			case e.Name == "":
+				writeAPIError(w, http.StatusBadRequest, "name is required")
+				return
+			case e.Score < 0 || e.TimeMs < 0:
+				writeAPIError(w, http.StatusBadRequest, "score and timeMs must not be negative")
+				return
+			}
+			if err := checkDisplayName(e.Name); err != nil {
+				writeAPIError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if ok, retryAfter := guard.Allow(r); !ok {
+				slog.WarnContext(r.Context(), "leaderboard submission rejected", "reason", "submission rate", "ip", clientIP(r, guard.proxies))
+				writeRetryAfter(w, http.StatusTooManyRequests, "too many score submissions; try again later", retryAfter)
+				return
+			}
+			var board *leaderboard
+			if sub.Category != "" {
+				b, status, err := categoryBoard(categories, sub.Category)
+				if err != nil {
+					writeAPIError(w, status, err.Error())
+					return
+				}
+				board = b
+			}
+			now := time.Now()
+			claims, err := sess.verifyScore(sub.Token, sub.Signature, sub.Score, sub.TimeMs, now)
+			if err != nil {
+				writeAPIError(w, http.StatusForbidden, err.Error())
+				return
+			}
+			if admit != nil {
+				if err := admit(claims.ID); errors.Is(err, errNotServed) {
+					writeAPIError(w, http.StatusForbidden, err.Error())
+					return
+				} else if err != nil {
+					writeAPIError(w, http.StatusInternalServerError, "could not check session")
+					return
+				}
+			}
+			elapsed, err := sess.elapsed(claims.ID, now)
+			if err != nil {
+				writeAPIError(w, http.StatusForbidden, err.Error())
+				return
+			}
+			e.TimeMs = elapsed.Milliseconds()
+			snap, err := scores.Snapshot(claims.ID, now)
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, "could not load score")
+				return
+			}
+			e.Score = snap.Score
+			e.SubmittedAt = now.UTC()
+			if p, err := profiles.Current(r); err == nil {
+				e.Profile = p.ID
+			}
+			switch err := names.Check(e.Name, nameOwner(e.Profile, claims.ID), now); {
+			case errors.Is(err, errNameBlocked):
+				writeAPIError(w, http.StatusBadRequest, err.Error())
+				return
+			case errors.Is(err, errNameTaken):
+				writeAPIError(w, http.StatusConflict, "name is claimed by another player")
+				return
+			case err != nil:
+				writeAPIError(w, http.StatusInternalServerError, "could not check name")
+				return
+			}
+			if err := sess.spend(claims); err != nil {
+				writeAPIError(w, http.StatusForbidden, err.Error())
+				return
+			}
+			if reason := guard.Suspect(claims.ID); reason != "" {
+				boards := []string{lb.key}
+				if board != nil {
+					boards = append(boards, board.key)
+				}
+				if err := guard.Quarantine(e, boards, reason); err != nil {
+					writeAPIError(w, http.StatusInternalServerError, "could not save score")
+					return
+				}
+				slog.WarnContext(r.Context(), "leaderboard score quarantined", "reason", reason, "session", claims.ID, "score", e.Score)
+				writeJSON(w, http.StatusAccepted, e)
+				return
+			}
+			rank, err := lb.Add(e)
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, "could not save score")
+				return
+			}
+			if board != nil {
+				if _, err := board.Add(e); err != nil {
+					writeAPIError(w, http.StatusInternalServerError, "could not save score")
+					return
+				}
+			}
+			if rank > 0 && rank <= webhookTopRank {
+				hooks.Notify(eventLeaderboardTop, map[string]any{"leaderboard": lb.key, "rank": rank, "entry": e})
+			}
+			mail.TopScore(lb.key, rank, e)
+			writeJSON(w, http.StatusCreated, e)
+
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+}
+
+// csvCell guards a player-supplied value against being read as a formula
+// by spreadsheet software.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// leaderboardCSVHandler serves GET /api/leaderboard.csv?sort=S, every
+// entry as a CSV download, with its numbers and dates written for the
+// locale langs negotiates.
+func leaderboardCSVHandler(lb *leaderboard, langs *languageRegistry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		_, _, order, err := pageParams(r)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		entries, total := lb.Page(0, maxLeaderboardEntries, order)
+		loc := langs.locale(r)
+		w.Header().Add("Vary", "Accept-Language")
+		if lang := loc.Lang(); lang != "" {
+			w.Header().Set("Content-Language", lang)
+		}
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+		export := newCSVExport(w, r, "leaderboard.csv", []string{"rank", "name", "score", "timeMs", "submittedAt"})
+		for i, e := range entries {
+			if err := export.Write([]string{
+				loc.Int(int64(i + 1)),
+				csvCell(e.Name),
+				loc.Int(int64(e.Score)),
+				loc.Int(e.TimeMs),
+				loc.Date(e.SubmittedAt),
+			}); err != nil {
+				return
+			}
+		}
+		export.Close()
+	})
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLeaderboardConcurrentSubmissions(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb, err := openLeaderboard(store, leaderboardKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e := LeaderboardEntry{Name: fmt.Sprintf("player%d", i), Score: i * 10, TimeMs: 1000, SubmittedAt: time.Now()}
+			if _, err := lb.Add(e); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	top := lb.Top(maxLeaderboardEntries)
+	if len(top) != 50 {
+		t.Fatalf("%d entries kept, want 50", len(top))
+	}
+	for i := 1; i < len(top); i++ {
+		if top[i-1].Score < top[i].Score {
+			t.Fatalf("entries out of order: %d before %d", top[i-1].Score, top[i].Score)
+		}
+	}
+
+	// A new process opens the board from the same directory.
+	store, err = newFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted, err := openLeaderboard(store, leaderboardKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := restarted.Top(maxLeaderboardEntries)
+	if len(again) != len(top) || again[0].Name != top[0].Name || again[0].Score != 490 {
+		t.Errorf("after a restart the board starts %+v of %d, want %+v of %d", again[0], len(again), top[0], len(top))
+	}
+}
+
+func TestLeaderboardCapsEntries(t *testing.T) {
+	lb, err := openLeaderboard(newMemoryStore(), leaderboardKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxLeaderboardEntries+20; i++ {
+		if _, err := lb.Add(LeaderboardEntry{Name: "p", Score: i, SubmittedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(lb.Top(maxLeaderboardEntries + 20)); n != maxLeaderboardEntries {
+		t.Fatalf("%d entries kept, want the cap of %d", n, maxLeaderboardEntries)
+	}
+	if low := lb.Top(maxLeaderboardEntries)[maxLeaderboardEntries-1].Score; low != 20 {
+		t.Errorf("lowest kept score %d, want 20: the lowest scores are the ones dropped", low)
+	}
+}
+
+func TestLeaderboardSubmitAndList(t *testing.T) {
+	s := startServer(t, "-min-run-time", "0s")
+	for _, name := range []string{"ada", " grace "} {
+		if code := submitScore(t, s, startSession(t, s), name, 0, 1000); code != http.StatusCreated {
+			t.Fatalf("submitting %q: status %d, want 201", name, code)
+		}
+	}
+	if code := getJSON(t, s.url("/api/leaderboard?limit=0"), nil); code != http.StatusBadRequest {
+		t.Errorf("limit=0: status %d, want 400", code)
+	}
+	var board struct {
+		Entries []LeaderboardEntry `json:"entries"`
+	}
+	if code := getJSON(t, s.url("/api/leaderboard?limit=1"), &board); code != http.StatusOK || len(board.Entries) != 1 {
+		t.Fatalf("GET limit=1: status %d with %d entries, want one", code, len(board.Entries))
+	}
+	getJSON(t, s.url("/api/leaderboard"), &board)
+	for _, e := range board.Entries {
+		if e.Name == "grace" && !e.SubmittedAt.IsZero() {
+			return
+		}
+	}
+	t.Errorf("entries %+v, want grace's, trimmed and dated", board.Entries)
+}
+
+func TestLeaderboardRejectsInvalidScores(t *testing.T) {
+	s := startServer(t)
+	for _, body := range []string{
+		`{"score": 10, "timeMs": 1000}`,
+		`{"name": "  ", "score": 10, "timeMs": 1000}`,
+		`{"name": "ada", "score": -1, "timeMs": 1000}`,
+		`{"name": "ada", "score": 10, "timeMs": -5}`,
+		`not json`,
+	} {
+		resp, err := http.Post(s.url("/api/leaderboard"), "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("POST %s: status %d, want 400", body, resp.StatusCode)
+		}
+	}
+}
+
+// pagedBoard returns a board of five entries whose score, time, and date
+// orders all differ.
+func pagedBoard(t *testing.T) *leaderboard {
+	t.Helper()
+	lb, err := openLeaderboard(newMemoryStore(), leaderboardKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, e := range []LeaderboardEntry{
+		{Name: "ada", Score: 500, TimeMs: 9000},
+		{Name: "bob", Score: 400, TimeMs: 1000},
+		{Name: "cy", Score: 300, TimeMs: 5000},
+		{Name: "Smith, Jo", Score: 200, TimeMs: 3000},
+		{Name: `=HYPERLINK("x")`, Score: 100, TimeMs: 7000},
+	} {
+		e.SubmittedAt = start.Add(time.Duration(i) * time.Hour)
+		if _, err := lb.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return lb
+}
+
+// pageNames fetches a page of h with query and returns the entry names
+// and the X-Total-Count header.
+func pageNames(t *testing.T, h http.Handler, query string) (int, []string, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/leaderboard?"+query, nil))
+	var body struct {
+		Entries []LeaderboardEntry `json:"entries"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	names := make([]string, len(body.Entries))
+	for i, e := range body.Entries {
+		names[i] = e.Name
+	}
+	return w.Code, names, w.Header().Get("X-Total-Count")
+}
+
+func TestLeaderboardPagination(t *testing.T) {
+	h := leaderboardHandler(pagedBoard(t), nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"ada", "bob", "cy", "Smith, Jo", `=HYPERLINK("x")`}},
+		{"limit=2", []string{"ada", "bob"}},
+		{"offset=2&limit=2", []string{"cy", "Smith, Jo"}},
+		{"offset=4&limit=2", []string{`=HYPERLINK("x")`}},
+		{"offset=5", []string{}},
+		{"offset=50", []string{}},
+		{"sort=score&limit=1", []string{"ada"}},
+		{"sort=time", []string{"bob", "Smith, Jo", "cy", `=HYPERLINK("x")`, "ada"}},
+		{"sort=date", []string{`=HYPERLINK("x")`, "Smith, Jo", "cy", "bob", "ada"}},
+		{"sort=time&offset=1&limit=2", []string{"Smith, Jo", "cy"}},
+	}
+	for _, tt := range tests {
+		code, names, total := pageNames(t, h, tt.query)
+		if code != http.StatusOK || !slices.Equal(names, tt.want) {
+			t.Errorf("?%s: status %d, entries %v, want %v", tt.query, code, names, tt.want)
+		}
+		if total != "5" {
+			t.Errorf("?%s: X-Total-Count %q, want 5", tt.query, total)
+		}
+	}
+	for _, query := range []string{"limit=0", fmt.Sprintf("limit=%d", maxLeaderboardEntries+1), "limit=ten", "offset=-1", "sort=name"} {
+		if code, _, _ := pageNames(t, h, query); code != http.StatusBadRequest {
+			t.Errorf("?%s: status %d, want 400", query, code)
+		}
+	}
+}
+
+func TestLeaderboardCSV(t *testing.T) {
+	langs, err := newLanguageRegistry(staticFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := leaderboardCSVHandler(pagedBoard(t), langs)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/leaderboard.csv?sort=time", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/csv") {
+		t.Errorf("Content-Type %q, want text/csv", got)
+	}
+	if got := w.Header().Get("Content-Disposition"); !strings.Contains(got, `attachment; filename="leaderboard.csv"`) {
+		t.Errorf("Content-Disposition %q, want a leaderboard.csv download", got)
+	}
+	if !strings.Contains(w.Body.String(), `"Smith, Jo"`) {
+		t.Errorf("a name with a comma is not quoted:\n%s", w.Body)
+	}
+	rows, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("malformed CSV: %v\n%s", err, w.Body)
+	}
+	if len(rows) != 6 || !slices.Equal(rows[0], []string{"rank", "name", "score", "timeMs", "submittedAt"}) {
+		t.Fatalf("rows %q, want a header and 5 entries", rows)
+	}
+	if want := []string{"1", "bob", "400", "1000"}; !slices.Equal(rows[1][:4], want) {
+		t.Errorf("first row %q, want %q in time order", rows[1], want)
+	}
+	if rows[2][1] != "Smith, Jo" {
+		t.Errorf("second name %q, want Smith, Jo in one cell", rows[2][1])
+	}
+	if rows[4][1] != `'=HYPERLINK("x")` {
+		t.Errorf("formula name written as %q, want it defused with a quote", rows[4][1])
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/leaderboard.csv?sort=rank", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid sort: status %d, want 400", w.Code)
+	}
+}
+
+// submitCategoryScore signs a score for sess and posts it to the
+// leaderboard as name under category, returning the status.
+func submitCategoryScore(t *testing.T, s *runningServer, sess sessionResponse, name, category string) int {
+	t.Helper()
+	key, err := hex.DecodeString(sess.SessionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return postJSON(t, s.url("/api/leaderboard"), map[string]any{
+		"name":      name,
+		"category":  category,
+		"score":     0,
+		"timeMs":    1000,
+		"token":     sess.Token,
+		"signature": scoreSignature(key, 0, 1000, sess.SessionID),
+	}, nil)
+}
+
+// boardNames returns the names on the leaderboard GET path serves.
+func boardNames(t *testing.T, s *runningServer, path string) []string {
+	t.Helper()
+	var body struct {
+		Entries []LeaderboardEntry `json:"entries"`
+	}
+	if code := getJSON(t, s.url(path), &body); code != http.StatusOK {
+		t.Fatalf("GET %s: status %d", path, code)
+	}
+	names := []string{}
+	for _, e := range body.Entries {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+func TestCategoryLeaderboards(t *testing.T) {
+	s := startServer(t, "-min-run-time", "0s")
+	if code := submitCategoryScore(t, s, startSession(t, s), "ada", "history"); code != http.StatusCreated {
+		t.Fatalf("history score: status %d", code)
+	}
+	if code := submitScore(t, s, startSession(t, s), "bob", 0, 1000); code != http.StatusCreated {
+		t.Fatalf("uncategorized score: status %d", code)
+	}
+	for path, want := range map[string][]string{
+		"/api/leaderboard?category=history": {"ada"},
+		"/api/leaderboard?category=science": {},
+		"/api/leaderboard":                  {"ada", "bob"},
+	} {
+		got := boardNames(t, s, path)
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("%s lists %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestUnknownCategoryRejected(t *testing.T) {
+	s := startServer(t, "-min-run-time", "0s")
+	if code := getJSON(t, s.url("/api/leaderboard?category=alchemy"), nil); code != http.StatusBadRequest {
+		t.Errorf("GET unknown category: status %d, want 400", code)
+	}
+	sess := startSession(t, s)
+	if code := submitCategoryScore(t, s, sess, "ada", "alchemy"); code != http.StatusBadRequest {
+		t.Errorf("POST unknown category: status %d, want 400", code)
+	}
+	// The rejected submission left the session's score unspent.
+	if code := submitCategoryScore(t, s, sess, "ada", "history"); code != http.StatusCreated {
+		t.Errorf("resubmitting to a known category: status %d, want 201", code)
+	}
+}
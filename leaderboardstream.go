@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// streamKeepAlive is how often an idle event stream sends a comment so
+// that proxies do not time the connection out.
+const streamKeepAlive = 15 * time.Second
+
+// leaderboardStreamHandler serves GET /api/leaderboard/stream?limit=N as
+// server-sent events: a "leaderboard" event with the top N entries on
+// connect, and another whenever a new score changes them. Streams end
+// when the client goes away or done is closed.
+func leaderboardStreamHandler(lb *leaderboard, done <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		limit := defaultLeaderboardLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxLeaderboardEntries {
+				writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxLeaderboardEntries))
+				return
+			}
+			limit = n
+		}
+
+		rc := http.NewResponseController(w)
+		// The stream outlives the server's write timeout by design.
+		rc.SetWriteDeadline(time.Time{})
+		changes, unsubscribe := lb.Subscribe()
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		var sent []LeaderboardEntry
+		send := func() error {
+			top := lb.Top(limit)
+			if sent != nil && slices.Equal(top, sent) {
+				return nil
+			}
+			sent = top
+			data, err := json.Marshal(map[string]any{"entries": top})
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "event: leaderboard\ndata: %s\n\n", data); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+		if _, err := fmt.Fprintf(w, "retry: 5000\n"); err != nil {
+			return
+		}
+		if err := send(); err != nil {
+			return
+		}
+
+		keepAlive := time.NewTicker(streamKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-changes:
+				if err := send(); err != nil {
+					return
+				}
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			case <-done:
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseEvent is one server-sent event: its type and data lines.
+type sseEvent struct {
+	Event, Data string
+}
+
+// readEvents sends the events read from body to the returned channel,
+// which is closed when body ends.
+func readEvents(body io.Reader) <-chan sseEvent {
+	events := make(chan sseEvent)
+	go func() {
+		defer close(events)
+		sc := bufio.NewScanner(body)
+		var ev sseEvent
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case line == "":
+				if ev.Event != "" || ev.Data != "" {
+					events <- ev
+				}
+				ev = sseEvent{}
+			case strings.HasPrefix(line, "event: "):
+				ev.Event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.Data += strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return events
+}
+
+// nextEvent returns the next event, failing the test if none arrives in
+// time.
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("the stream ended")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event within 5s")
+	}
+	return sseEvent{}
+}
+
+// openStream connects to the leaderboard stream of s.
+func openStream(t *testing.T, s *runningServer) <-chan sseEvent {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, s.url("/api/leaderboard/stream?limit=5"), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("stream: status %d, Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return readEvents(resp.Body)
+}
+
+func TestLeaderboardStreamPushesNewScores(t *testing.T) {
+	s := startServer(t, "-min-run-time", "0s", "-session-secret", string(testSecret))
+	events := openStream(t, s)
+	var board struct {
+		Entries []LeaderboardEntry `json:"entries"`
+	}
+	first := nextEvent(t, events)
+	if err := json.Unmarshal([]byte(first.Data), &board); first.Event != "leaderboard" || err != nil {
+		t.Fatalf("first event %+v, want the current leaderboard", first)
+	}
+	if len(board.Entries) != 0 {
+		t.Fatalf("a fresh leaderboard has %d entries", len(board.Entries))
+	}
+
+	if code := submitScore(t, s, startSession(t, s), "ada", 0, 1000); code != http.StatusCreated {
+		t.Fatalf("submission: status %d", code)
+	}
+	ev := nextEvent(t, events)
+	if err := json.Unmarshal([]byte(ev.Data), &board); ev.Event != "leaderboard" || err != nil {
+		t.Fatalf("event %+v after a submission, want the leaderboard", ev)
+	}
+	if len(board.Entries) != 1 || board.Entries[0].Name != "ada" {
+		t.Errorf("pushed entries %+v, want ada's new score", board.Entries)
+	}
+}
+
+func TestLeaderboardStreamEndsOnShutdown(t *testing.T) {
+	s := startServer(t)
+	events := openStream(t, s)
+	nextEvent(t, events)
+	s.stop(t)
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("an event arrived instead of the stream ending")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the stream was still open 5s after shutdown")
+	}
+}
+
+func TestLeaderboardStreamBadLimit(t *testing.T) {
+	s := startServer(t)
+	if code, _ := get(t, s.url("/api/leaderboard/stream?limit=0")); code != http.StatusBadRequest {
+		t.Errorf("limit=0: status %d, want 400", code)
+	}
+}
+
+func TestLeaderboardStreamUnsubscribesOnDisconnect(t *testing.T) {
+	lb, err := openLeaderboard(newMemoryStore(), leaderboardKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(leaderboardStreamHandler(lb, make(chan struct{})))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := readEvents(resp.Body)
+	nextEvent(t, events)
+	if n := lb.Subscribers(); n != 1 {
+		t.Fatalf("%d subscribers while connected, want 1", n)
+	}
+	cancel()
+	resp.Body.Close()
+	for deadline := time.Now().Add(5 * time.Second); lb.Subscribers() != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the subscription outlived the client by 5s")
+		}
+	}
+}
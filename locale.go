@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// localeDateLayouts are how a locale writes a date and time of day, as
+// time layouts keyed by language and, where a region writes them
+// differently from the rest, by language and region. Times are in UTC.
+// Locales missing here write dates as neutralLocale does.
+var localeDateLayouts = map[string]string{
+	"de":    "02.01.2006, 15:04 MST",
+	"en":    "1/2/2006, 3:04 PM MST",
+	"en-GB": "02/01/2006, 15:04 MST",
+	"es":    "2/1/2006, 15:04 MST",
+	"fr":    "02/01/2006 15:04 MST",
+	"fr-CA": "2006-01-02 15:04 MST",
+	"it":    "02/01/2006, 15:04 MST",
+	"ja":    "2006/01/02 15:04 MST",
+	"nl":    "02-01-2006 15:04 MST",
+	"pl":    "2.01.2006, 15:04 MST",
+	"pt":    "02/01/2006, 15:04 MST",
+	"ru":    "02.01.2006, 15:04 MST",
+	"zh":    "2006/1/2 15:04 MST",
+}
+
+// locale is how a response writes dates and numbers for its reader. The
+// zero locale is neutralLocale.
+type locale struct {
+	tag     language.Tag
+	printer *message.Printer
+	layout  string
+}
+
+// neutralLocale writes dates in ISO 8601 and numbers as bare digits, for
+// requests no available language matches.
+var neutralLocale = locale{}
+
+// newLocale returns the locale of tag.
+func newLocale(tag language.Tag) locale {
+	base, _ := tag.Base()
+	layout, ok := "", false
+	if region, conf := tag.Region(); conf == language.Exact {
+		layout, ok = localeDateLayouts[base.String()+"-"+region.String()]
+	}
+	if !ok {
+		layout = localeDateLayouts[base.String()]
+	}
+	return locale{tag: tag, printer: message.NewPrinter(tag), layout: layout}
+}
+
+// Lang returns the language tag of l, or "" for neutralLocale.
+func (l locale) Lang() string {
+	if l.printer == nil {
+		return ""
+	}
+	return l.tag.String()
+}
+
+// Date writes t.
+func (l locale) Date(t time.Time) string {
+	if l.layout == "" {
+		return t.UTC().Format(time.RFC3339)
+	}
+	return t.UTC().Format(l.layout)
+}
+
+// Int writes n, with the locale's digit grouping.
+func (l locale) Int(n int64) string {
+	if l.printer == nil {
+		return strconv.FormatInt(n, 10)
+	}
+	return l.printer.Sprintf("%d", n)
+}
+
+// locale picks how to write dates and numbers for r, negotiated as the
+// content language is: the ?lang= parameter if it names an available
+// language, otherwise the best match for Accept-Language. The region the
+// reader asked for is kept, so that en-GB reads British dates from the
+// English content. Without a match it is neutralLocale.
+func (l *languageRegistry) locale(r *http.Request) locale {
+	if v := r.URL.Query().Get("lang"); v != "" {
+		if tag, err := language.Parse(v); err == nil && slices.Contains(l.langs, tag.String()) {
+			return newLocale(tag)
+		}
+	}
+	accept, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil || len(accept) == 0 {
+		return neutralLocale
+	}
+	matched, _, conf := l.matcher.Match(accept...)
+	if conf == language.No {
+		return neutralLocale
+	}
+	base, _ := matched.Base()
+	for _, tag := range accept {
+		if b, _ := tag.Base(); b == base {
+			return newLocale(tag)
+		}
+	}
+	return newLocale(matched)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+func TestLocaleFormatting(t *testing.T) {
+	at := time.Date(2025, 3, 14, 21, 5, 0, 0, time.UTC)
+	tests := []struct {
+		tag, number, date string
+	}{
+		{"en-US", "1,250", "3/14/2025, 9:05 PM UTC"},
+		{"en-GB", "1,250", "14/03/2025, 21:05 UTC"},
+		{"de", "1.250", "14.03.2025, 21:05 UTC"},
+		{"fr-CA", "", "2025-03-14 21:05 UTC"},
+		{"fr", "", "14/03/2025 21:05 UTC"},
+	}
+	for _, tt := range tests {
+		l := newLocale(language.MustParse(tt.tag))
+		if got := l.Int(1250); tt.number != "" && got != tt.number {
+			t.Errorf("%s: Int(1250) = %q, want %q", tt.tag, got, tt.number)
+		}
+		if got := l.Date(at); got != tt.date {
+			t.Errorf("%s: Date = %q, want %q", tt.tag, got, tt.date)
+		}
+	}
+	if got := neutralLocale.Int(1250); got != "1250" {
+		t.Errorf("neutral Int(1250) = %q, want bare digits", got)
+	}
+	if got := neutralLocale.Date(at.In(time.FixedZone("CET", 3600))); got != "2025-03-14T21:05:00Z" {
+		t.Errorf("neutral Date = %q, want RFC 3339 in UTC", got)
+	}
+}
+
+func TestLocaleNegotiation(t *testing.T) {
+	langs := newTestLanguages(t)
+	tests := []struct {
+		query, accept, want string
+	}{
+		{"", "en-GB,en;q=0.8", "en-GB"},
+		{"", "fr-CA", "fr-CA"},
+		{"?lang=fr", "en-US", "fr"},
+		{"?lang=xx", "es", "es"},
+		{"", "de", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/leaderboard.csv"+tt.query, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept-Language", tt.accept)
+		}
+		if got := langs.locale(r).Lang(); got != tt.want {
+			t.Errorf("%q with Accept-Language %q: locale %q, want %q", tt.query, tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestLeaderboardCSVLocalized(t *testing.T) {
+	h := leaderboardCSVHandler(pagedBoard(t), newTestLanguages(t))
+	export := func(accept string) (http.Header, []string) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/api/leaderboard.csv", nil)
+		if accept != "" {
+			r.Header.Set("Accept-Language", accept)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		rows, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+		if w.Code != http.StatusOK || err != nil || len(rows) < 2 {
+			t.Fatalf("Accept-Language %q: status %d, %v\n%s", accept, w.Code, err, w.Body)
+		}
+		return w.Header(), rows[1]
+	}
+
+	// ada tops the board with 500 points in 9000ms, submitted at noon.
+	us, usRow := export("en-US")
+	gb, gbRow := export("en-GB")
+	if usRow[3] != "9,000" || usRow[4] != "3/1/2024, 12:00 PM UTC" {
+		t.Errorf("en-US row %q", usRow)
+	}
+	if gbRow[3] != "9,000" || gbRow[4] != "01/03/2024, 12:00 UTC" {
+		t.Errorf("en-GB row %q", gbRow)
+	}
+	if us.Get("Content-Language") != "en-US" || gb.Get("Content-Language") != "en-GB" {
+		t.Errorf("Content-Language %q and %q, want en-US and en-GB", us.Get("Content-Language"), gb.Get("Content-Language"))
+	}
+	if !strings.Contains(us.Get("Vary"), "Accept-Language") {
+		t.Errorf("Vary %q lacks Accept-Language", us.Get("Vary"))
+	}
+
+	neutral, row := export("de")
+	if row[3] != "9000" || row[4] != "2024-03-01T12:00:00Z" || neutral.Get("Content-Language") != "" {
+		t.Errorf("unmatched language: row %q, Content-Language %q, want bare digits and RFC 3339", row, neutral.Get("Content-Language"))
+	}
+}
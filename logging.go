@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// logLevel is the minimum level of the application logger. A config
+// reload may change it while the server runs.
+var logLevel = new(slog.LevelVar)
+
+// parseLogLevel parses a level name: debug, info, warn, or error.
+func parseLogLevel(name string) (slog.Level, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q", name)
+	}
+	return lvl, nil
+}
+
+// newLogger builds the application logger for the given minimum level and
+// format (json or text).
+func newLogger(w io.Writer, level slog.Leveler, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.New(requestIDHandler{slog.NewJSONHandler(w, opts)}), nil
+	case "text":
+		return slog.New(requestIDHandler{slog.NewTextHandler(w, opts)}), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q (want json or text)", format)
+}
+
+// requestIDHeader carries the ID correlating a request's log lines.
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID matches the caller-supplied request IDs that are reused
+// rather than replaced, so a client cannot write arbitrary text into the
+// logs.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestIDKey is the context key for a request's ID.
+type requestIDKey struct{}
+
+// requestID returns the ID requestIDs gave the request ctx belongs to, or
+// "" outside of one.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDs gives each request an ID: the caller's X-Request-ID when it
+// sends a valid one, such as a proxy's, or else a new UUID. The ID is
+// echoed in the response header and stored in the request context, where
+// log lines written with it pick it up.
+func requestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newUUID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// requestIDHandler adds the request ID, if the context has one, to each
+// record.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// unloggedPaths are polled by orchestrators often enough that logging
+// them would drown out real traffic.
+var unloggedPaths = map[string]bool{"/healthz": true, "/readyz": true}
+
+// unloggedPrefixes are left out of the access log as well: profiles are
+// fetched in bursts while debugging and say nothing about real traffic.
+var unloggedPrefixes = []string{pprofPrefix}
+
+// unlogged reports whether requests for p go unlogged.
+func unlogged(p string) bool {
+	for _, prefix := range unloggedPrefixes {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return unloggedPaths[p]
+}
+
+// logSampler thins out the access log's lines for static files served
+// without trouble, keeping one in rate of them. A static file is any path
+// with an extension outside of basePath's /api/, such as a script, a
+// stylesheet, or an icon. API requests, errors, and requests slower than
+// slow are always logged. Counting rather than drawing at random keeps the
+// sampled share exact whatever the traffic.
+type logSampler struct {
+	rate     uint64
+	slow     time.Duration
+	basePath string
+	n        atomic.Uint64
+}
+
+// newLogSampler keeps one in rate of the unremarkable static file lines;
+// a rate of 1 or less keeps them all, and a slow of 0 treats no request
+// as slow.
+func newLogSampler(rate int, slow time.Duration, basePath string) *logSampler {
+	return &logSampler{rate: uint64(max(rate, 1)), slow: slow, basePath: basePath}
+}
+
+// keep reports whether the request for p, answered with status after
+// elapsed, is logged, and the rate its line stands for.
+func (s *logSampler) keep(p string, status int, elapsed time.Duration) (bool, uint64) {
+	switch {
+	case s.rate == 1,
+		strings.HasPrefix(p, s.basePath+"/api/"), path.Ext(p) == "",
+		(status < 200 || status > 299) && status != http.StatusNotModified,
+		s.slow > 0 && elapsed >= s.slow:
+		return true, 1
+	}
+	return s.n.Add(1)%s.rate == 1, s.rate
+}
+
+// logRequests writes one access-log line per request to logger, recording
+// the client IP, resolved through proxies, and the method, path, route
+// template, status, bytes written, and duration. Lines sampler thins out
+// carry the rate they were sampled at as sample_rate.
+func logRequests(next http.Handler, logger *slog.Logger, proxies trustedProxies, sampler *logSampler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unlogged(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			elapsed := time.Since(start)
+			keep, rate := sampler.keep(r.URL.Path, rec.Status(), elapsed)
+			if !keep {
+				return
+			}
+			attrs := []slog.Attr{
+				slog.String("client", clientIP(r, proxies)),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("route", requestRoute(r.Context())),
+				slog.Int("status", rec.Status()),
+				slog.Int64("bytes", rec.bytes),
+				slog.Duration("duration", elapsed),
+			}
+			if rate > 1 {
+				attrs = append(attrs, slog.Uint64("sample_rate", rate))
+			}
+			logger.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
+		}()
+		rec.serve(next, r)
+	})
+}
+
+// statusRecorder wraps an http.ResponseWriter to capture the status code
+// and the number of body bytes written.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(p)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// serve serves r with next, recording a panic that leaves no status as
+// the 500 recoverPanics answers it with. The panic carries on up, so that
+// middleware recording the request from a deferred call sees the status
+// it ends in.
+func (rec *statusRecorder) serve(next http.Handler, r *http.Request) {
+	completed := false
+	defer func() {
+		if !completed && rec.status == 0 {
+			rec.status = http.StatusInternalServerError
+		}
+	}()
+	next.ServeHTTP(rec, r)
+	completed = true
+}
+
+// Status returns the response status, which is 200 if the handler never
+// set one explicitly.
+func (rec *statusRecorder) Status() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+func (rec *statusRecorder) Flush() {
+	http.NewResponseController(rec.ResponseWriter).Flush()
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loggedRequest serves a request for path with next through logRequests,
+// logging as JSON, and returns the logged line's fields.
+func loggedRequest(t *testing.T, next http.Handler, path string) map[string]any {
+	t.Helper()
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, slog.LevelInfo, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := logRequests(next, logger, nil, newLogSampler(1, 0, ""))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("log output %q is not one JSON line: %v", buf.String(), err)
+	}
+	return line
+}
+
+func TestLogRequestsRecordsStatusAndBytes(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello, world")
+	})
+	line := loggedRequest(t, ok, "/help")
+	if line["method"] != "GET" || line["path"] != "/help" {
+		t.Errorf("logged method %v path %v, want GET /help", line["method"], line["path"])
+	}
+	if line["status"] != float64(200) || line["bytes"] != float64(12) {
+		t.Errorf("logged status %v bytes %v, want 200 and 12", line["status"], line["bytes"])
+	}
+	if _, ok := line["duration"]; !ok {
+		t.Error("no duration logged")
+	}
+
+	line = loggedRequest(t, http.NotFoundHandler(), "/missing")
+	if line["status"] != float64(404) || line["bytes"] != float64(len("404 page not found\n")) {
+		t.Errorf("logged status %v bytes %v for a 404", line["status"], line["bytes"])
+	}
+}
+
+func TestNewLoggerFormats(t *testing.T) {
+	for format, prefix := range map[string]string{"json": "{", "text": "time="} {
+		var buf bytes.Buffer
+		logger, err := newLogger(&buf, slog.LevelInfo, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logger.Info("hello")
+		if !strings.HasPrefix(buf.String(), prefix) {
+			t.Errorf("%s log line %q does not start with %q", format, buf.String(), prefix)
+		}
+	}
+	if _, err := newLogger(io.Discard, slog.LevelInfo, "xml"); err == nil {
+		t.Error("unknown log format accepted")
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	var buf bytes.Buffer
+	level, err := parseLogLevel("warn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, _ := newLogger(&buf, level, "text")
+	logger.Info("dropped")
+	logger.Warn("kept")
+	if strings.Contains(buf.String(), "dropped") || !strings.Contains(buf.String(), "kept") {
+		t.Errorf("warn level logged %q", buf.String())
+	}
+	if _, err := parseLogLevel("loud"); err == nil {
+		t.Error("unknown log level accepted")
+	}
+}
+
+var uuidForm = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// requestIDLines serves a request through requestIDs and logRequests to a
+// handler that logs a line of its own, with X-Request-ID set to sent
+// unless it is empty, and returns the response and both logged lines.
+func requestIDLines(t *testing.T, sent string) (*httptest.ResponseRecorder, []map[string]any) {
+	t.Helper()
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, slog.LevelInfo, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.InfoContext(r.Context(), "handled")
+	})
+	r := httptest.NewRequest(http.MethodGet, "/api/questions", nil)
+	if sent != "" {
+		r.Header.Set(requestIDHeader, sent)
+	}
+	w := httptest.NewRecorder()
+	requestIDs(logRequests(handler, logger, nil, newLogSampler(1, 0, ""))).ServeHTTP(w, r)
+	var lines []map[string]any
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var line map[string]any
+		if err := dec.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("logged %d lines, want the handler's and the request's", len(lines))
+	}
+	return w, lines
+}
+
+func TestRequestIDEchoedAndLogged(t *testing.T) {
+	w, lines := requestIDLines(t, "edge-42.a:b")
+	if got := w.Header().Get(requestIDHeader); got != "edge-42.a:b" {
+		t.Errorf("echoed %s %q, want the caller's", requestIDHeader, got)
+	}
+	for _, line := range lines {
+		if line["request_id"] != "edge-42.a:b" {
+			t.Errorf("%v line has request_id %v, want the caller's", line["msg"], line["request_id"])
+		}
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	for _, sent := range []string{"", "has spaces", "evil\nline", strings.Repeat("x", 129)} {
+		w, lines := requestIDLines(t, sent)
+		id := w.Header().Get(requestIDHeader)
+		if !uuidForm.MatchString(id) {
+			t.Errorf("sent %q: response ID %q is not a fresh UUID", sent, id)
+		}
+		for _, line := range lines {
+			if line["request_id"] != id {
+				t.Errorf("sent %q: %v line has request_id %v, want %s", sent, line["msg"], line["request_id"], id)
+			}
+		}
+	}
+	if requestID(httptest.NewRequest(http.MethodGet, "/", nil).Context()) != "" {
+		t.Error("a context outside a request has an ID")
+	}
+}
+
+func TestServerEchoesRequestID(t *testing.T) {
+	s := startServer(t)
+	req, _ := http.NewRequest(http.MethodGet, s.url("/api/questions"), nil)
+	req.Header.Set(requestIDHeader, "trace-7")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get(requestIDHeader); got != "trace-7" {
+		t.Errorf("%s = %q, want trace-7", requestIDHeader, got)
+	}
+	resp, err = http.Get(s.url("/api/questions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get(requestIDHeader); !uuidForm.MatchString(got) {
+		t.Errorf("%s = %q, want a generated UUID", requestIDHeader, got)
+	}
+}
+
+func TestLogSamplingKeepsErrorsAndAPI(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, slog.LevelInfo, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/game/css/broken.css":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/game/css/cached.css":
+			w.WriteHeader(http.StatusNotModified)
+		case "/game/js/slow.js":
+			time.Sleep(20 * time.Millisecond)
+		}
+	})
+	h := logRequests(next, logger, nil, newLogSampler(10, 10*time.Millisecond, "/game"))
+	for range 100 {
+		for _, p := range []string{"/game/css/game.css", "/game/css/broken.css", "/game/api/questions", "/game/", "/game/css/cached.css"} {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, p, nil))
+		}
+	}
+	for range 3 {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/game/js/slow.js", nil))
+	}
+
+	counts := map[string]int{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		p := rec["path"].(string)
+		counts[p]++
+		if rate, sampled := rec["sample_rate"]; sampled != (p == "/game/css/game.css" || p == "/game/css/cached.css") || (sampled && rate != 10.0) {
+			t.Errorf("%s logged with sample_rate %v", p, rate)
+		}
+	}
+	// The 200s and 304s for static files share one count, of which a
+	// tenth are logged.
+	if n := counts["/game/css/game.css"] + counts["/game/css/cached.css"]; n != 20 {
+		t.Errorf("200 successful static requests logged %d times, want 20", n)
+	}
+	want := map[string]int{
+		"/game/css/broken.css": 100,
+		"/game/api/questions":  100,
+		"/game/":               100,
+		"/game/js/slow.js":     3,
+	}
+	for p, n := range want {
+		if counts[p] != n {
+			t.Errorf("%s logged %d times, want %d", p, counts[p], n)
+		}
+	}
+}
+
+func TestLogSamplingConfig(t *testing.T) {
+	cfg, err := loadConfig(nil, noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LogStaticRate != 1 {
+		t.Errorf("default log-static-rate %d, want every line logged", cfg.LogStaticRate)
+	}
+	for _, args := range [][]string{{"-log-static-rate", "0"}, {"-log-slow", "-1s"}} {
+		if _, err := loadConfig(args, noEnv); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// mailQueueSize is how many emails may wait to be sent before new ones
+	// are dropped.
+	mailQueueSize = 16
+	// mailWorkers is how many emails are sent at once.
+	mailWorkers = 1
+	// mailAttempts is how many times sending one email is tried.
+	mailAttempts = 4
+	// mailBackoff is the wait before the first retry; it doubles after
+	// each failure.
+	mailBackoff = 5 * time.Second
+)
+
+// MailSettings configure the emails announcing new top scores, sent over
+// SMTP to the comma-separated addresses in To. They are off while Host is
+// empty. Port 465 is spoken over TLS from the start; on any other port the
+// connection is upgraded with STARTTLS when the server offers it. User and
+// Password, when set, authenticate with PLAIN, which is only sent over TLS.
+type MailSettings struct {
+	Host     string
+	Port     int
+	From     string
+	To       string
+	User     string
+	Password string
+	// TopRank is the worst leaderboard rank that is emailed.
+	TopRank int
+}
+
+// recipients returns the entries of To.
+func (s MailSettings) recipients() []string {
+	var to []string
+	for _, addr := range strings.Split(s.To, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	return to
+}
+
+// mailSender hands a formatted message to a mail server.
+type mailSender interface {
+	Send(ctx context.Context, from string, to []string, msg []byte) error
+}
+
+// mailMessage is an email waiting to be sent.
+type mailMessage struct {
+	subject, body string
+	at            time.Time
+}
+
+// mailer emails new top scores from a bounded pool of workers, so that a
+// slow or failing mail server never holds up a request. A nil *mailer
+// sends nothing.
+type mailer struct {
+	sender   mailSender
+	from     string
+	to       []string
+	envelope struct {
+		from string
+		to   []string
+	}
+	topRank int
+	queue   chan mailMessage
+}
+
+// newMailer returns a notifier sending with sender as settings describe,
+// or nil if they name no mail server.
+func newMailer(settings MailSettings, sender mailSender) *mailer {
+	if settings.Host == "" {
+		return nil
+	}
+	m := &mailer{
+		sender:  sender,
+		from:    settings.From,
+		to:      settings.recipients(),
+		topRank: settings.TopRank,
+		queue:   make(chan mailMessage, mailQueueSize),
+	}
+	// The envelope takes the bare addresses; the headers keep any names.
+	m.envelope.from = bareAddress(m.from)
+	for _, addr := range m.to {
+		m.envelope.to = append(m.envelope.to, bareAddress(addr))
+	}
+	return m
+}
+
+// bareAddress returns the address of addr without its display name.
+func bareAddress(addr string) string {
+	if a, err := mail.ParseAddress(addr); err == nil {
+		return a.Address
+	}
+	return addr
+}
+
+// start runs the sending workers until ctx is cancelled.
+func (m *mailer) start(ctx context.Context) {
+	if m == nil {
+		return
+	}
+	for range mailWorkers {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case msg := <-m.queue:
+					m.deliver(ctx, msg)
+				}
+			}
+		}()
+	}
+}
+
+// TopScore queues an email announcing that e placed at rank on the
+// leaderboard board, if that is within the top rank emailed. It drops the
+// email if the queue is full.
+func (m *mailer) TopScore(board string, rank int, e LeaderboardEntry) {
+	if m == nil || rank < 1 || rank > m.topRank {
+		return
+	}
+	name := printable(e.Name)
+	if date, ok := strings.CutPrefix(board, "daily-"); ok {
+		board = "daily challenge leaderboard of " + date
+	} else {
+		board = "all-time leaderboard"
+	}
+	msg := mailMessage{
+		subject: fmt.Sprintf("New #%d score on the LobeLabyrinth leaderboard: %d by %s", rank, e.Score, name),
+		body: fmt.Sprintf("%s has taken place %d on the %s.\n\nScore: %d\nTime: %s\nSubmitted: %s\n",
+			name, rank, board, e.Score, (time.Duration(e.TimeMs) * time.Millisecond).String(), e.SubmittedAt.UTC().Format(time.RFC1123)),
+		at: time.Now(),
+	}
+	select {
+	case m.queue <- msg:
+	default:
+		slog.Warn("mail queue full; dropping email", "subject", msg.subject)
+	}
+}
+
+// deliver sends msg, retrying with exponential backoff.
+func (m *mailer) deliver(ctx context.Context, msg mailMessage) {
+	data := m.format(msg)
+	backoff := mailBackoff
+	for attempt := 1; ; attempt++ {
+		err := m.sender.Send(ctx, m.envelope.from, m.envelope.to, data)
+		if err == nil {
+			return
+		}
+		if attempt == mailAttempts || ctx.Err() != nil {
+			slog.Warn("sending mail failed", "subject", msg.subject, "attempts", attempt, "err", err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// format writes msg as a plain-text email from m.from to m.to.
+func (m *mailer) format(msg mailMessage) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(m.to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", msg.at.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@lobelabyrinth>\r\n", randomID(16))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&b)
+	qp.Write([]byte(strings.ReplaceAll(msg.body, "\n", "\r\n")))
+	qp.Close()
+	return b.Bytes()
+}
+
+// printable drops the control characters from a player-supplied s, which
+// could otherwise break the headers it is written into.
+func printable(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// smtpSender sends mail through the SMTP server settings name, bounded by
+// the outbound timeouts.
+type smtpSender struct {
+	host        string
+	addr        string
+	implicitTLS bool
+	auth        smtp.Auth
+	timeouts    OutboundTimeouts
+}
+
+func newSMTPSender(settings MailSettings, timeouts OutboundTimeouts) *smtpSender {
+	s := &smtpSender{
+		host:        settings.Host,
+		addr:        net.JoinHostPort(settings.Host, strconv.Itoa(settings.Port)),
+		implicitTLS: settings.Port == 465,
+		timeouts:    timeouts,
+	}
+	if settings.User != "" {
+		s.auth = smtp.PlainAuth("", settings.User, settings.Password, settings.Host)
+	}
+	return s
+}
+
+func (s *smtpSender) Send(ctx context.Context, from string, to []string, msg []byte) error {
+	dialer := &net.Dialer{Timeout: s.timeouts.Dial}
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if s.timeouts.Request > 0 {
+		conn.SetDeadline(time.Now().Add(s.timeouts.Request))
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	tlsConfig := &tls.Config{ServerName: s.host}
+	if s.implicitTLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok && !s.implicitTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if s.auth != nil {
+		if err := c.Auth(s.auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// validateMail checks settings, if a mail server is named.
+func validateMail(settings MailSettings) []error {
+	if settings.Host == "" {
+		return nil
+	}
+	var errs []error
+	if settings.Port < 1 || settings.Port > 65535 {
+		errs = append(errs, fmt.Errorf("smtp-port %d is not a port", settings.Port))
+	}
+	if _, err := mail.ParseAddress(settings.From); err != nil {
+		errs = append(errs, fmt.Errorf("smtp-from: %q: %w", settings.From, err))
+	}
+	to := settings.recipients()
+	if len(to) == 0 {
+		errs = append(errs, errors.New("smtp-to must name at least one address"))
+	}
+	for _, addr := range to {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			errs = append(errs, fmt.Errorf("smtp-to: %q: %w", addr, err))
+		}
+	}
+	if (settings.User == "") != (settings.Password == "") {
+		errs = append(errs, errors.New("smtp-user and smtp-password must be set together"))
+	}
+	if settings.TopRank < 1 {
+		errs = append(errs, errors.New("smtp-top-rank must be at least 1"))
+	}
+	return errs
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sentMail is one message handed to a mail server.
+type sentMail struct {
+	from string
+	to   []string
+	msg  *mail.Message
+}
+
+// fakeSender is a mailSender recording what it is asked to send onto
+// sent, failing while fail returns an error.
+type fakeSender struct {
+	sent chan sentMail
+	fail func() error
+}
+
+func newFakeSender() *fakeSender {
+	return &fakeSender{sent: make(chan sentMail, mailQueueSize)}
+}
+
+func (f *fakeSender) Send(ctx context.Context, from string, to []string, data []byte) error {
+	if f.fail != nil {
+		if err := f.fail(); err != nil {
+			return err
+		}
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		return err
+	}
+	f.sent <- sentMail{from: from, to: to, msg: msg}
+	return nil
+}
+
+// nextMail waits for the next message sent.
+func nextMail(t *testing.T, sent <-chan sentMail) sentMail {
+	t.Helper()
+	select {
+	case m := <-sent:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail was sent")
+		return sentMail{}
+	}
+}
+
+// testMailSettings name a mail server with two recipients.
+var testMailSettings = MailSettings{
+	Host:    "smtp.example",
+	Port:    587,
+	From:    "LobeLabyrinth <scores@castle.example>",
+	To:      "keeper@castle.example, Warden <warden@castle.example>",
+	TopRank: 1,
+}
+
+func TestMailerEmailsNewTopScore(t *testing.T) {
+	sender := newFakeSender()
+	m := newMailer(testMailSettings, sender)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.start(ctx)
+
+	entry := LeaderboardEntry{Name: "ada", Score: 1250, TimeMs: 95000, SubmittedAt: time.Date(2025, 3, 14, 21, 5, 0, 0, time.UTC)}
+	m.TopScore(leaderboardKey, 2, entry)
+	m.TopScore(leaderboardKey, 1, entry)
+	got := nextMail(t, sender.sent)
+	if got.from != "scores@castle.example" || !slices.Equal(got.to, []string{"keeper@castle.example", "warden@castle.example"}) {
+		t.Errorf("envelope from %q to %v", got.from, got.to)
+	}
+	dec := new(mime.WordDecoder)
+	subject, _ := dec.DecodeHeader(got.msg.Header.Get("Subject"))
+	if want := "New #1 score on the LobeLabyrinth leaderboard: 1250 by ada"; subject != want {
+		t.Errorf("subject %q, want %q", subject, want)
+	}
+	if to := got.msg.Header.Get("To"); !strings.Contains(to, "Warden <warden@castle.example>") {
+		t.Errorf("To header %q drops the recipients' names", to)
+	}
+	select {
+	case extra := <-sender.sent:
+		t.Errorf("a #2 score was emailed too: %v", extra.msg.Header.Get("Subject"))
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	m.TopScore("daily-2025-03-14", 1, entry)
+	daily := nextMail(t, sender.sent)
+	body, _ := io.ReadAll(daily.msg.Body)
+	if !strings.Contains(string(body), "daily challenge leaderboard of 2025-03-14") {
+		t.Errorf("daily score email reads %q", body)
+	}
+}
+
+func TestMailerNeverBlocks(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	sender := newFakeSender()
+	sender.fail = func() error {
+		<-release
+		return nil
+	}
+	m := newMailer(testMailSettings, sender)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.start(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		for range mailQueueSize + 2 {
+			m.TopScore(leaderboardKey, 1, LeaderboardEntry{Name: "ada"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("queueing emails for a hung mail server blocked")
+	}
+
+	var off *mailer
+	off.TopScore(leaderboardKey, 1, LeaderboardEntry{Name: "ada"}) // does nothing
+	off.start(ctx)
+	if newMailer(MailSettings{}, sender) != nil {
+		t.Error("a mailer was made without a mail server")
+	}
+}
+
+func TestMailerStopsRetryingOnShutdown(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	sender := newFakeSender()
+	sender.fail = func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		return errors.New("421 try again later")
+	}
+	m := newMailer(testMailSettings, sender)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	m.deliver(ctx, mailMessage{subject: "s", body: "b", at: start})
+	if d := time.Since(start); d > mailBackoff {
+		t.Errorf("delivery kept retrying for %v after shutdown", d)
+	}
+	// The attempt in flight at shutdown may be the last one.
+	if attempts > 2 {
+		t.Errorf("%d attempts, want delivery to stop at shutdown", attempts)
+	}
+}
+
+func TestMailHeadersResistInjection(t *testing.T) {
+	m := newMailer(testMailSettings, newFakeSender())
+	m.TopScore(leaderboardKey, 1, LeaderboardEntry{Name: "ada\r\nBcc: mallory@evil.example"})
+	msg, err := mail.ReadMessage(strings.NewReader(string(m.format(<-m.queue))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bcc := msg.Header.Get("Bcc"); bcc != "" {
+		t.Errorf("a player's name added the header Bcc: %s", bcc)
+	}
+}
+
+func TestValidateMail(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(*MailSettings)
+		want string
+	}{
+		{"valid", func(*MailSettings) {}, ""},
+		{"off", func(s *MailSettings) { *s = MailSettings{} }, ""},
+		{"bad port", func(s *MailSettings) { s.Port = 0 }, "not a port"},
+		{"bad from", func(s *MailSettings) { s.From = "scores" }, "smtp-from"},
+		{"no recipients", func(s *MailSettings) { s.To = " , " }, "at least one address"},
+		{"bad recipient", func(s *MailSettings) { s.To += ", warden" }, `smtp-to: "warden"`},
+		{"user without password", func(s *MailSettings) { s.User = "scores" }, "set together"},
+		{"top rank", func(s *MailSettings) { s.TopRank = 0 }, "smtp-top-rank"},
+	}
+	for _, tt := range tests {
+		settings := testMailSettings
+		tt.edit(&settings)
+		err := errors.Join(validateMail(settings)...)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: error %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// fakeSMTPServer accepts SMTP sessions on a local port, sending the
+// envelope recipients and data of each message onto a channel.
+func fakeSMTPServer(t *testing.T) (int, <-chan []string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	got := make(chan []string, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+				reply("220 fake ESMTP")
+				var lines []string
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					line = strings.TrimRight(line, "\r\n")
+					switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+					case "EHLO", "HELO", "MAIL", "RSET", "NOOP":
+						reply("250 ok")
+					case "RCPT":
+						lines = append(lines, line)
+						reply("250 ok")
+					case "DATA":
+						reply("354 go ahead")
+						for {
+							data, err := r.ReadString('\n')
+							if err != nil {
+								return
+							}
+							if data = strings.TrimRight(data, "\r\n"); data == "." {
+								break
+							}
+							lines = append(lines, data)
+						}
+						got <- lines
+						lines = nil
+						reply("250 queued")
+					case "QUIT":
+						reply("221 bye")
+						return
+					default:
+						reply("502 unknown")
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, got
+}
+
+func TestTopScoreEmailedOverSMTP(t *testing.T) {
+	port, got := fakeSMTPServer(t)
+	s := startServer(t, "-min-run-time", "0s",
+		"-smtp-host", "127.0.0.1", "-smtp-port", strconv.Itoa(port),
+		"-smtp-from", "scores@castle.example", "-smtp-to", "keeper@castle.example,warden@castle.example")
+	// Both runs score nothing, so rank goes to the faster run: bob's
+	// starts first and finishes last.
+	bob := startSession(t, s)
+	if code := submitScore(t, s, startSession(t, s), "ada", 0, 1000); code != 201 {
+		t.Fatalf("submission: status %d", code)
+	}
+	var lines []string
+	select {
+	case lines = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email reached the mail server")
+	}
+	text := strings.Join(lines, "\n")
+	for _, want := range []string{"RCPT TO:<keeper@castle.example>", "RCPT TO:<warden@castle.example>", "Subject: New #1 score on the LobeLabyrinth leaderboard: 0 by ada", "From: scores@castle.example"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("email lacks %q:\n%s", want, text)
+		}
+	}
+
+	// A second place is not emailed.
+	submitScore(t, s, bob, "bob", 0, 2000)
+	select {
+	case lines := <-got:
+		t.Errorf("a #2 score was emailed:\n%s", strings.Join(lines, "\n"))
+	case <-time.After(200 * time.Millisecond):
+	}
+}
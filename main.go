@@ -1,8 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"embed"
-	"net/http"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
 )
 
 var HELP_HEADER = `<!DOCTYPE html>
@@ -31,40 +45,219 @@ var HELP_HEADER = `<!DOCTYPE html>
     <link rel="stylesheet" href="css/achievements.css">
     <link rel="stylesheet" href="css/victory.css">
     <link rel="stylesheet" href="css/accessibility.css">
-</head>
-<body>
+    <link rel="stylesheet" href="theme.css">
+` + codeStyleSheet + `</head>
+<body class="help-page">
+<main class="help-content">
 `
 var HELP_FOOTER = `
+</main>
 </body>
 </html>
 `
-var HELP_CONTENT = HELP_HEADER + READMEHTML() + HELP_FOOTER
+var HELP_CONTENT = renderHelp(README)
 
 //go:embed README.md
 var README string
 
-func READMEHTML() string {
-	// Convert README markdown to HTML (simple conversion for demo purposes)
-	// In a real application, consider using a markdown library for better conversion
-	html := "<pre>" + README + "</pre>"
-	return html
+// markdown converts GitHub-flavoured Markdown to HTML. Raw HTML in the
+// source is left out of the output (goldmark's default, non-unsafe mode),
+// so README content cannot inject scripts into the help page. Headings get
+// id attributes for the table of contents to link to, and fenced code is
+// highlighted.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM, codeHighlighting),
+	goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+)
+
+// renderHelp builds the complete /help page around readme.
+func renderHelp(readme string) string {
+	return HELP_HEADER + READMEHTML(readme) + HELP_FOOTER
+}
+
+// renderLocalizedHelp builds the /help page around a readme written in
+// lang.
+func renderLocalizedHelp(readme, lang string) string {
+	return strings.Replace(renderHelp(readme), `<html lang="en">`, `<html lang="`+lang+`">`, 1)
+}
+
+// READMEHTML renders readme as HTML, preceded by a table of contents of
+// its sections. For the embedded README this happens once while
+// HELP_CONTENT is initialised, since it never changes within a build.
+func READMEHTML(readme string) string {
+	source := []byte(readme)
+	doc := markdown.Parser().Parse(text.NewReader(source))
+	var buf bytes.Buffer
+	buf.WriteString(tocHTML(tocEntries(doc, source)))
+	if err := markdown.Renderer().Render(&buf, source, doc); err != nil {
+		// Fall back to escaped preformatted text rather than an empty page.
+		return "<pre>" + html.EscapeString(readme) + "</pre>"
+	}
+	return buf.String()
 }
 
-//go:embed */*.css */*.json */*.js *.html *.ico manifest.json
+//go:generate go run gen_brotli.go
+//go:generate go run . checksums -o checksums.txt
+
+//go:embed */*.css */*.json */*.js */*.br *.html *.ico manifest.json README*.md questions/media/*
 var staticFS embed.FS
 
+// embedPatterns are the go:embed patterns for staticFS, so that the
+// checksums subcommand can hash a source tree as it would be embedded.
+var embedPatterns = []string{
+	"*/*.css", "*/*.json", "*/*.js", "*/*.br", "*.html", "*.ico", "manifest.json", "README*.md",
+	questionMediaDir + "/*",
+}
+
+// assetPatterns mirrors the go:embed patterns for staticFS so that dev
+// mode exposes the same files from disk, less the Brotli variants, which
+// would go stale as the sources are edited. It also
+// admits audio and video so new media can be tried out before it is added
+// to the embed directive.
+var assetPatterns = []string{
+	"*/*.css", "*/*.json", "*/*.js", "*.html", "*.ico", "manifest.json", "README*.md",
+	questionMediaDir + "/*",
+	"*/*.mp3", "*/*.ogg", "*/*.wav", "*/*.m4a", "*/*.mp4", "*/*.webm",
+}
+
 func main() {
-	serve(HELP_CONTENT, staticFS)
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	level, err := parseLogLevel(cfg.LogLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	logLevel.Set(level)
+	logger, err := newLogger(os.Stderr, logLevel, cfg.LogFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+
+	if cfg.Dev {
+		slog.Info("dev mode: serving assets from disk")
+	} else {
+		slog.Info("serving embedded assets")
+	}
+	if cfg.ContentDir != "" {
+		slog.Info("serving content from disk in place of the built-in copy", "dir", cfg.ContentDir)
+	}
+	content := siteContent(cfg, staticFS)
+	if cfg.VerifyAssets {
+		if cfg.Dev {
+			slog.Warn("not verifying assets in dev mode, which serves them from disk")
+		} else if mismatches, err := verifyAssets(staticFS, assetChecksums); err != nil || len(mismatches) > 0 {
+			for _, m := range mismatches {
+				slog.Error("embedded asset does not match "+checksumsFile, "file", m.File, "problem", m.Problem)
+			}
+			fatal("asset verification failed", "err", err, "mismatches", len(mismatches))
+		}
+	}
+
+	if err := validateContent(content); err != nil {
+		logContentError("content validation failed", err)
+		os.Exit(1)
+	}
+
+	if err := serve(content, cfg); errors.Is(err, errForcedShutdown) {
+		os.Exit(exitForcedShutdown)
+	} else if err != nil {
+		fatal("server stopped", "err", err)
+	}
+}
+
+// fatal logs msg at error level and exits non-zero.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// contentDirPatterns are the files -content-dir may replace: the game
+// data, the maps, the stylesheets the themes are built from, the manifest,
+// question media, and the READMEs behind the help page.
+var contentDirPatterns = []string{
+	"data/*.json", "maps/*.json", "css/*.css", "manifest.json", "README*.md", questionMediaDir + "/*",
+}
+
+// siteContent returns the content cfg serves: bundle, or in dev mode the
+// working directory, with the files of cfg.ContentDir in place of its own.
+func siteContent(cfg *Config, bundle fs.FS) fs.FS {
+	content := bundle
+	if cfg.Dev {
+		content = devFS{os.DirFS("."), assetPatterns}
+	}
+	if cfg.ContentDir != "" {
+		content = tenantFS{over: devFS{os.DirFS(cfg.ContentDir), contentDirPatterns}, base: content}
+	}
+	return content
 }
-func serve(helpContent string, staticFS embed.FS) {
-	// serve the filesystem using an HTTP server
-	// if a request is made to /help, serve the helpContent
-	http.HandleFunc("/help", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-		w.Write([]byte(helpContent))
-	})
-	// serve static files (css, js, manifest.json)
-	fileserver := http.FileServer(http.FS(staticFS))
-	http.Handle("/", fileserver)
-	http.ListenAndServe(":8080", nil)
+
+// devFS restricts an on-disk tree to the files matching patterns, such as
+// those that would be embedded, so dev mode does not also publish saves,
+// the leaderboard, or .git.
+type devFS struct {
+	fsys     fs.FS
+	patterns []string
+}
+
+func (d devFS) Open(name string) (fs.File, error) {
+	if !d.allowed(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return d.fsys.Open(name)
+}
+
+// ReadDir lists only the allowed entries of a directory, so that walking
+// the tree does not stumble over hidden or unexported ones.
+func (d devFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !d.allowed(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	entries, err := fs.ReadDir(d.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	allowed := entries[:0]
+	for _, e := range entries {
+		if d.allowed(path.Join(name, e.Name())) {
+			allowed = append(allowed, e)
+		}
+	}
+	return allowed, nil
+}
+
+func (d devFS) allowed(name string) bool {
+	if name == "." {
+		return true
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") {
+			return false
+		}
+	}
+	if fi, err := fs.Stat(d.fsys, name); err == nil && fi.IsDir() {
+		return !strings.Contains(name, "/") || name == questionMediaDir
+	}
+	for _, pattern := range d.patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// render converts src with the help page's Markdown settings.
+func render(t *testing.T, src string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(src), &buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestREADMEHTMLRendersMarkdown(t *testing.T) {
+	src := "# Title\n\n- first\n- second\n\n[docs](https://example.com/docs)\n\n" +
+		"```go\nx := 1\n```\n\n| a | b |\n|---|---|\n| 1 | 2 |\n"
+	out := render(t, src)
+	for _, want := range []string{
+		"<h1", ">Title</h1>",
+		"<ul>", "<li>first</li>", "<li>second</li>",
+		`<a href="https://example.com/docs">docs</a>`,
+		"<pre", "<code",
+		"<table>", "<th>a</th>", "<td>2</td>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("rendered Markdown lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.HasPrefix(out, "<pre>") {
+		t.Errorf("README was wrapped in <pre> instead of rendered:\n%s", out)
+	}
+}
+
+func TestREADMEHTMLDropsRawHTML(t *testing.T) {
+	out := render(t, "Hello\n\n<script>alert(1)</script>\n\n<b onclick=\"steal()\">bold</b>\n")
+	for _, bad := range []string{"<script", "alert(1)</script>", "onclick"} {
+		if strings.Contains(out, bad) {
+			t.Errorf("raw HTML %q survived rendering:\n%s", bad, out)
+		}
+	}
+}
+
+func TestREADMEHTMLDeterministic(t *testing.T) {
+	if a, b := READMEHTML(README), READMEHTML(README); a != b {
+		t.Fatal("rendering the README twice gave different output")
+	}
+}
+
+func TestHelpContentUsesGameStyles(t *testing.T) {
+	if !strings.Contains(HELP_CONTENT, `href="css/game.css"`) {
+		t.Error("help page does not link the game stylesheet")
+	}
+	if strings.Contains(HELP_CONTENT, "<pre>"+README) {
+		t.Error("help page still shows the README as preformatted text")
+	}
+}
+
+func TestDevFSOnlyExposesAssets(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"index.html", "README.md", "css/game.css", "leaderboard.json", ".git/config", "css/deep/x.css"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys := devFS{os.DirFS(dir), assetPatterns}
+	for name, want := range map[string]bool{
+		"index.html":       true,
+		"README.md":        true,
+		"css/game.css":     true,
+		"leaderboard.json": false,
+		".git/config":      false,
+		"css/deep/x.css":   false,
+	} {
+		f, err := fsys.Open(name)
+		if err == nil {
+			f.Close()
+		}
+		if got := err == nil; got != want {
+			t.Errorf("opening %s succeeded = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestDevModeServesFromDisk(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.CopyFS(dir, staticFS); err != nil {
+		t.Fatal(err)
+	}
+	content := devFS{os.DirFS(dir), assetPatterns}
+	langs, err := newLanguageRegistry(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := serveRoutes(t, map[string]http.Handler{
+		"/help": devHelpHandler(content, langs, ""),
+		"/":     http.FileServer(http.FS(content)),
+	})
+
+	if err := os.WriteFile(filepath.Join(dir, "css/game.css"), []byte("body { color: rebeccapurple; }"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, body := get(t, s.url("/css/game.css")); !strings.Contains(body, "rebeccapurple") {
+		t.Errorf("edited stylesheet not served without a rebuild: %q", body)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("# Edited on disk\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, body := get(t, s.url("/help")); !strings.Contains(body, "Edited on disk</h1>") {
+		t.Error("/help did not re-render the edited README")
+	}
+}
+
+func TestContentDirOverlaysEmbedded(t *testing.T) {
+	dir := tenantContent(t, "c001")
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("not content"), 0o644)
+	cfg, err := loadConfig([]string{"-content-dir", dir}, noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := siteContent(cfg, staticFS)
+	if err := validateContent(content); err != nil {
+		t.Errorf("overlaid content: %v", err)
+	}
+	for name, fromDisk := range map[string]bool{questionsFile: true, "maps/tower.json": true, "css/game.css": false, "index.html": false} {
+		got, err := fs.ReadFile(content, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want []byte
+		if fromDisk {
+			want, err = os.ReadFile(filepath.Join(dir, name))
+		} else {
+			want, err = fs.ReadFile(staticFS, name)
+		}
+		if err != nil || string(got) != string(want) {
+			t.Errorf("%s is not the copy from %s", name, map[bool]string{true: "-content-dir", false: "the embedded files"}[fromDisk])
+		}
+	}
+
+	s := startServer(t, "-content-dir", dir)
+	var body struct {
+		Questions []PublicQuestion `json:"questions"`
+	}
+	if code := getJSON(t, s.url("/api/questions?count=50"), &body); code != http.StatusOK || len(body.Questions) == 0 ||
+		slices.ContainsFunc(body.Questions, func(q PublicQuestion) bool { return strings.HasPrefix(q.ID, "q") }) {
+		t.Errorf("questions: status %d, %+v; want only those from -content-dir", code, body.Questions)
+	}
+	if code, _ := get(t, s.url("/api/maps/tower")); code != http.StatusOK {
+		t.Errorf("map: status %d", code)
+	}
+}
+
+func TestContentDirValidated(t *testing.T) {
+	if _, err := loadConfig([]string{"-content-dir", filepath.Join(t.TempDir(), "nope")}, noEnv); err == nil || !strings.Contains(err.Error(), "content-dir") {
+		t.Errorf("missing content-dir: %v", err)
+	}
+	cfg, err := loadConfig([]string{"-content-dir", "testdata/validate/invalid"}, noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateContent(siteContent(cfg, staticFS)); err == nil {
+		t.Error("invalid questions in -content-dir passed validation")
+	}
+}
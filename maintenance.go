@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maintenanceRetryAfter is how long clients are told to wait before trying
+// again in maintenance mode that has no announced end.
+const maintenanceRetryAfter = time.Minute
+
+// maintenanceExempt are the paths, or with a trailing slash the path
+// prefixes, still served in maintenance mode: the admin API so the mode
+// can be switched off, the probes, metrics, and profiles so the process is
+// not restarted or lost from monitoring, and the stylesheet of the
+// maintenance page.
+var maintenanceExempt = []string{"/healthz", "/readyz", "/metrics", pprofPrefix, "/api/admin/", "/api/v1/admin/", "/css/"}
+
+// maintenance is the switch for maintenance mode, in which everything but
+// maintenanceExempt answers 503, and when it is expected to end, if that
+// was announced. It is kept in memory only, so every run starts with it
+// off.
+type maintenance struct {
+	on    atomic.Bool
+	until atomic.Int64 // Unix nanoseconds; 0 if no end was announced
+}
+
+// Set switches maintenance mode on or off, announcing until as its end
+// when it is not zero.
+func (m *maintenance) Set(on bool, until time.Time) {
+	var end int64
+	if on && !until.IsZero() {
+		end = until.UnixNano()
+	}
+	m.until.Store(end)
+	if m.on.Swap(on) != on {
+		slog.Info("maintenance mode", "enabled", on)
+	}
+}
+
+// Toggle flips maintenance mode, with no announced end.
+func (m *maintenance) Toggle() {
+	m.until.Store(0)
+	for {
+		on := m.on.Load()
+		if m.on.CompareAndSwap(on, !on) {
+			slog.Info("maintenance mode", "enabled", !on)
+			return
+		}
+	}
+}
+
+// Until returns the announced end of maintenance mode, zero if there is
+// none.
+func (m *maintenance) Until() time.Time {
+	if end := m.until.Load(); end != 0 {
+		return time.Unix(0, end).UTC()
+	}
+	return time.Time{}
+}
+
+// retryAfter returns how long clients should wait at now before trying
+// again: until the announced end, or maintenanceRetryAfter once that has
+// passed or if there is none.
+func (m *maintenance) retryAfter(now time.Time) time.Duration {
+	if until := m.Until(); until.After(now) {
+		return until.Sub(now)
+	}
+	return maintenanceRetryAfter
+}
+
+// watchSignals toggles maintenance mode on each maintenanceSignals
+// signal until ctx is done.
+func (m *maintenance) watchSignals(ctx context.Context) {
+	if len(maintenanceSignals) == 0 {
+		return
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, maintenanceSignals...)
+	go func() {
+		defer signal.Stop(sig)
+		for {
+			select {
+			case <-sig:
+				m.Toggle()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// middleware answers requests with 503 while maintenance mode is on: the
+// themed page for browsers, an API error for API clients, both with a
+// Retry-After header.
+func (m *maintenance) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.on.Load() || isMaintenanceExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		retryAfter := m.retryAfter(time.Now())
+		if wantsJSON(r) {
+			w.Header().Set("Cache-Control", "no-store")
+			writeRetryAfter(w, http.StatusServiceUnavailable, "down for maintenance, back soon", retryAfter)
+			return
+		}
+		setRetryAfter(w, retryAfter)
+		writeError(w, r, http.StatusServiceUnavailable)
+	})
+}
+
+// isMaintenanceExempt reports whether p is served in maintenance mode.
+func isMaintenanceExempt(p string) bool {
+	for _, e := range maintenanceExempt {
+		if p == e || strings.HasSuffix(e, "/") && strings.HasPrefix(p, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// maintenanceStatus is the body of /api/admin/maintenance: whether
+// maintenance mode is on, and its announced end, if any.
+type maintenanceStatus struct {
+	Enabled bool       `json:"enabled"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+// adminMaintenanceHandler serves GET /api/admin/maintenance, reporting
+// whether maintenance mode is on, and PUT with {"enabled": bool} to
+// switch it, optionally with "until", an RFC 3339 time, announcing when
+// it will end.
+func adminMaintenanceHandler(m *maintenance) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut:
+			var req struct {
+				Enabled *bool      `json:"enabled"`
+				Until   *time.Time `json:"until"`
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, 1<<10)
+			dec := json.NewDecoder(r.Body)
+			dec.DisallowUnknownFields()
+			err := dec.Decode(&req)
+			if err != nil || req.Enabled == nil {
+				writeBodyError(w, err, `body must be {"enabled": true} or {"enabled": false}`)
+				return
+			}
+			var until time.Time
+			if req.Until != nil {
+				if !*req.Enabled || !req.Until.After(time.Now()) {
+					writeAPIError(w, http.StatusBadRequest, "until must be a future time, with enabled true")
+					return
+				}
+				until = *req.Until
+			}
+			m.Set(*req.Enabled, until)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		status := maintenanceStatus{Enabled: m.on.Load()}
+		if until := m.Until(); !until.IsZero() {
+			status.Until = &until
+		}
+		writeJSON(w, http.StatusOK, status)
+	})
+}
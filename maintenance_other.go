@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// maintenanceSignals toggle maintenance mode. Without SIGUSR1, only the
+// admin API can.
+var maintenanceSignals []os.Signal
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// statusOf fetches path with the Accept header accept and returns the
+// response, its body closed.
+func statusOf(t *testing.T, s *runningServer, path, accept string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, s.url(path), nil)
+	req.Header.Set("Accept", accept)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	s := startServer(t, "-admin-password", testAdminPassword)
+	if code := adminRequest(t, s, http.MethodPut, "/api/admin/maintenance", "admin", testAdminPassword, map[string]any{"enabled": true}); code != http.StatusOK {
+		t.Fatalf("enable: status %d", code)
+	}
+
+	api := statusOf(t, s, "/api/questions", "application/json")
+	if api.StatusCode != http.StatusServiceUnavailable || api.Header.Get("Content-Type") != "application/json" || api.Header.Get("Retry-After") == "" {
+		t.Errorf("API in maintenance: status %d as %s, Retry-After %q; want a 503 JSON error with Retry-After",
+			api.StatusCode, api.Header.Get("Content-Type"), api.Header.Get("Retry-After"))
+	}
+	page := statusOf(t, s, "/", "text/html")
+	if page.StatusCode != http.StatusServiceUnavailable || !strings.HasPrefix(page.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("page in maintenance: status %d as %s, want a 503 HTML page", page.StatusCode, page.Header.Get("Content-Type"))
+	}
+	for _, path := range []string{"/healthz", "/css/game.css"} {
+		if resp := statusOf(t, s, path, "*/*"); resp.StatusCode != http.StatusOK {
+			t.Errorf("%s in maintenance: status %d, want 200", path, resp.StatusCode)
+		}
+	}
+	if code := adminRequest(t, s, http.MethodGet, "/api/admin/maintenance", "admin", testAdminPassword, nil); code != http.StatusOK {
+		t.Errorf("admin API in maintenance: status %d, want 200", code)
+	}
+
+	if code := adminRequest(t, s, http.MethodPut, "/api/admin/maintenance", "admin", testAdminPassword, map[string]any{"enabled": false}); code != http.StatusOK {
+		t.Fatalf("disable: status %d", code)
+	}
+	if resp := statusOf(t, s, "/api/questions", "application/json"); resp.StatusCode != http.StatusOK {
+		t.Errorf("API after maintenance: status %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestMaintenanceAnnouncedEnd(t *testing.T) {
+	s := startServer(t, "-admin-password", testAdminPassword)
+	until := time.Now().Add(90 * time.Second).UTC().Format(time.RFC3339)
+	if code := adminRequest(t, s, http.MethodPut, "/api/admin/maintenance", "admin", testAdminPassword, map[string]any{"enabled": true, "until": until}); code != http.StatusOK {
+		t.Fatalf("enable until %s: status %d", until, code)
+	}
+	resp, err := http.Get(s.url("/api/questions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if secs := retryHintOf(t, resp.Header, body); resp.StatusCode != http.StatusServiceUnavailable || secs < 85 || secs > 90 {
+		t.Errorf("status %d, retry after %ds; want 503 with the time left until %s", resp.StatusCode, secs, until)
+	}
+	if got := statusOf(t, s, "/", "text/html").Header.Get("Retry-After"); got == "" || got == "60" {
+		t.Errorf("maintenance page: Retry-After %q, want the time left until %s", got, until)
+	}
+	for _, body := range []map[string]any{{"enabled": true, "until": "2001-01-01T00:00:00Z"}, {"enabled": false, "until": until}, {}} {
+		if code := adminRequest(t, s, http.MethodPut, "/api/admin/maintenance", "admin", testAdminPassword, body); code != http.StatusBadRequest {
+			t.Errorf("PUT %v: status %d, want 400", body, code)
+		}
+	}
+}
+
+func TestMaintenanceToggledBySignal(t *testing.T) {
+	s := startServer(t)
+	toggle := func(want int) {
+		t.Helper()
+		syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+		waitFor(t, "maintenance toggle", func() bool {
+			return statusOf(t, s, "/api/questions", "application/json").StatusCode == want
+		})
+	}
+	toggle(http.StatusServiceUnavailable)
+	toggle(http.StatusOK)
+}
+
+func TestMaintenanceOffAfterRestart(t *testing.T) {
+	inTempDir(t)
+	s := startServerIn(t, "-admin-password", testAdminPassword)
+	adminRequest(t, s, http.MethodPut, "/api/admin/maintenance", "admin", testAdminPassword, map[string]any{"enabled": true})
+	s.stop(t)
+	s = startServerIn(t)
+	if resp := statusOf(t, s, "/api/questions", "application/json"); resp.StatusCode != http.StatusOK {
+		t.Errorf("after a restart: status %d, want 200", resp.StatusCode)
+	}
+}
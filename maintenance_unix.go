@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// maintenanceSignals toggle maintenance mode.
+var maintenanceSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// manifestFile is the embedded web app manifest the generated one is
+// based on.
+const manifestFile = "manifest.json"
+
+// manifestOverrides returns the manifest members set in cfg, keyed by
+// their manifest name. Members left empty keep the embedded file's value.
+func (cfg *Config) manifestOverrides() map[string]string {
+	overrides := map[string]string{
+		"name":             cfg.AppName,
+		"short_name":       cfg.AppShortName,
+		"theme_color":      cfg.ThemeColor,
+		"background_color": cfg.BackgroundColor,
+		"start_url":        cfg.StartURL,
+		"scope":            cfg.Scope,
+	}
+	for k, v := range overrides {
+		if v == "" {
+			delete(overrides, k)
+		}
+	}
+	return overrides
+}
+
+// buildManifest returns the manifest in content with overrides applied.
+// Root-relative start_url and scope values are moved under basePath, and
+// the icons are the PNGs served under /icons/.
+func buildManifest(content fs.FS, overrides map[string]string, basePath string) ([]byte, error) {
+	data, err := fs.ReadFile(content, manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	manifest := make(map[string]any)
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("%s: %w", manifestFile, err)
+	}
+	for k, v := range overrides {
+		manifest[k] = v
+	}
+	for _, k := range []string{"start_url", "scope"} {
+		if v, ok := manifest[k].(string); ok && strings.HasPrefix(v, "/") {
+			manifest[k] = basePath + v
+		}
+	}
+	manifest["icons"] = manifestIcons(basePath)
+	return json.MarshalIndent(manifest, "", "  ")
+}
+
+// manifestHandler serves a generated manifest with a content-derived ETag.
+func manifestHandler(manifest []byte) http.Handler {
+	tag := etag(contentHash(manifest))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/manifest+json")
+		w.Header().Set("ETag", tag)
+		http.ServeContent(w, r, manifestFile, time.Time{}, strings.NewReader(string(manifest)))
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// fetchManifest fetches /manifest.json from s and decodes it.
+func fetchManifest(t *testing.T, s *runningServer) (*http.Response, map[string]any) {
+	t.Helper()
+	resp, err := http.Get(s.url("/manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	manifest := make(map[string]any)
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	return resp, manifest
+}
+
+func TestManifestThemeColorFromConfig(t *testing.T) {
+	s := startServer(t, "-theme-color", "#336699")
+	resp, manifest := fetchManifest(t, s)
+	if got := resp.Header.Get("Content-Type"); got != "application/manifest+json" {
+		t.Errorf("Content-Type = %q, want application/manifest+json", got)
+	}
+	if got := manifest["theme_color"]; got != "#336699" {
+		t.Errorf("theme_color = %v, want the configured #336699", got)
+	}
+	if got := manifest["name"]; got != "LobeLabyrinth - A MindMaze Adventure" {
+		t.Errorf("name = %v, want the embedded file's", got)
+	}
+}
+
+func TestManifestDefaultsAndETag(t *testing.T) {
+	s := startServer(t)
+	resp, manifest := fetchManifest(t, s)
+	for k, want := range map[string]string{
+		"short_name":       "LobeLabyrinth",
+		"theme_color":      "#D4AF37",
+		"background_color": "#2C1810",
+		"start_url":        "/game.html",
+		"scope":            "/",
+	} {
+		if got := manifest[k]; got != want {
+			t.Errorf("%s = %v, want the default %q", k, got, want)
+		}
+	}
+	tag := resp.Header.Get("ETag")
+	if tag == "" {
+		t.Fatal("manifest has no ETag")
+	}
+	req, _ := http.NewRequest(http.MethodGet, s.url("/manifest.json"), nil)
+	req.Header.Set("If-None-Match", tag)
+	again, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again.Body.Close()
+	if again.StatusCode != http.StatusNotModified {
+		t.Errorf("matching If-None-Match: status %d, want 304", again.StatusCode)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	// mapFilesPattern matches the additional game maps, one per file and
+	// identified by the file's base name.
+	mapFilesPattern = "maps/*.json"
+	// defaultMapFile is the original castle, which the client loads
+	// directly and which is listed as defaultMapID.
+	defaultMapFile = "data/rooms.json"
+	defaultMapID   = "castle"
+)
+
+// Room is one room of a game map. Questions optionally pins specific
+// questions to the room on top of its categories.
+type Room struct {
+	ID                 string   `json:"id"`
+	Name               string   `json:"name"`
+	Description        string   `json:"description"`
+	Connections        []string `json:"connections"`
+	RequiredScore      int      `json:"requiredScore"`
+	IsStartingRoom     bool     `json:"isStartingRoom"`
+	IsFinalRoom        bool     `json:"isFinalRoom,omitempty"`
+	ImageURL           string   `json:"imageUrl,omitempty"`
+	QuestionCategories []string `json:"questionCategories"`
+	Questions          []string `json:"questions,omitempty"`
+}
+
+// gameMap is a castle layout players can choose.
+type gameMap struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Rooms       []Room `json:"rooms"`
+}
+
+// mapSummary describes a map in the /api/maps listing.
+type mapSummary struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Rooms       int    `json:"rooms"`
+}
+
+// mapID returns the ID of the map in file.
+func mapID(file string) string {
+	if file == defaultMapFile {
+		return defaultMapID
+	}
+	return strings.TrimSuffix(path.Base(file), ".json")
+}
+
+// mapFiles returns the map files present in fsys, sorted.
+func mapFiles(fsys fs.FS) []string {
+	files, _ := fs.Glob(fsys, mapFilesPattern)
+	if _, err := fs.Stat(fsys, defaultMapFile); err == nil {
+		files = append(files, defaultMapFile)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// loadMaps parses every map in fsys, keyed by ID. The maps are expected
+// to have passed validateContent.
+func loadMaps(fsys fs.FS) (map[string]*gameMap, error) {
+	maps := make(map[string]*gameMap)
+	for _, file := range mapFiles(fsys) {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		m := &gameMap{}
+		if err := json.Unmarshal(data, m); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		m.ID = mapID(file)
+		if _, dup := maps[m.ID]; dup {
+			return nil, fmt.Errorf("%s: duplicate map id %q", file, m.ID)
+		}
+		maps[m.ID] = m
+	}
+	return maps, nil
+}
+
+// mapsHandler serves GET /api/maps, listing the available maps, and
+// GET /api/maps/{id}, returning one in full.
+func mapsHandler(maps map[string]*gameMap) http.Handler {
+	summaries := make([]mapSummary, 0, len(maps))
+	for _, id := range sortedKeys(maps) {
+		m := maps[id]
+		summaries = append(summaries, mapSummary{ID: m.ID, Name: m.Name, Description: m.Description, Rooms: len(m.Rooms)})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		id := r.PathValue("id")
+		if id == "" {
+			writeJSON(w, http.StatusOK, map[string]any{"maps": summaries})
+			return
+		}
+		m, ok := maps[id]
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, "no such map")
+			return
+		}
+		writeJSON(w, http.StatusOK, m)
+	})
+}
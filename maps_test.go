@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// mapDoc builds a map file from room objects given as JSON.
+func mapDoc(rooms ...string) string {
+	return `{"name": "Test Keep", "rooms": [` + strings.Join(rooms, ", ") + `]}`
+}
+
+const (
+	startRoom = `{"id": "gate", "name": "Gate", "connections": ["hall"], "isStartingRoom": true}`
+	hallRoom  = `{"id": "hall", "name": "Hall", "connections": ["gate", "vault"]}`
+	vaultRoom = `{"id": "vault", "name": "Vault", "connections": ["hall"], "isFinalRoom": true}`
+)
+
+func TestValidateMap(t *testing.T) {
+	tests := []struct {
+		name, doc, want string
+	}{
+		{"well formed", mapDoc(startRoom, hallRoom, vaultRoom), ""},
+		{"unreachable exit", mapDoc(
+			`{"id": "gate", "name": "Gate", "connections": ["hall"], "isStartingRoom": true}`,
+			`{"id": "hall", "name": "Hall", "connections": ["gate"]}`,
+			`{"id": "vault", "name": "Vault", "connections": ["hall"], "isFinalRoom": true}`,
+		), "rooms[2] (vault): final room cannot be reached from the starting room"},
+		{"dangling connection", mapDoc(startRoom, `{"id": "hall", "name": "Hall", "connections": ["gate", "vault", "moat"]}`, vaultRoom), `connects to unknown room "moat"`},
+		{"no start", mapDoc(strings.Replace(startRoom, `"isStartingRoom": true`, `"isStartingRoom": false`, 1), hallRoom, vaultRoom), "no starting room"},
+		{"no exit", mapDoc(startRoom, hallRoom, strings.Replace(vaultRoom, `"isFinalRoom": true`, `"isFinalRoom": false`, 1)), "no final room"},
+		{"duplicate room", mapDoc(startRoom, hallRoom, vaultRoom, strings.Replace(vaultRoom, `"Vault"`, `"Second Vault"`, 1)), "duplicate id"},
+		{"no rooms", `{"name": "Empty", "rooms": []}`, "no rooms"},
+		{"unknown question", mapDoc(startRoom, strings.Replace(hallRoom, `"name": "Hall"`, `"name": "Hall", "questions": ["q404"]`, 1), vaultRoom), `unknown question "q404"`},
+	}
+	for _, tt := range tests {
+		got := problemMessages(validateMap("maps/keep.json", []byte(tt.doc), map[string]bool{"q001": true}))
+		switch {
+		case tt.want == "" && got != "":
+			t.Errorf("%s: unexpected problems: %s", tt.name, got)
+		case !strings.Contains(got, tt.want):
+			t.Errorf("%s: problems %q, want one containing %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadEmbeddedMaps(t *testing.T) {
+	maps, err := loadMaps(staticFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{defaultMapID, "tower"} {
+		if m := maps[id]; m == nil || m.Name == "" || len(m.Rooms) == 0 {
+			t.Errorf("map %q missing or empty: %+v", id, m)
+		}
+	}
+}
+
+func TestMapsEndpoints(t *testing.T) {
+	s := startServer(t)
+	var list struct {
+		Maps []mapSummary `json:"maps"`
+	}
+	if code := getJSON(t, s.url("/api/maps"), &list); code != http.StatusOK {
+		t.Fatalf("/api/maps: status %d", code)
+	}
+	if len(list.Maps) < 2 {
+		t.Fatalf("listed maps %+v, want the castle and the tower", list.Maps)
+	}
+	for _, summary := range list.Maps {
+		var m gameMap
+		if code := getJSON(t, s.url("/api/maps/"+summary.ID), &m); code != http.StatusOK {
+			t.Errorf("/api/maps/%s: status %d", summary.ID, code)
+		}
+		if len(m.Rooms) != summary.Rooms {
+			t.Errorf("map %s has %d rooms, listed with %d", summary.ID, len(m.Rooms), summary.Rooms)
+		}
+	}
+	if code := getJSON(t, s.url("/api/maps/atlantis"), nil); code != http.StatusNotFound {
+		t.Errorf("unknown map: status %d, want 404", code)
+	}
+}
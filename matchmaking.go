@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// matchResult is the /api/matchmake response: the room to connect to at
+// /ws/room/{room}, and how many players were matched into it.
+type matchResult struct {
+	Room     string `json:"room"`
+	Players  int    `json:"players"`
+	Capacity int    `json:"capacity"`
+	Solo     bool   `json:"solo"`
+}
+
+// pendingMatch is a room players are being matched into. ready is closed
+// once the match starts, after which players no longer changes.
+type pendingMatch struct {
+	id      string
+	players int
+	ready   chan struct{}
+}
+
+// matchmaker pairs waiting players into rooms of up to capacity, starting
+// a room early once its first player has waited timeout.
+type matchmaker struct {
+	capacity int
+	timeout  time.Duration
+
+	mu   sync.Mutex
+	open *pendingMatch
+}
+
+func newMatchmaker(capacity int, timeout time.Duration) *matchmaker {
+	return &matchmaker{capacity: capacity, timeout: timeout}
+}
+
+// start closes the match to new players. m.mu must be held.
+func (m *matchmaker) start(pm *pendingMatch) {
+	if m.open == pm {
+		m.open = nil
+	}
+	close(pm.ready)
+}
+
+// Matchmake places the caller in the open match, creating one if there is
+// none, and waits for it to fill or time out. If ctx is done first the
+// caller gives up its place.
+func (m *matchmaker) Matchmake(ctx context.Context) (matchResult, error) {
+	m.mu.Lock()
+	pm := m.open
+	if pm == nil {
+		pm = &pendingMatch{id: "m-" + randomID(8), ready: make(chan struct{})}
+		m.open = pm
+	}
+	pm.players++
+	if pm.players >= m.capacity {
+		m.start(pm)
+	}
+	m.mu.Unlock()
+
+	timer := time.NewTimer(m.timeout)
+	defer timer.Stop()
+	select {
+	case <-pm.ready:
+	case <-timer.C:
+		m.mu.Lock()
+		if m.open == pm {
+			m.start(pm)
+		}
+		m.mu.Unlock()
+	case <-ctx.Done():
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.open == pm {
+			pm.players--
+			if pm.players == 0 {
+				m.open = nil
+			}
+		}
+		return matchResult{}, ctx.Err()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return matchResult{Room: pm.id, Players: pm.players, Capacity: m.capacity, Solo: pm.players == 1}, nil
+}
+
+// matchmakeHandler serves POST /api/matchmake, responding once the caller
+// has been matched into a room.
+func matchmakeHandler(m *matchmaker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		res, err := m.Matchmake(r.Context())
+		if err != nil {
+			// The client went away; there is no one to respond to.
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMatchmakingRespectsCapacity(t *testing.T) {
+	const capacity, players = 3, 9
+	m := newMatchmaker(capacity, 10*time.Second)
+	results := make([]matchResult, players)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := m.Matchmake(context.Background())
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = res
+		}()
+	}
+	wg.Wait()
+
+	rooms := map[string]int{}
+	for _, res := range results {
+		rooms[res.Room]++
+		if res.Players != capacity || res.Capacity != capacity || res.Solo {
+			t.Errorf("result %+v, want a full room of %d", res, capacity)
+		}
+	}
+	if len(rooms) != players/capacity {
+		t.Errorf("%d players went to %d rooms, want %d", players, len(rooms), players/capacity)
+	}
+	for room, n := range rooms {
+		if n != capacity {
+			t.Errorf("room %s got %d players, capacity %d", room, n, capacity)
+		}
+	}
+}
+
+func TestMatchmakingTimesOutToSolo(t *testing.T) {
+	m := newMatchmaker(4, 50*time.Millisecond)
+	start := time.Now()
+	res, err := m.Matchmake(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Solo || res.Players != 1 {
+		t.Errorf("a lone player got %+v, want a solo room", res)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("solo room started after %v, before the timeout", elapsed)
+	}
+	next, err := m.Matchmake(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Room == res.Room {
+		t.Error("a player joined a room that had already started")
+	}
+}
+
+func TestMatchmakingCancelGivesUpPlace(t *testing.T) {
+	m := newMatchmaker(2, 10*time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := m.Matchmake(ctx)
+		errc <- err
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		m.mu.Lock()
+		waiting := m.open != nil && m.open.players == 1
+		m.mu.Unlock()
+		if waiting {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the first player never queued")
+		}
+	}
+	cancel()
+	if err := <-errc; err == nil {
+		t.Fatal("a cancelled matchmake succeeded")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.open != nil {
+		t.Errorf("the cancelled player still holds a place in %+v", m.open)
+	}
+}
+
+func TestMatchmakeEndpoint(t *testing.T) {
+	s := startServer(t, "-room-capacity", "2")
+	results := make([]matchResult, 2)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Post(s.url("/api/matchmake"), "application/json", nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			if err := json.NewDecoder(resp.Body).Decode(&results[i]); err != nil || resp.StatusCode != http.StatusOK {
+				t.Errorf("matchmake: status %d (%v)", resp.StatusCode, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if results[0].Room == "" || results[0].Room != results[1].Room || results[0].Players != 2 {
+		t.Errorf("two players were matched as %+v and %+v, want one room of 2", results[0], results[1])
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// questionMediaDir holds the images questions show, which they name by
+// file. The directory is embedded with the other assets.
+const questionMediaDir = "questions/media"
+
+// questionMediaTypes are the image types a question may show, by file
+// extension, each with the type http.DetectContentType must find in the
+// file. SVG is left out, since it can carry script.
+var questionMediaTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// validMediaName is what the name of a question image may look like: a
+// plain file name, so that it cannot reach outside questionMediaDir.
+var validMediaName = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]{0,127}$`)
+
+// checkMediaName returns why name cannot be a question image, or "" if
+// it can.
+func checkMediaName(name string) string {
+	if !validMediaName.MatchString(name) {
+		return fmt.Sprintf("image %q must be a file name in %s", name, questionMediaDir)
+	}
+	if questionMediaTypes[strings.ToLower(path.Ext(name))] == "" {
+		return fmt.Sprintf("image %q must be a PNG, JPEG, GIF, or WebP file", name)
+	}
+	return ""
+}
+
+// questionMediaURL returns where the image name is served, relative to
+// the base path.
+func questionMediaURL(name string) string {
+	return questionMediaDir + "/" + name
+}
+
+// validateQuestionMedia checks that every image the questions of a
+// question file refer to is in questionMediaDir of fsys and holds the
+// type its extension names. Names checkMediaName rejects are left to
+// validateQuestions.
+func validateQuestionMedia(fsys fs.FS, file string, data []byte) []contentProblem {
+	var doc struct {
+		Questions []Question `json:"questions"`
+	}
+	if json.Unmarshal(data, &doc) != nil {
+		return nil
+	}
+	var problems []contentProblem
+	for i, q := range doc.Questions {
+		if q.Image == "" || checkMediaName(q.Image) != "" {
+			continue
+		}
+		item := fmt.Sprintf("questions[%d] (%s)", i, q.ID)
+		image, err := fs.ReadFile(fsys, path.Join(questionMediaDir, q.Image))
+		if err != nil {
+			problems = append(problems, contentProblem{File: file, Item: item, Message: fmt.Sprintf("image %q is not in %s", q.Image, questionMediaDir)})
+			continue
+		}
+		want := questionMediaTypes[strings.ToLower(path.Ext(q.Image))]
+		if got := http.DetectContentType(image); got != want {
+			problems = append(problems, contentProblem{File: file, Item: item, Message: fmt.Sprintf("image %q holds %s, not %s", q.Image, got, want)})
+		}
+	}
+	return problems
+}
+
+// questionMediaHandler serves GET /questions/media/{file}, the question
+// images in content, with the content type their extension names, which
+// startup validation has checked against the files. Like other static
+// assets they are cached by the hashes of their content.
+func questionMediaHandler(content fs.FS, hashes map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file := r.PathValue("file")
+		name := path.Join(questionMediaDir, file)
+		if checkMediaName(file) != "" {
+			writeError(w, r, http.StatusNotFound)
+			return
+		}
+		image, err := fs.ReadFile(content, name)
+		if err != nil {
+			writeError(w, r, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", questionMediaTypes[strings.ToLower(path.Ext(file))])
+		if hash, ok := hashes[name]; ok {
+			w.Header().Set("ETag", etag(hash))
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", staticMaxAge))
+		}
+		http.ServeContent(w, r, file, time.Time{}, bytes.NewReader(image))
+	})
+}
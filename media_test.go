@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// pngImage is the signature of a PNG file, which is all
+// http.DetectContentType looks at.
+var pngImage = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestQuestionImageServed(t *testing.T) {
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var withImage *Question
+	for i := range questions {
+		if questions[i].Image != "" {
+			withImage = &questions[i]
+		}
+	}
+	if withImage == nil {
+		t.Fatal("no question shows an image")
+	}
+	s := startServer(t)
+
+	var body struct {
+		Questions []PublicQuestion `json:"questions"`
+	}
+	getJSON(t, s.url("/api/questions?count=50"), &body)
+	var url string
+	for _, q := range body.Questions {
+		if q.ID == withImage.ID {
+			url = q.Image
+		}
+	}
+	if url != questionMediaDir+"/"+withImage.Image {
+		t.Fatalf("%s served with image URL %q", withImage.ID, url)
+	}
+
+	want, err := fs.ReadFile(staticFS, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, image := getWithHeaders(t, s.url("/"+url), nil)
+	if resp.StatusCode != http.StatusOK || image != string(want) {
+		t.Fatalf("GET %s: status %d, not the image", url, resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "image/png" {
+		t.Errorf("Content-Type = %q, want image/png", got)
+	}
+	if got := resp.Header.Get("Cache-Control"); !strings.HasPrefix(got, "public, max-age=") {
+		t.Errorf("Cache-Control = %q, want public caching", got)
+	}
+	if code, _ := conditionalGet(t, s.url("/"+url), resp.Header.Get("ETag")); code != http.StatusNotModified {
+		t.Errorf("revalidating the image: status %d, want 304", code)
+	}
+	for _, path := range []string{"/questions/media/missing.png", "/questions/media/..%2Fdata%2Fquestions.json", "/questions/media/logo.svg"} {
+		if code, _ := get(t, s.url(path)); code != http.StatusNotFound {
+			t.Errorf("GET %s: status %d, want 404", path, code)
+		}
+	}
+}
+
+func TestValidateQuestionMedia(t *testing.T) {
+	question := func(image string) string {
+		return `{"questions": [{` + validQuestion + `, "image": "` + image + `"}]}`
+	}
+	tests := []struct {
+		name, doc, want string
+	}{
+		{"valid", question("mars.png"), ""},
+		{"dangling", question("venus.png"), `image "venus.png" is not in questions/media`},
+		{"wrong type", question("notes.jpg"), `holds text/plain; charset=utf-8, not image/jpeg`},
+		{"path", question("../data/questions.json"), "must be a file name"},
+		{"svg", question("logo.svg"), "PNG, JPEG, GIF, or WebP"},
+	}
+	for _, tt := range tests {
+		fsys := fstest.MapFS{
+			"data/questions.json":       {Data: []byte(tt.doc)},
+			"data/achievements.json":    {Data: []byte(`{"achievements": []}`)},
+			"questions/media/mars.png":  {Data: pngImage},
+			"questions/media/notes.jpg": {Data: []byte("not a picture")},
+			"questions/media/logo.svg":  {Data: []byte("<svg/>")},
+		}
+		err := validateContent(fsys)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: error %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestValidateCommandRejectsDanglingImage(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "site")
+	if err := os.CopyFS(dir, os.DirFS("testdata/validate/valid")); err != nil {
+		t.Fatal(err)
+	}
+	doc := `{"questions": [{` + validQuestion + `, "image": "gone.png"}]}`
+	if err := os.WriteFile(filepath.Join(dir, "data", "questions.json"), []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	code, out, _ := runCommand("validate", dir)
+	if code != 1 || !strings.Contains(out, `image "gone.png" is not in questions/media`) {
+		t.Errorf("exit %d, want 1 naming the missing image:\n%s", code, out)
+	}
+
+	os.MkdirAll(filepath.Join(dir, questionMediaDir), 0o755)
+	os.WriteFile(filepath.Join(dir, questionMediaDir, "gone.png"), pngImage, 0o644)
+	if code, out, _ := runCommand("validate", dir); code != 0 {
+		t.Errorf("with the image in place: exit %d:\n%s", code, out)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsNamespace prefixes every exported metric name.
+const metricsNamespace = "lobelabyrinth"
+
+// durationBuckets are the upper bounds, in seconds, of the request latency
+// histogram. They match the Prometheus client defaults.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metrics collects request counters and latency histograms and renders
+// them, along with registered gauges, in the Prometheus text format.
+type metrics struct {
+	mu        sync.Mutex
+	requests  map[[2]string]uint64 // {route, status} -> count
+	durations map[string]*histogram
+
+	gauges []gauge
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+type gauge struct {
+	name, help string
+	value      func() float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:  make(map[[2]string]uint64),
+		durations: make(map[string]*histogram),
+	}
+}
+
+// addGauge registers a gauge whose value is read at scrape time.
+func (m *metrics) addGauge(name, help string, value func() float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gauges = append(m.gauges, gauge{name: metricsNamespace + "_" + name, help: help, value: value})
+}
+
+// observe records one finished request.
+func (m *metrics) observe(route string, status int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[[2]string{route, strconv.Itoa(status)}]++
+	h := m.durations[route]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		m.durations[route] = h
+	}
+	secs := d.Seconds()
+	for i, le := range durationBuckets {
+		if secs <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += secs
+	h.count++
+}
+
+// instrument records the route, status, and latency of each request
+// handled by next. The route is the template of the ServeMux pattern that
+// matched, or unmatchedRoute, which keeps label cardinality bounded.
+func (m *metrics) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() { m.observe(requestRoute(r.Context()), rec.Status(), time.Since(start)) }()
+		rec.serve(next, r)
+	})
+}
+
+// handler serves the metrics in the Prometheus text exposition format.
+func (m *metrics) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.write(w)
+	})
+}
+
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name := metricsNamespace + "_http_requests_total"
+	fmt.Fprintf(w, "# HELP %s Total HTTP requests by route and status.\n# TYPE %s counter\n", name, name)
+	keys := make([][2]string, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{route=%s,status=%s} %d\n", name, labelValue(k[0]), labelValue(k[1]), m.requests[k])
+	}
+
+	name = metricsNamespace + "_http_request_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s HTTP request latency by route.\n# TYPE %s histogram\n", name, name)
+	routes := make([]string, 0, len(m.durations))
+	for route := range m.durations {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		h := m.durations[route]
+		label := labelValue(route)
+		var cumulative uint64
+		for i, le := range durationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket{route=%s,le=\"%g\"} %d\n", name, label, le, cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{route=%s,le=\"+Inf\"} %d\n", name, label, h.count)
+		fmt.Fprintf(w, "%s_sum{route=%s} %g\n", name, label, h.sum)
+		fmt.Fprintf(w, "%s_count{route=%s} %d\n", name, label, h.count)
+	}
+
+	for _, g := range m.gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", g.name, g.help, g.name, g.name, g.value())
+	}
+}
+
+// labelValue quotes s as a Prometheus label value.
+func labelValue(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+	return `"` + s + `"`
+}
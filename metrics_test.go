@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// requestCount returns the value of the request counter line for a route
+// matching route and status in the scrape, or -1 if there is none.
+func requestCount(scrape, route string, status int) int {
+	re := regexp.MustCompile(`(?m)^lobelabyrinth_http_requests_total\{route="` + route + `",status="` + strconv.Itoa(status) + `"\} (\d+)$`)
+	m := re.FindStringSubmatch(scrape)
+	if m == nil {
+		return -1
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+func TestMetricsCountRequests(t *testing.T) {
+	s := startServer(t, "-metrics")
+	for i := 0; i < 3; i++ {
+		getJSON(t, s.url("/api/questions"), nil)
+	}
+	get(t, s.url("/no-such-file.js"))
+	code, scrape := get(t, s.url("/metrics"))
+	if code != http.StatusOK {
+		t.Fatalf("/metrics: status %d", code)
+	}
+	if n := requestCount(scrape, `[^"]*/api/questions`, 200); n != 3 {
+		t.Errorf("questions counted %d times, want 3:\n%s", n, scrape)
+	}
+	if n := requestCount(scrape, unmatchedRoute, 404); n != 1 {
+		t.Errorf("missing file counted %d times under %q, want 1", n, unmatchedRoute)
+	}
+	for _, want := range []string{
+		"# TYPE lobelabyrinth_http_request_duration_seconds histogram",
+		"lobelabyrinth_http_requests_in_flight ",
+		"lobelabyrinth_leaderboard_entries 0",
+	} {
+		if !strings.Contains(scrape, want) {
+			t.Errorf("scrape lacks %q", want)
+		}
+	}
+
+	_, scrape = get(t, s.url("/metrics"))
+	if n := requestCount(scrape, `[^"]*/api/questions`, 200); n != 3 {
+		t.Errorf("counter changed to %d between scrapes with no requests", n)
+	}
+}
+
+func TestMetricsOptIn(t *testing.T) {
+	s := startServer(t)
+	if code, _ := get(t, s.url("/metrics")); code != http.StatusNotFound {
+		t.Errorf("/metrics without -metrics: status %d, want 404", code)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import "net/http"
+
+// middleware wraps a handler in behavior of its own, such as a rate limit
+// or compression.
+type middleware func(http.Handler) http.Handler
+
+// chain returns h wrapped in mws, the first outermost: chain(h, a, b)
+// serves a request through a, then b, then h.
+func chain(h http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// stack is an ordered set of middleware for a kind of route, outermost
+// first, so that the routes of a kind all get the same set in the same
+// order.
+type stack []middleware
+
+// with returns s with mws added inside its own middleware, leaving s as
+// it is.
+func (s stack) with(mws ...middleware) stack {
+	return append(s[:len(s):len(s)], mws...)
+}
+
+// then returns h wrapped in s.
+func (s stack) then(h http.Handler) http.Handler {
+	return chain(h, s...)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// recording returns a middleware noting name in order as requests enter
+// and leave it.
+func recording(name string, order *[]string) middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*order = append(*order, name)
+			h.ServeHTTP(w, r)
+			*order = append(*order, "/"+name)
+		})
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	h := http.HandlerFunc(func(http.ResponseWriter, *http.Request) { order = append(order, "handler") })
+	chain(h, recording("a", &order), recording("b", &order)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if want := []string{"a", "b", "handler", "/b", "/a"}; !slices.Equal(order, want) {
+		t.Errorf("served through %v, want %v", order, want)
+	}
+}
+
+func TestStackWithLeavesStack(t *testing.T) {
+	var order []string
+	base := make(stack, 0, 4)
+	base = append(base, recording("outer", &order))
+	api := base.with(recording("api", &order))
+	admin := base.with(recording("auth", &order))
+	h := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+	api.then(h).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if want := []string{"outer", "api", "/api", "/outer"}; !slices.Equal(order, want) {
+		t.Errorf("api stack served through %v, want %v", order, want)
+	}
+	order = nil
+	admin.then(h).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if want := []string{"outer", "auth", "/auth", "/outer"}; !slices.Equal(order, want) {
+		t.Errorf("admin stack served through %v, want %v: adding to one stack changed another", order, want)
+	}
+	if len(base) != 1 {
+		t.Errorf("base stack grew to %d", len(base))
+	}
+}
+
+func TestAdminStackOrder(t *testing.T) {
+	// The rate limit comes before the credentials are checked, so that
+	// guessing them is limited too, and CORS and security headers are set
+	// on the refusals.
+	s := startServer(t, "-admin-password", testAdminPassword, "-rate-limit", "1", "-rate-burst", "2", "-cors-origins", "https://app.example")
+	var codes []int
+	for range 3 {
+		req, _ := http.NewRequest(http.MethodGet, s.url("/api/admin/backup"), nil)
+		req.Header.Set("Origin", "https://app.example")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		codes = append(codes, resp.StatusCode)
+		if resp.Header.Get("Access-Control-Allow-Origin") != "https://app.example" || resp.Header.Get("X-Content-Type-Options") != "nosniff" || resp.Header.Get("X-Request-Id") == "" {
+			t.Errorf("status %d lacks the outer middleware's headers: %v", resp.StatusCode, resp.Header)
+		}
+	}
+	if want := []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}; !slices.Equal(codes, want) {
+		t.Errorf("unauthenticated admin requests: %v, want %v", codes, want)
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// hiddenNamespace holds the questions a moderator took out of play,
+	// keyed by question ID.
+	hiddenNamespace = "hidden-questions"
+	// chatFlagNamespace holds chat messages the filter caught, awaiting
+	// review, keyed by a random ID.
+	chatFlagNamespace = "chat-flags"
+	// bannedNamespace holds the display names barred from multiplayer
+	// rooms, keyed by the lower-cased name.
+	bannedNamespace = "banned-names"
+	// maxModerationBody bounds a resolve request.
+	maxModerationBody = 1 << 10
+)
+
+// Moderation queue item kinds, the prefix of an item's ID.
+const (
+	itemQuestion = "question"
+	itemChat     = "chat"
+)
+
+// moderationActions lists what a moderator may do with each kind of
+// item: any item can be dismissed, a reported question hidden, and the
+// sender of a flagged chat message banned.
+var moderationActions = map[string]map[string]bool{
+	itemQuestion: {"dismiss": true, "hide": true},
+	itemChat:     {"dismiss": true, "ban": true},
+}
+
+// errNoItem reports a moderation item that is not in the queue, and
+// errBadAction an action that does not apply to the item.
+var (
+	errNoItem    = errors.New("no such moderation item")
+	errBadAction = errors.New("unknown action")
+)
+
+// chatFlag is a chat message the filter masked, as it was sent.
+type chatFlag struct {
+	Room   string    `json:"room"`
+	Name   string    `json:"name"`
+	Text   string    `json:"text"`
+	SentAt time.Time `json:"sentAt"`
+}
+
+// moderationMark records when a question was hidden or a name banned.
+type moderationMark struct {
+	At time.Time `json:"at"`
+}
+
+// moderationItem is one entry of the moderation queue. ID is the kind
+// and the question or flag ID, such as "question:q001".
+type moderationItem struct {
+	ID       string         `json:"id"`
+	Kind     string         `json:"kind"`
+	Question *reportSummary `json:"question,omitempty"`
+	Chat     *chatFlag      `json:"chat,omitempty"`
+}
+
+// moderation keeps the moderation state in a Store. The hidden questions
+// and banned names are also held in memory, since they are consulted on
+// every question pick and room join.
+type moderation struct {
+	store   Store
+	reports *reportBook
+
+	mu     sync.RWMutex
+	hidden map[string]bool
+	banned map[string]bool
+}
+
+// newModeration loads the moderation state already in store. Reported
+// questions are queued from reports.
+func newModeration(store Store, reports *reportBook) (*moderation, error) {
+	m := &moderation{store: store, reports: reports}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload reads the hidden questions and banned names again, as after a
+// restore.
+func (m *moderation) Reload() error {
+	hidden, err := m.keys(hiddenNamespace)
+	if err != nil {
+		return err
+	}
+	banned, err := m.keys(bannedNamespace)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hidden, m.banned = hidden, banned
+	return nil
+}
+
+// keys returns the keys stored in namespace as a set.
+func (m *moderation) keys(namespace string) (map[string]bool, error) {
+	keys, err := m.store.List(namespace)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		set[k] = true
+	}
+	return set, nil
+}
+
+// mark stores a moderationMark under key in namespace.
+func (m *moderation) mark(namespace, key string) error {
+	data, err := json.Marshal(moderationMark{At: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	return m.store.Set(namespace, key, data)
+}
+
+// Hide takes question id out of play.
+func (m *moderation) Hide(id string) error {
+	if err := m.mark(hiddenNamespace, id); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hidden[id] = true
+	return nil
+}
+
+// Ban bars name, in any case, from multiplayer rooms.
+func (m *moderation) Ban(name string) error {
+	name = strings.ToLower(name)
+	if err := m.mark(bannedNamespace, name); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.banned[name] = true
+	return nil
+}
+
+// Visible returns questions less the hidden ones.
+func (m *moderation) Visible(questions []Question) []Question {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.hidden) == 0 {
+		return questions
+	}
+	visible := make([]Question, 0, len(questions))
+	for _, q := range questions {
+		if !m.hidden[q.ID] {
+			visible = append(visible, q)
+		}
+	}
+	return visible
+}
+
+// Hash returns a hash of the set of hidden questions.
+func (m *moderation) Hash() string {
+	m.mu.RLock()
+	ids := sortedKeys(m.hidden)
+	m.mu.RUnlock()
+	return contentHash([]byte(strings.Join(ids, "\n")))
+}
+
+// Banned reports whether name, in any case, has been banned.
+func (m *moderation) Banned(name string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.banned[strings.ToLower(name)]
+}
+
+// FlagChat queues f for review.
+func (m *moderation) FlagChat(f chatFlag) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return m.store.Set(chatFlagNamespace, randomID(8), data)
+}
+
+// chatFlags returns the queued chat messages, keyed by flag ID.
+func (m *moderation) chatFlags() (map[string]chatFlag, error) {
+	ids, err := m.store.List(chatFlagNamespace)
+	if err != nil {
+		return nil, err
+	}
+	flags := make(map[string]chatFlag, len(ids))
+	for _, id := range ids {
+		data, err := m.store.Get(chatFlagNamespace, id)
+		if err != nil {
+			return nil, err
+		}
+		var f chatFlag
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("chat flag %s: %w", id, err)
+		}
+		flags[id] = f
+	}
+	return flags, nil
+}
+
+// Queue lists the items awaiting a moderator: reported questions, most
+// reported first, then flagged chat messages, oldest first.
+func (m *moderation) Queue() ([]moderationItem, error) {
+	summaries, err := m.reports.Summaries()
+	if err != nil {
+		return nil, err
+	}
+	flags, err := m.chatFlags()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]moderationItem, 0, len(summaries)+len(flags))
+	for i := range summaries {
+		items = append(items, moderationItem{ID: itemQuestion + ":" + summaries[i].QuestionID, Kind: itemQuestion, Question: &summaries[i]})
+	}
+	var chats []moderationItem
+	for id, f := range flags {
+		chats = append(chats, moderationItem{ID: itemChat + ":" + id, Kind: itemChat, Chat: &f})
+	}
+	sort.Slice(chats, func(i, j int) bool { return chats[i].Chat.SentAt.Before(chats[j].Chat.SentAt) })
+	return append(items, chats...), nil
+}
+
+// Resolve takes action on the queued item id and removes it from the
+// queue. Hiding a question takes it out of play at once; banning returns
+// the name banned, so its players can be removed from their rooms.
+func (m *moderation) Resolve(id, action string) (banned string, err error) {
+	kind, key, _ := strings.Cut(id, ":")
+	actions, ok := moderationActions[kind]
+	if !ok || key == "" {
+		return "", errNoItem
+	}
+	if !actions[action] {
+		return "", fmt.Errorf("%w %q: %s items take %s", errBadAction, action, kind, strings.Join(sortedKeys(actions), ", "))
+	}
+	switch kind {
+	case itemQuestion:
+		reports, err := m.reports.load(key)
+		if err != nil {
+			return "", err
+		}
+		if len(reports) == 0 {
+			return "", errNoItem
+		}
+		if action == "hide" {
+			if err := m.Hide(key); err != nil {
+				return "", err
+			}
+		}
+		return "", m.reports.Clear(key)
+	default:
+		data, err := m.store.Get(chatFlagNamespace, key)
+		if errors.Is(err, ErrNotFound) {
+			return "", errNoItem
+		}
+		if err != nil {
+			return "", err
+		}
+		var f chatFlag
+		if err := json.Unmarshal(data, &f); err != nil {
+			return "", fmt.Errorf("chat flag %s: %w", key, err)
+		}
+		if action == "ban" {
+			banned = f.Name
+			if err := m.Ban(banned); err != nil {
+				return "", err
+			}
+		}
+		return banned, m.store.Delete(chatFlagNamespace, key)
+	}
+}
+
+// resolveRequest is the body of POST /api/admin/moderation/{id}.
+type resolveRequest struct {
+	Action string `json:"action"`
+}
+
+// adminModerationHandler serves GET /api/admin/moderation, the queue of
+// reported questions and flagged chat, and POST
+// /api/admin/moderation/{id} with {"action": A} to resolve one item. A
+// banned name's players are removed from rooms. Given cursor or limit,
+// the queue is paged in item ID order instead, which items queued while
+// paging do not disturb.
+func adminModerationHandler(m *moderation, rooms *hub, sess *sessions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		switch {
+		case id == "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+			items, err := m.Queue()
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, "could not load moderation queue")
+				return
+			}
+			query := r.URL.Query()
+			if !query.Has("cursor") && !query.Has("limit") {
+				writeJSON(w, http.StatusOK, map[string]any{"items": items})
+				return
+			}
+			page, err := cursorParams(r, sess, "moderation")
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			byID := make(map[string]moderationItem, len(items))
+			for _, item := range items {
+				byID[item.ID] = item
+			}
+			ids, next := page.Keys(sortedKeys(byID))
+			items = items[:0]
+			for _, id := range ids {
+				items = append(items, byID[id])
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"items": items, "nextCursor": next})
+		case id != "" && r.Method == http.MethodPost:
+			var req resolveRequest
+			r.Body = http.MaxBytesReader(w, r.Body, maxModerationBody)
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeBodyError(w, err, "invalid JSON body")
+				return
+			}
+			banned, err := m.Resolve(id, req.Action)
+			switch {
+			case errors.Is(err, errNoItem):
+				writeAPIError(w, http.StatusNotFound, err.Error())
+				return
+			case errors.Is(err, errBadAction):
+				writeAPIError(w, http.StatusBadRequest, err.Error())
+				return
+			case err != nil:
+				writeAPIError(w, http.StatusInternalServerError, "could not resolve moderation item")
+				return
+			}
+			if banned != "" {
+				rooms.KickName(banned)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			if id == "" {
+				w.Header().Set("Allow", "GET, HEAD")
+			} else {
+				w.Header().Set("Allow", "POST")
+			}
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+}
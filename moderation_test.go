@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// moderationQueue fetches the moderation queue.
+func moderationQueue(t *testing.T, s *runningServer) []moderationItem {
+	t.Helper()
+	code, data := adminDo(t, s, http.MethodGet, "/api/admin/moderation", nil)
+	if code != http.StatusOK {
+		t.Fatalf("/api/admin/moderation: status %d", code)
+	}
+	var body struct {
+		Items []moderationItem `json:"items"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Items
+}
+
+// resolveItem resolves the moderation item id with action and returns
+// the status.
+func resolveItem(t *testing.T, s *runningServer, id, action string) int {
+	t.Helper()
+	code, _ := adminDo(t, s, http.MethodPost, "/api/admin/moderation/"+id, []byte(`{"action": "`+action+`"}`))
+	return code
+}
+
+func TestHidingReportedQuestion(t *testing.T) {
+	s := startServer(t, "-admin-password", testAdminPassword)
+	questions, _ := loadQuestions(staticFS, questionsFile)
+	id := questions[0].ID
+	if !slices.Contains(questionIDs(t, s, "count=50"), id) {
+		t.Fatalf("%s is not served to begin with", id)
+	}
+	reportQuestion(t, s, startSession(t, s), id, "wrong-answer")
+
+	items := moderationQueue(t, s)
+	if len(items) != 1 || items[0].ID != "question:"+id || items[0].Question == nil || items[0].Question.Count != 1 {
+		t.Fatalf("queue %+v, want the reported question alone", items)
+	}
+	if code := resolveItem(t, s, items[0].ID, "ban"); code != http.StatusBadRequest {
+		t.Errorf("banning a question: status %d, want 400", code)
+	}
+	if code := resolveItem(t, s, items[0].ID, "hide"); code != http.StatusNoContent {
+		t.Fatalf("hide: status %d, want 204", code)
+	}
+	if items := moderationQueue(t, s); len(items) != 0 {
+		t.Errorf("queue after hiding %+v, want it empty", items)
+	}
+	if slices.Contains(questionIDs(t, s, "count=50"), id) {
+		t.Errorf("hidden question %s is still served", id)
+	}
+	if code := resolveItem(t, s, items[0].ID, "dismiss"); code != http.StatusNotFound {
+		t.Errorf("resolving it again: status %d, want 404", code)
+	}
+}
+
+func TestDismissingReportKeepsQuestion(t *testing.T) {
+	s := startServer(t, "-admin-password", testAdminPassword)
+	questions, _ := loadQuestions(staticFS, questionsFile)
+	id := questions[1].ID
+	reportQuestion(t, s, startSession(t, s), id, "typo")
+	if code := resolveItem(t, s, "question:"+id, "dismiss"); code != http.StatusNoContent {
+		t.Fatalf("dismiss: status %d, want 204", code)
+	}
+	if items := moderationQueue(t, s); len(items) != 0 {
+		t.Errorf("queue after dismissing %+v, want it empty", items)
+	}
+	if !slices.Contains(questionIDs(t, s, "count=50"), id) {
+		t.Errorf("dismissing the report hid %s", id)
+	}
+}
+
+func TestBanningFlaggedChatSender(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "blocklist.txt")
+	os.WriteFile(list, []byte("heck\n"), 0o644)
+	s := startServer(t, "-admin-password", testAdminPassword, "-chat-blocklist", list)
+	ada := joinRoom(t, s, "crypt", "ada")
+	ada.stateWith(1)
+	ada.send(clientMessage{Type: msgChat, Text: "what the heck"})
+	ada.next(msgChat)
+
+	items := moderationQueue(t, s)
+	if len(items) != 1 || items[0].Kind != itemChat || items[0].Chat.Name != "ada" || items[0].Chat.Text != "what the heck" {
+		t.Fatalf("queue %+v, want ada's message as sent", items)
+	}
+	if code := resolveItem(t, s, items[0].ID, "ban"); code != http.StatusNoContent {
+		t.Fatalf("ban: status %d, want 204", code)
+	}
+	if items := moderationQueue(t, s); len(items) != 0 {
+		t.Errorf("queue after banning %+v, want it empty", items)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		if _, _, err := ada.conn.Read(ctx); err != nil {
+			if ctx.Err() != nil {
+				t.Fatal("the banned player was not removed from the room")
+			}
+			break
+		}
+	}
+	again := joinRoom(t, s, "crypt", "ADA")
+	if msg := again.next(msgError); !strings.Contains(msg.Error, "may not be used") {
+		t.Errorf("rejoining under the banned name: error %q", msg.Error)
+	}
+}
+
+func TestModerationRequiresAdmin(t *testing.T) {
+	s := startServer(t, "-admin-password", testAdminPassword)
+	if code := adminRequest(t, s, http.MethodGet, "/api/admin/moderation", "admin", "wrong", nil); code != http.StatusUnauthorized {
+		t.Errorf("wrong password: status %d, want 401", code)
+	}
+	if code := resolveItem(t, s, "nonsense", "dismiss"); code != http.StatusNotFound {
+		t.Errorf("unknown item: status %d, want 404", code)
+	}
+}
+
+func TestHiddenQuestionsPersist(t *testing.T) {
+	inTempDir(t)
+	s := startServerIn(t, "-admin-password", testAdminPassword)
+	questions, _ := loadQuestions(staticFS, questionsFile)
+	id := questions[2].ID
+	reportQuestion(t, s, startSession(t, s), id, "typo")
+	resolveItem(t, s, "question:"+id, "hide")
+	s.stop(t)
+
+	s = startServerIn(t, "-admin-password", testAdminPassword)
+	if slices.Contains(questionIDs(t, s, "count=50"), id) {
+		t.Errorf("hidden question %s is served again after a restart", id)
+	}
+}
@@ -0,0 +1,624 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// roomQuestionCount is how many questions players in a multiplayer
+	// room race through.
+	roomQuestionCount = 10
+	// maxWSMessage bounds a single message from a client.
+	maxWSMessage = 4 << 10
+	// wsJoinTimeout is how long a new connection may take to send join.
+	wsJoinTimeout = 10 * time.Second
+	// wsWriteTimeout bounds sending one message to a client.
+	wsWriteTimeout = 10 * time.Second
+	// wsPingInterval is how often idle connections are pinged, so dead
+	// ones are noticed and dropped.
+	wsPingInterval = 30 * time.Second
+	// playerSendBuffer is how many messages may queue for a slow client
+	// before it is disconnected.
+	playerSendBuffer = 16
+	// closingRetryAfter is how long a player turned away during shutdown
+	// is told to wait before reconnecting, by when a restarted server is
+	// usually up.
+	closingRetryAfter = 5 * time.Second
+)
+
+// Message types of the multiplayer protocol. Clients send join first and
+// then answer and finished, and chat at any time; the server sends state
+// to the whole room whenever it changes, result to a player who answered,
+// chat to the whole room from each player who chats, and error for a
+// message it cannot accept. When the server shuts down it sends
+// server-closing, after which players have GraceMs to finish before their
+// connections are closed.
+const (
+	msgJoin     = "join"
+	msgAnswer   = "answer"
+	msgFinished = "finished"
+	msgChat     = "chat"
+	msgState    = "state"
+	msgResult   = "result"
+	msgError    = "error"
+	msgClosing  = "server-closing"
+)
+
+// clientMessage is a message from a player.
+type clientMessage struct {
+	Type        string `json:"type"`
+	Name        string `json:"name,omitempty"`
+	QuestionID  string `json:"questionID,omitempty"`
+	ChoiceIndex int    `json:"choiceIndex"`
+	Text        string `json:"text,omitempty"`
+}
+
+// serverMessage is a message to a player. Which fields are set depends
+// on Type.
+type serverMessage struct {
+	Type       string           `json:"type"`
+	Room       string           `json:"room,omitempty"`
+	Players    []playerState    `json:"players,omitempty"`
+	Questions  []PublicQuestion `json:"questions,omitempty"`
+	QuestionID string           `json:"questionID,omitempty"`
+	Correct    *bool            `json:"correct,omitempty"`
+	Error      string           `json:"error,omitempty"`
+	GraceMs    int64            `json:"graceMs,omitempty"`
+	From       string           `json:"from,omitempty"`
+	Name       string           `json:"name,omitempty"`
+	Text       string           `json:"text,omitempty"`
+	Time       *time.Time       `json:"time,omitempty"`
+}
+
+// playerState is one player's standing in a state message.
+type playerState struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Score    int    `json:"score"`
+	Answered int    `json:"answered"`
+	Finished bool   `json:"finished"`
+}
+
+// roomPlayer is a connected player. All fields but send, conn, and chat
+// are guarded by the hub's mutex.
+type roomPlayer struct {
+	id, name string
+	conn     *websocket.Conn
+	send     chan []byte // encoded serverMessages
+	chat     *rate.Limiter
+	kick     context.CancelFunc
+	room     *gameRoom
+	score    int
+	answered map[string]bool
+	finished bool
+}
+
+// gameRoom is a set of players racing through the same questions.
+type gameRoom struct {
+	id        string
+	questions []Question
+	players   map[*roomPlayer]struct{}
+}
+
+// hub tracks the multiplayer rooms and their connected players.
+type hub struct {
+	bank     *questionBank
+	capacity int
+	grace    time.Duration
+	filter   *chatFilter
+	mod      *moderation
+	cors     *corsPolicy
+	// names holds the display names players claim; a player joins as
+	// the owner the session in the URL or the signed-in profile makes
+	// them, found with sess and profiles.
+	names    *nameRegistry
+	sess     *sessions
+	profiles *accounts
+	// compressMin is the smallest message compressed for clients that
+	// negotiate permessage-deflate; 0 turns compression off.
+	compressMin int
+	// codeTTL is how long a private room's code stays reserved while
+	// no one is in the room.
+	codeTTL time.Duration
+
+	ctx    context.Context // cancelled by Shutdown
+	cancel context.CancelFunc
+	conns  sync.WaitGroup
+
+	mu     sync.Mutex
+	rooms  map[string]*gameRoom
+	codes  map[string]*roomCode
+	open   int // WebSocket connections currently open, joined or not
+	closed bool
+}
+
+// newHub returns a hub drawing questions from bank, less those mod hides,
+// holding up to capacity players per room, giving players grace to finish
+// when it shuts down, masking chat with filter (which may be nil) and
+// flagging what it masks to mod, turning away the names mod bans and
+// those names refuses, accepting WebSocket connections from the origins
+// cors allows, compressing messages of at least compressMin bytes for
+// clients that support it, and freeing the codes of private rooms left
+// empty for codeTTL.
+func newHub(bank *questionBank, capacity int, grace time.Duration, filter *chatFilter, mod *moderation, names *nameRegistry, sess *sessions, profiles *accounts, cors *corsPolicy, compressMin int, codeTTL time.Duration) *hub {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &hub{
+		bank:        bank,
+		capacity:    capacity,
+		grace:       grace,
+		filter:      filter,
+		mod:         mod,
+		names:       names,
+		sess:        sess,
+		profiles:    profiles,
+		cors:        cors,
+		compressMin: compressMin,
+		codeTTL:     codeTTL,
+		ctx:         ctx,
+		cancel:      cancel,
+		rooms:       make(map[string]*gameRoom),
+		codes:       make(map[string]*roomCode),
+	}
+}
+
+// acceptOptions allows WebSocket connections from the policy's origins in
+// addition to the site's own.
+func (p *corsPolicy) acceptOptions() *websocket.AcceptOptions {
+	o := p.allowed.Load()
+	if o.any {
+		return &websocket.AcceptOptions{InsecureSkipVerify: true}
+	}
+	opts := &websocket.AcceptOptions{}
+	for origin := range o.origins {
+		if u, err := url.Parse(origin); err == nil && u.Host != "" {
+			opts.OriginPatterns = append(opts.OriginPatterns, u.Host)
+		}
+	}
+	return opts
+}
+
+// join adds a player called name to room id, creating the room if needed,
+// and tells everyone in it. A private room must have its code reserved.
+func (h *hub) join(id, name string, conn *websocket.Conn, kick context.CancelFunc) (*roomPlayer, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, errors.New("server is shutting down")
+	}
+	if h.mod.Banned(name) {
+		return nil, errors.New("this name may not be used")
+	}
+	capacity, err := h.checkRoom(id, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	room := h.rooms[id]
+	if room == nil {
+		room = &gameRoom{id: id, questions: pickQuestions(h.mod.Visible(h.bank.All()), roomQuestionCount), players: make(map[*roomPlayer]struct{})}
+		h.rooms[id] = room
+	}
+	if len(room.players) >= capacity {
+		return nil, errRoomFull
+	}
+	if c := h.codes[id]; c != nil {
+		c.idleSince = time.Time{}
+	}
+	p := &roomPlayer{
+		id:       randomID(8),
+		name:     name,
+		conn:     conn,
+		send:     make(chan []byte, playerSendBuffer),
+		chat:     rate.NewLimiter(chatRate, chatBurst),
+		kick:     kick,
+		room:     room,
+		answered: make(map[string]bool),
+	}
+	room.players[p] = struct{}{}
+	h.broadcast(room)
+	return p, nil
+}
+
+// pickQuestions returns up to n questions chosen at random.
+func pickQuestions(all []Question, n int) []Question {
+	picked := make([]Question, len(all))
+	copy(picked, all)
+	rand.Shuffle(len(picked), func(i, j int) { picked[i], picked[j] = picked[j], picked[i] })
+	if len(picked) > n {
+		picked = picked[:n]
+	}
+	return picked
+}
+
+// leave removes p from its room, dropping the room once it is empty.
+func (h *hub) leave(p *roomPlayer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room := p.room
+	delete(room.players, p)
+	if len(room.players) == 0 {
+		delete(h.rooms, room.id)
+		if c := h.codes[room.id]; c != nil {
+			c.idleSince = time.Now()
+		}
+		return
+	}
+	h.broadcast(room)
+}
+
+// answer grades p's choice for question id and updates the room.
+func (h *hub) answer(p *roomPlayer, id string, choice int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var q *Question
+	for i := range p.room.questions {
+		if p.room.questions[i].ID == id {
+			q = &p.room.questions[i]
+		}
+	}
+	switch {
+	case q == nil:
+		return fmt.Errorf("question %q is not part of this room", id)
+	case p.finished:
+		return errors.New("already finished")
+	case p.answered[id]:
+		return fmt.Errorf("question %q already answered", id)
+	case choice < 0 || choice >= len(q.Answers):
+		return errors.New("choiceIndex out of range")
+	}
+	correct := choice == q.CorrectAnswer
+	p.answered[id] = true
+	if correct {
+		p.score += q.Points
+	}
+	h.deliver(p, serverMessage{Type: msgResult, QuestionID: id, Correct: &correct})
+	if len(p.answered) == len(p.room.questions) {
+		p.finished = true
+	}
+	h.broadcast(p.room)
+	return nil
+}
+
+// finish marks p as done with the room's questions.
+func (h *hub) finish(p *roomPlayer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p.finished = true
+	h.broadcast(p.room)
+}
+
+// broadcast sends the room's state to everyone in it. h.mu must be held.
+func (h *hub) broadcast(room *gameRoom) {
+	msg := serverMessage{Type: msgState, Room: room.id, Players: make([]playerState, 0, len(room.players))}
+	for p := range room.players {
+		msg.Players = append(msg.Players, playerState{
+			ID:       p.id,
+			Name:     p.name,
+			Score:    p.score,
+			Answered: len(p.answered),
+			Finished: p.finished,
+		})
+	}
+	sort.Slice(msg.Players, func(i, j int) bool {
+		if msg.Players[i].Score != msg.Players[j].Score {
+			return msg.Players[i].Score > msg.Players[j].Score
+		}
+		return msg.Players[i].ID < msg.Players[j].ID
+	})
+	for _, q := range room.questions {
+		msg.Questions = append(msg.Questions, q.Public())
+	}
+	h.deliverAll(room, msg)
+}
+
+// deliver queues msg for p, disconnecting p if its queue is full rather
+// than letting one slow client hold up the room.
+func (h *hub) deliver(p *roomPlayer, msg serverMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("could not encode multiplayer message", "type", msg.Type, "err", err)
+		return
+	}
+	h.queue(p, data)
+}
+
+// deliverAll queues msg for everyone in room, encoding it just once.
+// Compression still happens per connection, as each negotiates its own.
+func (h *hub) deliverAll(room *gameRoom, msg serverMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("could not encode multiplayer message", "type", msg.Type, "err", err)
+		return
+	}
+	for p := range room.players {
+		h.queue(p, data)
+	}
+}
+
+func (h *hub) queue(p *roomPlayer, data []byte) {
+	select {
+	case p.send <- data:
+	default:
+		p.kick()
+	}
+}
+
+// KickName disconnects every player called name, in any case.
+func (h *hub) KickName(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, room := range h.rooms {
+		for p := range room.players {
+			if strings.EqualFold(p.name, name) {
+				p.kick()
+			}
+		}
+	}
+}
+
+// presence is the /api/presence response: the open WebSocket connections
+// and how many players are in each room. Private rooms are left out, so
+// that their codes are not given away.
+type presence struct {
+	Connections int            `json:"connections"`
+	Rooms       map[string]int `json:"rooms"`
+}
+
+// Presence reports who is currently connected.
+func (h *hub) Presence() presence {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p := presence{Connections: h.open, Rooms: make(map[string]int, len(h.rooms))}
+	for id, room := range h.rooms {
+		if !validRoomCode.MatchString(id) {
+			p.Rooms[id] = len(room.players)
+		}
+	}
+	return p
+}
+
+// presenceHandler serves GET /api/presence.
+func presenceHandler(h *hub) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, h.Presence())
+	})
+}
+
+// Shutdown drains the hub. It stops accepting connections, tells every
+// player the server is closing, and waits for them to leave for the grace
+// period, cut to half the time ctx leaves so the rest can go to closing
+// the connections still open with a going-away status. Those that are not
+// gone when ctx expires are dropped.
+func (h *hub) Shutdown(ctx context.Context) error {
+	grace := h.grace
+	if deadline, ok := ctx.Deadline(); ok {
+		grace = min(grace, time.Until(deadline)/2)
+	}
+	h.mu.Lock()
+	h.closed = true
+	for _, p := range h.players() {
+		h.deliver(p, serverMessage{Type: msgClosing, GraceMs: grace.Milliseconds()})
+	}
+	h.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.conns.Wait()
+		close(done)
+	}()
+	defer h.cancel()
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+
+	h.mu.Lock()
+	players := h.players()
+	h.mu.Unlock()
+	for _, p := range players {
+		go p.conn.Close(websocket.StatusGoingAway, "server shutting down")
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// players returns everyone in a room. h.mu must be held.
+func (h *hub) players() []*roomPlayer {
+	var players []*roomPlayer
+	for _, room := range h.rooms {
+		for p := range room.players {
+			players = append(players, p)
+		}
+	}
+	return players
+}
+
+// ServeHTTP serves /ws/room/{id}, upgrading to a WebSocket and running
+// the player's session in the room. A private room that cannot be joined
+// is refused before the upgrade: 404 Not Found for a code that is not
+// reserved, 409 Conflict when the room is full. So is a ?session=S token
+// that does not verify, with 403 Forbidden. The name a player joins as
+// must be one names would let them use on the leaderboard.
+func (h *hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validStoreName.MatchString(id) {
+		writeAPIError(w, http.StatusBadRequest, "room id must be 1-128 letters, digits, '-' or '_'")
+		return
+	}
+	switch err := h.CheckRoom(id, time.Now()); {
+	case errors.Is(err, errRoomCodeUnknown):
+		writeAPIError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, errRoomFull):
+		writeAPIError(w, http.StatusConflict, err.Error())
+		return
+	}
+	sessionID, err := h.sess.fromQuery(r)
+	if err != nil {
+		writeAPIError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	var profileID string
+	if p, err := h.profiles.Current(r); err == nil {
+		profileID = p.ID
+	}
+	owner := nameOwner(profileID, sessionID)
+	h.mu.Lock()
+	closed := h.closed
+	if !closed {
+		h.conns.Add(1)
+	}
+	h.mu.Unlock()
+	if closed {
+		writeRetryAfter(w, http.StatusServiceUnavailable, "server is shutting down", closingRetryAfter)
+		return
+	}
+	defer h.conns.Done()
+
+	opts := h.cors.acceptOptions()
+	if h.compressMin > 0 {
+		// Without context takeover each message is compressed on its own,
+		// which keeps the memory per connection small.
+		opts.CompressionMode = websocket.CompressionNoContextTakeover
+		opts.CompressionThreshold = h.compressMin
+	}
+	conn, err := websocket.Accept(w, r, opts)
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+	h.mu.Lock()
+	h.open++
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		h.open--
+		h.mu.Unlock()
+	}()
+	conn.SetReadLimit(maxWSMessage)
+	ctx, kick := context.WithCancel(h.ctx)
+	defer kick()
+
+	name, err := readJoin(ctx, conn)
+	if err == nil {
+		err = h.names.Check(name, owner, time.Now())
+		if err != nil && !errors.Is(err, errNameBlocked) && !errors.Is(err, errNameTaken) {
+			slog.Warn("could not check name", "err", err)
+			err = errors.New("could not check name")
+		}
+	}
+	if err != nil {
+		wsjson.Write(ctx, conn, serverMessage{Type: msgError, Error: err.Error()})
+		conn.Close(websocket.StatusPolicyViolation, err.Error())
+		return
+	}
+	p, err := h.join(id, name, conn, kick)
+	if err != nil {
+		wsjson.Write(ctx, conn, serverMessage{Type: msgError, Error: err.Error()})
+		conn.Close(websocket.StatusTryAgainLater, err.Error())
+		return
+	}
+	defer h.leave(p)
+	go p.writeLoop(ctx)
+
+	for {
+		var msg clientMessage
+		if err := wsjson.Read(ctx, conn, &msg); err != nil {
+			return
+		}
+		var err error
+		switch msg.Type {
+		case msgAnswer:
+			err = h.answer(p, msg.QuestionID, msg.ChoiceIndex)
+		case msgFinished:
+			h.finish(p)
+		case msgChat:
+			err = h.chat(p, msg.Text)
+		case msgJoin:
+			err = errors.New("already joined")
+		default:
+			err = fmt.Errorf("unknown message type %q", msg.Type)
+		}
+		if err != nil {
+			h.mu.Lock()
+			h.deliver(p, serverMessage{Type: msgError, Error: err.Error()})
+			h.mu.Unlock()
+		}
+	}
+}
+
+// readJoin waits for the join message that must open a connection and
+// returns the player's name.
+func readJoin(ctx context.Context, conn *websocket.Conn) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, wsJoinTimeout)
+	defer cancel()
+	var msg clientMessage
+	if err := wsjson.Read(ctx, conn, &msg); err != nil {
+		return "", errors.New("expected a join message")
+	}
+	name := strings.TrimSpace(msg.Name)
+	switch {
+	case msg.Type != msgJoin:
+		return "", fmt.Errorf("expected a join message, got %q", msg.Type)
+	case name == "":
+		return "", errors.New("name is required")
+	}
+	return name, checkDisplayName(name)
+}
+
+// writeLoop sends p its queued messages and keeps the connection alive
+// with pings until ctx is done, closing the connection on any failure.
+func (p *roomPlayer) writeLoop(ctx context.Context) {
+	defer p.kick()
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data := <-p.send:
+			wctx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+			err := p.conn.Write(wctx, websocket.MessageText, data)
+			cancel()
+			if err != nil {
+				return
+			}
+		case <-ping.C:
+			pctx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+			err := p.conn.Ping(pctx)
+			cancel()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+// wsPlayer is a test client connected to a multiplayer room.
+type wsPlayer struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+// joinRoom connects to room on s and joins it as name.
+func joinRoom(t *testing.T, s *runningServer, room, name string) *wsPlayer {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws://"+s.addr+"/ws/room/"+room, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	p := &wsPlayer{t: t, conn: conn}
+	p.send(clientMessage{Type: msgJoin, Name: name})
+	return p
+}
+
+func (p *wsPlayer) send(msg clientMessage) {
+	p.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := wsjson.Write(ctx, p.conn, msg); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+// next returns the next message of type typ, skipping others.
+func (p *wsPlayer) next(typ string) serverMessage {
+	p.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		var msg serverMessage
+		if err := wsjson.Read(ctx, p.conn, &msg); err != nil {
+			p.t.Fatalf("waiting for a %s message: %v", typ, err)
+		}
+		if msg.Type == typ {
+			return msg
+		}
+	}
+}
+
+// stateWith returns the next state message listing n players.
+func (p *wsPlayer) stateWith(n int) serverMessage {
+	p.t.Helper()
+	for {
+		if msg := p.next(msgState); len(msg.Players) == n {
+			return msg
+		}
+	}
+}
+
+func TestRoomBroadcastReachesEveryPlayer(t *testing.T) {
+	s := startServer(t)
+	ada := joinRoom(t, s, "crypt", "ada")
+	ada.stateWith(1)
+	grace := joinRoom(t, s, "crypt", "grace")
+
+	for _, p := range []*wsPlayer{ada, grace} {
+		state := p.stateWith(2)
+		if state.Room != "crypt" || len(state.Questions) == 0 {
+			t.Errorf("state %+v lacks the room or its questions", state)
+		}
+	}
+
+	ada.send(clientMessage{Type: msgFinished})
+	for _, p := range []*wsPlayer{ada, grace} {
+		for {
+			state := p.stateWith(2)
+			if finished(state, "ada") {
+				break
+			}
+		}
+	}
+}
+
+// finished reports whether name is listed as finished in state.
+func finished(state serverMessage, name string) bool {
+	for _, ps := range state.Players {
+		if ps.Name == name {
+			return ps.Finished
+		}
+	}
+	return false
+}
+
+func TestRoomRejectsBadMessages(t *testing.T) {
+	s := startServer(t)
+	p := joinRoom(t, s, "crypt", "ada")
+	p.stateWith(1)
+	p.send(clientMessage{Type: "teleport"})
+	if msg := p.next(msgError); !strings.Contains(msg.Error, "unknown message type") {
+		t.Errorf("error %q, want an unknown message type", msg.Error)
+	}
+	p.send(clientMessage{Type: msgJoin, Name: "ada"})
+	if msg := p.next(msgError); msg.Error != "already joined" {
+		t.Errorf("second join: error %q", msg.Error)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws://"+s.addr+"/ws/room/crypt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseNow()
+	wsjson.Write(ctx, conn, clientMessage{Type: msgAnswer})
+	var msg serverMessage
+	if err := wsjson.Read(ctx, conn, &msg); err != nil || msg.Type != msgError {
+		t.Errorf("answer before join: got %+v (%v), want an error", msg, err)
+	}
+}
+
+func TestRoomLeaveAndShutdown(t *testing.T) {
+	s := startServer(t)
+	ada := joinRoom(t, s, "crypt", "ada")
+	ada.stateWith(1)
+	grace := joinRoom(t, s, "crypt", "grace")
+	ada.stateWith(2)
+	grace.conn.Close(websocket.StatusNormalClosure, "")
+	ada.stateWith(1)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.stop(t)
+	}()
+	ada.next(msgClosing)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	for {
+		if _, _, err := ada.conn.Read(ctx); err != nil {
+			if ctx.Err() != nil {
+				t.Fatal("the connection stayed open after shutdown")
+			}
+			if got := websocket.CloseStatus(err); got != websocket.StatusGoingAway {
+				t.Errorf("connection closed with %v, want StatusGoingAway", got)
+			}
+			break
+		}
+	}
+	<-done
+}
+
+// presenceOf fetches /api/presence from s.
+func presenceOf(t *testing.T, s *runningServer) presence {
+	t.Helper()
+	var p presence
+	if code := getJSON(t, s.url("/api/presence"), &p); code != http.StatusOK {
+		t.Fatalf("/api/presence: status %d", code)
+	}
+	return p
+}
+
+// waitForPresence polls presence on s until want holds.
+func waitForPresence(t *testing.T, s *runningServer, what string, want func(presence) bool) {
+	t.Helper()
+	waitFor(t, what, func() bool { return want(presenceOf(t, s)) })
+}
+
+func TestPresenceFollowsConnections(t *testing.T) {
+	s := startServer(t, "-metrics")
+	if p := presenceOf(t, s); p.Connections != 0 || len(p.Rooms) != 0 {
+		t.Fatalf("presence before anyone connects: %+v", p)
+	}
+	ada := joinRoom(t, s, "crypt", "ada")
+	ada.stateWith(1)
+	grace := joinRoom(t, s, "keep", "grace")
+	grace.stateWith(1)
+	waitForPresence(t, s, "counting both players", func(p presence) bool {
+		return p.Connections == 2 && p.Rooms["crypt"] == 1 && p.Rooms["keep"] == 1
+	})
+	if _, scrape := get(t, s.url("/metrics")); !strings.Contains(scrape, "_websocket_connections 2\n") {
+		t.Error("the websocket connections gauge does not read 2")
+	}
+
+	grace.conn.Close(websocket.StatusNormalClosure, "")
+	waitForPresence(t, s, "dropping the closed connection", func(p presence) bool {
+		_, open := p.Rooms["keep"]
+		return p.Connections == 1 && !open
+	})
+}
+
+// stopAsync stops s in the background, closing the returned channel once
+// serve has returned.
+func stopAsync(t *testing.T, s *runningServer) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.stop(t)
+	}()
+	return done
+}
+
+func TestShutdownWaitsForPlayersToLeave(t *testing.T) {
+	s := startServer(t, "-drain-grace", "10s")
+	ada := joinRoom(t, s, "crypt", "ada")
+	ada.stateWith(1)
+
+	start := time.Now()
+	done := stopAsync(t, s)
+	closing := ada.next(msgClosing)
+	if closing.GraceMs <= 0 || closing.GraceMs > 10000 {
+		t.Errorf("closing message grants %dms, want the drain grace", closing.GraceMs)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, resp, err := websocket.Dial(ctx, "ws://"+s.addr+"/ws/room/crypt", nil); err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("joining while draining: %v, want 503", err)
+	}
+	select {
+	case <-done:
+		t.Fatal("shutdown finished with a player still connected")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	ada.conn.Close(websocket.StatusNormalClosure, "")
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not finish once the last player left")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("shutdown took %v, want it to end when the room emptied", elapsed)
+	}
+}
+
+func TestShutdownClosesLingeringPlayers(t *testing.T) {
+	s := startServer(t, "-drain-grace", "30s", "-shutdown-timeout", "2s")
+	ada := joinRoom(t, s, "crypt", "ada")
+	ada.stateWith(1)
+
+	done := stopAsync(t, s)
+	if closing := ada.next(msgClosing); closing.GraceMs > 1000 {
+		t.Errorf("closing message grants %dms, want at most half the 2s shutdown timeout", closing.GraceMs)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var err error
+	for err == nil {
+		_, _, err = ada.conn.Read(ctx)
+	}
+	if status := websocket.CloseStatus(err); status != websocket.StatusGoingAway {
+		t.Errorf("lingering player closed with %v (%v), want going away", status, err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not finish after closing the lingering player")
+	}
+}
+
+// countingConn counts the bytes read from a connection.
+type countingConn struct {
+	net.Conn
+	read *atomic.Int64
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+// dialCounting connects to room on s with the compression mode mode and
+// returns the connection, the extensions the server agreed to, and the
+// count of bytes read off the wire.
+func dialCounting(t *testing.T, s *runningServer, room string, mode websocket.CompressionMode) (*wsPlayer, string, *atomic.Int64) {
+	t.Helper()
+	read := new(atomic.Int64)
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			return countingConn{Conn: conn, read: read}, err
+		},
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, resp, err := websocket.Dial(ctx, "ws://"+s.addr+"/ws/room/"+room, &websocket.DialOptions{HTTPClient: client, CompressionMode: mode})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	return &wsPlayer{t: t, conn: conn}, resp.Header.Get("Sec-WebSocket-Extensions"), read
+}
+
+// stateBytes joins p's room as name and returns the encoded length of
+// the first state message and the bytes read off the wire to receive it.
+func stateBytes(p *wsPlayer, read *atomic.Int64, name string) (encoded, wire int64) {
+	p.t.Helper()
+	before := read.Load()
+	p.send(clientMessage{Type: msgJoin, Name: name})
+	msg := p.stateWith(1)
+	data, _ := json.Marshal(msg)
+	return int64(len(data)), read.Load() - before
+}
+
+func TestWebSocketCompression(t *testing.T) {
+	s := startServer(t, "-ws-compress-min-bytes", "64")
+
+	p, ext, read := dialCounting(t, s, "deflate", websocket.CompressionNoContextTakeover)
+	if !strings.Contains(ext, "permessage-deflate") {
+		t.Fatalf("negotiated extensions %q, want permessage-deflate", ext)
+	}
+	encoded, wire := stateBytes(p, read, "ada")
+	if encoded < 64 || wire >= encoded {
+		t.Errorf("a %d-byte state message took %d bytes on the wire, want fewer", encoded, wire)
+	}
+
+	plain, ext, read := dialCounting(t, s, "plain", websocket.CompressionDisabled)
+	if ext != "" {
+		t.Errorf("a client without compression got the extensions %q", ext)
+	}
+	encoded, wire = stateBytes(plain, read, "bob")
+	if wire < encoded {
+		t.Errorf("an uncompressed %d-byte state message took only %d bytes on the wire", encoded, wire)
+	}
+}
+
+func TestWebSocketCompressionOff(t *testing.T) {
+	s := startServer(t, "-ws-compress=false")
+	p, ext, _ := dialCounting(t, s, "plain", websocket.CompressionNoContextTakeover)
+	if ext != "" {
+		t.Errorf("-ws-compress=false negotiated %q", ext)
+	}
+	p.send(clientMessage{Type: msgJoin, Name: "ada"})
+	p.stateWith(1)
+}
+
+func TestBroadcastEncodedOnce(t *testing.T) {
+	h := newHub(newQuestionBank(nil), 4, 0, nil, nil, nil, nil, nil, nil, 512, 0)
+	room := &gameRoom{id: "r", players: map[*roomPlayer]struct{}{}}
+	var players []*roomPlayer
+	for range 3 {
+		p := &roomPlayer{send: make(chan []byte, 1), kick: func() { t.Error("player kicked") }}
+		room.players[p] = struct{}{}
+		players = append(players, p)
+	}
+	h.broadcast(room)
+	first := <-players[0].send
+	for _, p := range players[1:] {
+		if data := <-p.send; &data[0] != &first[0] {
+			t.Error("players were sent separately encoded copies of one state")
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// nameNamespace holds the claimed display names, keyed by nameKey, and
+	// nameOwnerNamespace the nameKey of the name each owner holds, keyed by
+	// owner.
+	nameNamespace      = "display-names"
+	nameOwnerNamespace = "display-name-owners"
+	// minNameLength is the shortest display name that may be claimed, in
+	// runes; maxNameLength bounds it as it does leaderboard names.
+	minNameLength = 3
+	// maxNameBody bounds a name claim.
+	maxNameBody = 1 << 10
+	// nameSuggestions is how many free names are offered in place of a
+	// taken one.
+	nameSuggestions = 3
+)
+
+// validDisplayName is what a display name may look like: letters and
+// digits, with single spaces, underscores, hyphens, and dots between them.
+var validDisplayName = regexp.MustCompile(`^[\p{L}\p{N}]+(?:[ _.-][\p{L}\p{N}]+)*$`)
+
+var (
+	errNameTaken   = errors.New("name is taken")
+	errNameBlocked = errors.New("name is not allowed")
+)
+
+// displayName is a claimed name and who holds it: a profile, for good, or
+// a session, until it expires.
+type displayName struct {
+	Name      string    `json:"name"`
+	Owner     string    `json:"owner"`
+	ClaimedAt time.Time `json:"claimedAt"`
+	Expires   time.Time `json:"expires"`
+}
+
+// nameRegistry hands out display names, unique regardless of case, to
+// sessions and profiles. Names on the leaderboard lb are reserved for the
+// profiles that put them there, and names with a word filter blocks cannot
+// be claimed at all.
+type nameRegistry struct {
+	mu     sync.Mutex // serializes claims
+	store  Store
+	filter *chatFilter
+	lb     *leaderboard
+}
+
+func newNameRegistry(store Store, filter *chatFilter, lb *leaderboard) *nameRegistry {
+	return &nameRegistry{store: store, filter: filter, lb: lb}
+}
+
+// nameOwner returns the owner key of a profile, if one is signed in, or
+// else of a session.
+func nameOwner(profileID, sessionID string) string {
+	if profileID != "" {
+		return "profile-" + profileID
+	}
+	return "session-" + sessionID
+}
+
+// nameKey returns the store key of a name, the same for every casing.
+func nameKey(name string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(name)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// checkDisplayName reports what is wrong with name as a display name, if
+// anything.
+func checkDisplayName(name string) error {
+	switch n := utf8.RuneCountInString(name); {
+	case n < minNameLength || n > maxNameLength:
+		return fmt.Errorf("name must be between %d and %d characters", minNameLength, maxNameLength)
+	case !validDisplayName.MatchString(name):
+		return errors.New("name may only hold letters and digits, with single spaces, underscores, hyphens, or dots between them")
+	}
+	return nil
+}
+
+// load returns the claim on the name with key, if it has a live one.
+func (n *nameRegistry) load(key string, now time.Time) (displayName, bool, error) {
+	data, err := n.store.Get(nameNamespace, key)
+	if errors.Is(err, ErrNotFound) {
+		return displayName{}, false, nil
+	}
+	if err != nil {
+		return displayName{}, false, err
+	}
+	var d displayName
+	if err := json.Unmarshal(data, &d); err != nil {
+		return displayName{}, false, err
+	}
+	if !d.Expires.IsZero() && now.After(d.Expires) {
+		return displayName{}, false, nil
+	}
+	return d, true, nil
+}
+
+// available reports whether owner, who is signed in to profileID if that
+// is set, may hold name.
+func (n *nameRegistry) available(name, owner, profileID string, now time.Time) (bool, error) {
+	d, ok, err := n.load(nameKey(name), now)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		return d.Owner == owner, nil
+	}
+	folded := strings.ToLower(name)
+	for _, e := range n.lb.Top(maxLeaderboardEntries) {
+		if strings.ToLower(e.Name) == folded && (e.Profile == "" || e.Profile != profileID) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// ownedKey returns the key of the name owner holds, "" if none.
+func (n *nameRegistry) ownedKey(owner string) (string, error) {
+	data, err := n.store.Get(nameOwnerNamespace, owner)
+	if errors.Is(err, ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var key string
+	err = json.Unmarshal(data, &key)
+	return key, err
+}
+
+// Owned returns the name owner holds, "" if none.
+func (n *nameRegistry) Owned(owner string, now time.Time) (string, error) {
+	key, err := n.ownedKey(owner)
+	if err != nil || key == "" {
+		return "", err
+	}
+	d, ok, err := n.load(key, now)
+	if err != nil || !ok || d.Owner != owner {
+		return "", err
+	}
+	return d.Name, nil
+}
+
+// Allowed reports whether owner may use name, which is so unless another
+// holds it.
+func (n *nameRegistry) Allowed(name, owner string, now time.Time) (bool, error) {
+	d, ok, err := n.load(nameKey(name), now)
+	return err == nil && (!ok || d.Owner == owner), err
+}
+
+// Check reports whether owner may go by name where a name is shown
+// without being claimed, on a leaderboard or in a room: it fails with
+// errNameBlocked for a name the filter blocks and errNameTaken for one
+// another holds. The shape of name is checkDisplayName's to judge.
+func (n *nameRegistry) Check(name, owner string, now time.Time) error {
+	if n.filter.Blocks(name) {
+		return errNameBlocked
+	}
+	ok, err := n.Allowed(name, owner, now)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNameTaken
+	}
+	return nil
+}
+
+// Claim gives name to owner, who is signed in to profileID if that is set,
+// releasing the name owner held before. A name that is taken fails with
+// errNameTaken and up to nameSuggestions free variants of it.
+func (n *nameRegistry) Claim(name, owner, profileID string, now time.Time) ([]string, error) {
+	if n.filter.Blocks(name) {
+		return nil, errNameBlocked
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	ok, err := n.available(name, owner, profileID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		suggestions, err := n.suggest(name, owner, profileID, now)
+		if err != nil {
+			return nil, err
+		}
+		return suggestions, errNameTaken
+	}
+	d := displayName{Name: name, Owner: owner, ClaimedAt: now.UTC()}
+	if profileID == "" {
+		d.Expires = now.Add(sessionTTL).UTC()
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	key := nameKey(name)
+	if old, err := n.ownedKey(owner); err == nil && old != "" && old != key {
+		if prev, ok, err := n.load(old, now); err == nil && ok && prev.Owner == owner {
+			n.store.Delete(nameNamespace, old)
+		}
+	}
+	if err := n.store.Set(nameNamespace, key, data); err != nil {
+		return nil, err
+	}
+	ref, _ := json.Marshal(key)
+	return nil, n.store.Set(nameOwnerNamespace, owner, ref)
+}
+
+// suggest returns free names made of name and a number, shortening name
+// where the number would make it too long.
+func (n *nameRegistry) suggest(name, owner, profileID string, now time.Time) ([]string, error) {
+	var suggestions []string
+	for i := 2; i < 1000 && len(suggestions) < nameSuggestions; i++ {
+		suffix := strconv.Itoa(i)
+		base := []rune(name)
+		if len(base)+len(suffix) > maxNameLength {
+			base = base[:maxNameLength-len(suffix)]
+		}
+		candidate := string(base) + suffix
+		if checkDisplayName(candidate) != nil || n.filter.Blocks(candidate) {
+			continue
+		}
+		ok, err := n.available(candidate, owner, profileID, now)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			suggestions = append(suggestions, candidate)
+		}
+	}
+	return suggestions, nil
+}
+
+// collect deletes the claims of sessions that have expired, every
+// interval until ctx is cancelled.
+func (n *nameRegistry) collect(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			keys, err := n.store.List(nameNamespace)
+			if err != nil {
+				slog.Warn("could not list display names", "err", err)
+				continue
+			}
+			n.mu.Lock()
+			for _, key := range keys {
+				data, err := n.store.Get(nameNamespace, key)
+				if err != nil {
+					continue
+				}
+				var d displayName
+				if json.Unmarshal(data, &d) == nil && !d.Expires.IsZero() && now.After(d.Expires) {
+					n.store.Delete(nameNamespace, key)
+					if owned, err := n.ownedKey(d.Owner); err == nil && owned == key {
+						n.store.Delete(nameOwnerNamespace, d.Owner)
+					}
+				}
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+// nameRequest is the body of POST /api/name.
+type nameRequest struct {
+	Name string `json:"name"`
+}
+
+// nameHandler serves GET /api/name?session=S, the display name held by the
+// signed-in profile or else by session S, and POST /api/name?session=S,
+// which claims the name in a nameRequest for them. A name that is taken is
+// 409 Conflict, with free variants of it under details.suggestions.
+func nameHandler(names *nameRegistry, sess *sessions, profiles *accounts) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		sessionID, err := sess.fromQuery(r)
+		if err != nil {
+			writeAPIError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		var profileID string
+		if p, err := profiles.Current(r); err == nil {
+			profileID = p.ID
+		}
+		if profileID == "" && sessionID == "" {
+			writeAPIError(w, http.StatusBadRequest, "session is required")
+			return
+		}
+		owner := nameOwner(profileID, sessionID)
+		now := time.Now()
+		if r.Method != http.MethodPost {
+			name, err := names.Owned(owner, now)
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, "could not load name")
+				return
+			}
+			if name == "" {
+				writeAPIError(w, http.StatusNotFound, "no name claimed")
+				return
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			writeJSON(w, http.StatusOK, nameRequest{Name: name})
+			return
+		}
+
+		var req nameRequest
+		r.Body = http.MaxBytesReader(w, r.Body, maxNameBody)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeBodyError(w, err, "invalid JSON body")
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if err := checkDisplayName(req.Name); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		suggestions, err := names.Claim(req.Name, owner, profileID, now)
+		switch {
+		case errors.Is(err, errNameBlocked):
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, errNameTaken):
+			if suggestions == nil {
+				suggestions = []string{}
+			}
+			writeAPIErrorDetails(w, http.StatusConflict, err.Error(), map[string][]string{"suggestions": suggestions})
+		case err != nil:
+			writeAPIError(w, http.StatusInternalServerError, "could not claim name")
+		default:
+			writeJSON(w, http.StatusCreated, req)
+		}
+	})
+}
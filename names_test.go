@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// claimName claims name for sess on s and returns the status and the
+// error's suggestions, if any.
+func claimName(t *testing.T, s *runningServer, sess sessionResponse, name string) (int, []string) {
+	t.Helper()
+	var body struct {
+		Error struct {
+			Details struct {
+				Suggestions []string `json:"suggestions"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	code := postJSON(t, s.url("/api/name?session="+url.QueryEscape(sess.Token)), nameRequest{Name: name}, &body)
+	return code, body.Error.Details.Suggestions
+}
+
+func TestCheckDisplayName(t *testing.T) {
+	for _, name := range []string{"ada", "Ada Lovelace", "ada_99", "Zoë", "a.b-c"} {
+		if err := checkDisplayName(name); err != nil {
+			t.Errorf("%q: %v", name, err)
+		}
+	}
+	for _, name := range []string{"ad", strings.Repeat("a", maxNameLength+1), "ada  lovelace", " ada", "ada!", "<b>ada</b>", "ada​x"} {
+		if err := checkDisplayName(name); err == nil {
+			t.Errorf("%q was accepted", name)
+		}
+	}
+}
+
+func TestChatFilterBlocks(t *testing.T) {
+	f := &chatFilter{words: map[string]bool{"heck": true}}
+	for text, want := range map[string]bool{
+		"heck":       true,
+		"HECK_fan":   true,
+		"h.e.c.k":    true,
+		"check":      false,
+		"heckle jim": false,
+	} {
+		if got := f.Blocks(text); got != want {
+			t.Errorf("Blocks(%q) = %v, want %v", text, got, want)
+		}
+	}
+	var none *chatFilter
+	if none.Blocks("heck") {
+		t.Error("a nil filter blocked a name")
+	}
+}
+
+func TestNameRegistryClaims(t *testing.T) {
+	store := newMemoryStore()
+	lb, err := openLeaderboard(store, leaderboardKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := newNameRegistry(store, &chatFilter{words: map[string]bool{"heck": true}}, lb)
+	now := time.Now()
+	ada, bob := nameOwner("", "s1"), nameOwner("", "s2")
+
+	if _, err := names.Claim("Ada", ada, "", now); err != nil {
+		t.Fatal(err)
+	}
+	suggestions, err := names.Claim("ADA", bob, "", now)
+	if !errors.Is(err, errNameTaken) || !slices.Equal(suggestions, []string{"ADA2", "ADA3", "ADA4"}) {
+		t.Errorf("a taken name in another case: %v, suggestions %v", err, suggestions)
+	}
+	if ok, _ := names.Allowed("ada", bob, now); ok {
+		t.Error("another player may use a claimed name")
+	}
+	if ok, _ := names.Allowed("ada", ada, now); !ok {
+		t.Error("the holder may not use their own name")
+	}
+	if _, err := names.Claim("Heck Yes", bob, "", now); !errors.Is(err, errNameBlocked) {
+		t.Errorf("a blocked word: %v, want errNameBlocked", err)
+	}
+
+	// Claiming another name releases the first, and session claims lapse.
+	if _, err := names.Claim("Countess", ada, "", now); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := names.Owned(ada, now); got != "Countess" {
+		t.Errorf("Owned = %q, want Countess", got)
+	}
+	if _, err := names.Claim("ada", bob, "", now); err != nil {
+		t.Errorf("a released name: %v", err)
+	}
+	if _, err := names.Claim("Countess", bob, "", now.Add(sessionTTL+time.Minute)); err != nil {
+		t.Errorf("an expired session's name: %v", err)
+	}
+
+	// A profile's leaderboard name is reserved for it.
+	lb.Add(LeaderboardEntry{Name: "Grace", Score: 10, Profile: "p1", SubmittedAt: now})
+	if _, err := names.Claim("grace", nameOwner("", "s3"), "", now); !errors.Is(err, errNameTaken) {
+		t.Errorf("a name on the leaderboard: %v, want errNameTaken", err)
+	}
+	if _, err := names.Claim("Grace", nameOwner("p1", "s4"), "p1", now); err != nil {
+		t.Errorf("a profile claiming its own leaderboard name: %v", err)
+	}
+}
+
+func TestNameEndpoint(t *testing.T) {
+	blocklist := writeConfigFile(t, "names.txt", "# words\nheck\n")
+	s := startServer(t, "-min-run-time", "0s", "-name-blocklist", blocklist)
+	ada, bob := startSession(t, s), startSession(t, s)
+
+	if code, _ := get(t, s.url("/api/name?session="+url.QueryEscape(ada.Token))); code != http.StatusNotFound {
+		t.Errorf("no name claimed yet: status %d, want 404", code)
+	}
+	if code, _ := claimName(t, s, ada, " Ada "); code != http.StatusCreated {
+		t.Fatalf("claiming a name: status %d", code)
+	}
+	var held nameRequest
+	if code := getJSON(t, s.url("/api/name?session="+url.QueryEscape(ada.Token)), &held); code != http.StatusOK || held.Name != "Ada" {
+		t.Errorf("the claimed name: status %d, %+v", code, held)
+	}
+	if code, suggestions := claimName(t, s, bob, "ada"); code != http.StatusConflict || len(suggestions) != nameSuggestions {
+		t.Errorf("a duplicate name: status %d, suggestions %v; want 409 with %d", code, suggestions, nameSuggestions)
+	}
+	if code, _ := claimName(t, s, bob, "heck"); code != http.StatusBadRequest {
+		t.Errorf("a blocked word: status %d, want 400", code)
+	}
+	if code, _ := claimName(t, s, bob, "x"); code != http.StatusBadRequest {
+		t.Errorf("a name too short: status %d, want 400", code)
+	}
+	if code, _ := claimName(t, s, sessionResponse{Token: "forged"}, "forger"); code != http.StatusForbidden {
+		t.Errorf("a forged session: status %d, want 403", code)
+	}
+	if code := postJSON(t, s.url("/api/name"), nameRequest{Name: "nobody"}, nil); code != http.StatusBadRequest {
+		t.Errorf("no session: status %d, want 400", code)
+	}
+
+	if code := submitScore(t, s, bob, "ADA", 0, 1000); code != http.StatusConflict {
+		t.Errorf("a score under another's name: status %d, want 409", code)
+	}
+	if code := submitScore(t, s, bob, "heck", 0, 1000); code != http.StatusBadRequest {
+		t.Errorf("a score under a blocked word: status %d, want 400", code)
+	}
+	if code := submitScore(t, s, bob, "x", 0, 1000); code != http.StatusBadRequest {
+		t.Errorf("a score under a name too short: status %d, want 400", code)
+	}
+	if code := submitScore(t, s, bob, "Bob", 0, 1000); code != http.StatusCreated {
+		t.Errorf("retrying a refused score under a free name: status %d, want 201", code)
+	}
+	if code := submitScore(t, s, ada, "Ada", 0, 1000); code != http.StatusCreated {
+		t.Errorf("a score under the player's own name: status %d, want 201", code)
+	}
+
+	if msg := joinRoom(t, s, "crypt", "ADA").next(msgError); msg.Error != errNameTaken.Error() {
+		t.Errorf("joining a room under another's name: error %q, want %q", msg.Error, errNameTaken)
+	}
+	if msg := joinRoom(t, s, "crypt", "heck").next(msgError); msg.Error != errNameBlocked.Error() {
+		t.Errorf("joining a room under a blocked word: error %q, want %q", msg.Error, errNameBlocked)
+	}
+	joinRoom(t, s, "crypt?session="+url.QueryEscape(ada.Token), "Ada").stateWith(1)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// OutboundTimeouts bound the server's own requests to webhook receivers
+// and OAuth providers, so that a remote that stops answering cannot hold
+// a goroutine for long. A zero value switches its bound off.
+type OutboundTimeouts struct {
+	// Dial bounds connecting, and TLSHandshake the handshake after it.
+	Dial         time.Duration
+	TLSHandshake time.Duration
+	// ResponseHeader bounds the wait for the response headers once the
+	// request is sent.
+	ResponseHeader time.Duration
+	// Request bounds the whole request, reading the body included.
+	Request time.Duration
+}
+
+// Connection pool sizes of the outbound client. Webhook deliveries and
+// OAuth logins go to a handful of hosts, so a few idle connections to each
+// are kept.
+const (
+	outboundMaxIdleConns        = 16
+	outboundMaxIdleConnsPerHost = 4
+	outboundIdleConnTimeout     = 90 * time.Second
+)
+
+// newOutboundClient returns the client for every outbound request, bounded
+// by t and sharing one connection pool. Requests still in flight when ctx
+// is canceled are canceled with it, and idle connections are closed.
+func newOutboundClient(ctx context.Context, t OutboundTimeouts) *http.Client {
+	dialer := &net.Dialer{Timeout: t.Dial, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   t.TLSHandshake,
+		ResponseHeaderTimeout: t.ResponseHeader,
+		ExpectContinueTimeout: time.Second,
+		MaxIdleConns:          outboundMaxIdleConns,
+		MaxIdleConnsPerHost:   outboundMaxIdleConnsPerHost,
+		IdleConnTimeout:       outboundIdleConnTimeout,
+	}
+	context.AfterFunc(ctx, transport.CloseIdleConnections)
+	return &http.Client{Timeout: t.Request, Transport: shutdownTransport{ctx: ctx, next: transport}}
+}
+
+// shutdownTransport cancels each request when ctx is canceled, as well as
+// when the request's own context is. Requests made on behalf of a player,
+// such as an OAuth token exchange, carry the player's request context,
+// which shutdown leaves running while requests drain.
+type shutdownTransport struct {
+	ctx  context.Context
+	next http.RoundTripper
+}
+
+func (t shutdownTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	stop := context.AfterFunc(t.ctx, cancel)
+	done := func() {
+		stop()
+		cancel()
+	}
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		done()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: done}
+	return resp, nil
+}
+
+// cancelOnClose releases a request's context once its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
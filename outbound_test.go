@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hangingServer starts a server whose handler calls respond and then
+// hangs until the test ends.
+func hangingServer(t *testing.T, respond func(http.ResponseWriter)) *httptest.Server {
+	t.Helper()
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respond(w)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+	return srv
+}
+
+// timedGet gets url with client, failing the test if the request does
+// not end within limit, and returns its error.
+func timedGet(t *testing.T, client *http.Client, url string, limit time.Duration) error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		resp, err := client.Get(url)
+		if err == nil {
+			_, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(limit):
+		t.Fatalf("GET %s still hanging after %v", url, limit)
+		return nil
+	}
+}
+
+func TestOutboundClientTimesOutSilentRemote(t *testing.T) {
+	srv := hangingServer(t, func(http.ResponseWriter) {})
+	client := newOutboundClient(context.Background(), OutboundTimeouts{ResponseHeader: 100 * time.Millisecond})
+	start := time.Now()
+	if err := timedGet(t, client, srv.URL, 5*time.Second); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("GET of a silent remote: %v, want a timeout", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("timed out after %v, want about 100ms", d)
+	}
+}
+
+func TestOutboundClientBoundsWholeRequest(t *testing.T) {
+	srv := hangingServer(t, func(w http.ResponseWriter) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+	})
+	client := newOutboundClient(context.Background(), OutboundTimeouts{ResponseHeader: time.Minute, Request: 200 * time.Millisecond})
+	if err := timedGet(t, client, srv.URL, 5*time.Second); err == nil {
+		t.Error("a body that never ends was read to completion")
+	}
+}
+
+func TestOutboundClientCanceledOnShutdown(t *testing.T) {
+	srv := hangingServer(t, func(http.ResponseWriter) {})
+	ctx, cancel := context.WithCancel(context.Background())
+	client := newOutboundClient(ctx, OutboundTimeouts{})
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if err := timedGet(t, client, srv.URL, 5*time.Second); err == nil {
+		t.Error("a request in flight survived shutdown")
+	}
+}
+
+func TestOutboundTimeoutsValidated(t *testing.T) {
+	cfg, err := loadConfig([]string{"-outbound-timeout", "30s", "-outbound-dial-timeout", "2s"}, noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Outbound.Request != 30*time.Second || cfg.Outbound.Dial != 2*time.Second || cfg.Outbound.ResponseHeader != 10*time.Second {
+		t.Errorf("outbound timeouts %+v", cfg.Outbound)
+	}
+	if _, err := loadConfig([]string{"-outbound-response-timeout", "-1s"}, noEnv); err == nil || !strings.Contains(err.Error(), "outbound timeouts") {
+		t.Errorf("negative timeout: %v", err)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// pprofPrefix is where the runtime profiles are served when enabled.
+const pprofPrefix = "/debug/pprof/"
+
+// handlePprof mounts the net/http/pprof handlers under pprofPrefix on mux,
+// each behind auth. They are left out of rate limiting and compression:
+// profiles arrive gzipped already, and a CPU profile or trace holds its
+// request open for as long as it records.
+func handlePprof(mux *http.ServeMux, auth func(http.Handler) http.Handler) {
+	mux.Handle(pprofPrefix, auth(http.HandlerFunc(pprof.Index)))
+	mux.Handle(pprofPrefix+"cmdline", auth(http.HandlerFunc(pprof.Cmdline)))
+	mux.Handle(pprofPrefix+"profile", auth(http.HandlerFunc(pprof.Profile)))
+	mux.Handle(pprofPrefix+"symbol", auth(http.HandlerFunc(pprof.Symbol)))
+	mux.Handle(pprofPrefix+"trace", auth(http.HandlerFunc(pprof.Trace)))
+}
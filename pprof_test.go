@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// adminGet fetches path from s with the admin credentials and returns the
+// status code and body.
+func adminGet(t *testing.T, s *runningServer, path string) (int, string) {
+	t.Helper()
+	code, body := adminDo(t, s, http.MethodGet, path, nil)
+	return code, string(body)
+}
+
+// loggedPaths returns the paths of the access-log records in logs.
+func loggedPaths(logs *logRecorder) []string {
+	logs.mu.Lock()
+	defer logs.mu.Unlock()
+	var paths []string
+	for _, r := range logs.records {
+		if r.Message != "request" {
+			continue
+		}
+		r.Attrs(func(a slog.Attr) bool {
+			if a.Key == "path" {
+				paths = append(paths, a.Value.String())
+			}
+			return true
+		})
+	}
+	return paths
+}
+
+func TestPprofOptIn(t *testing.T) {
+	s := startServer(t, "-admin-password", testAdminPassword)
+	if code, _ := adminGet(t, s, pprofPrefix); code != http.StatusNotFound {
+		t.Errorf("%s without -pprof: status %d, want 404", pprofPrefix, code)
+	}
+	if _, err := loadConfig([]string{"-pprof"}, noEnv); err == nil || !strings.Contains(err.Error(), "pprof requires admin-password") {
+		t.Errorf("-pprof without an admin password: %v", err)
+	}
+}
+
+func TestPprofServedToAdmins(t *testing.T) {
+	s := startServer(t, "-pprof", "-admin-password", testAdminPassword, "-rate-limit", "1", "-rate-burst", "1")
+	if code, _ := get(t, s.url(pprofPrefix)); code != http.StatusUnauthorized {
+		t.Errorf("%s without credentials: status %d, want 401", pprofPrefix, code)
+	}
+	if code := adminRequest(t, s, http.MethodGet, pprofPrefix, "admin", "wrong password", nil); code != http.StatusUnauthorized {
+		t.Errorf("%s with a wrong password: status %d, want 401", pprofPrefix, code)
+	}
+	// Profiles are exempt from the rate limit of one request a second.
+	for range 3 {
+		code, body := adminGet(t, s, pprofPrefix)
+		if code != http.StatusOK || !strings.Contains(body, "goroutine") || !strings.Contains(body, "heap") {
+			t.Fatalf("%s: status %d, want the profile index", pprofPrefix, code)
+		}
+	}
+	if code, body := adminGet(t, s, pprofPrefix+"goroutine?debug=1"); code != http.StatusOK || !strings.Contains(body, "goroutine profile") {
+		t.Errorf("goroutine profile: status %d", code)
+	}
+	if code, body := adminGet(t, s, pprofPrefix+"cmdline"); code != http.StatusOK || body == "" {
+		t.Errorf("cmdline: status %d", code)
+	}
+
+	// Once a later request on the same connection is logged, every
+	// earlier one would have been.
+	get(t, s.url("/css/game.css"))
+	waitFor(t, "the stylesheet request to be logged", func() bool {
+		for _, p := range loggedPaths(s.logs) {
+			if p == "/css/game.css" {
+				return true
+			}
+		}
+		return false
+	})
+	for _, p := range loggedPaths(s.logs) {
+		if strings.HasPrefix(p, pprofPrefix) {
+			t.Errorf("profile request %s was access-logged", p)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxQuestionImportBody bounds a CSV upload to /api/admin/questions/import.
+	maxQuestionImportBody = 1 << 20
+	// maxQuestionImportRows caps the questions in a single import.
+	maxQuestionImportRows = 1000
+	// importAnswerSeparator separates the answers within the answers cell.
+	importAnswerSeparator = "|"
+)
+
+// importColumns are the columns an import may have, named after the
+// Question JSON fields; importRequired must all be present.
+var (
+	importColumns  = []string{"id", "category", "difficulty", "question", "answers", "correctAnswer", "points", "timeLimit", "explanation", "hint"}
+	importRequired = []string{"category", "difficulty", "question", "answers", "correctAnswer"}
+)
+
+// importDefaults are the points and time limit of an imported question
+// that leaves them out, by difficulty, matching the embedded questions.
+var importDefaults = map[string]struct{ points, timeLimit int }{
+	"easy":   {50, 30},
+	"medium": {75, 25},
+	"hard":   {100, 20},
+}
+
+// importRow is a parsed CSV row: the question it describes, or what is
+// wrong with it. Line is the row's line number in the file.
+type importRow struct {
+	Line     int
+	Question Question
+	Problems []string
+}
+
+// importFailure reports why one row was not imported.
+type importFailure struct {
+	Line     int      `json:"line"`
+	ID       string   `json:"id,omitempty"`
+	Problems []string `json:"problems"`
+}
+
+// importSummary is the response to an import.
+type importSummary struct {
+	Rows     int             `json:"rows"`
+	Imported []string        `json:"imported"`
+	Failed   []importFailure `json:"failed"`
+}
+
+// parseImport reads the questions from a CSV file whose first row names
+// its columns. A file that is not valid CSV, lacks a required column, or
+// has too many rows is an error; problems confined to a row are reported
+// in that row.
+func parseImport(r io.Reader) ([]importRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	col := make(map[string]int, len(header))
+	for i, name := range header {
+		known := ""
+		for _, c := range importColumns {
+			if strings.EqualFold(strings.TrimSpace(name), c) {
+				known = c
+			}
+		}
+		switch _, dup := col[known]; {
+		case known == "":
+			return nil, fmt.Errorf("unknown column %q", name)
+		case dup:
+			return nil, fmt.Errorf("duplicate column %q", name)
+		}
+		col[known] = i
+	}
+	for _, c := range importRequired {
+		if _, ok := col[c]; !ok {
+			return nil, fmt.Errorf("missing column %q", c)
+		}
+	}
+
+	var rows []importRow
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == maxQuestionImportRows {
+			return nil, fmt.Errorf("at most %d rows may be imported at once", maxQuestionImportRows)
+		}
+		line, _ := cr.FieldPos(0)
+		rows = append(rows, parseImportRow(line, record, col, len(header)))
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("file has no rows")
+	}
+	return rows, nil
+}
+
+// parseImportRow builds the question in record, whose cells are laid out
+// as col says.
+func parseImportRow(line int, record []string, col map[string]int, width int) importRow {
+	row := importRow{Line: line}
+	if len(record) != width {
+		row.Problems = append(row.Problems, fmt.Sprintf("has %d cells, the header has %d", len(record), width))
+		return row
+	}
+	cell := func(name string) string {
+		if i, ok := col[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	number := func(name string, def int) int {
+		v := cell(name)
+		if v == "" {
+			return def
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			row.Problems = append(row.Problems, fmt.Sprintf("%s %q is not a whole number", name, v))
+		}
+		return n
+	}
+
+	q := Question{
+		ID:          cell("id"),
+		Category:    cell("category"),
+		Difficulty:  strings.ToLower(cell("difficulty")),
+		Question:    cell("question"),
+		Explanation: cell("explanation"),
+		Hint:        cell("hint"),
+	}
+	for _, a := range strings.Split(cell("answers"), importAnswerSeparator) {
+		q.Answers = append(q.Answers, strings.TrimSpace(a))
+	}
+	defaults := importDefaults[q.Difficulty]
+	q.CorrectAnswer = number("correctAnswer", -1)
+	q.Points = number("points", defaults.points)
+	q.TimeLimit = number("timeLimit", defaults.timeLimit)
+	if q.ID == "" {
+		sum := sha256.Sum256([]byte(q.Question))
+		q.ID = "q-" + hex.EncodeToString(sum[:5])
+	}
+	if !validStoreName.MatchString(q.ID) {
+		row.Problems = append(row.Problems, "id must be 1-128 letters, digits, '-' or '_'")
+	}
+	row.Question = q
+	return row
+}
+
+// adminImportHandler serves POST /api/admin/questions/import, creating a
+// question from each row of a CSV body. Rows that fail to parse or
+// validate, or whose ID is taken, are skipped and reported; the rest are
+// imported.
+func adminImportHandler(overlay *questionOverlay) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxQuestionImportBody)
+		rows, err := parseImport(r.Body)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeAPIError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("import must be at most %d bytes", tooLarge.Limit))
+				return
+			}
+			writeAPIError(w, http.StatusBadRequest, "invalid CSV: "+err.Error())
+			return
+		}
+
+		summary := importSummary{Rows: len(rows), Imported: []string{}, Failed: []importFailure{}}
+		for _, row := range rows {
+			problems := row.Problems
+			if len(problems) == 0 {
+				found, err := overlay.Create(row.Question)
+				switch {
+				case errors.Is(err, errQuestionExists):
+					problems = append(problems, err.Error())
+				case err != nil:
+					writeAPIErrorDetails(w, http.StatusInternalServerError, "could not save question", summary)
+					return
+				}
+				for _, p := range found {
+					problems = append(problems, p.Message)
+				}
+			}
+			if len(problems) > 0 {
+				summary.Failed = append(summary.Failed, importFailure{Line: row.Line, ID: row.Question.ID, Problems: problems})
+				continue
+			}
+			summary.Imported = append(summary.Imported, row.Question.ID)
+		}
+		writeJSON(w, http.StatusOK, summary)
+	})
+}
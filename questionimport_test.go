@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// importCSV uploads doc to the question import endpoint and returns the
+// status code and summary.
+func importCSV(t *testing.T, s *runningServer, doc string) (int, importSummary) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, s.url("/api/admin/questions/import"), strings.NewReader(doc))
+	req.Header.Set("Content-Type", "text/csv")
+	req.SetBasicAuth("admin", testAdminPassword)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var summary importSummary
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, summary
+}
+
+func TestQuestionImport(t *testing.T) {
+	s := startServer(t, "-admin-password", testAdminPassword)
+	code, summary := importCSV(t, s, `id,category,difficulty,question,answers,correctAnswer,explanation
+q900,history,easy,"When did the Norman conquest begin?",1066|1215|1492,0,"Hastings, 1066."
+q901,science,Hard,What is the chemical symbol for gold?, Au | Ag | Fe ,0,
+`)
+	if code != http.StatusOK {
+		t.Fatalf("import: status %d", code)
+	}
+	if summary.Rows != 2 || !slices.Equal(summary.Imported, []string{"q900", "q901"}) || len(summary.Failed) != 0 {
+		t.Fatalf("summary %+v, want both rows imported", summary)
+	}
+	ids := questionIDs(t, s, "count=50")
+	if !slices.Contains(ids, "q900") || !slices.Contains(ids, "q901") {
+		t.Errorf("imported questions not served: %v", ids)
+	}
+	var hard struct {
+		Questions []struct {
+			ID      string   `json:"id"`
+			Answers []string `json:"answers"`
+			Points  int      `json:"points"`
+		} `json:"questions"`
+	}
+	getJSON(t, s.url("/api/questions?count=50&difficulty=hard&category=science"), &hard)
+	for _, q := range hard.Questions {
+		if q.ID == "q901" && (!slices.Equal(q.Answers, []string{"Au", "Ag", "Fe"}) || q.Points != importDefaults["hard"].points) {
+			t.Errorf("q901 imported as %+v", q)
+		}
+	}
+}
+
+func TestQuestionImportReportsBadRows(t *testing.T) {
+	s := startServer(t, "-admin-password", testAdminPassword)
+	questions, _ := loadQuestions(staticFS, questionsFile)
+	code, summary := importCSV(t, s, `id,category,difficulty,question,answers,correctAnswer
+q900,history,easy,A good question?,yes|no,0
+q901,history,brutal,An unknown difficulty?,yes|no,0
+q902,history,easy,An index past the answers?,yes|no,5
+q903,history,easy,Not a number?,yes|no,first
+q904,history,easy,Too few cells
+`+questions[0].ID+`,history,easy,A taken ID?,yes|no,0
+,history,medium,No ID given?,yes|no,1
+`)
+	if code != http.StatusOK {
+		t.Fatalf("import: status %d", code)
+	}
+	if summary.Rows != 7 || len(summary.Imported) != 2 || summary.Imported[0] != "q900" || !strings.HasPrefix(summary.Imported[1], "q-") {
+		t.Errorf("imported %v of %d rows, want q900 and a generated ID", summary.Imported, summary.Rows)
+	}
+	lines := map[int]string{}
+	for _, f := range summary.Failed {
+		if len(f.Problems) == 0 {
+			t.Errorf("line %d failed without a problem", f.Line)
+		}
+		lines[f.Line] = strings.Join(f.Problems, "; ")
+	}
+	for line, want := range map[int]string{3: "difficulty", 4: "correctAnswer", 5: "whole number", 6: "cells", 7: "exists"} {
+		if !strings.Contains(lines[line], want) {
+			t.Errorf("line %d: problems %q, want one mentioning %q", line, lines[line], want)
+		}
+	}
+	if len(summary.Failed) != 5 {
+		t.Errorf("%d rows failed, want 5: %+v", len(summary.Failed), summary.Failed)
+	}
+}
+
+func TestQuestionImportRejectsMalformedFiles(t *testing.T) {
+	s := startServer(t, "-admin-password", testAdminPassword)
+	for name, doc := range map[string]string{
+		"empty":          "",
+		"header only":    "category,difficulty,question,answers,correctAnswer\n",
+		"missing column": "category,difficulty,question,answers\nhistory,easy,When?,a|b\n",
+		"unknown column": "category,difficulty,question,answers,correctAnswer,colour\n",
+		"bare quote":     "category,difficulty,question,answers,correctAnswer\nhistory,easy,\"When?,a|b,0\n",
+		"too many rows":  "category,difficulty,question,answers,correctAnswer\n" + strings.Repeat("history,easy,When?,a|b,0\n", maxQuestionImportRows+1),
+	} {
+		if code, _ := importCSV(t, s, doc); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, code)
+		}
+	}
+	if code, _ := importCSV(t, s, strings.Repeat("x", maxQuestionImportBody+1)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversize upload: status %d, want 413", code)
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// questionsFile is the embedded question bank. Translations sit beside it
+// as data/questions.<lang>.json.
+const questionsFile = "data/questions.json"
+
+const (
+	// defaultQuestionCount is how many questions /api/questions returns
+	// when no count is given.
+	defaultQuestionCount = 10
+	// maxQuestionCount caps a single /api/questions request.
+	maxQuestionCount = 50
+)
+
+// difficulties lists the difficulty levels a question may declare.
+var difficulties = map[string]bool{"easy": true, "medium": true, "hard": true}
+
+// Question is one quiz question as stored in data/questions.json. Hint is
+// an optional clue /api/hint gives out once the wrong answers it may rule
+// out are gone.
+type Question struct {
+	ID            string   `json:"id"`
+	Category      string   `json:"category"`
+	Difficulty    string   `json:"difficulty"`
+	Question      string   `json:"question"`
+	Answers       []string `json:"answers"`
+	CorrectAnswer int      `json:"correctAnswer"`
+	Points        int      `json:"points"`
+	TimeLimit     int      `json:"timeLimit"`
+	Explanation   string   `json:"explanation"`
+	Hint          string   `json:"hint,omitempty"`
+	// Image names a file in questionMediaDir shown with the question.
+	Image string `json:"image,omitempty"`
+	// Weight scales how likely the question is to be drawn; zero counts
+	// as 1. See SelectionWeights.
+	Weight float64 `json:"weight,omitempty"`
+}
+
+// PublicQuestion is the view of a Question sent to players: it leaves out
+// the answer key and explanation, which only /api/answer reveals.
+type PublicQuestion struct {
+	ID         string   `json:"id"`
+	Category   string   `json:"category"`
+	Difficulty string   `json:"difficulty"`
+	Question   string   `json:"question"`
+	Answers    []string `json:"answers"`
+	Points     int      `json:"points"`
+	TimeLimit  int      `json:"timeLimit"`
+	// Image is the URL of the question's image relative to the base path,
+	// if it has one.
+	Image string `json:"image,omitempty"`
+}
+
+// Public returns the player-facing view of q.
+func (q *Question) Public() PublicQuestion {
+	p := PublicQuestion{
+		ID:         q.ID,
+		Category:   q.Category,
+		Difficulty: q.Difficulty,
+		Question:   q.Question,
+		Answers:    q.Answers,
+		Points:     q.Points,
+		TimeLimit:  q.TimeLimit,
+	}
+	if q.Image != "" {
+		p.Image = questionMediaURL(q.Image)
+	}
+	return p
+}
+
+// questionBank indexes the loaded questions by ID. The question set may be
+// replaced while the bank is in use; slices it hands out are never
+// modified afterwards.
+type questionBank struct {
+	mu           sync.RWMutex
+	questions    []Question
+	byID         map[string]*Question
+	byCategory   map[string][]int
+	byDifficulty map[string][]int
+	// hash is the contentHash of the question set.
+	hash string
+	// replaced are called after each Replace.
+	replaced []func()
+}
+
+func newQuestionBank(questions []Question) *questionBank {
+	b := &questionBank{}
+	b.Replace(questions)
+	return b
+}
+
+// Replace swaps in a new set of questions, rebuilding the indexes.
+func (b *questionBank) Replace(questions []Question) {
+	byID := make(map[string]*Question, len(questions))
+	byCategory := make(map[string][]int)
+	byDifficulty := make(map[string][]int)
+	for i := range questions {
+		q := &questions[i]
+		byID[q.ID] = q
+		byCategory[q.Category] = append(byCategory[q.Category], i)
+		byDifficulty[q.Difficulty] = append(byDifficulty[q.Difficulty], i)
+	}
+	// Questions come from JSON, so they encode.
+	data, _ := json.Marshal(questions)
+	b.mu.Lock()
+	b.questions, b.byID = questions, byID
+	b.byCategory, b.byDifficulty = byCategory, byDifficulty
+	b.hash = contentHash(data)
+	replaced := b.replaced
+	b.mu.Unlock()
+	for _, fn := range replaced {
+		fn()
+	}
+}
+
+// OnReplace arranges for fn to be called after each later Replace, once
+// the new questions are in place.
+func (b *questionBank) OnReplace(fn func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.replaced = append(b.replaced, fn)
+}
+
+// Hash returns a hash of the question set, which changes whenever it is
+// replaced with different questions.
+func (b *questionBank) Hash() string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.hash
+}
+
+// HasCategory reports whether any question is in category.
+func (b *questionBank) HasCategory(category string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.byCategory[category]) > 0
+}
+
+// Filter returns the questions in any of categories and at any of the
+// difficulty levels. An empty list does not filter on that field.
+func (b *questionBank) Filter(categories, levels []string) []Question {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	match := func(index map[string][]int, values []string) map[int]bool {
+		if len(values) == 0 {
+			return nil
+		}
+		set := make(map[int]bool)
+		for _, v := range values {
+			for _, i := range index[v] {
+				set[i] = true
+			}
+		}
+		return set
+	}
+	inCategory := match(b.byCategory, categories)
+	atLevel := match(b.byDifficulty, levels)
+	var out []Question
+	for i, q := range b.questions {
+		if (inCategory == nil || inCategory[i]) && (atLevel == nil || atLevel[i]) {
+			out = append(out, q)
+		}
+	}
+	return out
+}
+
+// categoryCount is one entry of /api/categories.
+type categoryCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// Categories lists every category with its number of questions, by name.
+func (b *questionBank) Categories() []categoryCount {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	out := make([]categoryCount, 0, len(b.byCategory))
+	for name, ids := range b.byCategory {
+		out = append(out, categoryCount{Name: name, Count: len(ids)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// All returns every question. The caller must not modify the result.
+func (b *questionBank) All() []Question {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.questions
+}
+
+// loadQuestionBank loads the questions in the file name from fsys into a
+// questionBank.
+func loadQuestionBank(fsys fs.FS, name string) (*questionBank, error) {
+	questions, err := loadQuestions(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return newQuestionBank(questions), nil
+}
+
+// Get returns the question with the given ID.
+func (b *questionBank) Get(id string) (*Question, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	q, ok := b.byID[id]
+	return q, ok
+}
+
+// loadQuestions parses the question bank in the file name from fsys.
+func loadQuestions(fsys fs.FS, name string) ([]Question, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Questions []Question `json:"questions"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return doc.Questions, nil
+}
+
+// seededOrder sorts pool into the order of seed: by the SHA-256 of the seed
+// in decimal, a colon, and the question's ID, lowest digest first. Where a
+// question falls depends only on the seed and its own ID, not on the
+// server's state, the Go release, or what else is in the pool, so adding
+// or hiding a question leaves the rest of a seeded set in order.
+func seededOrder(pool []PublicQuestion, seed uint64) {
+	prefix := strconv.FormatUint(seed, 10) + ":"
+	keys := make(map[string][sha256.Size]byte, len(pool))
+	for _, q := range pool {
+		keys[q.ID] = sha256.Sum256([]byte(prefix + q.ID))
+	}
+	sort.SliceStable(pool, func(i, j int) bool {
+		a, b := keys[pool[i].ID], keys[pool[j].ID]
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+}
+
+// seededQuery returns the query of a shareable URL for the seeded set
+// query asked for: the seed, count, and filters in a canonical form,
+// without the player and session tokens, which are not for sharing. The
+// count is spelled out so that the link keeps its length should the
+// default change.
+func seededQuery(query url.Values, seed uint64, count int, categories, levels []string) string {
+	v := url.Values{}
+	v.Set("seed", strconv.FormatUint(seed, 10))
+	v.Set("count", strconv.Itoa(count))
+	if lang := query.Get("lang"); lang != "" {
+		v.Set("lang", lang)
+	}
+	if len(categories) > 0 {
+		v.Set("category", strings.Join(slices.Sorted(slices.Values(categories)), ","))
+	}
+	if len(levels) > 0 {
+		v.Set("difficulty", strings.Join(slices.Sorted(slices.Values(levels)), ","))
+	}
+	return v.Encode()
+}
+
+// questionsHandler serves GET /api/questions?count=N&difficulty=X&category=C&seed=S&token=T,
+// returning a random subset of questions without their answers, drawn by
+// the weights of sel. difficulty
+// and category each take a comma-separated list: a question must match one
+// of the listed values of every parameter given. Supplying seed serves the
+// first questions in seededOrder instead, the same for every caller, and
+// the response carries the seed and a URL to share the set by. With a
+// player token and no seed, questions the player has not answered yet are
+// picked first, and once none are left the least recently answered ones.
+// With a session token, each question's answers are shuffled into an order
+// fixed for that session, which /api/answer expects choiceIndex to refer
+// to, and the questions returned are added to the session's replay and
+// recorded in scores as served, which starts their time limits. Unless
+// difficulty or seed is given, a session's draw also favors questions
+// matching its recent accuracy, and the response reports the difficulty
+// aimed for.
+// Questions are in the negotiated language where a translation exists.
+// Questions mod hides are never served. Requests without either token
+// carry an ETag over the questions that may be served and the parameters,
+// and are answered 304 Not Modified when the client's copy is current;
+// without seed, that copy is an earlier pick from the same questions. A
+// session is served the wording of its bucket of any experiment in exps.
+func questionsHandler(banks map[string]*questionBank, langs *languageRegistry, history *histories, perf *performances, rp *replays, sess *sessions, scores *scorekeeper, mod *moderation, exps *experiments, sel *selector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		bank := localize(w, r, langs, banks)
+		query := r.URL.Query()
+		// Without a player or session the response depends only on the
+		// question set and the parameters, so it can be revalidated.
+		if query.Get("token") == "" && query.Get("session") == "" {
+			tag := etag(contentHash([]byte(bank.Hash() + "\x00" + mod.Hash() + "\x00" + w.Header().Get("Content-Language") + "\x00" + query.Encode())))
+			w.Header().Set("Cache-Control", "no-cache")
+			if notModified(w, r, tag) {
+				return
+			}
+		}
+
+		count := defaultQuestionCount
+		if v := query.Get("count"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxQuestionCount {
+				writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("count must be between 1 and %d", maxQuestionCount))
+				return
+			}
+			count = n
+		}
+
+		levels := splitList(query.Get("difficulty"))
+		for _, d := range levels {
+			if !difficulties[d] {
+				writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("unknown difficulty %q", d))
+				return
+			}
+		}
+		categories := splitList(query.Get("category"))
+		for _, c := range categories {
+			if !bank.HasCategory(c) {
+				writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("unknown category %q", c))
+				return
+			}
+		}
+
+		var seed uint64
+		seeded := query.Get("seed") != ""
+		if seeded {
+			n, err := strconv.ParseUint(query.Get("seed"), 10, 64)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, "seed must be a non-negative integer")
+				return
+			}
+			seed = n
+		}
+
+		sessionID, err := sess.fromQuery(r)
+		if err != nil {
+			writeAPIError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
+		var hist questionHistory
+		if token := query.Get("token"); token != "" {
+			if !validToken.MatchString(token) {
+				writeAPIError(w, http.StatusBadRequest, "invalid token")
+				return
+			}
+			if hist, err = history.Load(token); err != nil {
+				writeAPIError(w, http.StatusInternalServerError, "could not load question history")
+				return
+			}
+		}
+
+		_, span := tracer.Start(r.Context(), "selectQuestions")
+		var pool []PublicQuestion
+		questions := mod.Visible(bank.Filter(categories, levels))
+		for i := range questions {
+			q := exps.Apply(bank, questions[i], sessionID)
+			pool = append(pool, sess.shuffleAnswers(q.Public(), sessionID))
+		}
+		resp := map[string]any{}
+		now := time.Now()
+		if seeded {
+			seededOrder(pool, seed)
+			resp["seed"] = seed
+			resp["url"] = requestBasePath(r) + r.URL.Path + "?" + seededQuery(query, seed, count, categories, levels)
+		} else {
+			var target string
+			if sessionID != "" && len(levels) == 0 {
+				p, err := perf.Load(sessionID)
+				if err != nil {
+					span.End()
+					writeAPIError(w, http.StatusInternalServerError, "could not load session performance")
+					return
+				}
+				target = p.Difficulty()
+				resp["difficulty"] = target
+			}
+			sel.Order(pool, questions, target, now)
+		}
+		if !seeded && hist != nil {
+			preferUnseen(pool, hist)
+		}
+		span.SetAttributes(attribute.Int("questions.matched", len(questions)))
+		if len(pool) > count {
+			pool = pool[:count]
+		}
+		span.End()
+		sel.Served(pool, now)
+		if sessionID != "" {
+			ids := make([]string, len(pool))
+			for i, q := range pool {
+				ids[i] = q.ID
+				rp.record(sessionID, replayEvent{Type: replayQuestion, Question: q.ID}, now)
+			}
+			if err := scores.Serve(sessionID, ids, now); err != nil {
+				writeAPIError(w, http.StatusInternalServerError, "could not record the questions served")
+				return
+			}
+		}
+		resp["questions"] = pool
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+// categoriesHandler serves GET /api/categories, listing the categories in
+// the negotiated language's question bank with their question counts.
+func categoriesHandler(banks map[string]*questionBank, langs *languageRegistry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		bank := localize(w, r, langs, banks)
+		writeJSON(w, http.StatusOK, map[string]any{"categories": bank.Categories()})
+	})
+}
+
+// splitList splits a comma-separated query value, dropping empty items.
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadEmbeddedQuestions(t *testing.T) {
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(questions) == 0 {
+		t.Fatal("no embedded questions")
+	}
+	for _, q := range questions {
+		if q.ID == "" || !difficulties[q.Difficulty] {
+			t.Errorf("question %+v lacks an ID or a known difficulty", q)
+		}
+	}
+}
+
+// servedQuestion is the part of a question served by /api/questions the
+// tests look at.
+type servedQuestion struct {
+	ID         string `json:"id"`
+	Difficulty string `json:"difficulty"`
+}
+
+// questionIDs returns the IDs of the questions /api/questions answered
+// query with.
+func questionIDs(t *testing.T, s *runningServer, query string) []string {
+	t.Helper()
+	var body struct {
+		Questions []servedQuestion `json:"questions"`
+	}
+	if code := getJSON(t, s.url("/api/questions?"+query), &body); code != http.StatusOK {
+		t.Fatalf("/api/questions?%s: status %d", query, code)
+	}
+	ids := make([]string, len(body.Questions))
+	for i, q := range body.Questions {
+		ids[i] = q.ID
+	}
+	return ids
+}
+
+func TestQuestionsCountAndDifficulty(t *testing.T) {
+	s := startServer(t)
+	if ids := questionIDs(t, s, "count=3"); len(ids) != 3 {
+		t.Errorf("count=3 gave %d questions", len(ids))
+	}
+	var body struct {
+		Questions []servedQuestion `json:"questions"`
+	}
+	getJSON(t, s.url("/api/questions?count=5&difficulty=hard"), &body)
+	if len(body.Questions) == 0 {
+		t.Fatal("difficulty=hard gave no questions")
+	}
+	for _, q := range body.Questions {
+		if q.Difficulty != "hard" {
+			t.Errorf("difficulty=hard gave %s question %s", q.Difficulty, q.ID)
+		}
+	}
+}
+
+func TestQuestionsSeedIsDeterministic(t *testing.T) {
+	s := startServer(t)
+	first := questionIDs(t, s, "count=5&seed=42")
+	if again := questionIDs(t, s, "count=5&seed=42"); !reflect.DeepEqual(first, again) {
+		t.Errorf("seed=42 gave %v, then %v", first, again)
+	}
+	if other := questionIDs(t, s, "count=5&seed=43"); reflect.DeepEqual(first, other) {
+		t.Errorf("seeds 42 and 43 both gave %v", first)
+	}
+}
+
+func TestQuestionsRejectsBadParameters(t *testing.T) {
+	s := startServer(t)
+	for _, query := range []string{"count=0", "count=-1", "count=100000", "count=many", "difficulty=impossible"} {
+		if code := getJSON(t, s.url("/api/questions?"+query), nil); code != http.StatusBadRequest {
+			t.Errorf("/api/questions?%s: status %d, want 400", query, code)
+		}
+	}
+}
+
+func TestQuestionsMultiValueFilters(t *testing.T) {
+	all, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := startServer(t)
+	tests := []struct {
+		query      string
+		categories []string
+		levels     []string
+	}{
+		{"category=history", []string{"history"}, nil},
+		{"category=history,science", []string{"history", "science"}, nil},
+		{"difficulty=easy,medium&category=history", []string{"history"}, []string{"easy", "medium"}},
+		{"difficulty=hard&category=science,geography", []string{"science", "geography"}, []string{"hard"}},
+	}
+	for _, tt := range tests {
+		want := map[string]bool{}
+		for _, q := range all {
+			if slices.Contains(tt.categories, q.Category) && (tt.levels == nil || slices.Contains(tt.levels, q.Difficulty)) {
+				want[q.ID] = true
+			}
+		}
+		var body struct {
+			Questions []struct {
+				ID         string `json:"id"`
+				Category   string `json:"category"`
+				Difficulty string `json:"difficulty"`
+			} `json:"questions"`
+		}
+		if code := getJSON(t, s.url("/api/questions?count=50&"+tt.query), &body); code != http.StatusOK {
+			t.Fatalf("%s: status %d", tt.query, code)
+		}
+		if len(body.Questions) != len(want) {
+			t.Errorf("%s: %d questions, want %d", tt.query, len(body.Questions), len(want))
+		}
+		for _, q := range body.Questions {
+			if !want[q.ID] {
+				t.Errorf("%s: served %s question %s in %s", tt.query, q.Difficulty, q.ID, q.Category)
+			}
+		}
+	}
+}
+
+func TestQuestionsUnknownFilterValues(t *testing.T) {
+	s := startServer(t)
+	for _, query := range []string{"category=alchemy", "category=history,alchemy", "difficulty=easy,brutal"} {
+		if code := getJSON(t, s.url("/api/questions?"+query), nil); code != http.StatusBadRequest {
+			t.Errorf("/api/questions?%s: status %d, want 400", query, code)
+		}
+	}
+}
+
+func TestCategoriesListing(t *testing.T) {
+	all, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{}
+	for _, q := range all {
+		want[q.Category]++
+	}
+	s := startServer(t)
+	var body struct {
+		Categories []struct {
+			Name  string `json:"name"`
+			Count int    `json:"count"`
+		} `json:"categories"`
+	}
+	if code := getJSON(t, s.url("/api/categories"), &body); code != http.StatusOK {
+		t.Fatalf("/api/categories: status %d", code)
+	}
+	if len(body.Categories) != len(want) {
+		t.Errorf("%d categories listed, want %d", len(body.Categories), len(want))
+	}
+	for i, c := range body.Categories {
+		if c.Count != want[c.Name] {
+			t.Errorf("category %s: count %d, want %d", c.Name, c.Count, want[c.Name])
+		}
+		if i > 0 && body.Categories[i-1].Name >= c.Name {
+			t.Errorf("categories not sorted: %s before %s", body.Categories[i-1].Name, c.Name)
+		}
+	}
+}
+
+// conditionalGet fetches url with If-None-Match inm, if given, and
+// returns the status code and the response's ETag.
+func conditionalGet(t *testing.T, url, inm string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if inm != "" {
+		req.Header.Set("If-None-Match", inm)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotModified && len(body) > 0 {
+		t.Errorf("304 for %s has a %d-byte body", url, len(body))
+	}
+	return resp.StatusCode, resp.Header.Get("ETag")
+}
+
+func TestQuestionsConditionalGet(t *testing.T) {
+	s := startServer(t)
+	url := s.url("/api/questions?count=5&seed=7")
+	code, tag := conditionalGet(t, url, "")
+	if code != http.StatusOK || tag == "" {
+		t.Fatalf("first fetch: status %d, ETag %q", code, tag)
+	}
+	// Compression weakens the tag of a full response only.
+	if code, again := conditionalGet(t, url, tag); code != http.StatusNotModified || again != strings.TrimPrefix(tag, "W/") {
+		t.Errorf("unchanged set: status %d, ETag %q, want 304 with %q", code, again, tag)
+	}
+	if code, _ := conditionalGet(t, url, `"stale"`); code != http.StatusOK {
+		t.Errorf("stale If-None-Match: status %d, want 200", code)
+	}
+	if _, other := conditionalGet(t, s.url("/api/questions?count=6&seed=7"), ""); other == tag {
+		t.Error("different parameters share an ETag")
+	}
+	// A player's questions depend on their history, so are not tagged.
+	if _, own := conditionalGet(t, s.url("/api/questions?count=5&token="+testSaveToken), ""); own != "" {
+		t.Errorf("a player's questions carry the ETag %s", own)
+	}
+}
+
+func TestQuestionsETagChangesWithAdminEdits(t *testing.T) {
+	s := startServer(t, "-admin-password", testAdminPassword)
+	url := s.url("/api/questions?count=50")
+	_, before := conditionalGet(t, url, "")
+	if code := adminRequest(t, s, http.MethodPost, "/api/admin/questions", "admin", testAdminPassword, newAdminQuestion("q900")); code != http.StatusCreated {
+		t.Fatalf("adding a question: status %d", code)
+	}
+	code, after := conditionalGet(t, url, before)
+	if code != http.StatusOK || after == before {
+		t.Errorf("after an admin edit: status %d, ETag %q (was %q), want 200 with a new tag", code, after, before)
+	}
+	if code, _ := conditionalGet(t, url, after); code != http.StatusNotModified {
+		t.Errorf("the new tag: status %d, want 304", code)
+	}
+}
+
+func TestSeededOrderStable(t *testing.T) {
+	pool := func(ids ...string) []PublicQuestion {
+		qs := make([]PublicQuestion, len(ids))
+		for i, id := range ids {
+			qs[i] = PublicQuestion{ID: id}
+		}
+		return qs
+	}
+	ids := func(qs []PublicQuestion) []string {
+		out := make([]string, len(qs))
+		for i, q := range qs {
+			out[i] = q.ID
+		}
+		return out
+	}
+	// The order is the documented SHA-256 order of "42:<id>", and must
+	// not change between releases.
+	qs := pool("q001", "q002", "q003", "q004", "q005")
+	seededOrder(qs, 42)
+	if got, want := ids(qs), []string{"q003", "q005", "q001", "q002", "q004"}; !slices.Equal(got, want) {
+		t.Errorf("seed 42 orders %v, want %v", got, want)
+	}
+	// Neither the pool's order nor what else is in it moves a question
+	// relative to the rest.
+	qs = pool("q004", "q002", "q005", "q001")
+	seededOrder(qs, 42)
+	if got, want := ids(qs), []string{"q005", "q001", "q002", "q004"}; !slices.Equal(got, want) {
+		t.Errorf("seed 42 orders a smaller pool %v, want %v", got, want)
+	}
+}
+
+func TestSeededSetsShareable(t *testing.T) {
+	s := startServer(t, "-session-secret", string(testSecret))
+	const query = "count=6&seed=99&difficulty=medium,easy&category=history,science"
+	var first struct {
+		Questions []servedQuestion `json:"questions"`
+		Seed      uint64           `json:"seed"`
+		URL       string           `json:"url"`
+	}
+	if code := getJSON(t, s.url("/api/questions?"+query), &first); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	want := questionIDs(t, s, query)
+	if len(want) == 0 || first.Seed != 99 {
+		t.Fatalf("seeded set %v with seed %d", want, first.Seed)
+	}
+	if first.URL != "/api/questions?category=history%2Cscience&count=6&difficulty=easy%2Cmedium&seed=99" {
+		t.Errorf("share URL %q is not canonical", first.URL)
+	}
+	if got := questionIDs(t, s, strings.TrimPrefix(first.URL, "/api/questions?")); !slices.Equal(got, want) {
+		t.Errorf("the share URL serves %v, want %v", got, want)
+	}
+
+	// Every student gets the same set and order, whatever their history
+	// or session, and so does another server.
+	sess := startSession(t, s)
+	var own struct {
+		URL string `json:"url"`
+	}
+	getJSON(t, s.url("/api/questions?"+query+"&token=student-1&session="+sess.Token), &own)
+	if strings.Contains(own.URL, "token") || strings.Contains(own.URL, "session") {
+		t.Errorf("share URL %q carries the student's tokens", own.URL)
+	}
+	for _, q := range []string{query + "&token=student-1", query + "&session=" + sess.Token, "seed=99&category=science,history&difficulty=easy,medium&count=6"} {
+		if got := questionIDs(t, s, q); !slices.Equal(got, want) {
+			t.Errorf("%s: %v, want %v", q, got, want)
+		}
+	}
+	s.stop(t)
+	if got := questionIDs(t, startServer(t), query); !slices.Equal(got, want) {
+		t.Errorf("another server served %v, want %v", got, want)
+	}
+}
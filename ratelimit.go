@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiterIdle is how long, by default, a client may go without
+// requests before its bucket is forgotten.
+const rateLimiterIdle = 3 * time.Minute
+
+// rateLimiter hands out one token bucket per key; its middleware keys
+// buckets by client IP.
+type rateLimiter struct {
+	limit   rate.Limit
+	burst   int
+	proxies trustedProxies
+	// idle is how long a bucket is kept unused. Forgetting one refills it,
+	// so it should be no shorter than a bucket takes to refill.
+	idle time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*rateClient
+}
+
+type rateClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newRateLimiter allows each key limit events per second with bursts of
+// up to burst. The middleware resolves client addresses forwarded by
+// proxies as clientIP does.
+func newRateLimiter(limit float64, burst int, proxies trustedProxies) *rateLimiter {
+	return &rateLimiter{
+		limit:   rate.Limit(limit),
+		burst:   burst,
+		proxies: proxies,
+		idle:    rateLimiterIdle,
+		clients: make(map[string]*rateClient),
+	}
+}
+
+// SetRate changes the allowance to limit events per second with bursts of
+// up to burst, for the clients already seen as well as new ones.
+func (l *rateLimiter) SetRate(limit float64, burst int) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit, l.burst = rate.Limit(limit), burst
+	for _, c := range l.clients {
+		c.limiter.SetLimitAt(now, l.limit)
+		c.limiter.SetBurstAt(now, burst)
+	}
+}
+
+// reserve takes a token for key, returning how long the caller would
+// have to wait if none is available right now.
+func (l *rateLimiter) reserve(key string) (ok bool, retryAfter time.Duration) {
+	now := time.Now()
+	l.mu.Lock()
+	c, found := l.clients[key]
+	if !found {
+		c = &rateClient{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+	l.mu.Unlock()
+
+	res := c.limiter.ReserveN(now, 1)
+	if !res.OK() {
+		return false, time.Second
+	}
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// collect drops idle clients every interval until ctx is cancelled.
+func (l *rateLimiter) collect(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			for key, c := range l.clients {
+				if now.Sub(c.lastSeen) > l.idle {
+					delete(l.clients, key)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// middleware rejects requests over the client's rate with 429 Too Many
+// Requests and a Retry-After header.
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, retryAfter := l.reserve(clientIP(r, l.proxies))
+		if !ok {
+			writeRetryAfter(w, http.StatusTooManyRequests, "too many requests", retryAfter)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// retryHint is the details of a response asking the client to try again
+// later: the seconds to wait, as in its Retry-After header.
+type retryHint struct {
+	RetryAfterSeconds int `json:"retryAfterSeconds"`
+}
+
+// setRetryAfter sets the Retry-After header to d rounded up to whole
+// seconds, and at least one, and returns the seconds.
+func setRetryAfter(w http.ResponseWriter, d time.Duration) int {
+	secs := max(int(math.Ceil(d.Seconds())), 1)
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	return secs
+}
+
+// writeRetryAfter sends an API error asking the client to try again after
+// d, both in the Retry-After header and under details, so that clients can
+// schedule the retry instead of polling.
+func writeRetryAfter(w http.ResponseWriter, status int, message string, d time.Duration) {
+	secs := setRetryAfter(w, d)
+	writeAPIErrorDetails(w, status, message, retryHint{RetryAfterSeconds: secs})
+}
+
+// trustedProxies are the networks whose X-Forwarded-For entries are
+// believed.
+type trustedProxies []netip.Prefix
+
+// parseTrustedProxies parses a comma-separated list of CIDR prefixes or
+// single addresses.
+func parseTrustedProxies(list string) (trustedProxies, error) {
+	var proxies trustedProxies
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return nil, fmt.Errorf("%q is not a CIDR prefix or IP address", entry)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// contains reports whether addr is one of the trusted proxies.
+func (t trustedProxies) contains(addr netip.Addr) bool {
+	for _, p := range t {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client that sent r. When the
+// connection comes from a trusted proxy, X-Forwarded-For is read from
+// right to left, each entry having been added by the hop before it, and
+// the first address that is not a trusted proxy is the client. Entries to
+// the left of that one are the client's own to forge, so they are never
+// believed; nor is the header when no proxies are trusted.
+func clientIP(r *http.Request, proxies trustedProxies) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !proxies.contains(addr.Unmap()) {
+		return host
+	}
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// The chain cannot be followed further than the last trusted
+			// proxy, so that is the best answer.
+			break
+		}
+		addr = hop.Unmap()
+		if !proxies.contains(addr) {
+			break
+		}
+	}
+	return addr.String()
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRejectsBursts(t *testing.T) {
+	l := newRateLimiter(1, 5, nil)
+	h := l.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	var ok, limited atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodPost, "/api/leaderboard", nil)
+			r.RemoteAddr = "203.0.113.7:5555"
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			switch w.Code {
+			case http.StatusOK:
+				ok.Add(1)
+			case http.StatusTooManyRequests:
+				limited.Add(1)
+				if w.Header().Get("Retry-After") == "" {
+					t.Error("429 without Retry-After")
+				}
+			default:
+				t.Errorf("status %d", w.Code)
+			}
+		}()
+	}
+	wg.Wait()
+	if limited.Load() == 0 {
+		t.Fatal("no request over the limit was rejected")
+	}
+	if n := ok.Load(); n < 5 || n > 6 {
+		t.Errorf("%d requests allowed, want the burst of 5", n)
+	}
+
+	// Another client has a bucket of its own.
+	r := httptest.NewRequest(http.MethodPost, "/api/leaderboard", nil)
+	r.RemoteAddr = "198.51.100.2:5555"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("a second client was limited: status %d", w.Code)
+	}
+}
+
+func TestClientIPTrustsProxiesOnly(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.5:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9")
+	if got := clientIP(r, nil); got != "10.0.0.5" {
+		t.Errorf("X-Forwarded-For honoured from an untrusted peer: %s", got)
+	}
+	proxies, err := parseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := clientIP(r, proxies); got != "203.0.113.9" {
+		t.Errorf("client behind a trusted proxy = %s, want 203.0.113.9", got)
+	}
+	r.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.9")
+	if got := clientIP(r, proxies); got != "203.0.113.9" {
+		t.Errorf("forged leftmost entry believed: %s", got)
+	}
+}
+
+func TestRateLimiterForgetsIdleClients(t *testing.T) {
+	l := newRateLimiter(1, 1, nil)
+	l.reserve("203.0.113.7")
+	l.mu.Lock()
+	l.clients["203.0.113.7"].lastSeen = time.Now().Add(-2 * rateLimiterIdle)
+	l.mu.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go l.collect(ctx, 5*time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mu.Lock()
+		n := len(l.clients)
+		l.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("idle client never forgotten")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRateLimitAppliesToAPI(t *testing.T) {
+	s := startServer(t, "-rate-limit", "1", "-rate-burst", "2")
+	limited := false
+	for i := 0; i < 10; i++ {
+		if code := getJSON(t, s.url("/api/questions"), nil); code == http.StatusTooManyRequests {
+			limited = true
+		}
+	}
+	if !limited {
+		t.Error("/api/questions was never rate limited")
+	}
+	for i := 0; i < 10; i++ {
+		if code, _ := get(t, s.url("/css/game.css")); code != http.StatusOK {
+			t.Fatalf("static file limited: status %d", code)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, remote, xff, want string
+		proxies                 trustedProxies
+	}{
+		{"direct", "203.0.113.9:4000", "", "203.0.113.9", proxies},
+		{"direct ignores header", "203.0.113.9:4000", "198.51.100.1", "203.0.113.9", proxies},
+		{"no proxies configured", "10.0.0.5:4000", "198.51.100.1", "10.0.0.5", nil},
+		{"single trusted proxy", "192.0.2.1:4000", "198.51.100.1", "198.51.100.1", proxies},
+		{"chain of trusted proxies", "10.0.0.5:4000", "198.51.100.1, 10.1.2.3", "198.51.100.1", proxies},
+		{"spoofed leftmost entry", "10.0.0.5:4000", "6.6.6.6, 198.51.100.1", "198.51.100.1", proxies},
+		{"garbled entry stops the walk", "10.0.0.5:4000", "198.51.100.1, not-an-ip, 10.1.2.3", "10.1.2.3", proxies},
+		{"all hops trusted", "10.0.0.5:4000", "10.9.9.9", "10.9.9.9", proxies},
+		{"no header from proxy", "10.0.0.5:4000", "", "10.0.0.5", proxies},
+		{"mapped IPv4 peer", "[::ffff:10.0.0.5]:4000", "198.51.100.1", "198.51.100.1", proxies},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if got := clientIP(r, tt.proxies); got != tt.want {
+			t.Errorf("%s: clientIP = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	if _, err := parseTrustedProxies("10.0.0.0/8,bogus"); err == nil {
+		t.Error("an entry that is neither a prefix nor an address was accepted")
+	}
+	proxies, err := parseTrustedProxies(" 10.1.2.3/8 , ::1, ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proxies) != 2 || proxies[0].String() != "10.0.0.0/8" || proxies[1].String() != "::1/128" {
+		t.Errorf("parsed %v, want [10.0.0.0/8 ::1/128]", proxies)
+	}
+}
+
+func TestTrustedProxiesResolveLoggedAndLimitedClient(t *testing.T) {
+	s := startServer(t, "-trusted-proxies", "127.0.0.1", "-rate-limit", "1", "-rate-burst", "2")
+	getFrom := func(client string) int {
+		req, _ := http.NewRequest(http.MethodGet, s.url("/api/questions"), nil)
+		req.Header.Set("X-Forwarded-For", client)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for range 5 {
+		getFrom("198.51.100.1")
+	}
+	if code := getFrom("198.51.100.1"); code != http.StatusTooManyRequests {
+		t.Errorf("the forwarded client was not limited: status %d", code)
+	}
+	if code := getFrom("198.51.100.2"); code != http.StatusOK {
+		t.Errorf("a second client behind the proxy shared the first's allowance: status %d", code)
+	}
+	if v, ok := s.logs.attr("request", "client"); !ok || v.String() != "198.51.100.1" {
+		t.Errorf("logged client = %v, want 198.51.100.1", v)
+	}
+}
+
+// retryHintOf returns the seconds a response with header and body asks
+// the client to wait, failing the test unless its Retry-After header and
+// the details of its body agree.
+func retryHintOf(t *testing.T, header http.Header, body []byte) int {
+	t.Helper()
+	var resp struct {
+		Error struct {
+			Details retryHint `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("%v\n%s", err, body)
+	}
+	secs, err := strconv.Atoi(header.Get("Retry-After"))
+	if err != nil || secs != resp.Error.Details.RetryAfterSeconds {
+		t.Errorf("Retry-After %q, details %+v; want the same whole seconds", header.Get("Retry-After"), resp.Error.Details)
+	}
+	return secs
+}
+
+func TestRetryAfterHints(t *testing.T) {
+	l := newRateLimiter(0.5, 1, nil)
+	h := l.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	var w *httptest.ResponseRecorder
+	for range 2 {
+		r := httptest.NewRequest(http.MethodPost, "/api/leaderboard", nil)
+		r.RemoteAddr = "203.0.113.7:5555"
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+	}
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status %d, want 429", w.Code)
+	}
+	if secs := retryHintOf(t, w.Header(), w.Body.Bytes()); secs < 1 || secs > 2 {
+		t.Errorf("throttled at one request per 2s: retry after %ds, want 1-2", secs)
+	}
+
+	w = httptest.NewRecorder()
+	newConcurrencyLimiter(ConcurrencyLimits{}, nil).reject(w)
+	if secs := retryHintOf(t, w.Header(), w.Body.Bytes()); w.Code != http.StatusServiceUnavailable || secs != 1 {
+		t.Errorf("busy: status %d, retry after %ds; want 503 after 1s", w.Code, secs)
+	}
+}
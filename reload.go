@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay is how long the content must stay unchanged before it is
+// reloaded, so an editor's burst of writes and renames loads once.
+const reloadDelay = 250 * time.Millisecond
+
+// contentReloader re-reads the question and achievement files in dev
+// mode and swaps them into the running server. Languages that had no
+// variant at startup are not picked up until a restart, nor are
+// achievements when achievements is nil, as it is when they were missing.
+type contentReloader struct {
+	content      fs.FS
+	langs        *languageRegistry
+	banks        map[string]*questionBank
+	overlay      *questionOverlay
+	achievements *achievementSet
+}
+
+// watch reloads the content whenever a question or achievement file under
+// root changes on disk, until ctx is done.
+func (c *contentReloader) watch(ctx context.Context, root string) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(root, filepath.FromSlash(path.Dir(questionsFile)))
+	if err := w.Add(dir); err != nil {
+		w.Close()
+		return err
+	}
+	slog.Info("watching content for changes", "dir", dir)
+	go func() {
+		defer w.Close()
+		timer := time.NewTimer(reloadDelay)
+		timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case ev := <-w.Events:
+				rel, err := filepath.Rel(root, ev.Name)
+				if err != nil || !isContentFile(filepath.ToSlash(rel)) {
+					continue
+				}
+				timer.Reset(reloadDelay)
+			case err := <-w.Errors:
+				slog.Warn("content watcher", "err", err)
+			case <-timer.C:
+				if err := c.reload(); err != nil {
+					logContentError("content reload failed; keeping the previous content", err)
+					continue
+				}
+				slog.Info("content reloaded")
+			}
+		}
+	}()
+	return nil
+}
+
+// isContentFile reports whether name is a question or achievement file.
+func isContentFile(name string) bool {
+	q, _ := path.Match(questionFilesPattern, name)
+	a, _ := path.Match(achievementFilesPattern, name)
+	return q || a
+}
+
+// reload validates and parses every content file, then swaps them all in.
+// Nothing is replaced unless everything loads. Replacing a bank's
+// questions rebuilds its search index too.
+func (c *contentReloader) reload() error {
+	if err := validateContent(c.content); err != nil {
+		return err
+	}
+	questions := make(map[string][]Question, len(c.banks))
+	for lang := range c.banks {
+		name, _ := c.langs.file(questionsFile, lang)
+		qs, err := loadQuestions(c.content, name)
+		if err != nil {
+			return err
+		}
+		questions[lang] = qs
+	}
+	var achievements map[string][]Achievement
+	if c.achievements != nil {
+		var err error
+		if achievements, err = loadLocalized(c.content, c.langs, achievementsFile, loadAchievements); err != nil {
+			return err
+		}
+	}
+
+	if err := c.overlay.Rebase(questions[defaultLanguage]); err != nil {
+		return fmt.Errorf("applying admin edits: %w", err)
+	}
+	for lang, qs := range questions {
+		if lang != defaultLanguage {
+			c.banks[lang].Replace(qs)
+		}
+	}
+	if c.achievements != nil {
+		c.achievements.Replace(achievements)
+	}
+	return nil
+}
+
+// logContentError logs msg with err, listing each problem separately when
+// err comes from content validation.
+func logContentError(msg string, err error) {
+	var ce *contentError
+	if errors.As(err, &ce) {
+		for _, p := range ce.Problems {
+			slog.Error("invalid content", "file", p.File, "item", p.Item, "problem", p.Message)
+		}
+	}
+	slog.Error(msg, "err", err)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeQuestions replaces the questions file in the working directory with
+// questions.
+func writeQuestions(t *testing.T, questions []Question) {
+	t.Helper()
+	data, err := json.MarshalIndent(map[string]any{"questions": questions}, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(questionsFile, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after 5s.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(50 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s did not happen within 5s", what)
+		}
+	}
+}
+
+func TestDevModeReloadsQuestions(t *testing.T) {
+	inTempDir(t)
+	if err := os.CopyFS(".", staticFS); err != nil {
+		t.Fatal(err)
+	}
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := startServerIn(t, "-dev")
+
+	added := questions[0]
+	added.ID = "q900"
+	added.Question = "Which gate does the drawbridge guard?"
+	writeQuestions(t, append(slices.Clone(questions), added))
+	waitFor(t, "serving the added question", func() bool {
+		return slices.Contains(questionIDs(t, s, "count=50"), "q900")
+	})
+	waitFor(t, "finding the added question", func() bool {
+		return slices.Equal(searchIDs(t, s, "drawbridge"), []string{"q900"})
+	})
+
+	// A broken edit is reported and the last good questions kept.
+	invalid := append(slices.Clone(questions), added)
+	invalid[0].CorrectAnswer = len(invalid[0].Answers)
+	writeQuestions(t, invalid)
+	waitFor(t, "logging the failed reload", func() bool {
+		_, ok := s.logs.attr("content reload failed; keeping the previous content", "err")
+		return ok
+	})
+	if ids := questionIDs(t, s, "count=50"); !slices.Contains(ids, "q900") || len(ids) != len(questions)+1 {
+		t.Errorf("after a broken edit %d questions are served, want the last good %d", len(ids), len(questions)+1)
+	}
+
+	// Taking the question out again takes it out of search.
+	writeQuestions(t, questions)
+	waitFor(t, "no longer finding the removed question", func() bool {
+		return len(searchIDs(t, s, "drawbridge")) == 0
+	})
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// replayNamespace holds one recording per game session, keyed by
+	// session ID.
+	replayNamespace = "replays"
+	// maxReplays caps how many recordings are kept; the oldest are
+	// dropped first.
+	maxReplays = 1000
+	// replayRetention is how long a recording is kept after its session
+	// started.
+	replayRetention = 7 * 24 * time.Hour
+	// maxReplayEvents bounds a single recording. Later events are
+	// dropped.
+	maxReplayEvents = 500
+	// maxReplayBody bounds a client-reported event.
+	maxReplayBody = 1 << 10
+)
+
+// Replay event types. The server records question and answer events
+// itself, along with hint and the points it cost; clients report room as
+// the player moves.
+const (
+	replayRoom     = "room"
+	replayQuestion = "question"
+	replayAnswer   = "answer"
+	replayHint     = "hint"
+)
+
+// replayEvent is one step of a recorded session. T is milliseconds since
+// the recording started, and Choice is in the question's canonical answer
+// order.
+type replayEvent struct {
+	T        int64  `json:"t"`
+	Type     string `json:"type"`
+	Room     string `json:"room,omitempty"`
+	Question string `json:"question,omitempty"`
+	Choice   *int   `json:"choice,omitempty"`
+	Correct  *bool  `json:"correct,omitempty"`
+	Delta    int    `json:"delta,omitempty"`
+}
+
+// replay is the recording of one game session.
+type replay struct {
+	Started time.Time     `json:"started"`
+	Events  []replayEvent `json:"events"`
+}
+
+// replays records game sessions in a Store, keeping at most max of them
+// for up to retention.
+type replays struct {
+	store     Store
+	max       int
+	retention time.Duration
+
+	mu      sync.Mutex
+	started map[string]time.Time // session ID -> recording start
+}
+
+// newReplays indexes the recordings already in store.
+func newReplays(store Store) (*replays, error) {
+	rp := &replays{store: store, max: maxReplays, retention: replayRetention}
+	if err := rp.Reindex(); err != nil {
+		return nil, err
+	}
+	return rp, nil
+}
+
+// Reindex rebuilds the index from the recordings in the store, as after a
+// restore.
+func (rp *replays) Reindex() error {
+	ids, err := rp.store.List(replayNamespace)
+	if err != nil {
+		return err
+	}
+	started := make(map[string]time.Time, len(ids))
+	for _, id := range ids {
+		rec, err := rp.Load(id)
+		if err != nil {
+			return fmt.Errorf("stored replay %s: %w", id, err)
+		}
+		started[id] = rec.Started
+	}
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.started = started
+	return nil
+}
+
+// Load returns the recording of session id.
+func (rp *replays) Load(id string) (replay, error) {
+	var rec replay
+	data, err := rp.store.Get(replayNamespace, id)
+	if err != nil {
+		return rec, err
+	}
+	err = json.Unmarshal(data, &rec)
+	return rec, err
+}
+
+// Record appends ev, which happened at now, to the recording of session
+// id, starting one if needed.
+func (rp *replays) Record(id string, ev replayEvent, now time.Time) error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rec, err := rp.Load(id)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		if err := rp.makeRoom(); err != nil {
+			return err
+		}
+		rec = replay{Started: now.UTC()}
+	case err != nil:
+		return err
+	}
+	if len(rec.Events) >= maxReplayEvents {
+		return nil
+	}
+	ev.T = now.Sub(rec.Started).Milliseconds()
+	rec.Events = append(rec.Events, ev)
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := rp.store.Set(replayNamespace, id, data); err != nil {
+		return err
+	}
+	rp.started[id] = rec.Started
+	return nil
+}
+
+// makeRoom drops the oldest recordings until a new one fits. rp.mu must
+// be held.
+func (rp *replays) makeRoom() error {
+	if len(rp.started) < rp.max {
+		return nil
+	}
+	ids := sortedKeys(rp.started)
+	sort.SliceStable(ids, func(i, j int) bool { return rp.started[ids[i]].Before(rp.started[ids[j]]) })
+	for _, id := range ids[:len(ids)-rp.max+1] {
+		if err := rp.drop(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drop deletes the recording of session id. rp.mu must be held.
+func (rp *replays) drop(id string) error {
+	if err := rp.store.Delete(replayNamespace, id); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	delete(rp.started, id)
+	return nil
+}
+
+// collect deletes recordings older than the retention period, every
+// interval until ctx is cancelled.
+func (rp *replays) collect(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			rp.mu.Lock()
+			for id, started := range rp.started {
+				if now.Sub(started) > rp.retention {
+					if err := rp.drop(id); err != nil {
+						slog.Warn("could not delete expired replay", "session", id, "err", err)
+					}
+				}
+			}
+			rp.mu.Unlock()
+		}
+	}
+}
+
+// record is Record for handlers, where a failure to record must not fail
+// the request.
+func (rp *replays) record(id string, ev replayEvent, now time.Time) {
+	if err := rp.Record(id, ev, now); err != nil {
+		slog.Warn("could not record replay event", "session", id, "err", err)
+	}
+}
+
+// replaySummary is a recording as GET /api/admin/replays lists it.
+type replaySummary struct {
+	ID      string    `json:"id"`
+	Started time.Time `json:"started"`
+}
+
+// Page returns the recordings on page, oldest first, and the cursor of
+// the next page. They are keyed by start time, so recordings started
+// while paging come after the ones already seen.
+func (rp *replays) Page(page cursorPage) ([]replaySummary, string) {
+	rp.mu.Lock()
+	byKey := make(map[string]replaySummary, len(rp.started))
+	for id, started := range rp.started {
+		byKey[started.UTC().Format(replayKeyTime)+"_"+id] = replaySummary{ID: id, Started: started}
+	}
+	rp.mu.Unlock()
+	keys, next := page.Keys(sortedKeys(byKey))
+	out := make([]replaySummary, len(keys))
+	for i, key := range keys {
+		out[i] = byKey[key]
+	}
+	return out, next
+}
+
+// replayKeyTime formats start times so that they sort in time order.
+const replayKeyTime = "20060102T150405.000000000"
+
+// adminReplaysHandler serves GET /api/admin/replays?cursor=C&limit=N, the
+// recordings kept, a page at a time.
+func adminReplaysHandler(rp *replays, sess *sessions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		page, err := cursorParams(r, sess, "replays")
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		items, next := rp.Page(page)
+		writeJSON(w, http.StatusOK, map[string]any{"replays": items, "nextCursor": next})
+	})
+}
+
+// replayHandler serves GET /api/replay/{id}, the recording of a session,
+// and POST /api/replay/{id}?session=S, which appends a room event
+// reported by the player of that session.
+func replayHandler(rp *replays, sess *sessions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if !validStoreName.MatchString(id) {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			rec, err := rp.Load(id)
+			if errors.Is(err, ErrNotFound) {
+				writeAPIError(w, http.StatusNotFound, "no replay for this session")
+				return
+			}
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, "could not load replay")
+				return
+			}
+			writeJSON(w, http.StatusOK, rec)
+		case http.MethodPost:
+			sessionID, err := sess.fromQuery(r)
+			if err != nil {
+				writeAPIError(w, http.StatusForbidden, err.Error())
+				return
+			}
+			if sessionID != id {
+				writeAPIError(w, http.StatusForbidden, "session token does not match this replay")
+				return
+			}
+			var ev replayEvent
+			r.Body = http.MaxBytesReader(w, r.Body, maxReplayBody)
+			if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+				writeBodyError(w, err, "invalid JSON body")
+				return
+			}
+			if ev.Type != replayRoom || !validStoreName.MatchString(ev.Room) {
+				writeAPIError(w, http.StatusBadRequest, `only {"type":"room","room":"<id>"} events may be reported`)
+				return
+			}
+			if err := rp.Record(id, replayEvent{Type: replayRoom, Room: ev.Room}, time.Now()); err != nil {
+				writeAPIError(w, http.StatusInternalServerError, "could not record event")
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+}
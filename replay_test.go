@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReplayRecordsOrderedRelativeEvents(t *testing.T) {
+	rp, err := newReplays(newMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	yes := true
+	events := []replayEvent{
+		{Type: replayRoom, Room: "entrance"},
+		{Type: replayQuestion, Question: "q001"},
+		{Type: replayAnswer, Question: "q001", Correct: &yes, Delta: 50},
+	}
+	for i, ev := range events {
+		if err := rp.Record("session-1", ev, start.Add(time.Duration(i)*1500*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec, err := rp.Load("session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Started.Equal(start) || len(rec.Events) != len(events) {
+		t.Fatalf("replay started %v with %d events, want %v and %d", rec.Started, len(rec.Events), start, len(events))
+	}
+	for i, ev := range rec.Events {
+		if ev.Type != events[i].Type || ev.T != int64(i)*1500 {
+			t.Errorf("event %d is %s at %dms, want %s at %dms", i, ev.Type, ev.T, events[i].Type, i*1500)
+		}
+	}
+}
+
+func TestReplayCapDropsOldest(t *testing.T) {
+	rp, err := newReplays(newMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp.max = 2
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := rp.Record(fmt.Sprintf("session-%d", i), replayEvent{Type: replayRoom, Room: "hall"}, now.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := rp.Load("session-0"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("the oldest replay survived past the cap: %v", err)
+	}
+	for _, id := range []string{"session-1", "session-2"} {
+		if _, err := rp.Load(id); err != nil {
+			t.Errorf("%s: %v", id, err)
+		}
+	}
+
+	for i := 0; i < maxReplayEvents+10; i++ {
+		rp.Record("session-2", replayEvent{Type: replayRoom, Room: "hall"}, now)
+	}
+	if rec, _ := rp.Load("session-2"); len(rec.Events) != maxReplayEvents {
+		t.Errorf("replay holds %d events, want the cap of %d", len(rec.Events), maxReplayEvents)
+	}
+}
+
+func TestReplayExpiry(t *testing.T) {
+	rp, err := newReplays(newMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp.retention = time.Hour
+	rp.Record("stale", replayEvent{Type: replayRoom, Room: "hall"}, time.Now().Add(-2*time.Hour))
+	rp.Record("fresh", replayEvent{Type: replayRoom, Room: "hall"}, time.Now())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go rp.collect(ctx, 10*time.Millisecond)
+	waitFor(t, "expiring the stale replay", func() bool {
+		_, err := rp.Load("stale")
+		return errors.Is(err, ErrNotFound)
+	})
+	if _, err := rp.Load("fresh"); err != nil {
+		t.Errorf("a fresh replay was expired: %v", err)
+	}
+}
+
+func TestReplayOfAnsweredSession(t *testing.T) {
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	correct := make(map[string]string, len(questions))
+	for _, q := range questions {
+		correct[q.ID] = q.Answers[q.CorrectAnswer]
+	}
+	s := startServer(t, "-session-secret", string(testSecret))
+	sess := startSession(t, s)
+	served := questionIDs(t, s, "count=2&session="+url.QueryEscape(sess.Token))
+	if code := postJSON(t, s.url("/api/replay/"+sess.SessionID+"?session="+url.QueryEscape(sess.Token)), map[string]any{"type": replayRoom, "room": "library"}, nil); code != http.StatusNoContent {
+		t.Fatalf("room event: status %d", code)
+	}
+	// Answer the first question right, in the order it was shown.
+	choice := slices.Index(shownQuestions(t, s, sess)[served[0]], correct[served[0]])
+	if code := postJSON(t, s.url("/api/answer?session="+url.QueryEscape(sess.Token)), map[string]any{"questionID": served[0], "choiceIndex": choice}, nil); code != http.StatusOK {
+		t.Fatalf("answer: status %d", code)
+	}
+
+	var rec replay
+	if code := getJSON(t, s.url("/api/replay/"+sess.SessionID), &rec); code != http.StatusOK {
+		t.Fatalf("/api/replay: status %d", code)
+	}
+	var kinds []string
+	for i, ev := range rec.Events {
+		kinds = append(kinds, ev.Type+":"+ev.Question+ev.Room)
+		if i > 0 && ev.T < rec.Events[i-1].T {
+			t.Errorf("event %d at %dms comes before the previous one at %dms", i, ev.T, rec.Events[i-1].T)
+		}
+	}
+	last := rec.Events[len(rec.Events)-1]
+	if last.Type != replayAnswer || last.Question != served[0] || last.Correct == nil || !*last.Correct || last.Delta <= 0 {
+		t.Errorf("events %v end with %+v, want the correct answer to %s with its points", kinds, last, served[0])
+	}
+	q0, q1, room := slices.Index(kinds, "question:"+served[0]), slices.Index(kinds, "question:"+served[1]), slices.Index(kinds, "room:library")
+	if q0 < 0 || q1 < 0 || room < q0 || room < q1 {
+		t.Errorf("events %v: want both questions served, then the room", kinds)
+	}
+	if code := getJSON(t, s.url("/api/replay/no-such-session"), nil); code != http.StatusNotFound {
+		t.Errorf("unknown session: status %d, want 404", code)
+	}
+}
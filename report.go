@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// reportNamespace holds player reports of bad questions, keyed by
+	// question ID.
+	reportNamespace = "reports"
+	// reportRate and reportBurst limit how often one client may report a
+	// question: a handful at once, then one a minute.
+	reportRate  = 1.0 / 60
+	reportBurst = 5
+	// maxReportBody bounds a report submission.
+	maxReportBody = 4 << 10
+	// maxReportComment bounds a report's comment, in runes.
+	maxReportComment = 500
+)
+
+// reportReasons lists what a player may report a question for.
+var reportReasons = map[string]bool{"wrong-answer": true, "ambiguous": true, "typo": true, "offensive": true, "other": true}
+
+// errAlreadyReported reports a second report of a question from the same
+// session.
+var errAlreadyReported = errors.New("question already reported in this session")
+
+// questionReport is one player's report of a question.
+type questionReport struct {
+	Reason     string    `json:"reason"`
+	Comment    string    `json:"comment,omitempty"`
+	Session    string    `json:"session"`
+	ReportedAt time.Time `json:"reportedAt"`
+}
+
+// reportSummary is the admin view of the reports against one question.
+type reportSummary struct {
+	QuestionID string           `json:"questionID"`
+	Count      int              `json:"count"`
+	Reasons    map[string]int   `json:"reasons"`
+	Reports    []questionReport `json:"reports"`
+}
+
+// reportBook keeps question reports in a Store.
+type reportBook struct {
+	mu    sync.Mutex // serializes read-modify-write updates
+	store Store
+}
+
+func newReportBook(store Store) *reportBook {
+	return &reportBook{store: store}
+}
+
+// load returns the reports against question id.
+func (b *reportBook) load(id string) ([]questionReport, error) {
+	data, err := b.store.Get(reportNamespace, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var reports []questionReport
+	err = json.Unmarshal(data, &reports)
+	return reports, err
+}
+
+// Add files rep against question id, unless its session already reported
+// that question.
+func (b *reportBook) Add(id string, rep questionReport) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	reports, err := b.load(id)
+	if err != nil {
+		return err
+	}
+	for _, prev := range reports {
+		if prev.Session == rep.Session {
+			return errAlreadyReported
+		}
+	}
+	data, err := json.Marshal(append(reports, rep))
+	if err != nil {
+		return err
+	}
+	return b.store.Set(reportNamespace, id, data)
+}
+
+// Summaries returns the reports against every reported question, most
+// reported first.
+func (b *reportBook) Summaries() ([]reportSummary, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ids, err := b.store.List(reportNamespace)
+	if err != nil {
+		return nil, err
+	}
+	summaries := make([]reportSummary, 0, len(ids))
+	for _, id := range ids {
+		reports, err := b.load(id)
+		if err != nil {
+			return nil, fmt.Errorf("reports for %s: %w", id, err)
+		}
+		s := reportSummary{QuestionID: id, Count: len(reports), Reasons: make(map[string]int), Reports: reports}
+		for _, rep := range reports {
+			s.Reasons[rep.Reason]++
+		}
+		summaries = append(summaries, s)
+	}
+	sort.SliceStable(summaries, func(i, j int) bool { return summaries[i].Count > summaries[j].Count })
+	return summaries, nil
+}
+
+// Clear dismisses every report against question id.
+func (b *reportBook) Clear(id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.store.Delete(reportNamespace, id)
+}
+
+// reportRequest is the body of POST /api/questions/{id}/report.
+type reportRequest struct {
+	Reason  string `json:"reason"`
+	Comment string `json:"comment"`
+}
+
+// reportHandler serves POST /api/questions/{id}/report?session=S, filing a
+// player's report of a bad question. Each session may report a question
+// once, and reports from one client are rate-limited by limiter.
+func reportHandler(banks map[string]*questionBank, book *reportBook, limiter *rateLimiter, sess *sessions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		sessionID, err := sess.fromQuery(r)
+		if err != nil {
+			writeAPIError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if sessionID == "" {
+			writeAPIError(w, http.StatusBadRequest, "session is required")
+			return
+		}
+		id := r.PathValue("id")
+		if _, ok := banks[defaultLanguage].Get(id); !ok {
+			writeAPIError(w, http.StatusNotFound, "unknown question")
+			return
+		}
+		var req reportRequest
+		r.Body = http.MaxBytesReader(w, r.Body, maxReportBody)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeBodyError(w, err, "invalid JSON body")
+			return
+		}
+		comment := strings.TrimSpace(req.Comment)
+		switch {
+		case !reportReasons[req.Reason]:
+			writeAPIError(w, http.StatusBadRequest, "reason must be one of "+strings.Join(sortedKeys(reportReasons), ", "))
+			return
+		case len([]rune(comment)) > maxReportComment:
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("comment must be at most %d characters", maxReportComment))
+			return
+		}
+		if ok, retryAfter := limiter.reserve(clientIP(r, limiter.proxies)); !ok {
+			writeRetryAfter(w, http.StatusTooManyRequests, "too many reports", retryAfter)
+			return
+		}
+		rep := questionReport{Reason: req.Reason, Comment: comment, Session: sessionID, ReportedAt: time.Now().UTC()}
+		switch err := book.Add(id, rep); {
+		case errors.Is(err, errAlreadyReported):
+			writeAPIError(w, http.StatusConflict, err.Error())
+		case err != nil:
+			writeAPIError(w, http.StatusInternalServerError, "could not save report")
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
+
+// adminReportsHandler serves GET /api/admin/reports, every reported
+// question with its reports, and DELETE /api/admin/reports/{id} to dismiss
+// the reports against one.
+func adminReportsHandler(book *reportBook) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		switch {
+		case id == "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+			summaries, err := book.Summaries()
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, "could not load reports")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"questions": summaries})
+		case id != "" && r.Method == http.MethodDelete:
+			err := book.Clear(id)
+			if errors.Is(err, ErrNotFound) {
+				writeAPIError(w, http.StatusNotFound, "no reports for this question")
+				return
+			}
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, "could not clear reports")
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			if id == "" {
+				w.Header().Set("Allow", "GET, HEAD")
+			} else {
+				w.Header().Set("Allow", "DELETE")
+			}
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// reportQuestion files a report of question id from sess and returns the
+// status code.
+func reportQuestion(t *testing.T, s *runningServer, sess sessionResponse, id, reason string) int {
+	t.Helper()
+	return postJSON(t, s.url("/api/questions/"+id+"/report?session="+url.QueryEscape(sess.Token)), reportRequest{Reason: reason, Comment: "  the dates look off  "}, nil)
+}
+
+// adminReports fetches the admin view of the reports.
+func adminReports(t *testing.T, s *runningServer) []reportSummary {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, s.url("/api/admin/reports"), nil)
+	req.SetBasicAuth("admin", testAdminPassword)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/api/admin/reports: status %d", resp.StatusCode)
+	}
+	var body struct {
+		Questions []reportSummary `json:"questions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Questions
+}
+
+func TestReportQuestion(t *testing.T) {
+	s := startServer(t, "-admin-password", testAdminPassword)
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := questions[0].ID, questions[1].ID
+	alice, bob := startSession(t, s), startSession(t, s)
+
+	if code := reportQuestion(t, s, alice, first, "wrong-answer"); code != http.StatusNoContent {
+		t.Fatalf("report: status %d, want 204", code)
+	}
+	if code := reportQuestion(t, s, alice, first, "typo"); code != http.StatusConflict {
+		t.Errorf("second report from one session: status %d, want 409", code)
+	}
+	if code := reportQuestion(t, s, bob, first, "ambiguous"); code != http.StatusNoContent {
+		t.Errorf("report from another session: status %d, want 204", code)
+	}
+	if code := reportQuestion(t, s, alice, second, "typo"); code != http.StatusNoContent {
+		t.Errorf("report of another question: status %d, want 204", code)
+	}
+
+	summaries := adminReports(t, s)
+	if len(summaries) != 2 || summaries[0].QuestionID != first || summaries[1].QuestionID != second {
+		t.Fatalf("admin view %+v, want %s then %s", summaries, first, second)
+	}
+	top := summaries[0]
+	if top.Count != 2 || top.Reasons["wrong-answer"] != 1 || top.Reasons["ambiguous"] != 1 {
+		t.Errorf("%s summary: count %d, reasons %v", first, top.Count, top.Reasons)
+	}
+	for _, rep := range top.Reports {
+		if rep.ReportedAt.IsZero() || rep.Comment != "the dates look off" {
+			t.Errorf("stored report %+v lacks its timestamp or trimmed comment", rep)
+		}
+	}
+
+	if code := adminRequest(t, s, http.MethodDelete, "/api/admin/reports/"+first, "admin", testAdminPassword, nil); code != http.StatusNoContent {
+		t.Errorf("dismiss: status %d, want 204", code)
+	}
+	if summaries := adminReports(t, s); len(summaries) != 1 || summaries[0].QuestionID != second {
+		t.Errorf("after dismissing %s: %+v", first, summaries)
+	}
+	if code := adminRequest(t, s, http.MethodGet, "/api/admin/reports", "admin", "wrong password!", nil); code != http.StatusUnauthorized {
+		t.Errorf("admin view with a bad password: status %d, want 401", code)
+	}
+}
+
+func TestReportRejectsBadRequests(t *testing.T) {
+	s := startServer(t)
+	sess := startSession(t, s)
+	questions, _ := loadQuestions(staticFS, questionsFile)
+	id := questions[0].ID
+	if code := postJSON(t, s.url("/api/questions/"+id+"/report"), reportRequest{Reason: "typo"}, nil); code != http.StatusBadRequest {
+		t.Errorf("no session: status %d, want 400", code)
+	}
+	if code := reportQuestion(t, s, sess, "no-such-question", "typo"); code != http.StatusNotFound {
+		t.Errorf("unknown question: status %d, want 404", code)
+	}
+	if code := reportQuestion(t, s, sess, id, "boring"); code != http.StatusBadRequest {
+		t.Errorf("unknown reason: status %d, want 400", code)
+	}
+}
+
+func TestReportsRateLimited(t *testing.T) {
+	s := startServer(t)
+	questions, _ := loadQuestions(staticFS, questionsFile)
+	sess := startSession(t, s)
+	limited := false
+	for _, q := range questions[:reportBurst+2] {
+		if code := reportQuestion(t, s, sess, q.ID, "typo"); code == http.StatusTooManyRequests {
+			limited = true
+		}
+	}
+	if !limited {
+		t.Errorf("%d reports in a row were never limited", reportBurst+2)
+	}
+}
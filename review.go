@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// reviewNamespace holds each player's review schedule, keyed by player
+	// token.
+	reviewNamespace = "reviews"
+	// initialEase is the SM-2 ease factor of a card never reviewed.
+	initialEase = 2.5
+	// minEase is the floor SM-2 keeps the ease factor above.
+	minEase = 1.3
+	// passingQuality is the lowest grade that counts as recalled.
+	passingQuality = 3
+	// maxQuality is the best grade: a perfect, effortless answer.
+	maxQuality = 5
+)
+
+// reviewCard is the SM-2 scheduling state of one question for one player.
+type reviewCard struct {
+	Ease        float64   `json:"ease"`
+	Interval    int       `json:"interval"` // days
+	Repetitions int       `json:"repetitions"`
+	Due         time.Time `json:"due"`
+}
+
+// grade updates the card for an answer of the given quality (0-5) at now,
+// following SM-2: a passing grade stretches the interval by the ease
+// factor, and a failing one starts the card over at one day.
+func (c *reviewCard) grade(quality int, now time.Time) {
+	if c.Ease == 0 {
+		c.Ease = initialEase
+	}
+	if quality >= passingQuality {
+		switch c.Repetitions {
+		case 0:
+			c.Interval = 1
+		case 1:
+			c.Interval = 6
+		default:
+			c.Interval = int(math.Round(float64(c.Interval) * c.Ease))
+		}
+		c.Repetitions++
+	} else {
+		c.Repetitions = 0
+		c.Interval = 1
+	}
+	miss := float64(maxQuality - quality)
+	c.Ease = max(minEase, c.Ease+0.1-miss*(0.08+miss*0.02))
+	c.Due = now.UTC().AddDate(0, 0, c.Interval)
+}
+
+// reviewSchedule is a player's cards by question ID.
+type reviewSchedule map[string]reviewCard
+
+// reviews keeps per-player review schedules in a Store.
+type reviews struct {
+	mu    sync.Mutex // serializes read-modify-write updates
+	store Store
+}
+
+func newReviews(store Store) *reviews {
+	return &reviews{store: store}
+}
+
+// Load returns the schedule for token, which is empty for a new player.
+func (rv *reviews) Load(token string) (reviewSchedule, error) {
+	data, err := rv.store.Get(reviewNamespace, token)
+	if errors.Is(err, ErrNotFound) {
+		return reviewSchedule{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s reviewSchedule
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Grade records an answer of the given quality to question id by the
+// player with token and returns the question's updated card.
+func (rv *reviews) Grade(token, id string, quality int, now time.Time) (reviewCard, error) {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+	s, err := rv.Load(token)
+	if err != nil {
+		return reviewCard{}, err
+	}
+	card := s[id]
+	card.grade(quality, now)
+	s[id] = card
+	data, err := json.Marshal(s)
+	if err != nil {
+		return reviewCard{}, err
+	}
+	return card, rv.store.Set(reviewNamespace, token, data)
+}
+
+// reviewItem is a question due for review, with when it fell due.
+type reviewItem struct {
+	PublicQuestion
+	Due time.Time `json:"due"`
+}
+
+// reviewHandler serves GET /api/review?token=T&count=N, listing the
+// questions the player is due to review, most overdue first. As with
+// /api/questions, a session token shuffles the answers and records the
+// questions in scores as served. Questions are in
+// the negotiated language where a translation exists.
+func reviewHandler(banks map[string]*questionBank, langs *languageRegistry, rv *reviews, sess *sessions, scores *scorekeeper) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		query := r.URL.Query()
+		token := query.Get("token")
+		if !validToken.MatchString(token) {
+			writeAPIError(w, http.StatusBadRequest, "invalid or missing token")
+			return
+		}
+		count := defaultQuestionCount
+		if v := query.Get("count"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxQuestionCount {
+				writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("count must be between 1 and %d", maxQuestionCount))
+				return
+			}
+			count = n
+		}
+		sessionID, err := sess.fromQuery(r)
+		if err != nil {
+			writeAPIError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		s, err := rv.Load(token)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "could not load review schedule")
+			return
+		}
+
+		bank := localize(w, r, langs, banks)
+		now := time.Now()
+		due := []reviewItem{}
+		for id, card := range s {
+			q, ok := bank.Get(id)
+			if !ok || card.Due.After(now) {
+				continue
+			}
+			due = append(due, reviewItem{PublicQuestion: sess.shuffleAnswers(q.Public(), sessionID), Due: card.Due})
+		}
+		sort.Slice(due, func(i, j int) bool {
+			if !due[i].Due.Equal(due[j].Due) {
+				return due[i].Due.Before(due[j].Due)
+			}
+			return due[i].ID < due[j].ID
+		})
+		if len(due) > count {
+			due = due[:count]
+		}
+		if sessionID != "" {
+			ids := make([]string, len(due))
+			for i, item := range due {
+				ids[i] = item.ID
+			}
+			if err := scores.Serve(sessionID, ids, now); err != nil {
+				writeAPIError(w, http.StatusInternalServerError, "could not record the questions served")
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"questions": due})
+	})
+}
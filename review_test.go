@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReviewIntervalGrowsWhenRecalled(t *testing.T) {
+	now := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	var c reviewCard
+	var intervals []int
+	for i := 0; i < 4; i++ {
+		c.grade(4, now)
+		intervals = append(intervals, c.Interval)
+	}
+	for i := 1; i < len(intervals); i++ {
+		if intervals[i] <= intervals[i-1] {
+			t.Fatalf("intervals %v do not grow with each recall", intervals)
+		}
+	}
+	if intervals[0] != 1 || intervals[1] != 6 {
+		t.Errorf("first intervals %v, want SM-2's 1 and 6 days", intervals[:2])
+	}
+	if want := now.AddDate(0, 0, c.Interval); !c.Due.Equal(want) {
+		t.Errorf("due %v, want %v, one interval on", c.Due, want)
+	}
+}
+
+func TestReviewWrongAnswerResetsInterval(t *testing.T) {
+	now := time.Now()
+	var c reviewCard
+	for i := 0; i < 3; i++ {
+		c.grade(5, now)
+	}
+	ease := c.Ease
+	c.grade(1, now)
+	if c.Interval != 1 || c.Repetitions != 0 {
+		t.Errorf("after a wrong answer: interval %d, repetitions %d, want 1 and 0", c.Interval, c.Repetitions)
+	}
+	if c.Ease >= ease {
+		t.Errorf("ease %v did not drop from %v after a wrong answer", c.Ease, ease)
+	}
+	for i := 0; i < 20; i++ {
+		c.grade(0, now)
+	}
+	if c.Ease < minEase {
+		t.Errorf("ease %v fell below the floor %v", c.Ease, minEase)
+	}
+}
+
+func TestReviewListsOverdueQuestions(t *testing.T) {
+	const token = "review-player-1"
+	inTempDir(t)
+	store, err := newFileStore("store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rv := newReviews(store)
+	now := time.Now()
+	for id, answered := range map[string]time.Time{
+		"q001": now.AddDate(0, 0, -3),
+		"q002": now.AddDate(0, 0, -10),
+		"q003": now,
+	} {
+		if _, err := rv.Grade(token, id, 2, answered); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := startServerIn(t)
+
+	var body struct {
+		Questions []servedQuestion `json:"questions"`
+	}
+	if code := getJSON(t, s.url("/api/review?token="+token), &body); code != http.StatusOK {
+		t.Fatalf("/api/review: status %d", code)
+	}
+	var ids []string
+	for _, q := range body.Questions {
+		ids = append(ids, q.ID)
+	}
+	if want := []string{"q002", "q001"}; !slices.Equal(ids, want) {
+		t.Errorf("due questions %v, want the overdue ones, most overdue first: %v", ids, want)
+	}
+
+	// Grading an answer reschedules the question into the future.
+	questions, _ := loadQuestions(staticFS, questionsFile)
+	if code := postJSON(t, s.url("/api/answer?token="+token), map[string]any{"questionID": "q002", "choiceIndex": questions[1].CorrectAnswer, "quality": 5}, nil); code != http.StatusOK {
+		t.Fatalf("graded answer: status %d", code)
+	}
+	getJSON(t, s.url("/api/review?token="+token), &body)
+	if len(body.Questions) != 1 || body.Questions[0].ID != "q001" {
+		t.Errorf("after reviewing q002 the due questions are %+v, want only q001", body.Questions)
+	}
+}
+
+func TestReviewRejectsBadQuality(t *testing.T) {
+	s := startServer(t)
+	for _, body := range []map[string]any{
+		{"questionID": "q001", "choiceIndex": 0, "quality": 9},
+		{"questionID": "q001", "choiceIndex": 0, "quality": -1},
+	} {
+		if code := postJSON(t, s.url("/api/answer?token=review-player-2"), body, nil); code != http.StatusBadRequest {
+			t.Errorf("quality %v: status %d, want 400", body["quality"], code)
+		}
+	}
+}
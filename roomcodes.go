@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	// roomCodeAlphabet is what room codes are made of: upper-case letters
+	// and digits, less those easily mistaken for one another (0 and O, 1,
+	// I, and L).
+	roomCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+	// roomCodeLength is how many characters a room code has.
+	roomCodeLength = 6
+	// maxRoomCodes bounds how many private rooms may be reserved at once.
+	maxRoomCodes = 10000
+	// maxRoomCreateBody bounds a room creation body.
+	maxRoomCreateBody = 1 << 10
+	// roomCodeCollectInterval is how often expired codes are freed, and so
+	// how long a client turned away for want of codes is told to wait.
+	roomCodeCollectInterval = time.Minute
+)
+
+// validRoomCode is what a room code looks like. Room IDs of this form are
+// private rooms, which only a reserved code opens.
+var validRoomCode = regexp.MustCompile(`^[` + roomCodeAlphabet + `]{` + strconv.Itoa(roomCodeLength) + `}$`)
+
+var (
+	errRoomCodeUnknown = errors.New("room code is unknown or has expired")
+	errRoomFull        = errors.New("room is full")
+	errTooManyRooms    = errors.New("too many private rooms are open; try again later")
+)
+
+// roomCode is a private room reserved by its code: how many players it
+// holds, and since when it has been empty, or zero while anyone is in it.
+type roomCode struct {
+	capacity  int
+	idleSince time.Time
+}
+
+// newRoomCode returns a random code in roomCodeAlphabet.
+func newRoomCode() string {
+	code := make([]byte, 0, roomCodeLength)
+	var b [1]byte
+	for len(code) < roomCodeLength {
+		rand.Read(b[:])
+		// Bytes past the last whole multiple of the alphabet are skipped,
+		// so every character is equally likely.
+		if int(b[0]) < 256/len(roomCodeAlphabet)*len(roomCodeAlphabet) {
+			code = append(code, roomCodeAlphabet[int(b[0])%len(roomCodeAlphabet)])
+		}
+	}
+	return string(code)
+}
+
+// expired reports whether c, empty for the hub's codeTTL, has been
+// freed at now.
+func (h *hub) expired(c *roomCode, now time.Time) bool {
+	return !c.idleSince.IsZero() && now.Sub(c.idleSince) > h.codeTTL
+}
+
+// CreateRoom reserves a private room for up to capacity players and
+// returns its code, unique among the codes reserved and the rooms open.
+func (h *hub) CreateRoom(capacity int, now time.Time) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return "", errors.New("server is shutting down")
+	}
+	if len(h.codes) >= maxRoomCodes {
+		return "", errTooManyRooms
+	}
+	for {
+		code := newRoomCode()
+		if c, ok := h.codes[code]; ok && !h.expired(c, now) {
+			continue
+		}
+		if _, ok := h.rooms[code]; ok {
+			continue
+		}
+		h.codes[code] = &roomCode{capacity: capacity, idleSince: now}
+		return code, nil
+	}
+}
+
+// checkRoom returns why a player could not join room id now, if it is a
+// private room it could not join: its code is not reserved, it has
+// expired, or the room is full. It also returns the room's capacity. h.mu
+// must be held.
+func (h *hub) checkRoom(id string, now time.Time) (int, error) {
+	if !validRoomCode.MatchString(id) {
+		return h.capacity, nil
+	}
+	c, ok := h.codes[id]
+	if !ok || h.expired(c, now) {
+		return 0, errRoomCodeUnknown
+	}
+	if room := h.rooms[id]; room != nil && len(room.players) >= c.capacity {
+		return 0, errRoomFull
+	}
+	return c.capacity, nil
+}
+
+// CheckRoom is checkRoom for callers not holding h.mu.
+func (h *hub) CheckRoom(id string, now time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.checkRoom(id, now)
+	return err
+}
+
+// collectCodes frees the codes of private rooms that have stayed empty for
+// codeTTL, every interval until ctx is cancelled.
+func (h *hub) collectCodes(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.mu.Lock()
+			for code, c := range h.codes {
+				if h.expired(c, now) {
+					delete(h.codes, code)
+				}
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// roomCreateRequest is the optional body of POST /api/room/create.
+type roomCreateRequest struct {
+	Capacity int `json:"capacity"`
+}
+
+// roomCreated is the /api/room/create response: the code to connect to at
+// /ws/room/{code}, how many players the room holds, and when the code is
+// freed if no one has joined by then.
+type roomCreated struct {
+	Code     string    `json:"code"`
+	Capacity int       `json:"capacity"`
+	Expires  time.Time `json:"expires"`
+}
+
+// roomCreateHandler serves POST /api/room/create, reserving a private room
+// in h for the capacity asked for, from 2 to the hub's, or by default the
+// hub's.
+func roomCreateHandler(h *hub) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		req := roomCreateRequest{Capacity: h.capacity}
+		r.Body = http.MaxBytesReader(w, r.Body, maxRoomCreateBody)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeBodyError(w, err, "invalid JSON body")
+			return
+		}
+		if req.Capacity < 2 || req.Capacity > h.capacity {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("capacity must be between 2 and %d", h.capacity))
+			return
+		}
+		now := time.Now()
+		code, err := h.CreateRoom(req.Capacity, now)
+		if err != nil {
+			writeRetryAfter(w, http.StatusServiceUnavailable, err.Error(), roomCodeCollectInterval)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusCreated, roomCreated{Code: code, Capacity: req.Capacity, Expires: now.Add(h.codeTTL).UTC()})
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+func TestNewRoomCode(t *testing.T) {
+	seen := make(map[string]bool)
+	for range 1000 {
+		code := newRoomCode()
+		if !validRoomCode.MatchString(code) || strings.ContainsAny(code, "0O1IL") {
+			t.Fatalf("room code %q", code)
+		}
+		seen[code] = true
+	}
+	if len(seen) < 990 {
+		t.Errorf("1000 room codes held only %d distinct ones", len(seen))
+	}
+}
+
+func TestRoomCodesExpire(t *testing.T) {
+	h := newHub(nil, 4, 0, nil, nil, nil, nil, nil, nil, 0, time.Minute)
+	now := time.Now()
+	code, err := h.CreateRoom(2, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.CheckRoom(code, now.Add(time.Minute)); err != nil {
+		t.Errorf("within its TTL: %v", err)
+	}
+	if err := h.CheckRoom(code, now.Add(time.Minute+time.Second)); !errors.Is(err, errRoomCodeUnknown) {
+		t.Errorf("past its TTL: %v, want errRoomCodeUnknown", err)
+	}
+	if err := h.CheckRoom(strings.Repeat("A", roomCodeLength), now); !errors.Is(err, errRoomCodeUnknown) && code != "AAAAAA" {
+		t.Errorf("an unreserved code: %v, want errRoomCodeUnknown", err)
+	}
+	if err := h.CheckRoom("crypt", now); err != nil {
+		t.Errorf("a public room: %v", err)
+	}
+}
+
+// createRoom asks s for a private room holding capacity players, or the
+// default if zero, and returns the status and the room.
+func createRoom(t *testing.T, s *runningServer, capacity int) (int, roomCreated) {
+	t.Helper()
+	var body any = roomCreateRequest{Capacity: capacity}
+	if capacity == 0 {
+		body = struct{}{}
+	}
+	var room roomCreated
+	code := postJSON(t, s.url("/api/room/create"), body, &room)
+	return code, room
+}
+
+// dialStatus dials the WebSocket of room on s, which must be refused, and
+// returns the status it is refused with.
+func dialStatus(t *testing.T, s *runningServer, room string) int {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, resp, err := websocket.Dial(ctx, "ws://"+s.addr+"/ws/room/"+room, nil)
+	if err == nil {
+		conn.CloseNow()
+		t.Fatalf("joined room %s", room)
+	}
+	if resp == nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestPrivateRoomsJoinedByCode(t *testing.T) {
+	s := startServer(t, "-room-capacity", "4")
+	code, room := createRoom(t, s, 2)
+	if code != http.StatusCreated || !validRoomCode.MatchString(room.Code) || room.Capacity != 2 {
+		t.Fatalf("create: status %d, %+v", code, room)
+	}
+	if d := time.Until(room.Expires); d < 9*time.Minute || d > 10*time.Minute {
+		t.Errorf("code expires %v, want in 10m", room.Expires)
+	}
+	if code, room := createRoom(t, s, 0); code != http.StatusCreated || room.Capacity != 4 {
+		t.Errorf("default capacity: status %d, %+v", code, room)
+	}
+	for _, capacity := range []int{1, 5} {
+		if code, _ := createRoom(t, s, capacity); code != http.StatusBadRequest {
+			t.Errorf("capacity %d: status %d, want 400", capacity, code)
+		}
+	}
+	if code, _ := get(t, s.url("/api/room/create")); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want 405", code)
+	}
+
+	ada := joinRoom(t, s, room.Code, "ada")
+	ada.stateWith(1)
+	grace := joinRoom(t, s, room.Code, "grace")
+	for _, p := range []*wsPlayer{ada, grace} {
+		if state := p.stateWith(2); state.Room != room.Code {
+			t.Errorf("state of room %q, want %q", state.Room, room.Code)
+		}
+	}
+	if status := dialStatus(t, s, room.Code); status != http.StatusConflict {
+		t.Errorf("a third player: status %d, want 409", status)
+	}
+	unknown := "ABCDEF"
+	if unknown == room.Code {
+		unknown = "ABCDEG"
+	}
+	if status := dialStatus(t, s, unknown); status != http.StatusNotFound {
+		t.Errorf("an unknown code: status %d, want 404", status)
+	}
+	if p := presenceOf(t, s); p.Connections != 2 || len(p.Rooms) != 0 {
+		t.Errorf("presence %+v lists the private room", p)
+	}
+}
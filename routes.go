@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// unmatchedRoute is the route label of a request no registered route
+// answered: one for a path no pattern matches, or one the static file
+// catch-all has no file for. Labelling them all alike keeps scanners and
+// typos from adding a label each.
+const unmatchedRoute = "unmatched"
+
+// routeKey is the context key for a request's *routeMatch.
+type routeKey struct{}
+
+// routeMatch is the route a request was served by: the ServeMux pattern
+// that matched, such as /api/v1/maps/{id}, and whether the handler found
+// nothing there after all.
+type routeMatch struct {
+	pattern  string
+	notFound bool
+}
+
+// matchRoutes gives each request a routeMatch for routeMux to fill in, so
+// that middleware wrapping the mux can label the request by its route
+// template rather than its path, whatever copies of the request are made
+// on the way in.
+func matchRoutes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), routeKey{}, &routeMatch{})))
+	})
+}
+
+// routeMux serves requests with mux, recording the pattern that matched,
+// even if the handler panics.
+func routeMux(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if m, ok := r.Context().Value(routeKey{}).(*routeMatch); ok {
+				m.pattern = r.Pattern
+			}
+		}()
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// routeNotFound marks the route of the request ctx belongs to as
+// unmatched, for a catch-all pattern that found nothing to serve.
+func routeNotFound(ctx context.Context) {
+	if m, ok := ctx.Value(routeKey{}).(*routeMatch); ok {
+		m.notFound = true
+	}
+}
+
+// requestRoute returns the route template of the request ctx belongs to,
+// or unmatchedRoute if it has none.
+func requestRoute(ctx context.Context) string {
+	m, ok := ctx.Value(routeKey{}).(*routeMatch)
+	if !ok || m.pattern == "" || m.notFound {
+		return unmatchedRoute
+	}
+	return m.pattern
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestRequestRoute(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/maps/{id}", func(http.ResponseWriter, *http.Request) {})
+	mux.Handle("/", themedNotFound(http.NotFoundHandler()))
+	h := matchRoutes(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routeMux(mux).ServeHTTP(w, r)
+		w.Header().Set("X-Route", requestRoute(r.Context()))
+	}))
+	for path, want := range map[string]string{
+		"/api/maps/abc": "/api/maps/{id}",
+		"/api/maps/xyz": "/api/maps/{id}",
+		"/no-such-file": unmatchedRoute,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if got := w.Header().Get("X-Route"); got != want {
+			t.Errorf("%s: route %q, want %q", path, got, want)
+		}
+	}
+	if got := requestRoute(context.Background()); got != unmatchedRoute {
+		t.Errorf("a request never routed: %q, want %q", got, unmatchedRoute)
+	}
+}
+
+func TestMetricsLabelRouteTemplates(t *testing.T) {
+	s := startServer(t, "-metrics")
+	for _, path := range []string{"/api/maps/abc", "/api/maps/xyz", "/no/such/page"} {
+		get(t, s.url(path))
+	}
+	_, scrape := get(t, s.url("/metrics"))
+	if n := requestCount(scrape, `/api/maps/\{id\}`, http.StatusNotFound); n != 2 {
+		t.Errorf("maps counted %d times under their template, want 2:\n%s", n, scrape)
+	}
+	if n := requestCount(scrape, unmatchedRoute, http.StatusNotFound); n != 1 {
+		t.Errorf("unknown page counted %d times under %q, want 1", n, unmatchedRoute)
+	}
+	if regexp.MustCompile(`route="[^"]*(abc|xyz)"`).MatchString(scrape) {
+		t.Error("a concrete path was used as a label")
+	}
+
+	var routes []string
+	s.logs.mu.Lock()
+	for _, r := range s.logs.records {
+		r.Attrs(func(a slog.Attr) bool {
+			if r.Message == "request" && a.Key == "route" {
+				routes = append(routes, a.Value.String())
+			}
+			return true
+		})
+	}
+	s.logs.mu.Unlock()
+	if len(routes) < 3 || routes[0] != routes[1] || routes[0] == unmatchedRoute || routes[2] != unmatchedRoute {
+		t.Errorf("logged routes %v, want the maps template twice and then %q", routes, unmatchedRoute)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// maxSaveBody bounds a single saved game-state upload.
+const maxSaveBody = 64 << 10
+
+// savesNamespace holds one JSON-encoded GameState per player token.
+const savesNamespace = "saves"
+
+// validToken matches player tokens, which are also used as Store keys.
+var validToken = regexp.MustCompile(`^[A-Za-z0-9_-]{8,64}$`)
+
+// GameState is the progress a player saves and restores.
+type GameState struct {
+	Room              string    `json:"room"`
+	Score             int       `json:"score"`
+	AnsweredQuestions []string  `json:"answeredQuestions"`
+	Achievements      []string  `json:"achievements"`
+	SavedAt           time.Time `json:"savedAt"`
+}
+
+// validate checks a state received from a client.
+func (s *GameState) validate() error {
+	if s.Room == "" {
+		return errors.New("room is required")
+	}
+	if s.Score < 0 {
+		return errors.New("score must not be negative")
+	}
+	for _, id := range s.AnsweredQuestions {
+		if id == "" {
+			return errors.New("answeredQuestions must not contain empty IDs")
+		}
+	}
+	for _, id := range s.Achievements {
+		if id == "" {
+			return errors.New("achievements must not contain empty IDs")
+		}
+	}
+	return nil
+}
+
+// saveHandler serves POST /api/save?token=T with a GameState body.
+func saveHandler(store Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		token := r.URL.Query().Get("token")
+		if !validToken.MatchString(token) {
+			writeAPIError(w, http.StatusBadRequest, "invalid or missing token")
+			return
+		}
+		var state GameState
+		r.Body = http.MaxBytesReader(w, r.Body, maxSaveBody)
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&state); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeAPIError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("save must be at most %d bytes", tooLarge.Limit))
+				return
+			}
+			writeAPIError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		if err := state.validate(); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		state.SavedAt = time.Now().UTC()
+		data, err := json.Marshal(state)
+		if err == nil {
+			err = traceStore(r.Context(), store).Set(savesNamespace, token, data)
+		}
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "could not save game")
+			return
+		}
+		writeJSON(w, http.StatusOK, state)
+	})
+}
+
+// loadHandler serves GET /api/load?token=T.
+func loadHandler(store Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		token := r.URL.Query().Get("token")
+		if !validToken.MatchString(token) {
+			writeAPIError(w, http.StatusBadRequest, "invalid or missing token")
+			return
+		}
+		data, err := traceStore(r.Context(), store).Get(savesNamespace, token)
+		if errors.Is(err, ErrNotFound) {
+			writeAPIError(w, http.StatusNotFound, "no save found")
+			return
+		}
+		var state GameState
+		if err == nil {
+			err = json.Unmarshal(data, &state)
+		}
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "could not load game")
+			return
+		}
+		writeJSON(w, http.StatusOK, state)
+	})
+}
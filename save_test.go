@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSaveToken = "player-token-1"
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	store := newMemoryStore()
+	save, load := saveHandler(store), loadHandler(store)
+
+	want := GameState{Room: "library", Score: 350, AnsweredQuestions: []string{"q001", "q007"}, Achievements: []string{"first_steps"}}
+	body, _ := json.Marshal(want)
+	w := httptest.NewRecorder()
+	save.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/save?token="+testSaveToken, strings.NewReader(string(body))))
+	if w.Code != http.StatusOK {
+		t.Fatalf("save: status %d: %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	load.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/load?token="+testSaveToken, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("load: status %d: %s", w.Code, w.Body)
+	}
+	var got GameState
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.SavedAt.IsZero() {
+		t.Error("loaded state has no save time")
+	}
+	got.SavedAt = want.SavedAt
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded %+v, saved %+v", got, want)
+	}
+}
+
+func TestLoadWithoutSave(t *testing.T) {
+	w := httptest.NewRecorder()
+	loadHandler(newMemoryStore()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/load?token="+testSaveToken, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("load with no save: status %d, want 404", w.Code)
+	}
+}
+
+func TestSaveRejectsBadRequests(t *testing.T) {
+	tests := []struct {
+		name, token, body string
+		want              int
+	}{
+		{"malformed JSON", testSaveToken, `{"room": "library",`, http.StatusBadRequest},
+		{"unknown field", testSaveToken, `{"room": "library", "cheat": true}`, http.StatusBadRequest},
+		{"missing room", testSaveToken, `{"score": 10}`, http.StatusBadRequest},
+		{"negative score", testSaveToken, `{"room": "library", "score": -1}`, http.StatusBadRequest},
+		{"empty question ID", testSaveToken, `{"room": "library", "answeredQuestions": [""]}`, http.StatusBadRequest},
+		{"bad token", "no", `{"room": "library"}`, http.StatusBadRequest},
+		{"oversized body", testSaveToken, `{"room": "library", "answeredQuestions": ["` + strings.Repeat("q", maxSaveBody) + `"]}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		store := newMemoryStore()
+		w := httptest.NewRecorder()
+		saveHandler(store).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/save?token="+tt.token, strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if keys, _ := store.List(savesNamespace); len(keys) != 0 {
+			t.Errorf("%s: a rejected save was stored", tt.name)
+		}
+	}
+}
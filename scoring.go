@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scoreNamespace holds the running score of each game session, keyed by
+// session ID.
+const scoreNamespace = "session-scores"
+
+// ScoringRules are how the server scores the answers of a session. A
+// correct answer earns
+//
+//	round((points + TimeBonus × (TimeBonusWindow − elapsed) / TimeBonusWindow) × streak)
+//
+// where the time bonus only applies to answers given within
+// TimeBonusWindow of the question being served, and streak is
+// 1 + StreakStep for each correct answer in a row before it, capped at
+// StreakMax. A wrong answer earns nothing and ends the streak, and only
+// the first answer to each question counts, unless MaxAttempts allows the
+// session that many wrong answers to it before it is locked. Hints are
+// deducted from the total at their own penalty.
+type ScoringRules struct {
+	TimeBonus       int
+	TimeBonusWindow time.Duration
+	StreakStep      float64
+	StreakMax       float64
+	// MaxAttempts is how many wrong answers a session may give to one
+	// question before further answers to it are refused. A correct answer
+	// before then still scores. Zero counts only the first answer.
+	MaxAttempts int
+	// RevealLocked reveals the explanation of a question once it is
+	// locked.
+	RevealLocked bool
+	// EnforceTimeLimits times out an answer a session gives later than the
+	// question's time limit after it was first served, plus TimeLimitGrace
+	// for the trip over the network, or to a question it was never
+	// served: the answer counts as wrong, earns nothing, and closes the
+	// question. A question without a timeLimit of
+	// its own takes the one DifficultyTimeLimits, a comma-separated list of
+	// difficulty=duration entries, gives its difficulty, if any.
+	EnforceTimeLimits    bool
+	TimeLimitGrace       time.Duration
+	DifficultyTimeLimits string
+}
+
+// parseDifficultyTimeLimits returns the time limit of each difficulty in
+// spec, a comma-separated list of difficulty=duration entries such as
+// "easy=30s,hard=20s".
+func parseDifficultyTimeLimits(spec string) (map[string]time.Duration, error) {
+	limits := make(map[string]time.Duration)
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not difficulty=duration", entry)
+		}
+		if !difficulties[name] {
+			return nil, fmt.Errorf("unknown difficulty %q", name)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%q is not a positive duration", value)
+		}
+		limits[name] = d
+	}
+	return limits, nil
+}
+
+// points returns what a correct answer to a question worth base points
+// earns, given after elapsed (0 if not known) as the streak'th correct
+// answer in a row.
+func (r ScoringRules) points(base int, elapsed time.Duration, streak int) int {
+	var bonus float64
+	if elapsed > 0 && elapsed < r.TimeBonusWindow {
+		bonus = float64(r.TimeBonus) * float64(r.TimeBonusWindow-elapsed) / float64(r.TimeBonusWindow)
+	}
+	multiplier := min(1+r.StreakStep*float64(streak-1), r.StreakMax)
+	return int(math.Round((float64(base) + bonus) * multiplier))
+}
+
+// errQuestionLocked reports an answer to a question its session has
+// answered wrongly as many times as ScoringRules.MaxAttempts allows.
+var errQuestionLocked = errors.New("too many wrong answers to this question")
+
+// sessionScore is the stored running score of a session: the points its
+// answers earned, the IDs of the questions answered, how many were
+// right, the current streak of correct answers, the wrong answers given
+// to each question under an attempt limit, and when each question was
+// first served to the session.
+type sessionScore struct {
+	Points   int                  `json:"points"`
+	Answered map[string]bool      `json:"answered"`
+	Correct  int                  `json:"correct"`
+	Streak   int                  `json:"streak"`
+	Misses   map[string]int       `json:"misses,omitempty"`
+	Served   map[string]time.Time `json:"served,omitempty"`
+	Expires  time.Time            `json:"expires"`
+}
+
+// answerOutcome is what an answer did to its session's score: the points
+// it earned and, under an attempt limit, the wrong answers still allowed
+// at its question, or whether it is now locked, and whether it came too
+// late.
+type answerOutcome struct {
+	Earned       int
+	AttemptsLeft int
+	Locked       bool
+	TimedOut     bool
+}
+
+// scoreSnapshot is a session's score as the server computed it. Score is
+// the points less the hint penalty. The signature covers every other
+// field, so that the client can show the snapshot but not alter it.
+type scoreSnapshot struct {
+	SessionID string    `json:"sessionID"`
+	Score     int       `json:"score"`
+	Points    int       `json:"points"`
+	Penalty   int       `json:"penalty"`
+	Answered  int       `json:"answered"`
+	Correct   int       `json:"correct"`
+	Streak    int       `json:"streak"`
+	IssuedAt  time.Time `json:"issuedAt"`
+	Signature string    `json:"signature"`
+}
+
+// snapshotSignature returns the hex HMAC of snap's fields under the server
+// secret.
+func (s *sessions) snapshotSignature(snap scoreSnapshot) string {
+	return hex.EncodeToString(s.mac("score-snapshot", snap.SessionID,
+		strconv.Itoa(snap.Score), strconv.Itoa(snap.Points), strconv.Itoa(snap.Penalty),
+		strconv.Itoa(snap.Answered), strconv.Itoa(snap.Correct), strconv.Itoa(snap.Streak),
+		snap.IssuedAt.Format(time.RFC3339Nano)))
+}
+
+// scorekeeper keeps the running score of each session in a Store as its
+// answers are graded.
+type scorekeeper struct {
+	mu    sync.Mutex // serializes read-modify-write updates
+	store Store
+	rules ScoringRules
+	// limits are the parsed rules.DifficultyTimeLimits.
+	limits map[string]time.Duration
+	hints  *hintLedger
+	sess   *sessions
+}
+
+func newScorekeeper(store Store, rules ScoringRules, hints *hintLedger, sess *sessions) *scorekeeper {
+	// The configuration was validated, so the limits parse.
+	limits, _ := parseDifficultyTimeLimits(rules.DifficultyTimeLimits)
+	return &scorekeeper{store: store, rules: rules, limits: limits, hints: hints, sess: sess}
+}
+
+// load returns the score of session id, empty for a new session.
+func (k *scorekeeper) load(id string) (sessionScore, error) {
+	sc := sessionScore{Answered: map[string]bool{}}
+	data, err := k.store.Get(scoreNamespace, id)
+	if errors.Is(err, ErrNotFound) {
+		return sc, nil
+	}
+	if err != nil {
+		return sc, err
+	}
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return sc, err
+	}
+	if sc.Answered == nil {
+		sc.Answered = map[string]bool{}
+	}
+	return sc, nil
+}
+
+// locked reports whether sc has used up its wrong answers to question qid.
+func (k *scorekeeper) locked(sc sessionScore, qid string) bool {
+	return k.rules.MaxAttempts > 0 && sc.Misses[qid] >= k.rules.MaxAttempts
+}
+
+// Locked reports whether session id may no longer answer question qid.
+func (k *scorekeeper) Locked(id, qid string) (bool, error) {
+	sc, err := k.load(id)
+	return err == nil && k.locked(sc, qid), err
+}
+
+// Serve records that session id was served the questions qids at now.
+// Only the first serve of each question counts, so fetching it again does
+// not restart its time limit.
+func (k *scorekeeper) Serve(id string, qids []string, now time.Time) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	sc, err := k.load(id)
+	if err != nil {
+		return err
+	}
+	if sc.Served == nil {
+		sc.Served = map[string]time.Time{}
+	}
+	changed := false
+	for _, qid := range qids {
+		if _, ok := sc.Served[qid]; !ok {
+			sc.Served[qid] = now.UTC()
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if sc.Expires.IsZero() {
+		sc.Expires = now.Add(sessionTTL).UTC()
+	}
+	data, err := json.Marshal(sc)
+	if err != nil {
+		return err
+	}
+	return k.store.Set(scoreNamespace, id, data)
+}
+
+// Served returns when session id was first served question qid, and
+// whether it ever was.
+func (k *scorekeeper) Served(id, qid string) (time.Time, bool, error) {
+	sc, err := k.load(id)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	at, ok := sc.Served[qid]
+	return at, ok, nil
+}
+
+// TimedOut reports whether an answer to q given elapsed after it was
+// first served came too late to count. Under enforced limits, an answer
+// to a timed question that was never served, as served reports, always
+// does.
+func (k *scorekeeper) TimedOut(q *Question, elapsed time.Duration, served bool) bool {
+	if !k.rules.EnforceTimeLimits {
+		return false
+	}
+	limit := time.Duration(q.TimeLimit) * time.Second
+	if limit == 0 {
+		limit = k.limits[q.Difficulty]
+	}
+	return limit > 0 && (!served || elapsed > limit+k.rules.TimeLimitGrace)
+}
+
+// Record scores an answer to q in session id, given elapsed after q was
+// first served. An answer to a question the session has already answered
+// earns nothing; one to a question it is locked out of fails with
+// errQuestionLocked. One that timed out, or that answers a timed question
+// never served to the session, earns nothing and closes the question.
+func (k *scorekeeper) Record(id string, q *Question, correct bool, elapsed time.Duration, now time.Time) (answerOutcome, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	sc, err := k.load(id)
+	if err != nil {
+		return answerOutcome{}, err
+	}
+	if k.locked(sc, q.ID) {
+		return answerOutcome{Locked: true}, errQuestionLocked
+	}
+	if sc.Answered[q.ID] {
+		return answerOutcome{}, nil
+	}
+	var out answerOutcome
+	_, served := sc.Served[q.ID]
+	switch {
+	case k.TimedOut(q, elapsed, served):
+		sc.Answered[q.ID] = true
+		sc.Streak = 0
+		out.TimedOut = true
+	case correct:
+		sc.Answered[q.ID] = true
+		sc.Correct++
+		sc.Streak++
+		out.Earned = k.rules.points(q.Points, elapsed, sc.Streak)
+		sc.Points += out.Earned
+	case k.rules.MaxAttempts > 0:
+		sc.Streak = 0
+		if sc.Misses == nil {
+			sc.Misses = map[string]int{}
+		}
+		sc.Misses[q.ID]++
+		out.AttemptsLeft = k.rules.MaxAttempts - sc.Misses[q.ID]
+		if out.AttemptsLeft == 0 {
+			sc.Answered[q.ID] = true
+			out.Locked = true
+		}
+	default:
+		sc.Answered[q.ID] = true
+		sc.Streak = 0
+	}
+	if sc.Expires.IsZero() {
+		sc.Expires = now.Add(sessionTTL).UTC()
+	}
+	data, err := json.Marshal(sc)
+	if err != nil {
+		return answerOutcome{}, err
+	}
+	return out, k.store.Set(scoreNamespace, id, data)
+}
+
+// Snapshot returns the signed score of session id at now.
+func (k *scorekeeper) Snapshot(id string, now time.Time) (scoreSnapshot, error) {
+	sc, err := k.load(id)
+	if err != nil {
+		return scoreSnapshot{}, err
+	}
+	penalty, err := k.hints.Penalty(id)
+	if err != nil {
+		return scoreSnapshot{}, err
+	}
+	snap := scoreSnapshot{
+		SessionID: id,
+		Score:     max(0, sc.Points-penalty),
+		Points:    sc.Points,
+		Penalty:   penalty,
+		Answered:  len(sc.Answered),
+		Correct:   sc.Correct,
+		Streak:    sc.Streak,
+		IssuedAt:  now.UTC(),
+	}
+	snap.Signature = k.sess.snapshotSignature(snap)
+	return snap, nil
+}
+
+// collect deletes the scores of sessions whose tokens have expired, every
+// interval until ctx is cancelled.
+func (k *scorekeeper) collect(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			ids, err := k.store.List(scoreNamespace)
+			if err != nil {
+				slog.Warn("could not list session scores", "err", err)
+				continue
+			}
+			k.mu.Lock()
+			for _, id := range ids {
+				if sc, err := k.load(id); err == nil && now.After(sc.Expires) {
+					k.store.Delete(scoreNamespace, id)
+				}
+			}
+			k.mu.Unlock()
+		}
+	}
+}
+
+// scoreHandler serves GET /api/score?session=S, the signed running score
+// of a game session.
+func scoreHandler(scores *scorekeeper, sess *sessions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		sessionID, err := sess.fromQuery(r)
+		if err != nil {
+			writeAPIError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if sessionID == "" {
+			writeAPIError(w, http.StatusBadRequest, "session is required")
+			return
+		}
+		snap, err := scores.Snapshot(sessionID, time.Now())
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "could not load score")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, snap)
+	})
+}
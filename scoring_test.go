@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRules are scoring rules with round numbers for the tests to work
+// scores out by hand.
+var testRules = ScoringRules{TimeBonus: 50, TimeBonusWindow: 10 * time.Second, StreakStep: 0.5, StreakMax: 2}
+
+func TestScoringRulesPoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    int
+		elapsed time.Duration
+		streak  int
+		want    int
+	}{
+		{"instant timing unknown", 100, 0, 1, 100},
+		{"fast", 100, 2 * time.Second, 1, 140},
+		{"half the window", 100, 5 * time.Second, 1, 125},
+		{"past the window", 100, 12 * time.Second, 1, 100},
+		{"second in a row", 100, 5 * time.Second, 2, 188},
+		{"streak capped", 100, 0, 9, 200},
+	}
+	for _, tt := range tests {
+		if got := testRules.points(tt.base, tt.elapsed, tt.streak); got != tt.want {
+			t.Errorf("%s: points(%d, %v, %d) = %d, want %d", tt.name, tt.base, tt.elapsed, tt.streak, got, tt.want)
+		}
+	}
+}
+
+func TestScorekeeperScoresTimedAnswersWithHints(t *testing.T) {
+	store := newMemoryStore()
+	sess := newSessions(testSecret, store, 0)
+	hints := newHintLedger(store, 10)
+	k := newScorekeeper(store, testRules, hints, sess)
+	now := time.Now()
+
+	answers := []struct {
+		id      string
+		points  int
+		correct bool
+		elapsed time.Duration
+	}{
+		{"q1", 100, true, 2 * time.Second}, // 100 + 40
+		{"q2", 100, true, 5 * time.Second}, // (100 + 25) × 1.5
+		{"q3", 100, false, time.Second},    // nothing, and the streak ends
+		{"q4", 50, true, 12 * time.Second}, // no bonus, no streak
+		{"q5", 100, true, 0},               // timing unknown: 100 × 1.5
+	}
+	for _, a := range answers {
+		if _, err := k.Record("s1", &Question{ID: a.id, Points: a.points}, a.correct, a.elapsed, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for range 2 {
+		if _, _, err := hints.Take("s1", "q3", 3, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snap, err := k.Snapshot("s1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := scoreSnapshot{SessionID: "s1", Score: 508, Points: 528, Penalty: 20, Answered: 5, Correct: 4, Streak: 2, IssuedAt: now.UTC()}
+	want.Signature = snap.Signature
+	if snap != want {
+		t.Errorf("snapshot = %+v, want %+v", snap, want)
+	}
+	if snap.Signature != sess.snapshotSignature(want) {
+		t.Error("snapshot signature does not cover its fields")
+	}
+	forged := snap
+	forged.Score = 9999
+	if sess.snapshotSignature(forged) == snap.Signature {
+		t.Error("altering the score kept the signature valid")
+	}
+
+	// Penalties never take a score below zero.
+	for range 3 {
+		hints.Take("s2", "q1", 3, now)
+	}
+	if snap, _ := k.Snapshot("s2", now); snap.Score != 0 || snap.Penalty != 30 {
+		t.Errorf("hints with no points: score %d, penalty %d, want 0 and 30", snap.Score, snap.Penalty)
+	}
+}
+
+func TestLeaderboardUsesServerScore(t *testing.T) {
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := questions[0]
+	if q.Points <= 0 {
+		t.Fatalf("question %s needs points for this test", q.ID)
+	}
+	s := startServer(t, "-min-run-time", "0s", "-session-secret", string(testSecret), "-time-bonus", "0")
+	sess := startSession(t, s)
+	choice := slices.Index(shownQuestions(t, s, sess)[q.ID], q.Answers[q.CorrectAnswer])
+
+	var resp answerResponse
+	if code := postJSON(t, s.url("/api/answer?session="+url.QueryEscape(sess.Token)), map[string]any{"questionID": q.ID, "choiceIndex": choice}, &resp); code != http.StatusOK {
+		t.Fatalf("answer: status %d", code)
+	}
+	if resp.Score == nil || resp.Score.Score != q.Points || resp.Score.Correct != 1 {
+		t.Fatalf("answer carried score %+v, want %d points for one correct answer", resp.Score, q.Points)
+	}
+	var snap scoreSnapshot
+	if code := getJSON(t, s.url("/api/score?session="+url.QueryEscape(sess.Token)), &snap); code != http.StatusOK {
+		t.Fatalf("/api/score: status %d", code)
+	}
+	if snap.Score != q.Points || snap.Signature == "" {
+		t.Errorf("/api/score = %+v, want a signed score of %d", snap, q.Points)
+	}
+	if code, _ := get(t, s.url("/api/score")); code != http.StatusBadRequest {
+		t.Errorf("/api/score without a session: status %d, want 400", code)
+	}
+	if code, _ := get(t, s.url("/api/score?session=forged")); code != http.StatusForbidden {
+		t.Errorf("/api/score with a forged session: status %d, want 403", code)
+	}
+
+	if code := submitScore(t, s, sess, "ada", 99999, 1000); code != http.StatusCreated {
+		t.Fatalf("submission: status %d", code)
+	}
+	var board struct {
+		Entries []LeaderboardEntry `json:"entries"`
+	}
+	getJSON(t, s.url("/api/leaderboard"), &board)
+	if len(board.Entries) != 1 || board.Entries[0].Score != q.Points {
+		t.Errorf("leaderboard %+v, want ada's claimed 99999 replaced by %d", board.Entries, q.Points)
+	}
+}
+
+func TestScorekeeperLocksQuestionAfterMaxAttempts(t *testing.T) {
+	store := newMemoryStore()
+	rules := testRules
+	rules.TimeBonus, rules.MaxAttempts = 0, 2
+	k := newScorekeeper(store, rules, newHintLedger(store, 10), newSessions(testSecret, store, 0))
+	now := time.Now()
+	q := &Question{ID: "q1", Points: 100}
+
+	for left := 1; left >= 0; left-- {
+		out, err := k.Record("s1", q, false, 0, now)
+		if err != nil || out.Earned != 0 || out.AttemptsLeft != left || out.Locked != (left == 0) {
+			t.Fatalf("wrong answer: %+v, %v; want %d attempts left", out, err, left)
+		}
+	}
+	if locked, err := k.Locked("s1", q.ID); err != nil || !locked {
+		t.Errorf("Locked = %v, %v after two wrong answers", locked, err)
+	}
+	if out, err := k.Record("s1", q, true, 0, now); !errors.Is(err, errQuestionLocked) || out.Earned != 0 {
+		t.Errorf("a correct answer once locked: %+v, %v; want errQuestionLocked", out, err)
+	}
+
+	// Within the limit, a correct answer still scores, and only once.
+	if out, _ := k.Record("s2", q, false, 0, now); out.AttemptsLeft != 1 {
+		t.Errorf("first wrong answer left %d attempts, want 1", out.AttemptsLeft)
+	}
+	if out, err := k.Record("s2", q, true, 0, now); err != nil || out.Earned != 100 || out.Locked {
+		t.Errorf("correct second attempt: %+v, %v; want 100 points", out, err)
+	}
+	if out, _ := k.Record("s2", q, true, 0, now); out.Earned != 0 {
+		t.Errorf("an answer after the correct one earned %d", out.Earned)
+	}
+	if locked, _ := k.Locked("s3", q.ID); locked {
+		t.Error("a new session is locked out")
+	}
+}
+
+func TestAnswerLockedOutAfterMaxAttempts(t *testing.T) {
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := startServer(t, "-time-bonus", "0", "-max-answer-attempts", "2")
+	sess := startSession(t, s)
+	// answer answers q with its right or a wrong choice as sess was shown
+	// it.
+	answer := func(q Question, right bool) answerResponse {
+		t.Helper()
+		shown := shownQuestions(t, s, sess)[q.ID]
+		choice := slices.Index(shown, q.Answers[q.CorrectAnswer])
+		if !right {
+			choice = (choice + 1) % len(shown)
+		}
+		var resp answerResponse
+		if code := postJSON(t, s.url("/api/answer?session="+url.QueryEscape(sess.Token)), map[string]any{"questionID": q.ID, "choiceIndex": choice}, &resp); code != http.StatusOK {
+			t.Fatalf("answer: status %d", code)
+		}
+		return resp
+	}
+
+	q := questions[0]
+	if resp := answer(q, false); resp.Correct || resp.Locked || resp.AttemptsLeft != 1 || resp.Explanation != "" {
+		t.Errorf("first wrong answer: %+v, want one attempt left and no explanation", resp)
+	}
+	if resp := answer(q, false); !resp.Locked || resp.AttemptsLeft != 0 {
+		t.Errorf("second wrong answer: %+v, want locked", resp)
+	}
+	if resp := answer(q, true); resp.Correct || !resp.Locked || resp.Explanation != "" || resp.Score == nil || resp.Score.Score != 0 {
+		t.Errorf("the right answer once locked: %+v, want it refused ungraded", resp)
+	}
+
+	s.stop(t)
+	s = startServer(t, "-time-bonus", "0", "-max-answer-attempts", "2", "-reveal-locked-explanation")
+	sess = startSession(t, s)
+	answer(q, false)
+	if resp := answer(q, true); !resp.Correct || resp.Score == nil || resp.Score.Score != q.Points {
+		t.Errorf("the right answer within the limit: %+v, want %d points", resp, q.Points)
+	}
+	q = questions[1]
+	answer(q, false)
+	if resp := answer(q, false); !resp.Locked || resp.Explanation != q.Explanation {
+		t.Errorf("locked with reveal: %+v, want the explanation", resp)
+	}
+}
+
+func TestParseDifficultyTimeLimits(t *testing.T) {
+	limits, err := parseDifficultyTimeLimits(" easy=30s, hard=1m ,")
+	if err != nil || len(limits) != 2 || limits["easy"] != 30*time.Second || limits["hard"] != time.Minute {
+		t.Errorf("limits %v, %v", limits, err)
+	}
+	for _, spec := range []string{"easy", "trivial=10s", "easy=soon", "easy=0s", "hard=-5s"} {
+		if _, err := parseDifficultyTimeLimits(spec); err == nil {
+			t.Errorf("%q was accepted", spec)
+		}
+	}
+	if _, err := loadConfig([]string{"-difficulty-time-limits", "easy=soon"}, noEnv); err == nil || !strings.Contains(err.Error(), "difficulty-time-limits") {
+		t.Errorf("an invalid time limit in the config: %v", err)
+	}
+}
+
+func TestScorekeeperTimesOutLateAnswers(t *testing.T) {
+	store := newMemoryStore()
+	rules := testRules
+	rules.TimeBonus, rules.EnforceTimeLimits, rules.TimeLimitGrace, rules.DifficultyTimeLimits = 0, true, 2*time.Second, "easy=10s"
+	k := newScorekeeper(store, rules, newHintLedger(store, 10), newSessions(testSecret, store, 0))
+	own := &Question{ID: "q1", Points: 100, Difficulty: "hard", TimeLimit: 5}
+	easy := &Question{ID: "q2", Points: 100, Difficulty: "easy"}
+	free := &Question{ID: "q3", Points: 100, Difficulty: "medium"}
+	tests := []struct {
+		q       *Question
+		elapsed time.Duration
+		served  bool
+		want    bool
+	}{
+		{own, 7 * time.Second, true, false}, // within the grace
+		{own, 7*time.Second + 1, true, true},
+		{easy, 11 * time.Second, true, false},
+		{easy, 13 * time.Second, true, true},
+		{free, time.Hour, true, false}, // no limit
+		{own, 0, false, true},          // never served
+		{free, 0, false, false},
+	}
+	for _, tt := range tests {
+		if got := k.TimedOut(tt.q, tt.elapsed, tt.served); got != tt.want {
+			t.Errorf("TimedOut(%s, %v, %v) = %v, want %v", tt.q.ID, tt.elapsed, tt.served, got, tt.want)
+		}
+	}
+
+	now := time.Now()
+	if err := k.Serve("s1", []string{own.ID, easy.ID}, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Serve("s1", []string{own.ID}, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if served, ok, err := k.Served("s1", own.ID); err != nil || !ok || !served.Equal(now) {
+		t.Errorf("Served(%s) = %v, %v, %v; want the first serve at %v", own.ID, served, ok, err, now)
+	}
+	if out, err := k.Record("s1", easy, true, 20*time.Second, now); err != nil || !out.TimedOut || out.Earned != 0 {
+		t.Errorf("a late right answer: %+v, %v; want it timed out for nothing", out, err)
+	}
+	if out, _ := k.Record("s1", easy, true, time.Second, now); out.Earned != 0 {
+		t.Errorf("answering a timed-out question again earned %d", out.Earned)
+	}
+	if out, err := k.Record("s1", own, true, time.Second, now); err != nil || out.TimedOut || out.Earned != 100 {
+		t.Errorf("an answer in time: %+v, %v; want 100 points", out, err)
+	}
+	if out, err := k.Record("s2", own, true, time.Second, now); err != nil || !out.TimedOut || out.Earned != 0 {
+		t.Errorf("an answer to a question never served: %+v, %v; want it timed out for nothing", out, err)
+	}
+	rules.EnforceTimeLimits = false
+	if lax := newScorekeeper(store, rules, nil, nil); lax.TimedOut(easy, time.Hour, true) || lax.TimedOut(easy, 0, false) {
+		t.Error("a late answer timed out without enforced limits")
+	}
+}
+
+func TestAnswerTimedOutServerSide(t *testing.T) {
+	// The embedded questions have limits of their own, in whole seconds,
+	// so the test serves them without.
+	data, err := fs.ReadFile(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Questions []map[string]any `json:"questions"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range doc.Questions {
+		delete(q, "timeLimit")
+	}
+	if data, err = json.Marshal(doc); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "data"), 0o755)
+	if err := os.WriteFile(filepath.Join(dir, questionsFile), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	questions, err := loadQuestions(os.DirFS(dir), questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	untimed := questions[:2]
+
+	s := startServer(t, "-content-dir", dir, "-time-bonus", "0", "-enforce-time-limits", "-time-limit-grace", "0s",
+		"-difficulty-time-limits", "easy=300ms,medium=300ms,hard=300ms")
+	sess := startSession(t, s)
+	shown := shownQuestions(t, s, sess)
+	answer := func(q Question) answerResponse {
+		t.Helper()
+		var resp answerResponse
+		choice := slices.Index(shown[q.ID], q.Answers[q.CorrectAnswer])
+		if code := postJSON(t, s.url("/api/answer?session="+url.QueryEscape(sess.Token)), map[string]any{"questionID": q.ID, "choiceIndex": choice}, &resp); code != http.StatusOK {
+			t.Fatalf("answer: status %d", code)
+		}
+		return resp
+	}
+
+	if resp := answer(untimed[0]); !resp.Correct || resp.TimedOut || resp.Score == nil || resp.Score.Score != untimed[0].Points {
+		t.Errorf("an answer in time: %+v, want %d points", resp, untimed[0].Points)
+	}
+	time.Sleep(400 * time.Millisecond)
+	// Fetching the questions again leaves their limits counted from the
+	// first serve.
+	shown = shownQuestions(t, s, sess)
+	resp := answer(untimed[1])
+	if resp.Correct || !resp.TimedOut || resp.Score == nil || resp.Score.Score != untimed[0].Points {
+		t.Errorf("a late right answer: %+v, want it timed out for nothing", resp)
+	}
+
+	fresh := startSession(t, s)
+	var unserved answerResponse
+	if code := postJSON(t, s.url("/api/answer?session="+url.QueryEscape(fresh.Token)), map[string]any{"questionID": untimed[0].ID, "choiceIndex": 0}, &unserved); code != http.StatusOK {
+		t.Fatalf("answer: status %d", code)
+	}
+	if !unserved.TimedOut || unserved.Score == nil || unserved.Score.Score != 0 {
+		t.Errorf("an answer to a question never served to the session: %+v, want it timed out for nothing", unserved)
+	}
+}
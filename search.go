@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// defaultSearchLimit is how many results /api/search returns by
+	// default.
+	defaultSearchLimit = 10
+	// maxSearchLimit caps a single /api/search request.
+	maxSearchLimit = 50
+	// titleWeight is how much more a match in a title counts than one in
+	// the body.
+	titleWeight = 3
+	// snippetRadius is how many bytes of context a snippet keeps on each
+	// side of the first match.
+	snippetRadius = 80
+)
+
+// stopWords are too common to be worth indexing.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "for": true, "from": true, "how": true, "in": true,
+	"is": true, "it": true, "of": true, "on": true, "or": true, "that": true,
+	"the": true, "this": true, "to": true, "was": true, "what": true,
+	"which": true, "who": true, "with": true,
+}
+
+// tokenize splits text into lower-cased words, dropping stop words.
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := words[:0]
+	for _, w := range words {
+		if !stopWords[w] {
+			tokens = append(tokens, w)
+		}
+	}
+	return tokens
+}
+
+// searchDoc is one searchable item: a question or a help page section.
+type searchDoc struct {
+	Type  string
+	ID    string
+	URL   string
+	Title string
+	Body  string
+}
+
+// searchResult is one ranked /api/search hit. Snippet is HTML, with the
+// matched words wrapped in <mark>.
+type searchResult struct {
+	Type    string  `json:"type"`
+	ID      string  `json:"id,omitempty"`
+	URL     string  `json:"url,omitempty"`
+	Title   string  `json:"title"`
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+}
+
+// searchIndex is an in-memory inverted index from token to the documents
+// containing it, with per-document weighted term counts.
+type searchIndex struct {
+	docs     []searchDoc
+	postings map[string]map[int]int
+}
+
+func newSearchIndex(docs []searchDoc) *searchIndex {
+	idx := &searchIndex{docs: docs, postings: make(map[string]map[int]int)}
+	add := func(doc int, text string, weight int) {
+		for _, t := range tokenize(text) {
+			if idx.postings[t] == nil {
+				idx.postings[t] = make(map[int]int)
+			}
+			idx.postings[t][doc] += weight
+		}
+	}
+	for i, d := range docs {
+		add(i, d.Title, titleWeight)
+		add(i, d.Body, 1)
+	}
+	return idx
+}
+
+// liveIndex is the search index of one language, rebuilt from the
+// questions of its bank whenever they are replaced, as admin edits,
+// content reloads, and restores do.
+type liveIndex struct {
+	bank *questionBank
+	help []searchDoc
+	mu   sync.Mutex // serializes rebuilds, so the newest questions win
+	idx  atomic.Pointer[searchIndex]
+}
+
+// newLiveIndex indexes the questions in bank along with the help
+// sections help.
+func newLiveIndex(bank *questionBank, help []searchDoc) *liveIndex {
+	l := &liveIndex{bank: bank, help: help}
+	l.rebuild()
+	bank.OnReplace(l.rebuild)
+	return l
+}
+
+// rebuild swaps in an index of the bank's current questions.
+func (l *liveIndex) rebuild() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.idx.Store(newSearchIndex(append(questionDocs(l.bank), l.help...)))
+}
+
+// Load returns the current index.
+func (l *liveIndex) Load() *searchIndex {
+	return l.idx.Load()
+}
+
+// Search ranks the documents matching any of terms by TF-IDF and returns
+// up to limit of them.
+func (idx *searchIndex) Search(terms []string, limit int) []searchResult {
+	scores := make(map[int]float64)
+	for _, t := range terms {
+		docs := idx.postings[t]
+		if len(docs) == 0 {
+			continue
+		}
+		idf := math.Log(1 + float64(len(idx.docs))/float64(len(docs)))
+		for doc, tf := range docs {
+			scores[doc] += float64(tf) * idf
+		}
+	}
+	ranked := make([]int, 0, len(scores))
+	for doc := range scores {
+		ranked = append(ranked, doc)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if scores[ranked[i]] != scores[ranked[j]] {
+			return scores[ranked[i]] > scores[ranked[j]]
+		}
+		return ranked[i] < ranked[j]
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	results := make([]searchResult, len(ranked))
+	for i, doc := range ranked {
+		d := idx.docs[doc]
+		// Quote the body unless only the title matched.
+		text := d.Title
+		for _, t := range terms {
+			if strings.Contains(strings.ToLower(d.Body), t) {
+				text = d.Body
+				break
+			}
+		}
+		results[i] = searchResult{
+			Type:    d.Type,
+			ID:      d.ID,
+			URL:     d.URL,
+			Title:   d.Title,
+			Snippet: highlight(excerpt(text, terms), terms),
+			Score:   math.Round(scores[doc]*1000) / 1000,
+		}
+	}
+	return results
+}
+
+// excerpt returns the part of text around the first occurrence of any of
+// terms, or its beginning if none occurs.
+func excerpt(text string, terms []string) string {
+	lower := strings.ToLower(text)
+	first := -1
+	for _, t := range terms {
+		if i := strings.Index(lower, t); i >= 0 && (first < 0 || i < first) {
+			first = i
+		}
+	}
+	start := max(0, max(0, first)-snippetRadius)
+	end := min(len(text), start+2*snippetRadius)
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+	out := text[start:end]
+	if start > 0 {
+		out = "…" + out
+	}
+	if end < len(text) {
+		out += "…"
+	}
+	return out
+}
+
+// highlight HTML-escapes text and wraps each word matching terms in
+// <mark>.
+func highlight(text string, terms []string) string {
+	match := make(map[string]bool, len(terms))
+	for _, t := range terms {
+		match[t] = true
+	}
+	var b strings.Builder
+	word := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	for len(text) > 0 {
+		i := strings.IndexFunc(text, word)
+		if i < 0 {
+			b.WriteString(html.EscapeString(text))
+			break
+		}
+		b.WriteString(html.EscapeString(text[:i]))
+		text = text[i:]
+		j := strings.IndexFunc(text, func(r rune) bool { return !word(r) })
+		if j < 0 {
+			j = len(text)
+		}
+		w := html.EscapeString(text[:j])
+		if match[strings.ToLower(text[:j])] {
+			w = "<mark>" + w + "</mark>"
+		}
+		b.WriteString(w)
+		text = text[j:]
+	}
+	return b.String()
+}
+
+// questionDocs makes a searchDoc of each question, titled by its prompt.
+func questionDocs(bank *questionBank) []searchDoc {
+	questions := bank.All()
+	docs := make([]searchDoc, len(questions))
+	for i, q := range questions {
+		docs[i] = searchDoc{
+			Type:  "question",
+			ID:    q.ID,
+			Title: q.Question,
+			Body:  q.Category + " " + strings.Join(q.Answers, " "),
+		}
+	}
+	return docs
+}
+
+// helpDocs splits readme into one searchDoc per section, titled by its
+// heading and linking to the help page under basePath. Markdown emphasis
+// and code markers are dropped from the text.
+func helpDocs(readme, basePath string) []searchDoc {
+	var docs []searchDoc
+	var title string
+	var body strings.Builder
+	flush := func() {
+		if title != "" || strings.TrimSpace(body.String()) != "" {
+			docs = append(docs, searchDoc{
+				Type:  "help",
+				URL:   basePath + "/help",
+				Title: title,
+				Body:  strings.Join(strings.Fields(body.String()), " "),
+			})
+		}
+		body.Reset()
+	}
+	clean := strings.NewReplacer("**", "", "`", "", "*", "", "_", " ")
+	inCode := false
+	for _, line := range strings.Split(readme, "\n") {
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+			continue
+		}
+		if !inCode && strings.HasPrefix(line, "#") {
+			flush()
+			title = strings.TrimSpace(clean.Replace(strings.TrimLeft(line, "#")))
+			continue
+		}
+		body.WriteString(clean.Replace(line))
+		body.WriteByte('\n')
+	}
+	flush()
+	return docs
+}
+
+// searchHandler serves GET /api/search?q=words&limit=N from the index for
+// the negotiated language.
+func searchHandler(indexes map[string]*liveIndex, langs *languageRegistry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		query := r.URL.Query()
+		q := strings.TrimSpace(query.Get("q"))
+		if q == "" {
+			writeAPIError(w, http.StatusBadRequest, "q is required")
+			return
+		}
+		terms := tokenize(q)
+		if len(terms) == 0 {
+			writeAPIError(w, http.StatusBadRequest, "query has no searchable words")
+			return
+		}
+		limit := defaultSearchLimit
+		if v := query.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxSearchLimit {
+				writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit))
+				return
+			}
+			limit = n
+		}
+		idx := localize(w, r, langs, indexes).Load()
+		writeJSON(w, http.StatusOK, map[string]any{"query": q, "results": idx.Search(terms, limit)})
+	})
+}
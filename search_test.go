@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSearchRanksTitleAboveBody(t *testing.T) {
+	idx := newSearchIndex([]searchDoc{
+		{Type: "help", Title: "Controls", Body: "Press the arrow keys to walk toward the dragon."},
+		{Type: "help", Title: "Dragon lair", Body: "The final room of the castle."},
+		{Type: "help", Title: "Credits", Body: "Made with care."},
+	})
+	results := idx.Search(tokenize("Dragon"), 10)
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want the 2 mentioning dragons", len(results))
+	}
+	if results[0].Title != "Dragon lair" || results[1].Title != "Controls" {
+		t.Errorf("ranked %q above %q, want the title match first", results[0].Title, results[1].Title)
+	}
+	if results[0].Score <= results[1].Score {
+		t.Errorf("title match scored %v, no more than the body match's %v", results[0].Score, results[1].Score)
+	}
+	if !strings.Contains(results[1].Snippet, "<mark>dragon</mark>") {
+		t.Errorf("body snippet %q does not highlight the match", results[1].Snippet)
+	}
+}
+
+func TestSearchLimitAndEscaping(t *testing.T) {
+	var docs []searchDoc
+	for i := 0; i < 5; i++ {
+		docs = append(docs, searchDoc{Type: "question", Title: "Which <b>castle</b> tower?"})
+	}
+	results := newSearchIndex(docs).Search([]string{"castle"}, 3)
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want the limit of 3", len(results))
+	}
+	if got := results[0].Snippet; strings.Contains(got, "<b>") || !strings.Contains(got, "<mark>castle</mark>") {
+		t.Errorf("snippet %q is not escaped and highlighted", got)
+	}
+}
+
+func TestExcerptCentersOnFirstMatch(t *testing.T) {
+	text := "Dragons sleep " + strings.Repeat("in the deepest vaults ", 20) + "below the castle."
+	got := excerpt(text, []string{"dragons", "castle"})
+	if !strings.HasPrefix(got, "Dragons sleep") || !strings.HasSuffix(got, "…") {
+		t.Errorf("excerpt = %q, want it to open on the match at the start", got)
+	}
+}
+
+func TestTokenizeDropsStopWords(t *testing.T) {
+	got := tokenize("What is the Capital of FRANCE?")
+	if strings.Join(got, " ") != "capital france" {
+		t.Errorf("tokenize = %q, want [capital france]", got)
+	}
+}
+
+func TestSearchEndpoint(t *testing.T) {
+	s := startServer(t)
+	search := func(query string) (int, []searchResult) {
+		code, raw := get(t, s.url("/api/search?"+query))
+		if code != http.StatusOK {
+			return code, nil
+		}
+		var body struct {
+			Results []searchResult `json:"results"`
+		}
+		if err := json.Unmarshal([]byte(raw), &body); err != nil {
+			t.Fatalf("search %q: decoding the body: %v", query, err)
+		}
+		return code, body.Results
+	}
+
+	code, results := search("q=" + url.QueryEscape("astronomy"))
+	if code != http.StatusOK || len(results) == 0 {
+		t.Fatalf("search for a category: status %d with %d results", code, len(results))
+	}
+	for _, r := range results {
+		if r.Type != "question" {
+			t.Errorf("astronomy matched a %s", r.Type)
+		}
+	}
+	if _, results := search("q=phase&limit=2"); len(results) != 2 {
+		t.Errorf("limit=2 gave %d results", len(results))
+	}
+	for _, query := range []string{"", "q=", "q=" + url.QueryEscape("the of and"), "q=phase&limit=0", "q=phase&limit=x"} {
+		if code, _ := search(query); code != http.StatusBadRequest {
+			t.Errorf("query %q: status %d, want 400", query, code)
+		}
+	}
+}
+
+// searchIDs returns the IDs of the questions /api/search finds for q.
+func searchIDs(t *testing.T, s *runningServer, q string) []string {
+	t.Helper()
+	var body struct {
+		Results []searchResult `json:"results"`
+	}
+	if code := getJSON(t, s.url("/api/search?q="+url.QueryEscape(q)), &body); code != http.StatusOK {
+		t.Fatalf("search %q: status %d", q, code)
+	}
+	var ids []string
+	for _, r := range body.Results {
+		if r.Type == "question" {
+			ids = append(ids, r.ID)
+		}
+	}
+	return ids
+}
+
+func TestSearchFollowsAdminEdits(t *testing.T) {
+	s := startServer(t, "-admin-password", testAdminPassword)
+	q := newAdminQuestion("q900")
+	q.Question = "Which gargoyle guards the moat?"
+	if code := adminRequest(t, s, http.MethodPost, "/api/admin/questions", "admin", testAdminPassword, q); code != http.StatusCreated {
+		t.Fatalf("create: status %d, want 201", code)
+	}
+	if ids := searchIDs(t, s, "gargoyle"); !slices.Equal(ids, []string{"q900"}) {
+		t.Errorf("search after creating: %v, want the new question", ids)
+	}
+	if code := adminRequest(t, s, http.MethodDelete, "/api/admin/questions/q900", "admin", testAdminPassword, nil); code != http.StatusNoContent {
+		t.Fatalf("delete: status %d, want 204", code)
+	}
+	if ids := searchIDs(t, s, "gargoyle"); len(ids) != 0 {
+		t.Errorf("search after deleting: %v, want nothing", ids)
+	}
+}
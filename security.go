@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// inlineScriptsToken stands in for the hashes of the inline scripts in the
+// shipped HTML pages when it appears in the -csp policy.
+const inlineScriptsToken = "{inline-scripts}"
+
+// defaultCSP allows only same-origin resources plus the data: URIs used
+// for the inline SVG icons. Inline scripts are blocked except for those
+// shipped in the pages themselves, which are allowed by hash; inline
+// style attributes remain allowed since the markup relies on them.
+const defaultCSP = "default-src 'self'; script-src 'self' " + inlineScriptsToken + "; " +
+	"style-src 'self' 'unsafe-inline'; img-src 'self' data:; font-src 'self' data:; " +
+	"object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// inlineScript matches a script element, capturing its attributes and
+// its body. RE2 has no lookahead, so srcAttribute picks out the external
+// ones.
+var (
+	inlineScript = regexp.MustCompile(`(?is)<script\b([^>]*)>(.*?)</script>`)
+	srcAttribute = regexp.MustCompile(`(?i)(?:^|\s)src(?:\s|=|$)`)
+)
+
+// inlineScriptHashes returns CSP source expressions for the inline scripts
+// in the HTML files at the top of content, sorted.
+func inlineScriptHashes(content fs.FS) ([]string, error) {
+	pages, err := fs.Glob(content, "*.html")
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, page := range pages {
+		data, err := fs.ReadFile(content, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range inlineScript.FindAllSubmatch(data, -1) {
+			if srcAttribute.Match(m[1]) {
+				continue
+			}
+			sum := sha256.Sum256(m[2])
+			seen["'sha256-"+base64.StdEncoding.EncodeToString(sum[:])+"'"] = true
+		}
+	}
+	hashes := make([]string, 0, len(seen))
+	for h := range seen {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+	return hashes, nil
+}
+
+// contentSecurityPolicy expands inlineScriptsToken in policy.
+func contentSecurityPolicy(policy string, content fs.FS) (string, error) {
+	if !strings.Contains(policy, inlineScriptsToken) {
+		return policy, nil
+	}
+	hashes, err := inlineScriptHashes(content)
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(policy, inlineScriptsToken, strings.Join(hashes, " ")), nil
+}
+
+// frameAncestorsDirective matches a frame-ancestors directive in a policy.
+var frameAncestorsDirective = regexp.MustCompile(`(?i)\s*frame-ancestors[^;]*;?`)
+
+// allowFrameAncestors returns policy with its frame-ancestors directive
+// replaced by one allowing ancestors, the origins that may embed the game
+// in a frame. Without ancestors policy is returned as it is.
+func allowFrameAncestors(policy string, ancestors []string) string {
+	if len(ancestors) == 0 {
+		return policy
+	}
+	policy = strings.TrimSuffix(strings.TrimSpace(frameAncestorsDirective.ReplaceAllString(policy, "")), ";")
+	directive := "frame-ancestors " + strings.Join(ancestors, " ")
+	if policy == "" {
+		return directive
+	}
+	return policy + "; " + directive
+}
+
+// validFrameAncestor reports whether o may stand in a frame-ancestors
+// directive: 'self', or an http(s) origin whose host may start with a "*."
+// wildcard for any subdomain.
+func validFrameAncestor(o string) bool {
+	if o == "'self'" {
+		return true
+	}
+	u, err := url.Parse(o)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User != nil ||
+		(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" || u.Opaque != "" {
+		return false
+	}
+	host := strings.TrimPrefix(u.Hostname(), "*.")
+	return host != "" && !strings.ContainsAny(host, "*;, '")
+}
+
+// securityHeaders sets the Content-Security-Policy and related hardening
+// headers on every response. Unless framed, which policy is then expected
+// to restrict with frame-ancestors, responses also forbid being framed
+// with X-Frame-Options, for browsers that predate frame-ancestors.
+func securityHeaders(next http.Handler, policy string, framed bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		if policy != "" {
+			h.Set("Content-Security-Policy", policy)
+		}
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		if !framed {
+			h.Set("X-Frame-Options", "DENY")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
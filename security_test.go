@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// headers fetches url and returns the response headers.
+func headers(t *testing.T, url string) http.Header {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.Header
+}
+
+func TestSecurityHeadersOnEveryResponse(t *testing.T) {
+	s := startServer(t)
+	for _, path := range []string{"/", "/help", "/css/game.css", "/api/questions", "/no/such/file.txt"} {
+		h := headers(t, s.url(path))
+		for name, want := range map[string]string{
+			"X-Content-Type-Options": "nosniff",
+			"Referrer-Policy":        "strict-origin-when-cross-origin",
+			"X-Frame-Options":        "DENY",
+		} {
+			if got := h.Get(name); got != want {
+				t.Errorf("%s: %s = %q, want %q", path, name, got, want)
+			}
+		}
+		if h.Get("Content-Security-Policy") == "" {
+			t.Errorf("%s: no Content-Security-Policy", path)
+		}
+	}
+}
+
+func TestDefaultCSPBlocksInlineScripts(t *testing.T) {
+	s := startServer(t)
+	policy := headers(t, s.url("/help")).Get("Content-Security-Policy")
+	directives := make(map[string]string)
+	for _, d := range strings.Split(policy, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(d), " ")
+		directives[name] = value
+	}
+	if got := directives["default-src"]; got != "'self'" {
+		t.Errorf("default-src = %q, want 'self'", got)
+	}
+	if script := directives["script-src"]; strings.Contains(script, "'unsafe-inline'") || strings.Contains(script, "data:") {
+		t.Errorf("script-src = %q allows inline or data: scripts", script)
+	}
+	if img := directives["img-src"]; !strings.Contains(img, "data:") {
+		t.Errorf("img-src = %q, want data: allowed for the SVG icons", img)
+	}
+}
+
+func TestInlineScriptHashes(t *testing.T) {
+	page := `<script type="module">start()</script>
+<script src="js/game.js"></script>
+<script data-src="x">track()</script>
+<SCRIPT>
+  init()
+</SCRIPT>`
+	hashes, err := inlineScriptHashes(fstest.MapFS{"index.html": {Data: []byte(page)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, body := range []string{"start()", "track()", "\n  init()\n"} {
+		sum := sha256.Sum256([]byte(body))
+		want = append(want, "'sha256-"+base64.StdEncoding.EncodeToString(sum[:])+"'")
+	}
+	slices.Sort(want)
+	if !slices.Equal(hashes, want) {
+		t.Errorf("inlineScriptHashes = %q, want %q for the three inline scripts", hashes, want)
+	}
+}
+
+func TestCSPFromConfig(t *testing.T) {
+	const policy = "default-src 'none'; img-src 'self'"
+	s := startServer(t, "-csp", policy)
+	if got := headers(t, s.url("/help")).Get("Content-Security-Policy"); got != policy {
+		t.Errorf("Content-Security-Policy = %q, want the configured %q", got, policy)
+	}
+}
+
+func TestFrameAncestorsFromConfig(t *testing.T) {
+	s := startServer(t)
+	h := headers(t, s.url("/"))
+	if got := h.Get("X-Frame-Options"); got != "DENY" {
+		t.Errorf("by default X-Frame-Options = %q, want DENY", got)
+	}
+	if policy := h.Get("Content-Security-Policy"); !strings.Contains(policy, "frame-ancestors 'none'") {
+		t.Errorf("by default Content-Security-Policy = %q, want framing denied", policy)
+	}
+
+	s.stop(t)
+	s = startServer(t, "-allow-frame-ancestors", "https://lms.example.edu, https://*.school.example")
+	for _, path := range []string{"/", "/api/questions"} {
+		h := headers(t, s.url(path))
+		if got := h.Get("X-Frame-Options"); got != "" {
+			t.Errorf("%s: X-Frame-Options = %q with ancestors allowed", path, got)
+		}
+		policy := h.Get("Content-Security-Policy")
+		if n := strings.Count(policy, "frame-ancestors"); n != 1 || !strings.Contains(policy, "frame-ancestors https://lms.example.edu https://*.school.example") || !strings.Contains(policy, "default-src 'self'") {
+			t.Errorf("%s: Content-Security-Policy = %q, want the default with only the allowed ancestors", path, policy)
+		}
+	}
+}
+
+func TestAllowFrameAncestors(t *testing.T) {
+	tests := []struct {
+		policy, want string
+	}{
+		{"default-src 'self'; frame-ancestors 'none'; img-src 'self'", "default-src 'self'; img-src 'self'; frame-ancestors https://a.example"},
+		{"default-src 'self'", "default-src 'self'; frame-ancestors https://a.example"},
+		{"", "frame-ancestors https://a.example"},
+	}
+	for _, tt := range tests {
+		if got := allowFrameAncestors(tt.policy, []string{"https://a.example"}); got != tt.want {
+			t.Errorf("allowFrameAncestors(%q) = %q, want %q", tt.policy, got, tt.want)
+		}
+	}
+	if got := allowFrameAncestors("frame-ancestors 'none'", nil); got != "frame-ancestors 'none'" {
+		t.Errorf("without ancestors the policy became %q", got)
+	}
+}
+
+func TestFrameAncestorsValidated(t *testing.T) {
+	for _, o := range []string{"'self'", "https://lms.example.edu", "http://localhost:8080", "https://*.example.edu"} {
+		if _, err := loadConfig([]string{"-allow-frame-ancestors", o}, noEnv); err != nil {
+			t.Errorf("%q: %v", o, err)
+		}
+	}
+	for _, o := range []string{"lms.example.edu", "ftp://lms.example.edu", "https://lms.example.edu/course", "https://a.example;script-src *", "https://*", "*", "https://ada@lms.example.edu"} {
+		if _, err := loadConfig([]string{"-allow-frame-ancestors", o}, noEnv); err == nil || !strings.Contains(err.Error(), "allow-frame-ancestors") {
+			t.Errorf("%q: %v, want an invalid origin", o, err)
+		}
+	}
+}
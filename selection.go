@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+)
+
+// minExposure is the exposure below which a question counts as unseen and
+// is forgotten.
+const minExposure = 0.01
+
+// SelectionWeights tune how /api/questions draws questions when no seed is
+// given. A question's weight is its own weight, 1 unless it sets one,
+// divided by 1 + Freshness times how often it has been served lately to
+// anyone, and by 1 + Difficulty times how many levels it lies from the
+// session's target difficulty. The weights are normalized into a
+// distribution the questions are drawn from without replacement. How often
+// a question has been served halves every ExposureHalfLife. Zero factors
+// ignore exposure or difficulty.
+type SelectionWeights struct {
+	Freshness        float64
+	Difficulty       float64
+	ExposureHalfLife time.Duration
+}
+
+// exposure is how often a question had been served, decayed, as of at.
+type exposure struct {
+	level float64
+	at    time.Time
+}
+
+// selector weighs the questions /api/questions draws from, counting how
+// often each is served across all sessions, and draws them.
+type selector struct {
+	weights SelectionWeights
+	mu      sync.Mutex
+	seen    map[string]exposure
+	rng     *rand.Rand
+}
+
+// newSelector returns a selector that draws with rng, or with a randomly
+// seeded source if rng is nil.
+func newSelector(weights SelectionWeights, rng *rand.Rand) *selector {
+	if rng == nil {
+		rng = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+	return &selector{weights: weights, seen: make(map[string]exposure), rng: rng}
+}
+
+// decay returns e's level at now.
+func (s *selector) decay(e exposure, now time.Time) float64 {
+	return e.level * math.Exp2(-float64(now.Sub(e.at))/float64(s.weights.ExposureHalfLife))
+}
+
+// Served counts one exposure of each question in pool.
+func (s *selector) Served(pool []PublicQuestion, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, q := range pool {
+		s.seen[q.ID] = exposure{level: s.decay(s.seen[q.ID], now) + 1, at: now}
+	}
+}
+
+// Weights returns the weight of each of questions, given a session's
+// target difficulty, "" for none.
+func (s *selector) Weights(questions []Question, target string, now time.Time) []float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	weights := make([]float64, len(questions))
+	for i, q := range questions {
+		w := q.Weight
+		if w == 0 {
+			w = 1
+		}
+		w /= 1 + s.weights.Freshness*s.decay(s.seen[q.ID], now)
+		if target != "" {
+			d := difficultyRank[q.Difficulty] - difficultyRank[target]
+			w /= 1 + s.weights.Difficulty*float64(max(d, -d))
+		}
+		weights[i] = w
+	}
+	return weights
+}
+
+// Order reorders pool, the public form of questions, into a draw by their
+// Weights for target.
+func (s *selector) Order(pool []PublicQuestion, questions []Question, target string, now time.Time) {
+	weights := s.Weights(questions, target, now)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	weightedOrder(pool, weights, s.rng)
+}
+
+// weightedOrder reorders pool, whose weights are weights, into the order
+// of a draw from rng without replacement, each question drawn with
+// probability its share of the weight of those left. It takes the
+// questions by ascending -ln(u)/weight for uniform u, which orders them
+// the same way.
+func weightedOrder(pool []PublicQuestion, weights []float64, rng *rand.Rand) {
+	keys := make(map[string]float64, len(pool))
+	for i, q := range pool {
+		keys[q.ID] = -math.Log(1-rng.Float64()) / weights[i]
+	}
+	sort.SliceStable(pool, func(i, j int) bool {
+		return keys[pool[i].ID] < keys[pool[j].ID]
+	})
+}
+
+// collect forgets questions whose exposure has decayed away, every
+// interval until ctx is cancelled.
+func (s *selector) collect(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for id, e := range s.seen {
+				if s.decay(e, now) < minExposure {
+					delete(s.seen, id)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelectorWeights(t *testing.T) {
+	s := newSelector(SelectionWeights{Freshness: 1, Difficulty: 4, ExposureHalfLife: time.Hour}, nil)
+	questions := []Question{
+		{ID: "a", Difficulty: "easy"},
+		{ID: "b", Difficulty: "hard", Weight: 3},
+		{ID: "c", Difficulty: "medium"},
+	}
+	now := time.Now()
+	s.Served([]PublicQuestion{{ID: "c"}}, now)
+	s.Served([]PublicQuestion{{ID: "c"}}, now)
+
+	near := func(got, want []float64) bool {
+		for i := range got {
+			if math.Abs(got[i]-want[i]) > 1e-9 {
+				return false
+			}
+		}
+		return true
+	}
+	if got, want := s.Weights(questions, "easy", now), []float64{1, 3.0 / 9, 1.0 / 15}; !near(got, want) {
+		t.Errorf("weights %v, want %v", got, want)
+	}
+	if got, want := s.Weights(questions, "easy", now.Add(time.Hour)), []float64{1, 3.0 / 9, 1.0 / 10}; !near(got, want) {
+		t.Errorf("a half-life later: weights %v, want %v", got, want)
+	}
+	if got, want := s.Weights(questions, "", now), []float64{1, 3, 1.0 / 3}; !near(got, want) {
+		t.Errorf("with no target: weights %v, want %v", got, want)
+	}
+}
+
+func TestWeightedOrderMatchesWeights(t *testing.T) {
+	const draws = 20000
+	weights := []float64{1, 2, 7}
+	rng := rand.New(rand.NewPCG(1, 2))
+	first := map[string]int{}
+	for range draws {
+		pool := []PublicQuestion{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+		weightedOrder(pool, weights, rng)
+		first[pool[0].ID]++
+	}
+	for i, id := range []string{"a", "b", "c"} {
+		want := weights[i] / 10
+		if got := float64(first[id]) / draws; math.Abs(got-want) > 0.02 {
+			t.Errorf("%s drawn first %.3f of the time, want %.3f", id, got, want)
+		}
+	}
+}
+
+func TestSelectorOrderSeeded(t *testing.T) {
+	questions := []Question{{ID: "a"}, {ID: "b", Weight: 2}, {ID: "c"}, {ID: "d", Weight: 5}, {ID: "e"}}
+	draw := func(s *selector) string {
+		pool := make([]PublicQuestion, len(questions))
+		for i, q := range questions {
+			pool[i] = PublicQuestion{ID: q.ID}
+		}
+		s.Order(pool, questions, "", time.Now())
+		var ids []string
+		for _, q := range pool {
+			ids = append(ids, q.ID)
+		}
+		return strings.Join(ids, "")
+	}
+	weights := SelectionWeights{ExposureHalfLife: time.Hour}
+	a := newSelector(weights, rand.New(rand.NewPCG(1, 2)))
+	b := newSelector(weights, rand.New(rand.NewPCG(1, 2)))
+	for range 10 {
+		if x, y := draw(a), draw(b); x != y {
+			t.Fatalf("selectors seeded alike drew %s and %s", x, y)
+		}
+	}
+}
+
+func TestSelectionWeightsValidated(t *testing.T) {
+	cfg, err := loadConfig([]string{"-selection-freshness", "0.5", "-exposure-half-life", "10m"}, noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (SelectionWeights{Freshness: 0.5, Difficulty: 4, ExposureHalfLife: 10 * time.Minute}); cfg.Selection != want {
+		t.Errorf("selection %+v, want %+v", cfg.Selection, want)
+	}
+	for _, args := range [][]string{{"-selection-freshness", "-1"}, {"-selection-difficulty", "-1"}, {"-exposure-half-life", "0s"}} {
+		if _, err := loadConfig(args, noEnv); err == nil || !strings.Contains(err.Error(), strings.TrimPrefix(args[0], "-")) {
+			t.Errorf("%v: %v", args, err)
+		}
+	}
+}
@@ -0,0 +1,901 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// errForcedShutdown reports a shutdown that ran out of time, leaving the
+// requests still in flight to be cut off. main exits with
+// exitForcedShutdown on it.
+var errForcedShutdown = errors.New("shutdown timed out; connections were forcibly closed")
+
+// exitForcedShutdown is the exit code of a forced shutdown, set apart from
+// the 1 of other failures so that supervisors can tell dropped requests
+// from a crash.
+const exitForcedShutdown = 3
+
+// serve runs the HTTP server until it fails or the process receives SIGINT
+// or SIGTERM, in which case in-flight requests are given
+// cfg.ShutdownTimeout to finish before the server gives up on them and
+// closes their connections, returning errForcedShutdown. Background work,
+// from webhook deliveries to leaderboard streams, runs on a context that
+// is canceled as shutdown begins.
+func serve(content fs.FS, cfg *Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	basePath := cfg.basePath()
+
+	shutdownTracing, err := setupTracing(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Warn("could not flush traces", "err", err)
+		}
+	}()
+
+	tenants, err := loadTenants(cfg, content)
+	if err != nil {
+		return err
+	}
+	proxies, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	limiter := newRateLimiter(cfg.RateLimit, cfg.RateBurst, proxies)
+	go limiter.collect(ctx, time.Minute)
+	cors := newCORSPolicy(cfg.CORSOrigins)
+	// A reload re-reads the same file, environment, and flags as startup.
+	live := newLiveConfig(cfg, func() (*Config, error) { return loadConfig(os.Args[1:], os.Getenv) }, func(cfg *Config) {
+		limiter.SetRate(cfg.RateLimit, cfg.RateBurst)
+		cors.Set(cfg.CORSOrigins)
+		if level, err := parseLogLevel(cfg.LogLevel); err == nil {
+			logLevel.Set(level)
+		}
+	})
+	live.watchSignals(ctx)
+	s := &server{
+		ctx:      ctx,
+		basePath: basePath,
+		proxies:  proxies,
+		limiter:  limiter,
+		busy:     newConcurrencyLimiter(cfg.Concurrency, proxies),
+		compress: newCompression(cfg.CompressLevel, cfg.CompressMinBytes).middleware,
+		cors:     cors,
+		live:     live,
+		maint:    &maintenance{},
+		probes:   &health{},
+		outbound: newOutboundClient(ctx, cfg.Outbound),
+	}
+	s.maint.watchSignals(ctx)
+
+	store, err := openStore(cfg.Store, cfg.StoreDir, cfg.storeDSN())
+	if err != nil {
+		return err
+	}
+	if c, ok := store.(io.Closer); ok {
+		defer c.Close()
+	}
+	if err := migrateStore(store); err != nil {
+		return err
+	}
+	if s.sink, err = openEventSink(cfg.EventsSink, cfg.eventsFile()); err != nil {
+		return err
+	}
+	if c, ok := s.sink.(io.Closer); ok {
+		defer c.Close()
+	}
+	var access *accessLog
+	if cfg.AccessLog != "" {
+		if access, err = openAccessLog(cfg.AccessLog, int64(cfg.AccessLogMaxSize)<<20, cfg.AccessLogBackups); err != nil {
+			return err
+		}
+		defer access.Close()
+	}
+	secret := []byte(cfg.SessionSecret)
+	if len(secret) == 0 {
+		slog.Warn("no session-secret configured; using a random one, so sessions will not survive a restart")
+		secret = []byte(randomID(32))
+	}
+
+	// Requests for hosts no tenant claims get the site configured by cfg,
+	// which alone exposes the process's metrics and profiles.
+	fallback, err := s.site(content, cfg, store, secret)
+	if err != nil {
+		return err
+	}
+	var m *metrics
+	if cfg.Metrics {
+		m = newMetrics()
+		fallback.mux.Handle("/metrics", m.handler())
+	}
+	if cfg.Pprof {
+		handlePprof(fallback.mux, func(h http.Handler) http.Handler {
+			return basicAuth(h, cfg.AdminUser, cfg.AdminPassword)
+		})
+	}
+	sites := []*site{fallback}
+	router := newTenantRouter(routeMux(fallback.mux))
+	for _, t := range tenants {
+		tstore := newTenantStore(store, t.Name)
+		if err := migrateStore(tstore); err != nil {
+			return fmt.Errorf("tenant %s: %w", t.Name, err)
+		}
+		site, err := s.site(t.content, t.cfg, tstore, tenantSecret(secret, t.Name))
+		if err != nil {
+			return fmt.Errorf("tenant %s: %w", t.Name, err)
+		}
+		router.add(t.Hosts, routeMux(site.mux))
+		sites = append(sites, site)
+		slog.Info("serving tenant", "tenant", t.Name, "hosts", t.Hosts)
+	}
+
+	policy, err := contentSecurityPolicy(cfg.CSP, content)
+	if err != nil {
+		return err
+	}
+	var inFlight atomic.Int64
+	if m != nil {
+		m.addGauge("http_requests_in_flight", "Requests currently being served.", func() float64 {
+			return float64(inFlight.Load())
+		})
+		m.addGauge("leaderboard_entries", "Scores stored on the leaderboard.", func() float64 {
+			var n int
+			for _, site := range sites {
+				n += site.lb.Len()
+			}
+			return float64(n)
+		})
+		m.addGauge("leaderboard_streams", "Open leaderboard event streams.", func() float64 {
+			var n int
+			for _, site := range sites {
+				n += site.lb.Subscribers()
+			}
+			return float64(n)
+		})
+		m.addGauge("websocket_connections", "Open multiplayer WebSocket connections.", func() float64 {
+			var n int
+			for _, site := range sites {
+				n += site.rooms.Presence().Connections
+			}
+			return float64(n)
+		})
+	}
+	// Every request goes through the process stack before its site's
+	// routes. Request IDs come first, for the panic and access logs, then
+	// the slot the matched route is recorded in, and panics are recovered
+	// around everything else. The logs and metrics inside record from a
+	// deferred call, so a request that panics is recorded with its 500.
+	ancestors := cfg.frameAncestors()
+	sampler := newLogSampler(cfg.LogStaticRate, cfg.LogSlow, basePath)
+	process := stack{
+		requestIDs,
+		matchRoutes,
+		func(h http.Handler) http.Handler { return recoverPanics(h, basePath) },
+		func(h http.Handler) http.Handler { return logRequests(h, slog.Default(), proxies, sampler) },
+	}
+	if access != nil {
+		process = process.with(func(h http.Handler) http.Handler { return access.middleware(h, proxies) })
+	}
+	if m != nil {
+		process = process.with(m.instrument)
+	}
+	process = process.with(func(h http.Handler) http.Handler { return countInFlight(h, &inFlight) })
+	if cfg.OTLPEndpoint != "" {
+		process = process.with(traceRequests)
+	}
+	if cfg.tlsEnabled() {
+		process = process.with(hsts)
+	}
+	process = process.with(func(h http.Handler) http.Handler {
+		return securityHeaders(h, allowFrameAncestors(policy, ancestors), len(ancestors) > 0)
+	})
+	if basePath != "" {
+		process = process.with(func(h http.Handler) http.Handler { return stripBasePath(h, basePath) })
+	}
+	process = process.with(s.maint.middleware)
+	srv := &http.Server{
+		Handler:           process.then(router),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+	if cfg.H2C {
+		// HTTP/2 connections take their limits from srv; HTTP/1.1 requests
+		// pass straight through.
+		srv.Handler = h2c.NewHandler(srv.Handler, &http2.Server{IdleTimeout: cfg.IdleTimeout})
+	}
+
+	var ln net.Listener
+	if cfg.UnixSocket != "" {
+		ln, err = listenUnix(cfg.UnixSocket)
+	} else {
+		ln, err = listen(cfg.Addr, cfg.PortFallback)
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("listening", "addr", ln.Addr().String(), "base_path", basePath, "tls", cfg.tlsEnabled())
+
+	errc := make(chan error, 2)
+	var redirect *http.Server
+	switch {
+	case cfg.AutocertDomain != "":
+		m := newAutocertManager(cfg)
+		srv.TLSConfig = m.TLSConfig()
+		redirect = &http.Server{
+			Addr:              cfg.RedirectAddr,
+			Handler:           m.HTTPHandler(nil),
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			ReadTimeout:       cfg.ReadTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+		}
+		go func() {
+			errc <- redirect.ListenAndServe()
+		}()
+		go func() {
+			errc <- srv.ServeTLS(ln, "", "")
+		}()
+	case cfg.TLSCert != "":
+		certs, err := newCertReloader(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return err
+		}
+		if err := certs.watch(ctx); err != nil {
+			return err
+		}
+		srv.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+		go func() {
+			errc <- srv.ServeTLS(ln, "", "")
+		}()
+	default:
+		go func() {
+			errc <- srv.Serve(ln)
+		}()
+	}
+
+	s.probes.ready.Store(true)
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+	s.probes.ready.Store(false)
+
+	slog.Info("shutting down", "draining", inFlight.Load())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if redirect != nil {
+		if err := redirect.Shutdown(shutdownCtx); err != nil {
+			redirect.Close()
+		}
+	}
+	// Hijacked WebSocket connections are not tracked by srv.Shutdown. The
+	// sites' players are given their grace period together.
+	var wg sync.WaitGroup
+	for _, site := range sites {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := site.rooms.Shutdown(shutdownCtx); err != nil {
+				slog.Warn("multiplayer connections did not close in time", "err", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := srv.Shutdown(shutdownCtx); errors.Is(err, context.DeadlineExceeded) {
+		slog.Error("shutdown timed out; forcibly closing connections", "timeout", cfg.ShutdownTimeout, "in_flight", inFlight.Load())
+		srv.Close()
+		return errForcedShutdown
+	} else if err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// server is what the sites of every tenant share: the process's context,
+// limits, middleware, and event sink.
+type server struct {
+	ctx      context.Context
+	basePath string
+	proxies  trustedProxies
+	limiter  *rateLimiter
+	busy     *concurrencyLimiter
+	compress func(http.Handler) http.Handler
+	cors     *corsPolicy
+	live     *liveConfig
+	maint    *maintenance
+	probes   *health
+	outbound *http.Client
+	sink     eventSink
+}
+
+// site is the game served to one tenant, and what serve reports on and
+// shuts down of it.
+type site struct {
+	mux   *http.ServeMux
+	lb    *leaderboard
+	rooms *hub
+}
+
+// site builds the game for content and cfg, keeping its state in store and
+// signing its tokens with secret.
+func (s *server) site(content fs.FS, cfg *Config, store Store, secret []byte) (*site, error) {
+	ctx, basePath, proxies := s.ctx, s.basePath, s.proxies
+	limiter, busy, compress, cors, live := s.limiter, s.busy, s.compress, s.cors, s.live
+	multiplayerOn := func() bool { return live.Load().Features.Multiplayer }
+	dailyOn := func() bool { return live.Load().Features.Daily }
+	hintsOn := func() bool { return live.Load().Features.Hints }
+	// Each kind of route has a stack of its own. The API's takes request
+	// bodies of up to limit bytes, and the admin API's checks the admin
+	// credentials inside it. Streams are left uncompressed so that each
+	// event is delivered as it is written.
+	apiStack := func(limit int64) stack {
+		return stack{cors.middleware, limiter.middleware, compress, func(h http.Handler) http.Handler { return limitBody(limit, h) }}
+	}
+	adminStack := func(limit int64) stack {
+		return apiStack(limit).with(func(h http.Handler) http.Handler { return basicAuth(h, cfg.AdminUser, cfg.AdminPassword) })
+	}
+	api, adminAPI := apiStack(cfg.MaxBodyBytes).then, adminStack(cfg.MaxBodyBytes).then
+	stream := stack{cors.middleware, limiter.middleware}.then
+
+	mux := http.NewServeMux()
+	v1 := &apiRouter{mux: mux, version: "v1"}
+	mux.Handle("/api/version", api(apiVersionHandler()))
+	v1.Handle("/features", api(featuresHandler(live)))
+	mux.Handle("/healthz", s.probes.livenessHandler())
+	mux.Handle("/readyz", s.probes.readinessHandler())
+
+	langs, err := newLanguageRegistry(content)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("content languages", "langs", langs.Languages())
+
+	hashes, err := hashAssets(content)
+	if err != nil {
+		return nil, err
+	}
+	// Stylesheets and scripts get content-hashed URLs, so that browsers
+	// can keep them for good, and the pages link to those. Dev mode leaves
+	// them alone, since the files change under it.
+	assets := &fingerprints{}
+	if !cfg.Dev {
+		assets = newFingerprints(hashes)
+		pages, err := fs.Glob(content, "*.html")
+		if err != nil {
+			return nil, err
+		}
+		if content, err = assets.rewriteFiles(content, hashes, pages...); err != nil {
+			return nil, err
+		}
+	}
+
+	// if a request is made to /help, serve the rendered README
+	if cfg.Dev {
+		mux.Handle("/help", compress(devHelpHandler(content, langs, basePath)))
+	} else {
+		pages, err := loadLocalized(content, langs, "README.md", loadHelpPage)
+		if err != nil {
+			return nil, err
+		}
+		// The embedded README was rendered at startup; one from
+		// -content-dir has just been.
+		if cfg.ContentDir == "" {
+			pages[defaultLanguage] = newHelpPage(HELP_CONTENT)
+		}
+		for lang, page := range pages {
+			pages[lang] = newHelpPage(rebaseHelpLinks(string(assets.rewrite([]byte(page.html))), basePath))
+		}
+		mux.Handle("/help", compress(helpHandler(pages, langs)))
+	}
+
+	banks, err := loadLocalized(content, langs, questionsFile, loadQuestionBank)
+	if err != nil {
+		return nil, err
+	}
+	overlay, err := newQuestionOverlay(store, banks[defaultLanguage])
+	if err != nil {
+		return nil, err
+	}
+	if cfg.AdminPassword != "" {
+		admin := adminAPI(adminQuestionsHandler(overlay))
+		v1.Handle("/admin/questions", admin)
+		v1.Handle("/admin/questions/{id}", admin)
+		v1.Handle("/admin/questions/import", adminStack(maxQuestionImportBody).then(adminImportHandler(overlay)))
+	}
+
+	sess := newSessions(secret, store, cfg.MinRunTime)
+	go sess.collect(ctx, time.Minute)
+	v1.Handle("/session", api(sessionHandler(sess)))
+	var profiles *accounts
+	if providers := cfg.oauthProviders(); len(providers) > 0 {
+		profiles = newAccounts(store, sess, providers, s.outbound, cfg.PublicURL, basePath, len(cfg.frameAncestors()) > 0)
+		mux.Handle("/auth/{provider}/login", limiter.middleware(profiles.loginHandler()))
+		mux.Handle("/auth/{provider}/callback", limiter.middleware(profiles.callbackHandler()))
+		v1.Handle("/profile", api(profileHandler(profiles)))
+	}
+
+	haveAchievements, err := optionalContent(content, "achievements", achievementsFile)
+	if err != nil {
+		return nil, err
+	}
+	achievementVariants := map[string][]Achievement{}
+	if haveAchievements {
+		if achievementVariants, err = loadLocalized(content, langs, achievementsFile, loadAchievements); err != nil {
+			return nil, err
+		}
+	}
+	achievements := newAchievementSet(achievementVariants)
+	hooks := newWebhooks(cfg.webhookURLs(), cfg.WebhookSecret, s.outbound)
+	hooks.start(ctx)
+	mail := newMailer(cfg.Mail, newSMTPSender(cfg.Mail, cfg.Outbound))
+	mail.start(ctx)
+	tracker := newAchievementTracker(store, achievements, hooks)
+	history := newHistories(store)
+	rv := newReviews(store)
+	rp, err := newReplays(store)
+	if err != nil {
+		return nil, err
+	}
+	go rp.collect(ctx, time.Hour)
+	perf := newPerformances(store)
+	go perf.collect(ctx, time.Hour)
+	reports := newReportBook(store)
+	mod, err := newModeration(store, reports)
+	if err != nil {
+		return nil, err
+	}
+	exps, err := loadExperiments(cfg.Experiments, banks, store)
+	if err != nil {
+		return nil, err
+	}
+	sel := newSelector(cfg.Selection, nil)
+	go sel.collect(ctx, time.Hour)
+	hints := newHintLedger(store, cfg.HintPenalty)
+	go hints.collect(ctx, time.Hour)
+	scores := newScorekeeper(store, cfg.Scoring, hints, sess)
+	go scores.collect(ctx, time.Hour)
+	v1.Handle("/questions", api(questionsHandler(banks, langs, history, perf, rp, sess, scores, mod, exps, sel)))
+	v1.Handle("/categories", api(categoriesHandler(banks, langs)))
+	if ok, err := optionalContent(content, "question media", questionMediaDir); err != nil {
+		return nil, err
+	} else if ok {
+		mux.Handle("/"+questionMediaDir+"/{file}", questionMediaHandler(content, hashes))
+	}
+	attempts := newRateLimiter(answerAttemptRate, answerAttemptBurst, proxies)
+	go attempts.collect(ctx, time.Minute)
+	stats := newAnswerStats(store)
+	answers := newAnswerer(attempts, history, rv, perf, tracker, rp, stats, exps, scores, sess)
+	v1.Handle("/answer", api(answerHandler(banks, langs, answers)))
+	v1.Handle("/score", api(scoreHandler(scores, sess)))
+	statsLimit := busy.route(cfg.Concurrency.Stats, statsWeight)
+	switch {
+	case cfg.PublicStats:
+		v1.Handle("/stats", api(statsLimit(statsHandler(stats, exps))))
+		v1.Handle("/stats.csv", api(statsLimit(statsCSVHandler(stats))))
+	case cfg.AdminPassword != "":
+		v1.Handle("/stats", adminAPI(statsLimit(statsHandler(stats, exps))))
+		v1.Handle("/stats.csv", adminAPI(statsLimit(statsCSVHandler(stats))))
+	}
+	v1.Handle("/replay/{id}", api(replayHandler(rp, sess)))
+	v1.Handle("/share", api(shareHandler(rp, sess, cfg.PublicURL, basePath)))
+	mux.Handle("/share/{token}", sharePageHandler(rp, sess, langs))
+	eventLimiter := newRateLimiter(eventsRate, eventsBurst, proxies)
+	go eventLimiter.collect(ctx, time.Minute)
+	v1.Handle("/events", api(eventsHandler(s.sink, eventLimiter, sess)))
+	if cfg.AdminPassword != "" {
+		v1.Handle("/admin/replays", adminAPI(adminReplaysHandler(rp, sess)))
+	}
+	v1.Handle("/hint", api(requireFeature(hintsOn, "hints", hintHandler(banks, langs, hints, rp, sess))))
+	reportLimiter := newRateLimiter(reportRate, reportBurst, proxies)
+	go reportLimiter.collect(ctx, time.Minute)
+	v1.Handle("/questions/{id}/report", api(reportHandler(banks, reports, reportLimiter, sess)))
+	if cfg.AdminPassword != "" {
+		admin := adminAPI(adminReportsHandler(reports))
+		v1.Handle("/admin/reports", admin)
+		v1.Handle("/admin/reports/{id}", admin)
+	}
+	v1.Handle("/review", api(reviewHandler(banks, langs, rv, sess, scores)))
+	v1.Handle("/history", api(historyHandler(history, sess)))
+	haveMaps, err := optionalContent(content, "maps", path.Dir(mapFilesPattern), defaultMapFile)
+	if err != nil {
+		return nil, err
+	}
+	mapsAPI := unavailableFeature("maps")
+	if haveMaps {
+		maps, err := loadMaps(content)
+		if err != nil {
+			return nil, err
+		}
+		mapsAPI = mapsHandler(maps)
+	}
+	v1.Handle("/maps", api(mapsAPI))
+	v1.Handle("/maps/{id}", api(mapsAPI))
+	if cfg.Dev {
+		r := &contentReloader{content: content, langs: langs, banks: banks, overlay: overlay}
+		if haveAchievements {
+			r.achievements = achievements
+		}
+		if err := r.watch(ctx, "."); err != nil {
+			slog.Warn("content hot reload disabled", "err", err)
+		}
+	}
+	if haveAchievements {
+		v1.Handle("/achievements", api(achievementsHandler(achievements, tracker, langs)))
+		if cfg.AdminPassword != "" {
+			v1.Handle("/admin/achievements/reevaluate", adminAPI(adminAchievementsHandler(tracker)))
+		}
+	} else {
+		v1.Handle("/achievements", api(unavailableFeature("achievements")))
+	}
+
+	indexes := make(map[string]*liveIndex)
+	for _, lang := range langs.Languages() {
+		bank, ok := banks[lang]
+		if !ok {
+			bank = banks[defaultLanguage]
+		}
+		name, _ := langs.file("README.md", lang)
+		readme, err := fs.ReadFile(content, name)
+		if err != nil {
+			return nil, err
+		}
+		indexes[lang] = newLiveIndex(bank, helpDocs(string(readme), basePath))
+	}
+	v1.Handle("/search", api(busy.route(cfg.Concurrency.Search, searchWeight)(searchHandler(indexes, langs))))
+
+	filter, err := loadChatFilter(cfg.ChatBlocklist)
+	if err != nil {
+		return nil, err
+	}
+	lb, err := openLeaderboard(store, leaderboardKey)
+	if err != nil {
+		return nil, err
+	}
+	categoryLeaderboards := newCategoryBoards(store, banks[defaultLanguage])
+	daily := newDailyBoards(store)
+	guard := newScoreGuard(cfg.AntiCheat, rp, store, proxies, func(key string) (*leaderboard, error) {
+		if category, ok := strings.CutPrefix(key, "category-"); ok {
+			return categoryLeaderboards.Board(category)
+		}
+		if date, ok := strings.CutPrefix(key, "daily-"); ok {
+			return daily.Board(date)
+		}
+		return lb, nil
+	})
+	nameFilter := filter
+	if cfg.NameBlocklist != "" {
+		if nameFilter, err = loadChatFilter(cfg.NameBlocklist); err != nil {
+			return nil, err
+		}
+	}
+	names := newNameRegistry(store, nameFilter, lb)
+	go names.collect(ctx, time.Hour)
+
+	rooms := newHub(banks[defaultLanguage], cfg.RoomCapacity, cfg.DrainGrace, filter, mod, names, sess, profiles, cors, cfg.wsCompressMin(), cfg.RoomCodeTTL)
+	go rooms.collectCodes(ctx, roomCodeCollectInterval)
+	mux.Handle("/ws/room/{id}", limiter.middleware(requireFeature(multiplayerOn, "multiplayer", rooms)))
+	v1.Handle("/presence", api(requireFeature(multiplayerOn, "multiplayer", presenceHandler(rooms))))
+	v1.Handle("/matchmake", api(requireFeature(multiplayerOn, "multiplayer", matchmakeHandler(newMatchmaker(cfg.RoomCapacity, cfg.MatchmakeTimeout)))))
+	v1.Handle("/room/create", api(requireFeature(multiplayerOn, "multiplayer", roomCreateHandler(rooms))))
+	if cfg.AdminPassword != "" {
+		admin := adminAPI(adminModerationHandler(mod, rooms, sess))
+		v1.Handle("/admin/moderation", admin)
+		v1.Handle("/admin/moderation/{id}", admin)
+	}
+
+	v1.Handle("/name", api(nameHandler(names, sess, profiles)))
+	v1.Handle("/leaderboard", api(leaderboardHandler(lb, categoryLeaderboards, sess, scores, hooks, mail, profiles, guard, names, nil)))
+	v1.Handle("/leaderboard.csv", api(leaderboardCSVHandler(lb, langs)))
+	v1.Handle("/daily", api(requireFeature(dailyOn, "daily challenge", dailyHandler(banks, langs, sess, scores, mod, daily))))
+	v1.Handle("/daily/leaderboard", api(requireFeature(dailyOn, "daily challenge", dailyLeaderboardHandler(daily, sess, scores, hooks, mail, profiles, guard, names))))
+	svc := graphqlServices{mod: mod, achievements: achievements, tracker: tracker, langs: langs, lb: lb, categories: categoryLeaderboards, answers: answers}
+	if cfg.PublicStats {
+		svc.stats = stats
+	}
+	schema, err := newGraphQLSchema(svc)
+	if err != nil {
+		return nil, err
+	}
+	v1.Handle("/graphql", api(graphqlHandler(schema, banks, langs)))
+	if cfg.AdminPassword != "" {
+		admin := adminAPI(adminQuarantineHandler(guard))
+		v1.Handle("/admin/quarantine", admin)
+		v1.Handle("/admin/quarantine/{id}", admin)
+	}
+	v1.Handle("/leaderboard/stream", stream(leaderboardStreamHandler(lb, ctx.Done())))
+	v1.Handle("/save", api(saveHandler(store)))
+	v1.Handle("/load", api(loadHandler(store)))
+	v1.Handle("/sync", api(syncHandler(store)))
+	v1.Handle("/export", api(exportHandler(store)))
+	v1.Handle("/import", api(importHandler(store)))
+	if cfg.AdminPassword != "" {
+		// reload refreshes the state held in memory after a restore.
+		// Reloading the overlay replaces the bank's questions, which
+		// rebuilds the search index.
+		reload := func() error {
+			daily.Reset()
+			categoryLeaderboards.Reset()
+			return errors.Join(lb.Reload(), rp.Reindex(), overlay.Reload(), mod.Reload())
+		}
+		v1.Handle("/admin/backup", adminAPI(adminBackupHandler(store)))
+		v1.Handle("/admin/maintenance", adminAPI(adminMaintenanceHandler(s.maint)))
+		v1.Handle("/admin/restore", adminStack(maxRestoreBody).then(adminRestoreHandler(store, overlay, reload)))
+	}
+
+	manifest, err := buildManifest(content, cfg.manifestOverrides(), basePath)
+	if err != nil {
+		return nil, err
+	}
+	mux.Handle("/manifest.json", compress(manifestHandler(manifest)))
+	mux.Handle("/robots.txt", robotsHandler(cfg.PublicURL, basePath, cfg.robotsDisallow()))
+	mux.Handle("/sitemap.xml", compress(sitemapHandler(cfg.PublicURL, basePath)))
+	mux.Handle("/icons/{file}", iconHandler(newIconRenderer(manifest, cfg.IconCacheBytes), busy.route(cfg.Concurrency.Icons, iconWeight)))
+
+	a11yRules, err := loadA11yRules(content)
+	if err != nil {
+		return nil, err
+	}
+	themes := buildThemes(cfg.Theme, a11yRules)
+	mux.Handle("/"+themeFile, compress(themeHandler(themes)))
+
+	// The precache entries for the manifest and theme must track what is
+	// served, and so there is a worker for each theme variant.
+	hashes[manifestFile] = contentHash(manifest)
+	workers := make(map[string][]byte, len(themes))
+	for selection, theme := range themes {
+		hashes[themeFile] = contentHash(theme)
+		if workers[selection], err = buildServiceWorker(hashes, assets, basePath, selection); err != nil {
+			return nil, err
+		}
+	}
+	hashes[themeFile] = contentHash(themes[""])
+	mux.Handle("/sw.js", compress(serviceWorkerHandler(workers)))
+	index, err := buildAssetIndex(content, hashes, assets)
+	if err != nil {
+		return nil, err
+	}
+	index[manifestFile] = assetInfo{Hash: hashes[manifestFile], Size: int64(len(manifest)), URL: manifestFile}
+	index[themeFile] = assetInfo{Hash: hashes[themeFile], Size: int64(len(themes[""])), URL: themeFile}
+	assetIndex, err := assetIndexHandler(index)
+	if err != nil {
+		return nil, err
+	}
+	v1.Handle("/assets", api(assetIndex))
+
+	// serve static files (css, js, manifest.json)
+	types, err := parseAssetTypes(cfg.AssetTypes)
+	if err != nil {
+		return nil, err
+	}
+	// Directories are served their index pages, and pages and assets are
+	// then typed, localized, and themed before anything else sees them.
+	// Outside dev mode they are then served under their fingerprinted
+	// URLs, from their Brotli copies where there are some, and with cache
+	// validators.
+	if _, err := fs.Stat(content, cfg.IndexFile); err != nil {
+		return nil, fmt.Errorf("index-file: %w", err)
+	}
+	static := stack{
+		func(h http.Handler) http.Handler { return directoryIndexes(h, content, cfg.IndexFile) },
+		func(h http.Handler) http.Handler { return typeAssets(h, types) },
+		func(h http.Handler) http.Handler { return historyFallback(h, content, basePath, cfg.IndexFile) },
+		func(h http.Handler) http.Handler { return localizeStatic(h, langs) },
+		func(h http.Handler) http.Handler { return a11yPages(h, content, compress) },
+	}
+	if cfg.Dev {
+		static = static.with(compress)
+	} else {
+		brotliAssets, err := loadBrotli(content, hashes)
+		if err != nil {
+			return nil, err
+		}
+		static = static.with(
+			assets.middleware,
+			func(h http.Handler) http.Handler { return precompressed(h, brotliAssets, hashes, types) },
+			compress,
+			func(h http.Handler) http.Handler { return cacheStatic(h, hashes) },
+		)
+	}
+	static = static.with(func(h http.Handler) http.Handler { return serveMedia(h, content) })
+	mux.Handle("/", static.then(themedNotFound(http.FileServer(http.FS(content)))))
+	return &site{mux: mux, lb: lb, rooms: rooms}, nil
+}
+
+// helpPage is a pre-rendered help page, gzipped as well, and its ETag.
+type helpPage struct {
+	html, etag string
+	gzipped    []byte
+}
+
+func newHelpPage(html string) helpPage {
+	var b bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&b, gzip.BestCompression)
+	zw.Write([]byte(html))
+	zw.Close()
+	return helpPage{html: html, etag: etag(contentHash([]byte(html))), gzipped: b.Bytes()}
+}
+
+// loadHelpPage renders the README variant name from content.
+func loadHelpPage(content fs.FS, name string) (helpPage, error) {
+	readme, err := fs.ReadFile(content, name)
+	if err != nil {
+		return helpPage{}, err
+	}
+	lang := defaultLanguage
+	if m := localizedFile.FindStringSubmatch(name); m != nil {
+		lang = m[2]
+	}
+	return newHelpPage(renderLocalizedHelp(string(readme), lang)), nil
+}
+
+// helpHandler serves the pre-rendered help page in the negotiated
+// language, with a content-derived ETag. Clients that accept gzip get the
+// page's gzipped copy, under an ETag of its own; byte ranges are served
+// from the identity bytes, which they refer to. The handler is wrapped in
+// compress, which sets Vary.
+func helpHandler(pages map[string]helpPage, langs *languageRegistry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := localize(w, r, langs, pages)
+		h := w.Header()
+		h.Set("Content-Type", "text/html")
+		if r.Header.Get("Range") == "" && acceptsEncoding(r, "gzip") {
+			// ServeContent only sets Content-Length for unencoded bodies.
+			h.Set("Content-Encoding", "gzip")
+			h.Set("Content-Length", strconv.Itoa(len(page.gzipped)))
+			h.Set("ETag", strings.TrimSuffix(page.etag, `"`)+`-gzip"`)
+			http.ServeContent(w, r, "help.html", time.Time{}, bytes.NewReader(page.gzipped))
+			return
+		}
+		h.Set("ETag", page.etag)
+		http.ServeContent(w, r, "help.html", time.Time{}, strings.NewReader(page.html))
+	})
+}
+
+// rebaseHelpLinks makes the help page's stylesheet and manifest links
+// absolute under basePath, so they resolve however /help is reached.
+func rebaseHelpLinks(page, basePath string) string {
+	return strings.NewReplacer(
+		`href="css/`, `href="`+basePath+`/css/`,
+		`href="manifest.json"`, `href="`+basePath+`/manifest.json"`,
+	).Replace(page)
+}
+
+// devHelpHandler re-reads and renders the negotiated README variant from
+// content on every request so edits show up without a rebuild.
+func devHelpHandler(content fs.FS, langs *languageRegistry, basePath string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, lang := langs.file("README.md", langs.negotiate(r))
+		readme, err := fs.ReadFile(content, name)
+		if err != nil {
+			http.Error(w, name+": "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Language")
+		w.Header().Set("Content-Language", lang)
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(rebaseHelpLinks(renderLocalizedHelp(string(readme), lang), basePath)))
+	})
+}
+
+// stripBasePath serves requests under basePath by passing them to next
+// with the prefix removed, so every route, /healthz and /metrics included,
+// lives below it. The bare prefix redirects to its trailing-slash form so
+// that relative links on the index page resolve.
+func stripBasePath(next http.Handler, basePath string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == basePath:
+			target := basePath + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, basePath+"/"):
+			http.StripPrefix(basePath, next).ServeHTTP(w, withBasePath(r, basePath))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// countInFlight keeps n equal to the number of requests currently being
+// handled by next.
+func countInFlight(next http.Handler, n *atomic.Int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.Add(1)
+		defer n.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listen binds a TCP listener on addr, reporting a busy port explicitly
+// instead of as a generic bind failure. If the port is busy and fallback
+// is positive, up to that many following ports are tried in turn; the
+// listener's Addr reports where it ended up.
+func listen(addr string, fallback int) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		return ln, err
+	}
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, perr := strconv.Atoi(portStr)
+	if perr == nil && port != 0 {
+		for i := 1; i <= fallback && port+i <= 65535; i++ {
+			next := net.JoinHostPort(host, strconv.Itoa(port+i))
+			ln, err = net.Listen("tcp", next)
+			if err == nil {
+				slog.Warn("configured port is busy; using a fallback port", "addr", addr, "bound", ln.Addr().String())
+				return ln, nil
+			}
+			if !errors.Is(err, syscall.EADDRINUSE) {
+				return nil, err
+			}
+		}
+	}
+	return nil, fmt.Errorf("cannot listen on %s: address already in use", addr)
+}
+
+// unixSocketMode lets the server's user and group, such as a reverse
+// proxy run in that group, connect to the socket.
+const unixSocketMode = 0o660
+
+// listenUnix listens on a Unix domain socket at path. A socket file left
+// behind by a server that did not shut down cleanly is removed first, but
+// one that still accepts connections, or any other kind of file, is an
+// error. The listener removes the socket again when it is closed.
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("cannot listen on %s: file exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("cannot listen on %s: another server is using it", path)
+		}
+		slog.Warn("removing stale socket", "path", path)
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, unixSocketMode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
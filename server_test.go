@@ -0,0 +1,581 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// logRecorder is a slog.Handler keeping every record it is given.
+type logRecorder struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (l *logRecorder) Enabled(context.Context, slog.Level) bool { return true }
+
+func (l *logRecorder) Handle(_ context.Context, r slog.Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, r.Clone())
+	return nil
+}
+
+func (l *logRecorder) WithAttrs([]slog.Attr) slog.Handler { return l }
+func (l *logRecorder) WithGroup(string) slog.Handler      { return l }
+
+// attr returns the value of attribute key of the first record with message
+// msg, and whether there is one.
+func (l *logRecorder) attr(msg, key string) (slog.Value, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, r := range l.records {
+		if r.Message != msg {
+			continue
+		}
+		var v slog.Value
+		found := false
+		r.Attrs(func(a slog.Attr) bool {
+			if a.Key == key {
+				v, found = a.Value, true
+				return false
+			}
+			return true
+		})
+		if found {
+			return v, true
+		}
+	}
+	return slog.Value{}, false
+}
+
+// recordLogs sends the default logger's records to a logRecorder for the
+// rest of the test.
+func recordLogs(t *testing.T) *logRecorder {
+	t.Helper()
+	rec := &logRecorder{}
+	prev := slog.Default()
+	slog.SetDefault(slog.New(rec))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return rec
+}
+
+// inTempDir runs the rest of the test in a fresh working directory, so
+// that anything written to the current directory is thrown away.
+func inTempDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// runningServer is a server on a local port started by a test.
+type runningServer struct {
+	addr string
+	logs *logRecorder
+	done chan error
+}
+
+// startServer runs serve on the embedded content with the server flags
+// args, in a fresh working directory, and waits until it listens. The
+// server is stopped with SIGTERM at the end of the test unless the test
+// stops it first.
+func startServer(t *testing.T, args ...string) *runningServer {
+	t.Helper()
+	inTempDir(t)
+	return startServerIn(t, args...)
+}
+
+// startServerIn is startServer in the current working directory, serving
+// the content the flags select from there.
+func startServerIn(t *testing.T, args ...string) *runningServer {
+	t.Helper()
+	return startServerOn(t, staticFS, args...)
+}
+
+// startServerOn is startServerIn with base in place of the embedded
+// content.
+func startServerOn(t *testing.T, base fs.FS, args ...string) *runningServer {
+	t.Helper()
+	logs := recordLogs(t)
+	cfg, err := loadConfig(append([]string{"-addr", "127.0.0.1:0"}, args...), func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := siteContent(cfg, base)
+	done := make(chan error, 1)
+	s := &runningServer{logs: logs, done: done}
+	go func() { done <- serve(content, cfg) }()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if v, ok := logs.attr("listening", "addr"); ok {
+			s.addr = v.String()
+			break
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("server stopped before listening: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start listening")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Cleanup(func() { s.stop(t) })
+	return s
+}
+
+// stop sends the process SIGTERM and returns what serve returned, once.
+func (s *runningServer) stop(t *testing.T) error {
+	t.Helper()
+	if s.done == nil {
+		return nil
+	}
+	done := s.done
+	s.done = nil
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(15 * time.Second):
+		t.Fatal("server did not shut down")
+		return nil
+	}
+}
+
+// serveRoutes serves routes, handlers by ServeMux pattern, on a local port
+// for the rest of the test, for tests of a few handlers on their own.
+func serveRoutes(t *testing.T, routes map[string]http.Handler) *runningServer {
+	t.Helper()
+	mux := http.NewServeMux()
+	for pattern, h := range routes {
+		mux.Handle(pattern, h)
+	}
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return &runningServer{addr: srv.Listener.Addr().String()}
+}
+
+// url returns the address of path on the server.
+func (s *runningServer) url(path string) string {
+	return "http://" + s.addr + path
+}
+
+// getJSON fetches url, decoding a JSON body into v unless v is nil, and
+// returns the status code.
+func getJSON(t *testing.T, url string, v any) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("GET %s: decoding the body: %v", url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// get fetches url and returns the status code and body.
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+// postJSON posts body, encoded as JSON, to url, decoding a JSON response
+// into v unless v is nil, and returns the status code.
+func postJSON(t *testing.T, url string, body, v any) int {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("POST %s: decoding the body: %v", url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestCountInFlight(t *testing.T) {
+	var n atomic.Int64
+	release := make(chan struct{})
+	h := countInFlight(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}), &n)
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		close(done)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); n.Load() != 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests in flight, want 1", n.Load())
+		}
+	}
+	close(release)
+	<-done
+	if got := n.Load(); got != 0 {
+		t.Errorf("%d requests in flight after the last one finished, want 0", got)
+	}
+}
+
+func TestServeShutsDownOnSIGTERM(t *testing.T) {
+	s := startServer(t)
+	// The address logged is the one bound, not the :0 asked for.
+	if host, port, err := net.SplitHostPort(s.addr); err != nil || host != "127.0.0.1" || port == "0" {
+		t.Fatalf("logged address %q, want the port actually bound on 127.0.0.1", s.addr)
+	}
+	resp, err := http.Get(s.url("/"))
+	if err != nil {
+		t.Fatalf("server does not answer on its logged address: %v", err)
+	}
+	resp.Body.Close()
+	if err := s.stop(t); err != nil {
+		t.Fatalf("serve returned %v after SIGTERM, want nil", err)
+	}
+	if _, ok := s.logs.attr("shutting down", "draining"); !ok {
+		t.Error("shutdown did not log how many requests were draining")
+	}
+	if _, err := http.Get(s.url("/")); err == nil {
+		t.Error("server still answers after shutting down")
+	}
+}
+
+func TestServeUsesItsOwnMux(t *testing.T) {
+	// serve could only run once per process while it registered its
+	// routes on the default ServeMux; now it runs twice.
+	for i := 0; i < 2; i++ {
+		s := startServer(t)
+		if code, body := get(t, s.url("/help")); code != http.StatusOK || body == "" {
+			t.Errorf("server %d: /help status %d", i, code)
+		}
+		if err := s.stop(t); err != nil {
+			t.Fatalf("server %d: %v", i, err)
+		}
+	}
+	w := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/help", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("/help is registered on the default mux: status %d", w.Code)
+	}
+}
+
+func TestListenReportsBusyPort(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	ln, err := listen(busy.Addr().String(), 0)
+	if err == nil {
+		ln.Close()
+		t.Fatal("listening on a busy port succeeded")
+	}
+	if !strings.Contains(err.Error(), "already in use") {
+		t.Errorf("busy port error = %q, want it to say the address is in use", err)
+	}
+}
+
+// busyPort occupies a port on 127.0.0.1 whose successor is free, for the
+// rest of the test, and returns it.
+func busyPort(t *testing.T) int {
+	t.Helper()
+	for attempt := 0; attempt < 20; attempt++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := ln.Addr().(*net.TCPAddr).Port
+		next, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port+1)))
+		if err != nil {
+			ln.Close()
+			continue
+		}
+		next.Close()
+		t.Cleanup(func() { ln.Close() })
+		return port
+	}
+	t.Fatal("found no busy port with a free successor")
+	return 0
+}
+
+func TestPortFallback(t *testing.T) {
+	port := busyPort(t)
+	s := startServer(t, "-addr", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), "-port-fallback", "3")
+	if want := net.JoinHostPort("127.0.0.1", strconv.Itoa(port+1)); s.addr != want {
+		t.Errorf("bound %s, want the next port %s", s.addr, want)
+	}
+	if _, ok := s.logs.attr("configured port is busy; using a fallback port", "bound"); !ok {
+		t.Error("the fallback port was not logged")
+	}
+	if code, _ := get(t, s.url("/help")); code != http.StatusOK {
+		t.Errorf("server on the fallback port: status %d", code)
+	}
+}
+
+func TestNoPortFallbackByDefault(t *testing.T) {
+	port := busyPort(t)
+	inTempDir(t)
+	recordLogs(t)
+	cfg, err := loadConfig([]string{"-addr", net.JoinHostPort("127.0.0.1", strconv.Itoa(port))}, func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = serve(staticFS, cfg)
+	if err == nil || !strings.Contains(err.Error(), "already in use") {
+		t.Errorf("serve on a busy port = %v, want it to fail as in use", err)
+	}
+}
+
+func TestSlowHeaderClientDropped(t *testing.T) {
+	s := startServer(t, "-read-header-timeout", "200ms")
+	conn, err := net.Dial("tcp", s.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /help HTTP/1.1\r\nHost: example\r\n")
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	_, err = io.ReadAll(conn)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("connection with a partial header was still open after 5s")
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("connection dropped after %v, before the header timeout", elapsed)
+	}
+}
+
+func TestServerTimeoutFlags(t *testing.T) {
+	inTempDir(t)
+	cfg, err := loadConfig([]string{"-read-timeout", "7s", "-write-timeout", "80s", "-idle-timeout", "9s"}, func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ReadHeaderTimeout != 5*time.Second {
+		t.Errorf("default read-header-timeout = %v, want a short 5s", cfg.ReadHeaderTimeout)
+	}
+	if cfg.ReadTimeout != 7*time.Second || cfg.WriteTimeout != 80*time.Second || cfg.IdleTimeout != 9*time.Second {
+		t.Errorf("timeouts = %v/%v/%v, want the flags 7s/80s/9s", cfg.ReadTimeout, cfg.WriteTimeout, cfg.IdleTimeout)
+	}
+	if _, err := loadConfig([]string{"-read-header-timeout", "0"}, func(string) string { return "" }); err == nil {
+		t.Error("a zero read-header-timeout was accepted")
+	}
+}
+
+func TestServeOnUnixSocket(t *testing.T) {
+	inTempDir(t)
+	logs := recordLogs(t)
+	// Socket paths are limited to about a hundred bytes, which t.TempDir
+	// can exceed.
+	dir, err := os.MkdirTemp("", "ll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	sock := dir + "/ll.sock"
+	// A socket file from a server that crashed is cleared away.
+	stale, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	cfg, err := loadConfig([]string{"-unix-socket", sock}, noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	s := &runningServer{logs: logs, done: done}
+	go func() { done <- serve(siteContent(cfg, staticFS), cfg) }()
+	t.Cleanup(func() { s.stop(t) })
+	waitFor(t, "the server to listen", func() bool {
+		_, ok := logs.attr("listening", "addr")
+		return ok
+	})
+
+	if _, ok := logs.attr("removing stale socket", "path"); !ok {
+		t.Error("the stale socket was not reported")
+	}
+	fi, err := os.Stat(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != unixSocketMode {
+		t.Errorf("socket mode %v, want %v", perm, os.FileMode(unixSocketMode))
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	resp, err := client.Get("http://lobelabyrinth/api/questions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("request over the socket: status %d", resp.StatusCode)
+	}
+	client.CloseIdleConnections()
+
+	if err := s.stop(t); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(sock); !os.IsNotExist(err) {
+		t.Errorf("socket left behind after a clean shutdown: %v", err)
+	}
+}
+
+func TestUnixSocketExcludesAddr(t *testing.T) {
+	_, err := loadConfig([]string{"-unix-socket", "/tmp/ll.sock", "-addr", ":9090"}, noEnv)
+	if err == nil || !strings.Contains(err.Error(), "unix-socket") {
+		t.Errorf("-unix-socket with -addr: err = %v, want them rejected together", err)
+	}
+}
+
+func TestUnixSocketRefusesOtherFiles(t *testing.T) {
+	path := writeConfigFile(t, "not-a-socket", "data")
+	if _, err := listenUnix(path); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("listenUnix over a regular file: err = %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "data" {
+		t.Error("the regular file was replaced")
+	}
+}
+
+// h2cClient is an HTTP/2 client speaking cleartext HTTP/2 by prior
+// knowledge.
+func h2cClient() *http.Client {
+	return &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+}
+
+func TestH2C(t *testing.T) {
+	s := startServer(t, "-h2c")
+	resp, err := h2cClient().Get(s.url("/api/questions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 || resp.StatusCode != http.StatusOK {
+		t.Errorf("h2c request: %s status %d, want HTTP/2.0 and 200", resp.Proto, resp.StatusCode)
+	}
+	if resp.Header.Get("Content-Security-Policy") == "" {
+		t.Error("the HTTP/2 response skipped the security headers middleware")
+	}
+
+	resp, err = http.Get(s.url("/api/questions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 1 || resp.StatusCode != http.StatusOK {
+		t.Errorf("HTTP/1.1 request: %s status %d", resp.Proto, resp.StatusCode)
+	}
+}
+
+func TestH2COptIn(t *testing.T) {
+	s := startServer(t)
+	if _, err := h2cClient().Get(s.url("/")); err == nil {
+		t.Error("HTTP/2 by prior knowledge worked without -h2c")
+	}
+}
+
+func TestH2CExcludesTLS(t *testing.T) {
+	_, err := loadConfig([]string{"-h2c", "-tls-cert", "cert.pem", "-tls-key", "key.pem"}, noEnv)
+	if err == nil || !strings.Contains(err.Error(), "h2c") {
+		t.Errorf("-h2c with TLS: err = %v, want it rejected", err)
+	}
+}
+
+func TestShutdownForcesCloseAfterTimeout(t *testing.T) {
+	s := startServer(t, "-shutdown-timeout", "200ms", "-pprof", "-admin-password", testAdminPassword)
+	// A CPU profile holds its request open for as long as it records,
+	// which is well past the shutdown timeout.
+	failed := make(chan error, 1)
+	go func() {
+		req, _ := http.NewRequest(http.MethodGet, s.url(pprofPrefix+"profile?seconds=30"), nil)
+		req.SetBasicAuth("admin", testAdminPassword)
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			_, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		failed <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	start := time.Now()
+	if err := s.stop(t); !errors.Is(err, errForcedShutdown) {
+		t.Fatalf("serve returned %v, want errForcedShutdown", err)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("shutdown took %s despite its 200ms timeout", took)
+	}
+	if n, _ := s.logs.attr("shutting down", "draining"); n.Int64() != 1 {
+		t.Errorf("%d requests were draining at shutdown, want the profile's 1", n.Int64())
+	}
+	if _, ok := s.logs.attr("shutdown timed out; forcibly closing connections", "timeout"); !ok {
+		t.Error("the forced close was not logged")
+	}
+	select {
+	case err := <-failed:
+		if err == nil {
+			t.Error("the slow request completed despite its connection being closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the slow request's connection was left open")
+	}
+	if exitForcedShutdown == 1 {
+		t.Error("a forced shutdown exits as other failures do")
+	}
+}
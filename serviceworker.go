@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// serviceWorkerSource is the service worker script. The block between its
+// generatedStart and generatedEnd markers is replaced when it is served.
+//
+//go:embed sw.js
+var serviceWorkerSource string
+
+const (
+	generatedStart = "// BEGIN GENERATED\n"
+	generatedEnd   = "// END GENERATED\n"
+)
+
+// buildServiceWorker returns sw.js with a precache list of the assets in
+// hashes, each mapped to its content hash, and a version derived from
+// all of them. Media and the READMEs are left out since the game works
+// offline without them, as are the Brotli variants, which the browser
+// never requests by name. URLs are the ones assets gives, prefixed with
+// basePath, except the theme stylesheet's, which names the accessibility
+// variants of a11y; hashes must hold that variant's hash.
+func buildServiceWorker(hashes map[string]string, assets *fingerprints, basePath, a11y string) ([]byte, error) {
+	start := strings.Index(serviceWorkerSource, generatedStart)
+	end := strings.Index(serviceWorkerSource, generatedEnd)
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("sw.js: missing generated block markers")
+	}
+
+	var names []string
+	for name := range hashes {
+		if strings.HasPrefix(name, "README") || strings.HasSuffix(name, ".br") || mediaTypes[strings.ToLower(path.Ext(name))] {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	urls := []string{basePath + "/"}
+	assetHashes := make(map[string]string, len(names))
+	var manifest strings.Builder
+	for _, name := range names {
+		url := basePath + "/" + assets.URL(name)
+		if name == themeFile {
+			url = basePath + "/" + themeURL(a11y)
+		}
+		urls = append(urls, url)
+		assetHashes[url] = hashes[name][:16]
+		fmt.Fprintf(&manifest, "%s %s\n", name, hashes[name])
+	}
+	version := contentHash([]byte(manifest.String()))[:16]
+
+	list, err := json.MarshalIndent(urls, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	fps, err := json.MarshalIndent(assetHashes, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	b.WriteString(serviceWorkerSource[:start])
+	b.WriteString("// Generated by the server from the embedded assets.\n")
+	fmt.Fprintf(&b, "const SW_VERSION = '%s';\n\n", version)
+	fmt.Fprintf(&b, "// Path prefix the app is served under, without a trailing slash\n")
+	fmt.Fprintf(&b, "const BASE_PATH = %q;\n\n", basePath)
+	fmt.Fprintf(&b, "// Files to cache for offline functionality, mapped to their content hashes\n")
+	fmt.Fprintf(&b, "const ASSET_HASHES = %s;\n", fps)
+	fmt.Fprintf(&b, "const STATIC_ASSETS = %s;\n", list)
+	b.WriteString(serviceWorkerSource[end+len(generatedEnd):])
+	return []byte(b.String()), nil
+}
+
+// serviceWorkerHandler serves the generated service worker precaching the
+// theme variant the request selects from scripts, keyed as a11ySelection
+// names it. It is marked no-cache so browsers always revalidate it and
+// pick up new versions.
+func serviceWorkerHandler(scripts map[string][]byte) http.Handler {
+	tags := make(map[string]string, len(scripts))
+	for selection, script := range scripts {
+		tags[selection] = etag(contentHash(script))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		selection := a11ySelection(r)
+		w.Header().Add("Vary", a11yHints)
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", tags[selection])
+		http.ServeContent(w, r, "sw.js", time.Time{}, bytes.NewReader(scripts[selection]))
+	})
+}
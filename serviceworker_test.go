@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var swVersion = regexp.MustCompile(`const SW_VERSION = '([0-9a-f]+)';`)
+
+// fetchServiceWorker fetches /sw.js from a fresh server, which it stops
+// again, and returns its body and version.
+func fetchServiceWorker(t *testing.T) (body, version string) {
+	t.Helper()
+	s := startServer(t)
+	defer s.stop(t)
+	code, body := get(t, s.url("/sw.js"))
+	if code != http.StatusOK {
+		t.Fatalf("/sw.js: status %d", code)
+	}
+	m := swVersion.FindStringSubmatch(body)
+	if m == nil {
+		t.Fatal("/sw.js does not declare SW_VERSION")
+	}
+	return body, m[1]
+}
+
+func TestServiceWorkerPrecachesEmbeddedAssets(t *testing.T) {
+	body, _ := fetchServiceWorker(t)
+	scripts, err := fs.Glob(staticFS, "src/*.js")
+	if err != nil || len(scripts) == 0 {
+		t.Fatalf("no embedded scripts: %v", err)
+	}
+	for _, name := range append(scripts, "index.html") {
+		stem := "/" + strings.TrimSuffix(name, ".js")
+		if strings.HasSuffix(name, ".js") {
+			stem += "."
+		}
+		if !strings.Contains(body, `"`+stem) {
+			t.Errorf("precache list lacks %s", name)
+		}
+	}
+	if strings.Contains(body, "README") {
+		t.Error("precache list includes a README")
+	}
+}
+
+func TestServiceWorkerVersionStable(t *testing.T) {
+	_, first := fetchServiceWorker(t)
+	_, second := fetchServiceWorker(t)
+	if first != second {
+		t.Errorf("two servers over the same assets gave versions %s and %s", first, second)
+	}
+}
+
+func TestServiceWorkerHeaders(t *testing.T) {
+	s := startServer(t)
+	resp, err := http.Get(s.url("/sw.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); !strings.Contains(got, "javascript") {
+		t.Errorf("Content-Type = %q, want JavaScript", got)
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("Cache-Control = %q, want no-cache", got)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sessionTTL is how long a game session token stays valid.
+	sessionTTL = 2 * time.Hour
+	// sessionNamespace holds the start time of each active session, keyed
+	// by session ID, so that runs are timed by the server.
+	sessionNamespace = "sessions"
+	// spentNamespace holds the expiry of each token spent on a score,
+	// keyed by nonce, so that a restart does not let it be replayed.
+	spentNamespace = "spent-tokens"
+)
+
+var (
+	errBadToken       = errors.New("invalid session token")
+	errExpiredToken   = errors.New("session token expired")
+	errBadSignature   = errors.New("invalid score signature")
+	errReplayed       = errors.New("session token already used")
+	errUnknownSession = errors.New("session not found or expired")
+	errTooFast        = errors.New("run finished implausibly fast")
+)
+
+// sessionClaims is the signed payload of a session token.
+type sessionClaims struct {
+	ID      string `json:"id"`
+	Nonce   string `json:"nonce"`
+	Expires int64  `json:"exp"`
+}
+
+// sessions issues and verifies HMAC-signed game session tokens, times
+// each session from when it was issued, and remembers in the store which
+// tokens have already been spent on a score submission.
+type sessions struct {
+	secret []byte
+	ttl    time.Duration
+	store  Store
+	minRun time.Duration
+
+	mu sync.Mutex // serializes spending tokens
+}
+
+// newSessions returns sessions signed with secret whose start times and
+// spent tokens are kept in store. A run shorter than minRun is rejected
+// as implausible.
+func newSessions(secret []byte, store Store, minRun time.Duration) *sessions {
+	return &sessions{secret: secret, ttl: sessionTTL, store: store, minRun: minRun}
+}
+
+// sessionStart is the stored record of an active session.
+type sessionStart struct {
+	Started time.Time `json:"started"`
+	Expires time.Time `json:"expires"`
+}
+
+// spentToken is the stored record of a token spent on a score, kept
+// until the token would have expired anyway.
+type spentToken struct {
+	Expires time.Time `json:"expires"`
+}
+
+// start records that the session in claims began at now.
+func (s *sessions) start(claims sessionClaims, now time.Time) error {
+	data, err := json.Marshal(sessionStart{Started: now.UTC(), Expires: time.Unix(claims.Expires, 0).UTC()})
+	if err != nil {
+		return err
+	}
+	return s.store.Set(sessionNamespace, claims.ID, data)
+}
+
+// elapsed returns how long session id has been running at now. It fails
+// for a session that was never started or has expired, and for one
+// shorter than the plausible minimum.
+func (s *sessions) elapsed(id string, now time.Time) (time.Duration, error) {
+	data, err := s.store.Get(sessionNamespace, id)
+	if errors.Is(err, ErrNotFound) {
+		return 0, errUnknownSession
+	}
+	if err != nil {
+		return 0, err
+	}
+	var st sessionStart
+	if err := json.Unmarshal(data, &st); err != nil {
+		return 0, err
+	}
+	if !now.Before(st.Expires) {
+		return 0, errUnknownSession
+	}
+	d := now.Sub(st.Started)
+	if d < s.minRun {
+		return d, errTooFast
+	}
+	return d, nil
+}
+
+// randomID returns n random bytes, hex-encoded.
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *sessions) mac(parts ...string) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return h.Sum(nil)
+}
+
+// issue starts a new session and returns its token.
+func (s *sessions) issue(now time.Time) (string, sessionClaims) {
+	claims := sessionClaims{
+		ID:      randomID(16),
+		Nonce:   randomID(16),
+		Expires: now.Add(s.ttl).Unix(),
+	}
+	payload, _ := json.Marshal(claims)
+	enc := base64.RawURLEncoding
+	token := enc.EncodeToString(payload) + "." + enc.EncodeToString(s.mac("token", string(payload)))
+	return token, claims
+}
+
+// verify checks a token's signature and expiry and returns its claims.
+func (s *sessions) verify(token string, now time.Time) (sessionClaims, error) {
+	var claims sessionClaims
+	enc := base64.RawURLEncoding
+	payloadPart, sigPart, ok := strings.Cut(token, ".")
+	if !ok {
+		return claims, errBadToken
+	}
+	payload, err1 := enc.DecodeString(payloadPart)
+	sig, err2 := enc.DecodeString(sigPart)
+	if err1 != nil || err2 != nil || !hmac.Equal(sig, s.mac("token", string(payload))) {
+		return claims, errBadToken
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.ID == "" || claims.Nonce == "" {
+		return claims, errBadToken
+	}
+	if now.Unix() >= claims.Expires {
+		return claims, errExpiredToken
+	}
+	return claims, nil
+}
+
+// sessionKey is the per-session key a client signs its score with. It is
+// derived from the server secret, so it need not be stored.
+func (s *sessions) sessionKey(id string) []byte {
+	return s.mac("session-key", id)
+}
+
+// fromQuery verifies the session token in r's "session" query parameter
+// and returns its session ID, or "" if the parameter is absent.
+func (s *sessions) fromQuery(r *http.Request) (string, error) {
+	token := r.URL.Query().Get("session")
+	if token == "" {
+		return "", nil
+	}
+	claims, err := s.verify(token, time.Now())
+	if err != nil {
+		return "", err
+	}
+	return claims.ID, nil
+}
+
+// answerOrder returns the order in which the n answers of question id are
+// shown in session sessionID: position i holds canonical answer order[i].
+// It is derived from the server secret, so it is stable for a session but
+// cannot be predicted from the IDs alone.
+func (s *sessions) answerOrder(sessionID, id string, n int) []int {
+	seed := s.mac("answer-order", sessionID, id)
+	rng := mathrand.New(mathrand.NewPCG(binary.BigEndian.Uint64(seed[:8]), binary.BigEndian.Uint64(seed[8:16])))
+	return rng.Perm(n)
+}
+
+// shuffleAnswers returns q with its answers in the order of session
+// sessionID, or q unchanged if sessionID is "".
+func (s *sessions) shuffleAnswers(q PublicQuestion, sessionID string) PublicQuestion {
+	if sessionID == "" {
+		return q
+	}
+	shown := make([]string, len(q.Answers))
+	for i, j := range s.answerOrder(sessionID, q.ID, len(q.Answers)) {
+		shown[i] = q.Answers[j]
+	}
+	q.Answers = shown
+	return q
+}
+
+// scoreSignature is the hex HMAC-SHA256, under key, of
+// "<score>:<timeMs>:<sessionID>".
+func scoreSignature(key []byte, score int, timeMs int64, sessionID string) string {
+	h := hmac.New(sha256.New, key)
+	fmt.Fprintf(h, "%d:%d:%s", score, timeMs, sessionID)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// verifyScore checks that signature covers score and timeMs for the
+// session in token and that the token has not been spent. It does not
+// spend it: a submission turned away for its name or run time may be
+// retried, so the handler calls spend once the score is accepted.
+func (s *sessions) verifyScore(token, signature string, score int, timeMs int64, now time.Time) (sessionClaims, error) {
+	claims, err := s.verify(token, now)
+	if err != nil {
+		return claims, err
+	}
+	want := scoreSignature(s.sessionKey(claims.ID), score, timeMs, claims.ID)
+	if !hmac.Equal([]byte(want), []byte(strings.ToLower(signature))) {
+		return claims, errBadSignature
+	}
+	return claims, s.unspent(claims)
+}
+
+// unspent fails with errReplayed if the token behind claims has been
+// spent.
+func (s *sessions) unspent(claims sessionClaims) error {
+	_, err := s.store.Get(spentNamespace, claims.Nonce)
+	switch {
+	case err == nil:
+		return errReplayed
+	case errors.Is(err, ErrNotFound):
+		return nil
+	}
+	return err
+}
+
+// spend marks the token behind claims as used so it cannot be replayed,
+// failing with errReplayed if another submission spent it first.
+func (s *sessions) spend(claims sessionClaims) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.unspent(claims); err != nil {
+		return err
+	}
+	data, err := json.Marshal(spentToken{Expires: time.Unix(claims.Expires, 0).UTC()})
+	if err != nil {
+		return err
+	}
+	return s.store.Set(spentNamespace, claims.Nonce, data)
+}
+
+// collect forgets session start times, spent tokens, and the daily
+// challenges sessions were served once the sessions have expired, every
+// interval until ctx is cancelled.
+func (s *sessions) collect(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.collectExpired(sessionNamespace, now)
+			s.collectExpired(spentNamespace, now)
+			s.collectExpired(dailyServedNamespace, now)
+		}
+	}
+}
+
+// collectExpired deletes the records in namespace, session start times,
+// spent tokens, or the daily challenges sessions were served, whose
+// sessions expired at now. Each kind of record keeps that expiry under
+// "expires".
+func (s *sessions) collectExpired(namespace string, now time.Time) {
+	keys, err := s.store.List(namespace)
+	if err != nil {
+		slog.Warn("could not list sessions", "namespace", namespace, "err", err)
+		return
+	}
+	for _, key := range keys {
+		data, err := s.store.Get(namespace, key)
+		if err != nil {
+			continue
+		}
+		var rec spentToken
+		if json.Unmarshal(data, &rec) == nil && now.After(rec.Expires) {
+			s.store.Delete(namespace, key)
+		}
+	}
+}
+
+// sessionResponse is returned by POST /api/session.
+type sessionResponse struct {
+	SessionID  string    `json:"sessionID"`
+	Token      string    `json:"token"`
+	SessionKey string    `json:"sessionKey"`
+	StartedAt  time.Time `json:"startedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// sessionHandler serves POST /api/session, starting a game session and
+// its clock. The returned sessionKey (hex) is used to sign the final
+// score.
+func sessionHandler(s *sessions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		now := time.Now()
+		token, claims := s.issue(now)
+		if err := s.start(claims, now); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "could not start session")
+			return
+		}
+		writeJSON(w, http.StatusCreated, sessionResponse{
+			SessionID:  claims.ID,
+			Token:      token,
+			SessionKey: hex.EncodeToString(s.sessionKey(claims.ID)),
+			StartedAt:  now.UTC(),
+			ExpiresAt:  time.Unix(claims.Expires, 0).UTC(),
+		})
+	})
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSecret is the session secret of sessions made by the tests.
+var testSecret = []byte("a test secret of some length")
+
+func TestVerifyScoreAcceptsValidSubmission(t *testing.T) {
+	s := newSessions(testSecret, newMemoryStore(), 0)
+	now := time.Now()
+	token, claims := s.issue(now)
+	sig := scoreSignature(s.sessionKey(claims.ID), 420, 61000, claims.ID)
+	got, err := s.verifyScore(token, sig, 420, 61000, now)
+	if err != nil {
+		t.Fatalf("valid submission rejected: %v", err)
+	}
+	if got.ID != claims.ID {
+		t.Errorf("verified session %s, issued %s", got.ID, claims.ID)
+	}
+}
+
+func TestVerifyScoreRejectsForgery(t *testing.T) {
+	s := newSessions(testSecret, newMemoryStore(), 0)
+	now := time.Now()
+	token, claims := s.issue(now)
+	sig := scoreSignature(s.sessionKey(claims.ID), 420, 61000, claims.ID)
+
+	if _, err := s.verifyScore(token, sig, 9999, 61000, now); !errors.Is(err, errBadSignature) {
+		t.Errorf("score raised after signing: err = %v, want errBadSignature", err)
+	}
+	if _, err := s.verifyScore(token, strings.Repeat("0", len(sig)), 420, 61000, now); !errors.Is(err, errBadSignature) {
+		t.Errorf("made-up signature: err = %v, want errBadSignature", err)
+	}
+	// A client signing with a key of its own choosing does no better.
+	forged := scoreSignature([]byte("guessed key"), 420, 61000, claims.ID)
+	if _, err := s.verifyScore(token, forged, 420, 61000, now); !errors.Is(err, errBadSignature) {
+		t.Errorf("signature under another key: err = %v, want errBadSignature", err)
+	}
+	// Nor does one whose token comes from a server with another secret.
+	other := newSessions([]byte("another secret entirely"), newMemoryStore(), 0)
+	otherToken, otherClaims := other.issue(now)
+	otherSig := scoreSignature(other.sessionKey(otherClaims.ID), 420, 61000, otherClaims.ID)
+	if _, err := s.verifyScore(otherToken, otherSig, 420, 61000, now); !errors.Is(err, errBadToken) {
+		t.Errorf("token signed with another secret: err = %v, want errBadToken", err)
+	}
+}
+
+func TestVerifyScoreRejectsReplay(t *testing.T) {
+	s := newSessions(testSecret, newMemoryStore(), 0)
+	now := time.Now()
+	token, claims := s.issue(now)
+	sig := scoreSignature(s.sessionKey(claims.ID), 420, 61000, claims.ID)
+	if _, err := s.verifyScore(token, sig, 420, 61000, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.verifyScore(token, sig, 420, 61000, now); err != nil {
+		t.Errorf("a token verified but not spent: err = %v, want nil", err)
+	}
+	if err := s.spend(claims); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.verifyScore(token, sig, 420, 61000, now); !errors.Is(err, errReplayed) {
+		t.Errorf("replayed nonce: err = %v, want errReplayed", err)
+	}
+	if err := s.spend(claims); !errors.Is(err, errReplayed) {
+		t.Errorf("spending twice: err = %v, want errReplayed", err)
+	}
+}
+
+func TestSpentTokensOutliveRestart(t *testing.T) {
+	store := newMemoryStore()
+	s := newSessions(testSecret, store, 0)
+	now := time.Now()
+	token, claims := s.issue(now)
+	sig := scoreSignature(s.sessionKey(claims.ID), 420, 61000, claims.ID)
+	if err := s.spend(claims); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newSessions(testSecret, store, 0)
+	if _, err := restarted.verifyScore(token, sig, 420, 61000, now); !errors.Is(err, errReplayed) {
+		t.Errorf("a token spent before a restart: err = %v, want errReplayed", err)
+	}
+	restarted.collectExpired(spentNamespace, now)
+	if _, err := store.Get(spentNamespace, claims.Nonce); err != nil {
+		t.Errorf("a live spent token collected: err = %v", err)
+	}
+	restarted.collectExpired(spentNamespace, now.Add(sessionTTL+time.Second))
+	if _, err := store.Get(spentNamespace, claims.Nonce); !errors.Is(err, ErrNotFound) {
+		t.Errorf("an expired spent token still stored: err = %v", err)
+	}
+}
+
+func TestVerifyRejectsExpiredAndTamperedTokens(t *testing.T) {
+	s := newSessions(testSecret, newMemoryStore(), 0)
+	now := time.Now()
+	token, _ := s.issue(now)
+	if _, err := s.verify(token, now.Add(sessionTTL)); !errors.Is(err, errExpiredToken) {
+		t.Errorf("expired token: err = %v, want errExpiredToken", err)
+	}
+	payload, sig, _ := strings.Cut(token, ".")
+	for _, bad := range []string{"", "no-dot", payload + "." + sig + "x", "x" + payload + "." + sig} {
+		if _, err := s.verify(bad, now); !errors.Is(err, errBadToken) {
+			t.Errorf("token %q: err = %v, want errBadToken", bad, err)
+		}
+	}
+}
+
+// startSession starts a game session on the server and returns it.
+func startSession(t *testing.T, s *runningServer) sessionResponse {
+	t.Helper()
+	var sess sessionResponse
+	if code := postJSON(t, s.url("/api/session"), nil, &sess); code != http.StatusCreated {
+		t.Fatalf("POST /api/session: status %d", code)
+	}
+	return sess
+}
+
+// submitScore signs a score of score in timeMs for sess as the client
+// does and posts it to the leaderboard as name, returning the status.
+func submitScore(t *testing.T, s *runningServer, sess sessionResponse, name string, score int, timeMs int64) int {
+	t.Helper()
+	return submitScoreTo(t, s, "/api/leaderboard", sess, name, score, timeMs)
+}
+
+// submitScoreTo is submitScore to the leaderboard endpoint path.
+func submitScoreTo(t *testing.T, s *runningServer, path string, sess sessionResponse, name string, score int, timeMs int64) int {
+	t.Helper()
+	key, err := hex.DecodeString(sess.SessionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return postJSON(t, s.url(path), map[string]any{
+		"name":      name,
+		"score":     score,
+		"timeMs":    timeMs,
+		"token":     sess.Token,
+		"signature": scoreSignature(key, score, timeMs, sess.SessionID),
+	}, nil)
+}
+
+func TestSignedLeaderboardSubmission(t *testing.T) {
+	s := startServer(t, "-min-run-time", "0s", "-session-secret", string(testSecret))
+	sess := startSession(t, s)
+	if code := submitScore(t, s, sess, "ada", 0, 1000); code != http.StatusCreated {
+		t.Fatalf("signed submission: status %d, want 201", code)
+	}
+	if code := submitScore(t, s, sess, "ada", 0, 1000); code != http.StatusForbidden {
+		t.Errorf("replayed submission: status %d, want 403", code)
+	}
+
+	forged := startSession(t, s)
+	forged.SessionKey = hex.EncodeToString([]byte("not the session key"))
+	if code := submitScore(t, s, forged, "mallory", 0, 1000); code != http.StatusForbidden {
+		t.Errorf("forged signature: status %d, want 403", code)
+	}
+	if code := postJSON(t, s.url("/api/leaderboard"), map[string]any{"name": "eve", "score": 0, "timeMs": 1000}, nil); code != http.StatusForbidden {
+		t.Errorf("unsigned submission: status %d, want 403", code)
+	}
+}
+
+func TestAnswerOrderIsStablePermutation(t *testing.T) {
+	s := newSessions(testSecret, newMemoryStore(), 0)
+	order := s.answerOrder("session-a", "q001", 4)
+	if sorted := slices.Sorted(slices.Values(order)); !slices.Equal(sorted, []int{0, 1, 2, 3}) {
+		t.Fatalf("order %v is not a permutation of 4 answers", order)
+	}
+	if again := s.answerOrder("session-a", "q001", 4); !slices.Equal(order, again) {
+		t.Errorf("one session got orders %v and %v", order, again)
+	}
+	q := PublicQuestion{ID: "q001", Answers: []string{"a", "b", "c", "d"}}
+	if got := s.shuffleAnswers(q, ""); !slices.Equal(got.Answers, q.Answers) {
+		t.Errorf("without a session the answers were reordered to %v", got.Answers)
+	}
+}
+
+// shownQuestions returns the questions /api/questions shows session sess,
+// by ID, with their answers in the order shown.
+func shownQuestions(t *testing.T, s *runningServer, sess sessionResponse) map[string][]string {
+	t.Helper()
+	var body struct {
+		Questions []struct {
+			ID      string   `json:"id"`
+			Answers []string `json:"answers"`
+		} `json:"questions"`
+	}
+	if code := getJSON(t, s.url("/api/questions?count=50&session="+url.QueryEscape(sess.Token)), &body); code != http.StatusOK {
+		t.Fatalf("/api/questions in a session: status %d", code)
+	}
+	shown := make(map[string][]string, len(body.Questions))
+	for _, q := range body.Questions {
+		shown[q.ID] = q.Answers
+	}
+	return shown
+}
+
+func TestAnswersShuffledPerSession(t *testing.T) {
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := startServer(t, "-session-secret", string(testSecret), "-rate-limit", "1000", "-rate-burst", "1000")
+	a, b := startSession(t, s), startSession(t, s)
+	shownA, shownB := shownQuestions(t, s, a), shownQuestions(t, s, b)
+	if again := shownQuestions(t, s, a); !reflect.DeepEqual(again, shownA) {
+		t.Error("one session was shown two different answer orders")
+	}
+
+	differ := 0
+	for _, q := range questions {
+		if !slices.Equal(shownA[q.ID], shownB[q.ID]) {
+			differ++
+		}
+		if !slices.Equal(slices.Sorted(slices.Values(shownA[q.ID])), slices.Sorted(slices.Values(q.Answers))) {
+			t.Errorf("%s: shown answers %v are not the question's", q.ID, shownA[q.ID])
+		}
+	}
+	if differ == 0 {
+		t.Error("two sessions saw every question's answers in the same order")
+	}
+
+	// Grading maps the shown position back to the answer key.
+	for _, q := range questions[:5] {
+		shown := slices.Index(shownA[q.ID], q.Answers[q.CorrectAnswer])
+		var resp struct {
+			Correct bool `json:"correct"`
+		}
+		if code := postJSON(t, s.url("/api/answer?session="+url.QueryEscape(a.Token)), map[string]any{"questionID": q.ID, "choiceIndex": shown}, &resp); code != http.StatusOK {
+			t.Fatalf("%s: status %d", q.ID, code)
+		}
+		if !resp.Correct {
+			t.Errorf("%s: the correct answer at shown position %d was graded wrong", q.ID, shown)
+		}
+	}
+}
+
+func TestSessionElapsedTimedByServer(t *testing.T) {
+	s := newSessions(testSecret, newMemoryStore(), 30*time.Second)
+	start := time.Now()
+	_, claims := s.issue(start)
+	if err := s.start(claims, start); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := s.elapsed(claims.ID, start.Add(5*time.Minute)); err != nil || d != 5*time.Minute {
+		t.Errorf("a five-minute run: elapsed %v, err %v", d, err)
+	}
+	if _, err := s.elapsed(claims.ID, start.Add(10*time.Second)); !errors.Is(err, errTooFast) {
+		t.Errorf("a ten-second run: err = %v, want errTooFast", err)
+	}
+	if _, err := s.elapsed(claims.ID, start.Add(sessionTTL)); !errors.Is(err, errUnknownSession) {
+		t.Errorf("an expired session: err = %v, want errUnknownSession", err)
+	}
+	if _, err := s.elapsed("never-started", start); !errors.Is(err, errUnknownSession) {
+		t.Errorf("an unknown session: err = %v, want errUnknownSession", err)
+	}
+
+	s.collectExpired(sessionNamespace, start.Add(sessionTTL+time.Second))
+	if _, err := s.store.Get(sessionNamespace, claims.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expired session start still stored: err = %v", err)
+	}
+}
+
+func TestLeaderboardTimeComesFromServer(t *testing.T) {
+	s := startServer(t, "-min-run-time", "0s", "-session-secret", string(testSecret))
+	sess := startSession(t, s)
+	if code := submitScore(t, s, sess, "ada", 0, 1); code != http.StatusCreated {
+		t.Fatalf("timed run: status %d, want 201", code)
+	}
+	if code := submitScore(t, s, startSession(t, s), "bob", 0, 24*time.Hour.Milliseconds()); code != http.StatusCreated {
+		t.Fatalf("timed run: status %d, want 201", code)
+	}
+	var body struct {
+		Entries []LeaderboardEntry `json:"entries"`
+	}
+	getJSON(t, s.url("/api/leaderboard"), &body)
+	if len(body.Entries) != 2 {
+		t.Fatalf("entries %+v, want two", body.Entries)
+	}
+	for _, e := range body.Entries {
+		if e.TimeMs < 0 || e.TimeMs > time.Minute.Milliseconds() {
+			t.Errorf("%s recorded %dms, the claimed time rather than the server's", e.Name, e.TimeMs)
+		}
+	}
+}
+
+func TestTooFastRunRejected(t *testing.T) {
+	s := startServer(t, "-min-run-time", "1h", "-session-secret", string(testSecret))
+	if code := submitScore(t, s, startSession(t, s), "ada", 0, 2*time.Hour.Milliseconds()); code != http.StatusForbidden {
+		t.Errorf("run shorter than -min-run-time: status %d, want 403", code)
+	}
+}
+
+func TestSessionStartsSurviveRestart(t *testing.T) {
+	inTempDir(t)
+	args := []string{"-min-run-time", "0s", "-session-secret", string(testSecret)}
+	s := startServerIn(t, args...)
+	sess := startSession(t, s)
+	if err := s.stop(t); err != nil {
+		t.Fatal(err)
+	}
+	s = startServerIn(t, args...)
+	if code := submitScore(t, s, sess, "ada", 0, 1000); code != http.StatusCreated {
+		t.Errorf("session started before a restart: status %d, want 201", code)
+	}
+}
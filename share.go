@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Kinds of share link: the recording of a session, or only its score.
+const (
+	shareReplay = "replay"
+	shareScore  = "score"
+)
+
+const (
+	// defaultShareTTL is how long a share link stays valid unless its
+	// creator asks otherwise.
+	defaultShareTTL = 24 * time.Hour
+	// maxShareTTL caps a share link's lifetime at how long the recording
+	// it shows is kept.
+	maxShareTTL = replayRetention
+	// maxShareBody bounds a share link request.
+	maxShareBody = 1 << 10
+)
+
+var (
+	errBadShare     = errors.New("invalid share link")
+	errExpiredShare = errors.New("share link expired")
+)
+
+// shareClaims is the signed payload of a share link token: what it shows,
+// of which session, and until when.
+type shareClaims struct {
+	Kind    string `json:"kind"`
+	ID      string `json:"id"`
+	Expires int64  `json:"exp"`
+}
+
+// issueShare returns the token of a share link for claims, signed like a
+// session token but under a purpose of its own, so that neither can pass
+// for the other.
+func (s *sessions) issueShare(claims shareClaims) string {
+	payload, _ := json.Marshal(claims)
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(s.mac("share", string(payload)))
+}
+
+// verifyShare checks a share link token's signature and expiry and
+// returns its claims.
+func (s *sessions) verifyShare(token string, now time.Time) (shareClaims, error) {
+	var claims shareClaims
+	enc := base64.RawURLEncoding
+	payloadPart, sigPart, ok := strings.Cut(token, ".")
+	if !ok {
+		return claims, errBadShare
+	}
+	payload, err1 := enc.DecodeString(payloadPart)
+	sig, err2 := enc.DecodeString(sigPart)
+	if err1 != nil || err2 != nil || !hmac.Equal(sig, s.mac("share", string(payload))) {
+		return claims, errBadShare
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.ID == "" || (claims.Kind != shareReplay && claims.Kind != shareScore) {
+		return claims, errBadShare
+	}
+	if now.Unix() >= claims.Expires {
+		return claims, errExpiredShare
+	}
+	return claims, nil
+}
+
+// shareRequest is the body of POST /api/share. TTL is in seconds.
+type shareRequest struct {
+	Kind string `json:"kind"`
+	TTL  int64  `json:"ttl,omitempty"`
+}
+
+// shareResponse is a new share link.
+type shareResponse struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// shareHandler serves POST /api/share?session=S, which returns a link to
+// a read-only page showing the replay or the score of session S until the
+// link expires. Links are absolute, under publicOrigin and basePath.
+func shareHandler(rp *replays, sess *sessions, publicURL, basePath string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		sessionID, err := sess.fromQuery(r)
+		if err != nil {
+			writeAPIError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if sessionID == "" {
+			writeAPIError(w, http.StatusBadRequest, "session is required")
+			return
+		}
+		var req shareRequest
+		r.Body = http.MaxBytesReader(w, r.Body, maxShareBody)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeBodyError(w, err, "invalid JSON body")
+			return
+		}
+		if req.Kind != shareReplay && req.Kind != shareScore {
+			writeAPIError(w, http.StatusBadRequest, `kind must be "replay" or "score"`)
+			return
+		}
+		ttl := defaultShareTTL
+		if req.TTL != 0 {
+			ttl = time.Duration(req.TTL) * time.Second
+		}
+		if ttl <= 0 || ttl > maxShareTTL {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("ttl must be between 1 and %d seconds", int64(maxShareTTL/time.Second)))
+			return
+		}
+		if _, err := rp.Load(sessionID); errors.Is(err, ErrNotFound) {
+			writeAPIError(w, http.StatusNotFound, "no replay for this session")
+			return
+		} else if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "could not load replay")
+			return
+		}
+		expires := time.Now().Add(ttl).Truncate(time.Second).UTC()
+		token := sess.issueShare(shareClaims{Kind: req.Kind, ID: sessionID, Expires: expires.Unix()})
+		writeJSON(w, http.StatusCreated, shareResponse{URL: publicOrigin(publicURL, r) + basePath + "/share/" + token, Expires: expires})
+	})
+}
+
+// sharePageTemplate renders a shared replay or score, in the theme of the
+// error pages and without script.
+var sharePageTemplate = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="robots" content="noindex">
+    <title>{{.Title}} - LobeLabyrinth</title>
+    <link rel="stylesheet" href="{{.Base}}/css/game.css">
+    <link rel="stylesheet" href="{{.Base}}/theme.css">
+</head>
+<body class="help-page">
+<main class="help-content share-page">
+<h1>🏰 {{.Title}}</h1>
+<p>A run through the labyrinth begun {{.Started}}.</p>
+<table>
+<tr><th>Score</th><td>{{.Score}}</td></tr>
+<tr><th>Questions answered</th><td>{{.Answered}}</td></tr>
+<tr><th>Answered correctly</th><td>{{.Correct}}</td></tr>
+<tr><th>Hint penalty</th><td>{{.Penalty}}</td></tr>
+</table>
+{{- if .Events}}
+<h2>The Run</h2>
+<table>
+<tr><th>Time</th><th>Event</th><th>Points</th></tr>
+{{- range .Events}}
+<tr><td>{{.Time}}</td><td>{{.What}}</td><td>{{.Points}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+<p>This link expires {{.Expires}}.</p>
+<p><a href="{{.Base}}/">Enter the castle yourself</a></p>
+</main>
+</body>
+</html>
+`))
+
+// sharePage is what sharePageTemplate shows. Events are left out of score
+// links.
+type sharePage struct {
+	Title, Started, Expires, Score, Answered, Correct, Penalty string
+	Events                                                     []shareEvent
+	Base                                                       string
+}
+
+// shareEvent is one step of a shared replay, as shown.
+type shareEvent struct {
+	Time, What, Points string
+}
+
+// newSharePage summarizes rec for the link of claims, writing numbers and
+// dates for loc.
+func newSharePage(claims shareClaims, rec replay, loc locale) sharePage {
+	var points, penalty, answered, correct int
+	var events []shareEvent
+	for _, ev := range rec.Events {
+		var what string
+		switch ev.Type {
+		case replayRoom:
+			what = "Entered the " + ev.Room
+		case replayQuestion:
+			what = "Was asked question " + ev.Question
+		case replayAnswer:
+			answered++
+			points += ev.Delta
+			what = "Answered question " + ev.Question + " wrongly"
+			if ev.Correct != nil && *ev.Correct {
+				correct++
+				what = "Answered question " + ev.Question + " correctly"
+			}
+		case replayHint:
+			penalty -= ev.Delta
+			what = "Took a hint on question " + ev.Question
+		default:
+			continue
+		}
+		e := shareEvent{Time: fmt.Sprintf("%.1fs", float64(ev.T)/1000), What: what}
+		if ev.Delta != 0 {
+			e.Points = fmt.Sprintf("%+d", ev.Delta)
+		}
+		events = append(events, e)
+	}
+	page := sharePage{
+		Title:    "A Shared Score",
+		Started:  loc.Date(rec.Started),
+		Expires:  loc.Date(time.Unix(claims.Expires, 0)),
+		Score:    loc.Int(int64(max(0, points-penalty))),
+		Answered: loc.Int(int64(answered)),
+		Correct:  loc.Int(int64(correct)),
+		Penalty:  loc.Int(int64(penalty)),
+	}
+	if claims.Kind == shareReplay {
+		page.Title = "A Shared Replay"
+		page.Events = events
+	}
+	return page
+}
+
+// sharePageHandler serves GET /share/{token}, the read-only page of a
+// share link made by shareHandler. Links that are expired or not signed
+// by this server are 403 Forbidden.
+func sharePageHandler(rp *replays, sess *sessions, langs *languageRegistry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeError(w, r, http.StatusMethodNotAllowed)
+			return
+		}
+		claims, err := sess.verifyShare(r.PathValue("token"), time.Now())
+		if err != nil {
+			writeError(w, r, http.StatusForbidden)
+			return
+		}
+		rec, err := rp.Load(claims.ID)
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, r, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError)
+			return
+		}
+		page := newSharePage(claims, rec, langs.locale(r))
+		page.Base = requestBasePath(r)
+		w.Header().Add("Vary", "Accept-Language")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Robots-Tag", "noindex")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		sharePageTemplate.Execute(w, page)
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShareTokens(t *testing.T) {
+	sess := newSessions(testSecret, newMemoryStore(), 0)
+	now := time.Now()
+	claims := shareClaims{Kind: shareReplay, ID: "session-1", Expires: now.Add(time.Hour).Unix()}
+	token := sess.issueShare(claims)
+	if got, err := sess.verifyShare(token, now); err != nil || got != claims {
+		t.Fatalf("verifyShare = %+v, %v; want %+v", got, err, claims)
+	}
+	if _, err := sess.verifyShare(token, now.Add(time.Hour)); !errors.Is(err, errExpiredShare) {
+		t.Errorf("at its expiry: %v, want errExpiredShare", err)
+	}
+
+	payload, sig, _ := strings.Cut(token, ".")
+	other := sess.issueShare(shareClaims{Kind: shareScore, ID: "session-2", Expires: claims.Expires})
+	otherPayload, _, _ := strings.Cut(other, ".")
+	sessionToken, _ := sess.issue(now)
+	tests := map[string]string{
+		"another link's payload": otherPayload + "." + sig,
+		"altered signature":      payload + "." + strings.Repeat("A", len(sig)),
+		"no signature":           payload,
+		"garbled":                "!!.??",
+		"other secret":           newSessions([]byte("another secret"), newMemoryStore(), 0).issueShare(claims),
+		"session token":          sessionToken,
+		"unknown kind":           sess.issueShare(shareClaims{Kind: "profile", ID: "session-1", Expires: claims.Expires}),
+		"no session":             sess.issueShare(shareClaims{Kind: shareScore, Expires: claims.Expires}),
+	}
+	for name, token := range tests {
+		if _, err := sess.verifyShare(token, now); !errors.Is(err, errBadShare) {
+			t.Errorf("%s: %v, want errBadShare", name, err)
+		}
+	}
+}
+
+// createShare asks s for a share link of kind to the run of sess and
+// returns the status and link.
+func createShare(t *testing.T, s *runningServer, sess sessionResponse, kind string, ttl int64) (int, shareResponse) {
+	t.Helper()
+	var link shareResponse
+	code := postJSON(t, s.url("/api/share?session="+url.QueryEscape(sess.Token)), shareRequest{Kind: kind, TTL: ttl}, &link)
+	return code, link
+}
+
+func TestShareLinks(t *testing.T) {
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := questions[0]
+	s := startServer(t, "-session-secret", string(testSecret))
+	sess := startSession(t, s)
+	if code, _ := createShare(t, s, sess, shareReplay, 0); code != http.StatusNotFound {
+		t.Errorf("a run with nothing recorded: status %d, want 404", code)
+	}
+	choice := slices.Index(shownQuestions(t, s, sess)[q.ID], q.Answers[q.CorrectAnswer])
+	if code := postJSON(t, s.url("/api/answer?session="+url.QueryEscape(sess.Token)), map[string]any{"questionID": q.ID, "choiceIndex": choice}, nil); code != http.StatusOK {
+		t.Fatalf("answer: status %d", code)
+	}
+
+	code, link := createShare(t, s, sess, shareReplay, 0)
+	if code != http.StatusCreated || !strings.HasPrefix(link.URL, s.url("/share/")) {
+		t.Fatalf("replay link: status %d, %+v", code, link)
+	}
+	if d := time.Until(link.Expires); d < defaultShareTTL-time.Minute || d > defaultShareTTL {
+		t.Errorf("link expires %v, want in %v", link.Expires, defaultShareTTL)
+	}
+	code, page := get(t, link.URL)
+	if code != http.StatusOK || !strings.Contains(page, "A Shared Replay") || !strings.Contains(page, "Answered question "+q.ID+" correctly") {
+		t.Errorf("replay page: status %d\n%s", code, page)
+	}
+	if strings.Contains(page, "<script") {
+		t.Error("the share page runs script")
+	}
+
+	_, link = createShare(t, s, sess, shareScore, 60)
+	code, page = get(t, link.URL)
+	if code != http.StatusOK || !strings.Contains(page, "A Shared Score") || strings.Contains(page, "The Run") {
+		t.Errorf("score page: status %d\n%s", code, page)
+	}
+
+	// The server signed this one, but it has run out.
+	expired := newSessions(testSecret, newMemoryStore(), 0).issueShare(shareClaims{Kind: shareReplay, ID: sess.SessionID, Expires: time.Now().Add(-time.Minute).Unix()})
+	if code, page := getAccept(t, s.url("/share/"+expired), "text/html"); code != http.StatusForbidden || !strings.Contains(page, "The Seal Is Broken") {
+		t.Errorf("expired link: status %d, want the 403 page", code)
+	}
+	payload, sig, _ := strings.Cut(strings.TrimPrefix(link.URL, s.url("/share/")), ".")
+	tampered := payload[:len(payload)-2] + "x" + payload[len(payload)-1:] + "." + sig
+	if code, _ := get(t, s.url("/share/"+tampered)); code != http.StatusForbidden {
+		t.Errorf("tampered link: status %d, want 403", code)
+	}
+}
+
+func TestShareRejectsBadRequests(t *testing.T) {
+	s := startServer(t, "-session-secret", string(testSecret))
+	if code := postJSON(t, s.url("/api/share"), shareRequest{Kind: shareScore}, nil); code != http.StatusBadRequest {
+		t.Errorf("no session: status %d, want 400", code)
+	}
+	if code := postJSON(t, s.url("/api/share?session=forged"), shareRequest{Kind: shareScore}, nil); code != http.StatusForbidden {
+		t.Errorf("forged session: status %d, want 403", code)
+	}
+	sess := startSession(t, s)
+	for name, req := range map[string]shareRequest{
+		"unknown kind": {Kind: "profile"},
+		"negative ttl": {Kind: shareScore, TTL: -1},
+		"ttl too long": {Kind: shareScore, TTL: int64(maxShareTTL/time.Second) + 1},
+	} {
+		if code, _ := createShare(t, s, sess, req.Kind, req.TTL); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, code)
+		}
+	}
+	if code, _ := get(t, s.url("/api/share")); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /api/share: status %d, want 405", code)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteFile is the database the sqlite store opens in the store
+// directory when no DSN is given.
+const sqliteFile = "lobelabyrinth.db"
+
+// sqliteMigrations build the SQLite schema, one step per schema version;
+// the database's user_version records how many have been applied. Values
+// are keyed by (namespace, key), whose primary key index also serves
+// List in key order, so the leaderboards and statistics need no other.
+var sqliteMigrations = []string{
+	`CREATE TABLE kv (
+		namespace TEXT NOT NULL,
+		key       TEXT NOT NULL,
+		value     BLOB NOT NULL,
+		PRIMARY KEY (namespace, key)
+	) WITHOUT ROWID`,
+}
+
+// sqliteStore keeps values in a SQLite database. It uses a single
+// connection, which SQLite serializes writes on anyway, so an in-memory
+// database is shared by every caller.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+// sqliteQuerier is what the Store methods need of a database or a
+// transaction.
+type sqliteQuerier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// newSQLiteStore opens the SQLite database dsn, such as a file name,
+// ":memory:", or a "file:" URI, and brings its schema up to date. The
+// directory of a plain file name is created if needed.
+func newSQLiteStore(dsn string) (*sqliteStore, error) {
+	if dir := filepath.Dir(dsn); dsn != ":memory:" && !strings.HasPrefix(dsn, "file:") && dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite store %s: %w", dsn, err)
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+// migrateSQLite applies the sqliteMigrations db has not had yet, each in
+// a transaction of its own.
+func migrateSQLite(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("schema version %d is newer than this server's %d", version, len(sqliteMigrations))
+	}
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStore) Get(namespace, key string) ([]byte, error) {
+	return sqliteGet(s.db, namespace, key)
+}
+
+func (s *sqliteStore) Set(namespace, key string, value []byte) error {
+	return sqliteSet(s.db, namespace, key, value)
+}
+
+func (s *sqliteStore) List(namespace string) ([]string, error) {
+	return sqliteList(s.db, namespace)
+}
+
+func (s *sqliteStore) Delete(namespace, key string) error {
+	return sqliteDelete(s.db, namespace, key)
+}
+
+// Update runs fn in a transaction, committing the changes it makes
+// through its Store if it returns nil and discarding them otherwise.
+func (s *sqliteStore) Update(fn func(Store) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(sqliteTx{tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Close closes the database.
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+// sqliteTx is the Store view of a transaction that Update passes on.
+type sqliteTx struct {
+	tx *sql.Tx
+}
+
+func (t sqliteTx) Get(namespace, key string) ([]byte, error) {
+	return sqliteGet(t.tx, namespace, key)
+}
+
+func (t sqliteTx) Set(namespace, key string, value []byte) error {
+	return sqliteSet(t.tx, namespace, key, value)
+}
+
+func (t sqliteTx) List(namespace string) ([]string, error) {
+	return sqliteList(t.tx, namespace)
+}
+
+func (t sqliteTx) Delete(namespace, key string) error {
+	return sqliteDelete(t.tx, namespace, key)
+}
+
+func sqliteGet(q sqliteQuerier, namespace, key string) ([]byte, error) {
+	if err := checkStoreNames(namespace, key); err != nil {
+		return nil, err
+	}
+	var value []byte
+	err := q.QueryRow(`SELECT value FROM kv WHERE namespace = ? AND key = ?`, namespace, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if value == nil && err == nil {
+		value = []byte{}
+	}
+	return value, err
+}
+
+func sqliteSet(q sqliteQuerier, namespace, key string, value []byte) error {
+	if err := checkStoreNames(namespace, key); err != nil {
+		return err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	_, err := q.Exec(`INSERT INTO kv (namespace, key, value) VALUES (?, ?, ?)
+		ON CONFLICT (namespace, key) DO UPDATE SET value = excluded.value`, namespace, key, value)
+	return err
+}
+
+func sqliteList(q sqliteQuerier, namespace string) ([]string, error) {
+	if err := checkStoreNames(namespace); err != nil {
+		return nil, err
+	}
+	rows, err := q.Query(`SELECT key FROM kv WHERE namespace = ? ORDER BY key`, namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func sqliteDelete(q sqliteQuerier, namespace, key string) error {
+	if err := checkStoreNames(namespace, key); err != nil {
+		return err
+	}
+	res, err := q.Exec(`DELETE FROM kv WHERE namespace = ? AND key = ?`, namespace, key)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
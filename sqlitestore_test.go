@@ -0,0 +1,161 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openSQLite opens a SQLite store on dsn, closed at the end of the test.
+func openSQLite(t *testing.T, dsn string) *sqliteStore {
+	t.Helper()
+	s, err := newSQLiteStore(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSQLiteStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store { return openSQLite(t, ":memory:") })
+}
+
+func TestSQLiteStoreFile(t *testing.T) {
+	testStore(t, func(t *testing.T) Store { return openSQLite(t, filepath.Join(t.TempDir(), "db", sqliteFile)) })
+}
+
+func TestSQLiteStoreUpdate(t *testing.T) {
+	s := openSQLite(t, ":memory:")
+	errAbort := errors.New("abort")
+	err := s.Update(func(tx Store) error {
+		if err := tx.Set("saves", "a", []byte("1")); err != nil {
+			return err
+		}
+		if got, _ := tx.Get("saves", "a"); string(got) != "1" {
+			t.Errorf("the transaction does not see its own write: %q", got)
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("Update = %v, want fn's error", err)
+	}
+	if _, err := s.Get("saves", "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("a failed update was kept: err = %v", err)
+	}
+
+	err = s.Update(func(tx Store) error {
+		if err := tx.Set("saves", "a", []byte("1")); err != nil {
+			return err
+		}
+		return tx.Set("saves", "b", []byte("2"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := s.List("saves"); len(keys) != 2 {
+		t.Errorf("keys after a committed update: %v", keys)
+	}
+}
+
+func TestSQLiteStoreConcurrentUpdates(t *testing.T) {
+	s := openSQLite(t, filepath.Join(t.TempDir(), sqliteFile))
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.Update(func(tx Store) error {
+				n := 0
+				if data, err := tx.Get("stats", "count"); err == nil {
+					n, _ = strconv.Atoi(string(data))
+				}
+				return tx.Set("stats", "count", []byte(strconv.Itoa(n+1)))
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got, _ := s.Get("stats", "count"); string(got) != "20" {
+		t.Errorf("count after 20 concurrent increments = %q, want 20", got)
+	}
+}
+
+func TestSQLiteLeaderboardConcurrentSubmissions(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), sqliteFile)
+	lb, err := openLeaderboard(openSQLite(t, dsn), leaderboardKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e := LeaderboardEntry{Name: fmt.Sprintf("player%d", i), Score: i * 10, TimeMs: 1000, SubmittedAt: time.Now()}
+			if _, err := lb.Add(e); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	reopened, err := openLeaderboard(openSQLite(t, dsn), leaderboardKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if top := reopened.Top(maxLeaderboardEntries); len(top) != 50 || top[0].Score != 490 {
+		t.Errorf("reopened board has %d entries, want all 50 with 490 first", len(top))
+	}
+}
+
+func TestSQLiteSchemaVersion(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), sqliteFile)
+	s := openSQLite(t, dsn)
+	var version int
+	if err := s.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(sqliteMigrations) {
+		t.Errorf("user_version = %d, want %d", version, len(sqliteMigrations))
+	}
+	s.Close()
+
+	// Reopening does not apply the migrations again.
+	openSQLite(t, dsn).Close()
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, len(sqliteMigrations)+1))
+	db.Close()
+	if _, err := newSQLiteStore(dsn); err == nil {
+		t.Error("a database from a newer server was opened")
+	}
+}
+
+func TestServerOnSQLite(t *testing.T) {
+	inTempDir(t)
+	s := startServerIn(t, "-store", "sqlite", "-min-run-time", "0s")
+	if code := submitScore(t, s, startSession(t, s), "ada", 0, 1000); code != http.StatusCreated {
+		t.Fatalf("score: status %d", code)
+	}
+	s.stop(t)
+	if _, err := os.Stat(filepath.Join("store", sqliteFile)); err != nil {
+		t.Errorf("no database in the store directory: %v", err)
+	}
+	s = startServerIn(t, "-store", "sqlite")
+	if names := boardNames(t, s, "/api/leaderboard"); len(names) != 1 || names[0] != "ada" {
+		t.Errorf("leaderboard after a restart = %v, want [ada]", names)
+	}
+}
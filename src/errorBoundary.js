@@ -138,16 +138,16 @@ class ErrorBoundary {
                 </div>
                 
                 <div class="error-actions">
-                    <button class="error-btn error-btn-primary" onclick="this.handleRefresh()">
+                    <button class="error-btn error-btn-primary" data-action="refresh">
                         🔄 Refresh Game
                     </button>
-                    <button class="error-btn error-btn-secondary" onclick="this.handleContinue()">
+                    <button class="error-btn error-btn-secondary" data-action="continue">
                         ▶️ Continue Playing
                     </button>
-                    <button class="error-btn error-btn-secondary" onclick="this.handleSaveExit()">
+                    <button class="error-btn error-btn-secondary" data-action="save-exit">
                         💾 Save & Exit
                     </button>
-                    <button class="error-btn error-btn-tertiary" onclick="this.handleToggleDetails()">
+                    <button class="error-btn error-btn-tertiary" data-action="details">
                         🔍 Show Details
                     </button>
                 </div>
@@ -175,10 +175,10 @@ class ErrorBoundary {
      * @param {HTMLElement} dialog - Dialog element
      */
     setupDialogHandlers(dialog) {
-        const refreshBtn = dialog.querySelector('button[onclick*="handleRefresh"]');
-        const continueBtn = dialog.querySelector('button[onclick*="handleContinue"]');
-        const saveExitBtn = dialog.querySelector('button[onclick*="handleSaveExit"]');
-        const detailsBtn = dialog.querySelector('button[onclick*="handleToggleDetails"]');
+        const refreshBtn = dialog.querySelector('button[data-action="refresh"]');
+        const continueBtn = dialog.querySelector('button[data-action="continue"]');
+        const saveExitBtn = dialog.querySelector('button[data-action="save-exit"]');
+        const detailsBtn = dialog.querySelector('button[data-action="details"]');
 
         if (refreshBtn) {
             refreshBtn.onclick = () => this.handleRefresh();
@@ -247,11 +247,13 @@ class ErrorBoundary {
                 <div class="error-content">
                     <h3>💾 Game Saved Successfully</h3>
                     <p>Your progress has been saved. You can safely close the browser.</p>
-                    <button class="error-btn error-btn-primary" onclick="this.parentElement.parentElement.remove()">
+                    <button class="error-btn error-btn-primary" data-action="close">
                         ✓ Close
                     </button>
                 </div>
             `;
+            confirmDialog.querySelector('[data-action="close"]')
+                .addEventListener('click', () => confirmDialog.remove());
             
             document.body.appendChild(confirmDialog);
             
@@ -274,7 +276,7 @@ class ErrorBoundary {
      */
     handleToggleDetails(dialog) {
         const details = dialog.querySelector('.error-details');
-        const button = dialog.querySelector('button[onclick*="handleToggleDetails"]');
+        const button = dialog.querySelector('button[data-action="details"]');
         
         if (details && button) {
             const isVisible = details.style.display !== 'none';
@@ -316,15 +318,19 @@ class ErrorBoundary {
                 </div>
                 
                 <div class="critical-error-actions">
-                    <button class="error-btn error-btn-primary" onclick="window.location.reload()">
+                    <button class="error-btn error-btn-primary" data-action="reload">
                         🔄 Refresh Browser
                     </button>
-                    <button class="error-btn error-btn-secondary" onclick="this.showErrorLog()">
+                    <button class="error-btn error-btn-secondary" data-action="log">
                         📋 View Error Log
                     </button>
                 </div>
             </div>
         `;
+        criticalScreen.querySelector('[data-action="reload"]')
+            .addEventListener('click', () => window.location.reload());
+        criticalScreen.querySelector('[data-action="log"]')
+            .addEventListener('click', () => this.showErrorLog());
 
         document.body.appendChild(criticalScreen);
     }
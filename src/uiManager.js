@@ -1078,7 +1078,7 @@ Press any key to close this help.
                     <br>
                     ${stats.totalPoints} total points
                 </div>
-                <button class="achievement-close-btn" onclick="this.closest('.achievement-gallery').classList.remove('visible')">×</button>
+                <button class="achievement-close-btn">×</button>
             </div>
             <div class="achievement-categories">
         `;
@@ -1128,6 +1128,8 @@ Press any key to close this help.
         galleryHTML += '</div>';
         
         this.elements.achievementGallery.innerHTML = galleryHTML;
+        this.elements.achievementGallery.querySelector('.achievement-close-btn')
+            .addEventListener('click', () => this.elements.achievementGallery.classList.remove('visible'));
     }
 
     /**
@@ -1326,7 +1328,7 @@ Press any key to close this help.
                 availableRooms.map(async (roomId) => {
                     const room = await this.dataLoader.getRoom(roomId);
                     const roomName = room ? room.name : roomId;
-                    return `<button class="room-nav-btn" onclick="uiManager.moveToRoom('${roomId}')">${roomName}</button>`;
+                    return `<button class="room-nav-btn" data-room-id="${roomId}">${roomName}</button>`;
                 })
             );
 
@@ -1334,6 +1336,9 @@ Press any key to close this help.
                 <h4>Available Rooms:</h4>
                 <div class="room-nav-buttons">${roomButtons.join('')}</div>
             `;
+            this.elements.availableRooms.querySelectorAll('.room-nav-btn').forEach(button => {
+                button.addEventListener('click', () => this.moveToRoom(button.dataset.roomId));
+            });
 
         } catch (error) {
             console.error('Failed to update navigation options:', error);
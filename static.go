@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// staticMaxAge is how long browsers may reuse a static asset before
+// revalidating it against its ETag.
+const staticMaxAge = 3600
+
+// manifestType is the content type of the web app manifest, whatever its
+// extension.
+const manifestType = "application/manifest+json"
+
+// defaultAssetTypes are the content types static files are served with,
+// by lower-case extension, in place of the host's MIME tables, which
+// differ between systems and lack or mislabel some modern formats.
+// Scripts are text/javascript, which browsers insist on for modules.
+// Together with X-Content-Type-Options: nosniff, these are the types
+// browsers act on. -asset-types adds to and overrides them.
+var defaultAssetTypes = map[string]string{
+	".css":         "text/css; charset=utf-8",
+	".gif":         "image/gif",
+	".html":        "text/html; charset=utf-8",
+	".ico":         "image/x-icon",
+	".jpeg":        "image/jpeg",
+	".jpg":         "image/jpeg",
+	".js":          "text/javascript; charset=utf-8",
+	".json":        "application/json",
+	".md":          "text/markdown; charset=utf-8",
+	".mjs":         "text/javascript; charset=utf-8",
+	".otf":         "font/otf",
+	".png":         "image/png",
+	".svg":         "image/svg+xml",
+	".ttf":         "font/ttf",
+	".txt":         "text/plain; charset=utf-8",
+	".wasm":        "application/wasm",
+	".webmanifest": manifestType,
+	".webp":        "image/webp",
+	".woff":        "font/woff",
+	".woff2":       "font/woff2",
+	".xml":         "application/xml",
+}
+
+// parseAssetTypes returns defaultAssetTypes with the entries of spec, a
+// comma-separated list of .ext=type, added or overriding.
+func parseAssetTypes(spec string) (map[string]string, error) {
+	types := make(map[string]string, len(defaultAssetTypes))
+	for ext, typ := range defaultAssetTypes {
+		types[ext] = typ
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		ext, typ, ok := strings.Cut(entry, "=")
+		ext, typ = strings.ToLower(strings.TrimSpace(ext)), strings.TrimSpace(typ)
+		if !ok || len(ext) < 2 || ext[0] != '.' || strings.ContainsAny(ext[1:], "./") {
+			return nil, fmt.Errorf("%q is not .ext=type", entry)
+		}
+		if _, _, err := mime.ParseMediaType(typ); err != nil {
+			return nil, fmt.Errorf("%s: invalid content type %q", ext, typ)
+		}
+		types[ext] = typ
+	}
+	return types, nil
+}
+
+// assetType returns the content type of the static file name: the
+// manifest's own for the manifest, the one types gives its extension, or
+// failing those the host's.
+func assetType(name string, types map[string]string) string {
+	if path.Base(name) == manifestFile {
+		return manifestType
+	}
+	ext := strings.ToLower(path.Ext(name))
+	if typ, ok := types[ext]; ok {
+		return typ
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// typeAssets labels the static files next serves with assetType when it
+// knows their type. http.FileServer and http.ServeContent keep a type that
+// is already set instead of detecting one.
+func typeAssets(next http.Handler, types map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if typ := assetType(path.Clean(r.URL.Path), types); typ != "" {
+			w.Header().Set("Content-Type", typ)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hashAssets walks fsys and returns the hex SHA-256 of every regular file,
+// keyed by its slash-separated path.
+func hashAssets(fsys fs.FS) (map[string]string, error) {
+	hashes := make(map[string]string)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		hashes[name] = contentHash(data)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("hashing static assets: %w", err)
+	}
+	return hashes, nil
+}
+
+// contentHash returns the hex SHA-256 of data.
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// etag formats a content hash as a strong entity tag.
+func etag(hash string) string {
+	return `"` + hash[:32] + `"`
+}
+
+// cacheStatic sets a strong ETag and Cache-Control on responses for files
+// listed in hashes. http.FileServer then answers a matching If-None-Match
+// with 304 Not Modified on its own.
+func cacheStatic(next http.Handler, hashes map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		if name == "" {
+			name = "index.html"
+		}
+		if hash, ok := hashes[name]; ok {
+			w.Header().Set("ETag", etag(hash))
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", staticMaxAge))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loadBrotli returns the embedded .br variant of each asset in hashes,
+// keyed by the asset's name. A variant that no longer decodes to its
+// source was left behind by a missed go generate and is skipped.
+func loadBrotli(content fs.FS, hashes map[string]string) (map[string][]byte, error) {
+	variants := make(map[string][]byte)
+	for name := range hashes {
+		source, ok := strings.CutSuffix(name, ".br")
+		if !ok || hashes[source] == "" {
+			continue
+		}
+		data, err := fs.ReadFile(content, name)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", name, err)
+		}
+		decoded, err := io.ReadAll(brotli.NewReader(bytes.NewReader(data)))
+		if err != nil || contentHash(decoded) != hashes[source] {
+			slog.Warn("ignoring stale Brotli variant; run go generate", "file", name)
+			continue
+		}
+		variants[source] = data
+	}
+	return variants, nil
+}
+
+// precompressed serves the Brotli variant of an asset from variants to
+// clients that accept br. Everyone else falls through to next, which
+// gzips on the fly or sends the identity encoding. Variants are labelled
+// with their asset's type from types.
+func precompressed(next http.Handler, variants map[string][]byte, hashes, types map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		data, ok := variants[name]
+		if !ok || (r.Method != http.MethodGet && r.Method != http.MethodHead) ||
+			r.Header.Get("Range") != "" || !acceptsEncoding(r, "br") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Accept-Encoding")
+		h.Set("Content-Encoding", "br")
+		h.Set("Content-Type", assetType(name, types))
+		// The encoded bytes differ from the identity ones, so they need an
+		// entity tag of their own.
+		h.Set("ETag", `"`+hashes[name][:32]+`-br"`)
+		h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", staticMaxAge))
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+	})
+}
+
+// directoryIndexes serves the index file of a directory in content at the
+// directory's path, and the root's at the root. A directory without one is
+// 404 Not Found rather than listed, so that the layout of the assets is
+// not exposed.
+func directoryIndexes(next http.Handler, content fs.FS, index string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		if name == "" {
+			name = "."
+		}
+		if fi, err := fs.Stat(content, name); err != nil || !fi.IsDir() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		file := path.Join(name, index)
+		if fi, err := fs.Stat(content, file); err != nil || fi.IsDir() {
+			writeError(w, r, http.StatusNotFound)
+			return
+		}
+		// http.FileServer serves index.html itself, and redirects a
+		// directory to its trailing-slash form before any index is served.
+		if index == "index.html" || !strings.HasSuffix(r.URL.Path, "/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/" + file
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// historyFallback serves the app's index page for browser navigations to
+// client-side routes such as /room/library, which have no file of their
+// own. Requests for missing assets (anything with a file extension) and
+// API paths still fall through to a real 404.
+func historyFallback(next http.Handler, content fs.FS, basePath, index string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		if (r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+			name != "" && path.Ext(name) == "" &&
+			strings.Contains(r.Header.Get("Accept"), "text/html") &&
+			!strings.HasPrefix(r.URL.Path, "/api/") && r.URL.Path != "/help" {
+			if _, err := fs.Stat(content, name); err != nil {
+				serveIndexAt(w, r, content, basePath, index)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveIndexAt serves the index page for a deep route. The page refers to
+// its assets with relative URLs, so a <base> element is added to resolve
+// them against the app's root under basePath rather than the route's
+// directory.
+// Its theme link follows the request's accessibility selection, as
+// a11yPages does for the page itself.
+func serveIndexAt(w http.ResponseWriter, r *http.Request, content fs.FS, basePath, index string) {
+	page, err := fs.ReadFile(content, index)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	page = bytes.Replace(page, []byte("<head>"), []byte(`<head>
+    <base href="`+html.EscapeString(basePath)+`/">`), 1)
+	page = linkTheme(page, a11ySelection(r))
+	w.Header().Set("Accept-CH", a11yHints)
+	w.Header().Add("Vary", a11yHints)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, index, time.Time{}, bytes.NewReader(page))
+}
+
+// mediaTypes lists the extensions served by serveMedia, for which byte
+// range support matters to seeking in <audio> and <video> elements.
+var mediaTypes = map[string]bool{
+	".mp3": true, ".ogg": true, ".oga": true, ".wav": true, ".m4a": true,
+	".aac": true, ".flac": true, ".mp4": true, ".webm": true, ".ogv": true,
+}
+
+// buildTime is the commit time recorded in the binary's build info. The
+// embedded files carry no modification time of their own, so this stands
+// in for it to make Last-Modified and If-Range work.
+var buildTime = func() time.Time {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return time.Time{}
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.time" {
+			t, _ := time.Parse(time.RFC3339, s.Value)
+			return t
+		}
+	}
+	return time.Time{}
+}()
+
+// serveMedia serves audio and video files through http.ServeContent so
+// that Range, If-Range, and 206 Partial Content work; other requests go to
+// next.
+func serveMedia(next http.Handler, content fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		if !mediaTypes[strings.ToLower(path.Ext(name))] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		f, err := content.Open(name)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		rs, seekable := f.(io.ReadSeeker)
+		if err != nil || fi.IsDir() || !seekable {
+			next.ServeHTTP(w, r)
+			return
+		}
+		modTime := fi.ModTime()
+		if modTime.IsZero() {
+			modTime = buildTime
+		}
+		w.Header().Set("Accept-Ranges", "bytes")
+		http.ServeContent(w, r, name, modTime, rs)
+	})
+}
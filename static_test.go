@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// cachedFileServer serves fsys the way static files are served: through
+// http.FileServer with cacheStatic's headers.
+func cachedFileServer(t *testing.T, fsys fstest.MapFS) http.Handler {
+	t.Helper()
+	hashes, err := hashAssets(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cacheStatic(http.FileServer(http.FS(fsys)), hashes)
+}
+
+func TestStaticETagAndCacheControl(t *testing.T) {
+	h := cachedFileServer(t, fstest.MapFS{"css/game.css": {Data: []byte("body{}")}})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/css/game.css", nil))
+	tag := w.Header().Get("ETag")
+	if !strings.HasPrefix(tag, `"`) || len(tag) < 3 {
+		t.Fatalf("ETag = %q, want a strong entity tag", tag)
+	}
+	if got := w.Header().Get("Cache-Control"); !strings.HasPrefix(got, "public, max-age=") {
+		t.Errorf("Cache-Control = %q, want public with a max-age", got)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/css/game.css", nil)
+	r.Header.Set("If-None-Match", tag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("matching If-None-Match: status %d, want 304", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("304 response has a %d-byte body", w.Body.Len())
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/css/game.css", nil)
+	r.Header.Set("If-None-Match", `"stale"`)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "body{}" {
+		t.Errorf("stale If-None-Match: status %d body %q, want the file", w.Code, w.Body.String())
+	}
+}
+
+func TestStaticETagChangesWithContent(t *testing.T) {
+	tagOf := func(data string) string {
+		h := cachedFileServer(t, fstest.MapFS{"js/app.js": {Data: []byte(data)}})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/js/app.js", nil))
+		return w.Header().Get("ETag")
+	}
+	first, second := tagOf("console.log(1)"), tagOf("console.log(2)")
+	if first == second {
+		t.Errorf("two builds with different files share the ETag %s", first)
+	}
+	if again := tagOf("console.log(1)"); again != first {
+		t.Errorf("the same file got ETags %s and %s", first, again)
+	}
+}
+
+func TestHelpETagStable(t *testing.T) {
+	s := startServer(t)
+	get := func(inm string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, s.url("/help"), nil)
+		if inm != "" {
+			req.Header.Set("If-None-Match", inm)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	first := get("")
+	tag := first.Header.Get("ETag")
+	if tag == "" {
+		t.Fatal("/help has no ETag")
+	}
+	if again := get("").Header.Get("ETag"); again != tag {
+		t.Errorf("/help ETag changed from %s to %s", tag, again)
+	}
+	if resp := get(tag); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("/help with If-None-Match: status %d, want 304", resp.StatusCode)
+	}
+}
+
+func TestCompressionWeakensETag(t *testing.T) {
+	h := newCompression(defaultCompressLevel, defaultCompressMinSize).middleware(cachedFileServer(t, fstest.MapFS{"css/game.css": {Data: []byte(strings.Repeat("body{}\n", 500))}}))
+	r := httptest.NewRequest(http.MethodGet, "/css/game.css", nil)
+	plain := httptest.NewRecorder()
+	h.ServeHTTP(plain, r)
+	r = httptest.NewRequest(http.MethodGet, "/css/game.css", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	gzipped := httptest.NewRecorder()
+	h.ServeHTTP(gzipped, r)
+	tag := plain.Header().Get("ETag")
+	if gzipped.Header().Get("Content-Encoding") != "gzip" || gzipped.Header().Get("ETag") != "W/"+tag {
+		t.Errorf("gzipped ETag %q, want the weak form of %q", gzipped.Header().Get("ETag"), tag)
+	}
+}
+
+func TestHelpServedPrecompressed(t *testing.T) {
+	s := startServer(t)
+	plainResp, plain := getWithHeaders(t, s.url("/help"), map[string]string{"Accept-Encoding": "identity"})
+	if plainResp.Header.Get("Content-Encoding") != "" || !strings.Contains(plain, "<h1") {
+		t.Fatalf("/help without gzip: Content-Encoding %q", plainResp.Header.Get("Content-Encoding"))
+	}
+	resp, body := getWithHeaders(t, s.url("/help"), map[string]string{"Accept-Encoding": "gzip"})
+	if resp.Header.Get("Content-Encoding") != "gzip" || resp.Header.Get("Content-Length") != strconv.Itoa(len(body)) {
+		t.Fatalf("/help with gzip: Content-Encoding %q, Content-Length %q for %d bytes", resp.Header.Get("Content-Encoding"), resp.Header.Get("Content-Length"), len(body))
+	}
+	zr, err := gzip.NewReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unzipped, err := io.ReadAll(zr)
+	if err != nil || string(unzipped) != plain {
+		t.Errorf("the gzipped page does not decompress to the plain one (%v)", err)
+	}
+
+	tag := resp.Header.Get("ETag")
+	if tag == "" || tag == plainResp.Header.Get("ETag") {
+		t.Errorf("gzipped ETag %q, want one of its own beside %q", tag, plainResp.Header.Get("ETag"))
+	}
+	if again, _ := getWithHeaders(t, s.url("/help"), map[string]string{"Accept-Encoding": "gzip"}); again.Header.Get("ETag") != tag {
+		t.Errorf("gzipped ETag changed from %s to %s", tag, again.Header.Get("ETag"))
+	}
+	if resp, _ := getWithHeaders(t, s.url("/help"), map[string]string{"Accept-Encoding": "gzip", "If-None-Match": tag}); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("gzipped /help with If-None-Match: status %d, want 304", resp.StatusCode)
+	}
+	if resp, body := getWithHeaders(t, s.url("/help"), map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-9"}); resp.StatusCode != http.StatusPartialContent || body != plain[:10] {
+		t.Errorf("a range of /help: status %d, %q; want the plain page's first bytes", resp.StatusCode, body)
+	}
+}
+
+// getAccept fetches url with the Accept header accept and returns the
+// status code and body.
+func getAccept(t *testing.T, url, accept string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Accept", accept)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestDirectoryIndexes(t *testing.T) {
+	content := fstest.MapFS{
+		"home.html":      {Data: []byte("home")},
+		"index.html":     {Data: []byte("the default index")},
+		"docs/home.html": {Data: []byte("docs home")},
+		"css/game.css":   {Data: []byte("body{}")},
+	}
+	h := directoryIndexes(http.FileServer(http.FS(content)), content, "home.html")
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/", http.StatusOK, "home"},
+		{"/docs/", http.StatusOK, "docs home"},
+		{"/docs", http.StatusMovedPermanently, ""},
+		{"/css/", http.StatusNotFound, ""},
+		{"/css", http.StatusNotFound, ""},
+		{"/css/game.css", http.StatusOK, "body{}"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.code || (tt.body != "" && w.Body.String() != tt.body) {
+			t.Errorf("%s: status %d, %q; want %d, %q", tt.path, w.Code, w.Body, tt.code, tt.body)
+		}
+		if strings.Contains(w.Body.String(), "game.css") {
+			t.Errorf("%s lists the directory", tt.path)
+		}
+	}
+}
+
+func TestDirectoriesNotListed(t *testing.T) {
+	s := startServer(t)
+	index, err := fs.ReadFile(staticFS, "index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// title returns the title element of page, which is served altered.
+	title := func(page []byte) string {
+		return string(page[bytes.Index(page, []byte("<title>")):bytes.Index(page, []byte("</title>"))])
+	}
+	if code, body := get(t, s.url("/")); code != http.StatusOK || !strings.Contains(body, title(index)) {
+		t.Errorf("root: status %d, want index.html", code)
+	}
+	for _, dir := range []string{"/css/", "/css", "/data/", "/questions/media/"} {
+		if code, body := get(t, s.url(dir)); code != http.StatusNotFound || strings.Contains(body, "game.css") {
+			t.Errorf("%s: status %d, want 404 without a listing", dir, code)
+		}
+	}
+	if code, _ := get(t, s.url("/css/game.css")); code != http.StatusOK {
+		t.Errorf("a file in a directory: status %d, want 200", code)
+	}
+	s.stop(t)
+
+	debug, err := fs.ReadFile(staticFS, "debug.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = startServer(t, "-index-file", "debug.html")
+	if code, body := get(t, s.url("/")); code != http.StatusOK || !strings.Contains(body, title(debug)) {
+		t.Errorf("root with -index-file debug.html: status %d, want debug.html", code)
+	}
+}
+
+func TestIndexFileValidated(t *testing.T) {
+	for _, name := range []string{"", "..", "pages/index.html"} {
+		if _, err := loadConfig([]string{"-index-file", name}, noEnv); err == nil {
+			t.Errorf("-index-file %q was accepted", name)
+		}
+	}
+	cfg, err := loadConfig([]string{"-index-file", "missing.html"}, noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inTempDir(t)
+	if err := serve(staticFS, cfg); err == nil || !strings.Contains(err.Error(), "index-file") {
+		t.Errorf("serving a missing index file: %v", err)
+	}
+}
+
+func TestHistoryFallback(t *testing.T) {
+	s := startServer(t)
+	const html = "text/html,application/xhtml+xml"
+	index, err := fs.ReadFile(staticFS, "index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	title := string(index[bytes.Index(index, []byte("<title>")):bytes.Index(index, []byte("</title>"))])
+
+	code, body := getAccept(t, s.url("/room/library"), html)
+	if code != http.StatusOK || !strings.Contains(body, title) {
+		t.Errorf("deep route: status %d, want 200 with the index page", code)
+	}
+	if code, _ := getAccept(t, s.url("/js/missing.js"), html); code != http.StatusNotFound {
+		t.Errorf("missing script: status %d, want 404", code)
+	}
+	if code, _ := getAccept(t, s.url("/css/missing.css"), "text/css,*/*"); code != http.StatusNotFound {
+		t.Errorf("missing stylesheet: status %d, want 404", code)
+	}
+	if code, body := getAccept(t, s.url("/css/game.css"), "text/css,*/*"); code != http.StatusOK || strings.Contains(body, title) {
+		t.Errorf("real file: status %d, want 200 with the file itself", code)
+	}
+	if code, _ := getAccept(t, s.url("/api/nowhere"), html); code != http.StatusNotFound {
+		t.Errorf("unknown API path: status %d, want 404", code)
+	}
+	if code, _ := getAccept(t, s.url("/room/library"), "application/json"); code != http.StatusNotFound {
+		t.Errorf("deep route without Accept: text/html: status %d, want 404", code)
+	}
+}
+
+func TestMediaRangeRequest(t *testing.T) {
+	audio := bytes.Repeat([]byte{0xff, 0xfb, 0x90, 0x64}, 1000)
+	fsys := fstest.MapFS{"audio/theme.mp3": {Data: audio}}
+	h := serveMedia(http.NotFoundHandler(), fsys)
+
+	r := httptest.NewRequest(http.MethodGet, "/audio/theme.mp3", nil)
+	r.Header.Set("Range", "bytes=0-99")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("status %d, want 206", w.Code)
+	}
+	if got, want := w.Header().Get("Content-Range"), "bytes 0-99/4000"; got != want {
+		t.Errorf("Content-Range = %q, want %q", got, want)
+	}
+	if got := w.Header().Get("Content-Length"); got != "100" {
+		t.Errorf("Content-Length = %q, want 100", got)
+	}
+	if !bytes.Equal(w.Body.Bytes(), audio[:100]) {
+		t.Error("range body is not the first 100 bytes")
+	}
+	if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+		t.Errorf("Accept-Ranges = %q, want bytes", got)
+	}
+}
+
+func TestMediaIfRange(t *testing.T) {
+	// Embedded files have no modification time, so the build's stands in.
+	defer func(t time.Time) { buildTime = t }(buildTime)
+	buildTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{"audio/theme.mp3": {Data: make([]byte, 500)}}
+	h := serveMedia(http.NotFoundHandler(), fsys)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/audio/theme.mp3", nil))
+	modified := w.Header().Get("Last-Modified")
+	if want := buildTime.Format(http.TimeFormat); modified != want {
+		t.Fatalf("Last-Modified = %q, want the build time %q", modified, want)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/audio/theme.mp3", nil)
+	r.Header.Set("Range", "bytes=100-199")
+	r.Header.Set("If-Range", "Mon, 02 Jan 2006 15:04:05 GMT")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.Len() != 500 {
+		t.Errorf("stale If-Range: status %d with %d bytes, want the whole file", w.Code, w.Body.Len())
+	}
+	r.Header.Set("If-Range", modified)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusPartialContent || w.Body.Len() != 100 {
+		t.Errorf("current If-Range: status %d with %d bytes, want 206 with 100", w.Code, w.Body.Len())
+	}
+}
+
+func TestTypeAssetsOverridesDetection(t *testing.T) {
+	types, err := parseAssetTypes(".wasm=application/x-test-wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"app.js":           {Data: []byte("export const x = 1;")},
+		"lib/mod.mjs":      {Data: []byte("export default 1;")},
+		"data/q.json":      {Data: []byte(`{"questions":[]}`)},
+		"manifest.json":    {Data: []byte(`{"name":"x"}`)},
+		"app.webmanifest":  {Data: []byte(`{"name":"x"}`)},
+		"castle.svg":       {Data: []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`)},
+		"engine.wasm":      {Data: []byte("\x00asm")},
+		"fonts/rune.WOFF2": {Data: []byte("wOF2")},
+		"notes.unknown":    {Data: []byte("plain words")},
+	}
+	h := typeAssets(http.FileServer(http.FS(fsys)), types)
+	tests := map[string]string{
+		"/app.js":           "text/javascript; charset=utf-8",
+		"/lib/mod.mjs":      "text/javascript; charset=utf-8",
+		"/data/q.json":      "application/json",
+		"/manifest.json":    manifestType,
+		"/app.webmanifest":  manifestType,
+		"/castle.svg":       "image/svg+xml",
+		"/engine.wasm":      "application/x-test-wasm",
+		"/fonts/rune.WOFF2": "font/woff2",
+		"/notes.unknown":    "text/plain; charset=utf-8", // sniffed
+	}
+	for p, want := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+		if got := w.Header().Get("Content-Type"); w.Code != http.StatusOK || got != want {
+			t.Errorf("%s: status %d as %q, want %q", p, w.Code, got, want)
+		}
+	}
+}
+
+func TestParseAssetTypes(t *testing.T) {
+	types, err := parseAssetTypes(" .JSON = text/plain , .glb=model/gltf-binary,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if types[".json"] != "text/plain" || types[".glb"] != "model/gltf-binary" || types[".css"] != defaultAssetTypes[".css"] {
+		t.Errorf("parsed types: .json %q, .glb %q, .css %q", types[".json"], types[".glb"], types[".css"])
+	}
+	if defaultAssetTypes[".json"] != "application/json" {
+		t.Error("an override changed the default table")
+	}
+	for _, spec := range []string{"json=text/plain", ".json", ".=text/plain", ".a/b=text/plain", ".tar.gz=application/gzip", ".x=not a type"} {
+		if _, err := parseAssetTypes(spec); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+	if _, err := loadConfig([]string{"-asset-types", ".json"}, noEnv); err == nil || !strings.Contains(err.Error(), "asset-types") {
+		t.Errorf("-asset-types .json: %v", err)
+	}
+}
+
+func TestStaticContentTypes(t *testing.T) {
+	s := startServer(t, "-asset-types", ".md=text/plain; charset=utf-8")
+	fetch := func(path string, header map[string]string) *http.Response {
+		t.Helper()
+		resp, body := getWithHeaders(t, s.url(path), header)
+		if resp.StatusCode != http.StatusOK || body == "" {
+			t.Fatalf("%s: status %d", path, resp.StatusCode)
+		}
+		return resp
+	}
+	tests := map[string]string{
+		"/src/gameState.js":    "text/javascript; charset=utf-8",
+		"/data/questions.json": "application/json",
+		"/manifest.json":       manifestType,
+		"/css/game.css":        "text/css; charset=utf-8",
+		"/README.md":           "text/plain; charset=utf-8",
+	}
+	for p, want := range tests {
+		resp := fetch(p, nil)
+		if got := resp.Header.Get("Content-Type"); got != want {
+			t.Errorf("%s served as %q, want %q", p, got, want)
+		}
+		if got := resp.Header.Get("X-Content-Type-Options"); got != "nosniff" {
+			t.Errorf("%s: X-Content-Type-Options %q, want nosniff", p, got)
+		}
+	}
+	resp := fetch("/data/questions.json", map[string]string{"Accept-Encoding": "br"})
+	if resp.Header.Get("Content-Encoding") != "br" || resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Brotli questions served as %q in %q", resp.Header.Get("Content-Type"), resp.Header.Get("Content-Encoding"))
+	}
+}
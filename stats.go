@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// statsNamespace holds the running answer totals of each question, keyed
+// by question ID.
+const statsNamespace = "stats"
+
+// statsSorts are the orders /api/stats can list questions in: by ID, most
+// attempted first, or hardest (lowest correct rate) first.
+var statsSorts = map[string]bool{"id": true, "attempts": true, "hardest": true}
+
+// answerTotals are the counts kept for one question. Timed counts the
+// attempts whose answer time is known, which TotalMs adds up.
+type answerTotals struct {
+	Attempts int   `json:"attempts"`
+	Correct  int   `json:"correct"`
+	Timed    int   `json:"timed"`
+	TotalMs  int64 `json:"totalMs"`
+}
+
+// questionStats is the /api/stats view of one question.
+type questionStats struct {
+	QuestionID  string   `json:"questionID"`
+	Attempts    int      `json:"attempts"`
+	Correct     int      `json:"correct"`
+	CorrectRate float64  `json:"correctRate"`
+	AvgTimeMs   *float64 `json:"avgTimeMs,omitempty"`
+}
+
+// answerStats keeps per-question answer totals in a Store, updating them
+// as each answer arrives.
+type answerStats struct {
+	mu    sync.Mutex // serializes read-modify-write updates
+	store Store
+}
+
+func newAnswerStats(store Store) *answerStats {
+	return &answerStats{store: store}
+}
+
+// load returns the totals of question id.
+func (s *answerStats) load(id string) (answerTotals, error) {
+	var t answerTotals
+	data, err := s.store.Get(statsNamespace, id)
+	if errors.Is(err, ErrNotFound) {
+		return t, nil
+	}
+	if err != nil {
+		return t, err
+	}
+	err = json.Unmarshal(data, &t)
+	return t, err
+}
+
+// Record counts an answer to question id. elapsed is how long the player
+// took, or 0 if that is not known.
+func (s *answerStats) Record(id string, correct bool, elapsed time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.load(id)
+	if err != nil {
+		return err
+	}
+	t.add(correct, elapsed)
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return s.store.Set(statsNamespace, id, data)
+}
+
+// All returns the statistics of every answered question in the given
+// order.
+func (s *answerStats) All(order string) ([]questionStats, error) {
+	stats := []questionStats{}
+	err := s.Each(context.Background(), func(qs questionStats) error {
+		stats = append(stats, qs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Each goes in ID order, so ties keep it.
+	sortStats(stats, order)
+	return stats, nil
+}
+
+// Each calls fn with the statistics of each answered question in ID
+// order, reading them from the Store one at a time. It stops at the first
+// error fn returns, or once ctx is done, and returns that error.
+func (s *answerStats) Each(ctx context.Context, fn func(questionStats) error) error {
+	ids, err := s.store.List(statsNamespace)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		t, err := s.load(id)
+		if err != nil {
+			return fmt.Errorf("stats of %s: %w", id, err)
+		}
+		if t.Attempts == 0 {
+			continue
+		}
+		if err := fn(t.stats(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stats returns the /api/stats view of t, the totals of question id.
+func (t answerTotals) stats(id string) questionStats {
+	qs := questionStats{
+		QuestionID:  id,
+		Attempts:    t.Attempts,
+		Correct:     t.Correct,
+		CorrectRate: math.Round(float64(t.Correct)/float64(t.Attempts)*1000) / 1000,
+	}
+	if t.Timed > 0 {
+		avg := math.Round(float64(t.TotalMs) / float64(t.Timed))
+		qs.AvgTimeMs = &avg
+	}
+	return qs
+}
+
+// add counts one answer in t.
+func (t *answerTotals) add(correct bool, elapsed time.Duration) {
+	t.Attempts++
+	if correct {
+		t.Correct++
+	}
+	if elapsed > 0 {
+		t.Timed++
+		t.TotalMs += elapsed.Milliseconds()
+	}
+}
+
+// sortStats puts stats, which are in ID order, in one of the statsSorts
+// orders. Ties keep ID order.
+func sortStats(stats []questionStats, order string) {
+	switch order {
+	case "attempts":
+		sort.SliceStable(stats, func(i, j int) bool { return stats[i].Attempts > stats[j].Attempts })
+	case "hardest":
+		sort.SliceStable(stats, func(i, j int) bool { return stats[i].CorrectRate < stats[j].CorrectRate })
+	}
+}
+
+// statsHandler serves GET /api/stats?sort=S with the answer statistics of
+// each question that has been answered, sorted by S: id (the default),
+// attempts, or hardest. With experiment=ID, it serves the statistics of
+// that experiment in exps instead, by bucket.
+func statsHandler(stats *answerStats, exps *experiments) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		order := r.URL.Query().Get("sort")
+		if order == "" {
+			order = "id"
+		}
+		if !statsSorts[order] {
+			writeAPIError(w, http.StatusBadRequest, "sort must be id, attempts, or hardest")
+			return
+		}
+		if id := r.URL.Query().Get("experiment"); id != "" {
+			results, err := exps.Results(id, order)
+			if errors.Is(err, errNoExperiment) {
+				writeAPIError(w, http.StatusNotFound, err.Error())
+				return
+			}
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, "could not load experiment results")
+				return
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			writeJSON(w, http.StatusOK, map[string]any{"experiment": id, "buckets": results})
+			return
+		}
+		all, err := stats.All(order)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "could not load statistics")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, map[string]any{"questions": all})
+	})
+}
+
+// statsCSVHandler serves GET /api/stats.csv, the answer statistics of each
+// question that has been answered as a CSV download in ID order, streamed
+// from the Store as it is read.
+func statsCSVHandler(stats *answerStats) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		export := newCSVExport(w, r, "stats.csv", []string{"questionID", "attempts", "correct", "correctRate", "avgTimeMs"})
+		err := stats.Each(r.Context(), func(qs questionStats) error {
+			avg := ""
+			if qs.AvgTimeMs != nil {
+				avg = strconv.FormatFloat(*qs.AvgTimeMs, 'f', -1, 64)
+			}
+			return export.Write([]string{
+				csvCell(qs.QuestionID),
+				strconv.Itoa(qs.Attempts),
+				strconv.Itoa(qs.Correct),
+				strconv.FormatFloat(qs.CorrectRate, 'f', -1, 64),
+				avg,
+			})
+		})
+		if err != nil {
+			if r.Context().Err() == nil {
+				slog.Warn("stats export failed", "err", err)
+			}
+			return
+		}
+		export.Close()
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAnswerStatsAggregate(t *testing.T) {
+	stats := newAnswerStats(newMemoryStore())
+	record := func(id string, correct bool, elapsed time.Duration) {
+		t.Helper()
+		if err := stats.Record(id, correct, elapsed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record("q1", true, 2*time.Second)
+	record("q1", true, 4*time.Second)
+	record("q1", false, 0)
+	record("q2", false, time.Second)
+	record("q3", true, 0)
+	record("q3", false, 0)
+
+	all, err := stats.All("id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("stats of %d questions, want 3", len(all))
+	}
+	q1 := all[0]
+	if q1.QuestionID != "q1" || q1.Attempts != 3 || q1.Correct != 2 || q1.CorrectRate != 0.667 {
+		t.Errorf("q1 stats %+v, want 2 of 3 correct", q1)
+	}
+	if q1.AvgTimeMs == nil || *q1.AvgTimeMs != 3000 {
+		t.Errorf("q1 average time %v, want 3000ms over the timed answers", q1.AvgTimeMs)
+	}
+	if all[2].AvgTimeMs != nil {
+		t.Errorf("q3 has an average time with no timed answers")
+	}
+
+	for order, want := range map[string][]string{
+		"id":       {"q1", "q2", "q3"},
+		"hardest":  {"q2", "q3", "q1"},
+		"attempts": {"q1", "q3", "q2"},
+	} {
+		sorted, _ := stats.All(order)
+		for i, qs := range sorted {
+			if qs.QuestionID != want[i] {
+				t.Errorf("sort=%s: order %v, want %v", order, sorted, want)
+				break
+			}
+		}
+	}
+}
+
+func TestAnswerStatsConcurrentRecords(t *testing.T) {
+	stats := newAnswerStats(newMemoryStore())
+	var wg sync.WaitGroup
+	for i := range 40 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stats.Record("q1", i%4 == 0, 0)
+		}()
+	}
+	wg.Wait()
+	all, _ := stats.All("id")
+	if len(all) != 1 || all[0].Attempts != 40 || all[0].Correct != 10 {
+		t.Errorf("after 40 concurrent answers: %+v", all)
+	}
+}
+
+func TestStatsEndpoint(t *testing.T) {
+	s := startServer(t, "-public-stats")
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer := func(q Question, correct bool) {
+		choice := q.CorrectAnswer
+		if !correct {
+			choice = (choice + 1) % len(q.Answers)
+		}
+		if code := postJSON(t, s.url("/api/answer"), map[string]any{"questionID": q.ID, "choiceIndex": choice}, nil); code != http.StatusOK {
+			t.Fatalf("answer %s: status %d", q.ID, code)
+		}
+	}
+	answer(questions[0], true)
+	answer(questions[0], false)
+	answer(questions[1], false)
+
+	var body struct {
+		Questions []questionStats `json:"questions"`
+	}
+	if code := getJSON(t, s.url("/api/stats?sort=hardest"), &body); code != http.StatusOK {
+		t.Fatalf("/api/stats: status %d", code)
+	}
+	got := fmt.Sprint(body.Questions)
+	if len(body.Questions) != 2 {
+		t.Fatalf("stats %s, want two questions", got)
+	}
+	hardest, easier := body.Questions[0], body.Questions[1]
+	if hardest.QuestionID != questions[1].ID || hardest.Attempts != 1 || hardest.CorrectRate != 0 {
+		t.Errorf("hardest %+v, want %s with no correct answers", hardest, questions[1].ID)
+	}
+	if easier.QuestionID != questions[0].ID || easier.Attempts != 2 || easier.Correct != 1 || easier.CorrectRate != 0.5 {
+		t.Errorf("second %+v, want %s at half correct", easier, questions[0].ID)
+	}
+	if code := getJSON(t, s.url("/api/stats?sort=easiest"), nil); code != http.StatusBadRequest {
+		t.Errorf("unknown sort: status %d, want 400", code)
+	}
+}
+
+func TestStatsAdminGated(t *testing.T) {
+	s := startServer(t, "-admin-password", testAdminPassword)
+	if code := getJSON(t, s.url("/api/stats"), nil); code != http.StatusUnauthorized {
+		t.Errorf("stats without credentials: status %d, want 401", code)
+	}
+	if code := adminRequest(t, s, http.MethodGet, "/api/stats", "admin", testAdminPassword, nil); code != http.StatusOK {
+		t.Errorf("stats as admin: status %d, want 200", code)
+	}
+}
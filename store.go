@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned by Store.Get and Store.Delete when the key has
+// no value in the namespace.
+var ErrNotFound = errors.New("store: key not found")
+
+// validStoreName matches namespaces and keys. Restricting the alphabet
+// keeps them safe to use as file names.
+var validStoreName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// Store persists opaque values under a key within a namespace, such as a
+// saved game under its player token in "saves". Implementations are safe
+// for concurrent use.
+type Store interface {
+	// Get returns the value stored for key, or ErrNotFound.
+	Get(namespace, key string) ([]byte, error)
+	// Set stores value for key, replacing any previous value.
+	Set(namespace, key string, value []byte) error
+	// List returns the keys in namespace in sorted order.
+	List(namespace string) ([]string, error)
+	// Delete removes key, or returns ErrNotFound.
+	Delete(namespace, key string) error
+}
+
+// updater is implemented by Stores that can make several changes at
+// once: Update runs fn, and the changes fn makes through the Store it is
+// given are kept only if it returns nil.
+type updater interface {
+	Update(fn func(Store) error) error
+}
+
+// openStore returns the Store selected by kind: "file" rooted at dir,
+// "sqlite" in the database dsn, or "memory".
+func openStore(kind, dir, dsn string) (Store, error) {
+	switch kind {
+	case "file":
+		return newFileStore(dir)
+	case "sqlite":
+		return newSQLiteStore(dsn)
+	case "memory":
+		return newMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown store %q", kind)
+	}
+}
+
+// checkStoreNames rejects a namespace or key that is not a validStoreName.
+func checkStoreNames(names ...string) error {
+	for _, name := range names {
+		if !validStoreName.MatchString(name) {
+			return fmt.Errorf("store: invalid name %q", name)
+		}
+	}
+	return nil
+}
+
+// fileStore keeps each namespace in a subdirectory of dir and each value
+// in its own file.
+type fileStore struct {
+	mu  sync.RWMutex
+	dir string
+}
+
+// newFileStore returns a Store rooted at dir, creating it if needed.
+func newFileStore(dir string) (*fileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+func (s *fileStore) Get(namespace, key string) ([]byte, error) {
+	if err := checkStoreNames(namespace, key); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, err := os.ReadFile(filepath.Join(s.dir, namespace, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (s *fileStore) Set(namespace, key string, value []byte) error {
+	if err := checkStoreNames(namespace, key); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dir := filepath.Join(s.dir, namespace)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so readers never see a partial value.
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, key))
+}
+
+func (s *fileStore) List(namespace string) ([]string, error) {
+	if err := checkStoreNames(namespace); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries, err := os.ReadDir(filepath.Join(s.dir, namespace))
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+			keys = append(keys, e.Name())
+		}
+	}
+	return keys, nil
+}
+
+func (s *fileStore) Delete(namespace, key string) error {
+	if err := checkStoreNames(namespace, key); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := os.Remove(filepath.Join(s.dir, namespace, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// memoryStore keeps values in memory; they are lost when the process
+// exits.
+type memoryStore struct {
+	mu     sync.RWMutex
+	spaces map[string]map[string][]byte
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{spaces: make(map[string]map[string][]byte)}
+}
+
+func (s *memoryStore) Get(namespace, key string) ([]byte, error) {
+	if err := checkStoreNames(namespace, key); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.spaces[namespace][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (s *memoryStore) Set(namespace, key string, value []byte) error {
+	if err := checkStoreNames(namespace, key); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	space := s.spaces[namespace]
+	if space == nil {
+		space = make(map[string][]byte)
+		s.spaces[namespace] = space
+	}
+	space[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (s *memoryStore) List(namespace string) ([]string, error) {
+	if err := checkStoreNames(namespace); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.spaces[namespace]))
+	for k := range s.spaces[namespace] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *memoryStore) Delete(namespace, key string) error {
+	if err := checkStoreNames(namespace, key); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.spaces[namespace][key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.spaces[namespace], key)
+	return nil
+}
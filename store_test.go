@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// testStore runs the Store conformance suite against the stores open
+// returns, a fresh and empty one per subtest.
+func testStore(t *testing.T, open func(t *testing.T) Store) {
+	t.Run("GetMissing", func(t *testing.T) {
+		s := open(t)
+		if _, err := s.Get("saves", "nobody"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get of a missing key: err = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("SetGet", func(t *testing.T) {
+		s := open(t)
+		if err := s.Set("saves", "player1", []byte(`{"room":"library"}`)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.Get("saves", "player1")
+		if err != nil || string(got) != `{"room":"library"}` {
+			t.Errorf("Get = %q, %v", got, err)
+		}
+		if err := s.Set("saves", "player1", []byte(`{"room":"vault"}`)); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := s.Get("saves", "player1"); string(got) != `{"room":"vault"}` {
+			t.Errorf("Get after overwrite = %q", got)
+		}
+	})
+
+	t.Run("EmptyValue", func(t *testing.T) {
+		s := open(t)
+		if err := s.Set("saves", "blank", []byte{}); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := s.Get("saves", "blank"); err != nil || len(got) != 0 {
+			t.Errorf("Get of an empty value = %q, %v", got, err)
+		}
+	})
+
+	t.Run("ValuesAreCopied", func(t *testing.T) {
+		s := open(t)
+		value := []byte("original")
+		if err := s.Set("saves", "player1", value); err != nil {
+			t.Fatal(err)
+		}
+		copy(value, "mutated!")
+		got, _ := s.Get("saves", "player1")
+		if string(got) != "original" {
+			t.Errorf("changing the slice passed to Set changed the stored value to %q", got)
+		}
+		copy(got, "mutated!")
+		if again, _ := s.Get("saves", "player1"); string(again) != "original" {
+			t.Errorf("changing the slice Get returned changed the stored value to %q", again)
+		}
+	})
+
+	t.Run("ListSortedPerNamespace", func(t *testing.T) {
+		s := open(t)
+		for _, key := range []string{"charlie", "alpha", "bravo"} {
+			if err := s.Set("saves", key, []byte("x")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.Set("leaderboard", "scores", []byte("[]")); err != nil {
+			t.Fatal(err)
+		}
+		keys, err := s.List("saves")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"alpha", "bravo", "charlie"}; !reflect.DeepEqual(keys, want) {
+			t.Errorf("List = %v, want %v", keys, want)
+		}
+		if _, err := s.Get("leaderboard", "alpha"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("a key leaked across namespaces: err = %v", err)
+		}
+		keys, err = s.List("empty")
+		if err != nil || len(keys) != 0 {
+			t.Errorf("List of an unused namespace = %v, %v", keys, err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		s := open(t)
+		if err := s.Set("saves", "player1", []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Delete("saves", "player1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Get("saves", "player1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get after Delete: err = %v, want ErrNotFound", err)
+		}
+		if keys, _ := s.List("saves"); len(keys) != 0 {
+			t.Errorf("List after Delete = %v", keys)
+		}
+		if err := s.Delete("saves", "player1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Delete of a missing key: err = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("InvalidNames", func(t *testing.T) {
+		s := open(t)
+		for _, name := range []string{"", "../escape", "a/b", ".hidden", "has space"} {
+			if err := s.Set("saves", name, []byte("x")); err == nil {
+				t.Errorf("Set with key %q accepted", name)
+			}
+			if err := s.Set(name, "key", []byte("x")); err == nil {
+				t.Errorf("Set in namespace %q accepted", name)
+			}
+			if _, err := s.Get("saves", name); err == nil || errors.Is(err, ErrNotFound) {
+				t.Errorf("Get with key %q: err = %v, want a name error", name, err)
+			}
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		s := open(t)
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				key := fmt.Sprintf("player%02d", i)
+				for j := 0; j < 10; j++ {
+					value := []byte(fmt.Sprintf("%s-%d", key, j))
+					if err := s.Set("saves", key, value); err != nil {
+						t.Error(err)
+						return
+					}
+					if got, err := s.Get("saves", key); err != nil || string(got) != string(value) {
+						t.Errorf("Get(%s) = %q, %v, want %q", key, got, err, value)
+						return
+					}
+					if _, err := s.List("saves"); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if keys, _ := s.List("saves"); len(keys) != 20 {
+			t.Errorf("%d keys after concurrent writes, want 20", len(keys))
+		}
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store { return newMemoryStore() })
+}
+
+func TestFileStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		s, err := newFileStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestFileStorePersists(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("saves", "player1", []byte("kept")); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := newFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := reopened.Get("saves", "player1"); err != nil || string(got) != "kept" {
+		t.Errorf("after reopening: Get = %q, %v", got, err)
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+	if _, err := openStore("memory", "", ""); err != nil {
+		t.Error(err)
+	}
+	if _, err := openStore("file", t.TempDir(), ""); err != nil {
+		t.Error(err)
+	}
+	if _, err := openStore("floppy", "", ""); err == nil {
+		t.Error("unknown store kind accepted")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+const (
+	// storeSchemaVersion is the layout of the stored data this server
+	// reads and writes.
+	storeSchemaVersion = 1
+	// schemaNamespace and schemaKey locate the version of the layout the
+	// store holds.
+	schemaNamespace = "schema"
+	schemaKey       = "version"
+)
+
+// errFutureSchema rejects a store written by a newer server.
+var errFutureSchema = errors.New("store was written by a newer version of the server")
+
+// storeMigrations upgrade the stored data from the version each is keyed
+// by to the next one. A migration may be interrupted and run again, so
+// it must leave data it already upgraded alone.
+var storeMigrations = map[int]func(Store) error{
+	// Version 0 is a store from before versions were recorded, which
+	// already has the version 1 layout.
+	0: func(Store) error { return nil },
+}
+
+// storeVersion returns the schema version store holds, 0 if none has
+// been recorded.
+func storeVersion(store Store) (int, error) {
+	data, err := store.Get(schemaNamespace, schemaKey)
+	if errors.Is(err, ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid store schema version %q", data)
+	}
+	return v, nil
+}
+
+// migrateStore brings store up to storeSchemaVersion with the
+// storeMigrations.
+func migrateStore(store Store) error {
+	return migrateStoreTo(store, storeSchemaVersion, storeMigrations)
+}
+
+// migrateStoreTo brings store up to version, applying the migrations it
+// is behind on in order and recording the version after each, so that a
+// failure resumes from where it stopped. Each step runs in a transaction
+// if the store supports them. A store at a newer version is refused
+// rather than risk corrupting it.
+func migrateStoreTo(store Store, version int, migrations map[int]func(Store) error) error {
+	v, err := storeVersion(store)
+	if err != nil {
+		return err
+	}
+	if v > version {
+		return fmt.Errorf("%w (schema version %d, this server supports up to %d)", errFutureSchema, v, version)
+	}
+	for ; v < version; v++ {
+		migrate, ok := migrations[v]
+		if !ok {
+			return fmt.Errorf("no migration from store schema version %d", v)
+		}
+		step := func(s Store) error {
+			if err := migrate(s); err != nil {
+				return fmt.Errorf("migrating store from schema version %d: %w", v, err)
+			}
+			return s.Set(schemaNamespace, schemaKey, []byte(strconv.Itoa(v+1)))
+		}
+		if u, ok := store.(updater); ok {
+			err = u.Update(step)
+		} else {
+			err = step(store)
+		}
+		if err != nil {
+			return err
+		}
+		slog.Info("store migrated", "schema_version", v+1)
+	}
+	return nil
+}
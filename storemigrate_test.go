@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// storedVersion returns the schema version recorded in store.
+func storedVersion(t *testing.T, store Store) int {
+	t.Helper()
+	v, err := storeVersion(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestMigrateStoreRecordsVersion(t *testing.T) {
+	recordLogs(t)
+	store := newMemoryStore()
+	if err := migrateStore(store); err != nil {
+		t.Fatal(err)
+	}
+	if v := storedVersion(t, store); v != storeSchemaVersion {
+		t.Errorf("version after migrating = %d, want %d", v, storeSchemaVersion)
+	}
+	// Running again at the current version changes nothing.
+	if err := migrateStore(store); err != nil {
+		t.Fatal(err)
+	}
+	if v := storedVersion(t, store); v != storeSchemaVersion {
+		t.Errorf("version after migrating twice = %d", v)
+	}
+}
+
+func TestMigrateStoreResumesAfterFailure(t *testing.T) {
+	recordLogs(t)
+	defer func(first func(Store) error) { storeMigrations[0] = first }(storeMigrations[0])
+	errBroken := errors.New("broken")
+	runs := 0
+	storeMigrations[0] = func(s Store) error {
+		runs++
+		if err := s.Set("saves", "partial", []byte("x")); err != nil {
+			return err
+		}
+		if runs == 1 {
+			return errBroken
+		}
+		return nil
+	}
+
+	store := openSQLite(t, ":memory:")
+	if err := migrateStore(store); !errors.Is(err, errBroken) {
+		t.Fatalf("migrateStore = %v, want the migration's error", err)
+	}
+	if v := storedVersion(t, store); v != 0 {
+		t.Errorf("version after a failed migration = %d, want 0", v)
+	}
+	if _, err := store.Get("saves", "partial"); !errors.Is(err, ErrNotFound) {
+		t.Error("a failed migration's changes were kept")
+	}
+	if err := migrateStore(store); err != nil {
+		t.Fatal(err)
+	}
+	if runs != 2 || storedVersion(t, store) != storeSchemaVersion {
+		t.Errorf("after retrying: %d runs, version %d", runs, storedVersion(t, store))
+	}
+}
+
+func TestMigrateStoreV1ToV2(t *testing.T) {
+	recordLogs(t)
+	// Version 2 renames the "answered" field of a save to
+	// "answeredQuestions", and leaves saves that already have it alone.
+	migrations := map[int]func(Store) error{
+		0: storeMigrations[0],
+		1: func(s Store) error {
+			keys, err := s.List(savesNamespace)
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				data, err := s.Get(savesNamespace, key)
+				if err != nil {
+					return err
+				}
+				var save map[string]json.RawMessage
+				if err := json.Unmarshal(data, &save); err != nil {
+					return err
+				}
+				answered, ok := save["answered"]
+				if !ok {
+					continue
+				}
+				save["answeredQuestions"] = answered
+				delete(save, "answered")
+				if data, err = json.Marshal(save); err != nil {
+					return err
+				}
+				if err := s.Set(savesNamespace, key, data); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	store := openSQLite(t, ":memory:")
+	store.Set(schemaNamespace, schemaKey, []byte("1"))
+	store.Set(savesNamespace, "old", []byte(`{"room":"library","answered":["q1","q2"]}`))
+	store.Set(savesNamespace, "new", []byte(`{"room":"vault","answeredQuestions":["q3"]}`))
+
+	for range 2 {
+		if err := migrateStoreTo(store, 2, migrations); err != nil {
+			t.Fatal(err)
+		}
+		if v := storedVersion(t, store); v != 2 {
+			t.Errorf("version after migrating = %d, want 2", v)
+		}
+		for key, want := range map[string]GameState{
+			"old": {Room: "library", AnsweredQuestions: []string{"q1", "q2"}},
+			"new": {Room: "vault", AnsweredQuestions: []string{"q3"}},
+		} {
+			data, _ := store.Get(savesNamespace, key)
+			var got GameState
+			if err := json.Unmarshal(data, &got); err != nil || got.Room != want.Room || !slices.Equal(got.AnsweredQuestions, want.AnsweredQuestions) {
+				t.Errorf("save %s after migrating = %s, want %+v", key, data, want)
+			}
+		}
+	}
+	// Running the step again over data it already upgraded is harmless.
+	if err := migrations[1](store); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := store.Get(savesNamespace, "old"); !strings.Contains(string(data), `"answeredQuestions":["q1","q2"]`) {
+		t.Errorf("save after a second run = %s", data)
+	}
+}
+
+func TestMigrateStoreRefusesFutureVersion(t *testing.T) {
+	store := newMemoryStore()
+	store.Set(schemaNamespace, schemaKey, []byte(strconv.Itoa(storeSchemaVersion+1)))
+	if err := migrateStore(store); !errors.Is(err, errFutureSchema) {
+		t.Errorf("migrateStore = %v, want errFutureSchema", err)
+	}
+	store.Set(schemaNamespace, schemaKey, []byte("two"))
+	if err := migrateStore(store); err == nil {
+		t.Error("a garbled schema version was accepted")
+	}
+}
+
+func TestFutureStoreVersionAbortsStartup(t *testing.T) {
+	inTempDir(t)
+	recordLogs(t)
+	store, err := newFileStore("store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Set(schemaNamespace, schemaKey, []byte(strconv.Itoa(storeSchemaVersion+1)))
+	cfg, err := loadConfig([]string{"-addr", "127.0.0.1:0"}, noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := serve(siteContent(cfg, staticFS), cfg); !errors.Is(err, errFutureSchema) {
+		t.Errorf("serve = %v, want it to refuse the store", err)
+	}
+	if data, _ := store.Get(schemaNamespace, schemaKey); string(data) != strconv.Itoa(storeSchemaVersion+1) {
+		t.Errorf("the refused store's version was rewritten to %q", data)
+	}
+}
@@ -3,45 +3,28 @@
  * Provides offline functionality and improved performance through caching
  */
 
-const CACHE_NAME = 'lobelabyrinth-v1.0.0';
-const STATIC_CACHE_NAME = 'lobelabyrinth-static-v1.0.0';
-const DYNAMIC_CACHE_NAME = 'lobelabyrinth-dynamic-v1.0.0';
+// BEGIN GENERATED
+// The server replaces this block when serving /sw.js: SW_VERSION is derived
+// from the content hashes of the embedded assets, so any change to them
+// installs a fresh cache.
+const SW_VERSION = 'dev';
 
-// Files to cache for offline functionality
-const STATIC_ASSETS = [
-    '/',
-    '/game.html',
-    '/index.html',
-    
-    // CSS Files
-    '/css/game.css',
-    '/css/achievements.css',
-    '/css/victory.css',
-    '/css/test.css',
-    
-    // JavaScript Files
-    '/src/dataLoader.js',
-    '/src/gameState.js',
-    '/src/quizEngine.js',
-    '/src/animationManager.js',
-    '/src/achievementManager.js',
-    '/src/mapRenderer.js',
-    '/src/uiManager.js',
-    '/src/errorBoundary.js',
-    
-    // Data Files
-    '/data/rooms.json',
-    '/data/questions.json',
-    '/data/achievements.json',
-    
-    // Manifest
-    '/manifest.json'
-];
+// Path prefix the app is served under, without a trailing slash
+const BASE_PATH = '';
+
+// Files to cache for offline functionality, mapped to their content hashes
+const ASSET_HASHES = {};
+const STATIC_ASSETS = ['/'];
+// END GENERATED
+
+const CACHE_NAME = `lobelabyrinth-${SW_VERSION}`;
+const STATIC_CACHE_NAME = `lobelabyrinth-static-${SW_VERSION}`;
+const DYNAMIC_CACHE_NAME = `lobelabyrinth-dynamic-${SW_VERSION}`;
 
 // Files that change frequently - cache with network-first strategy
 const DYNAMIC_ASSETS = [
-    '/data/',
-    '/api/'
+    `${BASE_PATH}/data/`,
+    `${BASE_PATH}/api/`
 ];
 
 // Install event - cache static assets
@@ -236,7 +219,7 @@ async function handleNavigationRequest(request) {
         console.warn('⚠️ Service Worker: Navigation offline, serving cached game');
         
         // Serve cached game.html for offline navigation
-        const cachedGame = await caches.match('/game.html');
+        const cachedGame = await caches.match(`${BASE_PATH}/game.html`);
         if (cachedGame) {
             return cachedGame;
         }
@@ -351,7 +334,7 @@ function createOfflinePage() {
                     🔄 Try Again
                 </button>
                 
-                <button class="retry-btn" onclick="window.location.href='/game.html'">
+                <button class="retry-btn" onclick="window.location.href='${BASE_PATH}/game.html'">
                     🎮 Play Cached Game
                 </button>
                 
@@ -422,7 +405,7 @@ self.addEventListener('notificationclick', (event) => {
     
     if (event.action === 'play') {
         event.waitUntil(
-            clients.openWindow('/game.html')
+            clients.openWindow(`${BASE_PATH}/game.html`)
         );
     }
 });
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// syncRequest is the body of POST /api/sync: the client's state, when it
+// last synced, and, to settle a conflict reported earlier, the room it
+// picked.
+type syncRequest struct {
+	State        GameState `json:"state"`
+	LastSyncedAt time.Time `json:"lastSyncedAt"`
+	ResolveRoom  string    `json:"resolveRoom,omitempty"`
+}
+
+// syncResponse is the merged state. When Conflict is set the copies were
+// in different rooms with nothing to tell which is newer; the merge was
+// not saved, and the client should send it back with one of Rooms as
+// resolveRoom.
+type syncResponse struct {
+	State    GameState `json:"state"`
+	SyncedAt time.Time `json:"syncedAt"`
+	Conflict bool      `json:"conflict"`
+	Rooms    []string  `json:"rooms,omitempty"`
+}
+
+// union returns a followed by the elements of b not already in it.
+func union(a, b []string) []string {
+	out := append([]string{}, a...)
+	for _, v := range b {
+		if !slices.Contains(out, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// mergeStates combines the stored state with one a client last synced at
+// lastSynced. Score is the higher of the two, answered questions and
+// achievements the union. The room is the client's if the stored copy has
+// not changed since lastSynced, and otherwise that of the copy saved
+// later. If both were saved at the same instant in different rooms, that
+// is a conflict: both rooms are returned, stored first, and the merge
+// keeps the stored one.
+func mergeStates(stored, client GameState, lastSynced time.Time) (merged GameState, rooms []string) {
+	merged = GameState{
+		Room:              stored.Room,
+		Score:             max(stored.Score, client.Score),
+		AnsweredQuestions: union(stored.AnsweredQuestions, client.AnsweredQuestions),
+		Achievements:      union(stored.Achievements, client.Achievements),
+	}
+	switch {
+	case !stored.SavedAt.After(lastSynced), client.SavedAt.After(stored.SavedAt):
+		merged.Room = client.Room
+	case stored.SavedAt.Equal(client.SavedAt) && stored.Room != client.Room:
+		rooms = []string{stored.Room, client.Room}
+	}
+	return merged, rooms
+}
+
+// syncHandler serves POST /api/sync?token=T, merging a client's offline
+// progress with the saved game and saving the result.
+func syncHandler(store Store) http.Handler {
+	var mu sync.Mutex // serializes read-merge-write cycles
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		token := r.URL.Query().Get("token")
+		if !validToken.MatchString(token) {
+			writeAPIError(w, http.StatusBadRequest, "invalid or missing token")
+			return
+		}
+		var req syncRequest
+		r.Body = http.MaxBytesReader(w, r.Body, maxSaveBody)
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeAPIError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("sync must be at most %d bytes", tooLarge.Limit))
+				return
+			}
+			writeAPIError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		if err := req.State.validate(); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		merged := req.State
+		var rooms []string
+		data, err := traceStore(r.Context(), store).Get(savesNamespace, token)
+		switch {
+		case err == nil:
+			var stored GameState
+			if err := json.Unmarshal(data, &stored); err != nil {
+				writeAPIError(w, http.StatusInternalServerError, "could not load game")
+				return
+			}
+			merged, rooms = mergeStates(stored, req.State, req.LastSyncedAt)
+		case !errors.Is(err, ErrNotFound):
+			writeAPIError(w, http.StatusInternalServerError, "could not load game")
+			return
+		}
+		if rooms != nil && req.ResolveRoom != "" {
+			if !slices.Contains(rooms, req.ResolveRoom) {
+				writeAPIError(w, http.StatusBadRequest, "resolveRoom must be one of the conflicting rooms")
+				return
+			}
+			merged.Room, rooms = req.ResolveRoom, nil
+		}
+
+		now := time.Now().UTC()
+		merged.SavedAt = now
+		resp := syncResponse{State: merged, SyncedAt: now, Conflict: rooms != nil, Rooms: rooms}
+		if resp.Conflict {
+			writeJSON(w, http.StatusOK, resp)
+			return
+		}
+		data, err = json.Marshal(merged)
+		if err == nil {
+			err = traceStore(r.Context(), store).Set(savesNamespace, token, data)
+		}
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "could not save game")
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeStates(t *testing.T) {
+	synced := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	later := synced.Add(time.Hour)
+	stored := GameState{Room: "library", Score: 300, AnsweredQuestions: []string{"q1", "q2"}, Achievements: []string{"first_steps"}, SavedAt: synced}
+	tests := []struct {
+		name      string
+		stored    GameState
+		client    GameState
+		wantRoom  string
+		wantRooms []string
+	}{
+		{"server unchanged since sync", stored, GameState{Room: "vault", SavedAt: later}, "vault", nil},
+		{"client played offline after a server save", GameState{Room: "library", SavedAt: later}, GameState{Room: "vault", SavedAt: later.Add(time.Minute)}, "vault", nil},
+		{"server saved after the client", GameState{Room: "library", SavedAt: later.Add(time.Minute)}, GameState{Room: "vault", SavedAt: later}, "library", nil},
+		{"same instant, same room", GameState{Room: "vault", SavedAt: later}, GameState{Room: "vault", SavedAt: later}, "vault", nil},
+		{"same instant, different rooms", GameState{Room: "library", SavedAt: later}, GameState{Room: "vault", SavedAt: later}, "library", []string{"library", "vault"}},
+	}
+	for _, tt := range tests {
+		merged, rooms := mergeStates(tt.stored, tt.client, synced)
+		if merged.Room != tt.wantRoom || !reflect.DeepEqual(rooms, tt.wantRooms) {
+			t.Errorf("%s: room %q with conflict %v, want %q with %v", tt.name, merged.Room, rooms, tt.wantRoom, tt.wantRooms)
+		}
+	}
+
+	client := GameState{Room: "vault", Score: 250, AnsweredQuestions: []string{"q2", "q3"}, Achievements: []string{"explorer", "first_steps"}, SavedAt: later}
+	merged, _ := mergeStates(stored, client, synced)
+	if merged.Score != 300 {
+		t.Errorf("merged score %d, want the higher 300", merged.Score)
+	}
+	if want := []string{"q1", "q2", "q3"}; !reflect.DeepEqual(merged.AnsweredQuestions, want) {
+		t.Errorf("merged questions %v, want the union %v", merged.AnsweredQuestions, want)
+	}
+	if want := []string{"first_steps", "explorer"}; !reflect.DeepEqual(merged.Achievements, want) {
+		t.Errorf("merged achievements %v, want the union %v", merged.Achievements, want)
+	}
+}
+
+// syncState posts req to h and returns the status code and response.
+func syncState(t *testing.T, h http.Handler, req syncRequest) (int, syncResponse) {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/sync?token="+testSaveToken, strings.NewReader(string(body))))
+	var resp syncResponse
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, resp
+}
+
+// storedState returns the game saved under testSaveToken in store.
+func storedState(t *testing.T, store Store) GameState {
+	t.Helper()
+	data, err := store.Get(savesNamespace, testSaveToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state GameState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestSyncCleanMerge(t *testing.T) {
+	store := newMemoryStore()
+	h := syncHandler(store)
+	first := GameState{Room: "library", Score: 100, AnsweredQuestions: []string{"q1"}, Achievements: []string{}}
+	code, resp := syncState(t, h, syncRequest{State: first})
+	if code != http.StatusOK || resp.Conflict || resp.State.Room != "library" {
+		t.Fatalf("first sync: status %d, %+v", code, resp)
+	}
+
+	offline := GameState{Room: "vault", Score: 80, AnsweredQuestions: []string{"q2"}, Achievements: []string{"explorer"}, SavedAt: time.Now().UTC()}
+	code, resp = syncState(t, h, syncRequest{State: offline, LastSyncedAt: resp.SyncedAt})
+	if code != http.StatusOK || resp.Conflict {
+		t.Fatalf("offline sync: status %d, %+v", code, resp)
+	}
+	want := GameState{Room: "vault", Score: 100, AnsweredQuestions: []string{"q1", "q2"}, Achievements: []string{"explorer"}}
+	saved := storedState(t, store)
+	saved.SavedAt, resp.State.SavedAt = time.Time{}, time.Time{}
+	if !reflect.DeepEqual(resp.State, want) || !reflect.DeepEqual(saved, want) {
+		t.Errorf("merged %+v and saved %+v, want %+v", resp.State, saved, want)
+	}
+}
+
+func TestSyncConflict(t *testing.T) {
+	store := newMemoryStore()
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	data, _ := json.Marshal(GameState{Room: "library", Score: 100, SavedAt: at})
+	if err := store.Set(savesNamespace, testSaveToken, data); err != nil {
+		t.Fatal(err)
+	}
+	h := syncHandler(store)
+	req := syncRequest{State: GameState{Room: "vault", Score: 50, SavedAt: at}, LastSyncedAt: at.Add(-time.Hour)}
+
+	code, resp := syncState(t, h, req)
+	if code != http.StatusOK || !resp.Conflict || !reflect.DeepEqual(resp.Rooms, []string{"library", "vault"}) {
+		t.Fatalf("conflicting sync: status %d, %+v", code, resp)
+	}
+	if saved := storedState(t, store); saved.Room != "library" || !saved.SavedAt.Equal(at) {
+		t.Errorf("a conflicting merge was saved: %+v", saved)
+	}
+
+	req.ResolveRoom = "nowhere"
+	if code, _ := syncState(t, h, req); code != http.StatusBadRequest {
+		t.Errorf("resolving to another room: status %d, want 400", code)
+	}
+	req.ResolveRoom = "vault"
+	code, resp = syncState(t, h, req)
+	if code != http.StatusOK || resp.Conflict || resp.State.Room != "vault" || resp.State.Score != 100 {
+		t.Fatalf("resolved sync: status %d, %+v", code, resp)
+	}
+	if saved := storedState(t, store); saved.Room != "vault" {
+		t.Errorf("resolved merge saved in room %q, want vault", saved.Room)
+	}
+}
+
+func TestSyncRejectsBadRequests(t *testing.T) {
+	h := syncHandler(newMemoryStore())
+	for _, c := range []struct{ name, target, body string }{
+		{"no token", "/api/sync", `{"state": {"room": "library"}}`},
+		{"malformed JSON", "/api/sync?token=" + testSaveToken, `{"state": `},
+		{"missing room", "/api/sync?token=" + testSaveToken, `{"state": {"score": 5}}`},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, c.target, strings.NewReader(c.body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", c.name, w.Code)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// validTenantName is what a tenant may be called. The name prefixes the
+// tenant's Store namespaces, so it is kept short enough for them to stay
+// valid store names.
+var validTenantName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// tenantSettings are the settings a tenant may set for itself, by flag
+// name. The rest, such as the listener, the Store, and the rate limits,
+// belong to the process and are shared by every tenant.
+var tenantSettings = []string{
+	"admin-password", "admin-user", "app-name", "app-short-name",
+	"background-color", "chat-blocklist", "difficulty-time-limits",
+	"enforce-time-limits", "experiments", "exposure-half-life", "hint-penalty",
+	"max-answer-attempts", "min-run-time", "name-blocklist", "public-stats", "public-url",
+	"reveal-locked-explanation", "robots-disallow",
+	"room-capacity", "room-code-ttl", "scope", "selection-difficulty", "selection-freshness",
+	"smtp-from", "smtp-top-rank", "smtp-to",
+	"start-url", "streak-max", "streak-step",
+	"theme-accent", "theme-bg", "theme-color", "theme-panel",
+	"theme-primary", "theme-text", "time-bonus", "time-bonus-window",
+	"time-limit-grace", "webhook-secret", "webhook-urls",
+}
+
+// tenantContentPatterns are the files a tenant's content directory may
+// replace in the shared content: the game data, the maps, the manifest,
+// and question media. Pages, stylesheets, and scripts stay shared.
+var tenantContentPatterns = []string{
+	"data/*.json", "maps/*.json", "manifest.json", questionMediaDir + "/*",
+}
+
+// tenantSpec is one entry of the tenants file. Settings are keyed by flag
+// name, as in a config file, and applied over the process's own.
+type tenantSpec struct {
+	Name     string         `json:"name" yaml:"name"`
+	Hosts    []string       `json:"hosts" yaml:"hosts"`
+	Content  string         `json:"content,omitempty" yaml:"content,omitempty"`
+	Settings map[string]any `json:"settings,omitempty" yaml:"settings,omitempty"`
+}
+
+// tenant is a game instance served to the hosts named for it, with its own
+// content, settings, and Store namespaces.
+type tenant struct {
+	Name    string
+	Hosts   []string
+	content fs.FS
+	cfg     *Config
+}
+
+// loadTenants reads the tenants file named by cfg.Tenants, if any, and
+// checks every tenant in it: its name and hosts, the settings it
+// overrides, and the content it serves, which is content with the files
+// of its content directory in place of the shared ones.
+func loadTenants(cfg *Config, content fs.FS) ([]tenant, error) {
+	if cfg.Tenants == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(cfg.Tenants)
+	if err != nil {
+		return nil, fmt.Errorf("reading tenants: %w", err)
+	}
+	var specs []tenantSpec
+	switch strings.ToLower(filepath.Ext(cfg.Tenants)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &specs)
+	default:
+		err = json.Unmarshal(data, &specs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.Tenants, err)
+	}
+
+	var tenants []tenant
+	var errs []error
+	names := make(map[string]bool)
+	hosts := make(map[string]string)
+	for i, spec := range specs {
+		where := fmt.Sprintf("%s: tenants[%d]", cfg.Tenants, i)
+		if !validTenantName.MatchString(spec.Name) {
+			errs = append(errs, fmt.Errorf("%s: name %q must be lowercase letters, digits, and hyphens, up to 32", where, spec.Name))
+			continue
+		}
+		where = fmt.Sprintf("%s: tenant %s", cfg.Tenants, spec.Name)
+		if names[spec.Name] {
+			errs = append(errs, fmt.Errorf("%s: duplicate name", where))
+			continue
+		}
+		names[spec.Name] = true
+		if len(spec.Hosts) == 0 {
+			errs = append(errs, fmt.Errorf("%s: no hosts", where))
+		}
+		t := tenant{Name: spec.Name, content: content}
+		for _, h := range spec.Hosts {
+			host := tenantHost(h)
+			if host == "" || strings.ContainsAny(host, "/:@ ") {
+				errs = append(errs, fmt.Errorf("%s: invalid host %q", where, h))
+				continue
+			}
+			if other, ok := hosts[host]; ok {
+				errs = append(errs, fmt.Errorf("%s: host %s is also served by tenant %s", where, host, other))
+				continue
+			}
+			hosts[host] = spec.Name
+			t.Hosts = append(t.Hosts, host)
+		}
+		var err error
+		if t.cfg, err = tenantConfig(cfg, spec.Settings); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", where, err))
+		}
+		if spec.Content != "" {
+			if fi, err := os.Stat(spec.Content); err != nil || !fi.IsDir() {
+				errs = append(errs, fmt.Errorf("%s: content %q is not a directory", where, spec.Content))
+				continue
+			}
+			t.content = tenantFS{over: devFS{os.DirFS(spec.Content), tenantContentPatterns}, base: content}
+			if err := validateContent(t.content); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", where, err))
+			}
+		}
+		tenants = append(tenants, t)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return tenants, nil
+}
+
+// tenantConfig returns a copy of cfg with settings applied over it. Only
+// the tenantSettings may be set.
+func tenantConfig(cfg *Config, settings map[string]any) (*Config, error) {
+	tcfg := *cfg
+	fs := tcfg.flagSet()
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var errs []error
+	for _, k := range keys {
+		if !slices.Contains(tenantSettings, k) {
+			errs = append(errs, fmt.Errorf("setting %q cannot be set per tenant", k))
+			continue
+		}
+		if err := fs.Set(k, configValue(settings[k])); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", k, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	if err := tcfg.validate(); err != nil {
+		return nil, err
+	}
+	return &tcfg, nil
+}
+
+// tenantHost returns the host name of a Host header or tenants file entry:
+// lower case, without a port or trailing dot.
+func tenantHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// tenantSecret derives the session secret of tenant name from the
+// process's, so that tokens and share links signed for one tenant do not
+// verify on another.
+func tenantSecret(secret []byte, name string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("tenant\x00" + name))
+	return mac.Sum(nil)
+}
+
+// tenantRouter sends each request to the site of the tenant its Host
+// names, and every other request to the default site.
+type tenantRouter struct {
+	sites    map[string]http.Handler
+	fallback http.Handler
+}
+
+func newTenantRouter(fallback http.Handler) *tenantRouter {
+	return &tenantRouter{sites: make(map[string]http.Handler), fallback: fallback}
+}
+
+// add serves h to hosts.
+func (t *tenantRouter) add(hosts []string, h http.Handler) {
+	for _, host := range hosts {
+		t.sites[host] = h
+	}
+}
+
+func (t *tenantRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h, ok := t.sites[tenantHost(r.Host)]; ok {
+		h.ServeHTTP(w, r)
+		return
+	}
+	t.fallback.ServeHTTP(w, r)
+}
+
+// tenantFS is a tenant's content, or that of -content-dir: the files of
+// over in place of those of base. A file over replaces also hides base's
+// language variants and Brotli encodings of it, which would otherwise be
+// served in its stead.
+type tenantFS struct {
+	over, base fs.FS
+}
+
+func (t tenantFS) Open(name string) (fs.File, error) {
+	dir := false
+	if f, err := t.over.Open(name); err == nil {
+		fi, err := f.Stat()
+		if err == nil && !fi.IsDir() {
+			return f, nil
+		}
+		dir = err == nil
+		f.Close()
+	}
+	if t.hidden(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	f, err := t.base.Open(name)
+	if err != nil && dir {
+		return t.over.Open(name)
+	}
+	return f, err
+}
+
+// ReadDir lists the entries of both trees, those of over first.
+func (t tenantFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	over, overErr := fs.ReadDir(t.over, name)
+	base, baseErr := fs.ReadDir(t.base, name)
+	if overErr != nil && baseErr != nil {
+		return nil, baseErr
+	}
+	seen := make(map[string]bool, len(over))
+	entries := slices.Clone(over)
+	for _, e := range over {
+		seen[e.Name()] = true
+	}
+	for _, e := range base {
+		if !seen[e.Name()] && !t.hidden(path.Join(name, e.Name())) {
+			entries = append(entries, e)
+		}
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, nil
+}
+
+// hidden reports whether base's file name is a variant of a file over
+// replaces.
+func (t tenantFS) hidden(name string) bool {
+	source := strings.TrimSuffix(name, ".br")
+	if source != name && t.overHas(source) {
+		return true
+	}
+	if m := localizedFile.FindStringSubmatch(source); m != nil && t.overHas(m[1]+m[3]) {
+		return true
+	}
+	return false
+}
+
+func (t tenantFS) overHas(name string) bool {
+	fi, err := fs.Stat(t.over, name)
+	return err == nil && !fi.IsDir()
+}
+
+// tenantStore keeps a tenant's data in its own namespaces of the Store
+// shared by every tenant, each prefixed with the tenant's name and an
+// underscore. Tenant names hold no underscore, so the prefix ends at the
+// first one and no two tenants' namespaces can meet.
+type tenantStore struct {
+	store  Store
+	prefix string
+}
+
+// newTenantStore returns the Store of tenant name, which can make several
+// changes at once if store can.
+func newTenantStore(store Store, name string) Store {
+	s := tenantStore{store: store, prefix: "tenant-" + name + "_"}
+	if u, ok := store.(updater); ok {
+		return tenantUpdater{tenantStore: s, u: u}
+	}
+	return s
+}
+
+// namespace returns the shared namespace holding the tenant's namespace,
+// rejecting a namespace that is not a valid name before it is prefixed
+// into one.
+func (s tenantStore) namespace(namespace string) (string, error) {
+	if err := checkStoreNames(namespace); err != nil {
+		return "", err
+	}
+	return s.prefix + namespace, nil
+}
+
+func (s tenantStore) Get(namespace, key string) ([]byte, error) {
+	ns, err := s.namespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.Get(ns, key)
+}
+
+func (s tenantStore) Set(namespace, key string, value []byte) error {
+	ns, err := s.namespace(namespace)
+	if err != nil {
+		return err
+	}
+	return s.store.Set(ns, key, value)
+}
+
+func (s tenantStore) List(namespace string) ([]string, error) {
+	ns, err := s.namespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.List(ns)
+}
+
+func (s tenantStore) Delete(namespace, key string) error {
+	ns, err := s.namespace(namespace)
+	if err != nil {
+		return err
+	}
+	return s.store.Delete(ns, key)
+}
+
+// tenantUpdater is a tenantStore over a Store that is an updater.
+type tenantUpdater struct {
+	tenantStore
+	u updater
+}
+
+func (s tenantUpdater) Update(fn func(Store) error) error {
+	return s.u.Update(func(tx Store) error {
+		return fn(tenantStore{store: tx, prefix: s.prefix})
+	})
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestTenantStoresSeparate(t *testing.T) {
+	shared := openSQLite(t, ":memory:")
+	a, b := newTenantStore(shared, "a"), newTenantStore(shared, "b")
+	if _, ok := a.(updater); !ok {
+		t.Fatal("a tenant store over an updater cannot update")
+	}
+	a.Set("saves", "ada", []byte("a's"))
+	err := a.(updater).Update(func(tx Store) error {
+		return tx.Set("saves", "bob", []byte("a's too"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Set("saves", "ada", []byte("b's"))
+	shared.Set("saves", "ada", []byte("the default's"))
+
+	for _, tt := range []struct {
+		store Store
+		want  string
+		keys  []string
+	}{
+		{a, "a's", []string{"ada", "bob"}},
+		{b, "b's", []string{"ada"}},
+		{shared, "the default's", []string{"ada"}},
+	} {
+		if got, err := tt.store.Get("saves", "ada"); err != nil || string(got) != tt.want {
+			t.Errorf("Get = %q, %v; want %q", got, err, tt.want)
+		}
+		if keys, _ := tt.store.List("saves"); !slices.Equal(keys, tt.keys) {
+			t.Errorf("List = %v, want %v", keys, tt.keys)
+		}
+	}
+	a.Delete("saves", "ada")
+	if got, _ := b.Get("saves", "ada"); string(got) != "b's" {
+		t.Error("deleting a tenant's key deleted another's")
+	}
+}
+
+func TestTenantStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store { return newTenantStore(newMemoryStore(), "a") })
+}
+
+func TestTenantStoreOverSQLite(t *testing.T) {
+	testStore(t, func(t *testing.T) Store { return newTenantStore(openSQLite(t, ":memory:"), "a") })
+}
+
+func TestTenantNamespacesDoNotCollide(t *testing.T) {
+	shared := newMemoryStore()
+	// Joined by a hyphen, tenant "a" namespace "b-saves" and tenant "a-b"
+	// namespace "saves" would be one namespace.
+	a, ab := newTenantStore(shared, "a"), newTenantStore(shared, "a-b")
+	if err := a.Set("b-saves", "ada", []byte("a's")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ab.Set("saves", "ada", []byte("a-b's")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := a.Get("b-saves", "ada"); err != nil || string(got) != "a's" {
+		t.Errorf("tenant a's value = %q, %v; want a's own", got, err)
+	}
+	if got, err := ab.Get("saves", "ada"); err != nil || string(got) != "a-b's" {
+		t.Errorf("tenant a-b's value = %q, %v; want a-b's own", got, err)
+	}
+}
+
+func TestTenantHost(t *testing.T) {
+	for in, want := range map[string]string{
+		"Tenant-A.Example":      "tenant-a.example",
+		"tenant-a.example:8080": "tenant-a.example",
+		"tenant-a.example.":     "tenant-a.example",
+		"[::1]:8080":            "::1",
+	} {
+		if got := tenantHost(in); got != want {
+			t.Errorf("tenantHost(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTenantFSOverlaysContent(t *testing.T) {
+	base := fstest.MapFS{
+		"data/questions.json":    {Data: []byte("shared")},
+		"data/questions.fr.json": {Data: []byte("shared fr")},
+		"data/questions.json.br": {Data: []byte("shared br")},
+		"css/game.css":           {Data: []byte("body{}")},
+	}
+	fsys := tenantFS{over: fstest.MapFS{"data/questions.json": {Data: []byte("tenant")}}, base: base}
+	if data, _ := fs.ReadFile(fsys, "data/questions.json"); string(data) != "tenant" {
+		t.Errorf("questions = %q, want the tenant's", data)
+	}
+	if data, _ := fs.ReadFile(fsys, "css/game.css"); string(data) != "body{}" {
+		t.Errorf("stylesheet = %q, want the shared one", data)
+	}
+	for _, name := range []string{"data/questions.fr.json", "data/questions.json.br"} {
+		if _, err := fs.ReadFile(fsys, name); err == nil {
+			t.Errorf("%s of the shared questions is still served", name)
+		}
+	}
+}
+
+// tenantContent writes a content directory whose one question has the ID
+// id, with the embedded map asking only that, and returns its path.
+func tenantContent(t *testing.T, id string) string {
+	t.Helper()
+	questions, err := os.ReadFile("testdata/validate/valid/data/questions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tower, err := fs.ReadFile(staticFS, "maps/tower.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"data/questions.json": bytes.Replace(questions, []byte(`"id": "t001"`), []byte(`"id": "`+id+`"`), 1),
+		"maps/tower.json":     regexp.MustCompile(`"questions": \[[^]]*\]`).ReplaceAll(tower, []byte(`"questions": ["`+id+`"]`)),
+	} {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadTenantsValidated(t *testing.T) {
+	content := tenantContent(t, "a001")
+	invalid, err := filepath.Abs("testdata/validate/invalid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, doc, want string
+	}{
+		{"valid", `[{"name":"a","hosts":["a.example"],"content":"` + content + `","settings":{"theme-accent":"#336699"}}]`, ""},
+		{"bad name", `[{"name":"Tenant A","hosts":["a.example"]}]`, "lowercase letters"},
+		{"duplicate name", `[{"name":"a","hosts":["a.example"]},{"name":"a","hosts":["b.example"]}]`, "duplicate name"},
+		{"no hosts", `[{"name":"a"}]`, "no hosts"},
+		{"bad host", `[{"name":"a","hosts":["ada@a.example"]}]`, "invalid host"},
+		{"shared host", `[{"name":"a","hosts":["a.example"]},{"name":"b","hosts":["A.example:80"]}]`, "also served by tenant a"},
+		{"process setting", `[{"name":"a","hosts":["a.example"],"settings":{"addr":":9000"}}]`, `"addr" cannot be set per tenant`},
+		{"bad setting", `[{"name":"a","hosts":["a.example"],"settings":{"theme-accent":"blue"}}]`, "theme"},
+		{"missing content", `[{"name":"a","hosts":["a.example"],"content":"` + filepath.Join(content, "nope") + `"}]`, "is not a directory"},
+		{"invalid content", `[{"name":"a","hosts":["a.example"],"content":"` + invalid + `"}]`, "content problem"},
+		{"malformed", `[{"name":`, "tenants.json"},
+	}
+	for _, tt := range tests {
+		cfg := defaultConfig()
+		cfg.Tenants = writeConfigFile(t, "tenants.json", tt.doc)
+		tenants, err := loadTenants(cfg, staticFS)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want == "" && (len(tenants) != 1 || tenants[0].cfg.Theme.Accent != "#336699" || tenants[0].cfg.Addr != cfg.Addr):
+			t.Errorf("%s: tenants %+v", tt.name, tenants)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: error %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+
+	cfg := defaultConfig()
+	cfg.Tenants = writeConfigFile(t, "tenants.yaml", "- name: a\n  hosts: [a.example]\n  settings:\n    app-name: Castle A\n")
+	if tenants, err := loadTenants(cfg, staticFS); err != nil || len(tenants) != 1 || tenants[0].cfg.AppName != "Castle A" {
+		t.Errorf("YAML tenants: %+v, %v", tenants, err)
+	}
+}
+
+// hostRequest sends method to path on s for host, with body encoded as
+// JSON unless nil, decodes the response into out unless nil, and returns
+// the status.
+func hostRequest(t *testing.T, s *runningServer, host, method, path string, body, out any) int {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, s.url(path), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = host
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode
+}
+
+func TestTenantsServeOwnGames(t *testing.T) {
+	doc := `[
+		{"name":"tenant-a","hosts":["tenant-a.example"],"content":"` + tenantContent(t, "a001") + `"},
+		{"name":"tenant-b","hosts":["tenant-b.example"],"content":"` + tenantContent(t, "b001") + `","settings":{"theme-accent":"#336699","app-name":"Castle B"}}
+	]`
+	s := startServer(t, "-tenants", writeConfigFile(t, "tenants.json", doc), "-min-run-time", "0s")
+	const a, b, other = "tenant-a.example", "tenant-b.example:80", "127.0.0.1"
+
+	ids := func(host string) []string {
+		t.Helper()
+		var body struct {
+			Questions []PublicQuestion `json:"questions"`
+		}
+		if code := hostRequest(t, s, host, http.MethodGet, "/api/questions?count=50", nil, &body); code != http.StatusOK {
+			t.Fatalf("%s questions: status %d", host, code)
+		}
+		var ids []string
+		for _, q := range body.Questions {
+			ids = append(ids, q.ID)
+		}
+		return ids
+	}
+	if got := ids(a); !slices.Equal(got, []string{"a001"}) {
+		t.Errorf("tenant-a questions %v, want a001", got)
+	}
+	if got := ids(b); !slices.Equal(got, []string{"b001"}) {
+		t.Errorf("tenant-b questions %v, want b001", got)
+	}
+	if got := ids(other); !slices.Contains(got, "q001") || slices.Contains(got, "a001") {
+		t.Errorf("another host's questions %v, want the embedded ones", got)
+	}
+
+	var sess sessionResponse
+	if code := hostRequest(t, s, a, http.MethodPost, "/api/session", nil, &sess); code != http.StatusCreated {
+		t.Fatalf("tenant-a session: status %d", code)
+	}
+	key, _ := hex.DecodeString(sess.SessionKey)
+	entry := map[string]any{"name": "ada", "score": 0, "timeMs": 1000, "token": sess.Token, "signature": scoreSignature(key, 0, 1000, sess.SessionID)}
+	if code := hostRequest(t, s, b, http.MethodPost, "/api/leaderboard", entry, nil); code != http.StatusForbidden {
+		t.Errorf("tenant-a's session on tenant-b: status %d, want 403", code)
+	}
+	if code := hostRequest(t, s, a, http.MethodPost, "/api/leaderboard", entry, nil); code != http.StatusCreated {
+		t.Fatalf("tenant-a score: status %d", code)
+	}
+	board := func(host string) int {
+		t.Helper()
+		var body struct {
+			Entries []LeaderboardEntry `json:"entries"`
+		}
+		hostRequest(t, s, host, http.MethodGet, "/api/leaderboard", nil, &body)
+		return len(body.Entries)
+	}
+	if board(a) != 1 || board(b) != 0 || board(other) != 0 {
+		t.Errorf("leaderboards hold %d, %d, and %d entries, want only tenant-a's one", board(a), board(b), board(other))
+	}
+
+	var manifest struct {
+		Name string `json:"name"`
+	}
+	hostRequest(t, s, b, http.MethodGet, "/manifest.json", nil, &manifest)
+	if manifest.Name != "Castle B" {
+		t.Errorf("tenant-b manifest named %q, want Castle B", manifest.Name)
+	}
+	getTheme := func(host string) string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, s.url("/theme.css"), nil)
+		req.Host = host
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return string(data)
+	}
+	if !strings.Contains(getTheme(b), "#336699") || strings.Contains(getTheme(a), "#336699") {
+		t.Error("tenant-b's accent is not its own")
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// themeFile is where the generated theme stylesheet is served.
+const themeFile = "theme.css"
+
+// ThemeColors are the palette the stylesheets draw from, as #RRGGBB
+// colors. The defaults are the stylesheets' own.
+type ThemeColors struct {
+	Primary    string
+	Accent     string
+	Background string
+	Panel      string
+	Text       string
+}
+
+// a11yVariant is an accessibility variant of the theme stylesheet. It
+// applies the rules the stylesheets keep under its media query whatever
+// the browser reports, for users who cannot rely on the query or on the
+// game's script to switch them on.
+type a11yVariant struct {
+	name  string // in ?a11y= queries
+	media string // the media query whose rules it applies
+	hint  string // the client hint requesting it
+	value string // the hint's value that does
+}
+
+// a11yVariants are the variants a request may select, in the order they
+// are applied and named in a selection.
+var a11yVariants = []a11yVariant{
+	{name: "high-contrast", media: "@media (prefers-contrast: high)", hint: "Sec-CH-Prefers-Contrast", value: "more"},
+	{name: "reduced-motion", media: "@media (prefers-reduced-motion: reduce)", hint: "Sec-CH-Prefers-Reduced-Motion", value: "reduce"},
+}
+
+// a11yHints lists the client hints of a11yVariants, for Accept-CH and Vary.
+var a11yHints = func() string {
+	var hints []string
+	for _, v := range a11yVariants {
+		hints = append(hints, v.hint)
+	}
+	return strings.Join(hints, ", ")
+}()
+
+// a11ySelection returns the variants r asks for, as a comma-separated
+// list of names in a11yVariants order, or "" for none. An ?a11y= query
+// decides on its own, so that ?a11y=none overrides the client hints;
+// unknown names in it are ignored.
+func a11ySelection(r *http.Request) string {
+	var picked []string
+	if q, ok := r.URL.Query()["a11y"]; ok {
+		names := strings.Split(strings.Join(q, ","), ",")
+		for _, v := range a11yVariants {
+			if slices.Contains(names, v.name) {
+				picked = append(picked, v.name)
+			}
+		}
+		return strings.Join(picked, ",")
+	}
+	for _, v := range a11yVariants {
+		if strings.Trim(strings.TrimSpace(r.Header.Get(v.hint)), `"`) == v.value {
+			picked = append(picked, v.name)
+		}
+	}
+	return strings.Join(picked, ",")
+}
+
+// themeURL returns the URL of the theme stylesheet with the variants of
+// selection, relative to the base path.
+func themeURL(selection string) string {
+	if selection == "" {
+		return themeFile
+	}
+	return themeFile + "?a11y=" + selection
+}
+
+// loadA11yRules collects the rules of each of a11yVariants from the
+// stylesheets under css/ in content, keyed by variant name.
+func loadA11yRules(content fs.FS) (map[string]string, error) {
+	names, err := fs.Glob(content, "css/*.css")
+	if err != nil {
+		return nil, err
+	}
+	rules := make(map[string]string, len(a11yVariants))
+	for _, name := range names {
+		css, err := fs.ReadFile(content, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range a11yVariants {
+			blocks, err := mediaBlocks(string(css), v.media)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			for _, block := range blocks {
+				rules[v.name] += fmt.Sprintf("/* %s */%s\n", name, block)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// mediaBlocks returns the bodies of the blocks of css under media, the
+// prelude of an @media rule up to its opening brace.
+func mediaBlocks(css, media string) ([]string, error) {
+	var blocks []string
+	for {
+		i := strings.Index(css, media)
+		if i < 0 {
+			return blocks, nil
+		}
+		css = css[i+len(media):]
+		open := strings.IndexByte(css, '{')
+		if open < 0 || strings.TrimSpace(css[:open]) != "" {
+			return nil, fmt.Errorf("%s is not followed by a block", media)
+		}
+		depth := 0
+		end := -1
+		for j := open; j < len(css) && end < 0; j++ {
+			switch css[j] {
+			case '{':
+				depth++
+			case '}':
+				if depth--; depth == 0 {
+					end = j
+				}
+			}
+		}
+		if end < 0 {
+			return nil, fmt.Errorf("%s block is not closed", media)
+		}
+		blocks = append(blocks, css[open+1:end])
+		css = css[end+1:]
+	}
+}
+
+// buildTheme returns a stylesheet setting the custom properties that
+// css/mm-vars.css reads the palette from to colors.
+func buildTheme(colors ThemeColors) []byte {
+	var b strings.Builder
+	b.WriteString("/* Generated by the server from its theme settings. */\n:root {\n")
+	for _, p := range []struct{ name, value string }{
+		{"primary", colors.Primary},
+		{"accent", colors.Accent},
+		{"bg", colors.Background},
+		{"panel", colors.Panel},
+		{"text", colors.Text},
+	} {
+		fmt.Fprintf(&b, "    --%s: %s;\n", p.name, p.value)
+	}
+	b.WriteString("}\n")
+	return []byte(b.String())
+}
+
+// buildThemes returns the theme stylesheet for every selection of
+// a11yVariants, keyed as a11ySelection names it: the palette of colors
+// followed by the rules of each variant selected.
+func buildThemes(colors ThemeColors, rules map[string]string) map[string][]byte {
+	base := buildTheme(colors)
+	themes := make(map[string][]byte, 1<<len(a11yVariants))
+	for set := 0; set < 1<<len(a11yVariants); set++ {
+		var names []string
+		var b bytes.Buffer
+		b.Write(base)
+		for i, v := range a11yVariants {
+			if set&(1<<i) == 0 {
+				continue
+			}
+			names = append(names, v.name)
+			fmt.Fprintf(&b, "\n/* %s: the stylesheets' %s rules, applied regardless. */\n%s", v.name, v.media, rules[v.name])
+		}
+		themes[strings.Join(names, ",")] = b.Bytes()
+	}
+	return themes
+}
+
+// themeHandler serves the generated theme stylesheet in the variant the
+// request selects from themes. It is marked no-cache, since it changes
+// with the settings rather than the build.
+func themeHandler(themes map[string][]byte) http.Handler {
+	tags := make(map[string]string, len(themes))
+	for selection, css := range themes {
+		tags[selection] = etag(contentHash(css))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		selection := a11ySelection(r)
+		w.Header().Add("Vary", a11yHints)
+		w.Header().Set("Content-Type", "text/css; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", tags[selection])
+		http.ServeContent(w, r, themeFile, time.Time{}, bytes.NewReader(themes[selection]))
+	})
+}
+
+// linkTheme points the theme stylesheet link of page at the variants of
+// selection.
+func linkTheme(page []byte, selection string) []byte {
+	if selection == "" {
+		return page
+	}
+	return bytes.ReplaceAll(page, []byte(`href="`+themeFile+`"`), []byte(`href="`+themeURL(selection)+`"`))
+}
+
+// a11yPages serves the site's top-level pages linked to the theme variant
+// the request selects, so that it applies without the game's script, and
+// asks browsers for the client hints that select one. Pages without a
+// selection, and everything else, go to next; compress wraps the rewritten
+// pages.
+func a11yPages(next http.Handler, content fs.FS, compress func(http.Handler) http.Handler) http.Handler {
+	rewritten := compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := pageName(r)
+		page, err := fs.ReadFile(content, name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(linkTheme(page, a11ySelection(r))))
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := pageName(r)
+		if path.Ext(name) != ".html" || strings.Contains(name, "/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Accept-CH", a11yHints)
+		w.Header().Add("Vary", a11yHints)
+		if _, err := fs.Stat(content, name); err != nil || a11ySelection(r) == "" ||
+			(r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rewritten.ServeHTTP(w, r)
+	})
+}
+
+// pageName returns the file r asks for, index.html for the root.
+func pageName(r *http.Request) string {
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	if name == "" {
+		return "index.html"
+	}
+	return name
+}
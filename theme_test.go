@@ -0,0 +1,174 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestThemeDefaultsToPalette(t *testing.T) {
+	css := string(buildTheme(defaultConfig().Theme))
+	for _, want := range []string{"--primary: #8B4513;", "--accent: #FFFF00;", "--bg: #B8B8B8;", "--panel: #C0C0C0;", "--text: #000000;"} {
+		if !strings.Contains(css, want) {
+			t.Errorf("default theme lacks %q:\n%s", want, css)
+		}
+	}
+}
+
+func TestThemeAccentConfigurable(t *testing.T) {
+	s := startServer(t)
+	code, before := get(t, s.url("/theme.css"))
+	if code != http.StatusOK || !strings.Contains(before, "--accent: #FFFF00;") {
+		t.Fatalf("default /theme.css: status %d:\n%s", code, before)
+	}
+	_, tag := conditionalGet(t, s.url("/theme.css"), "")
+	s.stop(t)
+
+	s = startServer(t, "-theme-accent", "#336699")
+	code, after := get(t, s.url("/theme.css"))
+	if code != http.StatusOK || !strings.Contains(after, "--accent: #336699;") || strings.Contains(after, "#FFFF00") {
+		t.Errorf("/theme.css with -theme-accent: status %d:\n%s", code, after)
+	}
+	if !strings.Contains(after, "--primary: #8B4513;") {
+		t.Error("overriding the accent changed the other colors")
+	}
+	code, newTag := conditionalGet(t, s.url("/theme.css"), tag)
+	if code != http.StatusOK || newTag == tag {
+		t.Errorf("the old theme's ETag: status %d and tag %s, want 200 and a new one", code, newTag)
+	}
+	if code, _ := conditionalGet(t, s.url("/theme.css"), newTag); code != http.StatusNotModified {
+		t.Errorf("the current ETag: status %d, want 304", code)
+	}
+	// Pages link the stylesheet, and the service worker precaches it.
+	if _, index := get(t, s.url("/")); !strings.Contains(index, `href="theme.css"`) {
+		t.Error("index.html does not link theme.css")
+	}
+	if _, sw := get(t, s.url("/sw.js")); !strings.Contains(sw, contentHash([]byte(after))[:16]) {
+		t.Error("sw.js does not precache the served theme")
+	}
+}
+
+func TestThemeColorsValidated(t *testing.T) {
+	for _, args := range [][]string{
+		{"-theme-accent", "yellow"},
+		{"-theme-bg", "#12345"},
+		{"-theme-text", "#GGGGGG"},
+	} {
+		if _, err := loadConfig(args, noEnv); err == nil || !strings.Contains(err.Error(), args[0][1:]) {
+			t.Errorf("%v: error %v, want one naming the flag", args, err)
+		}
+	}
+}
+
+func TestA11ySelection(t *testing.T) {
+	tests := []struct {
+		name, query string
+		hints       map[string]string
+		want        string
+	}{
+		{"none", "", nil, ""},
+		{"query", "?a11y=high-contrast", nil, "high-contrast"},
+		{"both, reordered", "?a11y=reduced-motion,high-contrast", nil, "high-contrast,reduced-motion"},
+		{"repeated params", "?a11y=reduced-motion&a11y=high-contrast", nil, "high-contrast,reduced-motion"},
+		{"unknown ignored", "?a11y=sepia,reduced-motion", nil, "reduced-motion"},
+		{"contrast hint", "", map[string]string{"Sec-CH-Prefers-Contrast": `"more"`}, "high-contrast"},
+		{"motion hint", "", map[string]string{"Sec-CH-Prefers-Reduced-Motion": "reduce"}, "reduced-motion"},
+		{"hint declining", "", map[string]string{"Sec-CH-Prefers-Contrast": "no-preference"}, ""},
+		{"query overrides hints", "?a11y=none", map[string]string{"Sec-CH-Prefers-Contrast": "more"}, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/theme.css"+tt.query, nil)
+		for k, v := range tt.hints {
+			r.Header.Set(k, v)
+		}
+		if got := a11ySelection(r); got != tt.want {
+			t.Errorf("%s: selection %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMediaBlocks(t *testing.T) {
+	css := `a { color: red }
+@media (prefers-contrast: high) { .a { border: 2px } .b { outline: 0 } }
+@media (min-width: 600px) { .c { x: y } }
+@media (prefers-contrast: high) { @supports (x: y) { .d { z: 1 } } }`
+	blocks, err := mediaBlocks(css, "@media (prefers-contrast: high)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || !strings.Contains(blocks[0], ".b { outline: 0 }") || !strings.Contains(blocks[1], ".d { z: 1 }") || strings.Contains(blocks[0], ".c") {
+		t.Errorf("blocks %q", blocks)
+	}
+	if _, err := mediaBlocks("@media (prefers-contrast: high) { .a {", "@media (prefers-contrast: high)"); err == nil {
+		t.Error("an unclosed block was accepted")
+	}
+}
+
+// getWithHeaders fetches url with the request headers h and returns the
+// response and its body.
+func getWithHeaders(t *testing.T, url string, h map[string]string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	for k, v := range h {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestHighContrastThemeVariant(t *testing.T) {
+	s := startServer(t)
+	_, base := get(t, s.url("/theme.css"))
+	code, contrast := get(t, s.url("/theme.css?a11y=high-contrast"))
+	if code != http.StatusOK || !strings.HasPrefix(contrast, base) {
+		t.Fatalf("high-contrast theme: status %d, want the base theme extended", code)
+	}
+	for _, want := range []string{"/* css/accessibility.css */", "/* css/game.css */", "border-width: 2px"} {
+		if !strings.Contains(contrast, want) {
+			t.Errorf("high-contrast theme lacks %q", want)
+		}
+	}
+	if strings.Contains(base, "border-width") || strings.Contains(contrast, "prefers-reduced-motion") {
+		t.Error("theme variants carry rules they were not asked for")
+	}
+
+	resp, hinted := getWithHeaders(t, s.url("/theme.css"), map[string]string{"Sec-CH-Prefers-Contrast": "more"})
+	if hinted != contrast {
+		t.Error("the contrast client hint did not select the high-contrast theme")
+	}
+	if vary := strings.Join(resp.Header.Values("Vary"), ", "); !strings.Contains(vary, "Sec-CH-Prefers-Contrast") {
+		t.Errorf("Vary = %q, want the client hints", vary)
+	}
+	if _, overridden := getWithHeaders(t, s.url("/theme.css?a11y=none"), map[string]string{"Sec-CH-Prefers-Contrast": "more"}); overridden != base {
+		t.Error("?a11y=none did not override the client hint")
+	}
+
+	resp, page := getWithHeaders(t, s.url("/?a11y=high-contrast"), nil)
+	if !strings.Contains(page, `href="theme.css?a11y=high-contrast"`) {
+		t.Error("the page does not link the selected theme variant")
+	}
+	if !strings.Contains(resp.Header.Get("Accept-CH"), "Sec-CH-Prefers-Contrast") {
+		t.Errorf("Accept-CH = %q, want the client hints", resp.Header.Get("Accept-CH"))
+	}
+	if _, page := get(t, s.url("/")); !strings.Contains(page, `href="theme.css"`) {
+		t.Error("the plain page does not link the base theme")
+	}
+	if _, deep := getAccept(t, s.url("/room/library?a11y=reduced-motion"), "text/html"); !strings.Contains(deep, `href="theme.css?a11y=reduced-motion"`) {
+		t.Error("a deep route does not link the selected theme variant")
+	}
+
+	_, sw := get(t, s.url("/sw.js?a11y=high-contrast"))
+	if !strings.Contains(sw, `"/theme.css?a11y=high-contrast"`) || !strings.Contains(sw, contentHash([]byte(contrast))[:16]) {
+		t.Error("sw.js?a11y=high-contrast does not precache the high-contrast theme")
+	}
+	if _, sw := get(t, s.url("/sw.js")); strings.Contains(sw, "a11y=") {
+		t.Error("the plain sw.js precaches a theme variant")
+	}
+}
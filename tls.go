@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// hstsMaxAge is the Strict-Transport-Security lifetime, in seconds.
+const hstsMaxAge = 365 * 24 * 60 * 60
+
+// tlsEnabled reports whether o configures either TLS mode.
+func (o *Config) tlsEnabled() bool {
+	return o.TLSCert != "" || o.TLSKey != "" || o.AutocertDomain != ""
+}
+
+// validateTLS checks that at most one TLS mode is configured and that a
+// certificate file pair is complete.
+func (o *Config) validateTLS() error {
+	files := o.TLSCert != "" || o.TLSKey != ""
+	switch {
+	case files && o.AutocertDomain != "":
+		return errors.New("tls-cert/tls-key and autocert-domain are mutually exclusive")
+	case files && (o.TLSCert == "" || o.TLSKey == ""):
+		return errors.New("tls-cert and tls-key must be given together")
+	}
+	return nil
+}
+
+// loadKeyPair loads the pair in certFile and keyFile, failing unless its
+// certificate is valid at now.
+func loadKeyPair(certFile, keyFile string, now time.Time) (tls.Certificate, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if now.Before(pair.Leaf.NotBefore) {
+		return tls.Certificate{}, fmt.Errorf("%s is not valid until %s", certFile, pair.Leaf.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(pair.Leaf.NotAfter) {
+		return tls.Certificate{}, fmt.Errorf("%s expired %s", certFile, pair.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	return pair, nil
+}
+
+// newAutocertManager returns a Let's Encrypt certificate manager limited
+// to the configured domain.
+func newAutocertManager(o *Config) *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(o.AutocertDomain),
+		Cache:      autocert.DirCache(o.AutocertCacheDir),
+	}
+}
+
+// hsts tells browsers to use HTTPS for all future visits.
+func hsts(next http.Handler) http.Handler {
+	value := "max-age=" + strconv.Itoa(hstsMaxAge) + "; includeSubDomains"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHSTSOverTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(hsts(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})))
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	got := resp.Header.Get("Strict-Transport-Security")
+	if !strings.HasPrefix(got, "max-age=") || !strings.Contains(got, "includeSubDomains") {
+		t.Errorf("Strict-Transport-Security = %q", got)
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	tests := []struct {
+		cfg Config
+		ok  bool
+	}{
+		{Config{}, true},
+		{Config{TLSCert: "cert.pem", TLSKey: "key.pem"}, true},
+		{Config{AutocertDomain: "example.com"}, true},
+		{Config{TLSCert: "cert.pem"}, false},
+		{Config{TLSKey: "key.pem"}, false},
+		{Config{TLSCert: "cert.pem", TLSKey: "key.pem", AutocertDomain: "example.com"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.validateTLS(); (err == nil) != tt.ok {
+			t.Errorf("validateTLS(cert %q, key %q, autocert %q) = %v", tt.cfg.TLSCert, tt.cfg.TLSKey, tt.cfg.AutocertDomain, err)
+		}
+	}
+}
+
+func TestTLSModesFailFast(t *testing.T) {
+	_, err := loadConfig([]string{"-tls-cert", "cert.pem", "-autocert-domain", "example.com"}, func(string) string { return "" })
+	if err == nil {
+		t.Error("two TLS modes at once were accepted")
+	}
+}
+
+func TestAutocertLimitedToDomain(t *testing.T) {
+	m := newAutocertManager(&Config{AutocertDomain: "lobe.example.com", AutocertCacheDir: t.TempDir()})
+	if err := m.HostPolicy(context.Background(), "lobe.example.com"); err != nil {
+		t.Errorf("configured domain refused: %v", err)
+	}
+	if err := m.HostPolicy(context.Background(), "other.example.com"); err == nil {
+		t.Error("certificate would be requested for another domain")
+	}
+}
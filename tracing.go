@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the server's spans. Until setupTracing installs a
+// provider it is the global no-op tracer.
+var tracer = otel.Tracer("github.com/opd-ai/LobeLabyrinth")
+
+// setupTracing exports spans over OTLP/HTTP to cfg.OTLPEndpoint, sampling
+// cfg.TraceSampleRate of the traces not already sampled upstream, and
+// returns a function flushing and stopping the exporter. Without an
+// endpoint tracing stays off and the function does nothing.
+func setupTracing(ctx context.Context, cfg *Config) (func(context.Context) error, error) {
+	if cfg.OTLPEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.OTLPEndpoint))
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName("lobelabyrinth")))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TraceSampleRate))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// traceRequests starts a server span for each request, continuing any
+// trace context the caller sent, and labels it with the method, the
+// ServeMux pattern that matched, and the response status.
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPRequestMethodKey.String(r.Method), semconv.URLPath(r.URL.Path)))
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			status := rec.Status()
+			span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+			if route := requestRoute(ctx); route != unmatchedRoute {
+				span.SetName(r.Method + " " + route)
+				span.SetAttributes(semconv.HTTPRoute(route))
+			}
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+			span.End()
+		}()
+		rec.serve(next, r.WithContext(ctx))
+	})
+}
+
+// tracedStore records a span, a child of ctx, around each operation on
+// the Store it wraps.
+type tracedStore struct {
+	ctx   context.Context
+	store Store
+}
+
+// traceStore returns store traced under the span in ctx, or store itself
+// when ctx carries no span being recorded.
+func traceStore(ctx context.Context, store Store) Store {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return store
+	}
+	return &tracedStore{ctx: ctx, store: store}
+}
+
+// span starts the span for operation op in namespace. Keys are left out,
+// since many of them are player tokens.
+func (s *tracedStore) span(op, namespace string) trace.Span {
+	_, span := tracer.Start(s.ctx, "store."+op, trace.WithAttributes(attribute.String("store.namespace", namespace)))
+	return span
+}
+
+// endStoreSpan records err, other than ErrNotFound, on span and ends it.
+func endStoreSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s *tracedStore) Get(namespace, key string) ([]byte, error) {
+	span := s.span("Get", namespace)
+	value, err := s.store.Get(namespace, key)
+	endStoreSpan(span, err)
+	return value, err
+}
+
+func (s *tracedStore) Set(namespace, key string, value []byte) error {
+	span := s.span("Set", namespace)
+	err := s.store.Set(namespace, key, value)
+	endStoreSpan(span, err)
+	return err
+}
+
+func (s *tracedStore) List(namespace string) ([]string, error) {
+	span := s.span("List", namespace)
+	keys, err := s.store.List(namespace)
+	endStoreSpan(span, err)
+	return keys, err
+}
+
+func (s *tracedStore) Delete(namespace, key string) error {
+	span := s.span("Delete", namespace)
+	err := s.store.Delete(namespace, key)
+	endStoreSpan(span, err)
+	return err
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider keeping every span in memory,
+// and the W3C propagator, until the test ends.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+	return rec
+}
+
+// spanAttrs returns the attributes of span by key.
+func spanAttrs(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestTraceRequests(t *testing.T) {
+	rec := recordSpans(t)
+	store := newMemoryStore()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/widgets/{id}", func(w http.ResponseWriter, r *http.Request) {
+		traceStore(r.Context(), store).Get("widgets", r.PathValue("id"))
+		w.WriteHeader(http.StatusTeapot)
+	})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	mux.HandleFunc("/panics", func(w http.ResponseWriter, r *http.Request) {
+		panic("no widget")
+	})
+	h := matchRoutes(traceRequests(routeMux(mux)))
+
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	r := httptest.NewRequest(http.MethodGet, "/api/widgets/42", nil)
+	r.Header.Set("traceparent", parent)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("%d spans ended, want the request's and the store's", len(spans))
+	}
+	storeSpan, server := spans[0], spans[1]
+	if server.Name() != "GET /api/widgets/{id}" || server.SpanKind() != trace.SpanKindServer {
+		t.Errorf("server span %q of kind %v", server.Name(), server.SpanKind())
+	}
+	attrs := spanAttrs(server)
+	for key, want := range map[attribute.Key]string{
+		"http.request.method": "GET",
+		"http.route":          "/api/widgets/{id}",
+		"url.path":            "/api/widgets/42",
+	} {
+		if got := attrs[key].AsString(); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if got := attrs["http.response.status_code"].AsInt64(); got != http.StatusTeapot {
+		t.Errorf("http.response.status_code = %d, want %d", got, http.StatusTeapot)
+	}
+	if got := server.Parent().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" || !server.Parent().IsRemote() {
+		t.Errorf("server span's parent is in trace %s, want the caller's", got)
+	}
+	if storeSpan.Name() != "store.Get" || storeSpan.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("store span %q is not a child of the request's", storeSpan.Name())
+	}
+	if got := spanAttrs(storeSpan)["store.namespace"].AsString(); got != "widgets" {
+		t.Errorf("store.namespace = %q, want widgets", got)
+	}
+	if storeSpan.Status().Code == codes.Error {
+		t.Error("a missing key marked the store span as failed")
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/broken", nil))
+	if failed := rec.Ended()[2]; failed.Status().Code != codes.Error {
+		t.Errorf("a 502 left the span status %v, want an error", failed.Status().Code)
+	}
+
+	recordLogs(t)
+	recoverPanics(h, "").ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/panics", nil))
+	ended := rec.Ended()
+	if len(ended) != 4 || ended[3].Status().Code != codes.Error {
+		t.Errorf("a handler that panicked left %d spans ended, want its own marked failed", len(ended)-3)
+	}
+}
+
+func TestTracingOffByDefault(t *testing.T) {
+	shutdown, err := setupTracing(context.Background(), &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(context.Background())
+	store := newMemoryStore()
+	if got := traceStore(context.Background(), store); got != Store(store) {
+		t.Errorf("store wrapped as %T without a recording span", got)
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Content file patterns checked by validateContent. The globs also pick up
+// localized variants such as questions.fr.json.
+const (
+	questionFilesPattern    = "data/questions*.json"
+	achievementFilesPattern = "data/achievements*.json"
+)
+
+// Achievement is one entry in data/achievements.json.
+type Achievement struct {
+	ID          string               `json:"id"`
+	Name        string               `json:"name"`
+	Description string               `json:"description"`
+	Icon        string               `json:"icon"`
+	Category    string               `json:"category"`
+	Condition   AchievementCondition `json:"condition"`
+	Points      int                  `json:"points"`
+	Rarity      string               `json:"rarity"`
+}
+
+// AchievementCondition describes what unlocks an achievement. Value is a
+// count, duration, flag, or room ID depending on Type.
+type AchievementCondition struct {
+	Type         string          `json:"type"`
+	Value        json.RawMessage `json:"value,omitempty"`
+	TimeLimit    int             `json:"timeLimit,omitempty"`
+	Accuracy     float64         `json:"accuracy,omitempty"`
+	MinQuestions int             `json:"minQuestions,omitempty"`
+}
+
+// contentProblem is one invariant violation found in a content file.
+type contentProblem struct {
+	File    string `json:"file"`
+	Item    string `json:"item,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p contentProblem) String() string {
+	if p.Item == "" {
+		return p.File + ": " + p.Message
+	}
+	return p.File + ": " + p.Item + ": " + p.Message
+}
+
+// contentError lists every problem found by validateContent.
+type contentError struct {
+	Problems []contentProblem
+}
+
+func (e *contentError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = "  " + p.String()
+	}
+	return fmt.Sprintf("%d content problem(s):\n%s", len(e.Problems), strings.Join(lines, "\n"))
+}
+
+// contentFiles returns the question, achievement, and map files present
+// in fsys, sorted.
+func contentFiles(fsys fs.FS) []string {
+	questions, _ := fs.Glob(fsys, questionFilesPattern)
+	achievements, _ := fs.Glob(fsys, achievementFilesPattern)
+	files := append(questions, achievements...)
+	files = append(files, mapFiles(fsys)...)
+	sort.Strings(files)
+	return files
+}
+
+// validateContent checks every question, achievement, and map file in
+// fsys, and the images the questions show, and returns a *contentError
+// describing all problems, or nil.
+func validateContent(fsys fs.FS) error {
+	var problems []contentProblem
+	questionIDs := knownQuestionIDs(fsys)
+	for _, file := range contentFiles(fsys) {
+		validate := validateAchievements
+		if ok, _ := path.Match(questionFilesPattern, file); ok {
+			validate = func(file string, data []byte) []contentProblem {
+				return append(validateQuestions(file, data), validateQuestionMedia(fsys, file, data)...)
+			}
+		} else if ok, _ := path.Match(mapFilesPattern, file); ok || file == defaultMapFile {
+			validate = func(file string, data []byte) []contentProblem {
+				return validateMap(file, data, questionIDs)
+			}
+		}
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			problems = append(problems, contentProblem{File: file, Message: err.Error()})
+			continue
+		}
+		problems = append(problems, validate(file, data)...)
+	}
+	if len(problems) > 0 {
+		return &contentError{Problems: problems}
+	}
+	return nil
+}
+
+// validateQuestions checks a question file: every question needs an ID
+// unique within the file, a prompt, at least two non-empty answers, a
+// correct index within range, a known difficulty, and a category. A hint
+// must not contain the correct answer, and an image must be named as
+// checkMediaName requires.
+func validateQuestions(file string, data []byte) []contentProblem {
+	var doc struct {
+		Questions []Question `json:"questions"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return []contentProblem{{File: file, Message: "invalid JSON: " + err.Error()}}
+	}
+	if len(doc.Questions) == 0 {
+		return []contentProblem{{File: file, Message: "no questions"}}
+	}
+	var problems []contentProblem
+	seen := make(map[string]int)
+	for i, q := range doc.Questions {
+		item := fmt.Sprintf("questions[%d]", i)
+		if q.ID != "" {
+			item += " (" + q.ID + ")"
+		}
+		add := func(format string, args ...any) {
+			problems = append(problems, contentProblem{File: file, Item: item, Message: fmt.Sprintf(format, args...)})
+		}
+		switch prev, dup := seen[q.ID]; {
+		case q.ID == "":
+			add("missing id")
+		case dup:
+			add("duplicate id, first used by questions[%d]", prev)
+		default:
+			seen[q.ID] = i
+		}
+		if strings.TrimSpace(q.Question) == "" {
+			add("empty question prompt")
+		}
+		if len(q.Answers) < 2 {
+			add("needs at least 2 answers, has %d", len(q.Answers))
+		}
+		for j, a := range q.Answers {
+			if strings.TrimSpace(a) == "" {
+				add("answers[%d] is empty", j)
+			}
+		}
+		if q.CorrectAnswer < 0 || q.CorrectAnswer >= len(q.Answers) {
+			add("correctAnswer %d is outside answers[0:%d]", q.CorrectAnswer, len(q.Answers))
+		}
+		if !difficulties[q.Difficulty] {
+			add("unknown difficulty %q", q.Difficulty)
+		}
+		if q.Category == "" {
+			add("missing category")
+		}
+		if q.Points < 0 {
+			add("points must not be negative")
+		}
+		if q.TimeLimit < 0 {
+			add("timeLimit must not be negative")
+		}
+		if q.Weight < 0 {
+			add("weight must not be negative")
+		}
+		if q.Hint != "" && q.CorrectAnswer >= 0 && q.CorrectAnswer < len(q.Answers) &&
+			strings.Contains(strings.ToLower(q.Hint), strings.ToLower(strings.TrimSpace(q.Answers[q.CorrectAnswer]))) {
+			add("hint gives away the correct answer")
+		}
+		if q.Image != "" {
+			if msg := checkMediaName(q.Image); msg != "" {
+				add("%s", msg)
+			}
+		}
+	}
+	return problems
+}
+
+// validateAchievements checks an achievement file: IDs must be present and
+// unique, each needs a name, and each condition must be of a known type
+// with the value or thresholds that type expects.
+func validateAchievements(file string, data []byte) []contentProblem {
+	var doc struct {
+		Achievements []Achievement `json:"achievements"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return []contentProblem{{File: file, Message: "invalid JSON: " + err.Error()}}
+	}
+	var problems []contentProblem
+	seen := make(map[string]int)
+	for i, a := range doc.Achievements {
+		item := fmt.Sprintf("achievements[%d]", i)
+		if a.ID != "" {
+			item += " (" + a.ID + ")"
+		}
+		add := func(format string, args ...any) {
+			problems = append(problems, contentProblem{File: file, Item: item, Message: fmt.Sprintf(format, args...)})
+		}
+		switch prev, dup := seen[a.ID]; {
+		case a.ID == "":
+			add("missing id")
+		case dup:
+			add("duplicate id, first used by achievements[%d]", prev)
+		default:
+			seen[a.ID] = i
+		}
+		if a.Name == "" {
+			add("missing name")
+		}
+		c := a.Condition
+		var v any
+		if len(c.Value) > 0 {
+			json.Unmarshal(c.Value, &v)
+		}
+		switch kind, known := conditionKinds[c.Type]; {
+		case c.Type == "":
+			add("condition has no type")
+		case !known:
+			add("unknown condition type %q", c.Type)
+		case kind == conditionCount:
+			if n, ok := v.(float64); !ok || n <= 0 || n != math.Trunc(n) {
+				add("%s needs a positive whole number value", c.Type)
+			}
+			if c.Type == "quick_answers" && c.TimeLimit <= 0 {
+				add("quick_answers needs a positive timeLimit")
+			}
+		case kind == conditionRoom:
+			if room, ok := v.(string); !ok || room == "" {
+				add("%s needs a room ID value", c.Type)
+			}
+		case kind == conditionFlag:
+			if v != true {
+				add("%s needs the value true", c.Type)
+			}
+		case kind == conditionAccuracy:
+			if c.Accuracy <= 0 || c.Accuracy > 1 {
+				add("condition accuracy must be above 0 and at most 1")
+			}
+			if c.MinQuestions < 1 {
+				add("%s needs a positive minQuestions", c.Type)
+			}
+		}
+		if c.MinQuestions < 0 || c.TimeLimit < 0 {
+			add("condition thresholds must not be negative")
+		}
+		if a.Points < 0 {
+			add("points must not be negative")
+		}
+	}
+	return problems
+}
+
+// knownQuestionIDs returns the IDs in the default question file, for
+// checking the questions pinned to map rooms. It is empty if the file is
+// unreadable, which validateQuestions reports on its own.
+func knownQuestionIDs(fsys fs.FS) map[string]bool {
+	ids := make(map[string]bool)
+	questions, _ := loadQuestions(fsys, questionsFile)
+	for _, q := range questions {
+		ids[q.ID] = true
+	}
+	return ids
+}
+
+// validateMap checks a map file: room IDs must be present and unique,
+// connections must lead to rooms of the map, exactly one room is the
+// start, at least one is final, and every room can be reached from the
+// start. Questions pinned to rooms must exist.
+func validateMap(file string, data []byte, questionIDs map[string]bool) []contentProblem {
+	var m gameMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return []contentProblem{{File: file, Message: "invalid JSON: " + err.Error()}}
+	}
+	if len(m.Rooms) == 0 {
+		return []contentProblem{{File: file, Message: "no rooms"}}
+	}
+	var problems []contentProblem
+	rooms := make(map[string]*Room, len(m.Rooms))
+	for i := range m.Rooms {
+		if id := m.Rooms[i].ID; id != "" && rooms[id] == nil {
+			rooms[id] = &m.Rooms[i]
+		}
+	}
+	var start string
+	var finals []string
+	for i, room := range m.Rooms {
+		item := fmt.Sprintf("rooms[%d]", i)
+		if room.ID != "" {
+			item += " (" + room.ID + ")"
+		}
+		add := func(format string, args ...any) {
+			problems = append(problems, contentProblem{File: file, Item: item, Message: fmt.Sprintf(format, args...)})
+		}
+		switch {
+		case room.ID == "":
+			add("missing id")
+		case rooms[room.ID] != &m.Rooms[i]:
+			add("duplicate id")
+		}
+		if room.Name == "" {
+			add("missing name")
+		}
+		for _, to := range room.Connections {
+			switch {
+			case to == room.ID:
+				add("connects to itself")
+			case rooms[to] == nil:
+				add("connects to unknown room %q", to)
+			}
+		}
+		for _, id := range room.Questions {
+			if !questionIDs[id] {
+				add("unknown question %q", id)
+			}
+		}
+		if room.RequiredScore < 0 {
+			add("requiredScore must not be negative")
+		}
+		if room.IsStartingRoom {
+			if start != "" {
+				add("second starting room, after %q", start)
+			} else {
+				start = room.ID
+			}
+		}
+		if room.IsFinalRoom {
+			finals = append(finals, room.ID)
+		}
+	}
+	if start == "" {
+		problems = append(problems, contentProblem{File: file, Message: "no starting room"})
+	}
+	if len(finals) == 0 {
+		problems = append(problems, contentProblem{File: file, Message: "no final room"})
+	}
+	if start == "" || rooms[start] == nil {
+		return problems
+	}
+
+	reached := map[string]bool{start: true}
+	queue := []string{start}
+	for len(queue) > 0 {
+		room := rooms[queue[0]]
+		queue = queue[1:]
+		for _, to := range room.Connections {
+			if rooms[to] != nil && !reached[to] {
+				reached[to] = true
+				queue = append(queue, to)
+			}
+		}
+	}
+	for i, room := range m.Rooms {
+		if room.ID != "" && !reached[room.ID] {
+			item := fmt.Sprintf("rooms[%d] (%s)", i, room.ID)
+			msg := "cannot be reached from the starting room"
+			if room.IsFinalRoom {
+				msg = "final room " + msg
+			}
+			problems = append(problems, contentProblem{File: file, Item: item, Message: msg})
+		}
+	}
+	return problems
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestEmbeddedContentIsValid(t *testing.T) {
+	if err := validateContent(staticFS); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// validQuestion is a question passing validation, as JSON, into which the
+// tests splice their faults.
+const validQuestion = `"id": "q1", "category": "history", "difficulty": "easy", "question": "When?", "answers": ["1066", "1492"], "correctAnswer": 0`
+
+// problemMessages returns the messages of problems.
+func problemMessages(problems []contentProblem) string {
+	msgs := make([]string, len(problems))
+	for i, p := range problems {
+		msgs[i] = p.String()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func TestValidateQuestions(t *testing.T) {
+	tests := []struct {
+		name, doc, want string
+	}{
+		{"valid", `{"questions": [{` + validQuestion + `}]}`, ""},
+		{"malformed", `{"questions": [`, "invalid JSON"},
+		{"empty", `{"questions": []}`, "no questions"},
+		{"missing id", `{"questions": [{` + strings.Replace(validQuestion, `"id": "q1"`, `"id": ""`, 1) + `}]}`, "missing id"},
+		{"duplicate id", `{"questions": [{` + validQuestion + `}, {` + validQuestion + `}]}`, "questions[1] (q1): duplicate id, first used by questions[0]"},
+		{"empty prompt", `{"questions": [{` + strings.Replace(validQuestion, `"When?"`, `"  "`, 1) + `}]}`, "empty question prompt"},
+		{"index past answers", `{"questions": [{` + strings.Replace(validQuestion, `"correctAnswer": 0`, `"correctAnswer": 2`, 1) + `}]}`, "correctAnswer 2 is outside answers[0:2]"},
+		{"negative index", `{"questions": [{` + strings.Replace(validQuestion, `"correctAnswer": 0`, `"correctAnswer": -1`, 1) + `}]}`, "correctAnswer -1"},
+		{"one answer", `{"questions": [{` + strings.Replace(validQuestion, `["1066", "1492"]`, `["1066"]`, 1) + `}]}`, "needs at least 2 answers"},
+		{"unknown difficulty", `{"questions": [{` + strings.Replace(validQuestion, `"easy"`, `"brutal"`, 1) + `}]}`, `unknown difficulty "brutal"`},
+	}
+	for _, tt := range tests {
+		got := problemMessages(validateQuestions("data/questions.json", []byte(tt.doc)))
+		switch {
+		case tt.want == "" && got != "":
+			t.Errorf("%s: unexpected problems: %s", tt.name, got)
+		case !strings.Contains(got, tt.want):
+			t.Errorf("%s: problems %q, want one containing %q", tt.name, got, tt.want)
+		case tt.want != "" && !strings.HasPrefix(got, "data/questions.json: "):
+			t.Errorf("%s: problem %q does not name its file", tt.name, got)
+		}
+	}
+}
+
+func TestValidateAchievements(t *testing.T) {
+	const valid = `"id": "a1", "name": "First Steps", "condition": {"type": "correct_answers", "value": 1}`
+	tests := []struct {
+		name, doc, want string
+	}{
+		{"valid", `{"achievements": [{` + valid + `}]}`, ""},
+		{"malformed", `{"achievements": {`, "invalid JSON"},
+		{"duplicate id", `{"achievements": [{` + valid + `}, {` + valid + `}]}`, "duplicate id"},
+		{"missing name", `{"achievements": [{` + strings.Replace(valid, `"First Steps"`, `""`, 1) + `}]}`, "missing name"},
+		{"zero threshold", `{"achievements": [{` + strings.Replace(valid, `"value": 1`, `"value": 0`, 1) + `}]}`, "positive whole number"},
+		{"negative threshold", `{"achievements": [{` + strings.Replace(valid, `"value": 1`, `"value": -3`, 1) + `}]}`, "positive whole number"},
+		{"no condition type", `{"achievements": [{` + strings.Replace(valid, `"type": "correct_answers", `, ``, 1) + `}]}`, "condition has no type"},
+		{"unknown condition", `{"achievements": [{` + strings.Replace(valid, `"correct_answers"`, `"luck"`, 1) + `}]}`, `unknown condition type "luck"`},
+	}
+	for _, tt := range tests {
+		got := problemMessages(validateAchievements("data/achievements.json", []byte(tt.doc)))
+		switch {
+		case tt.want == "" && got != "":
+			t.Errorf("%s: unexpected problems: %s", tt.name, got)
+		case !strings.Contains(got, tt.want):
+			t.Errorf("%s: problems %q, want one containing %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateContentListsEveryProblem(t *testing.T) {
+	fsys := fstest.MapFS{
+		"data/questions.json":    {Data: []byte(`{"questions": [{` + strings.Replace(validQuestion, `"correctAnswer": 0`, `"correctAnswer": 5`, 1) + `}]}`)},
+		"data/achievements.json": {Data: []byte(`{"achievements": [{"id": "a1", "condition": {"type": "correct_answers", "value": 1}}]}`)},
+	}
+	err := validateContent(fsys)
+	var cerr *contentError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("validateContent = %v, want a *contentError", err)
+	}
+	files := map[string]bool{}
+	for _, p := range cerr.Problems {
+		files[p.File] = true
+	}
+	if !files["data/questions.json"] || !files["data/achievements.json"] {
+		t.Errorf("problems %v do not cover both faulty files", cerr.Problems)
+	}
+	if !strings.Contains(err.Error(), "2 content problem(s)") {
+		t.Errorf("error %q does not count the problems", err)
+	}
+}
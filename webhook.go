@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	// webhookQueueSize is how many events may wait for delivery before
+	// new ones are dropped.
+	webhookQueueSize = 64
+	// webhookWorkers is how many deliveries run at once.
+	webhookWorkers = 2
+	// webhookAttempts is how many times delivery to one URL is tried.
+	webhookAttempts = 4
+	// webhookBackoff is the wait before the first retry; it doubles after
+	// each failure.
+	webhookBackoff = time.Second
+	// webhookTopRank is the worst leaderboard rank that is announced.
+	webhookTopRank = 3
+)
+
+// Webhook event types.
+const (
+	eventAchievementUnlocked = "achievement.unlocked"
+	eventLeaderboardTop      = "leaderboard.top"
+)
+
+// webhookEvent is the JSON body POSTed to each webhook URL.
+type webhookEvent struct {
+	Type string    `json:"type"`
+	At   time.Time `json:"at"`
+	Data any       `json:"data"`
+}
+
+// webhooks delivers events to the configured URLs from a bounded pool of
+// workers, so that a slow or failing receiver never holds up a request.
+// A nil *webhooks discards every event.
+type webhooks struct {
+	urls   []string
+	secret []byte
+	client *http.Client
+	queue  chan webhookEvent
+}
+
+// newWebhooks returns a notifier for urls that delivers with client,
+// signing each body with secret if it is set, or nil if there are no URLs.
+func newWebhooks(urls []string, secret string, client *http.Client) *webhooks {
+	if len(urls) == 0 {
+		return nil
+	}
+	return &webhooks{
+		urls:   urls,
+		secret: []byte(secret),
+		client: client,
+		queue:  make(chan webhookEvent, webhookQueueSize),
+	}
+}
+
+// start runs the delivery workers until ctx is cancelled.
+func (h *webhooks) start(ctx context.Context) {
+	if h == nil {
+		return
+	}
+	for range webhookWorkers {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case ev := <-h.queue:
+					h.deliver(ctx, ev)
+				}
+			}
+		}()
+	}
+}
+
+// Notify queues an event of type typ, dropping it if the queue is full.
+func (h *webhooks) Notify(typ string, data any) {
+	if h == nil {
+		return
+	}
+	select {
+	case h.queue <- webhookEvent{Type: typ, At: time.Now().UTC(), Data: data}:
+	default:
+		slog.Warn("webhook queue full; dropping event", "type", typ)
+	}
+}
+
+// deliver sends ev to every URL, retrying each with exponential backoff.
+func (h *webhooks) deliver(ctx context.Context, ev webhookEvent) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		slog.Error("could not encode webhook event", "type", ev.Type, "err", err)
+		return
+	}
+	for _, url := range h.urls {
+		backoff := webhookBackoff
+		for attempt := 1; ; attempt++ {
+			err := h.post(ctx, url, ev.Type, body)
+			if err == nil {
+				break
+			}
+			if attempt == webhookAttempts || ctx.Err() != nil {
+				slog.Warn("webhook delivery failed", "url", url, "type", ev.Type, "attempts", attempt, "err", err)
+				break
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+	}
+}
+
+// post makes one delivery attempt. Any 2xx response counts as delivered.
+func (h *webhooks) post(ctx context.Context, url, typ string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-LobeLabyrinth-Event", typ)
+	if len(h.secret) > 0 {
+		req.Header.Set("X-LobeLabyrinth-Signature", "sha256="+webhookSignature(h.secret, body))
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookSignature is the hex HMAC-SHA256 of body under secret, which
+// receivers recompute to check that an event came from this server.
+func webhookSignature(secret, body []byte) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write(body)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// playerRef identifies a player in webhook events without revealing
+// their token, which would let receivers act as them.
+func playerRef(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// delivery is one request a test webhook receiver got.
+type delivery struct {
+	event     string
+	signature string
+	body      []byte
+}
+
+// webhookReceiver starts a receiver that answers each delivery with the
+// next of statuses, then 204, and passes on what it got.
+func webhookReceiver(t *testing.T, statuses ...int) (*httptest.Server, <-chan delivery) {
+	t.Helper()
+	got := make(chan delivery, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- delivery{event: r.Header.Get("X-LobeLabyrinth-Event"), signature: r.Header.Get("X-LobeLabyrinth-Signature"), body: body}
+		status := http.StatusNoContent
+		if len(statuses) > 0 {
+			status, statuses = statuses[0], statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, got
+}
+
+// nextDelivery waits for the receiver's next delivery.
+func nextDelivery(t *testing.T, got <-chan delivery) delivery {
+	t.Helper()
+	select {
+	case d := <-got:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook delivery")
+		return delivery{}
+	}
+}
+
+func TestWebhookOnAchievementUnlock(t *testing.T) {
+	const secret = "webhook signing key"
+	receiver, got := webhookReceiver(t)
+	s := startServer(t, "-webhook-urls", receiver.URL, "-webhook-secret", secret)
+	questions, err := loadQuestions(staticFS, questionsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := questions[0]
+	if code := postJSON(t, s.url("/api/answer?token="+testSaveToken), map[string]any{"questionID": q.ID, "choiceIndex": q.CorrectAnswer}, nil); code != http.StatusOK {
+		t.Fatalf("answer: status %d", code)
+	}
+
+	d := nextDelivery(t, got)
+	if d.event != eventAchievementUnlocked {
+		t.Fatalf("event %q, want %q", d.event, eventAchievementUnlocked)
+	}
+	want := "sha256=" + webhookSignature([]byte(secret), d.body)
+	if !hmac.Equal([]byte(d.signature), []byte(want)) {
+		t.Errorf("signature %q, want %q", d.signature, want)
+	}
+	var ev struct {
+		Type string    `json:"type"`
+		At   time.Time `json:"at"`
+		Data struct {
+			Player      string      `json:"player"`
+			Achievement Achievement `json:"achievement"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(d.body, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != eventAchievementUnlocked || ev.At.IsZero() || ev.Data.Achievement.ID != "first_steps" {
+		t.Errorf("payload %s", d.body)
+	}
+	if ev.Data.Player != playerRef(testSaveToken) || ev.Data.Player == testSaveToken {
+		t.Errorf("payload names the player %q, want the reference %q", ev.Data.Player, playerRef(testSaveToken))
+	}
+}
+
+func TestWebhookRetriesFailedDelivery(t *testing.T) {
+	receiver, got := webhookReceiver(t, http.StatusServiceUnavailable)
+	hooks := newWebhooks([]string{receiver.URL}, "", receiver.Client())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hooks.start(ctx)
+	hooks.Notify(eventLeaderboardTop, map[string]any{"rank": 1})
+
+	first, second := nextDelivery(t, got), nextDelivery(t, got)
+	if first.event != eventLeaderboardTop || string(first.body) != string(second.body) {
+		t.Errorf("retry sent %s after %s", second.body, first.body)
+	}
+	if first.signature != "" {
+		t.Errorf("unsigned webhooks sent the signature %q", first.signature)
+	}
+	select {
+	case d := <-got:
+		t.Errorf("a third delivery after a success: %s", d.body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWebhooksDisabledWithoutURLs(t *testing.T) {
+	hooks := newWebhooks(nil, "secret", http.DefaultClient)
+	if hooks != nil {
+		t.Fatal("webhooks configured without URLs")
+	}
+	hooks.start(context.Background())
+	hooks.Notify(eventAchievementUnlocked, nil) // must not block or panic
+}